@@ -6,15 +6,20 @@ package core
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/nerve/agent/pkg/log"
 	"github.com/nerve/agent/pkg/sysinfo"
+	"google.golang.org/grpc"
 )
 
 // Agent represents the nerve agent
@@ -29,6 +34,95 @@ type Agent struct {
 	wg          sync.WaitGroup
 	registered  bool
 	mu          sync.RWMutex
+
+	// Graceful drain of in-flight tasks on shutdown
+	tasksWg      sync.WaitGroup
+	running      map[string]Task
+	draining     bool
+	drainTimeout time.Duration
+	stopOnce     sync.Once
+	benchmarking bool
+
+	// Root context of requests to the server, cancelled by Stop so they
+	// don't hold up shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Installed binary location after a self-update, and how the stopped
+	// agent restarts into it, see restartSelf
+	binaryPath string
+	restart    func()
+
+	// Optional gRPC transport, see EnableGRPC
+	grpcConn *grpc.ClientConn
+
+	// Configuration pushed by the server, see applyConfig
+	configVersion   int64
+	enabledPlugins  []string
+	intervalChanged chan time.Duration
+
+	// Requests for an immediate heartbeat, see handleRefresh
+	heartbeatNow chan struct{}
+
+	// Delta heartbeats and the inventory hash the server holds, see SetHeartbeatDelta
+	heartbeatDelta bool
+	inventoryHash  string
+
+	// Sections heartbeats carry, nil for all, see SetHeartbeatSections
+	heartbeatSections heartbeatSections
+
+	// Plugins contributing custom heartbeat metrics, see SetPluginManager
+	plugins *PluginManager
+
+	// Constraints on command and script tasks, see SetCommandPolicy
+	commandPolicy *CommandPolicy
+
+	// Server public key tasks must be signed with, see SetTaskVerifier
+	verifier *TaskVerifier
+
+	// Task results awaiting delivery, see SetResultQueue
+	results *resultQueue
+
+	// File keeping rotated tokens and the --token they stem from, see SetTokenFile
+	tokenFile   string
+	tokenOrigin string
+
+	// TLS settings shared by all connections to the server, see SetTLS
+	tlsConfig *tls.Config
+
+	// Files the server may tail and the running tails' stop channels, see SetLogTail
+	logTail *LogTailOptions
+	tails   map[string]chan struct{}
+
+	// Shells operators may start and those running, see SetExec
+	exec   *ExecOptions
+	shells map[string]*shellSession
+
+	// Recent log lines the server may fetch, see SetLogBuffer
+	logBuffer *log.RingBuffer
+
+	// Control channel redial cap and state, see StartControlChannel
+	controlMaxBackoff time.Duration
+	control           *ControlState
+
+	// Glob patterns of the packages reported at registration, see SetPackageInventory
+	packagePatterns []string
+
+	// Alert rules pushed by the server and their evaluation, see handleRulesUpdate
+	alerts *alertEvaluator
+
+	// Metrics sampled between heartbeats, see SetMetricSampling
+	sampler        *sysinfo.Sampler
+	sampleInterval time.Duration
+
+	// Identity sources tried at registration, see SetIdentity
+	identitySources []string
+	injectedID      string
+
+	// Local health endpoint and what it reports, see StartHealthServer
+	healthServer  *http.Server
+	lastHeartbeat time.Time
+	errorBuffer   *log.RingBuffer
 }
 
 // SystemInfo represents collected system information
@@ -59,8 +153,21 @@ type SystemInfo struct {
 	CPUInfo        map[string]interface{} `json:"cpu_info"`
 	GPUInfo        []map[string]interface{} `json:"gpu_info"`
 	NetworkInfo    []map[string]interface{} `json:"network_info"`
+	RaidInfo       []sysinfo.RaidController `json:"raid_info,omitempty"`
 	UpdateTime     string                 `json:"update_time"`
 	AgentVersion   string                 `json:"agent_version"`
+
+	// Capabilities are advertised at registration only, see capabilities
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// AgentID is the identity asked for at registration, see identity
+	AgentID        string `json:"agent_id,omitempty"`
+	IdentitySource string `json:"identity_source,omitempty"`
+
+	// Kernel and packages are reported at registration only, see addKernelInventory
+	Kernel         *sysinfo.Kernel   `json:"kernel,omitempty"`
+	PackageManager string            `json:"package_manager,omitempty"`
+	Packages       []sysinfo.Package `json:"packages,omitempty"`
 }
 
 // Task represents a task from the server
@@ -72,6 +179,10 @@ type Task struct {
 	Plugin      string                 `json:"plugin,omitempty"`
 	Params      map[string]interface{} `json:"params,omitempty"`
 	Timeout     int                    `json:"timeout,omitempty"`
+	RequestID   string                 `json:"request_id,omitempty"`
+	AgentID     string                 `json:"agent_id,omitempty"`
+	ExpiresAt   time.Time              `json:"expires_at,omitempty"`
+	Signature   string                 `json:"signature,omitempty"`
 }
 
 // TaskResult represents the result of task execution
@@ -80,11 +191,18 @@ type TaskResult struct {
 	Success bool   `json:"success"`
 	Output  string `json:"output,omitempty"`
 	Error   string `json:"error,omitempty"`
+
+	// Data holds structured results, e.g. of benchmark tasks
+	Data map[string]interface{} `json:"data,omitempty"`
 }
 
+// AgentVersion is the running agent version, set by main at startup
+var AgentVersion = "1.0.0"
+
 const (
-	DefaultTimeout = 30 * time.Second
-	UserAgent      = "Nerve-Agent/1.0"
+	DefaultTimeout      = 30 * time.Second
+	DefaultDrainTimeout = 60 * time.Second
+	UserAgent           = "Nerve-Agent/1.0"
 )
 
 // NewAgent creates a new agent instance (deprecated, use NewAgentWithLogger)
@@ -94,28 +212,65 @@ func NewAgent(serverURL, token string, interval time.Duration, logger log.Logger
 
 // NewAgentWithLogger creates a new agent instance with a logger
 func NewAgentWithLogger(serverURL, token string, interval time.Duration, logger log.Logger) *Agent {
-	return &Agent{
+	return NewAgentWithTransport(serverURL, token, interval, logger, nil)
+}
+
+// NewAgentWithTransport creates a new agent instance whose HTTP requests to
+// the server go through transport, e.g. an in-memory fake server in tests;
+// nil uses the default transport. SetTransport and SetTLS only tune an
+// *http.Transport, and leave other transports as they are.
+func NewAgentWithTransport(serverURL, token string, interval time.Duration, logger log.Logger, transport http.RoundTripper) *Agent {
+	// An in-memory queue can't fail to load
+	results, _ := newResultQueue("", DefaultResultQueueSize)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a := &Agent{
+		ctx:       ctx,
+		cancel:    cancel,
 		serverURL: serverURL,
 		token:     token,
 		interval:  interval,
 		client: &http.Client{
-			Timeout: DefaultTimeout,
+			Transport: transport,
+			Timeout:   DefaultTimeout,
 		},
-		logger:   logger,
-		stopChan: make(chan struct{}),
+		logger:       logger,
+		stopChan:     make(chan struct{}),
+		running:      make(map[string]Task),
+		drainTimeout: DefaultDrainTimeout,
+
+		intervalChanged: make(chan time.Duration, 1),
+		heartbeatNow:    make(chan struct{}, 1),
+		heartbeatDelta:  true,
+		results:         results,
+		alerts:          newAlertEvaluator(),
+		identitySources: DefaultIdentitySources,
 	}
+	a.restart = a.reexec
+	return a
+}
+
+// SetDrainTimeout sets how long Stop waits for in-flight tasks to finish
+func (a *Agent) SetDrainTimeout(timeout time.Duration) {
+	a.drainTimeout = timeout
 }
 
 // Register registers the agent with the server
 func (a *Agent) Register() error {
-	info := a.collectSystemInfo()
+	info := a.collectSystemInfo(nil)
+	info.Capabilities = a.capabilities()
+	info.AgentID, info.IdentitySource = a.identity()
+	a.addKernelInventory(&info)
+	if a.grpcConn != nil {
+		return a.registerGRPC(info)
+	}
 	
 	data, err := json.Marshal(info)
 	if err != nil {
 		return fmt.Errorf("marshal system info: %w", err)
 	}
 
-	req, err := http.NewRequest("POST", a.serverURL+"/api/agents/register", bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(a.ctx, "POST", a.serverURL+"/api/agents/register", bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("create request: %w", err)
 	}
@@ -127,7 +282,7 @@ func (a *Agent) Register() error {
 	if err != nil {
 		return fmt.Errorf("send request: %w", err)
 	}
-	defer resp.Body.Close()
+	defer closeBody(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
@@ -156,8 +311,9 @@ func (a *Agent) Register() error {
 	return nil
 }
 
-// Collect system information
-func (a *Agent) collectSystemInfo() SystemInfo {
+// Collect system information, with the detailed inventory of the given
+// sections only (nil for all)
+func (a *Agent) collectSystemInfo(sections heartbeatSections) SystemInfo {
 	// Collect real system information
 	hostname := sysinfo.Hostname()
 	cpuType, cpuLogic := sysinfo.GetCPUData()
@@ -187,7 +343,7 @@ func (a *Agent) collectSystemInfo() SystemInfo {
 		gpuVendors = vendors
 	}
 	
-	return SystemInfo{
+	info := SystemInfo{
 		Hostname:     hostname,
 		CPUType:      cpuType,
 		CPULogic:     cpuLogic,
@@ -209,21 +365,39 @@ func (a *Agent) collectSystemInfo() SystemInfo {
 		GPUNum:       gpuNum,
 		GPUType:      gpuType,
 		GPUVendors:   gpuVendors,
-		DiskInfo:     sysinfo.GetDiskInfo(),
-		MemoryInfo:   sysinfo.GetMemoryInfo(),
-		CPUInfo:      sysinfo.GetCPUInfo(),
-		GPUInfo:      sysinfo.GetGPUInfos(),
-		NetworkInfo:  sysinfo.GetNetworkInfo(),
 		UpdateTime:   time.Now().Format("2006-01-02 15:04:05"),
-		AgentVersion: "1.0.0",
+		AgentVersion: AgentVersion,
+	}
+	if sections.includes(SectionDisk) {
+		info.DiskInfo = sysinfo.GetDiskInfo()
+		info.RaidInfo = sysinfo.RaidControllers()
+	}
+	if sections.includes(SectionMemory) {
+		info.MemoryInfo = sysinfo.GetMemoryInfo()
 	}
+	if sections.includes(SectionCPU) {
+		info.CPUInfo = sysinfo.GetCPUInfo()
+	}
+	if sections.includes(SectionGPU) {
+		info.GPUInfo = sysinfo.GetGPUInfos()
+	}
+	if sections.includes(SectionNetwork) {
+		info.NetworkInfo = sysinfo.GetNetworkInfo()
+	}
+	return info
 }
 
 // StartHeartbeat starts the heartbeat goroutine
 func (a *Agent) StartHeartbeat() {
+	a.startSampling()
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
+		if a.grpcConn != nil {
+			a.runHeartbeatStream()
+			return
+		}
+
 		ticker := time.NewTicker(a.interval)
 		defer ticker.Stop()
 
@@ -231,8 +405,15 @@ func (a *Agent) StartHeartbeat() {
 			select {
 			case <-a.stopChan:
 				return
+			case interval := <-a.intervalChanged:
+				ticker.Reset(interval)
+			case <-a.heartbeatNow:
+				if err := a.heartbeat(); err != nil && a.ctx.Err() == nil {
+					a.logger.Errorf("Refresh heartbeat failed: %v", err)
+				}
 			case <-ticker.C:
-				if err := a.heartbeat(); err != nil {
+				// A heartbeat cut short by Stop isn't a failure
+				if err := a.heartbeat(); err != nil && a.ctx.Err() == nil {
 					a.logger.Errorf("Heartbeat failed: %v", err)
 				}
 			}
@@ -240,7 +421,9 @@ func (a *Agent) StartHeartbeat() {
 	}()
 }
 
-// heartbeat sends heartbeat to server
+// heartbeat sends heartbeat to server. A server that doesn't hold the
+// inventory a delta heartbeat refers to asks for a resync, and the full
+// inventory is sent right away.
 func (a *Agent) heartbeat() error {
 	a.mu.RLock()
 	registered := a.registered
@@ -250,18 +433,34 @@ func (a *Agent) heartbeat() error {
 		return nil
 	}
 
-	info := a.collectSystemInfo()
-	
-	// Format heartbeat data according to backend expectations
-	heartbeatData := map[string]interface{}{
-		"status":     "online",
-		"system_info": info,
-	}
-	
-	data, err := json.Marshal(heartbeatData)
+	payload, hash, full := a.nextHeartbeatPayload()
+	resync, err := a.sendHeartbeat(payload)
 	if err != nil {
 		return err
 	}
+	if full {
+		a.setInventoryHash(hash)
+		return nil
+	}
+	if resync {
+		a.logger.Infof("Server requested full inventory, resending")
+		a.setInventoryHash("")
+		payload, hash, _ = a.nextHeartbeatPayload()
+		if _, err := a.sendHeartbeat(payload); err != nil {
+			return err
+		}
+		a.setInventoryHash(hash)
+	}
+	return nil
+}
+
+// sendHeartbeat posts a heartbeat and reports whether the server asked for
+// the full inventory
+func (a *Agent) sendHeartbeat(payload map[string]interface{}) (bool, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return false, err
+	}
 
 	// Use agent ID if available, otherwise try without ID (backend may support token-based heartbeat)
 	a.mu.RLock()
@@ -273,9 +472,9 @@ func (a *Agent) heartbeat() error {
 		heartbeatURL = a.serverURL + "/api/agents/" + agentID + "/heartbeat"
 	}
 	
-	req, err := http.NewRequest("POST", heartbeatURL, bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(a.ctx, "POST", heartbeatURL, bytes.NewReader(data))
 	if err != nil {
-		return err
+		return false, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
@@ -283,23 +482,43 @@ func (a *Agent) heartbeat() error {
 	
 	resp, err := a.client.Do(req)
 	if err != nil {
-		return err
+		return false, err
 	}
-	defer resp.Body.Close()
+	defer closeBody(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("heartbeat returned %d", resp.StatusCode)
+		return false, fmt.Errorf("heartbeat returned %d", resp.StatusCode)
 	}
 
+	var heartbeatResp struct {
+		Resync       bool   `json:"resync"`
+		RulesVersion string `json:"rules_version"`
+	}
+	json.NewDecoder(resp.Body).Decode(&heartbeatResp)
+
+	if candidates, ok := payload["alert_candidates"].([]AlertCandidate); ok {
+		a.alerts.delivered(len(candidates))
+	}
+	if a.alerts.checkVersion(heartbeatResp.RulesVersion) {
+		a.logger.Infof("Alert rules are stale, server has version %s; sending full metrics until they are pushed", heartbeatResp.RulesVersion)
+	}
+
+	a.recordHeartbeat()
 	a.logger.Debugf("Heartbeat sent successfully")
-	return nil
+	return heartbeatResp.Resync, nil
 }
 
 // StartTaskListener starts listening for tasks from server
 func (a *Agent) StartTaskListener() {
+	a.startResultDelivery()
+
 	a.wg.Add(1)
 	go func() {
 		defer a.wg.Done()
+		if a.grpcConn != nil {
+			a.runTaskStream()
+			return
+		}
 		
 		ticker := time.NewTicker(10 * time.Second)
 		defer ticker.Stop()
@@ -311,7 +530,7 @@ func (a *Agent) StartTaskListener() {
 			case <-ticker.C:
 				tasks := a.fetchTasks()
 				for _, task := range tasks {
-					go a.executeTask(task)
+					a.startTask(task)
 				}
 			}
 		}
@@ -320,7 +539,15 @@ func (a *Agent) StartTaskListener() {
 
 // fetchTasks fetches pending tasks from server
 func (a *Agent) fetchTasks() []Task {
-	req, err := http.NewRequest("GET", a.serverURL+"/api/tasks", nil)
+	a.mu.RLock()
+	agentID := a.agentID
+	a.mu.RUnlock()
+
+	if agentID == "" {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(a.ctx, "GET", a.serverURL+"/api/agents/"+url.PathEscape(agentID)+"/tasks", nil)
 	if err != nil {
 		a.logger.Errorf("Create request: %v", err)
 		return nil
@@ -330,10 +557,12 @@ func (a *Agent) fetchTasks() []Task {
 	
 	resp, err := a.client.Do(req)
 	if err != nil {
-		a.logger.Errorf("Fetch tasks: %v", err)
+		if a.ctx.Err() == nil {
+			a.logger.Errorf("Fetch tasks: %v", err)
+		}
 		return nil
 	}
-	defer resp.Body.Close()
+	defer closeBody(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		return nil
@@ -352,15 +581,74 @@ func (a *Agent) fetchTasks() []Task {
 	return response.Tasks
 }
 
+// startTask runs a task in the background unless the agent is draining
+func (a *Agent) startTask(task Task) {
+	a.mu.Lock()
+	if a.draining {
+		a.mu.Unlock()
+		a.logger.Infof("Draining, not starting task: %s", task.ID)
+		return
+	}
+	a.running[task.ID] = task
+	a.tasksWg.Add(1)
+	a.mu.Unlock()
+
+	go func() {
+		defer func() {
+			a.mu.Lock()
+			delete(a.running, task.ID)
+			a.mu.Unlock()
+			a.tasksWg.Done()
+		}()
+		a.executeTask(task)
+	}()
+}
+
+// taskIDs returns the tasks the agent runs or has yet to deliver results
+// for, which heartbeats report so the server can reconcile its task states
+func (a *Agent) taskIDs() []string {
+	a.mu.RLock()
+	listed := make(map[string]bool, len(a.running))
+	ids := make([]string, 0, len(a.running))
+	for id := range a.running {
+		listed[id] = true
+		ids = append(ids, id)
+	}
+	queue := a.results
+	a.mu.RUnlock()
+
+	// A finished task is queued for delivery before it leaves a.running
+	for _, id := range queue.taskIDs() {
+		if !listed[id] {
+			listed[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
 // executeTask executes a task and reports results
 func (a *Agent) executeTask(task Task) {
-	a.logger.Infof("Executing task: %s (type=%s)", task.ID, task.Type)
+	a.logger.Infof("Executing task: %s (type=%s, request=%s)", task.ID, task.Type, task.RequestID)
 
 	var result TaskResult
 	result.TaskID = task.ID
 	result.Success = false
 
-	// Execute based on task type
+	// Execute based on task type, unless the task isn't properly signed or
+	// the command policy refuses it
+	err := a.verifyTask(task)
+	if err == nil {
+		err = a.checkCommandPolicy(task)
+	}
+	if err != nil {
+		a.logger.Errorf("Task %s rejected: %v", task.ID, err)
+		result.Error = err.Error()
+		a.reportTaskResult(result, task.RequestID)
+		return
+	}
+
 	switch task.Type {
 	case "command":
 		result = a.executeCommand(task)
@@ -368,12 +656,23 @@ func (a *Agent) executeTask(task Task) {
 		result = a.executeScript(task)
 	case "hook":
 		result = a.executeHook(task)
+	case "update":
+		result = a.executeUpdate(task)
+	case "benchmark":
+		result = a.executeBenchmark(task)
 	default:
 		result.Error = fmt.Sprintf("unknown task type: %s", task.Type)
 	}
 
 	// Report result back to server
-	a.reportTaskResult(result)
+	a.reportTaskResult(result, task.RequestID)
+
+	// A successful update only takes effect after a restart. The agent first
+	// stops like on a signal, and Stop waits for this task, so the restart
+	// runs on its own.
+	if task.Type == "update" && result.Success {
+		go a.restartSelf()
+	}
 }
 
 // executeCommand executes a shell command
@@ -398,6 +697,14 @@ func (a *Agent) executeScript(task Task) TaskResult {
 
 // executeHook executes a hook plugin
 func (a *Agent) executeHook(task Task) TaskResult {
+	if !a.pluginEnabled(task.Plugin) {
+		return TaskResult{
+			TaskID:  task.ID,
+			Success: false,
+			Error:   fmt.Sprintf("plugin %s is not enabled on this agent", task.Plugin),
+		}
+	}
+
 	// TODO: Implement hook execution
 	return TaskResult{
 		TaskID:  task.ID,
@@ -406,46 +713,106 @@ func (a *Agent) executeHook(task Task) TaskResult {
 	}
 }
 
-// reportTaskResult reports task execution result to server
-func (a *Agent) reportTaskResult(result TaskResult) {
+// deliverResult sends a task result to the server. A result the server
+// refuses for good is returned as a rejectedError.
+func (a *Agent) deliverResult(ctx context.Context, result TaskResult, requestID string) error {
+	if a.grpcConn != nil {
+		return a.reportTaskResultGRPC(ctx, result, requestID)
+	}
+
 	data, err := json.Marshal(result)
 	if err != nil {
-		a.logger.Errorf("Marshal result: %v", err)
-		return
+		return &rejectedError{fmt.Errorf("marshal result: %v", err)}
 	}
 
-	req, err := http.NewRequest("POST", a.serverURL+"/api/tasks/"+result.TaskID+"/result", bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", a.serverURL+"/api/tasks/"+result.TaskID+"/result", bytes.NewReader(data))
 	if err != nil {
-		a.logger.Errorf("Create request: %v", err)
-		return
+		return fmt.Errorf("create request: %v", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	a.setAuthHeaders(req)
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
 	
 	resp, err := a.client.Do(req)
 	if err != nil {
-		a.logger.Errorf("Report result: %v", err)
-		return
+		return err
 	}
-	defer resp.Body.Close()
+	defer closeBody(resp.Body)
 
-	if resp.StatusCode == http.StatusOK {
+	switch {
+	case resp.StatusCode == http.StatusOK:
 		a.logger.Infof("Task result reported: %s", result.TaskID)
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		body, _ := io.ReadAll(resp.Body)
+		return &rejectedError{fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))}
+	default:
+		return fmt.Errorf("server returned %d", resp.StatusCode)
 	}
 }
 
 // setAuthHeaders sets authentication headers
 func (a *Agent) setAuthHeaders(req *http.Request) {
-	req.Header.Set("Authorization", "Bearer "+a.token)
+	req.Header.Set("Authorization", "Bearer "+a.authToken())
 	req.Header.Set("User-Agent", UserAgent)
 	req.Header.Set("Content-Type", "application/json")
 }
 
-// Stop stops the agent
+// Stop stops the agent. New tasks are no longer fetched or started, and
+// in-flight tasks get up to the drain timeout to finish and report results.
+// Later calls, e.g. on a signal during a decommission, do nothing.
 func (a *Agent) Stop() {
+	a.stopOnce.Do(a.stop)
+}
+
+// stop drains and stops the agent once, see Stop
+func (a *Agent) stop() {
+	a.mu.Lock()
+	a.draining = true
+	inflight := len(a.running)
+	a.mu.Unlock()
+
 	close(a.stopChan)
+	a.cancel()
 	a.wg.Wait()
+
+	if inflight > 0 {
+		a.logger.Infof("Draining %d in-flight task(s), timeout %v", inflight, a.drainTimeout)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		a.tasksWg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		a.logger.Info("All in-flight tasks finished")
+	case <-time.After(a.drainTimeout):
+		a.mu.RLock()
+		for id := range a.running {
+			a.logger.Errorf("Task still running after drain timeout: %s", id)
+		}
+		a.mu.RUnlock()
+	}
+
+	// Results still undelivered are kept in the result queue for the next
+	// run. Requests of the stopped agent are cancelled, so these get their own
+	// context, bounded by the request timeout.
+	if !a.flushResults(context.Background()) {
+		a.logger.Errorf("Stopping with %d undelivered task result(s)", a.results.len())
+	}
+
+	if a.grpcConn != nil {
+		a.grpcConn.Close()
+	}
+
+	a.stopHealthServer()
 	a.logger.Info("Agent stopped")
 }
 
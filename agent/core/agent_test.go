@@ -0,0 +1,446 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nerve/agent/pkg/log"
+)
+
+// fakeServer is an http.RoundTripper answering the agent's requests in
+// memory by path, recording them
+type fakeServer struct {
+	mu        sync.Mutex
+	responses map[string]fakeResponse
+	requests  []fakeRequest
+}
+
+type fakeResponse struct {
+	status int
+	body   string
+}
+
+type fakeRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   []byte
+}
+
+func newFakeServer() *fakeServer {
+	return &fakeServer{responses: make(map[string]fakeResponse)}
+}
+
+// respond sets the response to requests for path; other paths get 404
+func (f *fakeServer) respond(path string, status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.responses[path] = fakeResponse{status: status, body: body}
+}
+
+func (f *fakeServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		body, _ = io.ReadAll(req.Body)
+		req.Body.Close()
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests = append(f.requests, fakeRequest{method: req.Method, path: req.URL.Path, header: req.Header.Clone(), body: body})
+	response, ok := f.responses[req.URL.Path]
+	if !ok {
+		response = fakeResponse{status: http.StatusNotFound, body: `{"error":"not found"}`}
+	}
+	return &http.Response{
+		StatusCode: response.status,
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       io.NopCloser(bytes.NewReader([]byte(response.body))),
+		Request:    req,
+	}, nil
+}
+
+// received returns the requests made so far
+func (f *fakeServer) received() []fakeRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]fakeRequest(nil), f.requests...)
+}
+
+func newTestAgent(server *fakeServer) *Agent {
+	return NewAgentWithTransport("http://nerve.test", "test-token", time.Minute, log.NewWithWriter(false, io.Discard), server)
+}
+
+func TestRegisterCapturesID(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		wantID string
+	}{
+		{"server assigns an ID", `{"id":"node-1-3fa2","status":"registered"}`, "node-1-3fa2"},
+		{"server sends no ID", `{"status":"registered"}`, ""},
+		{"server sends no body", ``, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer()
+			server.respond("/api/agents/register", http.StatusOK, tt.body)
+			agent := newTestAgent(server)
+
+			if err := agent.Register(); err != nil {
+				t.Fatalf("Register: %v", err)
+			}
+			if agent.agentID != tt.wantID || !agent.registered {
+				t.Errorf("agentID = %q, registered = %v, want %q and registered", agent.agentID, agent.registered, tt.wantID)
+			}
+
+			requests := server.received()
+			if len(requests) != 1 {
+				t.Fatalf("made %d requests, want 1", len(requests))
+			}
+			request := requests[0]
+			if request.method != http.MethodPost {
+				t.Errorf("method = %s, want POST", request.method)
+			}
+			if got := request.header.Get("Authorization"); got != "Bearer test-token" {
+				t.Errorf("Authorization = %q, want the agent's token", got)
+			}
+			var info SystemInfo
+			if err := json.Unmarshal(request.body, &info); err != nil || info.Hostname == "" {
+				t.Errorf("body is not the system info (%v): %s", err, request.body)
+			}
+		})
+	}
+}
+
+func TestRegisterRejected(t *testing.T) {
+	server := newFakeServer()
+	server.respond("/api/agents/register", http.StatusForbidden, `{"error":"forbidden"}`)
+	agent := newTestAgent(server)
+
+	if err := agent.Register(); err == nil {
+		t.Fatal("Register succeeded, want the server's refusal")
+	}
+	if agent.registered {
+		t.Error("agent registered despite the refusal")
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name     string
+		agentID  string
+		path     string
+		status   int
+		wantErr  bool
+		wantSent int
+	}{
+		{"with ID", "node-1", "/api/agents/node-1/heartbeat", http.StatusOK, false, 1},
+		{"without ID", "", "/api/agents/heartbeat", http.StatusOK, false, 1},
+		{"refused", "node-1", "/api/agents/node-1/heartbeat", http.StatusUnauthorized, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer()
+			server.respond(tt.path, tt.status, `{}`)
+			agent := newTestAgent(server)
+			agent.agentID = tt.agentID
+			agent.registered = true
+
+			err := agent.heartbeat()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("heartbeat error = %v, want error %v", err, tt.wantErr)
+			}
+
+			requests := server.received()
+			if len(requests) != tt.wantSent {
+				t.Fatalf("made %d requests, want %d", len(requests), tt.wantSent)
+			}
+			if requests[0].path != tt.path || requests[0].method != http.MethodPost {
+				t.Errorf("sent %s %s, want POST %s", requests[0].method, requests[0].path, tt.path)
+			}
+			if got := requests[0].header.Get("Authorization"); got != "Bearer test-token" {
+				t.Errorf("Authorization = %q, want the agent's token", got)
+			}
+			if recorded := agent.healthStatus().LastHeartbeat != nil; recorded == tt.wantErr {
+				t.Errorf("last heartbeat recorded = %v, want %v", recorded, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeartbeatNotRegistered(t *testing.T) {
+	server := newFakeServer()
+	agent := newTestAgent(server)
+
+	if err := agent.heartbeat(); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	if requests := server.received(); len(requests) != 0 {
+		t.Errorf("unregistered agent made %d requests", len(requests))
+	}
+}
+
+// A server missing the inventory a delta heartbeat refers to gets the full
+// inventory at once
+func TestHeartbeatResync(t *testing.T) {
+	server := newFakeServer()
+	server.respond("/api/agents/node-1/heartbeat", http.StatusOK, `{}`)
+	agent := newTestAgent(server)
+	agent.agentID = "node-1"
+	agent.registered = true
+
+	if err := agent.heartbeat(); err != nil {
+		t.Fatalf("first heartbeat: %v", err)
+	}
+	server.respond("/api/agents/node-1/heartbeat", http.StatusOK, `{"resync":true}`)
+	if err := agent.heartbeat(); err != nil {
+		t.Fatalf("second heartbeat: %v", err)
+	}
+
+	requests := server.received()
+	if len(requests) != 3 {
+		t.Fatalf("made %d requests, want the full, the delta and the resent full heartbeat", len(requests))
+	}
+	for i, wantInfo := range []bool{true, false, true} {
+		var payload map[string]interface{}
+		if err := json.Unmarshal(requests[i].body, &payload); err != nil {
+			t.Fatalf("heartbeat %d: %v", i, err)
+		}
+		if _, hasInfo := payload["system_info"]; hasInfo != wantInfo {
+			t.Errorf("heartbeat %d carries system_info = %v, want %v", i, hasInfo, wantInfo)
+		}
+	}
+}
+
+func TestFetchTasks(t *testing.T) {
+	server := newFakeServer()
+	server.respond("/api/agents/node 1/tasks", http.StatusOK, `{"tasks":[{"id":"task-1","type":"command","content":"uptime"}],"total":1}`)
+	agent := newTestAgent(server)
+
+	if tasks := agent.fetchTasks(); len(tasks) != 0 {
+		t.Fatalf("agent without an ID fetched %d tasks", len(tasks))
+	}
+	if requests := server.received(); len(requests) != 0 {
+		t.Fatalf("agent without an ID made %d requests", len(requests))
+	}
+
+	agent.agentID = "node 1"
+	tasks := agent.fetchTasks()
+	if len(tasks) != 1 || tasks[0].ID != "task-1" {
+		t.Fatalf("fetched %+v, want task-1", tasks)
+	}
+	requests := server.received()
+	if len(requests) != 1 || requests[0].method != http.MethodGet {
+		t.Fatalf("made %+v, want one GET", requests)
+	}
+	if got := requests[0].header.Get("Authorization"); got != "Bearer test-token" {
+		t.Errorf("Authorization = %q, want the agent's token", got)
+	}
+}
+
+func TestDeliverResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		wantErr      bool
+		wantRejected bool
+	}{
+		{"accepted", http.StatusOK, false, false},
+		{"unknown task", http.StatusNotFound, true, true},
+		{"rate limited", http.StatusTooManyRequests, true, false},
+		{"server error", http.StatusServiceUnavailable, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newFakeServer()
+			server.respond("/api/tasks/task-1/result", tt.status, `{}`)
+			agent := newTestAgent(server)
+
+			result := TaskResult{TaskID: "task-1", Success: true, Output: "done"}
+			err := agent.deliverResult(context.Background(), result, "req-1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("deliverResult error = %v, want error %v", err, tt.wantErr)
+			}
+			var rejected *rejectedError
+			if errors.As(err, &rejected) != tt.wantRejected {
+				t.Errorf("rejected = %v, want %v", errors.As(err, &rejected), tt.wantRejected)
+			}
+
+			requests := server.received()
+			if len(requests) != 1 {
+				t.Fatalf("made %d requests, want 1", len(requests))
+			}
+			if got := requests[0].header.Get("X-Request-ID"); got != "req-1" {
+				t.Errorf("X-Request-ID = %q, want the task's request ID", got)
+			}
+			var sent TaskResult
+			if err := json.Unmarshal(requests[0].body, &sent); err != nil || sent.TaskID != result.TaskID || sent.Output != result.Output || !sent.Success {
+				t.Errorf("sent %s, want %+v", requests[0].body, result)
+			}
+		})
+	}
+}
+
+// Queued results are delivered in order; rejected ones are dropped and the
+// others kept until the server accepts them
+func TestFlushResults(t *testing.T) {
+	server := newFakeServer()
+	server.respond("/api/tasks/task-1/result", http.StatusOK, `{}`)
+	server.respond("/api/tasks/task-2/result", http.StatusNotFound, `{}`)
+	server.respond("/api/tasks/task-3/result", http.StatusBadGateway, `{}`)
+	agent := newTestAgent(server)
+
+	for _, id := range []string{"task-1", "task-2", "task-3"} {
+		agent.reportTaskResult(TaskResult{TaskID: id, Success: true}, "")
+	}
+	if agent.flushResults(context.Background()) {
+		t.Fatal("queue emptied despite the server failing task-3")
+	}
+	if ids := agent.results.taskIDs(); len(ids) != 1 || ids[0] != "task-3" {
+		t.Fatalf("queued %v, want [task-3]", ids)
+	}
+
+	server.respond("/api/tasks/task-3/result", http.StatusOK, `{}`)
+	if !agent.flushResults(context.Background()) {
+		t.Fatal("queue not emptied once the server accepted task-3")
+	}
+
+	var paths []string
+	for _, request := range server.received() {
+		paths = append(paths, request.path)
+	}
+	want := []string{"/api/tasks/task-1/result", "/api/tasks/task-2/result", "/api/tasks/task-3/result", "/api/tasks/task-3/result"}
+	if len(paths) != len(want) {
+		t.Fatalf("sent %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("sent %v, want %v", paths, want)
+		}
+	}
+}
+
+// Stop cancels the requests in flight rather than waiting out the request
+// timeout of a server that has stopped answering
+func TestStopWhileServerHung(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		select {
+		case requested <- struct{}{}:
+		default:
+		}
+		select {
+		case <-release:
+		case <-req.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	agent := NewAgentWithLogger(server.URL, "test-token", 10*time.Millisecond, log.NewWithWriter(false, io.Discard))
+	agent.agentID = "node-1"
+	agent.registered = true
+	agent.StartHeartbeat()
+	agent.StartTaskListener()
+
+	select {
+	case <-requested:
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent made no request")
+	}
+
+	stopped := make(chan struct{})
+	start := time.Now()
+	go func() {
+		agent.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatalf("Stop still waiting on the hung server after %v", time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// An update restarts only once the agent has stopped and delivered the
+// update's result
+func TestRestartAfterUpdate(t *testing.T) {
+	server := newFakeServer()
+	server.respond("/api/tasks/task-1/result", http.StatusOK, `{}`)
+	agent := newTestAgent(server)
+
+	var sentBeforeRestart []fakeRequest
+	restarted := false
+	agent.restart = func() {
+		restarted = true
+		sentBeforeRestart = server.received()
+	}
+
+	agent.reportTaskResult(TaskResult{TaskID: "task-1", Success: true}, "")
+	agent.restartSelf()
+
+	if !restarted {
+		t.Fatal("agent did not restart")
+	}
+	if len(sentBeforeRestart) != 1 || sentBeforeRestart[0].path != "/api/tasks/task-1/result" {
+		t.Fatalf("sent %v before restarting, want the task-1 result", sentBeforeRestart)
+	}
+	if agent.ctx.Err() == nil {
+		t.Error("agent still running when it restarted")
+	}
+}
+
+// A restart requested by the server stops the agent before restarting it
+func TestRestartRequested(t *testing.T) {
+	agent := newTestAgent(newFakeServer())
+	restarted := make(chan bool, 1)
+	agent.restart = func() { restarted <- agent.ctx.Err() != nil }
+
+	if reply := agent.handleControlMessage(ControlMessage{Type: "restart"}, nil); reply != nil {
+		t.Errorf("replied %+v to a restart", reply)
+	}
+	select {
+	case stopped := <-restarted:
+		if !stopped {
+			t.Error("agent still running when it restarted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("agent did not restart")
+	}
+}
+
+func TestUpdateChecksum(t *testing.T) {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	tests := []struct {
+		name   string
+		params map[string]interface{}
+		want   string
+	}{
+		{"single checksum", map[string]interface{}{"checksum": "abc"}, "abc"},
+		{"checksum for this platform", map[string]interface{}{"checksums": map[string]interface{}{platform: "def", "plan9/mips": "123"}}, "def"},
+		{"checksum wins", map[string]interface{}{"checksum": "abc", "checksums": map[string]interface{}{platform: "def"}}, "abc"},
+		{"no checksum for this platform", map[string]interface{}{"checksums": map[string]interface{}{"plan9/mips": "123"}}, ""},
+		{"no checksum", map[string]interface{}{"version": "1.2.0"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := updateChecksum(tt.params); got != tt.want {
+				t.Errorf("updateChecksum() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,452 @@
+// Package core provides agent-side alert rule evaluation: the server pushes
+// the rules agents can evaluate in a rules_update control message, and agents
+// in agent evaluation mode report the rules their metrics start matching as
+// alert candidates instead of sending their metrics every heartbeat.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerve/agent/pkg/sysinfo"
+)
+
+// Alert evaluation modes, set by the server in the pushed configuration
+const (
+	// AlertEvaluationServer sends metrics every heartbeat, for the server to
+	// evaluate; the default
+	AlertEvaluationServer = "server"
+	// AlertEvaluationAgent evaluates the pushed rules on the agent and
+	// reports alert candidates
+	AlertEvaluationAgent = "agent"
+)
+
+const (
+	// alertMetricsEvery is how often, in heartbeats, agents evaluating rules
+	// still send their metrics, so the server's view of them stays current
+	alertMetricsEvery = 10
+
+	// maxAlertCandidates caps the candidates kept while heartbeats fail
+	maxAlertCandidates = 100
+)
+
+// AlertCondition is a condition of a pushed alert rule, with the operators
+// of the server's rules
+type AlertCondition struct {
+	Field    string      `json:"field"`
+	Operator string      `json:"operator"`
+	Value    interface{} `json:"value"`
+}
+
+// ConditionGroup nests conditions and groups combined with its own logic
+type ConditionGroup struct {
+	Logic      string           `json:"logic,omitempty"`
+	Conditions []AlertCondition `json:"conditions,omitempty"`
+	Groups     []ConditionGroup `json:"groups,omitempty"`
+}
+
+// AlertRule is a rule pushed by the server for the agent to evaluate
+type AlertRule struct {
+	ID         string           `json:"id"`
+	Severity   string           `json:"severity"`
+	Logic      string           `json:"logic,omitempty"`
+	Conditions []AlertCondition `json:"conditions,omitempty"`
+	Groups     []ConditionGroup `json:"groups,omitempty"`
+}
+
+// AlertRuleSet is the versioned set of rules of a rules_update message
+type AlertRuleSet struct {
+	Version string      `json:"version"`
+	Rules   []AlertRule `json:"rules"`
+}
+
+// AlertCandidate reports a rule the agent's metrics started matching, with
+// the values of the fields the rule looks at so the server can check it
+type AlertCandidate struct {
+	RuleID    string                 `json:"rule_id"`
+	Data      map[string]interface{} `json:"data"`
+	MatchedAt time.Time              `json:"matched_at"`
+}
+
+// alertEvaluator holds the pushed rules and which of them match
+type alertEvaluator struct {
+	mu       sync.Mutex
+	mode     string
+	rules    *AlertRuleSet
+	patterns map[string]*regexp.Regexp
+
+	// stale is set when the server reports a newer rules version
+	stale bool
+
+	// matched holds the rules matching at the last evaluation; a candidate is
+	// only reported when a rule starts matching
+	matched map[string]bool
+
+	// pending candidates are sent with each heartbeat until one is delivered
+	pending []AlertCandidate
+
+	// sinceMetrics counts the heartbeats sent without metrics
+	sinceMetrics int
+}
+
+// newAlertEvaluator creates an evaluator in server evaluation mode
+func newAlertEvaluator() *alertEvaluator {
+	return &alertEvaluator{
+		mode:    AlertEvaluationServer,
+		matched: make(map[string]bool),
+	}
+}
+
+// validAlertEvaluation reports whether mode is an alert evaluation mode
+func validAlertEvaluation(mode string) bool {
+	return mode == AlertEvaluationServer || mode == AlertEvaluationAgent
+}
+
+// setMode switches the evaluation mode; leaving agent mode drops the
+// undelivered candidates
+func (e *alertEvaluator) setMode(mode string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if mode == e.mode {
+		return
+	}
+	e.mode = mode
+	e.matched = make(map[string]bool)
+	e.pending = nil
+	e.sinceMetrics = 0
+}
+
+// evaluating reports whether heartbeats evaluate the rules
+func (e *alertEvaluator) evaluating() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.mode == AlertEvaluationAgent && e.rules != nil
+}
+
+// setRules replaces the rules after checking their operators and patterns.
+// Rules that keep matching don't report candidates again.
+func (e *alertEvaluator) setRules(set AlertRuleSet) error {
+	if set.Version == "" {
+		return fmt.Errorf("rules version is required")
+	}
+	patterns := make(map[string]*regexp.Regexp)
+	for _, rule := range set.Rules {
+		if rule.ID == "" {
+			return fmt.Errorf("rule without id")
+		}
+		if err := compileConditions(rule.Conditions, rule.Groups, patterns); err != nil {
+			return fmt.Errorf("rule %s: %v", rule.ID, err)
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	matched := make(map[string]bool)
+	for _, rule := range set.Rules {
+		matched[rule.ID] = e.matched[rule.ID]
+	}
+	e.rules = &set
+	e.patterns = patterns
+	e.matched = matched
+	e.stale = false
+	return nil
+}
+
+// checkVersion marks the rules stale if the server holds another version,
+// so metrics are sent in full until the current rules arrive
+func (e *alertEvaluator) checkVersion(version string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.mode != AlertEvaluationAgent || version == "" {
+		return false
+	}
+	stale := e.rules == nil || e.rules.Version != version
+	changed := stale && !e.stale
+	e.stale = stale
+	return changed
+}
+
+// evaluate evaluates the rules against the heartbeat's metrics. It returns
+// the candidates to report, the rules version, empty if the agent doesn't
+// evaluate rules, and whether the heartbeat should carry its metrics.
+func (e *alertEvaluator) evaluate(usage *sysinfo.Usage, custom map[string]interface{}) ([]AlertCandidate, string, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.mode != AlertEvaluationAgent || e.rules == nil || usage == nil {
+		return nil, "", true
+	}
+
+	data := alertData(usage, custom)
+	now := time.Now()
+	for _, rule := range e.rules.Rules {
+		matched := evaluateGroup(rule.Logic, rule.Conditions, rule.Groups, data, e.patterns)
+		if matched && !e.matched[rule.ID] && len(e.pending) < maxAlertCandidates {
+			e.pending = append(e.pending, AlertCandidate{
+				RuleID:    rule.ID,
+				Data:      ruleData(rule.Conditions, rule.Groups, data, map[string]interface{}{}),
+				MatchedAt: now,
+			})
+		}
+		e.matched[rule.ID] = matched
+	}
+
+	// Metrics go along with candidates, so the server holds what fired them
+	e.sinceMetrics++
+	sendMetrics := e.stale || len(e.pending) > 0 || e.sinceMetrics >= alertMetricsEvery
+	if sendMetrics {
+		e.sinceMetrics = 0
+	}
+	candidates := append([]AlertCandidate(nil), e.pending...)
+	return candidates, e.rules.Version, sendMetrics
+}
+
+// delivered drops the first n pending candidates once a heartbeat carrying
+// them was accepted
+func (e *alertEvaluator) delivered(n int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if n > len(e.pending) {
+		n = len(e.pending)
+	}
+	e.pending = e.pending[n:]
+	if len(e.pending) == 0 {
+		e.pending = nil
+	}
+}
+
+// handleRulesUpdate replaces the alert rules with those pushed by the server
+func (a *Agent) handleRulesUpdate(msg ControlMessage) {
+	var set AlertRuleSet
+	if err := json.Unmarshal(msg.Data, &set); err != nil {
+		a.logger.Errorf("Invalid rules update: %v", err)
+		return
+	}
+	if err := a.alerts.setRules(set); err != nil {
+		a.logger.Errorf("Rejected rules version %s: %v", set.Version, err)
+		return
+	}
+	a.logger.Infof("Loaded %d alert rules, version %s", len(set.Rules), set.Version)
+}
+
+// alertData returns the metrics rules are evaluated against: the usage
+// fields, e.g. cpu_usage, and plugin metrics under custom
+func alertData(usage *sysinfo.Usage, custom map[string]interface{}) map[string]interface{} {
+	data := map[string]interface{}{}
+	raw, err := json.Marshal(usage)
+	if err == nil {
+		json.Unmarshal(raw, &data)
+	}
+	if len(custom) > 0 {
+		data["custom"] = custom
+	}
+	return data
+}
+
+// ruleData collects the values of the fields a rule's conditions look at
+func ruleData(conditions []AlertCondition, groups []ConditionGroup, data, into map[string]interface{}) map[string]interface{} {
+	for _, condition := range conditions {
+		if value, ok := lookupField(data, condition.Field); ok {
+			into[condition.Field] = value
+		}
+	}
+	for _, group := range groups {
+		ruleData(group.Conditions, group.Groups, data, into)
+	}
+	return into
+}
+
+// compileConditions checks the operators of conditions and nested groups,
+// compiling regex patterns into patterns
+func compileConditions(conditions []AlertCondition, groups []ConditionGroup, patterns map[string]*regexp.Regexp) error {
+	for _, condition := range conditions {
+		switch condition.Operator {
+		case "eq", "ne", "gt", "gte", "lt", "lte", "contains", "in", "not_in":
+		case "regex":
+			pattern, ok := condition.Value.(string)
+			if !ok {
+				return fmt.Errorf("regex condition on %s needs a pattern string", condition.Field)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid regex on %s: %v", condition.Field, err)
+			}
+			patterns[pattern] = re
+		default:
+			return fmt.Errorf("unknown operator %q", condition.Operator)
+		}
+	}
+	for _, group := range groups {
+		if err := compileConditions(group.Conditions, group.Groups, patterns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluateGroup combines conditions and nested groups with the given logic,
+// "and" by default; an empty AND group matches and an empty OR group does not
+func evaluateGroup(logic string, conditions []AlertCondition, groups []ConditionGroup, data map[string]interface{}, patterns map[string]*regexp.Regexp) bool {
+	or := logic == "or"
+	matched := !or
+	combine := func(m bool) {
+		if or {
+			matched = matched || m
+		} else {
+			matched = matched && m
+		}
+	}
+	for _, condition := range conditions {
+		combine(evaluateCondition(condition, data, patterns))
+	}
+	for _, group := range groups {
+		combine(evaluateGroup(group.Logic, group.Conditions, group.Groups, data, patterns))
+	}
+	return matched
+}
+
+// evaluateCondition checks a single condition the way the server does
+func evaluateCondition(condition AlertCondition, data map[string]interface{}, patterns map[string]*regexp.Regexp) bool {
+	value, exists := lookupField(data, condition.Field)
+	if !exists {
+		return false
+	}
+
+	switch condition.Operator {
+	case "eq":
+		return equalValues(value, condition.Value)
+	case "ne":
+		return !equalValues(value, condition.Value)
+	case "gt", "gte", "lt", "lte":
+		cmp, ok := compareNumbers(value, condition.Value)
+		if !ok {
+			return false
+		}
+		switch condition.Operator {
+		case "gt":
+			return cmp > 0
+		case "gte":
+			return cmp >= 0
+		case "lt":
+			return cmp < 0
+		default:
+			return cmp <= 0
+		}
+	case "contains":
+		str, ok := value.(string)
+		target, ok2 := condition.Value.(string)
+		return ok && ok2 && strings.Contains(str, target)
+	case "in":
+		return inList(value, condition.Value)
+	case "not_in":
+		_, ok := condition.Value.([]interface{})
+		return ok && !inList(value, condition.Value)
+	case "regex":
+		pattern, _ := condition.Value.(string)
+		re := patterns[pattern]
+		return re != nil && re.MatchString(fmt.Sprint(value))
+	default:
+		return false
+	}
+}
+
+// lookupField returns the value of a condition field. A key present as is
+// wins; otherwise a dotted field walks nested maps.
+func lookupField(data map[string]interface{}, field string) (interface{}, bool) {
+	if value, ok := data[field]; ok {
+		return value, true
+	}
+	if !strings.Contains(field, ".") {
+		return nil, false
+	}
+
+	var value interface{} = data
+	for _, key := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// equalValues reports whether a metric equals a condition value. Lists and
+// objects, which == panics on, compare deeply.
+func equalValues(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// compareNumbers returns -1, 0 or 1 comparing a to b, and false if either
+// value is not numeric
+func compareNumbers(a, b interface{}) (int, bool) {
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	if !okA || !okB {
+		return 0, false
+	}
+
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// toFloat converts JSON numbers and numeric strings to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// inList reports whether value is a member of list, a JSON array. Numbers
+// compare by value so 1 matches 1.0.
+func inList(value, list interface{}) bool {
+	items, ok := list.([]interface{})
+	if !ok {
+		return false
+	}
+
+	for _, item := range items {
+		if cmp, ok := compareNumbers(value, item); ok {
+			if cmp == 0 {
+				return true
+			}
+			continue
+		}
+		if fmt.Sprint(value) == fmt.Sprint(item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,270 @@
+// Package core provides the built-in benchmarks run by benchmark tasks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Benchmark bounds; params outside them are rejected so a run stays bounded
+const (
+	defaultBenchmarkDuration = 10 * time.Second
+	maxBenchmarkDuration     = 5 * time.Minute
+
+	// cpuBenchmarkBlock is the data hashed per operation of the cpu benchmark
+	cpuBenchmarkBlock = 64 * 1024
+
+	defaultDiskBenchmarkSizeMB = 256
+	maxDiskBenchmarkSizeMB     = 4096
+
+	// Block sizes of the sequential and random phases of the disk benchmark
+	diskSequentialBlock = 1024 * 1024
+	diskRandomBlock     = 4096
+
+	// diskCacheDropEvery is how many random reads run between dropping the
+	// file from the page cache, so repeated offsets aren't served from memory
+	diskCacheDropEvery = 256
+)
+
+// executeBenchmark runs the built-in benchmark named in the task params and
+// returns its measurements as structured data. Only one benchmark runs at a
+// time, so concurrent runs don't skew each other.
+func (a *Agent) executeBenchmark(task Task) TaskResult {
+	result := TaskResult{TaskID: task.ID}
+
+	a.mu.Lock()
+	if a.benchmarking {
+		a.mu.Unlock()
+		result.Error = "another benchmark is running on this host"
+		return result
+	}
+	a.benchmarking = true
+	a.mu.Unlock()
+	defer func() {
+		a.mu.Lock()
+		a.benchmarking = false
+		a.mu.Unlock()
+	}()
+
+	name, _ := task.Params["benchmark"].(string)
+	var data map[string]interface{}
+	var err error
+	switch name {
+	case "cpu":
+		data, err = runCPUBenchmark(task.Params)
+	case "disk":
+		data, err = runDiskBenchmark(task.Params)
+	default:
+		err = fmt.Errorf("unknown benchmark: %q", name)
+	}
+	if err != nil {
+		result.Error = fmt.Sprintf("%s benchmark: %v", name, err)
+		return result
+	}
+
+	result.Success = true
+	result.Data = data
+	result.Output = benchmarkSummary(name, data)
+	return result
+}
+
+// runCPUBenchmark hashes in-memory data with SHA-256 on a number of threads
+// (default: all CPUs) for a duration (default 10s), measuring throughput
+func runCPUBenchmark(params map[string]interface{}) (map[string]interface{}, error) {
+	threads, err := intParam(params, "threads", runtime.NumCPU(), 1, 4*runtime.NumCPU())
+	if err != nil {
+		return nil, err
+	}
+	duration, err := durationParam(params, "duration", defaultBenchmarkDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	block := make([]byte, cpuBenchmarkBlock)
+	if _, err := rand.Read(block); err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, threads)
+	var wg sync.WaitGroup
+	start := time.Now()
+	deadline := start.Add(duration)
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				// Check the clock every few operations only
+				for j := 0; j < 16; j++ {
+					sha256.Sum256(block)
+				}
+				counts[i] += 16
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start).Seconds()
+
+	var total int64
+	for _, count := range counts {
+		total += count
+	}
+	mb := float64(total) * cpuBenchmarkBlock / (1024 * 1024)
+	return map[string]interface{}{
+		"threads":                  float64(threads),
+		"duration_seconds":         elapsed,
+		"operations":               float64(total),
+		"operations_per_second":    float64(total) / elapsed,
+		"mb_per_second":            mb / elapsed,
+		"mb_per_second_per_thread": mb / elapsed / float64(threads),
+	}, nil
+}
+
+// runDiskBenchmark measures the filesystem at path (default: the temp
+// directory) fio-style on a scratch file of size_mb (default 256): a
+// sequential write flushed to disk, a sequential read with the file dropped
+// from the page cache, then random 4K reads and synced random 4K writes,
+// splitting duration (default 10s) between them
+func runDiskBenchmark(params map[string]interface{}) (map[string]interface{}, error) {
+	dir, _ := params["path"].(string)
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	sizeMB, err := intParam(params, "size_mb", defaultDiskBenchmarkSizeMB, 1, maxDiskBenchmarkSizeMB)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := durationParam(params, "duration", defaultBenchmarkDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.CreateTemp(dir, "nerve-benchmark-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	size := int64(sizeMB) * 1024 * 1024
+	block := make([]byte, diskSequentialBlock)
+	if _, err := rand.Read(block); err != nil {
+		return nil, err
+	}
+
+	// Sequential write, including the flush to disk
+	start := time.Now()
+	for written := int64(0); written < size; written += diskSequentialBlock {
+		if _, err := file.Write(block); err != nil {
+			return nil, fmt.Errorf("sequential write: %v", err)
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("sync: %v", err)
+	}
+	writeSeconds := time.Since(start).Seconds()
+
+	// Sequential read from disk rather than the page cache
+	cold := dropFileCache(file) == nil
+	start = time.Now()
+	if _, err := io.CopyBuffer(io.Discard, io.NewSectionReader(file, 0, size), block); err != nil {
+		return nil, fmt.Errorf("sequential read: %v", err)
+	}
+	readSeconds := time.Since(start).Seconds()
+
+	blocks := size / diskRandomBlock
+	random := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
+	io4k := block[:diskRandomBlock]
+
+	// Random reads
+	reads := 0
+	start = time.Now()
+	for deadline := start.Add(duration / 2); time.Now().Before(deadline); reads++ {
+		if reads%diskCacheDropEvery == 0 {
+			dropFileCache(file)
+		}
+		if _, err := file.ReadAt(io4k, random.Int63n(blocks)*diskRandomBlock); err != nil {
+			return nil, fmt.Errorf("random read: %v", err)
+		}
+	}
+	readRandomSeconds := time.Since(start).Seconds()
+
+	// Random writes, each synced like a database commit
+	writes := 0
+	start = time.Now()
+	for deadline := start.Add(duration / 2); time.Now().Before(deadline); writes++ {
+		if _, err := file.WriteAt(io4k, random.Int63n(blocks)*diskRandomBlock); err != nil {
+			return nil, fmt.Errorf("random write: %v", err)
+		}
+		if err := file.Sync(); err != nil {
+			return nil, fmt.Errorf("sync: %v", err)
+		}
+	}
+	writeRandomSeconds := time.Since(start).Seconds()
+
+	return map[string]interface{}{
+		"path":                       dir,
+		"size_mb":                    float64(sizeMB),
+		"cache_dropped":              cold,
+		"seq_write_mb_per_second":    float64(sizeMB) / writeSeconds,
+		"seq_read_mb_per_second":     float64(sizeMB) / readSeconds,
+		"rand_read_iops":             float64(reads) / readRandomSeconds,
+		"rand_read_latency_ms":       readRandomSeconds * 1000 / float64(max(reads, 1)),
+		"rand_sync_write_iops":       float64(writes) / writeRandomSeconds,
+		"rand_sync_write_latency_ms": writeRandomSeconds * 1000 / float64(max(writes, 1)),
+	}, nil
+}
+
+// benchmarkSummary formats benchmark results as the task output
+func benchmarkSummary(name string, data map[string]interface{}) string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := []string{name + " benchmark:"}
+	for _, key := range keys {
+		switch value := data[key].(type) {
+		case float64:
+			lines = append(lines, fmt.Sprintf("  %s: %.2f", key, value))
+		default:
+			lines = append(lines, fmt.Sprintf("  %s: %v", key, value))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// intParam reads an integer param, which JSON decodes as float64
+func intParam(params map[string]interface{}, key string, def, min, max int) (int, error) {
+	value, ok := params[key]
+	if !ok {
+		return def, nil
+	}
+	number, ok := value.(float64)
+	if !ok || number != float64(int(number)) || int(number) < min || int(number) > max {
+		return 0, fmt.Errorf("%s must be an integer between %d and %d", key, min, max)
+	}
+	return int(number), nil
+}
+
+// durationParam reads a duration param given in seconds
+func durationParam(params map[string]interface{}, key string, def time.Duration) (time.Duration, error) {
+	seconds, err := intParam(params, key, int(def/time.Second), 1, int(maxBenchmarkDuration/time.Second))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
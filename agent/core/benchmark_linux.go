@@ -0,0 +1,17 @@
+// Package core provides page cache control for the disk benchmark on Linux.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// dropFileCache evicts a file's clean pages from the page cache, so the
+// next reads hit the disk
+func dropFileCache(file *os.File) error {
+	return unix.Fadvise(int(file.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+// Package core provides page cache control for the disk benchmark elsewhere.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"errors"
+	"os"
+)
+
+// dropFileCache is unsupported outside Linux; reads may be served from the
+// page cache, which the disk benchmark reports as cache_dropped false
+func dropFileCache(file *os.File) error {
+	return errors.New("dropping the page cache is not supported on this platform")
+}
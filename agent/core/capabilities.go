@@ -0,0 +1,57 @@
+// Package core provides the capabilities the agent advertises at registration.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+// Agent capabilities; task types double as the capability needed to run them.
+// They must match the names the server checks at dispatch.
+const (
+	CapabilityCommand        = "command"
+	CapabilityScript         = "script"
+	CapabilityHook           = "hook"
+	CapabilityUpdate         = "update"
+	CapabilityBenchmark      = "benchmark"
+	CapabilityDeltaHeartbeat = "delta_heartbeat"
+	CapabilityCustomMetrics  = "custom_metrics"
+	CapabilityGRPC           = "grpc"
+	CapabilityControl        = "control"
+	CapabilityLogTail        = "log_tail"
+	CapabilityDecommission   = "decommission"
+	CapabilityExec           = "exec"
+	CapabilityRecentLogs     = "recent_logs"
+	CapabilityAgentAlerts    = "agent_alerts"
+	CapabilityRefresh        = "refresh"
+	CapabilityRestart        = "restart"
+)
+
+// capabilities lists what the agent supports as configured, so the server
+// doesn't dispatch tasks the agent would refuse
+func (a *Agent) capabilities() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	capabilities := []string{CapabilityHook, CapabilityUpdate, CapabilityBenchmark, CapabilityControl, CapabilityDecommission, CapabilityAgentAlerts, CapabilityRefresh, CapabilityRestart}
+	if a.commandPolicy == nil || a.commandPolicy.Mode != PolicyModeDisabled {
+		capabilities = append(capabilities, CapabilityCommand, CapabilityScript)
+	}
+	if a.heartbeatDelta {
+		capabilities = append(capabilities, CapabilityDeltaHeartbeat)
+	}
+	if a.plugins != nil {
+		capabilities = append(capabilities, CapabilityCustomMetrics)
+	}
+	if a.grpcConn != nil {
+		capabilities = append(capabilities, CapabilityGRPC)
+	}
+	if a.logTail != nil {
+		capabilities = append(capabilities, CapabilityLogTail)
+	}
+	if a.exec != nil {
+		capabilities = append(capabilities, CapabilityExec)
+	}
+	if a.logBuffer != nil {
+		capabilities = append(capabilities, CapabilityRecentLogs)
+	}
+	return capabilities
+}
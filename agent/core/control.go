@@ -0,0 +1,354 @@
+// Package core provides the WebSocket control channel used by the server to push configuration, log tail, exec, decommission and token rotation requests.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// controlReadTimeout bounds the silence between server pings (sent every 54s)
+	controlReadTimeout = 90 * time.Second
+
+	// minHeartbeatInterval is the smallest heartbeat interval the server may push
+	minHeartbeatInterval = time.Second
+
+	// controlWriteTimeout bounds each write to the control channel
+	controlWriteTimeout = 10 * time.Second
+)
+
+var pluginNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ControlMessage is a message exchanged on the control channel
+type ControlMessage struct {
+	Type      string          `json:"type"`
+	AgentID   string          `json:"agent_id,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+}
+
+// AgentConfig is the runtime configuration pushed by the server. Unset
+// fields leave the current setting unchanged.
+type AgentConfig struct {
+	Version           int64    `json:"version"`
+	HeartbeatInterval string   `json:"heartbeat_interval,omitempty"`
+	Debug             *bool    `json:"debug,omitempty"`
+	Plugins           []string `json:"plugins,omitempty"`
+
+	// AlertEvaluation is "server" or "agent", see AlertEvaluationAgent
+	AlertEvaluation string `json:"alert_evaluation,omitempty"`
+}
+
+// ConfigAck reports the outcome of applying a pushed configuration
+type ConfigAck struct {
+	Version int64  `json:"version"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// controlWriter serializes writes to a control channel connection, shared by
+// replies and running log tails; done is closed when the connection breaks
+type controlWriter struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+	done <-chan struct{}
+}
+
+// send writes a message to the control channel
+func (w *controlWriter) send(msg *ControlMessage) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.conn.SetWriteDeadline(time.Now().Add(controlWriteTimeout))
+	return w.conn.WriteJSON(msg)
+}
+
+// StartControlChannel keeps a WebSocket control channel open to the server
+// and handles control messages until the agent stops. A broken channel is
+// redialed with exponential backoff, see SetControlMaxBackoff; heartbeats
+// carry on over HTTP meanwhile.
+func (a *Agent) StartControlChannel() {
+	a.setControlState(ControlConnecting, nil)
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		failures := 0
+		for {
+			connectedAt, err := a.runControlChannel()
+
+			select {
+			case <-a.stopChan:
+				return
+			default:
+			}
+
+			if !connectedAt.IsZero() {
+				a.logger.Errorf("Control channel lost: %v", err)
+				if time.Since(connectedAt) >= controlStableAfter {
+					failures = 0
+				}
+			}
+			failures++
+			a.setControlState(ControlDisconnected, err)
+
+			delay := a.controlBackoff(failures)
+			a.logger.Debugf("Control channel: %v; redialing in %v", err, delay.Round(time.Millisecond))
+			select {
+			case <-a.stopChan:
+				return
+			case <-time.After(delay):
+			}
+		}
+	}()
+}
+
+// runControlChannel dials the control channel, announces the agent and
+// reads messages until it breaks. It returns when the channel connected,
+// zero if it never did, and why it broke.
+func (a *Agent) runControlChannel() (time.Time, error) {
+	a.mu.RLock()
+	agentID := a.agentID
+	a.mu.RUnlock()
+	if agentID == "" {
+		return time.Time{}, fmt.Errorf("agent has no ID yet")
+	}
+
+	wsURL, err := controlURL(a.serverURL, agentID)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+a.authToken())
+	header.Set("User-Agent", UserAgent)
+
+	dialer := websocket.Dialer{HandshakeTimeout: a.requestTimeout()}
+	if transport, ok := a.client.Transport.(*http.Transport); ok && transport != nil {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+
+	// Each dial authenticates with the current token, which may have been
+	// rotated since the last one
+	conn, _, err := dialer.DialContext(a.ctx, wsURL, header)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("dial: %v", err)
+	}
+	connectedAt := time.Now()
+	if previous := a.controlState(); previous != nil && previous.State == ControlDisconnected {
+		a.logger.Infof("Control channel reconnected after %v", connectedAt.Sub(previous.Since).Round(time.Second))
+	} else {
+		a.logger.Infof("Control channel connected")
+	}
+	a.setControlState(ControlConnected, nil)
+
+	// Unblock ReadMessage on shutdown
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-a.stopChan:
+			conn.Close()
+		case <-done:
+			conn.Close()
+		}
+	}()
+
+	out := &controlWriter{conn: conn, done: done}
+
+	conn.SetReadDeadline(time.Now().Add(controlReadTimeout))
+	conn.SetPingHandler(func(data string) error {
+		conn.SetReadDeadline(time.Now().Add(controlReadTimeout))
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(10*time.Second))
+	})
+
+	if err := out.send(a.helloMessage(agentID)); err != nil {
+		return connectedAt, fmt.Errorf("write: %v", err)
+	}
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return connectedAt, fmt.Errorf("read: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(controlReadTimeout))
+
+		// The server may batch several messages into one frame
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for decoder.More() {
+			var msg ControlMessage
+			if err := decoder.Decode(&msg); err != nil {
+				a.logger.Errorf("Decode control message: %v", err)
+				break
+			}
+			if reply := a.handleControlMessage(msg, out); reply != nil {
+				if err := out.send(reply); err != nil {
+					return connectedAt, fmt.Errorf("write: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// handleControlMessage processes a control message and returns the reply, if any
+func (a *Agent) handleControlMessage(msg ControlMessage, out *controlWriter) *ControlMessage {
+	switch msg.Type {
+	case "config":
+		var cfg AgentConfig
+		ack := ConfigAck{}
+		if err := json.Unmarshal(msg.Data, &cfg); err != nil {
+			ack.Error = fmt.Sprintf("invalid config: %v", err)
+		} else {
+			ack.Version = cfg.Version
+			if err := a.applyConfig(cfg); err != nil {
+				ack.Error = err.Error()
+				a.logger.Errorf("Rejected config version %d: %v", cfg.Version, err)
+			} else {
+				ack.Applied = true
+			}
+		}
+
+		data, _ := json.Marshal(ack)
+		return &ControlMessage{Type: "config_ack", AgentID: msg.AgentID, Data: data, Timestamp: time.Now()}
+	case "log_tail":
+		return a.handleLogTail(msg, out)
+	case "log_tail_stop":
+		a.handleLogTailStop(msg)
+		return nil
+	case "exec_start":
+		return a.handleExecStart(msg, out)
+	case "exec_input", "exec_resize":
+		a.handleExecInput(msg)
+		return nil
+	case "exec_stop":
+		a.handleExecStop(msg)
+		return nil
+	case "decommission":
+		a.handleDecommission(msg)
+		return nil
+	case "token_rotate":
+		return a.handleTokenRotate(msg)
+	case "recent_logs":
+		return a.handleRecentLogs(msg, out)
+	case "reregister":
+		a.handleReregister()
+		return nil
+	case "rules_update":
+		a.handleRulesUpdate(msg)
+		return nil
+	case "refresh":
+		a.handleRefresh()
+		return nil
+	case "restart":
+		a.logger.Info("Server requested a restart")
+		go a.restartSelf()
+		return nil
+	default:
+		a.logger.Debugf("Ignoring control message: %s", msg.Type)
+		return nil
+	}
+}
+
+// applyConfig validates a pushed configuration and applies it live. An
+// invalid configuration is rejected as a whole and nothing is changed.
+func (a *Agent) applyConfig(cfg AgentConfig) error {
+	var interval time.Duration
+	if cfg.HeartbeatInterval != "" {
+		parsed, err := time.ParseDuration(cfg.HeartbeatInterval)
+		if err != nil {
+			return fmt.Errorf("invalid heartbeat_interval: %v", err)
+		}
+		if parsed < minHeartbeatInterval {
+			return fmt.Errorf("heartbeat_interval must be at least %v", minHeartbeatInterval)
+		}
+		interval = parsed
+	}
+	for _, name := range cfg.Plugins {
+		if !pluginNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid plugin name: %q", name)
+		}
+	}
+	if cfg.AlertEvaluation != "" && !validAlertEvaluation(cfg.AlertEvaluation) {
+		return fmt.Errorf("invalid alert_evaluation %q, must be %q or %q", cfg.AlertEvaluation, AlertEvaluationServer, AlertEvaluationAgent)
+	}
+
+	a.mu.Lock()
+	if cfg.Version <= a.configVersion {
+		current := a.configVersion
+		a.mu.Unlock()
+		a.logger.Debugf("Config version %d already applied (current %d)", cfg.Version, current)
+		return nil
+	}
+	a.configVersion = cfg.Version
+	if interval > 0 && interval != a.interval {
+		a.interval = interval
+		// Re-arm the heartbeat ticker, replacing any change not yet picked up
+		select {
+		case <-a.intervalChanged:
+		default:
+		}
+		a.intervalChanged <- interval
+	}
+	if cfg.Plugins != nil {
+		a.enabledPlugins = cfg.Plugins
+	}
+	a.mu.Unlock()
+
+	if cfg.Debug != nil {
+		a.logger.SetDebug(*cfg.Debug)
+	}
+	if cfg.AlertEvaluation != "" {
+		a.alerts.setMode(cfg.AlertEvaluation)
+	}
+
+	a.logger.Infof("Applied config version %d (interval=%s, debug=%v, plugins=%v, alert_evaluation=%s)", cfg.Version, cfg.HeartbeatInterval, cfg.Debug != nil && *cfg.Debug, cfg.Plugins, cfg.AlertEvaluation)
+	return nil
+}
+
+// pluginEnabled reports whether the server allows running the named plugin
+func (a *Agent) pluginEnabled(name string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.enabledPlugins == nil {
+		return true
+	}
+	for _, enabled := range a.enabledPlugins {
+		if enabled == name {
+			return true
+		}
+	}
+	return false
+}
+
+// controlURL converts the server URL into the control channel WebSocket URL
+func controlURL(serverURL, agentID string) (string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid server URL: %v", err)
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+		u.Scheme = "wss"
+	default:
+		u.Scheme = "ws"
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws"
+	u.RawQuery = url.Values{"agent_id": {agentID}, "client_id": {agentID}}.Encode()
+
+	return u.String(), nil
+}
@@ -0,0 +1,110 @@
+// Package core provides the agent side of being decommissioned by the server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Service files written by the install script
+const (
+	systemdUnitPath = "/etc/systemd/system/nerve-agent.service"
+	openRCInitPath  = "/etc/init.d/nerve-agent"
+	serviceName     = "nerve-agent"
+)
+
+// decommissionRequest is the data of a decommission control message
+type decommissionRequest struct {
+	Uninstall bool `json:"uninstall"`
+}
+
+// handleDecommission stops the agent for good at the server's request. The
+// stop drains in-flight tasks and delivers their results first, then the
+// service and binary are removed if asked and the process exits.
+func (a *Agent) handleDecommission(msg ControlMessage) {
+	var req decommissionRequest
+	if len(msg.Data) > 0 {
+		if err := json.Unmarshal(msg.Data, &req); err != nil {
+			a.logger.Errorf("Invalid decommission request: %v", err)
+			return
+		}
+	}
+
+	a.logger.Info("Decommissioned by the server, stopping")
+	go func() {
+		a.Stop()
+		if req.Uninstall {
+			if err := a.uninstall(); err != nil {
+				a.logger.Errorf("Uninstall incomplete, please remove the agent manually: %v", err)
+			} else {
+				a.logger.Info("Agent uninstalled")
+			}
+		}
+		os.Exit(0)
+	}()
+}
+
+// uninstall removes the agent's service and binary. Under systemd the stop
+// is queued without waiting, as it would otherwise kill this process
+// mid-way; exiting afterwards doesn't trigger Restart=always.
+func (a *Agent) uninstall() error {
+	var errs []string
+	run := func(name string, args ...string) {
+		if out, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+			errs = append(errs, fmt.Sprintf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(out))))
+		}
+	}
+	remove := func(path string) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	systemd := fileExists(systemdUnitPath)
+	switch {
+	case systemd:
+		run("systemctl", "disable", serviceName)
+		remove(systemdUnitPath)
+	case fileExists(openRCInitPath):
+		run("rc-update", "del", serviceName, "default")
+		remove(openRCInitPath)
+	}
+
+	a.mu.RLock()
+	binary := a.binaryPath
+	a.mu.RUnlock()
+	if binary == "" {
+		if exe, err := os.Executable(); err == nil {
+			binary = exe
+		}
+	}
+	if binary != "" {
+		if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+			binary = resolved
+		}
+		remove(binary)
+	}
+
+	if systemd {
+		run("systemctl", "daemon-reload")
+		run("systemctl", "stop", "--no-block", serviceName)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
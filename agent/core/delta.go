@@ -0,0 +1,152 @@
+// Package core provides delta heartbeats that omit unchanged inventory.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/nerve/agent/pkg/sysinfo"
+)
+
+// SetHeartbeatDelta enables or disables delta heartbeats. With deltas, full
+// system_info is only sent when its hash differs from the one the server holds.
+func (a *Agent) SetHeartbeatDelta(enabled bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.heartbeatDelta = enabled
+}
+
+// volatileInventoryKeys are system_info values that change between
+// heartbeats; they are left out of the inventory hash so they don't force a
+// full resend, and are covered by the metrics block instead
+var volatileInventoryKeys = map[string]bool{
+	"update_time":   true,
+	"used":          true,
+	"available":     true,
+	"usage_percent": true,
+	"temperature":   true,
+	"power":         true,
+	"state":         true,
+	"rx_bytes":      true,
+	"tx_bytes":      true,
+	"rx_packets":    true,
+	"tx_packets":    true,
+}
+
+// inventoryHash returns a hash of the stable inventory in info
+func inventoryHash(info SystemInfo) string {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return ""
+	}
+	var inventory interface{}
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return ""
+	}
+	stripVolatile(inventory)
+
+	// Map keys are marshalled sorted, so equal inventories hash equally
+	data, err = json.Marshal(inventory)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stripVolatile removes volatileInventoryKeys from decoded JSON in place
+func stripVolatile(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if volatileInventoryKeys[key] {
+				delete(v, key)
+				continue
+			}
+			stripVolatile(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			stripVolatile(item)
+		}
+	}
+}
+
+// setInventoryHash records the inventory hash the server now holds
+func (a *Agent) setInventoryHash(hash string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inventoryHash = hash
+}
+
+// nextHeartbeatPayload builds the next heartbeat, leaving out system_info
+// when delta heartbeats are enabled and the inventory is unchanged. It
+// returns the inventory hash and whether the full inventory is included.
+func (a *Agent) nextHeartbeatPayload() (map[string]interface{}, string, bool) {
+	info := a.collectSystemInfo(a.selectedHeartbeatSections())
+	hash := inventoryHash(info)
+
+	// Heartbeats without an agent ID are matched by the hostname in system_info
+	a.mu.RLock()
+	full := !a.heartbeatDelta || a.agentID == "" || hash == "" || hash != a.inventoryHash
+	a.mu.RUnlock()
+
+	if full {
+		return a.heartbeatPayload(&info, hash), hash, true
+	}
+	return a.heartbeatPayload(nil, hash), hash, false
+}
+
+// heartbeatPayload formats heartbeat data according to backend expectations.
+// A nil info sends only the inventory hash; metrics are sent if selected,
+// see SetHeartbeatSections. Agents evaluating alert rules send the alert
+// candidates, and their metrics only along with candidates or every
+// alertMetricsEvery heartbeats.
+func (a *Agent) heartbeatPayload(info *SystemInfo, hash string) map[string]interface{} {
+	payload := map[string]interface{}{
+		"status":    "online",
+		"timestamp": time.Now().UTC(),
+	}
+
+	sendMetrics := a.selectedHeartbeatSections().includes(SectionMetrics)
+	var usage *sysinfo.Usage
+	if sendMetrics || a.alerts.evaluating() {
+		sample := sysinfo.GetUsage()
+		sample.Summary = a.metricSummary()
+		usage = &sample
+	}
+	custom := a.customMetrics()
+	candidates, rulesVersion, full := a.alerts.evaluate(usage, custom)
+	if rulesVersion != "" {
+		payload["rules_version"] = rulesVersion
+		if len(candidates) > 0 {
+			payload["alert_candidates"] = candidates
+		}
+	}
+
+	if sendMetrics && full {
+		payload["metrics"] = usage
+	}
+	if info != nil {
+		payload["system_info"] = info
+	}
+	if hash != "" {
+		payload["inventory_hash"] = hash
+	}
+	if len(custom) > 0 && full {
+		payload["custom"] = custom
+	}
+	if control := a.controlState(); control != nil {
+		payload["control"] = control
+	}
+	// Always sent, even empty, so the server knows the agent runs nothing
+	payload["tasks"] = a.taskIDs()
+	return payload
+}
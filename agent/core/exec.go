@@ -0,0 +1,375 @@
+// Package core provides interactive shells on a PTY, streamed over the
+// control channel for operators' exec sessions.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultExecIdleTimeout is how long a shell may go without input or
+	// output before it is killed
+	DefaultExecIdleTimeout = 10 * time.Minute
+
+	// DefaultMaxExecSessions caps the shells running at once
+	DefaultMaxExecSessions = 2
+
+	// execOutputChunk caps the output sent per message, so messages stay
+	// under the server's size limit once base64 encoded
+	execOutputChunk = 4096
+)
+
+// ExecOptions allows operators to open interactive shells on the agent
+type ExecOptions struct {
+	// Shells are the absolute paths of the shells that may be started; the
+	// first is started when a session names none
+	Shells []string
+	// IdleTimeout kills a shell after this long without input or output
+	IdleTimeout time.Duration
+	// MaxSessions caps the shells running at once
+	MaxSessions int
+}
+
+// execStartRequest is an exec_start control message from the server
+type execStartRequest struct {
+	ID          string `json:"id"`
+	Shell       string `json:"shell"`
+	Cols        int    `json:"cols"`
+	Rows        int    `json:"rows"`
+	IdleTimeout int    `json:"idle_timeout,omitempty"`
+}
+
+// execInput carries input for a shell, or a resize of its terminal
+type execInput struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+}
+
+// execOutput is sent for each chunk of a shell's output
+type execOutput struct {
+	ID   string `json:"id"`
+	Data []byte `json:"data"`
+}
+
+// execEnd is sent once a shell ends
+type execEnd struct {
+	ID       string `json:"id"`
+	Reason   string `json:"reason"`
+	Error    string `json:"error,omitempty"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+}
+
+// shellSession is a shell running on a PTY for an exec session
+type shellSession struct {
+	id    string
+	shell string
+	pty   *os.File
+	cmd   *exec.Cmd
+	input chan []byte
+	stop  chan struct{}
+}
+
+// SetExec allows the server to start the given shells on a PTY for
+// interactive exec sessions; without it exec is disabled. The shells run as
+// the agent's user, so only allow this where operators may have that access.
+func (a *Agent) SetExec(opts ExecOptions) error {
+	var shells []string
+	for _, shell := range opts.Shells {
+		if shell = strings.TrimSpace(shell); shell == "" {
+			continue
+		}
+		if !filepath.IsAbs(shell) {
+			return fmt.Errorf("exec shell %q must be an absolute path", shell)
+		}
+		shells = append(shells, filepath.Clean(shell))
+	}
+	if len(shells) > 0 && !ptySupported {
+		return errors.New("interactive exec is not supported on this platform")
+	}
+	opts.Shells = shells
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultExecIdleTimeout
+	}
+	if opts.MaxSessions <= 0 {
+		opts.MaxSessions = DefaultMaxExecSessions
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(opts.Shells) == 0 {
+		a.exec = nil
+		return nil
+	}
+	a.exec = &opts
+	return nil
+}
+
+// handleExecStart starts a shell requested by the server, returning an
+// exec_end reply if it can't be started
+func (a *Agent) handleExecStart(msg ControlMessage, out *controlWriter) *ControlMessage {
+	var req execStartRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.ID == "" {
+		return execEndMessage(execEnd{ID: req.ID, Reason: "error", Error: "invalid exec_start request"})
+	}
+
+	if err := a.startShell(req, out); err != nil {
+		a.logger.Errorf("Exec session %s refused: %v", req.ID, err)
+		return execEndMessage(execEnd{ID: req.ID, Reason: "error", Error: err.Error()})
+	}
+	return nil
+}
+
+// handleExecInput passes input or a terminal resize to a running shell
+func (a *Agent) handleExecInput(msg ControlMessage) {
+	var req execInput
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		return
+	}
+
+	a.mu.RLock()
+	session, ok := a.shells[req.ID]
+	a.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if msg.Type == "exec_resize" {
+		if req.Cols > 0 && req.Rows > 0 {
+			if err := setPTYSize(session.pty, req.Cols, req.Rows); err != nil {
+				a.logger.Debugf("Resize exec session %s: %v", req.ID, err)
+			}
+		}
+		return
+	}
+	if len(req.Data) == 0 {
+		return
+	}
+	select {
+	case session.input <- req.Data:
+	case <-session.stop:
+	}
+}
+
+// handleExecStop kills a running shell at the server's request
+func (a *Agent) handleExecStop(msg ControlMessage) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if session, ok := a.shells[req.ID]; ok {
+		close(session.stop)
+		delete(a.shells, req.ID)
+	}
+}
+
+// startShell checks an exec request against the allowed shells and limits
+// and starts the shell on a PTY
+func (a *Agent) startShell(req execStartRequest, out *controlWriter) error {
+	a.mu.RLock()
+	opts := a.exec
+	a.mu.RUnlock()
+
+	if opts == nil {
+		return fmt.Errorf("interactive exec is disabled on this agent")
+	}
+	shell := opts.Shells[0]
+	if req.Shell != "" {
+		shell = filepath.Clean(req.Shell)
+		allowed := false
+		for _, candidate := range opts.Shells {
+			allowed = allowed || candidate == shell
+		}
+		if !allowed {
+			return fmt.Errorf("shell %s is not allowed", req.Shell)
+		}
+	}
+	idleTimeout := opts.IdleTimeout
+	if requested := time.Duration(req.IdleTimeout) * time.Second; requested > 0 && requested < idleTimeout {
+		idleTimeout = requested
+	}
+
+	session := &shellSession{
+		id:    req.ID,
+		shell: shell,
+		input: make(chan []byte, 64),
+		stop:  make(chan struct{}),
+	}
+	a.mu.Lock()
+	if _, ok := a.shells[req.ID]; ok {
+		a.mu.Unlock()
+		return fmt.Errorf("exec session %s is already running", req.ID)
+	}
+	if len(a.shells) >= opts.MaxSessions {
+		a.mu.Unlock()
+		return fmt.Errorf("at most %d exec sessions may run at once", opts.MaxSessions)
+	}
+	if a.shells == nil {
+		a.shells = make(map[string]*shellSession)
+	}
+	a.shells[req.ID] = session
+	a.mu.Unlock()
+
+	pty, cmd, err := startPTY(shell, req.Cols, req.Rows)
+	if err != nil {
+		a.removeShell(session)
+		return fmt.Errorf("start %s: %v", shell, err)
+	}
+	session.pty, session.cmd = pty, cmd
+
+	a.logger.Infof("Started %s for exec session %s (pid %d)", shell, req.ID, cmd.Process.Pid)
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.runShell(session, idleTimeout, out)
+	}()
+	return nil
+}
+
+// runShell streams a shell's output and feeds it input until it exits, is
+// stopped, stays idle too long or the control channel closes, then kills
+// it and reports why it ended
+func (a *Agent) runShell(session *shellSession, idleTimeout time.Duration, out *controlWriter) {
+	defer a.removeShell(session)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- session.cmd.Wait()
+	}()
+
+	// The PTY reads EOF or EIO once the shell and its children have exited
+	output := make(chan []byte)
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, execOutputChunk)
+		for {
+			n, err := session.pty.Read(buf)
+			if n > 0 {
+				chunk := append([]byte(nil), buf[:n]...)
+				select {
+				case output <- chunk:
+				case <-session.stop:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+
+	end := execEnd{ID: session.id}
+	var waitErr error
+	for end.Reason == "" {
+		select {
+		case <-out.done:
+			// Nothing can be sent; the server ends the session when the agent disconnects
+			end.Reason = "disconnected"
+		case <-a.stopChan:
+			end.Reason = "agent stopping"
+		case <-session.stop:
+			end.Reason = "stopped"
+		case <-idle.C:
+			end.Reason = "idle timeout"
+		case waitErr = <-exited:
+			end.Reason = "exited"
+		case data := <-session.input:
+			resetTimer(idle, idleTimeout)
+			if _, err := session.pty.Write(data); err != nil {
+				end.Reason = "error"
+				end.Error = err.Error()
+			}
+		case data := <-output:
+			resetTimer(idle, idleTimeout)
+			msg, _ := json.Marshal(execOutput{ID: session.id, Data: data})
+			if err := out.send(&ControlMessage{Type: "exec_output", Data: msg, Timestamp: time.Now()}); err != nil {
+				end.Reason = "disconnected"
+			}
+		}
+	}
+
+	if end.Reason == "exited" {
+		// Send what the shell printed before exiting
+		for drained := false; !drained; {
+			select {
+			case data := <-output:
+				msg, _ := json.Marshal(execOutput{ID: session.id, Data: data})
+				out.send(&ControlMessage{Type: "exec_output", Data: msg, Timestamp: time.Now()})
+			case <-outputDone:
+				drained = true
+			case <-time.After(time.Second):
+				drained = true
+			}
+		}
+	} else {
+		killPTY(session.cmd)
+		waitErr = <-exited
+	}
+	session.pty.Close()
+
+	if end.Reason == "exited" {
+		code := 0
+		var exitErr *exec.ExitError
+		if errors.As(waitErr, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+		end.ExitCode = &code
+	}
+
+	a.logger.Infof("Exec session %s ended: %s", session.id, end.Reason)
+	if end.Reason != "disconnected" {
+		if err := out.send(execEndMessage(end)); err != nil {
+			a.logger.Debugf("Send exec end: %v", err)
+		}
+	}
+}
+
+// removeShell forgets a shell session that ended
+func (a *Agent) removeShell(session *shellSession) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.shells[session.id] == session {
+		close(session.stop)
+		delete(a.shells, session.id)
+	}
+}
+
+// resetTimer restarts a timer that may have fired
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// execEndMessage wraps an exec_end reply
+func execEndMessage(end execEnd) *ControlMessage {
+	data, _ := json.Marshal(end)
+	return &ControlMessage{Type: "exec_end", Data: data, Timestamp: time.Now()}
+}
@@ -0,0 +1,96 @@
+// Package core provides PTYs for interactive exec sessions on Linux.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptySupported reports whether shells can be started on a PTY here
+const ptySupported = true
+
+// startPTY starts a shell in a new session whose controlling terminal is a
+// new PTY of the given size, and returns the PTY's master side
+func startPTY(shell string, cols, rows int) (*os.File, *exec.Cmd, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open pty: %v", err)
+	}
+
+	// Use the raw descriptor without Fd(), which would make reads blocking
+	// and keep Close from interrupting them
+	var number int
+	err = controlFile(master, func(fd int) error {
+		if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+			return err
+		}
+		number, err = unix.IoctlGetInt(fd, unix.TIOCGPTN)
+		return err
+	})
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlock pty: %v", err)
+	}
+	if err := setPTYSize(master, cols, rows); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("size pty: %v", err)
+	}
+
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("open tty: %v", err)
+	}
+	defer tty.Close()
+
+	cmd := exec.Command(shell)
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Dir = "/"
+	if home, err := os.UserHomeDir(); err == nil {
+		cmd.Dir = home
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, cmd, nil
+}
+
+// setPTYSize sets the terminal size of a PTY
+func setPTYSize(pty *os.File, cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return nil
+	}
+	return controlFile(pty, func(fd int) error {
+		return unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Col: uint16(cols), Row: uint16(rows)})
+	})
+}
+
+// killPTY kills a shell and the processes it started in its session
+func killPTY(cmd *exec.Cmd) {
+	if err := unix.Kill(-cmd.Process.Pid, unix.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// controlFile runs fn on the descriptor of a file
+func controlFile(file *os.File, fn func(fd int) error) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var fnErr error
+	if err := conn.Control(func(fd uintptr) { fnErr = fn(int(fd)) }); err != nil {
+		return err
+	}
+	return fnErr
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+// Package core provides PTYs for interactive exec sessions elsewhere.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// ptySupported reports whether shells can be started on a PTY here
+const ptySupported = false
+
+// startPTY is unsupported outside Linux; SetExec refuses to enable exec
+func startPTY(shell string, cols, rows int) (*os.File, *exec.Cmd, error) {
+	return nil, nil, errors.New("interactive exec is not supported on this platform")
+}
+
+// setPTYSize is unsupported outside Linux
+func setPTYSize(pty *os.File, cols, rows int) error {
+	return errors.New("interactive exec is not supported on this platform")
+}
+
+// killPTY kills a shell
+func killPTY(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
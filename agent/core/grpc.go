@@ -0,0 +1,237 @@
+// Package core provides the optional gRPC transport for agent communication.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	grpcService = "/nerve.v1.AgentService/"
+
+	// grpcReconnectDelay is the wait before reopening a broken task stream
+	grpcReconnectDelay = 5 * time.Second
+)
+
+// grpcJSONCodec matches the server's JSON codec so no generated code is needed
+type grpcJSONCodec struct{}
+
+func (grpcJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (grpcJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (grpcJSONCodec) Name() string {
+	return "json"
+}
+
+// tokenCredentials attaches the current agent token to every call
+type tokenCredentials struct {
+	agent  *Agent
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.agent.authToken()}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+// EnableGRPC switches registration, heartbeats, task delivery and result
+// reporting to the gRPC service at addr. TLS is used when the server URL is https.
+func (a *Agent) EnableGRPC(addr string) error {
+	secure := strings.HasPrefix(a.serverURL, "https://")
+
+	creds := insecure.NewCredentials()
+	if secure {
+		creds = credentials.NewTLS(a.grpcTLSConfig())
+	}
+
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithPerRPCCredentials(tokenCredentials{agent: a, secure: secure}),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(grpcJSONCodec{})),
+	)
+	if err != nil {
+		return fmt.Errorf("create gRPC client: %w", err)
+	}
+
+	a.mu.Lock()
+	a.grpcConn = conn
+	a.mu.Unlock()
+
+	a.logger.Infof("Using gRPC transport: %s", addr)
+	return nil
+}
+
+// stopContext returns a context cancelled when the agent stops
+func (a *Agent) stopContext() (context.Context, context.CancelFunc) {
+	return context.WithCancel(a.ctx)
+}
+
+// registerGRPC registers the agent over gRPC
+func (a *Agent) registerGRPC(info SystemInfo) error {
+	ctx, cancel := context.WithTimeout(a.ctx, a.requestTimeout())
+	defer cancel()
+
+	var resp struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := a.grpcConn.Invoke(ctx, grpcService+"Register", info, &resp); err != nil {
+		return fmt.Errorf("register: %w", err)
+	}
+
+	a.mu.Lock()
+	a.agentID = resp.ID
+	a.registered = true
+	a.mu.Unlock()
+	a.logger.Infof("Registered successfully: ID=%s, Hostname=%s", resp.ID, info.Hostname)
+
+	return nil
+}
+
+// runHeartbeatStream sends heartbeats on a client stream, reopening it after errors
+func (a *Agent) runHeartbeatStream() {
+	ctx, cancel := a.stopContext()
+	defer cancel()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	var stream grpc.ClientStream
+	defer func() {
+		if stream != nil {
+			stream.CloseSend()
+		}
+	}()
+
+	for {
+		select {
+		case <-a.stopChan:
+			return
+		case interval := <-a.intervalChanged:
+			ticker.Reset(interval)
+			continue
+		case <-a.heartbeatNow:
+		case <-ticker.C:
+		}
+
+		a.mu.RLock()
+		registered := a.registered
+		agentID := a.agentID
+		a.mu.RUnlock()
+		if !registered {
+			continue
+		}
+
+		if stream == nil {
+			var err error
+			stream, err = a.grpcConn.NewStream(ctx, &grpc.StreamDesc{StreamName: "Heartbeat", ClientStreams: true}, grpcService+"Heartbeat")
+			if err != nil {
+				a.logger.Errorf("Open heartbeat stream: %v", err)
+				continue
+			}
+		}
+
+		// Heartbeat streams get no per-message reply to request a resync,
+		// so they always carry the full inventory
+		info := a.collectSystemInfo(a.selectedHeartbeatSections())
+		payload := a.heartbeatPayload(&info, inventoryHash(info))
+		payload["agent_id"] = agentID
+		if err := stream.SendMsg(payload); err != nil {
+			a.logger.Errorf("Heartbeat failed: %v", err)
+			stream = nil
+			continue
+		}
+		a.recordHeartbeat()
+		a.logger.Debugf("Heartbeat sent successfully")
+	}
+}
+
+// runTaskStream receives tasks pushed by the server until the agent stops
+func (a *Agent) runTaskStream() {
+	for {
+		if err := a.receiveTasks(); err != nil {
+			a.logger.Errorf("Task stream: %v", err)
+		}
+
+		select {
+		case <-a.stopChan:
+			return
+		case <-time.After(grpcReconnectDelay):
+		}
+	}
+}
+
+// receiveTasks opens a task stream and starts every task received on it
+func (a *Agent) receiveTasks() error {
+	a.mu.RLock()
+	agentID := a.agentID
+	a.mu.RUnlock()
+	if agentID == "" {
+		return fmt.Errorf("agent not registered")
+	}
+
+	ctx, cancel := a.stopContext()
+	defer cancel()
+
+	stream, err := a.grpcConn.NewStream(ctx, &grpc.StreamDesc{StreamName: "Tasks", ServerStreams: true, ClientStreams: true}, grpcService+"Tasks")
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(map[string]string{"agent_id": agentID}); err != nil {
+		return err
+	}
+
+	for {
+		var task Task
+		if err := stream.RecvMsg(&task); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		a.startTask(task)
+	}
+}
+
+// reportTaskResultGRPC reports a task result over gRPC
+func (a *Agent) reportTaskResultGRPC(ctx context.Context, result TaskResult, requestID string) error {
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout())
+	defer cancel()
+	if requestID != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", requestID)
+	}
+
+	var resp struct {
+		Status string `json:"status"`
+	}
+	if err := a.grpcConn.Invoke(ctx, grpcService+"ReportResult", result, &resp); err != nil {
+		if code := status.Code(err); code == codes.NotFound || code == codes.InvalidArgument || code == codes.PermissionDenied {
+			return &rejectedError{err}
+		}
+		return err
+	}
+	a.logger.Infof("Task result reported: %s", result.TaskID)
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package core provides the optional local HTTP endpoint reporting the
+// agent's health and status, for debugging on the host and health checks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/nerve/agent/pkg/log"
+)
+
+const (
+	// HealthRecentErrors is how many recent error lines the status reports
+	HealthRecentErrors = 20
+
+	// healthStaleHeartbeats is how many heartbeat intervals may pass since
+	// the last successful heartbeat before the agent is unhealthy
+	healthStaleHeartbeats = 3
+
+	// healthShutdownTimeout bounds closing the endpoint on Stop
+	healthShutdownTimeout = 5 * time.Second
+)
+
+// HealthStatus is the agent's status reported by the local endpoint
+type HealthStatus struct {
+	Healthy       bool           `json:"healthy"`
+	Reason        string         `json:"reason,omitempty"`
+	AgentID       string         `json:"agent_id"`
+	Registered    bool           `json:"registered"`
+	AgentVersion  string         `json:"agent_version"`
+	Server        string         `json:"server"`
+	Transport     string         `json:"transport"`
+	LastHeartbeat *time.Time     `json:"last_heartbeat,omitempty"`
+	Interval      string         `json:"heartbeat_interval"`
+	Control       *ControlState  `json:"control,omitempty"`
+	Draining      bool           `json:"draining"`
+	Tasks         []HealthTask   `json:"tasks"`
+	Plugins       []HealthPlugin `json:"plugins"`
+	RecentErrors  []string       `json:"recent_errors"`
+}
+
+// HealthTask is a task the agent runs
+type HealthTask struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Plugin string `json:"plugin,omitempty"`
+}
+
+// HealthPlugin is a loaded plugin and whether the server's configuration
+// enables its metrics
+type HealthPlugin struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Enabled bool   `json:"enabled"`
+}
+
+// SetErrorBuffer sets the buffer of recent error lines the status reports
+func (a *Agent) SetErrorBuffer(buffer *log.RingBuffer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.errorBuffer = buffer
+}
+
+// recordHeartbeat notes a heartbeat the server accepted
+func (a *Agent) recordHeartbeat() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lastHeartbeat = time.Now().UTC()
+}
+
+// StartHealthServer serves the agent's health on addr until the agent
+// stops: GET /healthz answers 200 while the agent is healthy and 503
+// otherwise, and GET /status reports the agent's status as JSON. A bare
+// port such as :9101 binds to the loopback interface. The endpoint is
+// unauthenticated, so it should stay on loopback.
+func (a *Agent) StartHealthServer(addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", addr, err)
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		a.logger.Infof("Health endpoint binds to %s, which is not a loopback address; it is reachable without authentication", host)
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", a.serveHealthz)
+	mux.HandleFunc("/status", a.serveStatus)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	a.mu.Lock()
+	a.healthServer = server
+	a.mu.Unlock()
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			a.logger.Errorf("Health endpoint: %v", err)
+		}
+	}()
+	a.logger.Infof("Health endpoint listening on %s", listener.Addr())
+	return nil
+}
+
+// stopHealthServer closes the health endpoint, if started
+func (a *Agent) stopHealthServer() {
+	a.mu.RLock()
+	server := a.healthServer
+	a.mu.RUnlock()
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+	defer cancel()
+	server.Shutdown(ctx)
+}
+
+// serveHealthz answers whether the agent is healthy
+//
+// GET /healthz
+func (a *Agent) serveHealthz(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := a.healthStatus()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "unhealthy: %s\n", status.Reason)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveStatus reports the agent's status
+//
+// GET /status
+func (a *Agent) serveStatus(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := a.healthStatus()
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(status)
+}
+
+// healthStatus collects the agent's status. The agent is healthy while it
+// is registered, not stopping, and its last heartbeat was accepted within
+// healthStaleHeartbeats intervals.
+func (a *Agent) healthStatus() HealthStatus {
+	a.mu.RLock()
+	status := HealthStatus{
+		AgentID:      a.agentID,
+		Registered:   a.registered,
+		AgentVersion: AgentVersion,
+		Server:       a.serverURL,
+		Transport:    "http",
+		Interval:     a.interval.String(),
+		Draining:     a.draining,
+		Tasks:        make([]HealthTask, 0, len(a.running)),
+		Plugins:      []HealthPlugin{},
+		RecentErrors: []string{},
+	}
+	if a.grpcConn != nil {
+		status.Transport = "grpc"
+	}
+	if !a.lastHeartbeat.IsZero() {
+		last := a.lastHeartbeat
+		status.LastHeartbeat = &last
+	}
+	if a.control != nil {
+		control := *a.control
+		status.Control = &control
+	}
+	for _, task := range a.running {
+		status.Tasks = append(status.Tasks, HealthTask{ID: task.ID, Type: task.Type, Plugin: task.Plugin})
+	}
+	interval := a.interval
+	plugins, enabled := a.plugins, a.enabledPlugins
+	errorBuffer := a.errorBuffer
+	secrets := []string{a.token, a.tokenOrigin}
+	a.mu.RUnlock()
+
+	sort.Slice(status.Tasks, func(i, j int) bool { return status.Tasks[i].ID < status.Tasks[j].ID })
+
+	if plugins != nil {
+		for _, plugin := range plugins.ListPlugins() {
+			name, _ := plugin["name"].(string)
+			version, _ := plugin["version"].(string)
+			// Without a server config listing plugins, all contribute metrics
+			on := enabled == nil
+			for _, enabledName := range enabled {
+				on = on || enabledName == name
+			}
+			status.Plugins = append(status.Plugins, HealthPlugin{Name: name, Version: version, Enabled: on})
+		}
+		sort.Slice(status.Plugins, func(i, j int) bool { return status.Plugins[i].Name < status.Plugins[j].Name })
+	}
+
+	if errorBuffer != nil {
+		for _, line := range errorBuffer.Lines(HealthRecentErrors) {
+			status.RecentErrors = append(status.RecentErrors, redactLogLine(line, secrets))
+		}
+	}
+
+	switch {
+	case !status.Registered:
+		status.Reason = "not registered"
+	case status.Draining:
+		status.Reason = "stopping"
+	case status.LastHeartbeat == nil:
+		status.Reason = "no heartbeat accepted yet"
+	case time.Since(*status.LastHeartbeat) > healthStaleHeartbeats*interval:
+		status.Reason = fmt.Sprintf("last heartbeat accepted %s ago", time.Since(*status.LastHeartbeat).Round(time.Second))
+	default:
+		status.Healthy = true
+	}
+	return status
+}
@@ -0,0 +1,82 @@
+// Package core provides selecting the inventory sections sent in heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Heartbeat sections: the usage metrics and the detailed inventory of each
+// kind of hardware. The summary inventory (hostname, SN, CPU type, GPU
+// count, ...) is always sent, as the server matches and lists agents by it.
+const (
+	SectionMetrics = "metrics"
+	SectionCPU     = "cpu"
+	SectionMemory  = "memory"
+	SectionDisk    = "disk"
+	SectionGPU     = "gpu"
+	SectionNetwork = "network"
+)
+
+// heartbeatSectionNames lists the valid heartbeat sections
+var heartbeatSectionNames = []string{SectionMetrics, SectionCPU, SectionMemory, SectionDisk, SectionGPU, SectionNetwork}
+
+// heartbeatSections is a set of heartbeat sections; nil holds all of them
+type heartbeatSections map[string]bool
+
+// includes reports whether a section is in the set
+func (s heartbeatSections) includes(section string) bool {
+	return s == nil || s[section]
+}
+
+// SetHeartbeatSections limits heartbeats to the given sections, e.g.
+// "metrics" and "gpu", so deployments that need only some of them spare the
+// collection and the bandwidth of the rest. Sections left out are neither
+// collected nor sent, and the server keeps what registration reported for
+// them. Without sections, or with "all", heartbeats carry everything.
+// Registration always sends the full inventory.
+func (a *Agent) SetHeartbeatSections(sections []string) error {
+	valid := make(map[string]bool, len(heartbeatSectionNames))
+	for _, name := range heartbeatSectionNames {
+		valid[name] = true
+	}
+
+	var selected heartbeatSections
+	all := false
+	for _, section := range sections {
+		section = strings.ToLower(strings.TrimSpace(section))
+		switch {
+		case section == "":
+			continue
+		case section == "all":
+			all = true
+			continue
+		case !valid[section]:
+			return fmt.Errorf("unknown heartbeat section %q, expected all or some of %s", section, strings.Join(heartbeatSectionNames, ","))
+		}
+		if selected == nil {
+			selected = make(heartbeatSections)
+		}
+		selected[section] = true
+	}
+	if all {
+		selected = nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.heartbeatSections = selected
+	return nil
+}
+
+// selectedHeartbeatSections returns the sections heartbeats carry
+func (a *Agent) selectedHeartbeatSections() heartbeatSections {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.heartbeatSections
+}
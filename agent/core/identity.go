@@ -0,0 +1,118 @@
+// Package core provides choosing the identity the agent registers under:
+// an injected ID, the machine-id, the hardware serial number or the
+// hostname.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nerve/agent/pkg/sysinfo"
+)
+
+// Identity sources
+const (
+	IdentityInjected  = "injected"
+	IdentityMachineID = "machine-id"
+	IdentitySN        = "sn"
+	IdentityHostname  = "hostname"
+)
+
+// AgentIDEnv is the environment variable an ID can be injected with, e.g.
+// by a container orchestrator
+const AgentIDEnv = "NERVE_AGENT_ID"
+
+// maxAgentIDLength caps the identity sent at registration
+const maxAgentIDLength = 128
+
+// DefaultIdentitySources registers under an injected ID if one is given,
+// else the hostname
+var DefaultIdentitySources = []string{IdentityInjected, IdentityHostname}
+
+// SetIdentity sets the identity sources tried in order at registration, and
+// the injected ID, which falls back to $NERVE_AGENT_ID. The hostname is
+// always the last resort. Sources without a value on this machine, such as
+// a placeholder SN, are skipped.
+func (a *Agent) SetIdentity(sources []string, injected string) error {
+	var chain []string
+	seen := make(map[string]bool)
+	for _, source := range sources {
+		source = strings.ToLower(strings.TrimSpace(source))
+		if source == "" || seen[source] {
+			continue
+		}
+		switch source {
+		case IdentityInjected, IdentityMachineID, IdentitySN, IdentityHostname:
+		default:
+			return fmt.Errorf("unknown identity source %q (want %s, %s, %s or %s)",
+				source, IdentityInjected, IdentityMachineID, IdentitySN, IdentityHostname)
+		}
+		seen[source] = true
+		chain = append(chain, source)
+	}
+	if !seen[IdentityHostname] {
+		chain = append(chain, IdentityHostname)
+	}
+
+	if injected = strings.TrimSpace(injected); injected == "" {
+		injected = strings.TrimSpace(os.Getenv(AgentIDEnv))
+	}
+	if injected != "" && sanitizeAgentID(injected) == "" {
+		return fmt.Errorf("injected agent ID %q has no usable characters", injected)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.identitySources = chain
+	a.injectedID = injected
+	return nil
+}
+
+// identity returns the ID to register under and the source it came from.
+// The ID is empty for the hostname, which the server keys agents by anyway.
+func (a *Agent) identity() (string, string) {
+	a.mu.RLock()
+	sources, injected := a.identitySources, a.injectedID
+	a.mu.RUnlock()
+
+	for _, source := range sources {
+		var id string
+		switch source {
+		case IdentityInjected:
+			id = injected
+		case IdentityMachineID:
+			id = sysinfo.MachineID()
+		case IdentitySN:
+			id = sysinfo.UniqueSN()
+		case IdentityHostname:
+			return "", IdentityHostname
+		}
+		if id = sanitizeAgentID(id); id != "" {
+			return id, source
+		}
+		a.logger.Debugf("No %s identity on this machine, trying the next source", source)
+	}
+	return "", IdentityHostname
+}
+
+// sanitizeAgentID makes an identity fit for URL paths: characters other
+// than letters, digits, '.', '-' and '_' become '-', and it mustn't start
+// with '.' or '-'
+func sanitizeAgentID(id string) string {
+	id = strings.Map(func(ch rune) rune {
+		if ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' {
+			return ch
+		}
+		return '-'
+	}, strings.TrimSpace(id))
+	id = strings.TrimLeft(id, ".-")
+	if len(id) > maxAgentIDLength {
+		id = id[:maxAgentIDLength]
+	}
+	return id
+}
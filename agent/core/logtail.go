@@ -0,0 +1,441 @@
+// Package core provides live tailing of local log files over the control channel.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultLogTailRate is the lines per second sent for one tail
+	DefaultLogTailRate = 50
+
+	// DefaultLogTailTimeout is the longest a tail runs
+	DefaultLogTailTimeout = 10 * time.Minute
+
+	// maxLogTails caps the tails running at once
+	maxLogTails = 4
+
+	// maxLogLineBytes caps each line sent; longer lines are truncated
+	maxLogLineBytes = 2048
+
+	// logTailPollInterval is how often a tailed file is checked for new lines
+	logTailPollInterval = 250 * time.Millisecond
+)
+
+// LogTailOptions restricts which files the server may tail and how fast
+type LogTailOptions struct {
+	// Paths are glob patterns of absolute file paths, e.g. /var/log/*.log.
+	// Both the requested path and the file it resolves to must match one.
+	Paths []string
+	// MaxRate caps the lines per second of each tail; excess lines are dropped
+	MaxRate int
+	// MaxDuration caps how long each tail runs
+	MaxDuration time.Duration
+}
+
+// logTailRequest is a log_tail control message from the server
+type logTailRequest struct {
+	ID      string `json:"id"`
+	Path    string `json:"path"`
+	Timeout int    `json:"timeout,omitempty"`
+	MaxRate int    `json:"max_rate,omitempty"`
+}
+
+// logLine is sent for each line of a tailed file. Dropped counts the lines
+// skipped by the rate limit since the previous line.
+type logLine struct {
+	ID      string `json:"id"`
+	Line    string `json:"line"`
+	Dropped int    `json:"dropped,omitempty"`
+}
+
+// logTailEnd is sent once a tail stops
+type logTailEnd struct {
+	ID      string `json:"id"`
+	Reason  string `json:"reason"`
+	Error   string `json:"error,omitempty"`
+	Dropped int    `json:"dropped,omitempty"`
+}
+
+// SetLogTail allows the server to tail files matching opts.Paths; without
+// it log tailing is disabled
+func (a *Agent) SetLogTail(opts LogTailOptions) error {
+	var paths []string
+	for _, pattern := range opts.Paths {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		paths = append(paths, pattern)
+		if !filepath.IsAbs(pattern) {
+			return fmt.Errorf("log tail path %q must be absolute", pattern)
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid log tail path %q: %v", pattern, err)
+		}
+	}
+	opts.Paths = paths
+	if opts.MaxRate <= 0 {
+		opts.MaxRate = DefaultLogTailRate
+	}
+	if opts.MaxDuration <= 0 {
+		opts.MaxDuration = DefaultLogTailTimeout
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if len(opts.Paths) == 0 {
+		a.logTail = nil
+		return nil
+	}
+	a.logTail = &opts
+	return nil
+}
+
+// handleLogTail starts a tail requested by the server, returning a
+// log_tail_end reply if it can't be started
+func (a *Agent) handleLogTail(msg ControlMessage, out *controlWriter) *ControlMessage {
+	var req logTailRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.ID == "" {
+		return logTailEndMessage(logTailEnd{ID: req.ID, Reason: "error", Error: "invalid log_tail request"})
+	}
+
+	if err := a.startLogTail(req, out); err != nil {
+		a.logger.Errorf("Log tail %s of %s refused: %v", req.ID, req.Path, err)
+		return logTailEndMessage(logTailEnd{ID: req.ID, Reason: "error", Error: err.Error()})
+	}
+	return nil
+}
+
+// handleLogTailStop stops a running tail at the server's request
+func (a *Agent) handleLogTailStop(msg ControlMessage) {
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(msg.Data, &req); err != nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if stop, ok := a.tails[req.ID]; ok {
+		close(stop)
+		delete(a.tails, req.ID)
+	}
+}
+
+// startLogTail checks a tail request against the allowed paths and limits
+// and starts streaming the file's new lines
+func (a *Agent) startLogTail(req logTailRequest, out *controlWriter) error {
+	a.mu.RLock()
+	opts := a.logTail
+	a.mu.RUnlock()
+
+	if opts == nil {
+		return fmt.Errorf("log tailing is disabled on this agent")
+	}
+	path, err := resolveTailPath(req.Path, opts.Paths)
+	if err != nil {
+		return err
+	}
+
+	rate := opts.MaxRate
+	if req.MaxRate > 0 && req.MaxRate < rate {
+		rate = req.MaxRate
+	}
+	timeout := opts.MaxDuration
+	if requested := time.Duration(req.Timeout) * time.Second; requested > 0 && requested < timeout {
+		timeout = requested
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open: %v", err)
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("seek: %v", err)
+	}
+
+	stop := make(chan struct{})
+	a.mu.Lock()
+	if err := a.reserveTailLocked(req.ID, stop); err != nil {
+		a.mu.Unlock()
+		file.Close()
+		return err
+	}
+	a.mu.Unlock()
+
+	a.logger.Infof("Tailing %s for %v (tail %s)", path, timeout, req.ID)
+	tail := &fileTail{id: req.ID, path: path, file: file, offset: offset, rate: rate}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.runLogTail(tail, timeout, stop, out)
+	}()
+	return nil
+}
+
+// reserveTailLocked records a starting tail, refusing duplicate IDs and more
+// than maxLogTails at once; caller must hold a.mu
+func (a *Agent) reserveTailLocked(id string, stop chan struct{}) error {
+	if _, ok := a.tails[id]; ok {
+		return fmt.Errorf("log tail %s is already running", id)
+	}
+	if len(a.tails) >= maxLogTails {
+		return fmt.Errorf("at most %d log tails may run at once", maxLogTails)
+	}
+	if a.tails == nil {
+		a.tails = make(map[string]chan struct{})
+	}
+	a.tails[id] = stop
+	return nil
+}
+
+// resolveTailPath returns the file a requested path resolves to if both
+// match an allowed pattern, so symlinks can't escape the allowed paths
+func resolveTailPath(path string, patterns []string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path must be absolute")
+	}
+	path = filepath.Clean(path)
+	if !matchAny(path, patterns) {
+		return "", fmt.Errorf("path %s is not allowed", path)
+	}
+
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("resolve: %v", err)
+	}
+	if !matchAny(resolved, patterns) {
+		return "", fmt.Errorf("path %s resolves outside the allowed paths", path)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", fmt.Errorf("stat: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("path %s is not a regular file", path)
+	}
+	return resolved, nil
+}
+
+// matchAny reports whether path matches one of the glob patterns
+func matchAny(path string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runLogTail sends new lines of a file until the tail is stopped, times out
+// or the control channel closes, then reports why it ended
+func (a *Agent) runLogTail(tail *fileTail, timeout time.Duration, stop chan struct{}, out *controlWriter) {
+	defer func() {
+		tail.close()
+		a.mu.Lock()
+		if a.tails[tail.id] == stop {
+			delete(a.tails, tail.id)
+		}
+		a.mu.Unlock()
+	}()
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(logTailPollInterval)
+	defer ticker.Stop()
+
+	end := logTailEnd{ID: tail.id}
+	for end.Reason == "" {
+		select {
+		case <-out.done:
+			// Nothing can be sent; the server ends the tail when the agent disconnects
+			return
+		case <-a.stopChan:
+			end.Reason = "agent stopping"
+		case <-stop:
+			end.Reason = "stopped"
+		case <-deadline.C:
+			end.Reason = "timeout"
+		case now := <-ticker.C:
+			err := tail.poll(now, func(line logLine) error {
+				data, _ := json.Marshal(line)
+				return out.send(&ControlMessage{Type: "log_line", Data: data, Timestamp: time.Now()})
+			})
+			if err != nil {
+				end.Reason = "error"
+				end.Error = err.Error()
+			}
+		}
+	}
+
+	end.Dropped = tail.dropped
+	a.logger.Infof("Log tail %s of %s ended: %s", tail.id, tail.path, end.Reason)
+	if err := out.send(logTailEndMessage(end)); err != nil {
+		a.logger.Debugf("Send log tail end: %v", err)
+	}
+}
+
+// logTailEndMessage wraps a log_tail_end reply
+func logTailEndMessage(end logTailEnd) *ControlMessage {
+	data, _ := json.Marshal(end)
+	return &ControlMessage{Type: "log_tail_end", Data: data, Timestamp: time.Now()}
+}
+
+// fileTail follows a file across truncation and rotation, rate limiting the
+// lines it emits
+type fileTail struct {
+	id     string
+	path   string
+	file   *os.File
+	reader *bufio.Reader
+	offset int64
+
+	// partial holds a line read up to the end of the file but not yet terminated
+	partial   []byte
+	truncated bool
+
+	// Token bucket of lines that may be sent, refilled at rate per second
+	rate    int
+	tokens  float64
+	refill  time.Time
+	dropped int
+	pending int
+}
+
+// poll emits the lines appended since the last poll. A file replaced by
+// rotation is read to its end before the new file is opened, and a
+// truncated file is read from its start.
+func (t *fileTail) poll(now time.Time, emit func(logLine) error) error {
+	if err := t.read(now, emit); err != nil {
+		return err
+	}
+
+	rotated, err := t.follow()
+	if err != nil || !rotated {
+		return err
+	}
+	// A line the old file never terminated is sent as is
+	if len(t.partial) > 0 {
+		if err := t.send(now, string(t.partial), emit); err != nil {
+			return err
+		}
+	}
+	t.reset()
+	return t.read(now, emit)
+}
+
+// read emits the complete lines up to the end of the file
+func (t *fileTail) read(now time.Time, emit func(logLine) error) error {
+	if t.reader == nil {
+		t.reader = bufio.NewReaderSize(t.file, maxLogLineBytes)
+	}
+
+	for {
+		chunk, err := t.reader.ReadSlice('\n')
+		t.offset += int64(len(chunk))
+		if room := maxLogLineBytes - len(t.partial); len(chunk) > room {
+			t.partial = append(t.partial, chunk[:room]...)
+			t.truncated = true
+		} else {
+			t.partial = append(t.partial, chunk...)
+		}
+
+		switch err {
+		case nil:
+			line := string(t.partial)
+			if !t.truncated {
+				line = strings.TrimRight(line, "\r\n")
+			}
+			t.partial = t.partial[:0]
+			t.truncated = false
+			if err := t.send(now, line, emit); err != nil {
+				return err
+			}
+		case bufio.ErrBufferFull:
+		case io.EOF:
+			return nil
+		default:
+			return fmt.Errorf("read: %v", err)
+		}
+	}
+}
+
+// send emits a line if the rate limit allows, counting it as dropped otherwise
+func (t *fileTail) send(now time.Time, line string, emit func(logLine) error) error {
+	if t.refill.IsZero() {
+		t.tokens = float64(t.rate)
+	} else if t.tokens += now.Sub(t.refill).Seconds() * float64(t.rate); t.tokens > float64(t.rate) {
+		t.tokens = float64(t.rate)
+	}
+	t.refill = now
+
+	if t.tokens < 1 {
+		t.dropped++
+		t.pending++
+		return nil
+	}
+	t.tokens--
+
+	err := emit(logLine{ID: t.id, Line: line, Dropped: t.pending})
+	t.pending = 0
+	return err
+}
+
+// follow reopens the file if it was rotated, reporting whether it was, and
+// rewinds it if it was truncated
+func (t *fileTail) follow() (bool, error) {
+	current, err := os.Stat(t.path)
+	if err != nil {
+		// Between rotation and the new file being created
+		return false, nil
+	}
+	opened, err := t.file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("stat: %v", err)
+	}
+
+	if !os.SameFile(current, opened) {
+		file, err := os.Open(t.path)
+		if err != nil {
+			return false, nil
+		}
+		t.file.Close()
+		t.file = file
+		return true, nil
+	}
+	if current.Size() < t.offset {
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return false, fmt.Errorf("seek: %v", err)
+		}
+		t.reset()
+	}
+	return false, nil
+}
+
+// reset starts reading the file from its current position as a new file
+func (t *fileTail) reset() {
+	t.offset = 0
+	t.reader = nil
+	t.partial = t.partial[:0]
+	t.truncated = false
+}
+
+// close closes the tailed file
+func (t *fileTail) close() {
+	t.file.Close()
+}
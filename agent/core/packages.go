@@ -0,0 +1,65 @@
+// Package core provides the kernel and package inventory the agent reports
+// at registration.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/nerve/agent/pkg/sysinfo"
+)
+
+// MaxReportedPackages caps the packages sent at registration; the server
+// rejects registrations listing more
+const MaxReportedPackages = 5000
+
+// SetPackageInventory reports the installed packages whose names match one
+// of the glob patterns at registration, e.g. "openssl*" or "*" for all.
+// Without patterns no packages are reported.
+func (a *Agent) SetPackageInventory(patterns []string) error {
+	var cleaned []string
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern == "" {
+			continue
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid package pattern %q: %v", pattern, err)
+		}
+		cleaned = append(cleaned, pattern)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.packagePatterns = cleaned
+	return nil
+}
+
+// addKernelInventory adds the kernel and, if enabled, the matching packages
+// to a registration. Hosts without dpkg or rpm report no packages.
+func (a *Agent) addKernelInventory(info *SystemInfo) {
+	info.Kernel = sysinfo.KernelInfo()
+
+	a.mu.RLock()
+	patterns := a.packagePatterns
+	a.mu.RUnlock()
+	if len(patterns) == 0 {
+		return
+	}
+
+	manager, packages, err := sysinfo.Packages(patterns)
+	if err != nil {
+		a.logger.Errorf("Failed to list installed packages with %s: %v", manager, err)
+		return
+	}
+	if len(packages) > MaxReportedPackages {
+		a.logger.Errorf("%d packages match --packages, reporting the first %d", len(packages), MaxReportedPackages)
+		packages = packages[:MaxReportedPackages]
+	}
+	info.PackageManager = manager
+	info.Packages = packages
+}
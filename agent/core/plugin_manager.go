@@ -25,6 +25,9 @@ type PluginManager struct {
 	plugins map[string]HookPlugin
 	mutex   sync.RWMutex
 	path    string
+
+	// Plugins whose CollectMetrics call hasn't returned, see CollectMetrics
+	collecting map[string]bool
 }
 
 // NewPluginManager creates a new plugin manager
@@ -32,6 +35,8 @@ func NewPluginManager(pluginPath string) *PluginManager {
 	return &PluginManager{
 		plugins: make(map[string]HookPlugin),
 		path:    pluginPath,
+
+		collecting: make(map[string]bool),
 	}
 }
 
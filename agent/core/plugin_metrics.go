@@ -0,0 +1,132 @@
+// Package core provides custom heartbeat metrics contributed by plugins.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultPluginMetricsTimeout bounds how long a heartbeat waits for plugin metrics
+const DefaultPluginMetricsTimeout = 5 * time.Second
+
+// MetricsPlugin is optionally implemented by a HookPlugin to contribute
+// metrics to every heartbeat, e.g. application health
+type MetricsPlugin interface {
+	CollectMetrics() map[string]interface{}
+}
+
+// metricsResult is the outcome of one plugin's CollectMetrics call
+type metricsResult struct {
+	name    string
+	metrics map[string]interface{}
+	err     error
+}
+
+// CollectMetrics calls CollectMetrics on every loaded plugin that implements
+// MetricsPlugin and returns the results keyed by plugin name. A plugin that
+// panics, returns values that can't be encoded as JSON or doesn't answer
+// within timeout is left out and reported in the returned errors; a plugin
+// still stuck in an earlier call is skipped rather than called again.
+func (pm *PluginManager) CollectMetrics(timeout time.Duration) (map[string]interface{}, []error) {
+	pm.mutex.Lock()
+	plugins := make(map[string]MetricsPlugin)
+	for name, plugin := range pm.plugins {
+		if collector, ok := plugin.(MetricsPlugin); ok && !pm.collecting[name] {
+			pm.collecting[name] = true
+			plugins[name] = collector
+		}
+	}
+	pm.mutex.Unlock()
+
+	if len(plugins) == 0 {
+		return nil, nil
+	}
+
+	// Buffered so plugins that answer after the timeout don't block
+	results := make(chan metricsResult, len(plugins))
+	for name, plugin := range plugins {
+		go pm.collectPluginMetrics(name, plugin, results)
+	}
+
+	custom := make(map[string]interface{})
+	var errs []error
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for pending := len(plugins); pending > 0; pending-- {
+		select {
+		case result := <-results:
+			delete(plugins, result.name)
+			if result.err != nil {
+				errs = append(errs, result.err)
+			} else if len(result.metrics) > 0 {
+				custom[result.name] = result.metrics
+			}
+		case <-deadline.C:
+			var names []string
+			for name := range plugins {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				errs = append(errs, fmt.Errorf("plugin %s: metrics timed out after %v", name, timeout))
+			}
+			return custom, errs
+		}
+	}
+	return custom, errs
+}
+
+// collectPluginMetrics runs one plugin's CollectMetrics, recovering from panics
+func (pm *PluginManager) collectPluginMetrics(name string, plugin MetricsPlugin, results chan<- metricsResult) {
+	result := metricsResult{name: name}
+	defer func() {
+		if r := recover(); r != nil {
+			result.metrics = nil
+			result.err = fmt.Errorf("plugin %s: metrics panicked: %v", name, r)
+		}
+
+		pm.mutex.Lock()
+		delete(pm.collecting, name)
+		pm.mutex.Unlock()
+
+		results <- result
+	}()
+
+	result.metrics = plugin.CollectMetrics()
+	if _, err := json.Marshal(result.metrics); err != nil {
+		result.metrics = nil
+		result.err = fmt.Errorf("plugin %s: invalid metrics: %v", name, err)
+	}
+}
+
+// SetPluginManager sets the plugins whose metrics are sent in heartbeats
+// under "custom"
+func (a *Agent) SetPluginManager(pm *PluginManager) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.plugins = pm
+}
+
+// customMetrics collects plugin metrics for a heartbeat, logging failing plugins
+func (a *Agent) customMetrics() map[string]interface{} {
+	a.mu.RLock()
+	pm := a.plugins
+	a.mu.RUnlock()
+
+	if pm == nil {
+		return nil
+	}
+
+	custom, errs := pm.CollectMetrics(DefaultPluginMetricsTimeout)
+	for _, err := range errs {
+		a.logger.Errorf("Custom metrics: %v", err)
+	}
+	return custom
+}
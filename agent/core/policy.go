@@ -0,0 +1,238 @@
+// Package core provides the agent-side policy for commands pushed by the server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Command policy modes
+const (
+	// PolicyModeOpen runs any command or script not matching the denylist
+	PolicyModeOpen = "open"
+	// PolicyModeAllowlist runs only commands starting with an allowed prefix
+	PolicyModeAllowlist = "allowlist"
+	// PolicyModeDisabled refuses all command and script tasks; hooks still run
+	PolicyModeDisabled = "disabled"
+)
+
+// DefaultDenylist is used when a policy doesn't list its own deny prefixes
+var DefaultDenylist = []string{
+	"rm -rf /",
+	"rm -fr /",
+	"mkfs",
+	"wipefs",
+	"shutdown",
+	"reboot",
+	"halt",
+	"poweroff",
+}
+
+// shellOperators separate the commands of a shell line; each part is
+// checked against the policy on its own
+var shellOperators = []string{"&&", "||", ";", "&", "|", "\n", "$(", "`", "(", ")"}
+
+// CommandPolicy constrains which command and script tasks the agent runs.
+// Deny always wins over Allow.
+type CommandPolicy struct {
+	Mode  string   `json:"mode"`
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// NewCommandPolicy returns a policy in the given mode with the default denylist
+func NewCommandPolicy(mode string) (*CommandPolicy, error) {
+	policy := &CommandPolicy{Mode: mode}
+	if err := policy.normalize(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// LoadCommandPolicy reads a JSON command policy from file
+func LoadCommandPolicy(file string) (*CommandPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command policy: %v", err)
+	}
+
+	var policy CommandPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse command policy: %v", err)
+	}
+	if err := policy.normalize(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// normalize validates the mode and cleans up the prefix lists
+func (p *CommandPolicy) normalize() error {
+	if p.Mode == "" {
+		p.Mode = PolicyModeOpen
+	}
+	switch p.Mode {
+	case PolicyModeOpen, PolicyModeAllowlist, PolicyModeDisabled:
+	default:
+		return fmt.Errorf("invalid command policy mode %q, must be %q, %q or %q",
+			p.Mode, PolicyModeOpen, PolicyModeAllowlist, PolicyModeDisabled)
+	}
+	if p.Mode == PolicyModeAllowlist && len(p.Allow) == 0 {
+		return fmt.Errorf("command policy mode %q needs at least one allow prefix", PolicyModeAllowlist)
+	}
+
+	if p.Deny == nil {
+		p.Deny = DefaultDenylist
+	}
+	p.Allow = normalizePrefixes(p.Allow)
+	p.Deny = normalizePrefixes(p.Deny)
+	return nil
+}
+
+// Check returns an error explaining why a task may not run, or nil. Only
+// command and script tasks are constrained.
+func (p *CommandPolicy) Check(task Task) error {
+	if p == nil {
+		return nil
+	}
+
+	var lines []string
+	switch task.Type {
+	case "command":
+		lines = []string{task.Command}
+	case "script":
+		lines = scriptLines(task.Script)
+	default:
+		return nil
+	}
+
+	if p.Mode == PolicyModeDisabled {
+		return fmt.Errorf("%s tasks are disabled by the agent command policy", task.Type)
+	}
+	for _, line := range lines {
+		if err := p.checkLine(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkLine checks every command of a shell line
+func (p *CommandPolicy) checkLine(line string) error {
+	for _, command := range splitCommands(line) {
+		if prefix, ok := matchPrefix(denyForm(command), p.Deny); ok {
+			return fmt.Errorf("command %q is denied by the agent command policy (matches %q)", command, prefix)
+		}
+		if p.Mode != PolicyModeAllowlist {
+			continue
+		}
+		// Redirections and expansions could smuggle in work an allowed prefix doesn't cover
+		if strings.ContainsAny(command, "<>$\\") {
+			return fmt.Errorf("command %q uses redirection or expansion, not allowed by the agent command policy", command)
+		}
+		if _, ok := matchPrefix(command, p.Allow); !ok {
+			return fmt.Errorf("command %q is not in the agent command policy allowlist", command)
+		}
+	}
+	return nil
+}
+
+// scriptLines returns the lines of a script that hold commands
+func scriptLines(script string) []string {
+	var lines []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// splitCommands splits a shell line at its operators into single commands
+// with whitespace collapsed
+func splitCommands(line string) []string {
+	parts := []string{line}
+	for _, operator := range shellOperators {
+		var split []string
+		for _, part := range parts {
+			split = append(split, strings.Split(part, operator)...)
+		}
+		parts = split
+	}
+
+	var commands []string
+	for _, part := range parts {
+		if command := strings.Join(strings.Fields(part), " "); command != "" {
+			commands = append(commands, command)
+		}
+	}
+	return commands
+}
+
+// denyForm strips what commonly disguises a denied command: a leading sudo
+// and the directory of the program, so "sudo /sbin/mkfs" reads as "mkfs"
+func denyForm(command string) string {
+	fields := strings.Fields(command)
+	if len(fields) > 1 && fields[0] == "sudo" {
+		fields = fields[1:]
+	}
+	fields[0] = path.Base(fields[0])
+	return strings.Join(fields, " ")
+}
+
+// normalizePrefixes collapses whitespace in prefixes and drops empty ones
+func normalizePrefixes(prefixes []string) []string {
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		if prefix = strings.Join(strings.Fields(prefix), " "); prefix != "" {
+			normalized = append(normalized, prefix)
+		}
+	}
+	return normalized
+}
+
+// matchPrefix returns the first prefix command starts with on a word
+// boundary, so "ls" matches "ls -l" but not "lsblk", and "mkfs" matches
+// "mkfs.ext4"
+func matchPrefix(command string, prefixes []string) (string, bool) {
+	for _, prefix := range prefixes {
+		if !strings.HasPrefix(command, prefix) {
+			continue
+		}
+		if len(command) == len(prefix) || !isWordChar(prefix[len(prefix)-1]) || !isWordChar(command[len(prefix)]) {
+			return prefix, true
+		}
+	}
+	return "", false
+}
+
+// isWordChar reports whether c continues a command or argument name
+func isWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// SetCommandPolicy sets the policy command and script tasks are checked
+// against before they run; nil runs every task
+func (a *Agent) SetCommandPolicy(policy *CommandPolicy) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.commandPolicy = policy
+}
+
+// checkCommandPolicy checks a task against the agent's command policy
+func (a *Agent) checkCommandPolicy(task Task) error {
+	a.mu.RLock()
+	policy := a.commandPolicy
+	a.mu.RUnlock()
+
+	return policy.Check(task)
+}
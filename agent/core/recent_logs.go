@@ -0,0 +1,112 @@
+// Package core provides the agent's recent log lines to the server on
+// request, with credentials redacted.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nerve/agent/pkg/log"
+)
+
+const (
+	// DefaultLogBufferLines is how many recent log lines are kept for the server
+	DefaultLogBufferLines = 500
+
+	// recentLogsChunk caps the encoded lines sent per message, so messages
+	// stay under the server's size limit
+	recentLogsChunk = 12 << 10
+
+	// redacted replaces credentials in returned log lines
+	redacted = "[REDACTED]"
+)
+
+// Credentials are redacted from log lines before they leave the agent: bearer
+// tokens, values of secret-looking keys and passwords in URLs
+var (
+	bearerPattern  = regexp.MustCompile(`(?i)(bearer\s+)[^\s"',]+`)
+	secretPattern  = regexp.MustCompile(`(?i)((?:token|password|passwd|secret|api[_-]?key|authorization|private[_-]?key)["']?\s*[:=]\s*["']?)[^\s"'&,;]+`)
+	urlUserPattern = regexp.MustCompile(`(://[^/\s:@]+:)[^/\s@]+@`)
+)
+
+// recentLogsRequest is a recent_logs control message from the server
+type recentLogsRequest struct {
+	ID    string `json:"id"`
+	Lines int    `json:"lines"`
+}
+
+// recentLogsResult carries a chunk of the requested lines; the last chunk
+// is marked done
+type recentLogsResult struct {
+	ID    string   `json:"id"`
+	Lines []string `json:"lines"`
+	Done  bool     `json:"done"`
+	Error string   `json:"error,omitempty"`
+}
+
+// SetLogBuffer lets the server fetch the recent lines of the agent's log
+// kept by buffer; without it the server can't
+func (a *Agent) SetLogBuffer(buffer *log.RingBuffer) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.logBuffer = buffer
+}
+
+// handleRecentLogs sends the requested number of recent log lines, redacted,
+// in chunks small enough for the server to accept
+func (a *Agent) handleRecentLogs(msg ControlMessage, out *controlWriter) *ControlMessage {
+	var req recentLogsRequest
+	if err := json.Unmarshal(msg.Data, &req); err != nil || req.ID == "" {
+		return recentLogsMessage(recentLogsResult{ID: req.ID, Done: true, Error: "invalid recent_logs request"})
+	}
+
+	a.mu.RLock()
+	buffer := a.logBuffer
+	secrets := []string{a.token, a.tokenOrigin}
+	a.mu.RUnlock()
+	if buffer == nil {
+		return recentLogsMessage(recentLogsResult{ID: req.ID, Done: true, Error: "log buffer is disabled on this agent"})
+	}
+
+	chunk := recentLogsResult{ID: req.ID, Lines: []string{}}
+	size := 0
+	for _, line := range buffer.Lines(req.Lines) {
+		line = redactLogLine(line, secrets)
+		encoded, _ := json.Marshal(line)
+		if size+len(encoded) > recentLogsChunk && len(chunk.Lines) > 0 {
+			if err := out.send(recentLogsMessage(chunk)); err != nil {
+				return nil
+			}
+			chunk.Lines, size = []string{}, 0
+		}
+		chunk.Lines = append(chunk.Lines, line)
+		size += len(encoded) + 1
+	}
+	chunk.Done = true
+	return recentLogsMessage(chunk)
+}
+
+// redactLogLine removes credentials from a log line, including the agent's
+// own tokens wherever they appear
+func redactLogLine(line string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			line = strings.ReplaceAll(line, secret, redacted)
+		}
+	}
+	line = bearerPattern.ReplaceAllString(line, "${1}"+redacted)
+	line = secretPattern.ReplaceAllString(line, "${1}"+redacted)
+	return urlUserPattern.ReplaceAllString(line, "${1}"+redacted+"@")
+}
+
+// recentLogsMessage wraps a recent_logs_result reply
+func recentLogsMessage(result recentLogsResult) *ControlMessage {
+	data, _ := json.Marshal(result)
+	return &ControlMessage{Type: "recent_logs_result", Data: data, Timestamp: time.Now()}
+}
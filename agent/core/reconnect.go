@@ -0,0 +1,149 @@
+// Package core provides the reconnection of the control channel with
+// exponential backoff, and the channel state reported in heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+const (
+	// DefaultControlMaxBackoff caps the wait between control channel dials
+	DefaultControlMaxBackoff = 2 * time.Minute
+
+	// controlMinBackoff is the wait before the first redial
+	controlMinBackoff = time.Second
+
+	// controlStableAfter is how long a channel must stay up for the backoff
+	// to start over, so a server dropping each connection at once is not
+	// redialed at the shortest wait
+	controlStableAfter = time.Minute
+
+	// maxControlErrorLength caps the last error reported in heartbeats
+	maxControlErrorLength = 200
+)
+
+// Control channel states reported in heartbeats; connecting until the
+// channel first opens
+const (
+	ControlConnecting   = "connecting"
+	ControlConnected    = "connected"
+	ControlDisconnected = "disconnected"
+)
+
+// ControlState describes the control channel in heartbeats
+type ControlState struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	// Reconnects counts the times the channel was reestablished
+	Reconnects int `json:"reconnects"`
+	// LastError is why the channel last broke or failed to open
+	LastError string `json:"last_error,omitempty"`
+}
+
+// controlHello announces the agent on each control channel connection, so a
+// server that restarted or took over the agent knows what it supports
+type controlHello struct {
+	Capabilities []string `json:"capabilities"`
+	AgentVersion string   `json:"agent_version"`
+}
+
+// SetControlMaxBackoff caps the wait between control channel redials,
+// which doubles from a second after each failed dial
+func (a *Agent) SetControlMaxBackoff(max time.Duration) error {
+	if max < controlMinBackoff {
+		return fmt.Errorf("control channel max backoff must be at least %v", controlMinBackoff)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.controlMaxBackoff = max
+	return nil
+}
+
+// controlBackoff returns the wait before the next redial, given the number
+// of failures in a row: doubling from controlMinBackoff up to the max
+// backoff, half of it random so agents cut off together don't redial together
+func (a *Agent) controlBackoff(failures int) time.Duration {
+	a.mu.RLock()
+	max := a.controlMaxBackoff
+	a.mu.RUnlock()
+	if max <= 0 {
+		max = DefaultControlMaxBackoff
+	}
+
+	backoff := controlMinBackoff
+	for i := 1; i < failures && backoff < max; i++ {
+		backoff *= 2
+	}
+	if backoff > max {
+		backoff = max
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// setControlState records a change of the control channel state
+func (a *Agent) setControlState(state string, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.control == nil {
+		a.control = &ControlState{}
+	}
+	if state == ControlDisconnected && a.control.State == ControlConnecting {
+		// Never connected yet; keep saying so
+		state = ControlConnecting
+	}
+	if state == ControlConnected && a.control.State == ControlDisconnected {
+		a.control.Reconnects++
+	}
+	if err != nil {
+		a.control.LastError = err.Error()
+		if len(a.control.LastError) > maxControlErrorLength {
+			a.control.LastError = a.control.LastError[:maxControlErrorLength]
+		}
+	}
+	if a.control.State != state {
+		a.control.State = state
+		a.control.Since = time.Now().UTC()
+	}
+}
+
+// controlState returns the control channel state, or nil if the channel
+// was never started
+func (a *Agent) controlState() *ControlState {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.control == nil {
+		return nil
+	}
+	state := *a.control
+	return &state
+}
+
+// helloMessage announces the agent's capabilities on a new connection
+func (a *Agent) helloMessage(agentID string) *ControlMessage {
+	data, _ := json.Marshal(controlHello{Capabilities: a.capabilities(), AgentVersion: AgentVersion})
+	return &ControlMessage{Type: "hello", AgentID: agentID, Data: data, Timestamp: time.Now()}
+}
+
+// handleReregister registers again at the request of a server that doesn't
+// know the agent, e.g. one that restarted without persistent storage
+func (a *Agent) handleReregister() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		a.logger.Infof("Server does not know this agent, registering again")
+		if err := a.Register(); err != nil {
+			a.logger.Errorf("Registration failed: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,22 @@
+// Package core provides refreshing the inventory on request of the server,
+// instead of waiting for the next heartbeat.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import "github.com/nerve/agent/pkg/sysinfo"
+
+// handleRefresh drops cached readings and the inventory hash the server
+// holds, and has the heartbeat loop send a heartbeat with the full
+// inventory right away. Refreshes requested while one is pending are merged.
+func (a *Agent) handleRefresh() {
+	a.logger.Infof("Server requested an inventory refresh")
+	sysinfo.Refresh()
+	a.setInventoryHash("")
+
+	select {
+	case a.heartbeatNow <- struct{}{}:
+	default:
+	}
+}
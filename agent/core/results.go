@@ -0,0 +1,275 @@
+// Package core provides the on-disk queue of task results awaiting delivery.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultResultQueueSize caps the results kept while the server is unreachable
+	DefaultResultQueueSize = 1000
+
+	// resultRetryMin and resultRetryMax bound the backoff between delivery attempts
+	resultRetryMin = 5 * time.Second
+	resultRetryMax = 5 * time.Minute
+)
+
+// queuedResult is a task result waiting to be acknowledged by the server
+type queuedResult struct {
+	Result    TaskResult `json:"result"`
+	RequestID string     `json:"request_id,omitempty"`
+	QueuedAt  time.Time  `json:"queued_at"`
+}
+
+// rejectedError marks a result the server refused for good, e.g. because it
+// no longer knows the task; retrying it can't succeed
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string {
+	return e.err.Error()
+}
+
+// resultQueue holds undelivered results in order, persisted to file if set
+// so they survive an agent restart
+type resultQueue struct {
+	mu    sync.Mutex
+	file  string
+	size  int
+	items []queuedResult
+
+	// wake is signalled when a result is queued
+	wake chan struct{}
+}
+
+// newResultQueue creates a queue of at most size results, loading results
+// left in file by a previous run
+func newResultQueue(file string, size int) (*resultQueue, error) {
+	if size <= 0 {
+		size = DefaultResultQueueSize
+	}
+	q := &resultQueue{
+		file: file,
+		size: size,
+		wake: make(chan struct{}, 1),
+	}
+	if file == "" {
+		return q, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create result queue directory: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read result queue: %v", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &q.items); err != nil {
+			return nil, fmt.Errorf("failed to parse result queue: %v", err)
+		}
+	}
+	if len(q.items) > 0 {
+		q.wake <- struct{}{}
+	}
+	return q, nil
+}
+
+// push queues a result and returns how many of the oldest results were
+// dropped to stay within the size cap
+func (q *resultQueue) push(item queuedResult) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.items = append(q.items, item)
+	dropped := 0
+	if len(q.items) > q.size {
+		dropped = len(q.items) - q.size
+		q.items = append([]queuedResult(nil), q.items[dropped:]...)
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return dropped, q.saveLocked()
+}
+
+// peek returns the oldest queued result
+func (q *resultQueue) peek() (queuedResult, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return queuedResult{}, false
+	}
+	return q.items[0], true
+}
+
+// remove drops a delivered result. It is matched by task and queue time, as
+// it may have been dropped for space while it was being delivered.
+func (q *resultQueue) remove(item queuedResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.items {
+		if queued.Result.TaskID == item.Result.TaskID && queued.QueuedAt.Equal(item.QueuedAt) {
+			q.items = append(q.items[:i:i], q.items[i+1:]...)
+			return q.saveLocked()
+		}
+	}
+	return nil
+}
+
+// taskIDs returns the tasks of the queued results
+func (q *resultQueue) taskIDs() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	ids := make([]string, 0, len(q.items))
+	for _, item := range q.items {
+		ids = append(ids, item.Result.TaskID)
+	}
+	return ids
+}
+
+// len returns the number of queued results
+func (q *resultQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.items)
+}
+
+// saveLocked writes the queue to its file via a temporary file, so a crash
+// never leaves it half written; caller must hold q.mu
+func (q *resultQueue) saveLocked() error {
+	if q.file == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(q.items)
+	if err != nil {
+		return fmt.Errorf("failed to marshal result queue: %v", err)
+	}
+	tmp := q.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write result queue: %v", err)
+	}
+	if err := os.Rename(tmp, q.file); err != nil {
+		return fmt.Errorf("failed to write result queue: %v", err)
+	}
+	return nil
+}
+
+// SetResultQueue keeps undelivered task results in file, holding at most
+// size of them; results left by a previous run are delivered first. Without
+// it results are retried in memory only.
+func (a *Agent) SetResultQueue(file string, size int) error {
+	queue, err := newResultQueue(file, size)
+	if err != nil {
+		return err
+	}
+	if n := queue.len(); n > 0 {
+		a.logger.Infof("Loaded %d undelivered task result(s) from %s", n, file)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.results = queue
+	return nil
+}
+
+// reportTaskResult queues a task result for delivery, echoing the request ID
+// of the task so the server can correlate it end-to-end. Results are retried
+// with backoff until the server acknowledges them.
+func (a *Agent) reportTaskResult(result TaskResult, requestID string) {
+	a.mu.RLock()
+	queue := a.results
+	a.mu.RUnlock()
+
+	dropped, err := queue.push(queuedResult{Result: result, RequestID: requestID, QueuedAt: time.Now()})
+	if err != nil {
+		a.logger.Errorf("Persist result %s: %v", result.TaskID, err)
+	}
+	if dropped > 0 {
+		a.logger.Errorf("Result queue full, dropped %d oldest undelivered result(s)", dropped)
+	}
+}
+
+// startResultDelivery delivers queued results until the agent stops,
+// backing off while the server is unreachable
+func (a *Agent) startResultDelivery() {
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		a.mu.RLock()
+		queue := a.results
+		a.mu.RUnlock()
+
+		backoff := resultRetryMin
+		for {
+			if a.flushResults(a.ctx) {
+				backoff = resultRetryMin
+				select {
+				case <-a.stopChan:
+					return
+				case <-queue.wake:
+				}
+				continue
+			}
+
+			a.logger.Infof("%d task result(s) pending delivery, retrying in %v", queue.len(), backoff)
+			select {
+			case <-a.stopChan:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > resultRetryMax {
+				backoff = resultRetryMax
+			}
+		}
+	}()
+}
+
+// flushResults delivers queued results in order and reports whether the
+// queue was emptied
+func (a *Agent) flushResults(ctx context.Context) bool {
+	a.mu.RLock()
+	queue := a.results
+	a.mu.RUnlock()
+
+	for {
+		item, ok := queue.peek()
+		if !ok {
+			return true
+		}
+
+		err := a.deliverResult(ctx, item.Result, item.RequestID)
+		if _, rejected := err.(*rejectedError); rejected {
+			a.logger.Errorf("Result %s rejected, not retrying: %v", item.Result.TaskID, err)
+		} else if err != nil {
+			a.logger.Errorf("Report result %s: %v", item.Result.TaskID, err)
+			return false
+		}
+
+		if err := queue.remove(item); err != nil {
+			a.logger.Errorf("Persist result queue: %v", err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Package core provides sampling metrics between heartbeats, so heartbeats
+// report their spread over the interval instead of a single reading.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nerve/agent/pkg/sysinfo"
+)
+
+const (
+	// DefaultSampleInterval is how often metrics are sampled between heartbeats
+	DefaultSampleInterval = 5 * time.Second
+
+	// minSampleInterval keeps sampling lightweight
+	minSampleInterval = time.Second
+)
+
+// SetMetricSampling samples CPU and memory usage every interval, so each
+// heartbeat's metrics carry their min, average, max and 95th percentile
+// since the previous heartbeat; 0 disables sampling
+func (a *Agent) SetMetricSampling(interval time.Duration) error {
+	if interval != 0 && interval < minSampleInterval {
+		return fmt.Errorf("metric sample interval must be 0 or at least %v", minSampleInterval)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.sampleInterval = interval
+	a.sampler = nil
+	if interval > 0 {
+		a.sampler = sysinfo.NewSampler(sysinfo.DefaultMaxSamples)
+	}
+	return nil
+}
+
+// startSampling samples metrics until the agent stops, if enabled
+func (a *Agent) startSampling() {
+	a.mu.RLock()
+	sampler, interval := a.sampler, a.sampleInterval
+	a.mu.RUnlock()
+	if sampler == nil {
+		return
+	}
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		sampler.Sample()
+		for {
+			select {
+			case <-a.stopChan:
+				return
+			case <-ticker.C:
+				sampler.Sample()
+			}
+		}
+	}()
+}
+
+// metricSummary returns the spread of the metrics sampled since the
+// previous heartbeat, nil without sampling
+func (a *Agent) metricSummary() map[string]sysinfo.MetricSummary {
+	a.mu.RLock()
+	sampler := a.sampler
+	a.mu.RUnlock()
+	if sampler == nil {
+		return nil
+	}
+	return sampler.Summary()
+}
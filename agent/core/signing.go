@@ -0,0 +1,128 @@
+// Package core provides verification of tasks signed by the server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"time"
+)
+
+// taskSignaturePrefix must match the prefix the server signs tasks with
+const taskSignaturePrefix = "nerve-task-v1\n"
+
+// signedTask holds the task fields a signature covers, encoded exactly as
+// the server encodes them for signing
+type signedTask struct {
+	ID        string                 `json:"id"`
+	AgentID   string                 `json:"agent_id"`
+	Type      string                 `json:"type"`
+	Command   string                 `json:"command,omitempty"`
+	Script    string                 `json:"script,omitempty"`
+	Plugin    string                 `json:"plugin,omitempty"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	Timeout   int                    `json:"timeout,omitempty"`
+	ExpiresAt int64                  `json:"expires_at,omitempty"`
+}
+
+// TaskVerifier checks task signatures against the server's Ed25519 public key
+type TaskVerifier struct {
+	key ed25519.PublicKey
+}
+
+// LoadTaskVerifier reads a PEM-encoded PKIX Ed25519 public key, as written
+// by `openssl pkey -pubout`
+func LoadTaskVerifier(file string) (*TaskVerifier, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task verification key: %v", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("task verification key %s is not PEM encoded", file)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task verification key: %v", err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("task verification key %s is not an Ed25519 key", file)
+	}
+	return &TaskVerifier{key: edKey}, nil
+}
+
+// Verify returns an error unless the task carries a valid signature, is
+// addressed to agentID and hasn't expired
+func (v *TaskVerifier) Verify(task Task, agentID string) error {
+	if task.Signature == "" {
+		return fmt.Errorf("task is not signed")
+	}
+	signature, err := base64.StdEncoding.DecodeString(task.Signature)
+	if err != nil {
+		return fmt.Errorf("malformed task signature: %v", err)
+	}
+
+	signed := signedTask{
+		ID:      task.ID,
+		AgentID: task.AgentID,
+		Type:    task.Type,
+		Command: task.Command,
+		Script:  task.Script,
+		Plugin:  task.Plugin,
+		Params:  task.Params,
+		Timeout: task.Timeout,
+	}
+	if !task.ExpiresAt.IsZero() {
+		signed.ExpiresAt = task.ExpiresAt.Unix()
+	}
+	data, err := json.Marshal(signed)
+	if err != nil {
+		return fmt.Errorf("marshal task for verification: %v", err)
+	}
+	if !ed25519.Verify(v.key, append([]byte(taskSignaturePrefix), data...), signature) {
+		return fmt.Errorf("invalid task signature")
+	}
+
+	// A valid signature may still be replayed to another agent or after expiry
+	if agentID != "" && task.AgentID != agentID {
+		return fmt.Errorf("task is addressed to agent %s", task.AgentID)
+	}
+	if !task.ExpiresAt.IsZero() && time.Now().After(task.ExpiresAt) {
+		return fmt.Errorf("task expired at %s", task.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SetTaskVerifier makes the agent run only tasks signed with the server's
+// key; nil runs unsigned tasks
+func (a *Agent) SetTaskVerifier(verifier *TaskVerifier) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.verifier = verifier
+}
+
+// verifyTask checks a task's signature if the agent requires signed tasks
+func (a *Agent) verifyTask(task Task) error {
+	a.mu.RLock()
+	verifier := a.verifier
+	agentID := a.agentID
+	a.mu.RUnlock()
+
+	if verifier == nil {
+		return nil
+	}
+	if err := verifier.Verify(task, agentID); err != nil {
+		return fmt.Errorf("task signature verification failed: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Package core provides TLS settings for the agent's connections to the server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions configures how the agent verifies the server and authenticates
+// to it. The zero value verifies the server against the system roots.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system roots,
+	// e.g. the server's self-signed certificate
+	CAFile string
+	// CertFile and KeyFile are the agent's client certificate for mutual TLS
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables server certificate verification; for
+	// development only, as anyone on the network path can impersonate the server
+	InsecureSkipVerify bool
+}
+
+// Config builds the TLS configuration described by the options
+func (o TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, fmt.Errorf("client certificate and key must be set together")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// SetTLS applies TLS options to the agent's HTTP, WebSocket and gRPC
+// connections; call it before EnableGRPC
+func (a *Agent) SetTLS(opts TLSOptions) error {
+	config, err := opts.Config()
+	if err != nil {
+		return err
+	}
+	if opts.InsecureSkipVerify {
+		a.logger.Errorf("TLS certificate verification is disabled; do not use --insecure-skip-verify in production")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.transportLocked().TLSClientConfig = config
+	a.tlsConfig = config
+	return nil
+}
+
+// grpcTLSConfig returns the TLS configuration for the gRPC transport
+func (a *Agent) grpcTLSConfig() *tls.Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.tlsConfig != nil {
+		return a.tlsConfig.Clone()
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12}
+}
@@ -0,0 +1,139 @@
+// Package core provides the agent side of automatic token rotation: taking
+// over a successor token pushed by the server and keeping it across restarts.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// TokenRotation is a successor token pushed by the server
+type TokenRotation struct {
+	TokenID       string `json:"token_id"`
+	Token         string `json:"token"`
+	ExpiresAt     string `json:"expires_at"`
+	PredecessorID string `json:"predecessor_id"`
+}
+
+// TokenRotationAck reports whether the agent switched to a successor token
+type TokenRotationAck struct {
+	TokenID string `json:"token_id"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// storedToken is the content of the token file. Origin identifies the
+// --token the rotations started from, so a token file left over from an
+// earlier installation is ignored once the agent is given a new token.
+type storedToken struct {
+	Token     string    `json:"token"`
+	Origin    string    `json:"origin"`
+	RotatedAt time.Time `json:"rotated_at"`
+}
+
+// SetTokenFile keeps tokens rotated by the server in file, and switches to
+// the token stored there by an earlier run if it was rotated from the
+// current token. Without a token file rotated tokens are lost on restart.
+func (a *Agent) SetTokenFile(file string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tokenFile = file
+	a.tokenOrigin = tokenFingerprint(a.token)
+	if file == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("failed to create token directory: %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read token file: %v", err)
+	}
+
+	var stored storedToken
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return fmt.Errorf("invalid token file: %v", err)
+	}
+	if stored.Token != "" && stored.Origin == a.tokenOrigin {
+		a.token = stored.Token
+		a.logger.Infof("Using token rotated at %s from %s", stored.RotatedAt.Format(time.RFC3339), file)
+	}
+	return nil
+}
+
+// authToken returns the token the agent authenticates with
+func (a *Agent) authToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.token
+}
+
+// handleTokenRotate switches to a successor token, stored first so a
+// restart does not fall back to the old token, and acknowledges it. The
+// server revokes the old token only after a successful ack.
+func (a *Agent) handleTokenRotate(msg ControlMessage) *ControlMessage {
+	var rotation TokenRotation
+	ack := TokenRotationAck{}
+	if err := json.Unmarshal(msg.Data, &rotation); err != nil {
+		ack.Error = fmt.Sprintf("invalid token rotation: %v", err)
+	} else if rotation.Token == "" {
+		ack.TokenID = rotation.TokenID
+		ack.Error = "token rotation carries no token"
+	} else {
+		ack.TokenID = rotation.TokenID
+		if err := a.applyToken(rotation.Token); err != nil {
+			ack.Error = err.Error()
+			a.logger.Errorf("Rejected token rotation %s: %v", rotation.TokenID, err)
+		} else {
+			ack.Applied = true
+			a.logger.Infof("Switched to rotated token %s (expires %s)", rotation.TokenID, rotation.ExpiresAt)
+		}
+	}
+
+	data, _ := json.Marshal(ack)
+	return &ControlMessage{Type: "token_rotate_ack", AgentID: msg.AgentID, Data: data, Timestamp: time.Now()}
+}
+
+// applyToken stores a token in the token file and makes it current
+func (a *Agent) applyToken(token string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.tokenFile != "" {
+		data, err := json.Marshal(storedToken{Token: token, Origin: a.tokenOrigin, RotatedAt: time.Now()})
+		if err != nil {
+			return fmt.Errorf("failed to marshal token: %v", err)
+		}
+		// Write via a temporary file, so a crash never leaves it half written
+		tmp := a.tokenFile + ".tmp"
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return fmt.Errorf("failed to write token file: %v", err)
+		}
+		if err := os.Rename(tmp, a.tokenFile); err != nil {
+			return fmt.Errorf("failed to write token file: %v", err)
+		}
+	}
+
+	a.token = token
+	return nil
+}
+
+// tokenFingerprint identifies a token without keeping it
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,106 @@
+// Package core provides connection pooling settings for the agent's HTTP client.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConns caps the idle connections kept for reuse
+	DefaultMaxIdleConns = 10
+
+	// DefaultIdleConnTimeout is how long an idle connection is kept; longer
+	// than the heartbeat interval so heartbeats reuse it
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultKeepAlive is the TCP keep-alive period of server connections
+	DefaultKeepAlive = 30 * time.Second
+
+	// dialTimeout bounds establishing a connection to the server
+	dialTimeout = 30 * time.Second
+
+	// maxDrainBytes caps the unread response body read to keep a connection reusable
+	maxDrainBytes = 64 << 10
+)
+
+// TransportOptions tunes how the agent pools connections to the server.
+// Heartbeats, task polls and results share the pool.
+type TransportOptions struct {
+	// MaxIdleConns caps the idle connections kept, all to the server
+	MaxIdleConns int
+	// IdleConnTimeout is how long an idle connection is kept open
+	IdleConnTimeout time.Duration
+	// KeepAlive is the TCP keep-alive period; negative disables keep-alive probes
+	KeepAlive time.Duration
+	// RequestTimeout bounds each API request, including reading the response
+	RequestTimeout time.Duration
+}
+
+// SetTransport applies connection pooling and timeout settings to the agent's
+// HTTP client; zero fields keep their defaults
+func (a *Agent) SetTransport(opts TransportOptions) error {
+	if opts.MaxIdleConns < 0 || opts.IdleConnTimeout < 0 || opts.RequestTimeout < 0 {
+		return fmt.Errorf("idle connections, idle timeout and request timeout must not be negative")
+	}
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = DefaultMaxIdleConns
+	}
+	if opts.IdleConnTimeout == 0 {
+		opts.IdleConnTimeout = DefaultIdleConnTimeout
+	}
+	if opts.KeepAlive == 0 {
+		opts.KeepAlive = DefaultKeepAlive
+	}
+	if opts.RequestTimeout == 0 {
+		opts.RequestTimeout = DefaultTimeout
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	transport := a.transportLocked()
+	transport.MaxIdleConns = opts.MaxIdleConns
+	// Every connection goes to the server, so the per-host cap is the pool size
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConns
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: opts.KeepAlive}).DialContext
+	a.client.Timeout = opts.RequestTimeout
+	return nil
+}
+
+// transportLocked returns the HTTP client's transport, replacing a default
+// one with a copy of http.DefaultTransport that can be tuned. A transport
+// given to NewAgentWithTransport is kept, and the settings go to a detached
+// copy instead. Caller must hold a.mu.
+func (a *Agent) transportLocked() *http.Transport {
+	if transport, ok := a.client.Transport.(*http.Transport); ok && transport != nil {
+		return transport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if a.client.Transport == nil {
+		a.client.Transport = transport
+	}
+	return transport
+}
+
+// requestTimeout returns the timeout of a single API request
+func (a *Agent) requestTimeout() time.Duration {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.client.Timeout
+}
+
+// closeBody reads what is left of a response body before closing it, so the
+// connection goes back to the pool instead of being closed
+func closeBody(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
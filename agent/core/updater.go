@@ -0,0 +1,192 @@
+// Package core provides agent self-update functionality.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// selfCheckTimeout bounds how long the new binary may take to answer --version
+const selfCheckTimeout = 10 * time.Second
+
+// executeUpdate downloads the requested agent version, verifies it and swaps
+// it in place of the running binary. The caller restarts the agent on success.
+func (a *Agent) executeUpdate(task Task) TaskResult {
+	result := TaskResult{TaskID: task.ID}
+
+	version, _ := task.Params["version"].(string)
+	if version == "" {
+		result.Error = "update task missing version"
+		return result
+	}
+	// The download is only trusted if it matches a checksum of the task,
+	// never one sent along with the download
+	expectedChecksum := updateChecksum(task.Params)
+	if expectedChecksum == "" {
+		result.Error = fmt.Sprintf("update task has no checksum for %s/%s", runtime.GOOS, runtime.GOARCH)
+		return result
+	}
+
+	current, err := os.Executable()
+	if err != nil {
+		result.Error = fmt.Sprintf("locate current binary: %v", err)
+		return result
+	}
+	current, _ = filepath.EvalSymlinks(current)
+
+	// Download next to the current binary so the final rename is atomic
+	newPath := current + ".new"
+	checksum, err := a.downloadBinary(version, newPath)
+	if err != nil {
+		os.Remove(newPath)
+		result.Error = fmt.Sprintf("download: %v", err)
+		return result
+	}
+
+	if !strings.EqualFold(checksum, expectedChecksum) {
+		os.Remove(newPath)
+		result.Error = fmt.Sprintf("checksum mismatch: expected %s, got %s", expectedChecksum, checksum)
+		return result
+	}
+
+	// Refuse binaries that cannot even start
+	if err := selfCheck(newPath); err != nil {
+		os.Remove(newPath)
+		result.Error = fmt.Sprintf("self-check failed, keeping current version: %v", err)
+		return result
+	}
+
+	backupPath := current + ".bak"
+	if err := os.Rename(current, backupPath); err != nil {
+		os.Remove(newPath)
+		result.Error = fmt.Sprintf("backup current binary: %v", err)
+		return result
+	}
+	if err := os.Rename(newPath, current); err != nil {
+		// Roll back to the previous binary
+		os.Rename(backupPath, current)
+		os.Remove(newPath)
+		result.Error = fmt.Sprintf("install new binary: %v", err)
+		return result
+	}
+
+	// /proc/self/exe now points at the backup, so remember where we live
+	a.mu.Lock()
+	a.binaryPath = current
+	a.mu.Unlock()
+
+	result.Success = true
+	result.Output = fmt.Sprintf("updated to %s (sha256 %s), previous binary kept at %s", version, checksum, backupPath)
+	return result
+}
+
+// updateChecksum returns the checksum an update task gives for this
+// platform: its checksum, else the one for "<platform>/<arch>" in its
+// checksums
+func updateChecksum(params map[string]interface{}) string {
+	if checksum, _ := params["checksum"].(string); checksum != "" {
+		return checksum
+	}
+	checksums, _ := params["checksums"].(map[string]interface{})
+	checksum, _ := checksums[runtime.GOOS+"/"+runtime.GOARCH].(string)
+	return checksum
+}
+
+// downloadBinary fetches the agent binary for this platform and returns its
+// SHA-256
+func (a *Agent) downloadBinary(version, dst string) (string, error) {
+	url := fmt.Sprintf("%s/api/binaries/download/%s/%s/%s", a.serverURL, version, runtime.GOOS, runtime.GOARCH)
+	req, err := http.NewRequestWithContext(a.ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
+	a.setAuthHeaders(req)
+
+	// Binaries can be large; don't apply the default API timeout
+	client := &http.Client{Transport: a.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), resp.Body); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// selfCheck runs the binary with --version to make sure it starts
+func selfCheck(path string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// restartSelf restarts the agent, e.g. after a successful update. The agent
+// stops first, so in-flight tasks drain and queued results, an update's own
+// included, are delivered before the process goes away.
+func (a *Agent) restartSelf() {
+	a.logger.Info("Restarting")
+	a.Stop()
+	a.restart()
+}
+
+// reexec replaces the stopped agent process with its binary, the updated one
+// after an update. Under systemd the process simply exits and Restart=always
+// brings it up again; otherwise the binary is started with the same arguments
+// before exiting.
+func (a *Agent) reexec() {
+	if os.Getenv("INVOCATION_ID") == "" {
+		a.mu.RLock()
+		exe := a.binaryPath
+		a.mu.RUnlock()
+		if exe == "" {
+			var err error
+			if exe, err = os.Executable(); err != nil {
+				a.logger.Errorf("Restart failed, please restart manually: %v", err)
+				return
+			}
+		}
+
+		cmd := exec.Command(exe, os.Args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			a.logger.Errorf("Restart failed, please restart manually: %v", err)
+			return
+		}
+	}
+
+	os.Exit(0)
+}
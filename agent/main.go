@@ -6,27 +6,97 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/nerve/agent/core"
 	agentlog "github.com/nerve/agent/pkg/log"
+	"github.com/nerve/pkg/logrotate"
 )
 
+// Version is set at build time via -ldflags "-X main.Version=..."
+var Version = "1.0.0"
+
 var (
-	serverURL = flag.String("server", "", "Server URL (e.g., https://nerve-center:8080)")
-	token     = flag.String("token", "", "Authentication token")
-	interval  = flag.Duration("interval", 30*time.Second, "Heartbeat interval")
-	debug     = flag.Bool("debug", false, "Enable debug logging")
+	showVersion  = flag.Bool("version", false, "Print version and exit")
+	serverURL    = flag.String("server", "", "Server URL (e.g., https://nerve-center:8080)")
+	token        = flag.String("token", "", "Authentication token")
+	interval     = flag.Duration("interval", 30*time.Second, "Heartbeat interval")
+	debug        = flag.Bool("debug", false, "Enable debug logging")
+	drainTimeout = flag.Duration("drain-timeout", core.DefaultDrainTimeout, "Grace period for in-flight tasks to finish on shutdown")
+	logFile      = flag.String("log-file", "", "Write logs to this file instead of stderr")
+	logMaxSize   = flag.Int64("log-max-size", 100, "Rotate the log file after this many megabytes (0 to disable)")
+	logMaxAge    = flag.Duration("log-max-age", 24*time.Hour, "Rotate the log file after this age (0 to disable)")
+	logBackups   = flag.Int("log-max-backups", 7, "Number of rotated log files to keep (0 to keep all)")
+	logBuffer    = flag.Int("log-buffer-lines", core.DefaultLogBufferLines, "Recent log lines kept in memory for the server to fetch, redacted (0 to disable)")
+	grpcAddr     = flag.String("grpc-addr", "", "Use the gRPC agent service at host:port instead of HTTP polling")
+	delta        = flag.Bool("heartbeat-delta", true, "Send full inventory in heartbeats only when it changes")
+	sections     = flag.String("heartbeat-sections", "all", "Comma-separated heartbeat sections to collect and send: metrics,cpu,memory,disk,gpu,network or all; registration always sends everything")
+	pluginDir    = flag.String("plugin-dir", "", "Load hook plugins (.so) from this directory")
+	policyFile   = flag.String("command-policy", "", "JSON file constraining the command and script tasks the agent runs")
+	commandMode  = flag.String("command-mode", "", "Command policy mode when no policy file is given: open (default denylist) or disabled (hooks only)")
+	verifyKey    = flag.String("task-verify-key", "", "Ed25519 public key (PEM) of the server; only tasks signed with its private key are run")
+	resultQueue  = flag.String("result-queue", "/var/lib/nerve-agent/results.json", "File keeping undelivered task results across restarts (empty to keep them in memory)")
+	tokenFile    = flag.String("token-file", "/var/lib/nerve-agent/token.json", "File keeping the token rotated by the server across restarts (empty to keep it in memory)")
+	resultsMax   = flag.Int("result-queue-size", core.DefaultResultQueueSize, "Undelivered task results kept before the oldest are dropped")
+	caCert       = flag.String("ca-cert", "", "PEM bundle of CAs trusted to verify the server, in addition to the system roots")
+	clientCert   = flag.String("client-cert", "", "Client certificate (PEM) for mutual TLS")
+	clientKey    = flag.String("client-key", "", "Client private key (PEM) for mutual TLS")
+	insecureTLS  = flag.Bool("insecure-skip-verify", false, "Don't verify the server certificate (development only; allows impersonating the server)")
+	maxIdleConns = flag.Int("max-idle-conns", core.DefaultMaxIdleConns, "Idle connections to the server kept for reuse")
+	idleTimeout  = flag.Duration("idle-conn-timeout", core.DefaultIdleConnTimeout, "How long an idle connection to the server is kept open")
+	keepAlive    = flag.Duration("keep-alive", core.DefaultKeepAlive, "TCP keep-alive period of server connections (negative to disable)")
+	reqTimeout   = flag.Duration("request-timeout", core.DefaultTimeout, "Timeout of each request to the server")
+	tailPaths    = flag.String("log-tail-paths", "", "Comma-separated glob patterns of log files the server may tail live, e.g. /var/log/*.log (empty to disable)")
+	tailRate     = flag.Int("log-tail-rate", core.DefaultLogTailRate, "Lines per second sent for each log tail; excess lines are dropped")
+	tailTimeout  = flag.Duration("log-tail-timeout", core.DefaultLogTailTimeout, "Longest a log tail runs")
+	execShells   = flag.String("exec-shells", "", "Comma-separated absolute paths of the shells operators may open interactively on a PTY, e.g. /bin/bash (empty to disable)")
+	execIdle     = flag.Duration("exec-idle-timeout", core.DefaultExecIdleTimeout, "Kill an interactive shell after this long without input or output")
+	execMax      = flag.Int("exec-max-sessions", core.DefaultMaxExecSessions, "Interactive shells running at once")
+	ctlBackoff   = flag.Duration("control-max-backoff", core.DefaultControlMaxBackoff, "Longest wait between attempts to reopen the control channel to the server")
+	sampleEvery  = flag.Duration("metric-sample-interval", core.DefaultSampleInterval, "Sample CPU and memory usage this often between heartbeats and report their min, avg, max and p95 (0 to disable)")
+	identitySrc  = flag.String("identity-source", strings.Join(core.DefaultIdentitySources, ","), "Comma-separated identity sources tried in order at registration: injected, machine-id, sn, hostname; the hostname is always the last resort")
+	agentID      = flag.String("agent-id", "", "ID to register under with the injected identity source (default $NERVE_AGENT_ID)")
+	packages     = flag.String("packages", "", "Comma-separated glob patterns of installed packages reported at registration, e.g. openssl*,openssh*; * for all (empty to disable)")
+	healthAddr   = flag.String("health-addr", "", "Serve /healthz and /status on this local address, e.g. 127.0.0.1:9101; a bare :port binds to loopback (empty to disable)")
 )
 
 func main() {
 	flag.Parse()
 
-	// Setup logger
+	if *showVersion {
+		fmt.Println(Version)
+		return
+	}
+	core.AgentVersion = Version
+
+	// Setup logger, keeping recent lines for the server when enabled
+	var logOutput io.Writer = os.Stderr
 	logger := agentlog.New(*debug)
+	if *logFile != "" {
+		rotatingFile, err := logrotate.NewFile(*logFile, *logMaxSize*1024*1024, *logMaxAge, *logBackups)
+		if err != nil {
+			logger.Fatalf("Failed to open log file: %v", err)
+		}
+		defer rotatingFile.Close()
+		logOutput = rotatingFile
+	}
+	var logRing *agentlog.RingBuffer
+	if *logBuffer > 0 {
+		logRing = agentlog.NewRingBuffer(*logBuffer)
+		logOutput = io.MultiWriter(logOutput, logRing)
+	}
+	var errorRing *agentlog.RingBuffer
+	if *healthAddr != "" {
+		errorRing = agentlog.NewRingBuffer(core.HealthRecentErrors)
+		logOutput = io.MultiWriter(logOutput, errorRing.ErrorsOnly())
+	}
+	logger = agentlog.NewWithWriter(*debug, logOutput)
 
 	if *serverURL == "" {
 		logger.Fatal("server URL is required (--server)")
@@ -39,6 +109,112 @@ func main() {
 
 	// Initialize core components
 	agent := core.NewAgentWithLogger(*serverURL, *token, *interval, logger)
+	agent.SetDrainTimeout(*drainTimeout)
+	if logRing != nil {
+		agent.SetLogBuffer(logRing)
+	}
+	if err := agent.SetTLS(core.TLSOptions{
+		CAFile:             *caCert,
+		CertFile:           *clientCert,
+		KeyFile:            *clientKey,
+		InsecureSkipVerify: *insecureTLS,
+	}); err != nil {
+		logger.Fatalf("Invalid TLS configuration: %v", err)
+	}
+	if err := agent.SetTransport(core.TransportOptions{
+		MaxIdleConns:    *maxIdleConns,
+		IdleConnTimeout: *idleTimeout,
+		KeepAlive:       *keepAlive,
+		RequestTimeout:  *reqTimeout,
+	}); err != nil {
+		logger.Fatalf("Invalid connection settings: %v", err)
+	}
+	agent.SetHeartbeatDelta(*delta)
+	if err := agent.SetHeartbeatSections(strings.Split(*sections, ",")); err != nil {
+		logger.Fatalf("Invalid --heartbeat-sections: %v", err)
+	}
+	if *policyFile != "" {
+		policy, err := core.LoadCommandPolicy(*policyFile)
+		if err != nil {
+			logger.Fatalf("Failed to load command policy: %v", err)
+		}
+		agent.SetCommandPolicy(policy)
+		logger.Infof("Command policy: %s", policy.Mode)
+	} else if *commandMode != "" {
+		policy, err := core.NewCommandPolicy(*commandMode)
+		if err != nil {
+			logger.Fatalf("Invalid command policy: %v", err)
+		}
+		agent.SetCommandPolicy(policy)
+		logger.Infof("Command policy: %s", policy.Mode)
+	}
+	if *verifyKey != "" {
+		verifier, err := core.LoadTaskVerifier(*verifyKey)
+		if err != nil {
+			logger.Fatalf("Failed to load task verification key: %v", err)
+		}
+		agent.SetTaskVerifier(verifier)
+		logger.Info("Only signed tasks will be run")
+	}
+	if err := agent.SetTokenFile(*tokenFile); err != nil {
+		logger.Errorf("Token file unavailable, rotated tokens last until restart: %v", err)
+		agent.SetTokenFile("")
+	}
+	if err := agent.SetResultQueue(*resultQueue, *resultsMax); err != nil {
+		logger.Errorf("Result queue unavailable, keeping results in memory: %v", err)
+		if err := agent.SetResultQueue("", *resultsMax); err != nil {
+			logger.Fatalf("Failed to create result queue: %v", err)
+		}
+	}
+	if *pluginDir != "" {
+		plugins := core.NewPluginManager(*pluginDir)
+		if err := plugins.LoadPlugins(); err != nil {
+			logger.Fatalf("Failed to load plugins: %v", err)
+		}
+		agent.SetPluginManager(plugins)
+	}
+	if *tailPaths != "" {
+		if err := agent.SetLogTail(core.LogTailOptions{
+			Paths:       strings.Split(*tailPaths, ","),
+			MaxRate:     *tailRate,
+			MaxDuration: *tailTimeout,
+		}); err != nil {
+			logger.Fatalf("Invalid log tail settings: %v", err)
+		}
+	}
+	if *execShells != "" {
+		if err := agent.SetExec(core.ExecOptions{
+			Shells:      strings.Split(*execShells, ","),
+			IdleTimeout: *execIdle,
+			MaxSessions: *execMax,
+		}); err != nil {
+			logger.Fatalf("Invalid exec settings: %v", err)
+		}
+		logger.Infof("Interactive exec enabled for %s", *execShells)
+	}
+	if err := agent.SetControlMaxBackoff(*ctlBackoff); err != nil {
+		logger.Fatalf("Invalid --control-max-backoff: %v", err)
+	}
+	if err := agent.SetMetricSampling(*sampleEvery); err != nil {
+		logger.Fatalf("Invalid --metric-sample-interval: %v", err)
+	}
+	if err := agent.SetIdentity(strings.Split(*identitySrc, ","), *agentID); err != nil {
+		logger.Fatalf("Invalid agent identity: %v", err)
+	}
+	if err := agent.SetPackageInventory(strings.Split(*packages, ",")); err != nil {
+		logger.Fatalf("Invalid --packages: %v", err)
+	}
+	if *grpcAddr != "" {
+		if err := agent.EnableGRPC(*grpcAddr); err != nil {
+			logger.Fatalf("Failed to enable gRPC: %v", err)
+		}
+	}
+	if *healthAddr != "" {
+		agent.SetErrorBuffer(errorRing)
+		if err := agent.StartHealthServer(*healthAddr); err != nil {
+			logger.Fatalf("Invalid --health-addr: %v", err)
+		}
+	}
 
 	// Initial registration
 	if err := agent.Register(); err != nil {
@@ -52,6 +228,9 @@ func main() {
 	// Start task listener
 	go agent.StartTaskListener()
 
+	// Start control channel for configuration pushed by the server
+	agent.StartControlChannel()
+
 	// Wait for interrupt
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
@@ -60,4 +239,3 @@ func main() {
 	logger.Info("Shutting down...")
 	agent.Stop()
 }
-
@@ -1,8 +1,10 @@
 package log
 
 import (
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 // Logger provides structured logging
@@ -15,23 +17,35 @@ type Logger interface {
 	Infof(format string, args ...interface{})
 	Errorf(format string, args ...interface{})
 	Debugf(format string, args ...interface{})
+	SetDebug(debug bool)
 }
 
 type logger struct {
-	debug bool
+	debug atomic.Bool
 	*log.Logger
 }
 
 // New creates a new logger
 func New(debug bool) Logger {
-	return &logger{
-		debug:  debug,
-		Logger: log.New(os.Stderr, "[NerveAgent] ", log.LstdFlags),
+	return NewWithWriter(debug, os.Stderr)
+}
+
+// NewWithWriter creates a new logger writing to w
+func NewWithWriter(debug bool, w io.Writer) Logger {
+	l := &logger{
+		Logger: log.New(w, "[NerveAgent] ", log.LstdFlags),
 	}
+	l.debug.Store(debug)
+	return l
+}
+
+// SetDebug enables or disables debug output at runtime
+func (l *logger) SetDebug(debug bool) {
+	l.debug.Store(debug)
 }
 
 func (l *logger) Debug(format string, args ...interface{}) {
-	if l.debug {
+	if l.debug.Load() {
 		l.Printf("[DEBUG] "+format, args...)
 	}
 }
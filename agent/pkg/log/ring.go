@@ -0,0 +1,90 @@
+// Package log provides an in-memory buffer of the most recent log lines.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package log
+
+import (
+	"io"
+	"strings"
+	"sync"
+)
+
+// maxRingLineLength caps each buffered line; longer lines are cut
+const maxRingLineLength = 2048
+
+// RingBuffer is an io.Writer keeping the last lines written to it, e.g. to
+// hand an agent's recent logs to the server. It is safe for concurrent use.
+type RingBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+	full  bool
+}
+
+// NewRingBuffer creates a buffer of the last size lines
+func NewRingBuffer(size int) *RingBuffer {
+	if size < 1 {
+		size = 1
+	}
+	return &RingBuffer{lines: make([]string, size)}
+}
+
+// Write buffers each line of p, evicting the oldest lines
+func (rb *RingBuffer) Write(p []byte) (int, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if len(line) > maxRingLineLength {
+			line = line[:maxRingLineLength]
+		}
+		rb.lines[rb.next] = line
+		rb.next = (rb.next + 1) % len(rb.lines)
+		rb.full = rb.full || rb.next == 0
+	}
+	return len(p), nil
+}
+
+// Lines returns up to the last n buffered lines, oldest first; n <= 0
+// returns them all
+func (rb *RingBuffer) Lines(n int) []string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	var lines []string
+	if rb.full {
+		lines = append(lines, rb.lines[rb.next:]...)
+	}
+	lines = append(lines, rb.lines[:rb.next]...)
+	if n > 0 && n < len(lines) {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// Size returns how many lines the buffer keeps
+func (rb *RingBuffer) Size() int {
+	return len(rb.lines)
+}
+
+// ErrorsOnly returns an io.Writer buffering only the error lines written to
+// it, e.g. to keep the recent errors apart from the rest of the log
+func (rb *RingBuffer) ErrorsOnly() io.Writer {
+	return errorFilter{rb}
+}
+
+// errorFilter passes the [ERROR] lines of each write on to a buffer
+type errorFilter struct {
+	rb *RingBuffer
+}
+
+// Write buffers the error lines of p
+func (f errorFilter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if strings.Contains(line, "[ERROR] ") {
+			f.rb.Write([]byte(line))
+		}
+	}
+	return len(p), nil
+}
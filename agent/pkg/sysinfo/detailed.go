@@ -125,9 +125,13 @@ func GetDetailedGPUInfo() []map[string]interface{} {
 			lines := strings.Split(strings.TrimSpace(string(out)), "\n")
 			for i, line := range lines {
 				fields := strings.Split(line, ", ")
-				if len(fields) >= 5 {
+				if len(fields) >= 6 {
+					index := i
+					if parsed, err := strconv.Atoi(strings.TrimSpace(fields[0])); err == nil {
+						index = parsed
+					}
 					gpus = append(gpus, map[string]interface{}{
-						"index":        i,
+						"index":        index,
 						"name":         strings.TrimSpace(fields[1]),
 						"memory_total": strings.TrimSpace(fields[2]) + " MB",
 						"driver":       strings.TrimSpace(fields[3]),
@@ -137,6 +141,7 @@ func GetDetailedGPUInfo() []map[string]interface{} {
 					})
 				}
 			}
+			addGPUTopology(gpus)
 		}
 
 		// Check AMD GPUs
@@ -0,0 +1,365 @@
+// Package sysinfo provides per-mount filesystem usage, with fill rate and
+// inode usage, and per-disk IO rates from /proc/diskstats for heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFilesystems and maxDisks cap the mounts and disks reported
+	maxFilesystems = 64
+	maxDisks       = 64
+
+	// statfsTimeout bounds the wait for a mount's statistics; a hung
+	// network filesystem is skipped until its call returns
+	statfsTimeout = 2 * time.Second
+
+	// sectorSize is the unit of the sector counts in /proc/diskstats
+	sectorSize = 512
+)
+
+// skippedFSTypes are filesystems not reported: kernel pseudo filesystems,
+// memory-backed ones covered by memory usage, container overlays whose
+// backing filesystem is reported instead, and read-only images that are
+// always full
+var skippedFSTypes = map[string]bool{
+	"proc": true, "sysfs": true, "devtmpfs": true, "devpts": true, "cgroup": true,
+	"cgroup2": true, "pstore": true, "bpf": true, "tracefs": true, "debugfs": true,
+	"securityfs": true, "mqueue": true, "hugetlbfs": true, "configfs": true,
+	"fusectl": true, "autofs": true, "binfmt_misc": true, "rpc_pipefs": true,
+	"nsfs": true, "efivarfs": true, "tmpfs": true, "ramfs": true, "overlay": true,
+	"squashfs": true, "iso9660": true,
+}
+
+// FilesystemUsage is the usage of a mounted filesystem. FillRate is the
+// growth of used space since the previous sample; HoursUntilFull is set
+// while it grows. Inode figures are left out for filesystems without a
+// fixed inode count, such as btrfs.
+type FilesystemUsage struct {
+	Device            string   `json:"device"`
+	FSType            string   `json:"fs_type"`
+	SizeBytes         uint64   `json:"size_bytes"`
+	UsedBytes         uint64   `json:"used_bytes"`
+	AvailableBytes    uint64   `json:"available_bytes"`
+	UsedPercent       float64  `json:"used_percent"`
+	InodesTotal       uint64   `json:"inodes_total,omitempty"`
+	InodesUsed        uint64   `json:"inodes_used,omitempty"`
+	InodesUsedPercent *float64 `json:"inodes_used_percent,omitempty"`
+	FillRate          float64  `json:"fill_rate_bytes_per_sec"`
+	HoursUntilFull    *float64 `json:"hours_until_full,omitempty"`
+}
+
+// DiskIO is the IO of a disk since the previous sample
+type DiskIO struct {
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	UtilPercent      float64 `json:"util_percent"`
+	AwaitMs          float64 `json:"await_ms"`
+}
+
+// mount is a filesystem mount from /proc/self/mountinfo
+type mount struct {
+	deviceID string
+	root     string
+	point    string
+	fsType   string
+	source   string
+}
+
+// fsStats are the statistics of a mounted filesystem, in bytes and inodes
+type fsStats struct {
+	size, free, available uint64
+	files, filesFree      uint64
+}
+
+// diskCounters are the cumulative counters of a disk in /proc/diskstats
+type diskCounters struct {
+	reads, readSectors, readMs    uint64
+	writes, writeSectors, writeMs uint64
+	ioMs                          uint64
+}
+
+type usedSample struct {
+	used uint64
+	at   time.Time
+}
+
+var (
+	fsMu       sync.Mutex
+	fsPrevious = map[string]usedSample{}
+	fsPending  = map[string]bool{}
+
+	diskMu       sync.Mutex
+	diskPrevious map[string]diskCounters
+	diskSampled  time.Time
+)
+
+// Filesystems returns the usage of the mounted filesystems keyed by mount
+// point. A filesystem mounted several times, e.g. by bind mounts, is
+// reported once, at the mount of its root or else the shortest mount point.
+func Filesystems() map[string]FilesystemUsage {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	data, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return nil
+	}
+
+	now := time.Now()
+	filesystems := make(map[string]FilesystemUsage)
+	for _, m := range uniqueMounts(parseMountinfo(string(data))) {
+		if len(filesystems) == maxFilesystems {
+			break
+		}
+		stats, ok := statfsWithTimeout(m.point)
+		if !ok || stats.size == 0 {
+			continue
+		}
+
+		usage := FilesystemUsage{
+			Device:         m.source,
+			FSType:         m.fsType,
+			SizeBytes:      stats.size,
+			UsedBytes:      stats.size - stats.free,
+			AvailableBytes: stats.available,
+		}
+		// Like df, the used share of the space available to users
+		if usable := usage.UsedBytes + stats.available; usable > 0 {
+			usage.UsedPercent = float64(usage.UsedBytes) / float64(usable) * 100
+		}
+		if stats.files > 0 {
+			usage.InodesTotal = stats.files
+			usage.InodesUsed = stats.files - stats.filesFree
+			percent := float64(usage.InodesUsed) / float64(stats.files) * 100
+			usage.InodesUsedPercent = &percent
+		}
+		usage.FillRate, usage.HoursUntilFull = fillRate(m.point, usage.UsedBytes, stats.available, now)
+		filesystems[m.point] = usage
+	}
+	return filesystems
+}
+
+// fillRate returns how fast a mount's used space grew since the previous
+// sample, and the hours until it is full at that rate if it grows
+func fillRate(point string, used, available uint64, now time.Time) (float64, *float64) {
+	fsMu.Lock()
+	defer fsMu.Unlock()
+
+	previous, ok := fsPrevious[point]
+	fsPrevious[point] = usedSample{used: used, at: now}
+	if !ok {
+		return 0, nil
+	}
+	elapsed := now.Sub(previous.at).Seconds()
+	if elapsed <= 0 {
+		return 0, nil
+	}
+	rate := (float64(used) - float64(previous.used)) / elapsed
+	if rate <= 0 {
+		return rate, nil
+	}
+	hours := float64(available) / rate / 3600
+	return rate, &hours
+}
+
+// statfsWithTimeout returns the statistics of a mount, giving up after
+// statfsTimeout. A mount whose previous call hasn't returned is skipped.
+func statfsWithTimeout(point string) (fsStats, bool) {
+	fsMu.Lock()
+	if fsPending[point] {
+		fsMu.Unlock()
+		return fsStats{}, false
+	}
+	fsPending[point] = true
+	fsMu.Unlock()
+
+	type result struct {
+		stats fsStats
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stats, err := statfs(point)
+		fsMu.Lock()
+		delete(fsPending, point)
+		fsMu.Unlock()
+		done <- result{stats, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.stats, r.err == nil
+	case <-time.After(statfsTimeout):
+		return fsStats{}, false
+	}
+}
+
+// parseMountinfo parses /proc/self/mountinfo lines such as
+// "36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw"
+func parseMountinfo(data string) []mount {
+	var mounts []mount
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		separator := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				separator = i
+				break
+			}
+		}
+		if len(fields) < 5 || separator < 0 || separator+2 >= len(fields) {
+			continue
+		}
+		mounts = append(mounts, mount{
+			deviceID: fields[2],
+			root:     unescapeMount(fields[3]),
+			point:    unescapeMount(fields[4]),
+			fsType:   fields[separator+1],
+			source:   unescapeMount(fields[separator+2]),
+		})
+	}
+	return mounts
+}
+
+// uniqueMounts drops the skipped filesystem types and keeps one mount per
+// device: the mount of the filesystem's root, else the shortest mount point
+func uniqueMounts(mounts []mount) []mount {
+	chosen := make(map[string]int)
+	var unique []mount
+	for _, m := range mounts {
+		if skippedFSTypes[m.fsType] {
+			continue
+		}
+		i, seen := chosen[m.deviceID]
+		if !seen {
+			chosen[m.deviceID] = len(unique)
+			unique = append(unique, m)
+			continue
+		}
+		current := unique[i]
+		if (m.root == "/" && current.root != "/") ||
+			(m.root == current.root && len(m.point) < len(current.point)) {
+			unique[i] = m
+		}
+	}
+	return unique
+}
+
+// unescapeMount decodes the octal escapes of mountinfo, e.g. \040 for a space
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if value, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(value))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// DiskIOStats returns the IO rates of the disks since the previous call,
+// keyed by device name; the first call reports none. Partitions, loop and
+// RAM devices are left out.
+func DiskIOStats() map[string]DiskIO {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+	data, err := os.ReadFile("/proc/diskstats")
+	if err != nil {
+		return nil
+	}
+	current := parseDiskstats(string(data))
+
+	diskMu.Lock()
+	defer diskMu.Unlock()
+
+	now := time.Now()
+	previous, elapsed := diskPrevious, now.Sub(diskSampled)
+	diskPrevious, diskSampled = current, now
+	if previous == nil || elapsed <= 0 {
+		return nil
+	}
+
+	seconds := elapsed.Seconds()
+	disks := make(map[string]DiskIO)
+	for name, c := range current {
+		p, ok := previous[name]
+		if !ok || len(disks) == maxDisks {
+			continue
+		}
+		// Counters reset when a device is re-attached
+		if c.reads < p.reads || c.writes < p.writes || c.ioMs < p.ioMs {
+			continue
+		}
+		reads, writes := c.reads-p.reads, c.writes-p.writes
+		io := DiskIO{
+			ReadsPerSec:      float64(reads) / seconds,
+			WritesPerSec:     float64(writes) / seconds,
+			ReadBytesPerSec:  float64(c.readSectors-p.readSectors) * sectorSize / seconds,
+			WriteBytesPerSec: float64(c.writeSectors-p.writeSectors) * sectorSize / seconds,
+			UtilPercent:      float64(c.ioMs-p.ioMs) / (seconds * 1000) * 100,
+		}
+		if io.UtilPercent > 100 {
+			io.UtilPercent = 100
+		}
+		if ops := reads + writes; ops > 0 {
+			io.AwaitMs = float64(c.readMs-p.readMs+c.writeMs-p.writeMs) / float64(ops)
+		}
+		disks[name] = io
+	}
+	return disks
+}
+
+// parseDiskstats reads the counters of whole disks from /proc/diskstats
+// lines such as "8 0 sda 4913 1200 391690 2116 ..."
+func parseDiskstats(data string) map[string]diskCounters {
+	disks := make(map[string]diskCounters)
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 14 {
+			continue
+		}
+		name := fields[2]
+		if strings.HasPrefix(name, "loop") || strings.HasPrefix(name, "ram") {
+			continue
+		}
+		// Only whole disks have an entry in /sys/block
+		if _, err := os.Stat("/sys/block/" + name); err != nil {
+			continue
+		}
+
+		var values [11]uint64
+		for i := range values {
+			values[i], _ = strconv.ParseUint(fields[3+i], 10, 64)
+		}
+		disks[name] = diskCounters{
+			reads:        values[0],
+			readSectors:  values[2],
+			readMs:       values[3],
+			writes:       values[4],
+			writeSectors: values[6],
+			writeMs:      values[7],
+			ioMs:         values[9],
+		}
+	}
+	return disks
+}
@@ -0,0 +1,100 @@
+// Package sysinfo provides per-GPU utilization sampling for heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gpuQueryTimeout bounds an nvidia-smi query, which can hang on a wedged GPU
+const gpuQueryTimeout = 5 * time.Second
+
+// GPUUsage represents point-in-time utilization of one GPU. Values nvidia-smi
+// reports as unsupported, e.g. power on some boards, are left at 0.
+type GPUUsage struct {
+	Index          int     `json:"index"`
+	Name           string  `json:"name"`
+	Utilization    float64 `json:"utilization"`
+	MemoryUsedMB   float64 `json:"memory_used_mb"`
+	MemoryTotalMB  float64 `json:"memory_total_mb"`
+	TemperatureC   float64 `json:"temperature_c"`
+	PowerDrawWatts float64 `json:"power_draw_watts"`
+}
+
+// nvidiaSMI is where nvidia-smi was found, empty on hosts without it
+var nvidiaSMI toolLookup
+
+// GetGPUUsage returns the utilization of each NVIDIA GPU, or nil on hosts
+// without nvidia-smi or when the query fails
+func GetGPUUsage() []GPUUsage {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	// Looked up once, so GPU-less hosts don't search PATH every heartbeat
+	nvidiaSMIPath := nvidiaSMI.get(func() string {
+		path, _ := exec.LookPath("nvidia-smi")
+		return path
+	})
+	if nvidiaSMIPath == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gpuQueryTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, nvidiaSMIPath,
+		"--query-gpu=index,name,utilization.gpu,memory.used,memory.total,temperature.gpu,power.draw",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil
+	}
+	return parseGPUUsage(string(out))
+}
+
+// parseGPUUsage parses nvidia-smi --query-gpu CSV output, skipping
+// malformed lines
+func parseGPUUsage(out string) []GPUUsage {
+	var gpus []GPUUsage
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 7 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		gpus = append(gpus, GPUUsage{
+			Index:          index,
+			Name:           fields[1],
+			Utilization:    parseGPUValue(fields[2]),
+			MemoryUsedMB:   parseGPUValue(fields[3]),
+			MemoryTotalMB:  parseGPUValue(fields[4]),
+			TemperatureC:   parseGPUValue(fields[5]),
+			PowerDrawWatts: parseGPUValue(fields[6]),
+		})
+	}
+	return gpus
+}
+
+// parseGPUValue parses a numeric nvidia-smi field; "[N/A]" and
+// "[Not Supported]" become 0
+func parseGPUValue(field string) float64 {
+	value, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
@@ -0,0 +1,194 @@
+// Package sysinfo provides NVLink topology and MIG partition discovery for
+// NVIDIA GPU hosts.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ansiEscape matches the formatting nvidia-smi topo -m puts in its header
+	ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+	// nvidia-smi -L lines of a GPU and of one of its MIG devices
+	gpuListLine = regexp.MustCompile(`^GPU (\d+):`)
+	migListLine = regexp.MustCompile(`^\s+MIG (\S+)\s+Device\s+\d+: \(UUID: ([^)]+)\)`)
+)
+
+// gpuTopology is one GPU's row of the nvidia-smi topo -m matrix
+type gpuTopology struct {
+	// links maps each other device, e.g. GPU1 or NIC0, to the connection
+	// to it: NV<n> for n bonded NVLinks, or PIX, PXB, PHB, NODE or SYS for
+	// PCIe paths of increasing distance
+	links        map[string]string
+	cpuAffinity  string
+	numaAffinity string
+}
+
+// addGPUTopology adds the interconnect matrix row, NVLink peers, MIG mode and
+// MIG instances to each GPU of GetDetailedGPUInfo. Nothing is added where
+// nvidia-smi doesn't support the query, e.g. topology on single-GPU hosts.
+func addGPUTopology(gpus []map[string]interface{}) {
+	var topology map[string]gpuTopology
+	if out, err := exec.Command("nvidia-smi", "topo", "-m").Output(); err == nil {
+		topology = parseGPUTopology(string(out))
+	}
+	var modes map[int]string
+	if out, err := exec.Command("nvidia-smi", "--query-gpu=index,mig.mode.current", "--format=csv,noheader").Output(); err == nil {
+		modes = parseMIGModes(string(out))
+	}
+	var instances map[int]map[string]interface{}
+	if out, err := exec.Command("nvidia-smi", "-L").Output(); err == nil {
+		instances = parseMIGInstances(string(out))
+	}
+
+	for _, gpu := range gpus {
+		index, ok := gpu["index"].(int)
+		if !ok {
+			continue
+		}
+
+		if row, ok := topology["GPU"+strconv.Itoa(index)]; ok {
+			links := make(map[string]interface{}, len(row.links))
+			peers := []string{}
+			for device, link := range row.links {
+				links[device] = link
+				if strings.HasPrefix(link, "NV") && strings.HasPrefix(device, "GPU") {
+					peers = append(peers, device)
+				}
+			}
+			// Sorted, so the inventory hash is stable
+			sort.Strings(peers)
+			gpu["topology"] = links
+			gpu["nvlink_peers"] = peers
+			if row.cpuAffinity != "" {
+				gpu["cpu_affinity"] = row.cpuAffinity
+			}
+			if row.numaAffinity != "" {
+				gpu["numa_affinity"] = row.numaAffinity
+			}
+		}
+
+		if mode, ok := modes[index]; ok {
+			gpu["mig_mode"] = mode
+			if mode == "Enabled" {
+				// Keyed by MIG device UUID, the ID used to schedule onto it
+				if migs, ok := instances[index]; ok {
+					gpu["mig_instances"] = migs
+				} else {
+					gpu["mig_instances"] = map[string]interface{}{}
+				}
+			}
+		}
+	}
+}
+
+// parseGPUTopology parses the matrix of nvidia-smi topo -m into the rows of
+// the GPUs, keyed by device name such as GPU0
+func parseGPUTopology(out string) map[string]gpuTopology {
+	lines := strings.Split(ansiEscape.ReplaceAllString(out, ""), "\n")
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// Device columns come first; affinity columns have spaces in their names
+	var devices []string
+	for _, cell := range strings.Split(lines[0], "\t") {
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+		if strings.Contains(cell, " ") {
+			break
+		}
+		devices = append(devices, cell)
+	}
+	if len(devices) == 0 {
+		return nil
+	}
+
+	topology := make(map[string]gpuTopology)
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		// The matrix ends at the blank line before the legend
+		if len(fields) == 0 {
+			break
+		}
+		if !strings.HasPrefix(fields[0], "GPU") || len(fields) < len(devices)+1 {
+			continue
+		}
+
+		row := gpuTopology{links: make(map[string]string, len(devices))}
+		for i, device := range devices {
+			if link := fields[i+1]; link != "X" && device != fields[0] {
+				row.links[device] = link
+			}
+		}
+		if rest := fields[len(devices)+1:]; len(rest) > 0 {
+			row.cpuAffinity = topologyValue(rest[0])
+			if len(rest) > 1 {
+				row.numaAffinity = topologyValue(rest[1])
+			}
+		}
+		topology[fields[0]] = row
+	}
+	return topology
+}
+
+// topologyValue returns an affinity cell, or "" if not applicable
+func topologyValue(cell string) string {
+	if cell == "N/A" {
+		return ""
+	}
+	return cell
+}
+
+// parseMIGModes parses nvidia-smi --query-gpu=index,mig.mode.current output
+// into the current MIG mode of each GPU supporting MIG
+func parseMIGModes(out string) map[int]string {
+	modes := make(map[int]string)
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) < 2 {
+			continue
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(fields[0]))
+		if err != nil {
+			continue
+		}
+		mode := strings.TrimSpace(fields[1])
+		if mode == "Enabled" || mode == "Disabled" {
+			modes[index] = mode
+		}
+	}
+	return modes
+}
+
+// parseMIGInstances parses nvidia-smi -L output into the MIG devices of each
+// GPU, as profile (e.g. 1g.10gb) keyed by MIG device UUID
+func parseMIGInstances(out string) map[int]map[string]interface{} {
+	instances := make(map[int]map[string]interface{})
+	gpu := -1
+	for _, line := range strings.Split(out, "\n") {
+		if match := gpuListLine.FindStringSubmatch(line); match != nil {
+			gpu, _ = strconv.Atoi(match[1])
+			continue
+		}
+		match := migListLine.FindStringSubmatch(line)
+		if match == nil || gpu < 0 {
+			continue
+		}
+		if instances[gpu] == nil {
+			instances[gpu] = make(map[string]interface{})
+		}
+		instances[gpu][strings.TrimSpace(match[2])] = match[1]
+	}
+	return instances
+}
@@ -0,0 +1,48 @@
+// Package sysinfo provides the machine identities an agent can register
+// under: the systemd machine-id and the hardware serial number.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"os"
+	"strings"
+)
+
+// machineIDPaths are where the machine-id is found, systemd's first
+var machineIDPaths = []string{"/etc/machine-id", "/var/lib/dbus/machine-id"}
+
+// placeholderSNs are serial numbers vendors leave in unset DMI fields,
+// shared by many machines
+var placeholderSNs = map[string]bool{
+	"unknown": true, "none": true, "n/a": true, "na": true, "0": true,
+	"not specified": true, "not applicable": true, "not available": true,
+	"to be filled by o.e.m.": true, "default string": true,
+	"system serial number": true, "0123456789": true,
+}
+
+// MachineID returns the machine-id, empty if there is none or it isn't
+// initialized yet
+func MachineID() string {
+	for _, path := range machineIDPaths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if id := strings.TrimSpace(string(data)); id != "" && id != "uninitialized" {
+			return id
+		}
+	}
+	return ""
+}
+
+// UniqueSN returns the hardware serial number, empty if it is unset or a
+// vendor placeholder
+func UniqueSN() string {
+	sn := strings.TrimSpace(GetSN())
+	if placeholderSNs[strings.ToLower(sn)] || strings.Trim(sn, "0") == "" {
+		return ""
+	}
+	return sn
+}
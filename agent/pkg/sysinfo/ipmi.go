@@ -0,0 +1,192 @@
+// Package sysinfo provides power supply, fan and temperature sensors read
+// from the BMC with ipmitool.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ipmiQueryTimeout bounds an ipmitool query; BMCs can be slow to answer
+	ipmiQueryTimeout = 10 * time.Second
+
+	// ipmiSampleInterval is how long sensor readings are reused, so every
+	// heartbeat doesn't query the BMC
+	ipmiSampleInterval = time.Minute
+)
+
+// Sensor types reported
+const (
+	SensorPowerSupply = "power_supply"
+	SensorFan         = "fan"
+	SensorTemperature = "temperature"
+)
+
+// Sensor statuses, normalized from ipmitool's
+const (
+	SensorOK       = "ok"
+	SensorWarning  = "warning"
+	SensorCritical = "critical"
+	SensorAbsent   = "absent"
+)
+
+// ipmiSensorTypes maps the ipmitool sdr types queried to the reported types
+var ipmiSensorTypes = []struct{ sdrType, sensorType string }{
+	{"Power Supply", SensorPowerSupply},
+	{"Fan", SensorFan},
+	{"Temperature", SensorTemperature},
+}
+
+// ipmiDevices are the IPMI device nodes; without one there is no BMC to read
+var ipmiDevices = []string{"/dev/ipmi0", "/dev/ipmi/0", "/dev/ipmidev/0"}
+
+// Sensor is a BMC sensor reading. Value is set for numeric readings such as
+// fan RPM or degrees C; Detail holds the discrete state, e.g. of a power
+// supply.
+type Sensor struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Status string   `json:"status"`
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	Detail string   `json:"detail,omitempty"`
+}
+
+var (
+	// ipmitool is where ipmitool was found, empty on hosts without a BMC
+	ipmitool toolLookup
+
+	ipmiSampleMu   sync.Mutex
+	ipmiSample     []Sensor
+	ipmiSampleTime time.Time
+)
+
+// IPMISensors returns the power supply, fan and temperature sensors of the
+// BMC, read at most once per ipmiSampleInterval. Hosts without ipmitool or
+// an IPMI device yield none.
+func IPMISensors() []Sensor {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	// Looked up once, so hosts without a BMC don't search PATH every heartbeat
+	ipmitoolPath := ipmitool.get(func() string {
+		for _, device := range ipmiDevices {
+			if _, err := os.Stat(device); err == nil {
+				path, _ := exec.LookPath("ipmitool")
+				return path
+			}
+		}
+		return ""
+	})
+	if ipmitoolPath == "" {
+		return nil
+	}
+
+	ipmiSampleMu.Lock()
+	defer ipmiSampleMu.Unlock()
+
+	if !ipmiSampleTime.IsZero() && time.Since(ipmiSampleTime) < ipmiSampleInterval {
+		return ipmiSample
+	}
+
+	var sensors []Sensor
+	for _, t := range ipmiSensorTypes {
+		ctx, cancel := context.WithTimeout(context.Background(), ipmiQueryTimeout)
+		out, err := exec.CommandContext(ctx, ipmitoolPath, "sdr", "type", t.sdrType).Output()
+		cancel()
+		if err != nil {
+			continue
+		}
+		sensors = append(sensors, parseSDR(string(out), t.sensorType)...)
+	}
+	ipmiSample, ipmiSampleTime = sensors, time.Now()
+	return sensors
+}
+
+// parseSDR parses ipmitool sdr type output, e.g.
+// "Fan1A | 30h | ok | 7.1 | 5040 RPM" or
+// "PS2 Status | 63h | ok | 10.2 | Presence detected, Power Supply AC lost"
+func parseSDR(out, sensorType string) []Sensor {
+	var sensors []Sensor
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "|")
+		if len(fields) < 5 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+
+		sensor := Sensor{Name: fields[0], Type: sensorType, Status: sdrStatus(fields[2])}
+		reading := fields[4]
+		if value, unit, ok := parseSDRReading(reading); ok {
+			sensor.Value, sensor.Unit = &value, unit
+		} else {
+			sensor.Detail = reading
+		}
+
+		// Power supplies report failures as discrete states under an ok status
+		if sensorType == SensorPowerSupply && sensor.Status == SensorOK && powerSupplyFailed(reading) {
+			sensor.Status = SensorCritical
+		}
+		if sensor.Name != "" {
+			sensors = append(sensors, sensor)
+		}
+	}
+	return sensors
+}
+
+// sdrStatus normalizes an ipmitool sensor status: ok, nc (non-critical),
+// cr and nr (critical and non-recoverable), or ns (no reading)
+func sdrStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "ok":
+		return SensorOK
+	case "nc", "lnc", "unc":
+		return SensorWarning
+	case "cr", "lcr", "ucr", "nr", "lnr", "unr":
+		return SensorCritical
+	default:
+		return SensorAbsent
+	}
+}
+
+// parseSDRReading parses a numeric reading such as "5040 RPM" or
+// "23 degrees C"
+func parseSDRReading(reading string) (float64, string, bool) {
+	number, unit, _ := strings.Cut(reading, " ")
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	switch unit = strings.TrimSpace(unit); unit {
+	case "degrees C":
+		unit = "C"
+	case "degrees F":
+		unit = "F"
+	}
+	return value, unit, true
+}
+
+// powerSupplyFailed reports whether a power supply's discrete state shows a
+// failure or lost input or redundancy
+func powerSupplyFailed(reading string) bool {
+	reading = strings.ToLower(reading)
+	for _, failure := range []string{"failure", "lost", "out-of-range"} {
+		if strings.Contains(reading, failure) {
+			return true
+		}
+	}
+	return false
+}
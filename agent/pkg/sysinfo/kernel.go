@@ -0,0 +1,201 @@
+// Package sysinfo provides kernel and installed package information for
+// vulnerability tracking.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// packageQueryTimeout bounds a dpkg or rpm query, which can be slow on hosts
+// with many packages or a locked database
+const packageQueryTimeout = 30 * time.Second
+
+// Package managers a package inventory can come from
+const (
+	PackageManagerDpkg = "dpkg"
+	PackageManagerRPM  = "rpm"
+)
+
+// Kernel describes the running kernel and the kernels installed next to it
+type Kernel struct {
+	Release string `json:"release"`
+	Version string `json:"version,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+
+	// Installed lists the releases with modules in /lib/modules; Latest is
+	// the newest of them, and RebootRequired is set when it isn't running
+	Installed      []string `json:"installed,omitempty"`
+	Latest         string   `json:"latest,omitempty"`
+	RebootRequired bool     `json:"reboot_required"`
+}
+
+// Package is an installed package
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+}
+
+// KernelInfo returns the running kernel release, version and command line,
+// and the installed kernels. It returns nil if the release is unknown.
+func KernelInfo() *Kernel {
+	kernel := &Kernel{
+		Release: readProcValue("/proc/sys/kernel/osrelease"),
+		Version: readProcValue("/proc/sys/kernel/version"),
+		Cmdline: readProcValue("/proc/cmdline"),
+	}
+	if kernel.Release == "" {
+		if out, err := exec.Command("uname", "-r").Output(); err == nil {
+			kernel.Release = strings.TrimSpace(string(out))
+		}
+	}
+	if kernel.Release == "" {
+		return nil
+	}
+
+	if runtime.GOOS == "linux" {
+		kernel.Installed = installedKernels()
+		for _, release := range kernel.Installed {
+			if kernel.Latest == "" || compareVersions(release, kernel.Latest) > 0 {
+				kernel.Latest = release
+			}
+		}
+		kernel.RebootRequired = kernel.Latest != "" && kernel.Latest != kernel.Release
+	}
+	return kernel
+}
+
+// readProcValue returns the trimmed content of a /proc file, or "" if it
+// can't be read
+func readProcValue(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// installedKernels returns the kernel releases with a modules directory,
+// which every distribution installs alongside the kernel image
+func installedKernels() []string {
+	entries, err := os.ReadDir("/lib/modules")
+	if err != nil {
+		return nil
+	}
+
+	var releases []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// Leftover directories of removed kernels hold no modules.dep
+		if _, err := os.Stat(filepath.Join("/lib/modules", entry.Name(), "modules.dep")); err == nil {
+			releases = append(releases, entry.Name())
+		}
+	}
+	return releases
+}
+
+// compareVersions compares dotted versions such as 5.15.0-91-generic,
+// numeric parts by value and others as text
+func compareVersions(a, b string) int {
+	split := func(version string) []string {
+		return strings.FieldsFunc(version, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+	}
+	x, y := split(a), split(b)
+	for i := 0; i < len(x) && i < len(y); i++ {
+		m, errM := strconv.Atoi(x[i])
+		n, errN := strconv.Atoi(y[i])
+		switch {
+		case errM == nil && errN == nil && m != n:
+			if m < n {
+				return -1
+			}
+			return 1
+		case (errM != nil || errN != nil) && x[i] != y[i]:
+			return strings.Compare(x[i], y[i])
+		}
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+// Packages returns the installed packages whose names match one of the
+// glob patterns, e.g. "openssl*" or "*" for all, with the package manager
+// they come from. Hosts without dpkg or rpm yield no packages and no error.
+func Packages(patterns []string) (string, []Package, error) {
+	if runtime.GOOS != "linux" || len(patterns) == 0 {
+		return "", nil, nil
+	}
+
+	manager, args := "", []string(nil)
+	if path, err := exec.LookPath("dpkg-query"); err == nil {
+		manager = PackageManagerDpkg
+		args = []string{path, "-W", "-f", "${db:Status-Abbrev}\t${Package}\t${Version}\t${Architecture}\n"}
+	} else if path, err := exec.LookPath("rpm"); err == nil {
+		manager = PackageManagerRPM
+		args = []string{path, "-qa", "--qf", "%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\n"}
+	} else {
+		return "", nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), packageQueryTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).Output()
+	if err != nil {
+		return manager, nil, err
+	}
+	return manager, parsePackages(manager, out, patterns), nil
+}
+
+// parsePackages parses "name<TAB>version<TAB>arch" lines, preceded by the
+// package status for dpkg, keeping installed packages matching a pattern
+func parsePackages(manager string, out []byte, patterns []string) []Package {
+	var packages []Package
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if manager == PackageManagerDpkg {
+			// dpkg also lists removed packages whose configuration is left
+			if len(fields) == 0 || !strings.HasPrefix(fields[0], "ii") {
+				continue
+			}
+			fields = fields[1:]
+		}
+		if len(fields) < 3 {
+			continue
+		}
+		pkg := Package{Name: fields[0], Version: fields[1], Arch: fields[2]}
+		if pkg.Arch == "(none)" {
+			pkg.Arch = ""
+		}
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, pkg.Name); matched {
+				packages = append(packages, pkg)
+				break
+			}
+		}
+	}
+	return packages
+}
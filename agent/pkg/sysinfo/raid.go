@@ -0,0 +1,314 @@
+// Package sysinfo provides per-controller RAID detail from storcli, megacli
+// and mdadm, so failing arrays can be caught.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// raidQueryTimeout bounds a RAID tool query; controller CLIs can hang on
+// busy or failing controllers
+const raidQueryTimeout = 20 * time.Second
+
+// RAID volume states, normalized across tools
+const (
+	RaidHealthy    = "healthy"
+	RaidDegraded   = "degraded"
+	RaidRebuilding = "rebuilding"
+	RaidFailed     = "failed"
+	RaidUnknown    = "unknown"
+)
+
+var (
+	// megacli lines opening an adapter's and a virtual drive's section
+	megacliAdapter = regexp.MustCompile(`^Adapter\s+(\d+)`)
+	megacliVolume  = regexp.MustCompile(`^Virtual Drive:\s*(\d+)`)
+
+	// mdstat lines naming an array, e.g. "md0 : active raid1 sda1[0] sdb1[1]"
+	mdstatArray = regexp.MustCompile(`^(md\S+)\s*:`)
+)
+
+// RaidController is a hardware RAID controller, or software RAID, and its
+// virtual disks
+type RaidController struct {
+	// Name is e.g. c0 for a storcli controller, a0 for a megacli adapter
+	// or md for software RAID
+	Name         string       `json:"name"`
+	Tool         string       `json:"tool"`
+	VirtualDisks []RaidVolume `json:"virtual_disks"`
+}
+
+// RaidVolume is a virtual disk of a controller. State is one of the
+// normalized states; Detail is the state as the tool reported it.
+type RaidVolume struct {
+	Name   string `json:"name"`
+	Level  string `json:"level,omitempty"`
+	Size   string `json:"size,omitempty"`
+	State  string `json:"state"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// RaidControllers returns the RAID controllers found through storcli, else
+// megacli, and the mdadm arrays. Hosts without these tools or without RAID
+// yield none, and Raid then remains the only RAID information.
+func RaidControllers() []RaidController {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	var controllers []RaidController
+	if path := lookPathAny("storcli64", "storcli"); path != "" {
+		if out, err := runRaidTool(path, "/call/vall", "show", "J"); err == nil {
+			controllers = append(controllers, parseStorcli(out)...)
+		}
+	} else if path := lookPathAny("MegaCli64", "megacli", "MegaCli"); path != "" {
+		if out, err := runRaidTool(path, "-LDInfo", "-Lall", "-aAll", "-NoLog"); err == nil {
+			controllers = append(controllers, parseMegacli(out)...)
+		}
+	}
+	if md := mdadmArrays(); md != nil {
+		controllers = append(controllers, *md)
+	}
+	return controllers
+}
+
+// lookPathAny returns the path of the first of the commands found
+func lookPathAny(names ...string) string {
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// runRaidTool runs a RAID tool with a timeout
+func runRaidTool(path string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), raidQueryTimeout)
+	defer cancel()
+
+	return exec.CommandContext(ctx, path, args...).Output()
+}
+
+// storcliOutput is the part of storcli's JSON output listing virtual drives
+type storcliOutput struct {
+	Controllers []struct {
+		CommandStatus struct {
+			Controller int    `json:"Controller"`
+			Status     string `json:"Status"`
+		} `json:"Command Status"`
+		ResponseData struct {
+			VirtualDrives []map[string]interface{} `json:"Virtual Drives"`
+		} `json:"Response Data"`
+	} `json:"Controllers"`
+}
+
+// parseStorcli parses the output of storcli /call/vall show J
+func parseStorcli(out []byte) []RaidController {
+	var parsed storcliOutput
+	if err := json.Unmarshal(out, &parsed); err != nil {
+		return nil
+	}
+
+	var controllers []RaidController
+	for _, c := range parsed.Controllers {
+		if c.CommandStatus.Status != "Success" {
+			continue
+		}
+		controller := RaidController{
+			Name:         fmt.Sprintf("c%d", c.CommandStatus.Controller),
+			Tool:         "storcli",
+			VirtualDisks: []RaidVolume{},
+		}
+		for _, vd := range c.ResponseData.VirtualDrives {
+			id, _ := vd["DG/VD"].(string)
+			level, _ := vd["TYPE"].(string)
+			size, _ := vd["Size"].(string)
+			state, _ := vd["State"].(string)
+			controller.VirtualDisks = append(controller.VirtualDisks, RaidVolume{
+				Name:   fmt.Sprintf("%s/v%s", controller.Name, id[strings.LastIndex(id, "/")+1:]),
+				Level:  level,
+				Size:   size,
+				State:  storcliState(state),
+				Detail: state,
+			})
+		}
+		controllers = append(controllers, controller)
+	}
+	return controllers
+}
+
+// storcliState normalizes a storcli virtual drive state
+func storcliState(state string) string {
+	switch state {
+	case "Optl":
+		return RaidHealthy
+	case "Dgrd", "Pdgd":
+		return RaidDegraded
+	case "Rec":
+		return RaidRebuilding
+	case "OfLn":
+		return RaidFailed
+	default:
+		return RaidUnknown
+	}
+}
+
+// parseMegacli parses the output of megacli -LDInfo -Lall -aAll. A volume
+// listing a rebuild among its ongoing operations counts as rebuilding.
+func parseMegacli(out []byte) []RaidController {
+	var controllers []RaidController
+	var controller *RaidController
+	var volume *RaidVolume
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := megacliAdapter.FindStringSubmatch(line); m != nil {
+			controllers = append(controllers, RaidController{Name: "a" + m[1], Tool: "megacli", VirtualDisks: []RaidVolume{}})
+			controller, volume = &controllers[len(controllers)-1], nil
+			continue
+		}
+		if controller == nil {
+			continue
+		}
+		if m := megacliVolume.FindStringSubmatch(line); m != nil {
+			controller.VirtualDisks = append(controller.VirtualDisks, RaidVolume{Name: controller.Name + "/v" + m[1], State: RaidUnknown})
+			volume = &controller.VirtualDisks[len(controller.VirtualDisks)-1]
+			continue
+		}
+		if volume == nil {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case key == "RAID Level":
+			volume.Level = megacliLevel(value)
+		case key == "Size":
+			volume.Size = value
+		case key == "State":
+			volume.Detail = value
+			volume.State = megacliState(value)
+		case key == "Rebuild" && volume.State == RaidDegraded:
+			volume.State = RaidRebuilding
+		}
+	}
+	return controllers
+}
+
+// megacliLevel turns "Primary-1, Secondary-0, RAID Level Qualifier-0" into RAID1
+func megacliLevel(value string) string {
+	primary, _, _ := strings.Cut(value, ",")
+	if level := strings.TrimPrefix(strings.TrimSpace(primary), "Primary-"); level != primary {
+		return "RAID" + level
+	}
+	return value
+}
+
+// megacliState normalizes a megacli virtual drive state
+func megacliState(state string) string {
+	switch state {
+	case "Optimal":
+		return RaidHealthy
+	case "Degraded", "Partially Degraded":
+		return RaidDegraded
+	case "Offline", "Failed":
+		return RaidFailed
+	default:
+		return RaidUnknown
+	}
+}
+
+// mdadmArrays returns the software RAID arrays listed in /proc/mdstat, with
+// the state mdadm --detail reports for each, or nil if there are none
+func mdadmArrays() *RaidController {
+	data, err := os.ReadFile("/proc/mdstat")
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if m := mdstatArray.FindStringSubmatch(line); m != nil {
+			names = append(names, m[1])
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	controller := &RaidController{Name: "md", Tool: "mdadm", VirtualDisks: []RaidVolume{}}
+	path := lookPathAny("mdadm")
+	for _, name := range names {
+		volume := RaidVolume{Name: name, State: RaidUnknown}
+		if path != "" {
+			if out, err := runRaidTool(path, "--detail", "/dev/"+name); err == nil {
+				volume = parseMdadmDetail(name, out)
+			}
+		}
+		controller.VirtualDisks = append(controller.VirtualDisks, volume)
+	}
+	return controller
+}
+
+// parseMdadmDetail parses the output of mdadm --detail for an array
+func parseMdadmDetail(name string, out []byte) RaidVolume {
+	volume := RaidVolume{Name: name, State: RaidUnknown}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, found := strings.Cut(scanner.Text(), " : ")
+		if !found {
+			continue
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch key {
+		case "Raid Level":
+			volume.Level = value
+		case "Array Size":
+			// "976630464 (931.39 GiB 1000.07 GB)"
+			if open, end := strings.Index(value, "("), strings.Index(value, ")"); open >= 0 && end > open {
+				volume.Size = value[open+1 : end]
+			} else {
+				volume.Size = value
+			}
+		case "State":
+			volume.Detail = value
+			volume.State = mdadmState(value)
+		}
+	}
+	return volume
+}
+
+// mdadmState normalizes an mdadm array state such as "clean, degraded, recovering"
+func mdadmState(state string) string {
+	state = strings.ToLower(state)
+	switch {
+	case strings.Contains(state, "failed") || strings.Contains(state, "inactive"):
+		return RaidFailed
+	case strings.Contains(state, "recovering") || strings.Contains(state, "resyncing") || strings.Contains(state, "reshaping"):
+		return RaidRebuilding
+	case strings.Contains(state, "degraded"):
+		return RaidDegraded
+	case strings.Contains(state, "clean") || strings.Contains(state, "active"):
+		return RaidHealthy
+	default:
+		return RaidUnknown
+	}
+}
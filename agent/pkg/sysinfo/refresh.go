@@ -0,0 +1,52 @@
+// Package sysinfo provides dropping cached readings and tool lookups, so
+// inventory is collected afresh, e.g. after hardware was changed.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"sync"
+	"time"
+)
+
+// toolLookup remembers where a tool was found, so hosts without it don't
+// search PATH every heartbeat, until Refresh forgets it
+type toolLookup struct {
+	mu     sync.Mutex
+	looked bool
+	path   string
+}
+
+// get returns the tool's path, looking it up with find the first time
+func (t *toolLookup) get(find func() string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.looked {
+		t.path = find()
+		t.looked = true
+	}
+	return t.path
+}
+
+// reset forgets the lookup
+func (t *toolLookup) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.looked = false
+	t.path = ""
+}
+
+// Refresh drops the cached BMC sensor readings and the lookups of
+// nvidia-smi and ipmitool, so the next collection reads everything afresh
+func Refresh() {
+	nvidiaSMI.reset()
+	ipmitool.reset()
+
+	ipmiSampleMu.Lock()
+	ipmiSample = nil
+	ipmiSampleTime = time.Time{}
+	ipmiSampleMu.Unlock()
+}
@@ -0,0 +1,125 @@
+// Package sysinfo provides sampling of CPU and memory usage between
+// heartbeats, summarised as min, average, max and 95th percentile so alert
+// rules can tell sustained load from momentary spikes.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Metrics the Sampler samples
+const (
+	SampledCPUUsage    = "cpu_usage"
+	SampledMemoryUsage = "memory_usage"
+)
+
+// DefaultMaxSamples caps the samples kept per metric between summaries;
+// the oldest are overwritten
+const DefaultMaxSamples = 720
+
+// MetricSummary is the spread of a metric's samples since the previous summary
+type MetricSummary struct {
+	Min     float64 `json:"min"`
+	Avg     float64 `json:"avg"`
+	Max     float64 `json:"max"`
+	P95     float64 `json:"p95"`
+	Samples int     `json:"samples"`
+}
+
+// Sampler samples CPU and memory usage into fixed-size rings. CPU usage is
+// computed between its own samples, so it doesn't disturb GetUsage.
+type Sampler struct {
+	mu      sync.Mutex
+	cpu     cpuSample
+	max     int
+	samples map[string][]float64
+	next    map[string]int
+}
+
+// NewSampler creates a sampler keeping at most maxSamples samples per metric
+func NewSampler(maxSamples int) *Sampler {
+	if maxSamples <= 0 {
+		maxSamples = DefaultMaxSamples
+	}
+	return &Sampler{
+		max:     maxSamples,
+		samples: make(map[string][]float64),
+		next:    make(map[string]int),
+	}
+}
+
+// Sample takes one sample of each metric; the first CPU sample only sets
+// the baseline
+func (s *Sampler) Sample() {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	current, ok := readCPUSample()
+	memory := memoryUsage()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ok {
+		if s.cpu.total != 0 {
+			s.addLocked(SampledCPUUsage, cpuBusy(s.cpu, current))
+		}
+		s.cpu = current
+	}
+	s.addLocked(SampledMemoryUsage, memory)
+}
+
+// addLocked adds a sample to a metric's ring; caller must hold s.mu
+func (s *Sampler) addLocked(metric string, value float64) {
+	ring := s.samples[metric]
+	if len(ring) < s.max {
+		s.samples[metric] = append(ring, value)
+		return
+	}
+	ring[s.next[metric]] = value
+	s.next[metric] = (s.next[metric] + 1) % s.max
+}
+
+// Summary summarises the samples taken since the previous call and starts
+// over; metrics without samples are left out
+func (s *Sampler) Summary() map[string]MetricSummary {
+	s.mu.Lock()
+	samples := s.samples
+	s.samples = make(map[string][]float64)
+	s.next = make(map[string]int)
+	s.mu.Unlock()
+
+	if len(samples) == 0 {
+		return nil
+	}
+	summary := make(map[string]MetricSummary, len(samples))
+	for metric, values := range samples {
+		summary[metric] = summarize(values)
+	}
+	return summary
+}
+
+// summarize returns the spread of values, using the nearest-rank 95th percentile
+func summarize(values []float64) MetricSummary {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	sum := 0.0
+	for _, value := range sorted {
+		sum += value
+	}
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	return MetricSummary{
+		Min:     sorted[0],
+		Avg:     sum / float64(len(sorted)),
+		Max:     sorted[len(sorted)-1],
+		P95:     sorted[rank],
+		Samples: len(sorted),
+	}
+}
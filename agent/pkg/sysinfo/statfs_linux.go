@@ -0,0 +1,23 @@
+// Package sysinfo provides filesystem statistics on Linux.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import "golang.org/x/sys/unix"
+
+// statfs returns the statistics of the filesystem mounted at path
+func statfs(path string) (fsStats, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return fsStats{}, err
+	}
+	blockSize := uint64(st.Bsize)
+	return fsStats{
+		size:      st.Blocks * blockSize,
+		free:      st.Bfree * blockSize,
+		available: st.Bavail * blockSize,
+		files:     st.Files,
+		filesFree: st.Ffree,
+	}, nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+// Package sysinfo provides filesystem statistics elsewhere.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import "errors"
+
+// statfs is unsupported outside Linux; Filesystems reports nothing there
+func statfs(path string) (fsStats, error) {
+	return fsStats{}, errors.New("filesystem statistics are not supported on this platform")
+}
@@ -6,6 +6,7 @@ package sysinfo
 
 import (
 	"fmt"
+	"net"
 	"os/exec"
 	"runtime"
 	"strings"
@@ -143,11 +144,13 @@ func IPMI() string {
 		if err == nil {
 			lines := strings.Split(string(out), "\n")
 			for _, line := range lines {
-				if strings.Contains(line, "IP Address") {
-					fields := strings.Fields(line)
-					if len(fields) > 3 {
-						return fields[3]
-					}
+				// Skip "IP Address Source" and keep only a parseable address
+				key, value, found := strings.Cut(line, ":")
+				if !found || strings.TrimSpace(key) != "IP Address" {
+					continue
+				}
+				if ip := strings.TrimSpace(value); net.ParseIP(ip) != nil {
+					return ip
 				}
 			}
 		}
@@ -216,8 +219,12 @@ func GetCPUInfo() map[string]interface{} {
 	}
 }
 
-// GetGPUInfos returns detailed GPU information
+// GetGPUInfos returns detailed GPU information, including NVLink topology
+// and MIG partitions
 func GetGPUInfos() []map[string]interface{} {
+	if gpus := GetDetailedGPUInfo(); len(gpus) > 0 {
+		return gpus
+	}
 	return []map[string]interface{}{}
 }
 
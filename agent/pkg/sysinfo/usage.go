@@ -0,0 +1,211 @@
+// Package sysinfo provides resource utilization sampling for heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sysinfo
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Usage represents point-in-time resource utilization
+type Usage struct {
+	CPUUsage       float64 `json:"cpu_usage"`
+	MemoryUsage    float64 `json:"memory_usage"`
+	DiskUsage      float64 `json:"disk_usage"`
+	NetworkRxBytes int64   `json:"network_rx_bytes"`
+	NetworkTxBytes int64   `json:"network_tx_bytes"`
+	UptimeSeconds  int64   `json:"uptime_seconds"`
+
+	// GPUs holds per-GPU utilization, empty on hosts without NVIDIA GPUs
+	GPUs []GPUUsage `json:"gpus,omitempty"`
+
+	// Sensors holds the BMC's power supply, fan and temperature sensors,
+	// empty on hosts without IPMI
+	Sensors []Sensor `json:"sensors,omitempty"`
+
+	// Filesystems holds the usage of each mounted filesystem keyed by mount
+	// point, and DiskIO the IO rates of each disk keyed by device name
+	Filesystems map[string]FilesystemUsage `json:"filesystems,omitempty"`
+	DiskIO      map[string]DiskIO          `json:"disk_io,omitempty"`
+
+	// Summary holds the spread of metrics sampled between heartbeats,
+	// keyed by metric name, see Sampler
+	Summary map[string]MetricSummary `json:"summary,omitempty"`
+}
+
+// cpuSample holds cumulative CPU jiffies from /proc/stat
+type cpuSample struct {
+	idle  uint64
+	total uint64
+}
+
+var (
+	lastCPUSample cpuSample
+	cpuSampleMu   sync.Mutex
+)
+
+// GetUsage returns current resource utilization percentages and counters.
+// CPU usage is computed against the previous call, so the first call reports 0.
+func GetUsage() Usage {
+	usage := Usage{}
+
+	if runtime.GOOS != "linux" {
+		return usage
+	}
+
+	usage.CPUUsage = cpuUsage()
+	usage.MemoryUsage = memoryUsage()
+	usage.DiskUsage = diskUsage("/")
+	usage.NetworkRxBytes, usage.NetworkTxBytes = networkBytes()
+	usage.UptimeSeconds = uptimeSeconds()
+	usage.GPUs = GetGPUUsage()
+	usage.Sensors = IPMISensors()
+	usage.Filesystems = Filesystems()
+	usage.DiskIO = DiskIOStats()
+
+	return usage
+}
+
+// cpuUsage returns the CPU busy percentage since the last sample
+func cpuUsage() float64 {
+	current, ok := readCPUSample()
+	if !ok {
+		return 0
+	}
+
+	cpuSampleMu.Lock()
+	previous := lastCPUSample
+	lastCPUSample = current
+	cpuSampleMu.Unlock()
+
+	return cpuBusy(previous, current)
+}
+
+// readCPUSample reads the cumulative CPU jiffies from /proc/stat
+func readCPUSample() (cpuSample, bool) {
+	data, err := os.ReadFile("/proc/stat")
+	if err != nil {
+		return cpuSample{}, false
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "cpu ") {
+		return cpuSample{}, false
+	}
+
+	var current cpuSample
+	for i, field := range strings.Fields(lines[0])[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		current.total += value
+		// idle and iowait columns
+		if i == 3 || i == 4 {
+			current.idle += value
+		}
+	}
+	return current, true
+}
+
+// cpuBusy returns the CPU busy percentage between two samples, 0 without
+// a previous sample
+func cpuBusy(previous, current cpuSample) float64 {
+	if previous.total == 0 || current.total <= previous.total {
+		return 0
+	}
+
+	totalDelta := float64(current.total - previous.total)
+	idleDelta := float64(current.idle - previous.idle)
+	return (totalDelta - idleDelta) / totalDelta * 100
+}
+
+// memoryUsage returns the used memory percentage from /proc/meminfo
+func memoryUsage() float64 {
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+
+	var total, available int64
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "MemTotal:") {
+			fmt.Sscanf(strings.TrimPrefix(line, "MemTotal:"), "%d", &total)
+		}
+		if strings.HasPrefix(line, "MemAvailable:") {
+			fmt.Sscanf(strings.TrimPrefix(line, "MemAvailable:"), "%d", &available)
+		}
+	}
+
+	if total == 0 {
+		return 0
+	}
+	return float64(total-available) / float64(total) * 100
+}
+
+// diskUsage returns the used space percentage of the filesystem at path
+func diskUsage(path string) float64 {
+	out, err := exec.Command("df", "-P", path).Output()
+	if err != nil {
+		return 0
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) < 2 {
+		return 0
+	}
+
+	fields := strings.Fields(lines[1])
+	if len(fields) < 5 {
+		return 0
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimRight(fields[4], "%"), 64)
+	if err != nil {
+		return 0
+	}
+	return percent
+}
+
+// networkBytes returns cumulative rx/tx bytes across non-loopback interfaces
+func networkBytes() (int64, int64) {
+	var rx, tx int64
+	for _, ifname := range GetNetcard() {
+		if ifname == "lo" {
+			continue
+		}
+		if value, err := readFileInt64(fmt.Sprintf("/sys/class/net/%s/statistics/rx_bytes", ifname)); err == nil {
+			rx += value
+		}
+		if value, err := readFileInt64(fmt.Sprintf("/sys/class/net/%s/statistics/tx_bytes", ifname)); err == nil {
+			tx += value
+		}
+	}
+	return rx, tx
+}
+
+// uptimeSeconds returns system uptime from /proc/uptime
+func uptimeSeconds() int64 {
+	data, err := os.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0
+	}
+
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+	return int64(uptime)
+}
@@ -0,0 +1,164 @@
+// Package logrotate provides a size- and age-rotated log file writer, shared
+// by the server and the agent.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package logrotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// File is an io.Writer that rotates the underlying file once it
+// exceeds maxSize bytes or is older than maxAge, keeping at most maxBackups
+// rotated files. It is safe for concurrent use.
+type File struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// NewFile opens path for appending. A zero maxSize or maxAge disables
+// that rotation trigger; a zero maxBackups keeps every rotated file.
+func NewFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*File, error) {
+	rf := &File{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// Write writes p to the current file, rotating first if needed
+func (rf *File) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.shouldRotate(int64(len(p))) {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (rf *File) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// shouldRotate reports whether writing n more bytes requires a rotation
+func (rf *File) shouldRotate(n int64) bool {
+	if rf.file == nil {
+		return true
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+n > rf.maxSize {
+		return true
+	}
+	if rf.maxAge > 0 && time.Since(rf.openedAt) > rf.maxAge {
+		return true
+	}
+	return false
+}
+
+// open opens the log file for appending
+func (rf *File) open() error {
+	file, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.openedAt = time.Now()
+	if info.Size() > 0 {
+		// Age an existing file from its last write
+		rf.openedAt = info.ModTime()
+	}
+	return nil
+}
+
+// rotate renames the current file with a timestamp suffix and opens a new one
+func (rf *File) rotate() error {
+	if rf.file != nil {
+		rf.file.Close()
+		rf.file = nil
+
+		backup := rf.path + "." + time.Now().Format("20060102-150405.000")
+		for i := 1; fileExists(backup); i++ {
+			backup = fmt.Sprintf("%s.%s-%d", rf.path, time.Now().Format("20060102-150405.000"), i)
+		}
+		if err := os.Rename(rf.path, backup); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate log file: %v", err)
+		}
+	}
+
+	if err := rf.open(); err != nil {
+		return err
+	}
+	rf.openedAt = time.Now()
+
+	rf.pruneBackups()
+	return nil
+}
+
+// pruneBackups removes the oldest rotated files beyond maxBackups
+func (rf *File) pruneBackups() {
+	if rf.maxBackups <= 0 {
+		return
+	}
+
+	backups, err := filepath.Glob(rf.path + ".*")
+	if err != nil || len(backups) <= rf.maxBackups {
+		return
+	}
+
+	// Timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for _, backup := range backups[:len(backups)-rf.maxBackups] {
+		os.Remove(backup)
+	}
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,89 @@
+// Package main provides the wiring of registry events to alert rules.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package main
+
+import (
+	"time"
+
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/alert"
+)
+
+// evaluateAgentAlerts evaluates what the registry learns about agents
+// against the alert rules: agents going offline, inventory changes, and the
+// metrics and hardware state in heartbeats
+func evaluateAgentAlerts(registry *core.Registry, alertMgr *alert.AlertManager) {
+	// Offline agents raise alerts unless covered by a maintenance window
+	registry.OnOffline(alertMgr.AgentOffline)
+
+	// Hardware inventory changes are evaluated against alert rules
+	registry.OnInventoryChange(func(agentID string, changes []core.InventoryChange) {
+		for _, change := range changes {
+			alertMgr.EvaluateRules(agentID, change.AlertData())
+		}
+	})
+
+	// Agent clocks drifting beyond --max-clock-skew are evaluated against alert rules
+	registry.OnClockSkew(func(agentID string, skew time.Duration) {
+		alertMgr.EvaluateRules(agentID, core.ClockSkewAlertData(skew))
+	})
+
+	// Machines registering with a taken hostname are evaluated against alert rules
+	registry.OnHostnameCollision(func(collision core.HostnameCollision) {
+		alertMgr.EvaluateRules(collision.AgentID, core.HostnameCollisionAlertData(collision))
+	})
+
+	// Kernels and packages reported at registration are evaluated against alert rules
+	registry.OnRegistered(func(agentID string) {
+		if agent := registry.Get(agentID); agent != nil && (agent.Kernel != nil || len(agent.Packages) > 0) {
+			alertMgr.EvaluateRules(agentID, core.KernelAlertData(agent))
+		}
+	})
+
+	// RAID state reported at registration, and each change of it in
+	// heartbeats, is evaluated against alert rules
+	registry.OnRegistered(func(agentID string) {
+		if agent := registry.Get(agentID); agent != nil && len(agent.RaidInfo) > 0 {
+			alertMgr.EvaluateRules(agentID, core.RaidAlertData(agent.RaidInfo))
+		}
+	})
+	registry.OnRaidChange(func(agentID string, controllers []core.RaidController) {
+		alertMgr.EvaluateRules(agentID, core.RaidAlertData(controllers))
+	})
+
+	// BMC sensors in heartbeats are evaluated against alert rules whenever
+	// one changes status
+	registry.OnSensorChange(func(agentID string, sensors []core.Sensor) {
+		alertMgr.EvaluateRules(agentID, core.SensorAlertData(sensors))
+	})
+
+	// Plugin metrics in heartbeats are evaluated against alert rules, except
+	// for agents evaluating the rules themselves, which report candidates
+	registry.OnCustomMetrics(func(agentID string, custom map[string]interface{}) {
+		if cfg := registry.Config(agentID); cfg != nil && cfg.AlertEvaluation == core.AlertEvaluationAgent {
+			return
+		}
+		alertMgr.EvaluateRules(agentID, core.CustomMetricsAlertData(custom))
+	})
+
+	// Filesystem usage and disk IO in heartbeats are evaluated against alert
+	// rules, except for agents evaluating the rules themselves
+	registry.OnStorageMetrics(func(agentID string, usage *core.AgentUsage) {
+		if cfg := registry.Config(agentID); cfg != nil && cfg.AlertEvaluation == core.AlertEvaluationAgent {
+			return
+		}
+		alertMgr.EvaluateRules(agentID, core.StorageAlertData(usage))
+	})
+
+	// CPU, memory and disk usage in heartbeats, with the summaries of agents
+	// sampling between heartbeats, are evaluated against alert rules, except
+	// for agents evaluating the rules themselves
+	registry.OnMetricsSummary(func(agentID string, usage *core.AgentUsage) {
+		if cfg := registry.Config(agentID); cfg != nil && cfg.AlertEvaluation == core.AlertEvaluationAgent {
+			return
+		}
+		alertMgr.EvaluateRules(agentID, core.MetricsSummaryAlertData(usage))
+	})
+}
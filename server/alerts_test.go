@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/api"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/alert"
+	"github.com/nerve/server/pkg/cluster"
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/metrics"
+	"github.com/nerve/server/pkg/security"
+	"github.com/nerve/server/pkg/storage"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// recordingNotifier records the alerts it is sent
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []*alert.Alert
+}
+
+func (n *recordingNotifier) Send(a *alert.Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func (n *recordingNotifier) Name() string {
+	return "recorder"
+}
+
+func (n *recordingNotifier) sent() []*alert.Alert {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return append([]*alert.Alert(nil), n.alerts...)
+}
+
+// harness is the server's API with in-memory storage, wired to its alert
+// rules as in main, and authenticating with tokens and RBAC
+type harness struct {
+	t           *testing.T
+	server      *httptest.Server
+	registry    *core.Registry
+	notifier    *recordingNotifier
+	tokens      *security.TokenManager
+	adminToken  string
+	agentTokens []string
+
+	// agentPermissions are the permissions of the agent role
+	agentPermissions []string
+}
+
+// The collector registers its metrics globally, so the harnesses share one
+var (
+	harnessMetricsOnce sync.Once
+	harnessMetrics     *metrics.MetricsCollector
+)
+
+func newHarness(t *testing.T, agents int) *harness {
+	gin.SetMode(gin.TestMode)
+	harnessMetricsOnce.Do(func() { harnessMetrics = metrics.NewMetricsCollector() })
+
+	logger := log.NewWithWriter(false, io.Discard)
+	registry := core.NewRegistry(storage.NewInMemory(), logger)
+	scheduler := core.NewScheduler(registry, logger)
+	alertMgr := alert.NewAlertManager()
+	notifier := &recordingNotifier{}
+	alertMgr.RegisterNotifier(notifier.Name(), notifier)
+	evaluateAgentAlerts(registry, alertMgr)
+
+	tokenManager := security.NewTokenManager(time.Hour, time.Hour)
+	auditLogger := security.NewAuditLogger(filepath.Join(t.TempDir(), "audit.log"))
+	permManager := security.NewPermissionManager()
+	apiRouter := api.NewAPIRouter(websocket.NewWebSocketManager(harnessMetrics), cluster.NewClusterManager(),
+		alertMgr, registry, scheduler, harnessMetrics, tokenManager, auditLogger)
+	apiRouter.SetPermissionManager(permManager)
+	router := gin.New()
+	apiRouter.SetupRoutes(router)
+
+	h := &harness{
+		t:        t,
+		server:   httptest.NewServer(router),
+		registry: registry,
+		notifier: notifier,
+		tokens:   tokenManager,
+	}
+	t.Cleanup(h.server.Close)
+
+	admin, err := tokenManager.CreateToken("harness-admin", "", []string{"*"}, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	h.adminToken = admin.Token
+	agentPermissions, err := permManager.RolePermissions("agent")
+	if err != nil {
+		t.Fatalf("RolePermissions: %v", err)
+	}
+	h.agentPermissions = agentPermissions
+	for i := 0; i < agents; i++ {
+		token, err := tokenManager.CreateToken(fmt.Sprintf("harness-agent-%d", i), "", agentPermissions, 0)
+		if err != nil {
+			t.Fatalf("CreateToken: %v", err)
+		}
+		h.agentTokens = append(h.agentTokens, token.Token)
+	}
+	return h
+}
+
+// do sends a request with a token and decodes the JSON response into out,
+// failing the test unless the response has the wanted status
+func (h *harness) do(method, path, token string, body interface{}, wantStatus int, out interface{}) {
+	h.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			h.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, h.server.URL+path, reader)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := h.server.Client().Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		h.t.Fatalf("%s %s = %d, want %d: %s", method, path, resp.StatusCode, wantStatus, data)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			h.t.Fatalf("%s %s: decode %s: %v", method, path, data, err)
+		}
+	}
+}
+
+// register registers fake agent i, returning the ID the server assigned
+func (h *harness) register(i int) string {
+	h.t.Helper()
+
+	var registered struct {
+		ID string `json:"id"`
+	}
+	h.do(http.MethodPost, "/api/agents/register", h.agentTokens[i], map[string]interface{}{
+		"hostname":      fmt.Sprintf("node-%d", i),
+		"sn":            fmt.Sprintf("SN-%d", i),
+		"cpu_logic":     8,
+		"agent_version": "1.0.0",
+	}, http.StatusOK, &registered)
+	if registered.ID == "" {
+		h.t.Fatalf("agent %d registered without an ID", i)
+	}
+	return registered.ID
+}
+
+// heartbeat sends a heartbeat of fake agent i reporting cpu usage
+func (h *harness) heartbeat(i int, agentID string, cpu float64) {
+	h.t.Helper()
+
+	h.do(http.MethodPost, "/api/agents/"+agentID+"/heartbeat", h.agentTokens[i], map[string]interface{}{
+		"status":  "online",
+		"metrics": map[string]interface{}{"cpu_usage": cpu, "memory_usage": 40.0},
+	}, http.StatusOK, nil)
+}
+
+// Agents register and heartbeat over HTTP; those crossing a rule's
+// threshold raise alerts that reach the notifier and can be resolved
+func TestAgentAlertsEndToEnd(t *testing.T) {
+	const agents = 4
+	h := newHarness(t, agents)
+
+	h.do(http.MethodPost, "/api/v1/alerts/rules", h.adminToken, map[string]interface{}{
+		"id":          "cpu-high",
+		"name":        "CPU high",
+		"description": "CPU usage above 90%",
+		"enabled":     true,
+		"severity":    "critical",
+		"conditions":  []map[string]interface{}{{"field": "cpu_usage", "operator": "gt", "value": 90}},
+		"actions":     []map[string]interface{}{{"type": "recorder", "enabled": true}},
+	}, http.StatusOK, nil)
+
+	ids := make([]string, agents)
+	for i := range ids {
+		ids[i] = h.register(i)
+		if agent := h.registry.Get(ids[i]); agent == nil || agent.Status != "online" {
+			t.Fatalf("agent %s not online after registering: %+v", ids[i], agent)
+		}
+	}
+
+	// Agents 0 and 1 stay below the threshold, 2 and 3 cross it
+	cpu := []float64{20, 90, 95, 99.5}
+	for i, id := range ids {
+		h.heartbeat(i, id, cpu[i])
+	}
+
+	var fired []string
+	for _, a := range h.notifier.sent() {
+		if a.RuleID != "cpu-high" || a.Severity != "critical" || a.Status != "active" {
+			t.Errorf("unexpected alert sent: %+v", a)
+		}
+		fired = append(fired, a.AgentID)
+	}
+	sort.Strings(fired)
+	if want := []string{ids[2], ids[3]}; fmt.Sprint(fired) != fmt.Sprint(want) {
+		t.Fatalf("notified alerts for %v, want %v", fired, want)
+	}
+
+	var listed struct {
+		Alerts []alert.Alert `json:"alerts"`
+		Total  int           `json:"total"`
+	}
+	h.do(http.MethodGet, "/api/v1/alerts/list", h.adminToken, nil, http.StatusOK, &listed)
+	if listed.Total != 2 {
+		t.Fatalf("listed %d alerts, want 2", listed.Total)
+	}
+
+	// Agents can't read or resolve alerts, administrators can
+	h.do(http.MethodPost, "/api/v1/alerts/"+listed.Alerts[0].ID+"/resolve", h.agentTokens[2], nil, http.StatusForbidden, nil)
+	h.do(http.MethodPost, "/api/v1/alerts/"+listed.Alerts[0].ID+"/resolve", h.adminToken, nil, http.StatusOK, nil)
+	h.do(http.MethodGet, "/api/v1/alerts/list", h.adminToken, nil, http.StatusOK, &listed)
+	resolved := 0
+	for _, a := range listed.Alerts {
+		if a.Status == "resolved" {
+			resolved++
+		}
+	}
+	if resolved != 1 {
+		t.Errorf("%d alerts resolved, want 1", resolved)
+	}
+}
+
+// Heartbeats and registrations without a token are refused before they
+// reach the registry or the alert rules
+func TestAgentRequestsNeedToken(t *testing.T) {
+	h := newHarness(t, 1)
+
+	h.do(http.MethodPost, "/api/agents/register", "", map[string]interface{}{"hostname": "node-0"}, http.StatusUnauthorized, nil)
+	h.do(http.MethodPost, "/api/agents/node-0/heartbeat", "not-a-token", map[string]interface{}{
+		"metrics": map[string]interface{}{"cpu_usage": 99.0},
+	}, http.StatusUnauthorized, nil)
+
+	if agents := h.registry.List(); len(agents) != 0 {
+		t.Errorf("%d agents registered without a token", len(agents))
+	}
+	if sent := h.notifier.sent(); len(sent) != 0 {
+		t.Errorf("%d alerts sent", len(sent))
+	}
+}
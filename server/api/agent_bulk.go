@@ -0,0 +1,271 @@
+// Package api provides bulk restart, delete and status operations on agents.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+const (
+	// maxBulkAgents caps the number of agents a single bulk request may target
+	maxBulkAgents = 1000
+
+	// bulkWorkers bounds how many agents are processed concurrently
+	bulkWorkers = 16
+)
+
+// BulkAgentRequest targets agents either by ID or by selector
+type BulkAgentRequest struct {
+	AgentIDs []string            `json:"agent_ids,omitempty"`
+	Selector *core.AgentSelector `json:"selector,omitempty"`
+
+	// Status and Reason are used by the bulk status operation
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+
+	// DryRun resolves and returns the target agents without changing them
+	DryRun bool `json:"dry_run,omitempty"`
+}
+
+// BulkAgentResult is the outcome of a bulk operation on one agent
+type BulkAgentResult struct {
+	AgentID string `json:"agent_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// bulkRestartAgents restarts a set of agents
+func (r *APIRouter) bulkRestartAgents(c *gin.Context) {
+	r.runBulkAgentOperation(c, nil, func(agentID string, req *BulkAgentRequest) error {
+		return r.restartAgentByID(agentID)
+	})
+}
+
+// bulkDeleteAgents deregisters a set of agents
+func (r *APIRouter) bulkDeleteAgents(c *gin.Context) {
+	r.runBulkAgentOperation(c, nil, func(agentID string, req *BulkAgentRequest) error {
+		return r.removeAgentByID(agentID)
+	})
+}
+
+// bulkUpdateAgentStatus sets the status of a set of agents
+func (r *APIRouter) bulkUpdateAgentStatus(c *gin.Context) {
+	validate := func(req *BulkAgentRequest) error {
+		if !contains(validAgentStatuses, req.Status) {
+			return errInvalidAgentStatus
+		}
+		return nil
+	}
+	r.runBulkAgentOperation(c, validate, func(agentID string, req *BulkAgentRequest) error {
+		return r.setAgentStatusByID(agentID, req.Status)
+	})
+}
+
+// runBulkAgentOperation binds and validates a bulk request, resolves its
+// target agents and applies op to each with a bounded worker pool
+func (r *APIRouter) runBulkAgentOperation(c *gin.Context, validate func(*BulkAgentRequest) error, op func(agentID string, req *BulkAgentRequest) error) {
+	var req BulkAgentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	if validate != nil {
+		if err := validate(&req); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+	}
+
+	agentIDs, err := r.resolveBulkTargets(&req)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if req.DryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":        true,
+			"agents":         agentIDs,
+			"unknown_agents": r.unknownAgents(agentIDs),
+			"total":          len(agentIDs),
+		})
+		return
+	}
+
+	results := make([]BulkAgentResult, len(agentIDs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	workers := bulkWorkers
+	if len(agentIDs) < workers {
+		workers = len(agentIDs)
+	}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := BulkAgentResult{AgentID: agentIDs[i], Success: true}
+				if err := op(agentIDs[i], &req); err != nil {
+					result.Success = false
+					result.Error = err.Error()
+				}
+				results[i] = result
+			}
+		}()
+	}
+	for i := range agentIDs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"total":     len(results),
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	})
+}
+
+// resolveBulkTargets returns the de-duplicated agent IDs targeted by a bulk request
+func (r *APIRouter) resolveBulkTargets(req *BulkAgentRequest) ([]string, error) {
+	if len(req.AgentIDs) > 0 && req.Selector != nil {
+		return nil, fmt.Errorf("specify either agent_ids or selector, not both")
+	}
+
+	var agentIDs []string
+	switch {
+	case len(req.AgentIDs) > 0:
+		seen := make(map[string]bool, len(req.AgentIDs))
+		for _, id := range req.AgentIDs {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				agentIDs = append(agentIDs, id)
+			}
+		}
+	case req.Selector != nil:
+		ids, err := r.selectAgents(req.Selector)
+		if err != nil {
+			return nil, err
+		}
+		agentIDs = ids
+	default:
+		return nil, fmt.Errorf("agent_ids or selector is required")
+	}
+
+	if len(agentIDs) == 0 {
+		return nil, fmt.Errorf("no agents matched")
+	}
+	if len(agentIDs) > maxBulkAgents {
+		return nil, fmt.Errorf("bulk operations are limited to %d agents, got %d", maxBulkAgents, len(agentIDs))
+	}
+	return agentIDs, nil
+}
+
+// selectAgents returns the IDs of registered agents matching the selector
+func (r *APIRouter) selectAgents(selector *core.AgentSelector) ([]string, error) {
+	if r.registry == nil {
+		return nil, nil
+	}
+	return r.registry.SelectAgents(selector, r.clusterMembers)
+}
+
+// agentFilters are the query parameters filtering the agent list and export
+var agentFilters = []string{"status", "cluster", "gpu_type"}
+
+// filterAgents returns the sorted IDs of registered agents matching the
+// filters in the query, or all of them
+func (r *APIRouter) filterAgents(c *gin.Context) ([]string, error) {
+	labels := make(map[string]string)
+	for _, key := range agentFilters {
+		if value := c.Query(key); value != "" {
+			labels[key] = value
+		}
+	}
+	selector, err := core.ParseAgentSelector(labels)
+	if err != nil {
+		return nil, err
+	}
+	return r.selectAgents(selector)
+}
+
+// unknownAgents returns the IDs that aren't registered agents
+func (r *APIRouter) unknownAgents(agentIDs []string) []string {
+	unknown := []string{}
+	if r.registry == nil {
+		return unknown
+	}
+	for _, id := range agentIDs {
+		if r.registry.Get(id) == nil {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown
+}
+
+// unsupportedAgents returns the registered agents that lack the capability
+// to run tasks of the given type
+func (r *APIRouter) unsupportedAgents(agentIDs []string, taskType string) []string {
+	if r.registry == nil {
+		return []string{}
+	}
+	return r.registry.UnsupportedAgents(agentIDs, taskType)
+}
+
+// heldAgents returns the registered agents among agentIDs whose tasks are
+// held back by their status, each with a note such as "skipped: maintenance"
+func (r *APIRouter) heldAgents(agentIDs []string) map[string]string {
+	held := map[string]string{}
+	if r.registry == nil {
+		return held
+	}
+	for id, status := range r.registry.HeldAgents(agentIDs) {
+		held[id] = "skipped: " + status
+	}
+	return held
+}
+
+// withoutAgents returns agentIDs minus the IDs in excluded
+func withoutAgents(agentIDs, excluded []string) []string {
+	if len(excluded) == 0 {
+		return agentIDs
+	}
+	skip := make(map[string]bool, len(excluded))
+	for _, id := range excluded {
+		skip[id] = true
+	}
+	kept := []string{}
+	for _, id := range agentIDs {
+		if !skip[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
+}
+
+// clusterMembers returns the agent IDs of a cluster
+func (r *APIRouter) clusterMembers(clusterID string) ([]string, error) {
+	if r.clusterMgr == nil {
+		return nil, fmt.Errorf("cluster %s not found", clusterID)
+	}
+	cluster, err := r.clusterMgr.GetCluster(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return cluster.Agents, nil
+}
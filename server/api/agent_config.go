@@ -0,0 +1,119 @@
+// Package api provides handlers for pushing versioned configuration to agents.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// setAgentConfig stores the desired configuration of an agent and pushes it
+// over the agent's WebSocket control channel if connected
+func (r *APIRouter) setAgentConfig(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var cfg core.AgentConfig
+	if err := c.ShouldBindJSON(&cfg); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	var agent *core.AgentInfo
+	if r.registry != nil {
+		agent = r.registry.Get(agentID)
+	}
+	if agent == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+	if cfg.AlertEvaluation == core.AlertEvaluationAgent && !agent.HasCapability(core.CapabilityAgentAlerts) {
+		apierror.Respond(c, apierror.UnsupportedTask, "agent does not support evaluating alert rules")
+		return
+	}
+
+	stored, err := r.registry.SetConfig(agentID, cfg)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	delivered := r.pushAgentConfig(agentID, stored)
+	r.pushAgentRules(agentID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "Config stored",
+		"agent_id":  agentID,
+		"config":    stored,
+		"delivered": delivered,
+	})
+}
+
+// getAgentConfig returns the desired configuration of an agent and the
+// version it last acknowledged
+func (r *APIRouter) getAgentConfig(c *gin.Context) {
+	agentID := c.Param("id")
+
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id": agentID,
+		"config":   r.registry.Config(agentID),
+		"status":   r.registry.ConfigStatus(agentID),
+	})
+}
+
+// pushAgentConfig sends a config control message and reports whether the
+// agent's control channel accepted it
+func (r *APIRouter) pushAgentConfig(agentID string, cfg *core.AgentConfig) bool {
+	if r.wsManager == nil || cfg == nil {
+		return false
+	}
+
+	var data map[string]interface{}
+	raw, _ := json.Marshal(cfg)
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return false
+	}
+
+	message, err := websocket.NewWebSocketMessage("config", agentID, data).ToJSON()
+	if err != nil {
+		return false
+	}
+	return r.wsManager.SendToAgent(agentID, message)
+}
+
+// resendAgentConfig re-pushes the desired configuration, and the alert rules
+// of agents evaluating them, when an agent connects, since a restarted agent
+// starts again from its command-line flags
+func (r *APIRouter) resendAgentConfig(agentID string) {
+	if r.registry == nil {
+		return
+	}
+	r.pushAgentConfig(agentID, r.registry.Config(agentID))
+	r.pushAgentRules(agentID)
+}
+
+// handleConfigAck records the configuration version applied by an agent
+func (r *APIRouter) handleConfigAck(client *websocket.Client, msg *websocket.WebSocketMessage) {
+	if r.registry == nil || msg.AgentID == "" {
+		return
+	}
+
+	version, _ := msg.Data["version"].(float64)
+	errMsg, _ := msg.Data["error"].(string)
+	if applied, _ := msg.Data["applied"].(bool); !applied && errMsg == "" {
+		errMsg = "config not applied"
+	}
+
+	r.registry.AckConfig(msg.AgentID, int64(version), errMsg)
+}
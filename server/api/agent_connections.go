@@ -0,0 +1,98 @@
+// Package api provides handlers comparing registered agents with live control
+// channels, and the announcement agents send when a channel opens.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// listConnectedAgents returns the agents with an open WebSocket control
+// channel, and the registered agents without one
+func (r *APIRouter) listConnectedAgents(c *gin.Context) {
+	var connectedIDs []string
+	if r.wsManager != nil {
+		connectedIDs = r.wsManager.GetConnectedAgents()
+	}
+	sort.Strings(connectedIDs)
+
+	connected := make([]gin.H, 0, len(connectedIDs))
+	isConnected := make(map[string]bool, len(connectedIDs))
+	for _, agentID := range connectedIDs {
+		isConnected[agentID] = true
+
+		entry := gin.H{"id": agentID, "registered": false}
+		if r.registry != nil {
+			if agent := r.registry.Get(agentID); agent != nil {
+				entry["registered"] = true
+				entry["hostname"] = agent.Hostname
+				entry["status"] = agent.Status
+				entry["last_seen"] = agent.LastSeen
+				entry["last_ping"] = agent.LastPing
+			}
+		}
+		connected = append(connected, entry)
+	}
+
+	// Registered agents that heartbeat without a control channel can't
+	// receive pushed config or commands
+	disconnected := make([]gin.H, 0)
+	registered := 0
+	if r.registry != nil {
+		agents := r.registry.List()
+		registered = len(agents)
+		sort.Slice(agents, func(i, j int) bool { return agents[i].ID < agents[j].ID })
+		for _, agent := range agents {
+			if isConnected[agent.ID] {
+				continue
+			}
+			disconnected = append(disconnected, gin.H{
+				"id":        agent.ID,
+				"hostname":  agent.Hostname,
+				"status":    agent.Status,
+				"last_seen": agent.LastSeen,
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"connected":        connected,
+		"disconnected":     disconnected,
+		"total_registered": registered,
+		"total_connected":  len(connected),
+	})
+}
+
+// handleHello records the capabilities an agent announces when its control
+// channel opens, and asks an agent the registry doesn't know, e.g. after a
+// restart without persistent storage, to register again
+func (r *APIRouter) handleHello(client *websocket.Client, msg *websocket.WebSocketMessage) {
+	// Trust the agent ID of the connection, not of the message
+	agentID := client.AgentID
+	if r.registry == nil || agentID == "" {
+		return
+	}
+
+	var capabilities []string
+	list, _ := msg.Data["capabilities"].([]interface{})
+	for _, item := range list {
+		if capability, ok := item.(string); ok {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	version, _ := msg.Data["agent_version"].(string)
+
+	// An invalid announcement keeps the capabilities the agent registered with
+	known, err := r.registry.Announce(agentID, capabilities, version)
+	if err == nil && !known {
+		if message, err := websocket.NewWebSocketMessage("reregister", agentID, nil).ToJSON(); err == nil {
+			r.wsManager.DeliverToAgent(agentID, message)
+		}
+	}
+}
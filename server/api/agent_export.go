@@ -0,0 +1,249 @@
+// Package api provides the export of the fleet inventory.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// exportFlushEvery is how many rows are written between flushes, so large
+// exports reach the client as they're produced
+const exportFlushEvery = 100
+
+// exportColumn is a column of the inventory export
+type exportColumn struct {
+	name  string
+	value func(agent *core.AgentInfo, clusters []string) interface{}
+}
+
+// exportColumns are the columns an export may select, in their default order
+var exportColumns = []exportColumn{
+	{"id", func(a *core.AgentInfo, _ []string) interface{} { return a.ID }},
+	{"hostname", func(a *core.AgentInfo, _ []string) interface{} { return a.Hostname }},
+	{"sn", func(a *core.AgentInfo, _ []string) interface{} { return a.SN }},
+	{"status", func(a *core.AgentInfo, _ []string) interface{} { return a.Status }},
+	{"cpu_type", func(a *core.AgentInfo, _ []string) interface{} { return a.CPUType }},
+	{"cpu_logic", func(a *core.AgentInfo, _ []string) interface{} { return a.CPULogic }},
+	{"memory", func(a *core.AgentInfo, _ []string) interface{} { return a.Memory }},
+	{"gpu_num", func(a *core.AgentInfo, _ []string) interface{} { return a.GPUNum }},
+	{"gpu_type", func(a *core.AgentInfo, _ []string) interface{} { return a.GPUType }},
+	{"os", func(a *core.AgentInfo, _ []string) interface{} { return a.OS }},
+	{"manageip", func(a *core.AgentInfo, _ []string) interface{} { return a.ManageIP }},
+	{"cluster", func(_ *core.AgentInfo, clusters []string) interface{} { return append([]string{}, clusters...) }},
+	{"last_seen", func(a *core.AgentInfo, _ []string) interface{} { return a.LastSeen }},
+}
+
+// defaultExportColumns are exported when no columns are selected
+var defaultExportColumns = []string{
+	"hostname", "sn", "cpu_type", "cpu_logic", "memory", "gpu_num", "gpu_type", "os", "cluster", "last_seen",
+}
+
+// exportAgents streams the inventory of the agents matching the list filters
+// as CSV or a JSON array. Rows are written one agent at a time, so the
+// response is never held in memory as a whole.
+//
+// GET /api/v1/agents/export?format=csv&columns=hostname,sn&status=online
+func (r *APIRouter) exportAgents(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		apierror.Respond(c, apierror.InvalidRequest, "format must be csv or json")
+		return
+	}
+
+	columns, err := parseExportColumns(c.Query("columns"))
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	agentIDs, err := r.filterAgents(c)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	clusters := r.agentClusters()
+
+	filename := fmt.Sprintf("agents-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+
+	// Agents removed since the IDs were selected are skipped
+	rows := func(write func(agent *core.AgentInfo) error) error {
+		for i, id := range agentIDs {
+			agent := r.registry.Get(id)
+			if agent == nil {
+				continue
+			}
+			if err := write(agent); err != nil {
+				return err
+			}
+			if (i+1)%exportFlushEvery == 0 {
+				c.Writer.Flush()
+			}
+		}
+		return nil
+	}
+
+	if format == "csv" {
+		err = writeCSVExport(c, columns, clusters, rows)
+	} else {
+		err = writeJSONExport(c, columns, clusters, rows)
+	}
+	if err != nil {
+		// Headers are out by now, the client sees a truncated export
+		c.Error(err)
+	}
+}
+
+// writeCSVExport writes a header row, then a row per agent
+func writeCSVExport(c *gin.Context, columns []exportColumn, clusters map[string][]string, rows func(func(*core.AgentInfo) error) error) error {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = column.name
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(columns))
+	err := rows(func(agent *core.AgentInfo) error {
+		for i, column := range columns {
+			record[i] = csvValue(column.value(agent, clusters[agent.ID]))
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+		// Hand buffered rows to the response before it's flushed
+		w.Flush()
+		return w.Error()
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// writeJSONExport writes a JSON array with an object per agent
+func writeJSONExport(c *gin.Context, columns []exportColumn, clusters map[string][]string, rows func(func(*core.AgentInfo) error) error) error {
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+
+	if _, err := c.Writer.WriteString("["); err != nil {
+		return err
+	}
+	first := true
+	err := rows(func(agent *core.AgentInfo) error {
+		row := make(map[string]interface{}, len(columns))
+		for _, column := range columns {
+			row[column.name] = column.value(agent, clusters[agent.ID])
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := c.Writer.WriteString(","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = c.Writer.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.Writer.WriteString("]\n")
+	return err
+}
+
+// csvValue formats a column value for a CSV cell
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case []string:
+		return strings.Join(v, ";")
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// parseExportColumns resolves a comma-separated column selection, or the
+// default columns if empty
+func parseExportColumns(selection string) ([]exportColumn, error) {
+	names := defaultExportColumns
+	if selection != "" {
+		names = strings.Split(selection, ",")
+	}
+
+	columns := make([]exportColumn, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		column, ok := findExportColumn(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		seen[name] = true
+		columns = append(columns, column)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+	return columns, nil
+}
+
+// findExportColumn looks up an export column by name
+func findExportColumn(name string) (exportColumn, bool) {
+	for _, column := range exportColumns {
+		if column.name == name {
+			return column, true
+		}
+	}
+	return exportColumn{}, false
+}
+
+// agentClusters returns the sorted IDs of the clusters of each agent
+func (r *APIRouter) agentClusters() map[string][]string {
+	clusters := make(map[string][]string)
+	if r.clusterMgr == nil {
+		return clusters
+	}
+	for _, cluster := range r.clusterMgr.ListClusters() {
+		for _, agentID := range cluster.Agents {
+			clusters[agentID] = append(clusters[agentID], cluster.ID)
+		}
+	}
+	for _, ids := range clusters {
+		sort.Strings(ids)
+	}
+	return clusters
+}
@@ -0,0 +1,43 @@
+// Package api provides operator-set agent metadata endpoints.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// patchAgent merges operator metadata into an agent. Keys set to null are
+// removed; metadata is never overwritten by the agent's own reports.
+func (r *APIRouter) patchAgent(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var req struct {
+		Metadata map[string]*string `json:"metadata" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+
+	metadata, err := r.registry.PatchMetadata(agentID, req.Metadata)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Agent updated",
+		"agent_id": agentID,
+		"metadata": metadata,
+	})
+}
@@ -0,0 +1,81 @@
+// Package api provides pushing alert rules to agents that evaluate them
+// themselves, and handling the alert candidates they report.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"encoding/json"
+
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/alert"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// maxAlertCandidates caps the alert candidates taken from a heartbeat
+const maxAlertCandidates = 100
+
+// agentEvaluatesAlerts reports whether an agent's configuration has it
+// evaluate alert rules itself
+func (r *APIRouter) agentEvaluatesAlerts(agentID string) bool {
+	if r.registry == nil || r.alertMgr == nil {
+		return false
+	}
+	cfg := r.registry.Config(agentID)
+	return cfg != nil && cfg.AlertEvaluation == core.AlertEvaluationAgent
+}
+
+// agentRulesVersion returns the version of the rules an agent evaluating
+// alert rules should hold, empty for agents that don't evaluate them
+func (r *APIRouter) agentRulesVersion(agentID string) string {
+	if !r.agentEvaluatesAlerts(agentID) {
+		return ""
+	}
+	return r.alertMgr.AgentRules().Version
+}
+
+// pushAgentRules sends the alert rules in a rules_update control message to
+// an agent evaluating them, and reports whether its control channel
+// accepted it
+func (r *APIRouter) pushAgentRules(agentID string) bool {
+	if r.wsManager == nil || !r.agentEvaluatesAlerts(agentID) {
+		return false
+	}
+
+	var data map[string]interface{}
+	raw, _ := json.Marshal(r.alertMgr.AgentRules())
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return false
+	}
+
+	message, err := websocket.NewWebSocketMessage("rules_update", agentID, data).ToJSON()
+	if err != nil {
+		return false
+	}
+	return r.wsManager.SendToAgent(agentID, message)
+}
+
+// handleAgentAlerts raises alerts for the candidates in a heartbeat of an
+// agent evaluating alert rules, and pushes the rules again when the agent
+// reports a stale version of them
+func (r *APIRouter) handleAgentAlerts(agentID string, hb *core.Heartbeat) {
+	version := r.agentRulesVersion(agentID)
+	if agentID == "" || version == "" {
+		return
+	}
+
+	if len(hb.AlertCandidates) > 0 {
+		var candidates []alert.Candidate
+		if err := json.Unmarshal(hb.AlertCandidates, &candidates); err == nil {
+			if len(candidates) > maxAlertCandidates {
+				candidates = candidates[:maxAlertCandidates]
+			}
+			r.alertMgr.EvaluateCandidates(agentID, candidates)
+		}
+	}
+
+	if hb.RulesVersion != version {
+		r.pushAgentRules(agentID)
+	}
+}
@@ -0,0 +1,46 @@
+// Package api provides the checks that an agent's requests come with the
+// token the agent is bound to.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/security"
+)
+
+// heartbeatTokenMatches reports whether token is the one the agent a
+// heartbeat comes from registered with, returning the agent's ID. Agents
+// not registered yet match any token; a registered agent without a token
+// is bound to this one, as registering would.
+func (r *APIRouter) heartbeatTokenMatches(hb *core.Heartbeat, token string) (string, bool) {
+	agentID := r.registry.HeartbeatAgentID(hb)
+	if agentID == "" {
+		return "", true
+	}
+	bound := r.registry.ClaimToken(agentID, token)
+	return agentID, subtle.ConstantTimeCompare([]byte(bound), []byte(token)) == 1
+}
+
+// callerHoldsAgent reports whether the request's token was issued for an
+// agent or is the one the agent is bound to. Without authentication, an
+// agent not bound to a token accepts any caller.
+func (r *APIRouter) callerHoldsAgent(c *gin.Context, agentID string) bool {
+	tokenInfo := security.RequestTokenInfo(c)
+	if tokenInfo != nil && tokenInfo.AgentID == agentID {
+		return true
+	}
+
+	bound := ""
+	if r.registry != nil {
+		bound = r.registry.AgentToken(agentID)
+	}
+	if bound == "" {
+		return tokenInfo == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(bound), []byte(security.TokenFromRequest(c))) == 1
+}
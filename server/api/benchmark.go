@@ -0,0 +1,119 @@
+// Package api provides the endpoints that run built-in benchmarks on agents
+// and compare their results.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/security"
+)
+
+// benchmarkAgent schedules a built-in benchmark on an agent. It runs once
+// any benchmark already running on the agent has reported back.
+//
+// POST /api/v1/agents/:id/benchmark {"benchmark": "disk", "params": {"size_mb": 512}}
+func (r *APIRouter) benchmarkAgent(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var benchmarkRequest struct {
+		Benchmark string                 `json:"benchmark" binding:"required"`
+		Params    map[string]interface{} `json:"params"`
+	}
+	if err := c.ShouldBindJSON(&benchmarkRequest); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	if err := core.ValidateBenchmark(benchmarkRequest.Benchmark); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	var agent *core.AgentInfo
+	if r.registry != nil {
+		agent = r.registry.Get(agentID)
+	}
+	if agent == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+	if !agent.SupportsTask(core.CapabilityBenchmark) {
+		apierror.Respond(c, apierror.UnsupportedTask, "agent does not support benchmark tasks")
+		return
+	}
+
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.Unavailable, "scheduler not available")
+		return
+	}
+
+	task, err := r.scheduler.ScheduleBenchmark(agentID, benchmarkRequest.Benchmark, benchmarkRequest.Params, security.RequestIDFromContext(c))
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Benchmark scheduled",
+		"agent_id": agentID,
+		"task":     task,
+	})
+}
+
+// getAgentBenchmarks returns an agent's benchmark results, oldest first. For
+// each benchmark run at least twice, the change of the latest results from
+// the previous run is included for before/after comparisons.
+//
+// GET /api/v1/agents/:id/benchmarks?benchmark=disk
+func (r *APIRouter) getAgentBenchmarks(c *gin.Context) {
+	agentID := c.Param("id")
+
+	benchmark := c.Query("benchmark")
+	if benchmark != "" {
+		if err := core.ValidateBenchmark(benchmark); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+	}
+
+	if r.registry == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+	results := r.registry.BenchmarkResults(agentID, benchmark)
+	if len(results) == 0 && r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+
+	// Latest and previous result of each benchmark
+	latest := make(map[string]core.BenchmarkResult)
+	previous := make(map[string]core.BenchmarkResult)
+	for _, result := range results {
+		if last, ok := latest[result.Benchmark]; ok {
+			previous[result.Benchmark] = last
+		}
+		latest[result.Benchmark] = result
+	}
+	comparisons := gin.H{}
+	for name, before := range previous {
+		after := latest[name]
+		comparisons[name] = gin.H{
+			"previous_task_id": before.TaskID,
+			"latest_task_id":   after.TaskID,
+			"change_percent":   core.CompareBenchmarks(before, after),
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id":    agentID,
+		"results":     results,
+		"total":       len(results),
+		"comparisons": comparisons,
+	})
+}
@@ -0,0 +1,84 @@
+// Package api provides the agent capacity endpoint, which ranks agents by
+// free resources for placing workloads.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// listAgentCapacity returns the online agents ranked by free resources,
+// optionally filtered by the agent list filters and minimum free resources
+//
+// GET /api/v1/agents/capacity?min_free_gpus=&min_free_cpu=&min_free_memory_gb=&gpu_type=&cluster=&sort=&limit=
+func (r *APIRouter) listAgentCapacity(c *gin.Context) {
+	var filter core.CapacityFilter
+	if value := c.Query("min_free_gpus"); value != "" {
+		gpus, err := strconv.Atoi(value)
+		if err != nil || gpus < 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid min_free_gpus")
+			return
+		}
+		filter.MinFreeGPUs = gpus
+	}
+	if value := c.Query("min_free_cpu"); value != "" {
+		cores, err := strconv.ParseFloat(value, 64)
+		if err != nil || cores < 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid min_free_cpu")
+			return
+		}
+		filter.MinFreeCPUCores = cores
+	}
+	if value := c.Query("min_free_memory_gb"); value != "" {
+		memory, err := strconv.ParseFloat(value, 64)
+		if err != nil || memory < 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid min_free_memory_gb")
+			return
+		}
+		filter.MinFreeMemoryGB = memory
+	}
+
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	if r.registry == nil {
+		c.JSON(http.StatusOK, gin.H{"agents": []*core.AgentCapacity{}, "total": 0, "unreported": []string{}})
+		return
+	}
+
+	agentIDs, err := r.filterAgents(c)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	capacities, unreported, err := r.registry.Capacity(agentIDs, filter, c.Query("sort"))
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	total := len(capacities)
+	if limit > 0 && len(capacities) > limit {
+		capacities = capacities[:limit]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agents":     capacities,
+		"total":      total,
+		"unreported": unreported,
+	})
+}
@@ -0,0 +1,138 @@
+// Package api provides the workflow that retires an agent for good.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+const (
+	// defaultDecommissionWait is how long a decommission waits for the
+	// agent's in-flight tasks to report their results
+	defaultDecommissionWait = 5 * time.Minute
+
+	// maxDecommissionWait caps the wait an operator may ask for
+	maxDecommissionWait = time.Hour
+
+	// decommissionPoll is how often in-flight tasks are checked
+	decommissionPoll = time.Second
+)
+
+// decommissionAgent retires an agent: its token is revoked and rejected
+// from then on, queued tasks are cancelled and the agent is told to stop and
+// uninstall itself. The record is removed in the background once in-flight
+// tasks report back or the wait runs out. Agents sharing the token with
+// other agents are refused unless force is set, since they'd be cut off too.
+//
+// POST /api/v1/agents/:id/decommission?wait=300&uninstall=true&force=false
+func (r *APIRouter) decommissionAgent(c *gin.Context) {
+	agentID := c.Param("id")
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, errAgentNotFound.Error())
+		return
+	}
+
+	wait := defaultDecommissionWait
+	if value := c.Query("wait"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > maxDecommissionWait {
+			apierror.Respond(c, apierror.InvalidRequest, "wait must be between 0 and 3600 seconds")
+			return
+		}
+		wait = time.Duration(seconds) * time.Second
+	}
+	uninstall := c.DefaultQuery("uninstall", "true") != "false"
+
+	token := r.registry.AgentToken(agentID)
+	if shared := withoutAgents(r.registry.AgentsWithToken(token), []string{agentID}); token != "" && len(shared) > 0 && c.Query("force") != "true" {
+		apierror.RespondDetails(c, apierror.TokenShared,
+			"token is shared with other agents, which would be cut off as well; set force=true to proceed",
+			gin.H{"shared_with": shared})
+		return
+	}
+
+	if _, err := r.registry.Decommission(agentID); err != nil {
+		apierror.RespondError(c, err, apierror.AgentNotFound)
+		return
+	}
+	tokenRevoked := false
+	if token != "" && r.tokenManager != nil {
+		tokenRevoked = r.tokenManager.RevokeToken(token) == nil
+	}
+
+	cancelled := 0
+	if r.scheduler != nil {
+		cancelled = r.scheduler.CancelAgentTasks(agentID)
+	}
+
+	// Agents predating decommissioning would ignore the message
+	notified := false
+	if r.wsManager != nil && r.registry.HasCapability(agentID, core.CapabilityDecommission) {
+		message, err := websocket.NewWebSocketMessage("decommission", agentID, map[string]interface{}{
+			"uninstall": uninstall,
+		}).ToJSON()
+		if err == nil {
+			notified = r.wsManager.SendToAgent(agentID, message)
+		}
+	}
+
+	details := map[string]interface{}{
+		"token_retired":   token != "",
+		"token_revoked":   tokenRevoked,
+		"tasks_cancelled": cancelled,
+		"agent_notified":  notified,
+		"uninstall":       uninstall,
+		"wait_seconds":    int(wait / time.Second),
+	}
+	if r.auditLogger != nil {
+		r.auditLogger.LogDecommission(c, agentID, "started", details)
+	}
+
+	go r.finishDecommission(c.Copy(), agentID, wait)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"agent_id": agentID,
+		"status":   core.StatusDecommissioning,
+		"details":  details,
+	})
+}
+
+// finishDecommission waits for the agent's in-flight tasks to report back,
+// then removes the agent. c is a copy of the request context for auditing.
+func (r *APIRouter) finishDecommission(c *gin.Context, agentID string, wait time.Duration) {
+	deadline := time.Now().Add(wait)
+	running := 0
+	if r.scheduler != nil {
+		for {
+			running = r.scheduler.RunningTasks(agentID)
+			if running == 0 || !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(decommissionPoll)
+		}
+		// Retries queued while waiting would never run
+		r.scheduler.CancelAgentTasks(agentID)
+	}
+
+	result := "success"
+	if err := r.removeAgentByID(agentID); err != nil {
+		result = "failure"
+	} else if r.wsManager != nil {
+		r.wsManager.PublishEvent(websocket.EventAgentRemoved, agentID, map[string]interface{}{"reason": "decommissioned"})
+	}
+
+	if r.auditLogger != nil {
+		r.auditLogger.LogDecommission(c, agentID, result, map[string]interface{}{
+			"results_pending": running,
+		})
+	}
+}
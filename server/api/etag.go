@@ -0,0 +1,49 @@
+// Package api provides ETag-based conditional responses for polled resources.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// respondWithETag writes payload as JSON tagged with a hash of the encoded
+// body, so the ETag changes whenever any returned field does. A request whose
+// If-None-Match carries the current ETag gets 304 without a body.
+func respondWithETag(c *gin.Context, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	c.Header("ETag", etag)
+
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
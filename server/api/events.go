@@ -0,0 +1,64 @@
+// Package api provides the UI event stream and the checks applied to the
+// live log tails observers start on it.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/security"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// streamEvents opens the UI event stream. Connecting requires agents:read;
+// each log tail rechecks that the token still grants it, since a stream
+// outlives the check at upgrade, and is recorded in the audit log.
+//
+// GET /ws/events
+func (r *APIRouter) streamEvents(c *gin.Context) {
+	audit := c.Copy()
+	token := security.TokenFromRequest(c)
+
+	r.wsManager.HandleEventStreamWith(c, websocket.ObserverOptions{
+		AuthorizeLogTail: func(agentID, path string) error {
+			return r.authorizeLogTail(token, audit.ClientIP())
+		},
+		OnLogTail: func(record websocket.LogTailRecord) {
+			if r.auditLogger == nil {
+				return
+			}
+			details := map[string]interface{}{
+				"path":        record.Path,
+				"observer_id": record.ObserverID,
+			}
+			if record.ID != "" {
+				details["tail_id"] = record.ID
+			}
+			if record.Timeout > 0 {
+				details["timeout_seconds"] = int(record.Timeout.Seconds())
+			}
+			if record.Error != "" {
+				details["error"] = record.Error
+			}
+			r.auditLogger.LogLogTail(audit, record.AgentID, record.Result, details)
+		},
+	})
+}
+
+// authorizeLogTail checks a token still grants agents:read when RBAC is enforced
+func (r *APIRouter) authorizeLogTail(token, clientIP string) error {
+	if r.permissions == nil || r.tokenManager == nil {
+		return nil
+	}
+	tokenInfo, err := r.tokenManager.ValidateTokenFrom(token, clientIP)
+	if err != nil {
+		return fmt.Errorf("unauthorized: %v", err)
+	}
+	if !r.permissions.TokenAllows(tokenInfo, "agents", "read") {
+		return fmt.Errorf("insufficient permissions: log tails require agents:read")
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Package api provides interactive exec sessions on agents for operators.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+const (
+	// defaultExecCols and defaultExecRows size the terminal unless the
+	// client asks for another size
+	defaultExecCols = 80
+	defaultExecRows = 24
+
+	// maxExecTerminalSize caps the columns and rows of a terminal
+	maxExecTerminalSize = 1000
+
+	// maxExecShellLength caps the length of a requested shell path
+	maxExecShellLength = 256
+)
+
+// execAgent opens an interactive shell on an agent over a WebSocket. The
+// shell must be allowed by the agent, which runs it on a PTY; the session
+// ends when either side closes it or it stays idle too long. The start and
+// end of every session, and every refusal, are written to the audit log.
+//
+// GET /api/v1/agents/:id/exec?shell=/bin/bash&cols=120&rows=40 (WebSocket)
+func (r *APIRouter) execAgent(c *gin.Context) {
+	agentID := c.Param("id")
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, errAgentNotFound.Error())
+		return
+	}
+
+	req := websocket.ExecRequest{AgentID: agentID, Shell: c.Query("shell"), Cols: defaultExecCols, Rows: defaultExecRows}
+	if len(req.Shell) > maxExecShellLength {
+		apierror.Respond(c, apierror.InvalidRequest, "shell path is too long")
+		return
+	}
+	for name, size := range map[string]*int{"cols": &req.Cols, "rows": &req.Rows} {
+		if value := c.Query(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxExecTerminalSize {
+				apierror.Respond(c, apierror.InvalidRequest, name+" must be between 1 and 1000")
+				return
+			}
+			*size = n
+		}
+	}
+
+	audit := c.Copy()
+	deny := func(code apierror.Code, message string) {
+		if r.auditLogger != nil {
+			r.auditLogger.LogExec(audit, agentID, "denied", map[string]interface{}{"shell": req.Shell, "error": message})
+		}
+		apierror.Respond(c, code, message)
+	}
+	switch {
+	case r.wsManager == nil || !r.wsManager.ExecEnabled():
+		deny(apierror.Forbidden, websocket.ErrExecDisabled.Error())
+		return
+	case !r.registry.HasCapability(agentID, core.CapabilityExec):
+		deny(apierror.UnsupportedTask, "agent does not allow interactive exec")
+		return
+	case !r.wsManager.IsAgentConnected(agentID):
+		deny(apierror.Unavailable, "agent is not connected")
+		return
+	}
+
+	onStart := func(id string) {
+		if r.auditLogger != nil {
+			r.auditLogger.LogExec(audit, agentID, "started", map[string]interface{}{
+				"session_id": id,
+				"shell":      req.Shell,
+			})
+		}
+	}
+	onEnd := func(summary websocket.ExecSummary) {
+		if r.auditLogger == nil {
+			return
+		}
+		details := map[string]interface{}{
+			"session_id":       summary.ID,
+			"shell":            summary.Shell,
+			"reason":           summary.Reason,
+			"duration_seconds": int(summary.Duration.Seconds()),
+			"input_bytes":      summary.InputBytes,
+			"output_bytes":     summary.OutputBytes,
+		}
+		if summary.Error != "" {
+			details["error"] = summary.Error
+		}
+		if summary.ExitCode != nil {
+			details["exit_code"] = *summary.ExitCode
+		}
+		r.auditLogger.LogExec(audit, agentID, "ended", details)
+	}
+
+	if err := r.wsManager.HandleExecSession(c, req, onStart, onEnd); err != nil {
+		if errors.Is(err, websocket.ErrExecLimit) {
+			deny(apierror.RateLimited, err.Error())
+			return
+		}
+		deny(apierror.Forbidden, err.Error())
+	}
+}
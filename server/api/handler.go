@@ -7,6 +7,8 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/install"
 	"github.com/nerve/server/pkg/log"
 )
 
@@ -30,7 +32,7 @@ func NewHandler(registry *core.Registry, scheduler *core.Scheduler, logger log.L
 func (h *Handler) RegisterAgent(c *gin.Context) {
 	var agent core.AgentInfo
 	if err := c.ShouldBindJSON(&agent); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -42,7 +44,7 @@ func (h *Handler) RegisterAgent(c *gin.Context) {
 	// Validate token (simple check for now)
 	token := c.GetHeader("Authorization")
 	if !strings.HasPrefix(token, "Bearer ") {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+		apierror.Respond(c, apierror.Unauthorized, "invalid token")
 		return
 	}
 
@@ -60,7 +62,7 @@ func (h *Handler) RegisterAgent(c *gin.Context) {
 func (h *Handler) Heartbeat(c *gin.Context) {
 	var agentInfo core.AgentInfo
 	if err := c.ShouldBindJSON(&agentInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -89,7 +91,7 @@ func (h *Handler) GetTasks(c *gin.Context) {
 func (h *Handler) SubmitTaskResult(c *gin.Context) {
 	var result core.TaskResult
 	if err := c.ShouldBindJSON(&result); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -111,7 +113,7 @@ func (h *Handler) GetAgent(c *gin.Context) {
 	id := c.Param("id")
 	agent := h.registry.Get(id)
 	if agent == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
 		return
 	}
 
@@ -122,18 +124,31 @@ func (h *Handler) GetAgent(c *gin.Context) {
 func (h *Handler) InstallScript(c *gin.Context) {
 	token := c.Query("token")
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
+		apierror.Respond(c, apierror.InvalidRequest, "token required")
 		return
 	}
 
-	script := generateInstallScript(token)
+	opts, err := install.OptionsFromQuery(c)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	opts.Token = token
+	opts.ServerURL = c.Query("server")
+	opts.DownloadPath = "/api/download"
+
+	script, err := install.Render(opts)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
 	c.String(http.StatusOK, script)
 }
 
 // DownloadAgent returns the agent binary
 func (h *Handler) DownloadAgent(c *gin.Context) {
 	// TODO: Implement actual binary download
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "not implemented"})
+	apierror.Respond(c, apierror.NotImplemented, "not implemented")
 }
 
 
@@ -0,0 +1,136 @@
+// Package api provides batched agent heartbeats, for edge aggregators that
+// relay the heartbeats of many agents in one request.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/security"
+)
+
+const (
+	// maxHeartbeatBatch caps the heartbeats in one batch
+	maxHeartbeatBatch = 500
+
+	// maxHeartbeatBatchBytes caps the size of a batch request body
+	maxHeartbeatBatchBytes = 16 << 20
+)
+
+// HeartbeatBatchEntry is a heartbeat relayed on behalf of an agent, with
+// the agent's token
+type HeartbeatBatchEntry struct {
+	// Token is the agent's token; entries without one use the request's
+	Token string `json:"token,omitempty"`
+
+	core.Heartbeat
+}
+
+// HeartbeatBatchResult is the outcome of one heartbeat of a batch
+type HeartbeatBatchResult struct {
+	Index   int           `json:"index"`
+	AgentID string        `json:"agent_id,omitempty"`
+	Success bool          `json:"success"`
+	Code    apierror.Code `json:"code,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Resync  bool          `json:"resync,omitempty"`
+
+	// RulesVersion is the version of the alert rules the agent should hold,
+	// for agents evaluating alert rules
+	RulesVersion string `json:"rules_version,omitempty"`
+}
+
+// agentHeartbeatBatch records a batch of heartbeats. Each entry is decoded,
+// rate limited and checked against its token on its own, so one bad entry
+// fails only itself.
+func (r *APIRouter) agentHeartbeatBatch(c *gin.Context) {
+	var req struct {
+		Heartbeats []json.RawMessage `json:"heartbeats"`
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxHeartbeatBatchBytes)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		// Oversized bodies are reported as PAYLOAD_TOO_LARGE
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	if len(req.Heartbeats) == 0 {
+		apierror.Respond(c, apierror.InvalidRequest, "heartbeats is required")
+		return
+	}
+	if len(req.Heartbeats) > maxHeartbeatBatch {
+		apierror.Respond(c, apierror.InvalidRequest,
+			fmt.Sprintf("a batch is limited to %d heartbeats, got %d", maxHeartbeatBatch, len(req.Heartbeats)))
+		return
+	}
+
+	requestToken := security.TokenFromRequest(c)
+	results := make([]HeartbeatBatchResult, len(req.Heartbeats))
+	succeeded := 0
+	for i, raw := range req.Heartbeats {
+		results[i] = r.applyBatchHeartbeat(i, raw, requestToken)
+		if results[i].Success {
+			succeeded++
+		} else if r.metrics != nil {
+			r.metrics.RecordHeartbeat(false)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results":   results,
+		"total":     len(results),
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	})
+}
+
+// applyBatchHeartbeat validates and records one heartbeat of a batch
+func (r *APIRouter) applyBatchHeartbeat(index int, raw json.RawMessage, requestToken string) HeartbeatBatchResult {
+	result := HeartbeatBatchResult{Index: index}
+	fail := func(code apierror.Code, message string) HeartbeatBatchResult {
+		result.Code = code
+		result.Error = message
+		return result
+	}
+
+	var entry HeartbeatBatchEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return fail(apierror.InvalidRequest, fmt.Sprintf("invalid heartbeat: %v", err))
+	}
+	hb := &entry.Heartbeat
+	sender := hb.Sender()
+	if sender == "" {
+		return fail(apierror.InvalidRequest, "agent_id or system_info.hostname is required")
+	}
+	result.AgentID = hb.AgentID
+
+	if !r.agentRequestAllowed("heartbeat", sender) {
+		return fail(apierror.RateLimited, "rate limit exceeded")
+	}
+
+	token := entry.Token
+	if token == "" {
+		token = requestToken
+	}
+	if r.registry != nil {
+		if r.registry.TokenRetired(token) {
+			return fail(apierror.TokenRetired, "token belongs to a decommissioned agent")
+		}
+		// A relay may only report for agents it holds the token of
+		if agentID, ok := r.heartbeatTokenMatches(hb, token); !ok {
+			return fail(apierror.Forbidden, fmt.Sprintf("token does not belong to agent %s", agentID))
+		}
+	}
+
+	result.AgentID, result.Resync = r.applyHeartbeat(hb)
+	result.RulesVersion = r.agentRulesVersion(result.AgentID)
+	result.Success = true
+	return result
+}
@@ -0,0 +1,51 @@
+// Package api provides idempotency key handling for endpoints that create
+// tasks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+)
+
+const (
+	// idempotencyKeyHeader carries the key clients retry a request with
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader marks responses returning what an earlier
+	// request with the same key created
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// idempotencyKey returns the idempotency key of a request, from the header or
+// else the body, scoped to the caller so tokens can't see each other's tasks.
+// It returns "" for requests without a key.
+func idempotencyKey(c *gin.Context, bodyKey string) (string, error) {
+	key := c.GetHeader(idempotencyKeyHeader)
+	if key == "" {
+		key = bodyKey
+	}
+	if key == "" {
+		return "", nil
+	}
+	if err := core.ValidateIdempotencyKey(key); err != nil {
+		return "", err
+	}
+	return c.GetString("user_id") + "/" + key, nil
+}
+
+// requestFingerprint identifies a decoded request body, so a key reused with
+// a different request can be told apart from a retry
+func requestFingerprint(request interface{}) string {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+// Package api provides the fleet hardware summary for capacity planning.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+)
+
+// inventorySummaryTTL is how long a computed summary is served before the
+// registry is walked again
+const inventorySummaryTTL = 10 * time.Second
+
+// inventorySummaryCache keeps the last computed fleet summary
+type inventorySummaryCache struct {
+	mu      sync.Mutex
+	summary *core.FleetSummary
+}
+
+// getInventorySummary returns fleet-wide hardware totals: CPU cores, total
+// and used memory, GPUs by type, and agents by OS and by cluster. The
+// summary is cached for a few seconds; generated_at tells when it was made.
+//
+// GET /api/v1/system/inventory-summary
+func (r *APIRouter) getInventorySummary(c *gin.Context) {
+	if r.registry == nil {
+		c.JSON(http.StatusOK, gin.H{"summary": &core.FleetSummary{
+			GPUsByType:      map[string]int{},
+			AgentsByOS:      map[string]int{},
+			AgentsByCluster: map[string]int{},
+			GeneratedAt:     time.Now(),
+		}})
+		return
+	}
+
+	cache := &r.inventorySummary
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.summary == nil || time.Since(cache.summary.GeneratedAt) > inventorySummaryTTL {
+		clusters := make(map[string][]string)
+		if r.clusterMgr != nil {
+			for _, cluster := range r.clusterMgr.ListClusters() {
+				for _, agentID := range cluster.Agents {
+					clusters[agentID] = append(clusters[agentID], cluster.ID)
+				}
+			}
+		}
+		cache.summary = r.registry.FleetSummary(clusters)
+	}
+	c.JSON(http.StatusOK, gin.H{"summary": cache.summary})
+}
@@ -0,0 +1,38 @@
+// Package api provides the admin endpoint pruning the Prometheus series of
+// agents no longer in the registry.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// pruneMetrics deletes the per-agent series of agents no longer registered,
+// e.g. removed through another server sharing the registry
+func (r *APIRouter) pruneMetrics(c *gin.Context) {
+	if r.metrics == nil || r.registry == nil {
+		apierror.Respond(c, apierror.Unavailable, "metrics collector not available")
+		return
+	}
+
+	registered := make(map[string]bool)
+	for _, agent := range r.registry.List() {
+		registered[agent.ID] = true
+	}
+	agents, series := r.metrics.PruneAgentMetrics(func(agentID string) bool {
+		return registered[agentID]
+	})
+	if agents == nil {
+		agents = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agents":        agents,
+		"series_pruned": series,
+	})
+}
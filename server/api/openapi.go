@@ -0,0 +1,146 @@
+// Package api provides the OpenAPI specification and Swagger UI for Nerve Center Server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// openAPIBasePath is the router prefix covered by the specification
+const openAPIBasePath = "/api/v1"
+
+// openAPIBase holds the documented operations and schemas
+//
+//go:embed openapi.json
+var openAPIBase []byte
+
+var pathParamPattern = regexp.MustCompile(`:([A-Za-z0-9_]+)`)
+
+// openAPISpec builds the served specification once from the embedded
+// document and the routes registered on the router
+type openAPISpec struct {
+	once sync.Once
+	data []byte
+	err  error
+}
+
+// build merges registered /api/v1 routes that have no documented operation
+// into the specification so it never falls behind the router
+func (s *openAPISpec) build(routes gin.RoutesInfo) ([]byte, error) {
+	s.once.Do(func() {
+		var spec map[string]interface{}
+		if err := json.Unmarshal(openAPIBase, &spec); err != nil {
+			s.err = err
+			return
+		}
+
+		paths, _ := spec["paths"].(map[string]interface{})
+		if paths == nil {
+			paths = make(map[string]interface{})
+			spec["paths"] = paths
+		}
+
+		for _, route := range routes {
+			if !strings.HasPrefix(route.Path, openAPIBasePath+"/") {
+				continue
+			}
+
+			path := pathParamPattern.ReplaceAllString(strings.TrimPrefix(route.Path, openAPIBasePath), "{$1}")
+			method := strings.ToLower(route.Method)
+
+			item, _ := paths[path].(map[string]interface{})
+			if item == nil {
+				item = make(map[string]interface{})
+				paths[path] = item
+			}
+			if _, ok := item[method]; ok {
+				continue
+			}
+			item[method] = undocumentedOperation(route)
+		}
+
+		s.data, s.err = json.MarshalIndent(spec, "", "  ")
+	})
+	return s.data, s.err
+}
+
+// undocumentedOperation describes a route that is missing from openapi.json
+func undocumentedOperation(route gin.RouteInfo) map[string]interface{} {
+	op := map[string]interface{}{
+		"summary": route.Method + " " + route.Path,
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{"description": "OK"},
+		},
+	}
+
+	// First path segment after /api/v1 doubles as the tag, e.g. "agents" -> "Agents"
+	segment := strings.SplitN(strings.TrimPrefix(route.Path, openAPIBasePath+"/"), "/", 2)[0]
+	if segment != "" {
+		op["tags"] = []string{strings.ToUpper(segment[:1]) + segment[1:]}
+	}
+
+	var params []map[string]interface{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+		params = append(params, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	return op
+}
+
+// serveOpenAPI returns the OpenAPI specification as JSON
+func (r *APIRouter) serveOpenAPI(router *gin.Engine) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		data, err := r.openAPI.build(router.Routes())
+		if err != nil {
+			apierror.Respond(c, apierror.Internal, "Failed to build OpenAPI specification")
+			return
+		}
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+	}
+}
+
+// serveSwaggerUI renders Swagger UI for the OpenAPI specification
+func serveSwaggerUI(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(swaggerUIPage))
+}
+
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Nerve Center API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+  <script>
+    window.onload = function () {
+      window.ui = SwaggerUIBundle({
+        url: "/api/openapi.json",
+        dom_id: "#swagger-ui",
+        persistAuthorization: true
+      });
+    };
+  </script>
+</body>
+</html>
+`
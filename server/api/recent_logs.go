@@ -0,0 +1,78 @@
+// Package api provides the recent log lines agents keep in memory.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+const (
+	// defaultRecentLogLines is how many lines are returned unless the
+	// client asks for another number
+	defaultRecentLogLines = 100
+
+	// maxRecentLogLines caps the lines a client may ask for
+	maxRecentLogLines = 10000
+
+	// recentLogsTimeout is how long to wait for the agent's reply
+	recentLogsTimeout = 10 * time.Second
+)
+
+// getAgentRecentLogs returns the most recent lines of an agent's own log,
+// oldest first, from the buffer the agent keeps in memory. The agent redacts
+// credentials before sending them. Only agents connected to this server over
+// the control channel can be asked.
+//
+// GET /api/v1/agents/:id/logs?lines=200
+func (r *APIRouter) getAgentRecentLogs(c *gin.Context) {
+	agentID := c.Param("id")
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, errAgentNotFound.Error())
+		return
+	}
+
+	lines := defaultRecentLogLines
+	if value := c.Query("lines"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxRecentLogLines {
+			apierror.Respond(c, apierror.InvalidRequest, "lines must be between 1 and 10000")
+			return
+		}
+		lines = n
+	}
+
+	switch {
+	case !r.registry.HasCapability(agentID, core.CapabilityRecentLogs):
+		apierror.Respond(c, apierror.UnsupportedTask, "agent does not keep recent logs")
+		return
+	case r.wsManager == nil:
+		apierror.Respond(c, apierror.Unavailable, websocket.ErrAgentNotConnected.Error())
+		return
+	}
+
+	logs, err := r.wsManager.FetchRecentLogs(agentID, lines, recentLogsTimeout)
+	if err != nil {
+		if errors.Is(err, websocket.ErrAgentNotConnected) || errors.Is(err, websocket.ErrRecentLogsTimeout) {
+			apierror.Respond(c, apierror.Unavailable, err.Error())
+			return
+		}
+		apierror.Respond(c, apierror.Internal, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id": agentID,
+		"lines":    logs,
+		"total":    len(logs),
+	})
+}
@@ -0,0 +1,56 @@
+// Package api provides asking an agent to refresh its inventory now rather
+// than at its next heartbeat.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// refreshAgent sends a refresh control message, on which the agent drops
+// its cached readings and sends a heartbeat with its full inventory. Only
+// agents with an open control channel can be asked.
+//
+// POST /api/v1/agents/:id/refresh
+func (r *APIRouter) refreshAgent(c *gin.Context) {
+	agentID := c.Param("id")
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, errAgentNotFound.Error())
+		return
+	}
+	if !r.registry.HasCapability(agentID, core.CapabilityRefresh) {
+		apierror.Respond(c, apierror.UnsupportedTask, "agent does not support inventory refresh")
+		return
+	}
+
+	sent := false
+	if r.wsManager != nil {
+		if message, err := websocket.NewWebSocketMessage("refresh", agentID, nil).ToJSON(); err == nil {
+			sent = r.wsManager.SendToAgent(agentID, message)
+		}
+	}
+
+	result := "sent"
+	if !sent {
+		result = "not_connected"
+	}
+	if r.auditLogger != nil {
+		r.auditLogger.LogRefresh(c, agentID, result)
+	}
+	if !sent {
+		apierror.Respond(c, apierror.AgentNotConnected, "agent has no open control channel")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"agent_id": agentID,
+		"message":  "Refresh requested; the agent sends its full inventory in a heartbeat",
+	})
+}
@@ -0,0 +1,29 @@
+// Package api provides the admin endpoint reporting the data retention
+// policy and the last cleanup run.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/retention"
+)
+
+// SetRetentionManager enables the retention status endpoint
+func (r *APIRouter) SetRetentionManager(manager *retention.Manager) {
+	r.retention = manager
+}
+
+// getRetention returns the retention policy, the last cleanup run and when
+// the next one is due
+func (r *APIRouter) getRetention(c *gin.Context) {
+	if r.retention == nil {
+		apierror.Respond(c, apierror.Unavailable, "retention scheduler not available")
+		return
+	}
+	c.JSON(http.StatusOK, r.retention.Status())
+}
@@ -5,18 +5,28 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/nerve/server/core"
 	"github.com/nerve/server/pkg/alert"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/binary"
 	"github.com/nerve/server/pkg/cluster"
+	"github.com/nerve/server/pkg/install"
 	"github.com/nerve/server/pkg/metrics"
+	"github.com/nerve/server/pkg/retention"
+	"github.com/nerve/server/pkg/security"
+	"github.com/nerve/server/pkg/storage"
+	"github.com/nerve/server/pkg/webhook"
 	"github.com/nerve/server/pkg/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // APIRouter sets up all API routes
@@ -25,18 +35,100 @@ type APIRouter struct {
 	clusterMgr    *cluster.ClusterManager
 	alertMgr      *alert.AlertManager
 	registry      *core.Registry
+	scheduler     *core.Scheduler
+	metrics       *metrics.MetricsCollector
+	tokenManager  *security.TokenManager
+	auditLogger   *security.AuditLogger
+	openAPI       openAPISpec
+
+	// agentLimiter throttles registrations and heartbeats per agent
+	agentLimiter  *security.RateLimiter
+
+	// permissions enforces RBAC on the v1 routes; nil leaves them open
+	permissions   *security.PermissionManager
+
+	// webhooks delivers agent lifecycle events; nil disables the endpoints
+	webhooks      *webhook.Manager
+
+	// retention cleans up old data; nil disables its status endpoint
+	retention     *retention.Manager
+
+	// binaries resolves release channels for agent updates; nil requires
+	// updates to name a version
+	binaries      *binary.AgentBinaryManager
+
+	// inventorySummary caches the fleet hardware summary
+	inventorySummary inventorySummaryCache
 }
 
 // NewAPIRouter creates a new API router
-func NewAPIRouter(wsManager *websocket.WebSocketManager, clusterMgr *cluster.ClusterManager, alertMgr *alert.AlertManager, registry *core.Registry) *APIRouter {
+func NewAPIRouter(wsManager *websocket.WebSocketManager, clusterMgr *cluster.ClusterManager, alertMgr *alert.AlertManager, registry *core.Registry, scheduler *core.Scheduler, metricsCollector *metrics.MetricsCollector, tokenManager *security.TokenManager, auditLogger *security.AuditLogger) *APIRouter {
 	return &APIRouter{
-		wsManager:  wsManager,
-		clusterMgr: clusterMgr,
-		alertMgr:   alertMgr,
-		registry:   registry,
+		wsManager:    wsManager,
+		clusterMgr:   clusterMgr,
+		alertMgr:     alertMgr,
+		registry:     registry,
+		scheduler:    scheduler,
+		metrics:      metricsCollector,
+		tokenManager: tokenManager,
+		auditLogger:  auditLogger,
 	}
 }
 
+// SetAgentRateLimiter sets the limiter applied to agent registrations and heartbeats
+func (r *APIRouter) SetAgentRateLimiter(limiter *security.RateLimiter) {
+	r.agentLimiter = limiter
+}
+
+// SetPermissionManager enables token authentication and per-route RBAC on
+// the v1 routes. Without it they are open, as in auth-disabled dev mode.
+func (r *APIRouter) SetPermissionManager(pm *security.PermissionManager) {
+	r.permissions = pm
+}
+
+// SetBinaryManager lets agent updates follow a release channel instead of
+// naming a version
+func (r *APIRouter) SetBinaryManager(bm *binary.AgentBinaryManager) {
+	r.binaries = bm
+}
+
+// authenticate requires a valid token when RBAC is enforced
+func (r *APIRouter) authenticate() gin.HandlerFunc {
+	if r.permissions == nil || r.tokenManager == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return security.TokenAuthMiddleware(r.tokenManager)
+}
+
+// require checks the token grants the action on the resource when RBAC is enforced
+func (r *APIRouter) require(resource, action string) gin.HandlerFunc {
+	if r.permissions == nil || r.tokenManager == nil {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return security.PermissionMiddleware(r.permissions)(resource, action)
+}
+
+// allowAgentRequest applies the agent rate limit, answering 429 when key is over it
+func (r *APIRouter) allowAgentRequest(c *gin.Context, endpoint, key string) bool {
+	if r.agentRequestAllowed(endpoint, key) {
+		return true
+	}
+	apierror.Respond(c, apierror.RateLimited, "rate limit exceeded")
+	return false
+}
+
+// agentRequestAllowed reports whether an agent request is within the rate
+// limit, counting it as rate limited if not
+func (r *APIRouter) agentRequestAllowed(endpoint, key string) bool {
+	if r.agentLimiter.Allow(endpoint + ":" + key) {
+		return true
+	}
+	if r.metrics != nil {
+		r.metrics.RecordRateLimited(endpoint)
+	}
+	return false
+}
+
 // SetupRoutes configures all API routes
 func (r *APIRouter) SetupRoutes(router *gin.Engine) {
 	// Web UI static files
@@ -45,99 +137,178 @@ func (r *APIRouter) SetupRoutes(router *gin.Engine) {
 		c.Redirect(http.StatusMovedPermanently, "/web/")
 	})
 
-	// WebSocket endpoint
+	// WebSocket endpoints for agents and UI observers; observers need a
+	// token, passed as ?token= by browsers
 	router.GET("/ws", r.wsManager.HandleWebSocket)
+	router.GET("/ws/events", r.authenticate(), r.require("agents", "read"), r.streamEvents)
+	if r.wsManager != nil {
+		r.wsManager.HandleMessageType("config_ack", r.handleConfigAck)
+		r.wsManager.HandleMessageType("token_rotate_ack", r.handleTokenRotateAck)
+		r.wsManager.HandleMessageType("hello", r.handleHello)
+		if r.tokenManager != nil {
+			r.tokenManager.OnRotate(r.pushTokenRotation)
+		}
+		r.wsManager.OnAgentConnect(r.resendAgentConfig)
+		if r.registry != nil {
+			r.wsManager.OnAgentAlive(r.registry.ControlAlive)
+		}
+	}
 
-	// API v1 routes
+	// API v1 routes; each route requires a resource:action permission when
+	// RBAC is enforced
 	v1 := router.Group("/api/v1")
 	{
 		// Agent routes
-		agents := v1.Group("/agents")
+		agents := v1.Group("/agents", r.authenticate())
 		{
-			agents.GET("/list", r.listAgents)
-			agents.GET("/:id", r.getAgent)
-			agents.POST("/:id/restart", r.restartAgent)
-			agents.GET("/:id/tasks", r.getAgentTasks)
+			agents.GET("/list", r.require("agents", "read"), r.listAgents)
+			agents.GET("/connected", r.require("agents", "read"), r.listConnectedAgents)
+			agents.GET("/export", r.require("agents", "read"), r.exportAgents)
+			agents.GET("/capacity", r.require("agents", "read"), r.listAgentCapacity)
+			agents.POST("/bulk/restart", r.require("agents", "update"), r.bulkRestartAgents)
+			agents.POST("/bulk/delete", r.require("agents", "delete"), r.bulkDeleteAgents)
+			agents.POST("/bulk/status", r.require("agents", "update"), r.bulkUpdateAgentStatus)
+			agents.GET("/:id", r.require("agents", "read"), r.getAgent)
+			agents.PATCH("/:id", r.require("agents", "update"), r.patchAgent)
+			agents.POST("/:id/restart", r.require("agents", "update"), r.restartAgent)
+			agents.POST("/:id/refresh", r.require("agents", "update"), r.refreshAgent)
+			agents.GET("/:id/tasks", r.require("agents", "read"), r.getAgentTasks)
+			agents.GET("/:id/heartbeats", r.require("agents", "read"), r.getAgentHeartbeats)
+			agents.GET("/:id/changes", r.require("agents", "read"), r.getAgentChanges)
+			agents.GET("/:id/packages", r.require("agents", "read"), r.getAgentPackages)
+			agents.POST("/:id/update", r.require("agents", "update"), r.updateAgent)
+			agents.POST("/:id/benchmark", r.require("tasks", "create"), r.benchmarkAgent)
+			agents.GET("/:id/benchmarks", r.require("agents", "read"), r.getAgentBenchmarks)
+			agents.GET("/:id/config", r.require("agents", "read"), r.getAgentConfig)
+			agents.POST("/:id/config", r.require("agents", "update"), r.setAgentConfig)
+			agents.POST("/:id/decommission", r.require("agents", "delete"), r.decommissionAgent)
+			agents.GET("/:id/exec", r.require("agents", "exec"), r.execAgent)
+			agents.GET("/:id/logs", r.require("agents", "read"), r.getAgentRecentLogs)
 		}
 
 		// Task routes
-		tasks := v1.Group("/tasks")
+		tasks := v1.Group("/tasks", r.authenticate())
+		{
+			tasks.GET("/list", r.require("tasks", "read"), r.listTasks)
+			tasks.POST("/", r.require("tasks", "create"), r.createTask)
+			tasks.GET("/search", r.require("tasks", "read"), r.searchTaskOutput)
+			tasks.GET("/:id", r.require("tasks", "read"), r.getTask)
+			tasks.POST("/:id/cancel", r.require("tasks", "update"), r.cancelTask)
+		}
+
+		// Recurring task schedules
+		schedules := v1.Group("/schedules", r.authenticate())
 		{
-			tasks.GET("/list", r.listTasks)
-			tasks.POST("/", r.createTask)
-			tasks.GET("/:id", r.getTask)
-			tasks.POST("/:id/cancel", r.cancelTask)
+			schedules.GET("/list", r.require("schedules", "read"), r.listSchedules)
+			schedules.POST("/", r.require("schedules", "create"), r.createSchedule)
+			schedules.GET("/:id", r.require("schedules", "read"), r.getSchedule)
+			schedules.PUT("/:id", r.require("schedules", "update"), r.updateSchedule)
+			schedules.DELETE("/:id", r.require("schedules", "delete"), r.deleteSchedule)
 		}
 
 		// Cluster routes
-		clusters := v1.Group("/clusters")
+		clusters := v1.Group("/clusters", r.authenticate())
 		{
-			clusters.GET("/list", r.listClusters)
-			clusters.POST("/", r.createCluster)
-			clusters.GET("/:id", r.getCluster)
-			clusters.PUT("/:id", r.updateCluster)
-			clusters.DELETE("/:id", r.deleteCluster)
-			clusters.GET("/:id/stats", r.getClusterStats)
-			clusters.POST("/:id/agents/:agent_id", r.addAgentToCluster)
-			clusters.DELETE("/:id/agents/:agent_id", r.removeAgentFromCluster)
-		}
-
-		// Alert routes
-		alerts := v1.Group("/alerts")
+			clusters.GET("/list", r.require("clusters", "read"), r.listClusters)
+			clusters.POST("/", r.require("clusters", "create"), r.createCluster)
+			clusters.GET("/:id", r.require("clusters", "read"), r.getCluster)
+			clusters.PUT("/:id", r.require("clusters", "update"), r.updateCluster)
+			clusters.DELETE("/:id", r.require("clusters", "delete"), r.deleteCluster)
+			clusters.GET("/:id/stats", r.require("clusters", "read"), r.getClusterStats)
+			clusters.POST("/:id/agents/:agent_id", r.require("clusters", "update"), r.addAgentToCluster)
+			clusters.DELETE("/:id/agents/:agent_id", r.require("clusters", "update"), r.removeAgentFromCluster)
+		}
+
+		// Alert routes, including maintenance windows
+		alerts := v1.Group("/alerts", r.authenticate())
+		{
+			alerts.GET("/list", r.require("alerts", "read"), r.listAlerts)
+			alerts.POST("/rules", r.require("alerts", "create"), r.createAlertRule)
+			alerts.GET("/rules", r.require("alerts", "read"), r.listAlertRules)
+			alerts.PUT("/rules/:id", r.require("alerts", "update"), r.updateAlertRule)
+			alerts.DELETE("/rules/:id", r.require("alerts", "delete"), r.deleteAlertRule)
+			alerts.POST("/rules/:id/enable", r.require("alerts", "update"), r.enableAlertRule)
+			alerts.POST("/rules/:id/disable", r.require("alerts", "update"), r.disableAlertRule)
+			alerts.POST("/rules/:id/test", r.require("alerts", "read"), r.testAlertRule)
+			alerts.POST("/:id/resolve", r.require("alerts", "update"), r.resolveAlert)
+			alerts.GET("/dead-letters", r.require("alerts", "read"), r.listAlertDeadLetters)
+			alerts.GET("/maintenance", r.require("alerts", "read"), r.listMaintenanceWindows)
+			alerts.POST("/maintenance", r.require("alerts", "create"), r.createMaintenanceWindow)
+			alerts.GET("/maintenance/:id", r.require("alerts", "read"), r.getMaintenanceWindow)
+			alerts.PUT("/maintenance/:id", r.require("alerts", "update"), r.updateMaintenanceWindow)
+			alerts.DELETE("/maintenance/:id", r.require("alerts", "delete"), r.deleteMaintenanceWindow)
+		}
+
+		// Outbound webhooks on agent lifecycle events
+		webhooks := v1.Group("/webhooks", r.authenticate())
 		{
-			alerts.GET("/list", r.listAlerts)
-			alerts.POST("/rules", r.createAlertRule)
-			alerts.GET("/rules", r.listAlertRules)
-			alerts.PUT("/rules/:id", r.updateAlertRule)
-			alerts.DELETE("/rules/:id", r.deleteAlertRule)
-			alerts.POST("/:id/resolve", r.resolveAlert)
+			webhooks.GET("/list", r.require("webhooks", "read"), r.listWebhooks)
+			webhooks.POST("/", r.require("webhooks", "create"), r.createWebhook)
+			webhooks.GET("/dead-letters", r.require("webhooks", "read"), r.listWebhookDeadLetters)
+			webhooks.GET("/:id", r.require("webhooks", "read"), r.getWebhook)
+			webhooks.PUT("/:id", r.require("webhooks", "update"), r.updateWebhook)
+			webhooks.DELETE("/:id", r.require("webhooks", "delete"), r.deleteWebhook)
 		}
 
 		// Plugin routes
-		plugins := v1.Group("/plugins")
+		plugins := v1.Group("/plugins", r.authenticate())
 		{
-			plugins.GET("/list", r.listPlugins)
-			plugins.POST("/upload", r.uploadPlugin)
-			plugins.DELETE("/:name", r.deletePlugin)
+			plugins.GET("/list", r.require("plugins", "read"), r.listPlugins)
+			plugins.POST("/upload", r.require("plugins", "create"), r.uploadPlugin)
+			plugins.DELETE("/:name", r.require("plugins", "delete"), r.deletePlugin)
 		}
 
-		// System routes
+		// System routes; health stays open for load balancers
 		system := v1.Group("/system")
 		{
-			system.GET("/stats", r.getSystemStats)
+			system.GET("/stats", r.authenticate(), r.require("system", "read"), r.getSystemStats)
+			system.GET("/inventory-summary", r.authenticate(), r.require("system", "read"), r.getInventorySummary)
 			system.GET("/health", r.getHealth)
+			system.GET("/retention", r.authenticate(), r.require("system", "read"), r.getRetention)
+			system.POST("/metrics/prune", r.authenticate(), r.require("system", "update"), r.pruneMetrics)
 		}
 
 		// Token management routes
-		tokens := v1.Group("/tokens")
+		tokens := v1.Group("/tokens", r.authenticate())
 		{
-			tokens.POST("/generate", r.generateToken)
-			tokens.GET("/list", r.listTokens)
-			tokens.DELETE("/:id", r.revokeToken)
+			tokens.POST("/generate", r.require("tokens", "create"), r.generateToken)
+			tokens.GET("/list", r.require("tokens", "read"), r.listTokens)
+			tokens.DELETE("/:id", r.require("tokens", "delete"), r.revokeToken)
 		}
 	}
 
-	// Legacy API routes (for backward compatibility)
+	// Legacy API routes (for backward compatibility). They require the
+	// permissions of their v1 equivalents when RBAC is enforced; agents use
+	// them with tokens of the agent role.
 	api := router.Group("/api")
 	{
+		// Agent endpoints
+		api.POST("/agents/register", r.authenticate(), r.require("agents", "report"), r.registerAgent)
+		api.POST("/agents/:id/heartbeat", r.authenticate(), r.require("agents", "report"), r.agentHeartbeat)
+		api.POST("/agents/heartbeat", r.authenticate(), r.require("agents", "report"), r.agentHeartbeat) // Token-based heartbeat (no ID required)
+		api.POST("/agents/heartbeat/batch", r.authenticate(), r.require("agents", "report"), r.agentHeartbeatBatch) // Heartbeats relayed by edge aggregators
+		api.GET("/agents/:id/tasks", r.authenticate(), r.require("tasks", "execute"), r.require("agents", "report"), r.pollTasks)
+		api.POST("/tasks/:id/result", r.authenticate(), r.require("tasks", "execute"), r.submitTaskResult)
+
 		// Agent management routes
-		api.POST("/agents/register", r.registerAgent)
-		api.GET("/agents", r.listAgents)
-		api.GET("/agents/:id", r.getAgent)
-		api.PUT("/agents/:id/status", r.updateAgentStatus)
-		api.DELETE("/agents/:id", r.deleteAgent)
-		api.POST("/agents/:id/heartbeat", r.agentHeartbeat)
-		api.POST("/agents/heartbeat", r.agentHeartbeat) // Token-based heartbeat (no ID required)
-		
-		// Task routes
-		api.POST("/tasks", r.createTask)
-		api.GET("/tasks", r.listTasks)
-		api.GET("/tasks/:id", r.getTask)
-		
+		api.GET("/agents", r.authenticate(), r.require("agents", "read"), r.listAgents)
+		api.GET("/agents/:id", r.authenticate(), r.require("agents", "read"), r.getAgent)
+		api.PUT("/agents/:id/status", r.authenticate(), r.require("agents", "update"), r.updateAgentStatus)
+		api.DELETE("/agents/:id", r.authenticate(), r.require("agents", "delete"), r.deleteAgent)
+
+		// Task routes; ?agent_id= only filters, agents poll /agents/:id/tasks
+		api.POST("/tasks", r.authenticate(), r.require("tasks", "create"), r.createTask)
+		api.GET("/tasks", r.authenticate(), r.require("tasks", "read"), r.listTasks)
+		api.GET("/tasks/:id", r.authenticate(), r.require("tasks", "read"), r.getTask)
+
 		// System routes
 		api.GET("/health", r.getHealth)
 		api.GET("/install", r.installScript)
 		api.GET("/download", r.downloadAgent)
+
+		// API documentation
+		api.GET("/openapi.json", r.serveOpenAPI(router))
+		api.GET("/docs", serveSwaggerUI)
 	}
 }
 
@@ -151,28 +322,45 @@ func (r *APIRouter) listAgents(c *gin.Context) {
 		return
 	}
 	
-	// Get agents from registry
-	agentInfos := r.registry.List()
-	agents := make([]gin.H, 0, len(agentInfos))
+	// Filtered agent IDs are sorted, so the ETag is stable
+	agentIDs, err := r.filterAgents(c)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	agents := make([]gin.H, 0, len(agentIDs))
 	
-	for _, agent := range agentInfos {
+	for _, id := range agentIDs {
+		agent := r.registry.Get(id)
+		if agent == nil {
+			continue
+		}
 		agents = append(agents, gin.H{
-			"id":            agent.ID,
-			"hostname":      agent.Hostname,
-			"status":        agent.Status,
-			"cpu_type":      agent.CPUType,
-			"cpu_logic":     agent.CPULogic,
-			"memory":        agent.Memory,
-			"os":            agent.OS,
-			"manageip":      agent.ManageIP,
-			"gpu_num":       agent.GPUNum,
-			"gpu_type":      agent.GPUType,
-			"last_seen":     agent.LastSeen,
-			"registered_at": agent.RegisteredAt,
+			"id":                 agent.ID,
+			"hostname":           agent.Hostname,
+			"status":             agent.Status,
+			"cpu_type":           agent.CPUType,
+			"cpu_logic":          agent.CPULogic,
+			"memory":             agent.Memory,
+			"os":                 agent.OS,
+			"manageip":           agent.ManageIP,
+			"gpu_num":            agent.GPUNum,
+			"gpu_type":           agent.GPUType,
+			"last_seen":          agent.LastSeen,
+			"last_ping":          agent.LastPing,
+			"registered_at":      agent.RegisteredAt,
+			"clock_skew_seconds": agent.ClockSkew,
+			"clock_skewed":       agent.ClockSkewed,
+			"flap_count":         agent.FlapCount,
+			"flapping":           agent.Flapping,
+			"connected":          r.wsManager != nil && r.wsManager.IsAgentConnected(agent.ID),
+			"metadata":           agent.Metadata,
+			"agent_version":      agent.AgentVersion,
+			"capabilities":       agent.Capabilities,
 		})
 	}
 	
-	c.JSON(http.StatusOK, gin.H{
+	respondWithETag(c, gin.H{
 		"agents": agents,
 		"total":  len(agents),
 	})
@@ -182,47 +370,92 @@ func (r *APIRouter) getAgent(c *gin.Context) {
 	agentID := c.Param("id")
 	
 	if r.registry == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
 		return
 	}
 	
 	agent := r.registry.Get(agentID)
 	if agent == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
 		return
 	}
+	var sensors []core.Sensor
+	if agent.Usage != nil {
+		sensors = agent.Usage.Sensors
+	}
 	
-	c.JSON(http.StatusOK, gin.H{
+	respondWithETag(c, gin.H{
 		"agent": gin.H{
-			"id":            agent.ID,
-			"hostname":      agent.Hostname,
-			"status":        agent.Status,
-			"cpu_type":      agent.CPUType,
-			"cpu_logic":     agent.CPULogic,
-			"memory":        agent.Memory,
-			"os":            agent.OS,
-			"sn":            agent.SN,
-			"product":       agent.Product,
-			"brand":         agent.Brand,
-			"netcard":       agent.Netcard,
-			"basearch":      agent.Basearch,
-			"gpu_num":       agent.GPUNum,
-			"gpu_type":      agent.GPUType,
-			"last_seen":     agent.LastSeen,
-			"registered_at": agent.RegisteredAt,
+			"id":                 agent.ID,
+			"hostname":           agent.Hostname,
+			"status":             agent.Status,
+			"cpu_type":           agent.CPUType,
+			"cpu_logic":          agent.CPULogic,
+			"memory":             agent.Memory,
+			"os":                 agent.OS,
+			"sn":                 agent.SN,
+			"product":            agent.Product,
+			"brand":              agent.Brand,
+			"netcard":            agent.Netcard,
+			"basearch":           agent.Basearch,
+			"gpu_num":            agent.GPUNum,
+			"gpu_type":           agent.GPUType,
+			"last_seen":          agent.LastSeen,
+			"last_ping":          agent.LastPing,
+			"registered_at":      agent.RegisteredAt,
+			"clock_skew_seconds": agent.ClockSkew,
+			"clock_skewed":       agent.ClockSkewed,
+			"flap_count":         agent.FlapCount,
+			"flapping":           agent.Flapping,
+			"connected":          r.wsManager != nil && r.wsManager.IsAgentConnected(agent.ID),
+			"metadata":           agent.Metadata,
+			"custom_metrics":     agent.CustomMetrics,
+			"control":            agent.Control,
+			"agent_version":      agent.AgentVersion,
+			"capabilities":       agent.Capabilities,
+			"kernel":             agent.Kernel,
+			"package_count":      len(agent.Packages),
+			"raid_info":          agent.RaidInfo,
+			"sensors":            sensors,
+			"running_tasks":      agent.RunningTasks,
+			"stray_tasks":        agent.StrayTasks,
 		},
 	})
 }
 
 func (r *APIRouter) restartAgent(c *gin.Context) {
 	agentID := c.Param("id")
-	// TODO: Implement agent restart
+
+	if err := r.restartAgentByID(agentID); err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Restart command sent",
 		"agent_id": agentID,
 	})
 }
 
+// restartAgentByID sends a restart control message, on which the agent
+// stops like on a signal and starts again. Only agents with an open control
+// channel can be restarted.
+func (r *APIRouter) restartAgentByID(agentID string) error {
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		return errAgentNotFound
+	}
+	if !r.registry.HasCapability(agentID, core.CapabilityRestart) {
+		return errRestartUnsupported
+	}
+
+	if r.wsManager != nil {
+		if message, err := websocket.NewWebSocketMessage("restart", agentID, nil).ToJSON(); err == nil && r.wsManager.SendToAgent(agentID, message) {
+			return nil
+		}
+	}
+	return errAgentNotConnected
+}
+
 func (r *APIRouter) getAgentTasks(c *gin.Context) {
 	agentID := c.Param("id")
 	// TODO: Implement agent task retrieval
@@ -232,54 +465,447 @@ func (r *APIRouter) getAgentTasks(c *gin.Context) {
 	})
 }
 
+func (r *APIRouter) getAgentHeartbeats(c *gin.Context) {
+	agentID := c.Param("id")
+
+	if r.registry == nil {
+		apierror.Respond(c, apierror.NotImplemented, "heartbeat history not available")
+		return
+	}
+
+	querier, ok := r.registry.Store().(storage.HeartbeatQuerier)
+	if !ok {
+		apierror.Respond(c, apierror.NotImplemented, "heartbeat history requires MongoDB storage")
+		return
+	}
+
+	// Default to the last hour, downsampled to 300 points
+	to := time.Now()
+	from := to.Add(-1 * time.Hour)
+	limit := 300
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		parsed, err := time.Parse(time.RFC3339, fromStr)
+		if err != nil {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid from, expected RFC3339")
+			return
+		}
+		from = parsed
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		parsed, err := time.Parse(time.RFC3339, toStr)
+		if err != nil {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid to, expected RFC3339")
+			return
+		}
+		to = parsed
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "invalid limit")
+			return
+		}
+		limit = parsed
+	}
+
+	points, err := querier.GetHeartbeats(agentID, from, to, limit)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id":   agentID,
+		"from":       from,
+		"to":         to,
+		"heartbeats": points,
+		"total":      len(points),
+	})
+}
+
+// getAgentChanges returns the hardware inventory changes recorded for an agent
+func (r *APIRouter) getAgentChanges(c *gin.Context) {
+	agentID := c.Param("id")
+
+	if r.registry == nil || r.registry.Get(agentID) == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+
+	changes := r.registry.InventoryChanges(agentID)
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id": agentID,
+		"changes":  changes,
+		"total":    len(changes),
+	})
+}
+
+// getAgentPackages returns the kernel and installed packages an agent
+// reported at registration, optionally only packages matching a name glob
+func (r *APIRouter) getAgentPackages(c *gin.Context) {
+	agentID := c.Param("id")
+
+	var agent *core.AgentInfo
+	if r.registry != nil {
+		agent = r.registry.Get(agentID)
+	}
+	if agent == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+
+	packages, err := core.FilterPackages(agent.Packages, c.Query("name"))
+	if err != nil {
+		apierror.Respond(c, apierror.InvalidRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"agent_id":        agentID,
+		"kernel":          agent.Kernel,
+		"package_manager": agent.PackageManager,
+		"packages":        packages,
+		"total":           len(packages),
+	})
+}
+
+func (r *APIRouter) updateAgent(c *gin.Context) {
+	agentID := c.Param("id")
+
+	// Without a version the agent updates to its release channel's version:
+	// the channel given, else the agent's "channel" metadata, else stable
+	var updateRequest struct {
+		Version  string `json:"version"`
+		Channel  string `json:"channel"`
+		Checksum string `json:"checksum"`
+	}
+
+	if err := c.ShouldBindJSON(&updateRequest); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	var agent *core.AgentInfo
+	if r.registry != nil {
+		agent = r.registry.Get(agentID)
+	}
+	if agent == nil {
+		apierror.Respond(c, apierror.AgentNotFound, "agent not found")
+		return
+	}
+	if !agent.SupportsTask(core.CapabilityUpdate) {
+		apierror.Respond(c, apierror.UnsupportedTask, "agent does not support update tasks")
+		return
+	}
+
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.Unavailable, "scheduler not available")
+		return
+	}
+
+	if updateRequest.Version == "" {
+		if r.binaries == nil {
+			apierror.Respond(c, apierror.InvalidRequest, "version is required")
+			return
+		}
+		channel := updateRequest.Channel
+		if channel == "" {
+			channel = agent.Metadata[core.MetadataChannel]
+		}
+		if channel == "" {
+			channel = binary.ChannelStable
+		}
+		version, err := r.binaries.ChannelVersion(channel)
+		if err != nil {
+			apierror.Respond(c, apierror.InvalidRequest, err.Error())
+			return
+		}
+		updateRequest.Version = version
+	}
+
+	// The agent must verify the download against a checksum it gets from
+	// here, not from the download itself
+	var checksums map[string]string
+	if updateRequest.Checksum == "" {
+		if r.binaries == nil {
+			apierror.Respond(c, apierror.InvalidRequest, "checksum is required")
+			return
+		}
+		checksums = r.binaries.Checksums(updateRequest.Version)
+		if len(checksums) == 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "no binary of version "+updateRequest.Version+" is uploaded; pass its checksum")
+			return
+		}
+	}
+
+	task := r.scheduler.ScheduleUpdate(agentID, updateRequest.Version, updateRequest.Checksum, checksums, security.RequestIDFromContext(c))
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Update scheduled",
+		"agent_id": agentID,
+		"task":     task,
+	})
+}
+
 // Task handlers
 func (r *APIRouter) listTasks(c *gin.Context) {
-	// TODO: Implement task listing
+	if r.scheduler == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"tasks": []gin.H{},
+			"total": 0,
+		})
+		return
+	}
+
+	// Listing never dispatches; agents poll pollTasks for theirs
+	agentID, status := c.Query("agent_id"), c.Query("status")
+	tasks := []*core.Task{}
+	for _, task := range r.scheduler.ListTasks() {
+		if (agentID == "" || task.AgentID == agentID) && (status == "" || task.Status == status) {
+			tasks = append(tasks, task)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"tasks": []gin.H{},
-		"total": 0,
+		"tasks": tasks,
+		"total": len(tasks),
+	})
+}
+
+// pollTasks hands an agent its pending tasks, marking them running. Only
+// the agent itself may poll, since the tasks aren't handed out again.
+//
+// GET /api/agents/:id/tasks
+func (r *APIRouter) pollTasks(c *gin.Context) {
+	agentID := c.Param("id")
+	if !r.callerHoldsAgent(c, agentID) {
+		apierror.Respond(c, apierror.Forbidden, "token does not belong to agent "+agentID)
+		return
+	}
+
+	tasks := []*core.Task{}
+	if r.scheduler != nil {
+		tasks = r.scheduler.DispatchTasks(agentID)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tasks": tasks,
+		"total": len(tasks),
 	})
 }
 
 func (r *APIRouter) createTask(c *gin.Context) {
 	var taskRequest struct {
-		Type         string   `json:"type"`
-		TargetAgents []string `json:"target_agents"`
-		Content      string   `json:"content"`
-		Timeout      int      `json:"timeout"`
+		Type         string                 `json:"type"`
+		TargetAgents []string               `json:"target_agents"`
+		Selector     *core.AgentSelector    `json:"selector"`
+		Content      string                 `json:"content"`
+		Params       map[string]interface{} `json:"params"`
+		Timeout      int                    `json:"timeout"`
+		ExpiresIn    int                    `json:"expires_in"`
+		Retry        *core.RetryPolicy      `json:"retry"`
+		DryRun       bool                   `json:"dry_run"`
+
+		// IdempotencyKey is used if the Idempotency-Key header isn't set
+		IdempotencyKey string `json:"idempotency_key,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&taskRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	// Retries with the same key return the tasks created the first time
+	key, err := idempotencyKey(c, taskRequest.IdempotencyKey)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	taskRequest.IdempotencyKey = ""
+	fingerprint := requestFingerprint(taskRequest)
+
+	if taskRequest.Retry != nil {
+		if err := taskRequest.Retry.Validate(); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+	}
+
+	if taskRequest.Type == "benchmark" {
+		if err := core.ValidateBenchmark(taskRequest.Content); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+	}
+
+	targets := taskRequest.TargetAgents
+	if taskRequest.Selector != nil {
+		if len(targets) > 0 {
+			apierror.Respond(c, apierror.InvalidRequest, "specify either target_agents or selector, not both")
+			return
+		}
+		selected, err := r.selectAgents(taskRequest.Selector)
+		if err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+		targets = selected
+	}
+
+	// Agents that can't run the task type are skipped rather than sent a
+	// task that would fail at dispatch
+	unsupported := r.unsupportedAgents(targets, taskRequest.Type)
+	targets = withoutAgents(targets, unsupported)
+
+	// Agents in maintenance or error status are skipped too, as their
+	// tasks would be held until an operator brings them back online
+	skipped := r.heldAgents(targets)
+	held := make([]string, 0, len(skipped))
+	for id := range skipped {
+		held = append(held, id)
+	}
+	targets = withoutAgents(targets, held)
+
+	template := core.TaskTemplate{
+		Type:    taskRequest.Type,
+		Content: taskRequest.Content,
+		Params:  taskRequest.Params,
+		Timeout: taskRequest.Timeout,
+		Retry:   taskRequest.Retry,
+	}
+
+	// Dry runs show what would be dispatched without submitting anything
+	if taskRequest.DryRun {
+		if targets == nil {
+			targets = []string{}
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"dry_run":            true,
+			"agents":             targets,
+			"unknown_agents":     r.unknownAgents(targets),
+			"unsupported_agents": unsupported,
+			"skipped_agents":     skipped,
+			"total":              len(targets),
+			"task":               template,
+		})
 		return
 	}
 
-	// TODO: Implement task creation
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.Unavailable, "scheduler not available")
+		return
+	}
+
+	// The tasks share a batch, so their output can be searched together
+	batchID := core.NewBatchID()
+	tasks := make([]*core.Task, 0, len(targets))
+	for _, agentID := range targets {
+		task := template.NewTask(agentID)
+		task.RequestID = security.RequestIDFromContext(c)
+		task.BatchID = batchID
+
+		if taskRequest.ExpiresIn > 0 {
+			task.ExpiresAt = time.Now().Add(time.Duration(taskRequest.ExpiresIn) * time.Second)
+		}
+
+		tasks = append(tasks, task)
+	}
+
+	message := "Task created successfully"
+	if key == "" {
+		for _, task := range tasks {
+			r.scheduler.SubmitTask(task)
+		}
+	} else {
+		var replayed bool
+		tasks, replayed, err = r.scheduler.SubmitIdempotent(key, fingerprint, tasks)
+		if err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+		if replayed {
+			c.Header(idempotentReplayedHeader, "true")
+			message = "Task already created"
+			if len(tasks) > 0 {
+				batchID = tasks[0].BatchID
+			}
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Task created successfully",
-		"task":    taskRequest,
+		"message":            message,
+		"batch_id":           batchID,
+		"tasks":              tasks,
+		"unsupported_agents": unsupported,
+		"skipped_agents":     skipped,
 	})
 }
 
 func (r *APIRouter) getTask(c *gin.Context) {
 	taskID := c.Param("id")
-	// TODO: Implement task retrieval
+
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.TaskNotFound, "task not found")
+		return
+	}
+
+	task := r.scheduler.GetTask(taskID)
+	if task == nil {
+		apierror.Respond(c, apierror.TaskNotFound, "task not found")
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"task": gin.H{
-			"id": taskID,
-		},
+		"task": task,
 	})
 }
 
 func (r *APIRouter) cancelTask(c *gin.Context) {
 	taskID := c.Param("id")
-	// TODO: Implement task cancellation
+
+	if r.scheduler == nil || !r.scheduler.CancelTask(taskID) {
+		apierror.Respond(c, apierror.TaskNotCancellable, "task not found or already dispatched")
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Task cancelled",
 		"task_id": taskID,
 	})
 }
 
+func (r *APIRouter) submitTaskResult(c *gin.Context) {
+	taskID := c.Param("id")
+
+	var result core.TaskResult
+	if err := c.ShouldBindJSON(&result); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	var task *core.Task
+	if r.scheduler != nil {
+		task = r.scheduler.GetTask(taskID)
+	}
+	if task == nil {
+		apierror.Respond(c, apierror.TaskNotFound, "task not found")
+		return
+	}
+	// Only the agent the task was sent to reports its result
+	if !r.callerHoldsAgent(c, task.AgentID) {
+		apierror.Respond(c, apierror.Forbidden, "token does not belong to agent "+task.AgentID)
+		return
+	}
+
+	result.TaskID = taskID
+	r.scheduler.RecordBenchmarkResult(&result)
+	r.scheduler.MarkTaskDone(taskID, result.Success, result.Output, result.Error)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "received",
+		"task_id": taskID,
+	})
+}
+
 // Cluster handlers
 func (r *APIRouter) listClusters(c *gin.Context) {
 	clusters := r.clusterMgr.ListClusters()
@@ -292,12 +918,12 @@ func (r *APIRouter) listClusters(c *gin.Context) {
 func (r *APIRouter) createCluster(c *gin.Context) {
 	var cluster cluster.Cluster
 	if err := c.ShouldBindJSON(&cluster); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
 	if err := r.clusterMgr.AddCluster(&cluster); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -311,7 +937,7 @@ func (r *APIRouter) getCluster(c *gin.Context) {
 	clusterID := c.Param("id")
 	cluster, err := r.clusterMgr.GetCluster(clusterID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.ClusterNotFound)
 		return
 	}
 
@@ -320,28 +946,75 @@ func (r *APIRouter) getCluster(c *gin.Context) {
 	})
 }
 
+// updateCluster validates and applies a cluster update. With
+// verify_agents=true, a membership change naming unregistered agents is
+// rejected and lists them; otherwise it is applied and they are listed as
+// unknown_agents.
+//
+// PUT /api/v1/clusters/:id?verify_agents=
 func (r *APIRouter) updateCluster(c *gin.Context) {
 	clusterID := c.Param("id")
 	var updates map[string]interface{}
 	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	verify := false
+	if raw := c.Query("verify_agents"); raw != "" {
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			apierror.Respond(c, apierror.InvalidRequest, "verify_agents must be true or false")
+			return
+		}
+		verify = parsed
+	}
+	if verify && r.registry == nil {
+		apierror.Respond(c, apierror.Unavailable, "agent registry not available")
+		return
+	}
+
+	var registered func(agentID string) bool
+	if r.registry != nil {
+		registered = func(agentID string) bool { return r.registry.Get(agentID) != nil }
+	}
+	var check func(agentID string) bool
+	if verify {
+		check = registered
+	}
+
+	if err := r.clusterMgr.UpdateClusterChecked(clusterID, updates, check); err != nil {
+		var unknown *cluster.UnknownAgentsError
+		if errors.As(err, &unknown) {
+			apierror.RespondDetails(c, apierror.InvalidRequest, err.Error(),
+				gin.H{"unknown_agents": unknown.AgentIDs})
+			return
+		}
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
-	if err := r.clusterMgr.UpdateCluster(clusterID, updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	updated, err := r.clusterMgr.GetCluster(clusterID)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.ClusterNotFound)
 		return
 	}
+	unknownAgents := []string{}
+	if registered != nil {
+		unknownAgents = cluster.UnknownAgents(updated.Agents, registered)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Cluster updated successfully",
+		"message":        "Cluster updated successfully",
+		"cluster":        updated,
+		"unknown_agents": unknownAgents,
 	})
 }
 
 func (r *APIRouter) deleteCluster(c *gin.Context) {
 	clusterID := c.Param("id")
 	if err := r.clusterMgr.DeleteCluster(clusterID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -354,7 +1027,7 @@ func (r *APIRouter) getClusterStats(c *gin.Context) {
 	clusterID := c.Param("id")
 	stats, err := r.clusterMgr.GetClusterStats(clusterID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.ClusterNotFound)
 		return
 	}
 
@@ -368,7 +1041,7 @@ func (r *APIRouter) addAgentToCluster(c *gin.Context) {
 	agentID := c.Param("agent_id")
 	
 	if err := r.clusterMgr.AddAgentToCluster(clusterID, agentID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -382,7 +1055,7 @@ func (r *APIRouter) removeAgentFromCluster(c *gin.Context) {
 	agentID := c.Param("agent_id")
 	
 	if err := r.clusterMgr.RemoveAgentFromCluster(clusterID, agentID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -400,15 +1073,27 @@ func (r *APIRouter) listAlerts(c *gin.Context) {
 	})
 }
 
+// listAlertDeadLetters returns the notifications notifiers failed to
+// deliver, optionally of one notifier
+//
+// GET /api/v1/alerts/dead-letters?notifier=
+func (r *APIRouter) listAlertDeadLetters(c *gin.Context) {
+	letters := r.alertMgr.DeadLetters(c.Query("notifier"))
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": letters,
+		"total":        len(letters),
+	})
+}
+
 func (r *APIRouter) createAlertRule(c *gin.Context) {
 	var rule alert.AlertRule
 	if err := c.ShouldBindJSON(&rule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
 	if err := r.alertMgr.AddAlertRule(&rule); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -430,12 +1115,12 @@ func (r *APIRouter) updateAlertRule(c *gin.Context) {
 	ruleID := c.Param("id")
 	var updates map[string]interface{}
 	if err := c.ShouldBindJSON(&updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
 	if err := r.alertMgr.UpdateAlertRule(ruleID, updates); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -447,7 +1132,7 @@ func (r *APIRouter) updateAlertRule(c *gin.Context) {
 func (r *APIRouter) deleteAlertRule(c *gin.Context) {
 	ruleID := c.Param("id")
 	if err := r.alertMgr.DeleteAlertRule(ruleID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -456,10 +1141,58 @@ func (r *APIRouter) deleteAlertRule(c *gin.Context) {
 	})
 }
 
+func (r *APIRouter) enableAlertRule(c *gin.Context) {
+	r.setAlertRuleEnabled(c, true)
+}
+
+func (r *APIRouter) disableAlertRule(c *gin.Context) {
+	r.setAlertRuleEnabled(c, false)
+}
+
+// setAlertRuleEnabled toggles a rule without a full update
+func (r *APIRouter) setAlertRuleEnabled(c *gin.Context, enabled bool) {
+	rule, err := r.alertMgr.SetAlertRuleEnabled(c.Param("id"), enabled)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.AlertRuleNotFound)
+		return
+	}
+
+	message := "Alert rule disabled successfully"
+	if enabled {
+		message = "Alert rule enabled successfully"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"rule":    rule,
+	})
+}
+
+// testAlertRule evaluates a rule against sample data without creating an
+// alert or firing notifiers
+func (r *APIRouter) testAlertRule(c *gin.Context) {
+	var testRequest struct {
+		Data map[string]interface{} `json:"data" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&testRequest); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	result, err := r.alertMgr.TestAlertRule(c.Param("id"), testRequest.Data)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.AlertRuleNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"result": result,
+	})
+}
+
 func (r *APIRouter) resolveAlert(c *gin.Context) {
 	alertID := c.Param("id")
 	if err := r.alertMgr.ResolveAlert(alertID); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
@@ -468,6 +1201,76 @@ func (r *APIRouter) resolveAlert(c *gin.Context) {
 	})
 }
 
+// Maintenance window handlers
+func (r *APIRouter) listMaintenanceWindows(c *gin.Context) {
+	windows := r.alertMgr.ListMaintenanceWindows()
+	c.JSON(http.StatusOK, gin.H{
+		"windows": windows,
+		"total":   len(windows),
+	})
+}
+
+func (r *APIRouter) createMaintenanceWindow(c *gin.Context) {
+	var window alert.MaintenanceWindow
+	if err := c.ShouldBindJSON(&window); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if err := r.alertMgr.AddMaintenanceWindow(&window); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Maintenance window created successfully",
+		"window":  window,
+	})
+}
+
+func (r *APIRouter) getMaintenanceWindow(c *gin.Context) {
+	window, err := r.alertMgr.GetMaintenanceWindow(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, err, apierror.MaintenanceWindowNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"window": window,
+		"active": window.Active(time.Now()),
+	})
+}
+
+func (r *APIRouter) updateMaintenanceWindow(c *gin.Context) {
+	windowID := c.Param("id")
+	var updates alert.MaintenanceWindow
+	if err := c.ShouldBindJSON(&updates); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if err := r.alertMgr.UpdateMaintenanceWindow(windowID, &updates); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Maintenance window updated successfully",
+	})
+}
+
+func (r *APIRouter) deleteMaintenanceWindow(c *gin.Context) {
+	windowID := c.Param("id")
+	if err := r.alertMgr.DeleteMaintenanceWindow(windowID); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Maintenance window deleted successfully",
+	})
+}
+
 // Plugin handlers
 func (r *APIRouter) listPlugins(c *gin.Context) {
 	// TODO: Implement plugin listing
@@ -512,15 +1315,32 @@ func (r *APIRouter) getSystemStats(c *gin.Context) {
 		}
 	}
 	
+	// Get task statistics from scheduler
+	totalTasks := 0
+	tasksByStatus := map[string]int{
+		"pending":   0,
+		"running":   0,
+		"completed": 0,
+		"failed":    0,
+	}
+
+	if r.scheduler != nil {
+		tasksByStatus = r.scheduler.CountByStatus()
+		for _, count := range tasksByStatus {
+			totalTasks += count
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"stats": gin.H{
-			"total_agents":   totalAgents,
-			"online_agents":  onlineAgents,
-			"offline_agents": offlineAgents,
-			"total_clusters": len(r.clusterMgr.ListClusters()),
-			"total_alerts":   len(r.alertMgr.ListAlerts()),
-			"total_tasks":    0,
-			"pending_tasks":  0,
+			"total_agents":    totalAgents,
+			"online_agents":   onlineAgents,
+			"offline_agents":  offlineAgents,
+			"total_clusters":  len(r.clusterMgr.ListClusters()),
+			"total_alerts":    len(r.alertMgr.ListAlerts()),
+			"total_tasks":     totalTasks,
+			"pending_tasks":   tasksByStatus["pending"],
+			"tasks_by_status": tasksByStatus,
 		},
 	})
 }
@@ -534,93 +1354,58 @@ func (r *APIRouter) getHealth(c *gin.Context) {
 
 // Agent registration handler
 func (r *APIRouter) registerAgent(c *gin.Context) {
-	var agentInfo struct {
-		Hostname     string                 `json:"hostname" binding:"required"`
-		CPUType      string                 `json:"cpu_type"`
-		CPULogic     int                    `json:"cpu_logic"`
-		Memsum       int64                  `json:"memsum"`
-		Memory       string                 `json:"memory"`
-		SN           string                 `json:"sn"`
-		Product      string                 `json:"product"`
-		Brand        string                 `json:"brand"`
-		Netcard      []string               `json:"netcard"`
-		Basearch     string                 `json:"basearch"`
-		Disk         map[string]interface{} `json:"disk"`
-		Raid         string                 `json:"raid"`
-		IPMIIP       string                 `json:"ipmi_ip"`
-		ManageIP     string                 `json:"manageip"`
-		StorageIP    string                 `json:"storageip"`
-		ParamIP      string                 `json:"paramip"`
-		OS           string                 `json:"os"`
-		GPUNum       int                    `json:"gpu_num"`
-		GPUType      string                 `json:"gpu_type"`
-		GPUVendors   []string               `json:"gpu_vendors"`
-		DiskInfo     []map[string]interface{} `json:"disk_info"`
-		MemoryInfo   []map[string]interface{} `json:"memory_info"`
-		CPUInfo      map[string]interface{} `json:"cpu_info"`
-		GPUInfo      []map[string]interface{} `json:"gpu_info"`
-		NetworkInfo  []map[string]interface{} `json:"network_info"`
-		AgentVersion string                 `json:"agent_version"`
-	}
+	var agentInfo core.RegisterRequest
 
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, core.MaxRegisterBodyBytes)
 	if err := c.ShouldBindJSON(&agentInfo); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		// Oversized bodies are reported as PAYLOAD_TOO_LARGE
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	if err := agentInfo.Validate(); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	if !r.allowAgentRequest(c, "register", agentInfo.Hostname) {
 		return
 	}
 
-	// TODO: Validate token from Authorization header
-	token := c.GetHeader("Authorization")
+	token := security.TokenFromRequest(c)
 	if token == "" {
-		// Try getting token from query parameter as fallback
-		token = c.Query("token")
-		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "authorization token required"})
+		apierror.Respond(c, apierror.Unauthorized, "authorization token required")
+		return
+	}
+
+	// A token issued for an agent registers only that agent
+	tokenInfo := security.RequestTokenInfo(c)
+	if tokenInfo != nil && tokenInfo.AgentID != "" {
+		if agentInfo.AgentID != "" && agentInfo.AgentID != tokenInfo.AgentID {
+			apierror.Respond(c, apierror.Forbidden, "token is issued for agent "+tokenInfo.AgentID)
 			return
 		}
+		agentInfo.AgentID = tokenInfo.AgentID
 	}
 
 	// Register agent with registry
 	if r.registry != nil {
-		agentID := agentInfo.Hostname + "-" + generateRandomID(8)
-		
-		// Create AgentInfo from request
-		info := &core.AgentInfo{
-			ID:           agentID,
-			Hostname:     agentInfo.Hostname,
-			CPUType:      agentInfo.CPUType,
-			CPULogic:     agentInfo.CPULogic,
-			Memsum:       agentInfo.Memsum,
-			Memory:       agentInfo.Memory,
-			SN:           agentInfo.SN,
-			Product:      agentInfo.Product,
-			Brand:        agentInfo.Brand,
-			Netcard:      agentInfo.Netcard,
-			Basearch:     agentInfo.Basearch,
-			Disk:         agentInfo.Disk,
-			Raid:         agentInfo.Raid,
-			IPMIIP:       agentInfo.IPMIIP,
-			ManageIP:     agentInfo.ManageIP,
-			StorageIP:    agentInfo.StorageIP,
-			ParamIP:      agentInfo.ParamIP,
-			OS:           agentInfo.OS,
-			Status:       "online",
-			GPUNum:       agentInfo.GPUNum,
-			GPUType:      agentInfo.GPUType,
-			GPUVendors:   agentInfo.GPUVendors,
-			DiskInfo:     agentInfo.DiskInfo,
-			MemoryInfo:   agentInfo.MemoryInfo,
-			CPUInfo:      agentInfo.CPUInfo,
-			GPUInfo:      agentInfo.GPUInfo,
-			NetworkInfo:  agentInfo.NetworkInfo,
-			UpdateTime:   time.Now().Format("2006-01-02 15:04:05"),
-			AgentVersion: agentInfo.AgentVersion,
-			RegisteredAt: time.Now(),
-			LastSeen:     time.Now(),
+		if r.registry.TokenRetired(token) {
+			apierror.Respond(c, apierror.TokenRetired, "token belongs to a decommissioned agent")
+			return
 		}
-		
-		// Register the agent
-		id := r.registry.Register(info)
-		
+
+		// An agent registered with another token can't be taken over while
+		// that token is still valid. Without authentication tokens aren't
+		// validated, so the last registration wins.
+		replaces := func(string) bool { return true }
+		if tokenInfo != nil {
+			replaces = func(bound string) bool { return r.tokenManager.Replaces(tokenInfo, bound) }
+		}
+		id, err := r.registry.RegisterWithToken(agentInfo.AgentInfo(agentInfo.AgentID), token, replaces)
+		if err != nil {
+			apierror.Respond(c, apierror.Forbidden, err.Error())
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"id":      id,
 			"status":  "registered",
@@ -642,74 +1427,80 @@ func (r *APIRouter) registerAgent(c *gin.Context) {
 func (r *APIRouter) agentHeartbeat(c *gin.Context) {
 	agentID := c.Param("id")
 	
-	var heartbeatData struct {
-		Status      string                 `json:"status"`
-		SystemInfo  map[string]interface{} `json:"system_info,omitempty"`
-		Tasks       []string               `json:"tasks,omitempty"`
-	}
+	var heartbeatData core.Heartbeat
 
 	if err := c.ShouldBindJSON(&heartbeatData); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		if r.metrics != nil {
+			r.metrics.RecordHeartbeat(false)
+		}
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
-	// Update agent heartbeat in registry
+	// Token-based heartbeats carry no ID and are matched by hostname
+	heartbeatData.AgentID = agentID
+	sender := heartbeatData.Sender()
+	if sender == "" {
+		sender = c.ClientIP()
+	}
+	if !r.allowAgentRequest(c, "heartbeat", sender) {
+		return
+	}
 	if r.registry != nil {
-		var agent *core.AgentInfo
-		
-		if agentID != "" {
-			// Use provided agent ID
-			agent = r.registry.Get(agentID)
-		} else {
-			// Token-based heartbeat: try to find agent by hostname from system_info
-			if heartbeatData.SystemInfo != nil {
-				if hostname, ok := heartbeatData.SystemInfo["hostname"].(string); ok && hostname != "" {
-					// Try to find agent by hostname (registry uses hostname as ID base)
-					agents := r.registry.List()
-					for _, a := range agents {
-						if a.Hostname == hostname {
-							agent = a
-							agentID = a.ID
-							break
-						}
-					}
-				}
-			}
+		token := security.TokenFromRequest(c)
+		if r.registry.TokenRetired(token) {
+			apierror.Respond(c, apierror.TokenRetired, "token belongs to a decommissioned agent")
+			return
 		}
-		
-		if agent != nil {
-			agent.LastSeen = time.Now()
-			if heartbeatData.Status != "" {
-				agent.Status = heartbeatData.Status
-			} else {
-				agent.Status = "online"
-			}
-			// Update system info if provided
-			if heartbeatData.SystemInfo != nil {
-				// Update relevant fields from system_info
-				if hostname, ok := heartbeatData.SystemInfo["hostname"].(string); ok {
-					agent.Hostname = hostname
-				}
-				if cpuType, ok := heartbeatData.SystemInfo["cpu_type"].(string); ok {
-					agent.CPUType = cpuType
-				}
-				if cpuLogic, ok := heartbeatData.SystemInfo["cpu_logic"].(float64); ok {
-					agent.CPULogic = int(cpuLogic)
+		// Only the token an agent registered with reports for it
+		if registered, ok := r.heartbeatTokenMatches(&heartbeatData, token); !ok {
+			apierror.Respond(c, apierror.Forbidden, "token does not belong to agent "+registered)
+			return
+		}
+	}
+
+	agentID, resync := r.applyHeartbeat(&heartbeatData)
+	response := gin.H{
+		"status":  "ok",
+		"message": "Heartbeat received",
+		"agent_id": agentID,
+		"resync":  resync,
+	}
+	// Agents evaluating alert rules compare it with the version they hold
+	if version := r.agentRulesVersion(agentID); version != "" {
+		response["rules_version"] = version
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// applyHeartbeat records a heartbeat and returns the ID of the agent it
+// came from, and whether the agent must resend its full inventory
+func (r *APIRouter) applyHeartbeat(hb *core.Heartbeat) (string, bool) {
+	agentID := hb.AgentID
+
+	// Update agent heartbeat in registry
+	if r.registry != nil {
+		if agent, interval := r.registry.Heartbeat(hb); agent != nil {
+			agentID = agent.ID
+			if r.metrics != nil {
+				if interval > 0 {
+					r.metrics.RecordHeartbeatInterval(interval)
 				}
-				if memory, ok := heartbeatData.SystemInfo["memory"].(string); ok {
-					agent.Memory = memory
+				if hb.Metrics != nil {
+					r.metrics.CollectAgentMetrics(agentID, metrics.AgentMetricsFromMap(hb.Metrics))
 				}
 			}
-			r.registry.Update(agentID, agent)
+			r.handleAgentAlerts(agentID, hb)
 		}
 		// If agent not found, still return success (may not be registered yet)
 	}
-	
-	c.JSON(http.StatusOK, gin.H{
-		"status":  "ok",
-		"message": "Heartbeat received",
-		"agent_id": agentID,
-	})
+
+	if r.metrics != nil {
+		r.metrics.RecordHeartbeat(true)
+	}
+
+	// Ask for the full inventory if a delta heartbeat refers to one we don't hold
+	return agentID, r.registry != nil && r.registry.NeedsInventory(hb)
 }
 
 // Update agent status handler
@@ -722,22 +1513,15 @@ func (r *APIRouter) updateAgentStatus(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&statusUpdate); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
-	// Validate status values
-	validStatuses := []string{"online", "offline", "maintenance", "error"}
-	if !contains(validStatuses, statusUpdate.Status) {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "invalid status. Must be one of: online, offline, maintenance, error",
-		})
+	if err := r.setAgentStatusByID(agentID, statusUpdate.Status); err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
 		return
 	}
 
-	// TODO: Update agent status in registry
-	// For now, return success
-	
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "updated",
 		"message": "Agent status updated successfully",
@@ -746,13 +1530,26 @@ func (r *APIRouter) updateAgentStatus(c *gin.Context) {
 	})
 }
 
+// setAgentStatusByID validates and sets the status of an agent
+func (r *APIRouter) setAgentStatusByID(agentID, status string) error {
+	if !contains(validAgentStatuses, status) {
+		return errInvalidAgentStatus
+	}
+	if r.registry != nil && !r.registry.SetStatus(agentID, status) {
+		return errAgentNotFound
+	}
+	return nil
+}
+
 // Delete agent handler
 func (r *APIRouter) deleteAgent(c *gin.Context) {
 	agentID := c.Param("id")
-	
-	// TODO: Remove agent from registry
-	// For now, return success
-	
+
+	if err := r.removeAgentByID(agentID); err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "deleted",
 		"message": "Agent deleted successfully",
@@ -760,29 +1557,64 @@ func (r *APIRouter) deleteAgent(c *gin.Context) {
 	})
 }
 
+// removeAgentByID deregisters an agent and drops its metrics
+func (r *APIRouter) removeAgentByID(agentID string) error {
+	if r.registry != nil && !r.registry.Remove(agentID) {
+		return errAgentNotFound
+	}
+
+	// Drop per-agent series so deregistered agents don't linger in Prometheus
+	if r.metrics != nil {
+		r.metrics.RemoveAgentMetrics(agentID)
+	}
+	return nil
+}
+
 // Install script handler
 func (r *APIRouter) installScript(c *gin.Context) {
 	token := c.Query("token")
 	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
+		apierror.Respond(c, apierror.InvalidRequest, "token required")
+		return
+	}
+
+	tokenInfo, ok := r.authorizeDownload(c)
+	if !ok {
+		return
+	}
+	if r.auditLogger != nil {
+		r.auditLogger.LogDownload(c, tokenInfo, "install", "success", nil)
+	}
+
+	opts, err := install.OptionsFromQuery(c)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
+	opts.Token = token
+	opts.ServerURL = c.Query("server")
+	opts.DownloadPath = "/api/download"
 
-	script := generateInstallScript(token)
+	script, err := install.Render(opts)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
 	c.Header("Content-Type", "text/plain")
 	c.String(http.StatusOK, script)
 }
 
 // Download agent binary handler
 func (r *APIRouter) downloadAgent(c *gin.Context) {
-	token := c.Query("token")
-	if token == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "token required"})
+	if security.TokenFromRequest(c) == "" {
+		apierror.Respond(c, apierror.InvalidRequest, "token required")
 		return
 	}
 
-	// TODO: Validate token
-	// For now, check if token is not empty
+	tokenInfo, ok := r.authorizeDownload(c)
+	if !ok {
+		return
+	}
 
 	// Get current working directory for better path resolution
 	wd, err := os.Getwd()
@@ -824,14 +1656,21 @@ func (r *APIRouter) downloadAgent(c *gin.Context) {
 	}
 
 	if !found {
-		c.JSON(http.StatusNotFound, gin.H{
-			"error": "Agent binary not found. Please build it first: cd agent && go build -o nerve-agent",
-			"hint":  "Checked paths: " + strings.Join(possiblePaths, ", "),
-			"cwd":   wd,
-		})
+		apierror.RespondDetails(c, apierror.BinaryNotFound,
+			"Agent binary not found. Please build it first: cd agent && go build -o nerve-agent",
+			gin.H{
+				"hint": "Checked paths: " + strings.Join(possiblePaths, ", "),
+				"cwd":  wd,
+			})
 		return
 	}
 
+	if r.auditLogger != nil {
+		r.auditLogger.LogDownload(c, tokenInfo, "binary/nerve-agent", "success", map[string]interface{}{
+			"path": binaryPath,
+		})
+	}
+
 	// Set headers for file download
 	c.Header("Content-Type", "application/octet-stream")
 	c.Header("Content-Disposition", "attachment; filename=nerve-agent")
@@ -840,6 +1679,26 @@ func (r *APIRouter) downloadAgent(c *gin.Context) {
 	c.File(binaryPath)
 }
 
+// authorizeDownload validates the request token and writes a 401 response when it is missing or invalid
+func (r *APIRouter) authorizeDownload(c *gin.Context) (*security.TokenInfo, bool) {
+	if r.tokenManager == nil {
+		return nil, true
+	}
+
+	tokenInfo, err := r.tokenManager.ValidateRequestToken(c)
+	if err != nil {
+		if r.auditLogger != nil {
+			r.auditLogger.LogDownload(c, nil, c.Request.URL.Path, "denied", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		apierror.Respond(c, apierror.Unauthorized, err.Error())
+		return nil, false
+	}
+
+	return tokenInfo, true
+}
+
 // Helper functions
 func generateRandomID(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
@@ -850,6 +1709,27 @@ func generateRandomID(length int) string {
 	return string(b)
 }
 
+// validAgentStatuses are the statuses an agent can be set to
+var validAgentStatuses = []string{"online", "offline", "maintenance", "error"}
+
+// Errors returned by the single-agent operations shared with bulk endpoints
+var (
+	errAgentNotFound      = errors.New("agent not found")
+	errInvalidAgentStatus = errors.New("invalid status. Must be one of: online, offline, maintenance, error")
+	errAgentNotConnected  = errors.New("agent has no open control channel")
+	errRestartUnsupported = errors.New("agent does not support restart")
+)
+
+// The agent operation errors map to their codes wherever they are returned
+func init() {
+	apierror.Register(errAgentNotFound, apierror.AgentNotFound)
+	apierror.Register(cluster.ErrClusterNotFound, apierror.ClusterNotFound)
+	apierror.Register(errInvalidAgentStatus, apierror.InvalidStatus)
+	apierror.Register(errAgentNotConnected, apierror.AgentNotConnected)
+	apierror.Register(errRestartUnsupported, apierror.UnsupportedTask)
+	apierror.Register(core.ErrIdempotencyKeyReused, apierror.IdempotencyKeyReused)
+}
+
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
 		if s == item {
@@ -859,132 +1739,135 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func generateInstallScript(token string) string {
-	return `#!/bin/bash
-set -e
-
-# Parse arguments
-SERVER_URL="${SERVER_URL:-http://localhost:8090}"
-TOKEN=""
-while [[ "$#" -gt 0 ]]; do
-  case $1 in
-    --token) TOKEN="$2"; shift ;;
-    --server) SERVER_URL="$2"; shift ;;
-  esac
-  shift
-done
-
-if [ -z "$TOKEN" ]; then
-  echo "Error: --token is required"
-  exit 1
-fi
-
-echo "Installing Nerve Agent..."
-
-# Download agent binary
-curl -fSL "$SERVER_URL/api/download?token=$TOKEN" -o /usr/local/bin/nerve-agent
-chmod +x /usr/local/bin/nerve-agent
-
-# Create systemd service
-cat > /etc/systemd/system/nerve-agent.service << EOF
-[Unit]
-Description=Nerve Agent
-After=network.target
-
-[Service]
-Type=simple
-ExecStart=/usr/local/bin/nerve-agent --server=$SERVER_URL --token=$TOKEN
-Restart=always
-RestartSec=10
-
-[Install]
-WantedBy=multi-user.target
-EOF
-
-# Enable and start service
-systemctl daemon-reload
-systemctl enable nerve-agent
-systemctl start nerve-agent
-
-echo "Nerve Agent installed successfully!"
-`
-}
-
 // NewMetricsHandler creates a metrics handler for Prometheus
 func NewMetricsHandler(collector *metrics.MetricsCollector) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// TODO: Implement Prometheus metrics endpoint
-		c.JSON(http.StatusOK, gin.H{
-			"message": "Metrics endpoint not yet implemented",
-		})
-	}
+	return gin.WrapH(promhttp.Handler())
 }
 
 // Token management handlers
 func (r *APIRouter) generateToken(c *gin.Context) {
 	var tokenRequest struct {
-		Name      string `json:"name" binding:"required"`
-		ExpiresIn int    `json:"expires_in"` // seconds
+		Name        string   `json:"name" binding:"required"`
+		ExpiresIn   int      `json:"expires_in"` // seconds
+		Role        string   `json:"role"`        // grants the role's permissions
+		Permissions []string `json:"permissions"` // e.g. "agents:read"
+		UserID      string   `json:"user_id"`     // the user the token belongs to
 	}
 
 	if err := c.ShouldBindJSON(&tokenRequest); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if r.tokenManager == nil {
+		apierror.Respond(c, apierror.Unavailable, "token manager not available")
+		return
+	}
+
+	permissions := append([]string{}, tokenRequest.Permissions...)
+	if tokenRequest.Role != "" {
+		if r.permissions == nil {
+			apierror.Respond(c, apierror.InvalidRequest, "roles are not available with auth disabled")
+			return
+		}
+		rolePermissions, err := r.permissions.RolePermissions(tokenRequest.Role)
+		if err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+		permissions = append(permissions, rolePermissions...)
+	}
+
+	// Issue through the token manager so install and download endpoints accept it
+	ttl := time.Duration(tokenRequest.ExpiresIn) * time.Second
+	var tokenInfo *security.TokenInfo
+	var err error
+	if tokenRequest.UserID != "" {
+		if r.permissions == nil {
+			apierror.Respond(c, apierror.InvalidRequest, "users are not available with auth disabled")
+			return
+		}
+		if _, err := r.permissions.GetUser(tokenRequest.UserID); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+		tokenInfo, err = r.tokenManager.CreateUserToken(tokenRequest.UserID, tokenRequest.Name, permissions, ttl)
+	} else {
+		tokenInfo, err = r.tokenManager.CreateToken(tokenRequest.Name, "", permissions, ttl)
+	}
+	if err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
 		return
 	}
 
-	// Generate a random token
-	token := generateRandomToken(32)
-	
-	// TODO: Store token in database with expiration
-	// For now, return the token directly
-	
 	c.JSON(http.StatusOK, gin.H{
-		"token":      token,
-		"name":       tokenRequest.Name,
-		"expires_at": time.Now().Add(time.Duration(tokenRequest.ExpiresIn) * time.Second),
-		"created_at": time.Now(),
+		"id":          tokenInfo.ID,
+		"token":       tokenInfo.Token,
+		"name":        tokenInfo.Name,
+		"owner_id":    tokenInfo.OwnerID,
+		"permissions": tokenInfo.Permissions,
+		"expires_at":  tokenInfo.ExpiresAt,
+		"created_at":  tokenInfo.CreatedAt,
 	})
 }
 
+// listTokens returns a page of tokens by ID and masked prefix, optionally
+// filtered by status and agent
+//
+// GET /api/v1/tokens/list?status=&agent_id=&offset=&limit=
 func (r *APIRouter) listTokens(c *gin.Context) {
-	// TODO: Get tokens from database
-	// For now, return mock data
-	tokens := []gin.H{
-		{
-			"id":         "token-001",
-			"name":       "Agent安装Token_2025-01-28T10:30:00",
-			"token":      "nerve_abc123...",
-			"created_at": time.Now().Add(-2 * time.Hour),
-			"expires_at": time.Now().Add(22 * time.Hour),
-			"status":     "active",
-		},
+	filter := security.TokenFilter{
+		Status:  c.Query("status"),
+		AgentID: c.Query("agent_id"),
 	}
-	
+	for param, target := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if value := c.Query(param); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				apierror.Respond(c, apierror.InvalidRequest, "invalid "+param)
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	if r.tokenManager == nil {
+		if err := filter.Validate(); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"tokens": []*security.TokenSummary{}, "total": 0, "offset": filter.Offset})
+		return
+	}
+
+	tokens, total, err := r.tokenManager.QueryTokens(filter)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"tokens": tokens,
-		"total":  len(tokens),
+		"total":  total,
+		"offset": filter.Offset,
 	})
 }
 
 func (r *APIRouter) revokeToken(c *gin.Context) {
 	tokenID := c.Param("id")
-	
-	// TODO: Revoke token in database
-	// For now, return success
-	
+
+	if r.tokenManager == nil {
+		apierror.Respond(c, apierror.Unavailable, "token manager not available")
+		return
+	}
+
+	if err := r.tokenManager.RevokeTokenByID(tokenID); err != nil {
+		apierror.RespondError(c, err, apierror.TokenNotFound)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Token revoked successfully",
 		"token_id": tokenID,
 	})
 }
-
-// Helper function to generate random token
-func generateRandomToken(length int) string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%int64(len(charset))]
-	}
-	return "nerve_" + string(b)
-}
-
@@ -0,0 +1,106 @@
+// Package api provides handlers for recurring task schedules.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+func (r *APIRouter) listSchedules(c *gin.Context) {
+	if r.scheduler == nil {
+		c.JSON(http.StatusOK, gin.H{"schedules": []*core.Schedule{}, "total": 0})
+		return
+	}
+
+	schedules := r.scheduler.ListSchedules()
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+
+	c.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+		"total":     len(schedules),
+	})
+}
+
+func (r *APIRouter) createSchedule(c *gin.Context) {
+	var schedule core.Schedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.Unavailable, "scheduler not available")
+		return
+	}
+
+	if err := r.scheduler.AddSchedule(&schedule); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Schedule created successfully",
+		"schedule": schedule,
+	})
+}
+
+func (r *APIRouter) getSchedule(c *gin.Context) {
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.ScheduleNotFound, "schedule not found")
+		return
+	}
+
+	schedule, err := r.scheduler.GetSchedule(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, err, apierror.ScheduleNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schedule": schedule})
+}
+
+func (r *APIRouter) updateSchedule(c *gin.Context) {
+	var schedule core.Schedule
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.ScheduleNotFound, "schedule not found")
+		return
+	}
+
+	if err := r.scheduler.UpdateSchedule(c.Param("id"), &schedule); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Schedule updated successfully",
+		"schedule": schedule,
+	})
+}
+
+func (r *APIRouter) deleteSchedule(c *gin.Context) {
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.ScheduleNotFound, "schedule not found")
+		return
+	}
+
+	if err := r.scheduler.DeleteSchedule(c.Param("id")); err != nil {
+		apierror.RespondError(c, err, apierror.ScheduleNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Schedule deleted successfully",
+	})
+}
@@ -0,0 +1,84 @@
+// Package api provides the search of the output of a batch of tasks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// searchTaskOutput streams the tasks of a batch whose output contains a
+// substring or matches a regular expression, with the matching lines. The
+// matches are written as they are found, followed by a summary of the scan.
+//
+// GET /api/v1/tasks/search?batch=batch-20251028100000-1a2b3c4d&output_contains=CRITICAL
+func (r *APIRouter) searchTaskOutput(c *gin.Context) {
+	search := core.OutputSearch{
+		BatchID:  c.Query("batch"),
+		Contains: c.Query("output_contains"),
+		Regex:    c.Query("output_regex"),
+	}
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			apierror.Respond(c, apierror.InvalidRequest, "limit must be a positive integer")
+			return
+		}
+		search.Limit = n
+	}
+	if err := search.Validate(); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+	if r.scheduler == nil {
+		apierror.Respond(c, apierror.Unavailable, "scheduler not available")
+		return
+	}
+
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Status(http.StatusOK)
+	if _, err := c.Writer.WriteString(`{"batch_id":` + strconv.Quote(search.BatchID) + `,"matches":[`); err != nil {
+		c.Error(err)
+		return
+	}
+
+	first := true
+	summary, err := r.scheduler.SearchOutput(search, func(match *core.OutputMatch) error {
+		data, err := json.Marshal(match)
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := c.Writer.WriteString(","); err != nil {
+				return err
+			}
+		}
+		first = false
+		if _, err := c.Writer.Write(data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+	if err != nil {
+		// Headers are out by now, the client sees a truncated response
+		c.Error(err)
+		return
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	c.Writer.WriteString(`],"summary":`)
+	c.Writer.Write(data)
+	c.Writer.WriteString("}\n")
+}
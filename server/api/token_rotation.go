@@ -0,0 +1,77 @@
+// Package api provides the control channel side of automatic agent token
+// rotation: pushing successor tokens and recording the agents' acks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"time"
+
+	"github.com/nerve/server/pkg/security"
+	"github.com/nerve/server/pkg/websocket"
+)
+
+// pushTokenRotation sends a successor token to an agent over its control
+// channel and reports whether it was delivered. Only a control channel
+// opened with the current token receives it, so connecting under another
+// agent's ID never yields its token.
+func (r *APIRouter) pushTokenRotation(agentID string, current, successor *security.TokenInfo) bool {
+	if r.wsManager == nil || !r.wsManager.AgentConnectedWith(agentID, current.Token) {
+		return false
+	}
+
+	message, err := websocket.NewWebSocketMessage("token_rotate", agentID, map[string]interface{}{
+		"token_id":       successor.ID,
+		"token":          successor.Token,
+		"expires_at":     successor.ExpiresAt.Format(time.RFC3339),
+		"predecessor_id": successor.PredecessorID,
+	}).ToJSON()
+	if err != nil {
+		return false
+	}
+	return r.wsManager.SendToAgent(agentID, message)
+}
+
+// handleTokenRotateAck revokes the token an agent replaced once it reports
+// having switched to the successor. A rejected rotation leaves the old token
+// valid until it expires.
+func (r *APIRouter) handleTokenRotateAck(client *websocket.Client, msg *websocket.WebSocketMessage) {
+	// Trust the agent ID of the connection, not of the message
+	agentID := client.AgentID
+	if r.tokenManager == nil || agentID == "" {
+		return
+	}
+
+	tokenID, _ := msg.Data["token_id"].(string)
+	errMsg, _ := msg.Data["error"].(string)
+	result := "success"
+	if applied, _ := msg.Data["applied"].(bool); !applied {
+		result = "failure"
+		if errMsg == "" {
+			errMsg = "token not applied"
+		}
+	} else if successor, err := r.tokenManager.AckRotation(agentID, tokenID); err != nil {
+		result = "failure"
+		errMsg = err.Error()
+	} else if r.registry != nil {
+		// Decommissioning retires the token the agent now uses
+		r.registry.BindToken(agentID, successor.Token)
+	}
+
+	if r.auditLogger != nil {
+		details := map[string]interface{}{"token_id": tokenID}
+		if errMsg != "" {
+			details["error"] = errMsg
+		}
+		r.auditLogger.LogEvent(&security.AuditEvent{
+			EventType: "authentication",
+			AgentID:   agentID,
+			UserID:    agentID,
+			Action:    "rotate_token",
+			Resource:  "token",
+			Result:    result,
+			Details:   details,
+		})
+	}
+}
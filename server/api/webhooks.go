@@ -0,0 +1,130 @@
+// Package api provides handlers for agent lifecycle webhooks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package api
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/webhook"
+)
+
+// SetWebhookManager enables the lifecycle webhook endpoints
+func (r *APIRouter) SetWebhookManager(webhooks *webhook.Manager) {
+	r.webhooks = webhooks
+}
+
+func (r *APIRouter) listWebhooks(c *gin.Context) {
+	if r.webhooks == nil {
+		c.JSON(http.StatusOK, gin.H{"webhooks": []*webhook.Webhook{}, "total": 0})
+		return
+	}
+
+	webhooks := r.webhooks.List()
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": webhooks,
+		"total":    len(webhooks),
+	})
+}
+
+// createWebhook adds a webhook. The response is the only one carrying the
+// webhook's signing secret.
+func (r *APIRouter) createWebhook(c *gin.Context) {
+	var hook webhook.Webhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if r.webhooks == nil {
+		apierror.Respond(c, apierror.Unavailable, "webhooks not available")
+		return
+	}
+
+	if err := r.webhooks.Create(&hook); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook created successfully",
+		"webhook": hook,
+	})
+}
+
+func (r *APIRouter) getWebhook(c *gin.Context) {
+	if r.webhooks == nil {
+		apierror.Respond(c, apierror.WebhookNotFound, "webhook not found")
+		return
+	}
+
+	hook, err := r.webhooks.Get(c.Param("id"))
+	if err != nil {
+		apierror.RespondError(c, err, apierror.WebhookNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook": hook})
+}
+
+func (r *APIRouter) updateWebhook(c *gin.Context) {
+	var hook webhook.Webhook
+	if err := c.ShouldBindJSON(&hook); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	if r.webhooks == nil {
+		apierror.Respond(c, apierror.WebhookNotFound, "webhook not found")
+		return
+	}
+
+	if err := r.webhooks.Update(c.Param("id"), &hook); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	updated, _ := r.webhooks.Get(c.Param("id"))
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook updated successfully",
+		"webhook": updated,
+	})
+}
+
+func (r *APIRouter) deleteWebhook(c *gin.Context) {
+	if r.webhooks == nil {
+		apierror.Respond(c, apierror.WebhookNotFound, "webhook not found")
+		return
+	}
+
+	if err := r.webhooks.Delete(c.Param("id")); err != nil {
+		apierror.RespondError(c, err, apierror.WebhookNotFound)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// listWebhookDeadLetters returns the events webhooks persistently failed to
+// receive, optionally of one webhook
+//
+// GET /api/v1/webhooks/dead-letters?webhook_id=
+func (r *APIRouter) listWebhookDeadLetters(c *gin.Context) {
+	letters := []webhook.DeadLetter{}
+	if r.webhooks != nil {
+		letters = r.webhooks.DeadLetters(c.Query("webhook_id"))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": letters,
+		"total":        len(letters),
+	})
+}
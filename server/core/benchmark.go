@@ -0,0 +1,226 @@
+// Package core provides benchmark tasks and the per-agent history of their
+// results.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Built-in benchmarks agents can run, named in a benchmark task's params
+const (
+	BenchmarkCPU  = "cpu"
+	BenchmarkDisk = "disk"
+)
+
+// Benchmarks lists the built-in benchmarks
+var Benchmarks = []string{BenchmarkCPU, BenchmarkDisk}
+
+// maxBenchmarkResults caps the results kept per agent and benchmark
+const maxBenchmarkResults = 20
+
+// BenchmarkResult is the outcome of a benchmark task
+type BenchmarkResult struct {
+	TaskID      string                 `json:"task_id"`
+	Benchmark   string                 `json:"benchmark"`
+	Params      map[string]interface{} `json:"params,omitempty"`
+	Results     map[string]interface{} `json:"results"`
+	CompletedAt time.Time              `json:"completed_at"`
+}
+
+// ValidateBenchmark checks a benchmark is built in
+func ValidateBenchmark(name string) error {
+	for _, benchmark := range Benchmarks {
+		if name == benchmark {
+			return nil
+		}
+	}
+	return fmt.Errorf("benchmark must be one of: %s", strings.Join(Benchmarks, ", "))
+}
+
+// benchmarkParams returns a copy of params naming the benchmark to run
+func benchmarkParams(benchmark string, params map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(params)+1)
+	for key, value := range params {
+		copied[key] = value
+	}
+	copied["benchmark"] = benchmark
+	return copied
+}
+
+// ScheduleBenchmark schedules a built-in benchmark on an agent. params tune
+// the benchmark, e.g. duration for cpu or size_mb for disk.
+func (s *Scheduler) ScheduleBenchmark(agentID, benchmark string, params map[string]interface{}, requestID string) (*Task, error) {
+	if err := ValidateBenchmark(benchmark); err != nil {
+		return nil, err
+	}
+
+	task := &Task{
+		ID:        generateTaskID(),
+		AgentID:   agentID,
+		Type:      "benchmark",
+		Params:    benchmarkParams(benchmark, params),
+		Status:    "pending",
+		RequestID: requestID,
+	}
+
+	s.SubmitTask(task)
+	return task, nil
+}
+
+// benchmarkRunningLocked reports whether a benchmark was dispatched to an
+// agent and hasn't reported back; caller must hold s.mu
+func (s *Scheduler) benchmarkRunningLocked(agentID string) bool {
+	for _, task := range s.tasks {
+		if task.AgentID == agentID && task.Type == "benchmark" && task.Status == "running" {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordBenchmarkResult stores the structured results of a successful
+// benchmark task with its agent. Call it before MarkTaskDone; results of
+// other tasks, or of tasks no longer running, are ignored.
+func (s *Scheduler) RecordBenchmarkResult(result *TaskResult) {
+	if !result.Success || len(result.Data) == 0 || s.registry == nil {
+		return
+	}
+
+	s.refreshTask(result.TaskID)
+	s.mu.RLock()
+	task, ok := s.tasks[result.TaskID]
+	if !ok || task.Type != "benchmark" || task.Status != "running" {
+		s.mu.RUnlock()
+		return
+	}
+	agentID := task.AgentID
+	params := make(map[string]interface{}, len(task.Params))
+	for key, value := range task.Params {
+		if key != "benchmark" {
+			params[key] = value
+		}
+	}
+	benchmark, _ := task.Params["benchmark"].(string)
+	s.mu.RUnlock()
+
+	s.registry.recordBenchmark(agentID, BenchmarkResult{
+		TaskID:      result.TaskID,
+		Benchmark:   benchmark,
+		Params:      params,
+		Results:     result.Data,
+		CompletedAt: time.Now(),
+	})
+}
+
+// benchmarksKey is the storage key of an agent's benchmark results
+func benchmarksKey(agentID string) string {
+	return "agent_benchmarks:" + agentID
+}
+
+// recordBenchmark appends a benchmark result to an agent's history, keeping
+// the latest maxBenchmarkResults per benchmark, and persists the history
+func (r *Registry) recordBenchmark(agentID string, result BenchmarkResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := append(r.loadBenchmarksLocked(agentID), result)
+	count := 0
+	kept := make([]BenchmarkResult, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Benchmark == result.Benchmark {
+			count++
+			if count > maxBenchmarkResults {
+				continue
+			}
+		}
+		kept = append(kept, history[i])
+	}
+	// Back to oldest first
+	for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+		kept[i], kept[j] = kept[j], kept[i]
+	}
+	r.benchmarks[agentID] = kept
+
+	if r.store != nil {
+		data, err := json.Marshal(kept)
+		if err == nil {
+			err = r.store.Set(benchmarksKey(agentID), string(data))
+		}
+		if err != nil {
+			r.logger.Errorf("Failed to persist benchmark results of agent %s: %v", agentID, err)
+		}
+	}
+	// Other servers drop their cached results along with the agent record
+	if _, ok := r.agents[agentID]; ok {
+		r.sharedChangedLocked(agentID)
+	}
+	r.logger.Infof("Recorded %s benchmark of agent %s", result.Benchmark, agentID)
+}
+
+// BenchmarkResults returns an agent's benchmark results, oldest first,
+// optionally only those of one benchmark
+func (r *Registry) BenchmarkResults(agentID, benchmark string) []BenchmarkResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := []BenchmarkResult{}
+	for _, result := range r.loadBenchmarksLocked(agentID) {
+		if benchmark == "" || result.Benchmark == benchmark {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// loadBenchmarksLocked returns the cached benchmark results, falling back to
+// storage; caller must hold r.mu
+func (r *Registry) loadBenchmarksLocked(agentID string) []BenchmarkResult {
+	if results, ok := r.benchmarks[agentID]; ok {
+		return results
+	}
+	if r.store == nil {
+		return nil
+	}
+
+	value, err := r.store.Get(benchmarksKey(agentID))
+	if err != nil {
+		return nil
+	}
+	data, ok := value.(string)
+	if !ok {
+		return nil
+	}
+
+	var results []BenchmarkResult
+	if err := json.Unmarshal([]byte(data), &results); err != nil {
+		r.logger.Errorf("Invalid stored benchmark results for agent %s: %v", agentID, err)
+		return nil
+	}
+	r.benchmarks[agentID] = results
+	return results
+}
+
+// CompareBenchmarks returns the change of each numeric result from previous
+// to latest in percent, for before/after comparisons. Results that are zero
+// in previous or not numeric in both are left out.
+func CompareBenchmarks(previous, latest BenchmarkResult) map[string]float64 {
+	change := make(map[string]float64)
+	for key, value := range latest.Results {
+		after, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		before, ok := previous.Results[key].(float64)
+		if !ok || before == 0 {
+			continue
+		}
+		change[key] = (after - before) / before * 100
+	}
+	return change
+}
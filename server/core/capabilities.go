@@ -0,0 +1,148 @@
+// Package core provides the capabilities agents advertise at registration.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nerve/server/pkg/log"
+)
+
+// Agent capabilities. Task types double as the capability needed to run them.
+const (
+	CapabilityCommand        = "command"
+	CapabilityScript         = "script"
+	CapabilityHook           = "hook"
+	CapabilityUpdate         = "update"
+	CapabilityBenchmark      = "benchmark"
+	CapabilityDeltaHeartbeat = "delta_heartbeat"
+	CapabilityCustomMetrics  = "custom_metrics"
+	CapabilityGRPC           = "grpc"
+	CapabilityControl        = "control"
+	CapabilityLogTail        = "log_tail"
+	CapabilityDecommission   = "decommission"
+	CapabilityExec           = "exec"
+	CapabilityRecentLogs     = "recent_logs"
+	CapabilityAgentAlerts    = "agent_alerts"
+	CapabilityRefresh        = "refresh"
+	CapabilityRestart        = "restart"
+)
+
+// maxCapabilities caps the capabilities an agent may advertise
+const maxCapabilities = 64
+
+// BaselineCapabilities are assumed for agents that register without
+// advertising any, i.e. agents that predate capability negotiation. Only the
+// task types every such agent accepts are included.
+var BaselineCapabilities = []string{
+	CapabilityCommand,
+	CapabilityScript,
+	CapabilityHook,
+	CapabilityUpdate,
+}
+
+// normalizeCapabilities sorts and de-duplicates advertised capabilities,
+// falling back to the baseline when none are advertised
+func normalizeCapabilities(capabilities []string) []string {
+	if len(capabilities) == 0 {
+		return append([]string(nil), BaselineCapabilities...)
+	}
+
+	seen := make(map[string]bool, len(capabilities))
+	normalized := make([]string, 0, len(capabilities))
+	for _, capability := range capabilities {
+		if capability == "" || seen[capability] {
+			continue
+		}
+		seen[capability] = true
+		normalized = append(normalized, capability)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// validateCapabilities checks the capabilities of a registration payload
+func validateCapabilities(capabilities []string) error {
+	if len(capabilities) > maxCapabilities {
+		return fmt.Errorf("capabilities: must have at most %d items", maxCapabilities)
+	}
+	for _, capability := range capabilities {
+		if err := validateString(capability, maxFieldLength); err != nil {
+			return fmt.Errorf("capabilities: %v", err)
+		}
+	}
+	return nil
+}
+
+// HasCapability reports whether the agent advertised a capability
+func (a *AgentInfo) HasCapability(capability string) bool {
+	return hasCapability(a.Capabilities, capability)
+}
+
+// SupportsTask reports whether the agent can run tasks of the given type
+func (a *AgentInfo) SupportsTask(taskType string) bool {
+	return a.HasCapability(taskType)
+}
+
+// Capabilities returns a copy of an agent's capabilities, or nil if the
+// agent is unknown
+func (r *Registry) Capabilities(id string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent, ok := r.agents[id]
+	if !ok {
+		return nil
+	}
+	return append([]string{}, agent.Capabilities...)
+}
+
+// HasCapability reports whether a registered agent advertised a capability
+func (r *Registry) HasCapability(id, capability string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent, ok := r.agents[id]
+	return ok && agent.HasCapability(capability)
+}
+
+// UnsupportedAgents returns the agents among ids that are known but can't
+// run tasks of the given type
+func (r *Registry) UnsupportedAgents(ids []string, taskType string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	unsupported := []string{}
+	for _, id := range ids {
+		if agent, ok := r.agents[id]; ok && !agent.SupportsTask(taskType) {
+			unsupported = append(unsupported, id)
+		}
+	}
+	return unsupported
+}
+
+// failUnsupportedLocked fails a task the agent lacks the capability for.
+// It isn't retried, as another attempt would be refused as well; caller must
+// hold s.mu.
+func (s *Scheduler) failUnsupportedLocked(task *Task, now time.Time) {
+	errMsg := fmt.Sprintf("agent does not support %s tasks", task.Type)
+	log.WithRequestID(s.logger, task.RequestID).Errorf("Task failed: %s - %s", task.ID, errMsg)
+
+	s.recordAttemptLocked(task, "failed", errMsg, now)
+	task.Status = "failed"
+	s.saveTaskLocked(task)
+}
+
+// hasCapability reports whether capabilities include capability
+func hasCapability(capabilities []string, capability string) bool {
+	for _, c := range capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
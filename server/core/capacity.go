@@ -0,0 +1,199 @@
+// Package core provides the free capacity of agents, derived from their
+// inventory and the utilization they last reported, for placing workloads.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// GPUIdleUtilization is the utilization in percent below which a GPU
+	// may count as free
+	GPUIdleUtilization = 10.0
+
+	// GPUIdleMemory is the share of its memory in percent a GPU may have in
+	// use and still count as free
+	GPUIdleMemory = 10.0
+)
+
+// Capacity sort orders; each ranks by the named resource first and breaks
+// ties with the others
+const (
+	CapacitySortGPU    = "gpu"
+	CapacitySortCPU    = "cpu"
+	CapacitySortMemory = "memory"
+)
+
+// AgentCapacity is the inventory of an agent and the part of it that is free
+type AgentCapacity struct {
+	AgentID  string `json:"agent_id"`
+	Hostname string `json:"hostname"`
+
+	// CPUCores is the logical core count; FreeCPUCores is the cores times
+	// the idle share of the reported CPU usage
+	CPUCores     int     `json:"cpu_cores"`
+	FreeCPUCores float64 `json:"free_cpu_cores"`
+
+	// MemoryGB is the total memory; FreeMemoryGB is the total times the
+	// unused share of the reported memory usage
+	MemoryGB     float64 `json:"memory_gb"`
+	FreeMemoryGB float64 `json:"free_memory_gb"`
+
+	// GPUs is the GPU count; FreeGPUs counts the GPUs reported below
+	// GPUIdleUtilization and GPUIdleMemory
+	GPUs     int    `json:"gpus"`
+	FreeGPUs int    `json:"free_gpus"`
+	GPUType  string `json:"gpu_type,omitempty"`
+
+	// ReportedAt is when the utilization was reported
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+// CapacityFilter selects agents with at least the given free resources;
+// zero fields match any agent
+type CapacityFilter struct {
+	MinFreeGPUs     int
+	MinFreeCPUCores float64
+	MinFreeMemoryGB float64
+}
+
+// matches reports whether a capacity has the free resources of the filter
+func (f CapacityFilter) matches(capacity *AgentCapacity) bool {
+	return capacity.FreeGPUs >= f.MinFreeGPUs &&
+		capacity.FreeCPUCores >= f.MinFreeCPUCores &&
+		capacity.FreeMemoryGB >= f.MinFreeMemoryGB
+}
+
+// agentCapacity computes the capacity of an agent from its last reported
+// usage, or returns nil if it has reported none
+func agentCapacity(agent *AgentInfo) *AgentCapacity {
+	usage := agent.Usage
+	if usage == nil {
+		return nil
+	}
+
+	// Memsum is reported in KiB
+	memoryGB := float64(agent.Memsum) / (1024 * 1024)
+	capacity := &AgentCapacity{
+		AgentID:      agent.ID,
+		Hostname:     agent.Hostname,
+		CPUCores:     agent.CPULogic,
+		FreeCPUCores: round2(float64(agent.CPULogic) * freeShare(usage.CPUUsage)),
+		MemoryGB:     round2(memoryGB),
+		FreeMemoryGB: round2(memoryGB * freeShare(usage.MemoryUsage)),
+		GPUs:         agent.GPUNum,
+		GPUType:      agent.GPUType,
+		ReportedAt:   usage.ReportedAt,
+	}
+
+	// GPUs without a utilization sample are never counted as free
+	if len(usage.GPUs) > capacity.GPUs {
+		capacity.GPUs = len(usage.GPUs)
+	}
+	for _, gpu := range usage.GPUs {
+		if gpu.Utilization < GPUIdleUtilization &&
+			gpu.MemoryTotalMB > 0 && gpu.MemoryUsedMB*100/gpu.MemoryTotalMB < GPUIdleMemory {
+			capacity.FreeGPUs++
+		}
+	}
+	return capacity
+}
+
+// freeShare returns the unused fraction of a usage percentage, clamped to 0-1
+func freeShare(usagePercent float64) float64 {
+	free := 1 - usagePercent/100
+	if free < 0 {
+		return 0
+	}
+	if free > 1 {
+		return 1
+	}
+	return free
+}
+
+// round2 rounds to two decimals
+func round2(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// Capacity returns the capacity of the online agents among agentIDs that
+// have the free resources of the filter, ranked by the sortBy resource. It
+// also returns the online agents that have not reported utilization yet.
+func (r *Registry) Capacity(agentIDs []string, filter CapacityFilter, sortBy string) ([]*AgentCapacity, []string, error) {
+	less, err := capacityOrder(sortBy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.RLock()
+	capacities := []*AgentCapacity{}
+	unreported := []string{}
+	for _, id := range agentIDs {
+		agent, ok := r.agents[id]
+		if !ok || agent.Status != "online" {
+			continue
+		}
+		capacity := agentCapacity(agent)
+		if capacity == nil {
+			unreported = append(unreported, id)
+			continue
+		}
+		if filter.matches(capacity) {
+			capacities = append(capacities, capacity)
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.SliceStable(capacities, func(i, j int) bool {
+		return less(capacities[i], capacities[j])
+	})
+	return capacities, unreported, nil
+}
+
+// capacityOrder returns the comparison ranking capacities by a sort order,
+// most free first
+func capacityOrder(sortBy string) (func(a, b *AgentCapacity) bool, error) {
+	gpu := func(a, b *AgentCapacity) int { return compareCapacity(float64(a.FreeGPUs), float64(b.FreeGPUs)) }
+	cpu := func(a, b *AgentCapacity) int { return compareCapacity(a.FreeCPUCores, b.FreeCPUCores) }
+	memory := func(a, b *AgentCapacity) int { return compareCapacity(a.FreeMemoryGB, b.FreeMemoryGB) }
+
+	var keys []func(a, b *AgentCapacity) int
+	switch sortBy {
+	case CapacitySortGPU, "":
+		keys = append(keys, gpu, cpu, memory)
+	case CapacitySortCPU:
+		keys = append(keys, cpu, memory, gpu)
+	case CapacitySortMemory:
+		keys = append(keys, memory, cpu, gpu)
+	default:
+		return nil, fmt.Errorf("invalid sort %q, must be one of: %s, %s, %s",
+			sortBy, CapacitySortGPU, CapacitySortCPU, CapacitySortMemory)
+	}
+
+	return func(a, b *AgentCapacity) bool {
+		for _, key := range keys {
+			if c := key(a, b); c != 0 {
+				return c > 0
+			}
+		}
+		return a.AgentID < b.AgentID
+	}, nil
+}
+
+// compareCapacity returns 1 if a is more than b, -1 if less, else 0
+func compareCapacity(a, b float64) int {
+	switch {
+	case a > b:
+		return 1
+	case a < b:
+		return -1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,78 @@
+// Package core provides clock skew detection from agent heartbeat timestamps.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"time"
+)
+
+// DefaultMaxClockSkew is the clock difference tolerated before an agent is
+// flagged; it also absorbs the network delay of the heartbeat
+const DefaultMaxClockSkew = 30 * time.Second
+
+// ClockSkewAlertData returns a skew as alert rule input, matchable with
+// conditions on event, skew_seconds and abs_skew_seconds
+func ClockSkewAlertData(skew time.Duration) map[string]interface{} {
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	return map[string]interface{}{
+		"event":            "clock_skew",
+		"skew_seconds":     skew.Seconds(),
+		"abs_skew_seconds": abs.Seconds(),
+	}
+}
+
+// SetMaxClockSkew sets the clock difference tolerated before an agent is flagged
+func (r *Registry) SetMaxClockSkew(max time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxClockSkew = max
+}
+
+// OnClockSkew registers a callback invoked when an agent's clock drifts
+// beyond the tolerated skew. It fires once per drift, not on every heartbeat.
+func (r *Registry) OnClockSkew(handler func(agentID string, skew time.Duration)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.skewHandlers = append(r.skewHandlers, handler)
+}
+
+// updateClockSkewLocked records the difference between the time an agent
+// reported and the server time, and returns it with whether the agent just
+// became skewed; caller must hold r.mu
+func (r *Registry) updateClockSkewLocked(agent *AgentInfo, reported, now time.Time) (time.Duration, bool) {
+	if reported.IsZero() {
+		return 0, false
+	}
+
+	skew := reported.Sub(now)
+	agent.ClockSkew = skew.Seconds()
+
+	wasSkewed := agent.ClockSkewed
+	agent.ClockSkewed = skew > r.maxClockSkew || skew < -r.maxClockSkew
+	if agent.ClockSkewed && !wasSkewed {
+		r.logger.Infof("Agent %s clock is skewed by %v", agent.ID, skew.Round(time.Second))
+		return skew, true
+	}
+	if wasSkewed && !agent.ClockSkewed {
+		r.logger.Infof("Agent %s clock is back in sync", agent.ID)
+	}
+	return skew, false
+}
+
+// notifyClockSkew invokes the clock skew handlers; must be called without r.mu held
+func (r *Registry) notifyClockSkew(agentID string, skew time.Duration) {
+	r.mu.RLock()
+	handlers := r.skewHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, skew)
+	}
+}
@@ -0,0 +1,185 @@
+// Package core provides versioned agent configuration pushed by the server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/nerve/server/pkg/storage"
+)
+
+// minHeartbeatInterval mirrors the smallest interval agents accept
+const minHeartbeatInterval = time.Second
+
+var pluginNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Alert evaluation modes of an agent
+const (
+	// AlertEvaluationServer has the agent send its metrics every heartbeat;
+	// the default
+	AlertEvaluationServer = "server"
+
+	// AlertEvaluationAgent has the agent evaluate the pushed alert rules and
+	// report alert candidates, sending its metrics only now and then
+	AlertEvaluationAgent = "agent"
+)
+
+// AgentConfig is the desired runtime configuration of an agent. Unset fields
+// leave the agent's current setting unchanged.
+type AgentConfig struct {
+	Version           int64     `json:"version"`
+	HeartbeatInterval string    `json:"heartbeat_interval,omitempty"`
+	Debug             *bool     `json:"debug,omitempty"`
+	Plugins           []string  `json:"plugins,omitempty"`
+	AlertEvaluation   string    `json:"alert_evaluation,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ConfigStatus is the last configuration acknowledgement from an agent
+type ConfigStatus struct {
+	AppliedVersion int64     `json:"applied_version"`
+	Error          string    `json:"error,omitempty"`
+	AckedAt        time.Time `json:"acked_at,omitempty"`
+}
+
+// Validate checks the configuration before it is stored and pushed
+func (c *AgentConfig) Validate() error {
+	if c.HeartbeatInterval != "" {
+		interval, err := time.ParseDuration(c.HeartbeatInterval)
+		if err != nil {
+			return fmt.Errorf("invalid heartbeat_interval: %v", err)
+		}
+		if interval < minHeartbeatInterval {
+			return fmt.Errorf("heartbeat_interval must be at least %v", minHeartbeatInterval)
+		}
+	}
+	for _, name := range c.Plugins {
+		if !pluginNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid plugin name: %q", name)
+		}
+	}
+	switch c.AlertEvaluation {
+	case "", AlertEvaluationServer, AlertEvaluationAgent:
+	default:
+		return fmt.Errorf("invalid alert_evaluation %q, must be %q or %q", c.AlertEvaluation, AlertEvaluationServer, AlertEvaluationAgent)
+	}
+	if c.HeartbeatInterval == "" && c.Debug == nil && c.Plugins == nil && c.AlertEvaluation == "" {
+		return fmt.Errorf("config must set at least one of heartbeat_interval, debug, plugins or alert_evaluation")
+	}
+	return nil
+}
+
+// configKey is the storage key of an agent's desired configuration
+func configKey(agentID string) string {
+	return "agent_config:" + agentID
+}
+
+// SetConfig validates and stores the desired configuration of an agent,
+// assigning it the next version. The configuration is persisted so it can be
+// pushed again when the agent reconnects.
+func (r *Registry) SetConfig(agentID string, cfg AgentConfig) (*AgentConfig, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := r.loadConfigLocked(agentID)
+	cfg.Version = 1
+	if previous != nil {
+		cfg.Version = previous.Version + 1
+	}
+	cfg.UpdatedAt = time.Now()
+
+	if r.store != nil {
+		data, err := json.Marshal(cfg)
+		if err != nil {
+			return nil, err
+		}
+		if err := r.store.Set(configKey(agentID), string(data)); err != nil {
+			return nil, fmt.Errorf("failed to persist config: %v", err)
+		}
+	}
+
+	r.configs[agentID] = &cfg
+	// Other servers drop their cached config along with the agent record
+	if _, ok := r.agents[agentID]; ok {
+		r.sharedChangedLocked(agentID)
+	}
+	r.logger.Infof("Stored config version %d for agent %s", cfg.Version, agentID)
+
+	return &cfg, nil
+}
+
+// Config returns the desired configuration of an agent, or nil if none is set
+func (r *Registry) Config(agentID string) *AgentConfig {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.loadConfigLocked(agentID)
+}
+
+// loadConfigLocked returns the cached config, falling back to storage; caller must hold r.mu
+func (r *Registry) loadConfigLocked(agentID string) *AgentConfig {
+	if cfg, ok := r.configs[agentID]; ok {
+		return cfg
+	}
+	if r.store == nil {
+		return nil
+	}
+
+	// Agents without a config are remembered too, since heartbeats look
+	// theirs up; other servers' changes drop the cached entry
+	value, err := r.store.Get(configKey(agentID))
+	if errors.Is(err, storage.ErrNotFound) {
+		r.configs[agentID] = nil
+		return nil
+	}
+	if err != nil {
+		return nil
+	}
+	data, ok := value.(string)
+	if !ok {
+		return nil
+	}
+
+	var cfg AgentConfig
+	if err := json.Unmarshal([]byte(data), &cfg); err != nil {
+		r.logger.Errorf("Invalid stored config for agent %s: %v", agentID, err)
+		return nil
+	}
+	r.configs[agentID] = &cfg
+	return &cfg
+}
+
+// AckConfig records the configuration version an agent reports as applied
+func (r *Registry) AckConfig(agentID string, version int64, errMsg string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := r.configStatus[agentID]
+	status.Error = errMsg
+	status.AckedAt = time.Now()
+	if errMsg == "" {
+		status.AppliedVersion = version
+		r.logger.Infof("Agent %s applied config version %d", agentID, version)
+	} else {
+		r.logger.Errorf("Agent %s rejected config version %d: %s", agentID, version, errMsg)
+	}
+	r.configStatus[agentID] = status
+}
+
+// ConfigStatus returns the last configuration acknowledgement from an agent
+func (r *Registry) ConfigStatus(agentID string) ConfigStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.configStatus[agentID]
+}
@@ -0,0 +1,84 @@
+// Package core provides the control channel state agents report in
+// heartbeats and the announcement they send on each connection.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// ControlChannel is an agent's report of its WebSocket control channel
+type ControlChannel struct {
+	// State is connecting until the channel first opens, then connected or
+	// disconnected
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+
+	// Reconnects counts the times the agent reestablished the channel, and
+	// LastError is why it last broke or failed to open
+	Reconnects int    `json:"reconnects"`
+	LastError  string `json:"last_error,omitempty"`
+}
+
+// validate checks a reported control channel state
+func (c *ControlChannel) validate() error {
+	switch c.State {
+	case "connecting", "connected", "disconnected":
+	default:
+		return fmt.Errorf("state: unknown state %q", c.State)
+	}
+	if c.Reconnects < 0 {
+		return fmt.Errorf("reconnects: must not be negative")
+	}
+	if err := validateString(c.LastError, maxFieldLength); err != nil {
+		return fmt.Errorf("last_error: %v", err)
+	}
+	return nil
+}
+
+// updateControlLocked records the control channel state from a heartbeat,
+// reporting whether it changed; caller must hold r.mu
+func (r *Registry) updateControlLocked(agent *AgentInfo, control *ControlChannel) bool {
+	if control == nil {
+		return false
+	}
+	if err := control.validate(); err != nil {
+		r.logger.Debugf("Ignoring control channel state of %s: %v", agent.ID, err)
+		return false
+	}
+
+	changed := agent.Control == nil || agent.Control.State != control.State || agent.Control.Reconnects != control.Reconnects
+	reported := *control
+	agent.Control = &reported
+	return changed
+}
+
+// Announce records the capabilities an agent announces when it opens its
+// control channel, e.g. to a server that took it over from another. It
+// reports false for agents the registry doesn't know, which must register.
+func (r *Registry) Announce(id string, capabilities []string, version string) (bool, error) {
+	if err := validateCapabilities(capabilities); err != nil {
+		return true, err
+	}
+	if err := validateString(version, maxFieldLength); err != nil {
+		return true, fmt.Errorf("agent_version: %v", err)
+	}
+
+	r.refreshAgent(id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent, ok := r.agents[id]
+	if !ok {
+		return false, nil
+	}
+	agent.Capabilities = normalizeCapabilities(capabilities)
+	if version != "" {
+		agent.AgentVersion = version
+	}
+	r.sharedChangedLocked(id)
+	return true, nil
+}
@@ -0,0 +1,50 @@
+// Package core provides plugin metrics reported in agent heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+// CustomMetricsAlertData returns plugin metrics as alert rule input,
+// matchable with conditions on event and nested custom.<plugin>.<metric> fields
+func CustomMetricsAlertData(custom map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"event":  "custom_metrics",
+		"custom": custom,
+	}
+}
+
+// OnCustomMetrics registers a callback invoked for each heartbeat that
+// carries plugin metrics
+func (r *Registry) OnCustomMetrics(handler func(agentID string, custom map[string]interface{})) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.customHandlers = append(r.customHandlers, handler)
+}
+
+// updateCustomMetricsLocked stores the plugin metrics of a heartbeat and
+// returns them, or nil if there are none or they exceed the inventory
+// bounds; caller must hold r.mu
+func (r *Registry) updateCustomMetricsLocked(agent *AgentInfo, custom map[string]interface{}) map[string]interface{} {
+	if len(custom) == 0 {
+		return nil
+	}
+	if err := validateInventory("custom", custom, 1); err != nil {
+		r.logger.Errorf("Agent %s sent invalid custom metrics: %v", agent.ID, err)
+		return nil
+	}
+
+	agent.CustomMetrics = custom
+	return custom
+}
+
+// notifyCustomMetrics invokes the custom metrics handlers; must be called without r.mu held
+func (r *Registry) notifyCustomMetrics(agentID string, custom map[string]interface{}) {
+	r.mu.RLock()
+	handlers := r.customHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, custom)
+	}
+}
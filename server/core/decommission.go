@@ -0,0 +1,194 @@
+// Package core provides the registry side of retiring agents for good.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nerve/server/pkg/sharedstate"
+)
+
+// StatusDecommissioning marks an agent being retired; it stays listed until
+// its in-flight tasks report back
+const StatusDecommissioning = "decommissioning"
+
+// retiredTokenKeyPrefix prefixes the storage keys of retired agent tokens
+const retiredTokenKeyPrefix = "retired_token:"
+
+// ErrAgentTokenMismatch is returned when registering an agent bound to
+// another token
+var ErrAgentTokenMismatch = errors.New("agent is registered with another token")
+
+// RegisterWithToken registers an agent and binds it to the token it
+// registered with. An agent already bound to another token is not taken
+// over unless replaces reports the token may replace the bound one, e.g.
+// because the bound token was revoked; ErrAgentTokenMismatch is returned
+// and nothing changes. replaces is called with the registry locked.
+func (r *Registry) RegisterWithToken(agent *AgentInfo, token string, replaces func(bound string) bool) (string, error) {
+	return r.register(agent, token, replaces)
+}
+
+// BindToken records the token an agent registered with, so decommissioning
+// the agent can retire it
+func (r *Registry) BindToken(agentID, token string) {
+	if token == "" {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.agentTokens[agentID] = token
+}
+
+// ClaimToken binds a registered agent not bound to a token, e.g. since a
+// restart forgot the bindings, to token, and returns the token the agent is
+// bound to
+func (r *Registry) ClaimToken(agentID, token string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.agents[agentID]; ok && token != "" && r.agentTokens[agentID] == "" {
+		r.agentTokens[agentID] = token
+	}
+	return r.agentTokens[agentID]
+}
+
+// AgentToken returns the token an agent registered with, or "" if unknown
+func (r *Registry) AgentToken(agentID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.agentTokens[agentID]
+}
+
+// AgentsWithToken returns the registered agents that registered with a token
+func (r *Registry) AgentsWithToken(token string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ids []string
+	for id, bound := range r.agentTokens {
+		if _, ok := r.agents[id]; ok && bound == token {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// TokenRetired reports whether a token belonged to a decommissioned agent.
+// Agents can't register or send heartbeats with it again.
+func (r *Registry) TokenRetired(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.retiredTokens[hashToken(token)]
+}
+
+// loadRetiredTokens restores the tokens retired before a restart
+func (r *Registry) loadRetiredTokens() {
+	if r.store == nil {
+		return
+	}
+
+	for key := range r.store.List() {
+		if strings.HasPrefix(key, retiredTokenKeyPrefix) {
+			r.retiredTokens[strings.TrimPrefix(key, retiredTokenKeyPrefix)] = true
+		}
+	}
+}
+
+// Decommission starts retiring an agent: its token is retired, persisted
+// so it stays rejected across restarts, and the agent is marked
+// decommissioning. The token is returned so the caller can revoke it too.
+func (r *Registry) Decommission(agentID string) (string, error) {
+	r.mu.Lock()
+
+	agent, ok := r.agents[agentID]
+	if !ok {
+		r.mu.Unlock()
+		return "", fmt.Errorf("agent %s not found", agentID)
+	}
+	agent.Status = StatusDecommissioning
+	r.sharedChangedLocked(agentID)
+
+	token := r.agentTokens[agentID]
+	if token != "" {
+		hash := hashToken(token)
+		r.retiredTokens[hash] = true
+		if r.store != nil {
+			if err := r.store.Set(retiredTokenKeyPrefix+hash, time.Now().Format(time.RFC3339)); err != nil {
+				r.logger.Errorf("Failed to persist retired token of agent %s: %v", agentID, err)
+			}
+		}
+		if r.bus != nil {
+			r.bus.Publish(sharedstate.KindRetiredToken, hash)
+		}
+	}
+	handlers := r.decommissionHandlers
+	r.mu.Unlock()
+
+	for _, handler := range handlers {
+		handler(agentID)
+	}
+	return token, nil
+}
+
+// OnDecommission registers a callback invoked when an agent starts being
+// decommissioned
+func (r *Registry) OnDecommission(handler func(agentID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.decommissionHandlers = append(r.decommissionHandlers, handler)
+}
+
+// CancelAgentTasks cancels an agent's tasks that haven't been dispatched or
+// are waiting to be retried, and returns how many were cancelled
+func (s *Scheduler) CancelAgentTasks(agentID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cancelled := 0
+	for _, task := range s.tasks {
+		if task.AgentID == agentID && (task.Status == "pending" || task.Status == "retrying") {
+			task.Status = "cancelled"
+			task.UpdatedAt = now
+			s.saveTaskLocked(task)
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// RunningTasks counts an agent's dispatched tasks still awaiting a result
+func (s *Scheduler) RunningTasks(agentID string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	running := 0
+	for _, task := range s.tasks {
+		if task.AgentID == agentID && task.Status == "running" {
+			running++
+		}
+	}
+	return running
+}
+
+// hashToken identifies a token without keeping it
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,177 @@
+// Package core provides the filesystem usage and disk IO agents report in
+// heartbeat metrics, with alerting per mount and per disk.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+// maxFilesystems and maxDisks cap the mounts and disks kept from a heartbeat
+const (
+	maxFilesystems = 64
+	maxDisks       = 64
+)
+
+// FilesystemUsage is the usage of a mounted filesystem, as reported in
+// heartbeat metrics. Inode figures are absent for filesystems without a
+// fixed inode count; HoursUntilFull is set while used space grows.
+type FilesystemUsage struct {
+	Device            string   `json:"device"`
+	FSType            string   `json:"fs_type"`
+	SizeBytes         float64  `json:"size_bytes"`
+	UsedBytes         float64  `json:"used_bytes"`
+	AvailableBytes    float64  `json:"available_bytes"`
+	UsedPercent       float64  `json:"used_percent"`
+	InodesTotal       float64  `json:"inodes_total,omitempty"`
+	InodesUsed        float64  `json:"inodes_used,omitempty"`
+	InodesUsedPercent *float64 `json:"inodes_used_percent,omitempty"`
+	FillRate          float64  `json:"fill_rate_bytes_per_sec"`
+	HoursUntilFull    *float64 `json:"hours_until_full,omitempty"`
+}
+
+// DiskIO is the IO of a disk over the agent's last heartbeat interval, as
+// reported in heartbeat metrics
+type DiskIO struct {
+	ReadsPerSec      float64 `json:"reads_per_sec"`
+	WritesPerSec     float64 `json:"writes_per_sec"`
+	ReadBytesPerSec  float64 `json:"read_bytes_per_sec"`
+	WriteBytesPerSec float64 `json:"write_bytes_per_sec"`
+	UtilPercent      float64 `json:"util_percent"`
+	AwaitMs          float64 `json:"await_ms"`
+}
+
+// parseFilesystems reads the per-mount usage of heartbeat metrics, skipping
+// malformed entries
+func parseFilesystems(value interface{}) map[string]FilesystemUsage {
+	mounts, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	filesystems := make(map[string]FilesystemUsage)
+	for point, item := range mounts {
+		fields, ok := item.(map[string]interface{})
+		if !ok || point == "" || validateString(point, maxFieldLength) != nil || len(filesystems) == maxFilesystems {
+			continue
+		}
+		fs := FilesystemUsage{}
+		fs.Device, _ = fields["device"].(string)
+		fs.FSType, _ = fields["fs_type"].(string)
+		if validateString(fs.Device, maxFieldLength) != nil || validateString(fs.FSType, maxFieldLength) != nil {
+			continue
+		}
+		fs.SizeBytes, _ = fields["size_bytes"].(float64)
+		fs.UsedBytes, _ = fields["used_bytes"].(float64)
+		fs.AvailableBytes, _ = fields["available_bytes"].(float64)
+		fs.UsedPercent, _ = fields["used_percent"].(float64)
+		fs.InodesTotal, _ = fields["inodes_total"].(float64)
+		fs.InodesUsed, _ = fields["inodes_used"].(float64)
+		if v, ok := fields["inodes_used_percent"].(float64); ok {
+			fs.InodesUsedPercent = &v
+		}
+		fs.FillRate, _ = fields["fill_rate_bytes_per_sec"].(float64)
+		if v, ok := fields["hours_until_full"].(float64); ok {
+			fs.HoursUntilFull = &v
+		}
+		filesystems[point] = fs
+	}
+	if len(filesystems) == 0 {
+		return nil
+	}
+	return filesystems
+}
+
+// parseDiskIO reads the per-disk IO of heartbeat metrics, skipping
+// malformed entries
+func parseDiskIO(value interface{}) map[string]DiskIO {
+	devices, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	disks := make(map[string]DiskIO)
+	for name, item := range devices {
+		fields, ok := item.(map[string]interface{})
+		if !ok || name == "" || validateString(name, maxFieldLength) != nil || len(disks) == maxDisks {
+			continue
+		}
+		io := DiskIO{}
+		io.ReadsPerSec, _ = fields["reads_per_sec"].(float64)
+		io.WritesPerSec, _ = fields["writes_per_sec"].(float64)
+		io.ReadBytesPerSec, _ = fields["read_bytes_per_sec"].(float64)
+		io.WriteBytesPerSec, _ = fields["write_bytes_per_sec"].(float64)
+		io.UtilPercent, _ = fields["util_percent"].(float64)
+		io.AwaitMs, _ = fields["await_ms"].(float64)
+		disks[name] = io
+	}
+	if len(disks) == 0 {
+		return nil
+	}
+	return disks
+}
+
+// OnStorageMetrics registers a callback invoked for each heartbeat whose
+// metrics carry filesystem usage or disk IO
+func (r *Registry) OnStorageMetrics(handler func(agentID string, usage *AgentUsage)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.storageHandlers = append(r.storageHandlers, handler)
+}
+
+// notifyStorageMetrics invokes the storage metrics handlers; must be called without r.mu held
+func (r *Registry) notifyStorageMetrics(agentID string, usage *AgentUsage) {
+	r.mu.RLock()
+	handlers := r.storageHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, usage)
+	}
+}
+
+// StorageAlertData returns an agent's filesystem usage and disk IO as alert
+// rule input, matchable with conditions on event and nested fields such as
+// filesystems./data.used_percent, filesystems./.inodes_used_percent or
+// disk_io.sda.util_percent. Mount points containing dots can't be
+// addressed this way.
+func StorageAlertData(usage *AgentUsage) map[string]interface{} {
+	filesystems := make(map[string]interface{}, len(usage.Filesystems))
+	for point, fs := range usage.Filesystems {
+		fields := map[string]interface{}{
+			"device":                  fs.Device,
+			"fs_type":                 fs.FSType,
+			"size_bytes":              fs.SizeBytes,
+			"used_bytes":              fs.UsedBytes,
+			"available_bytes":         fs.AvailableBytes,
+			"used_percent":            fs.UsedPercent,
+			"fill_rate_bytes_per_sec": fs.FillRate,
+		}
+		if fs.InodesUsedPercent != nil {
+			fields["inodes_total"] = fs.InodesTotal
+			fields["inodes_used"] = fs.InodesUsed
+			fields["inodes_used_percent"] = *fs.InodesUsedPercent
+		}
+		if fs.HoursUntilFull != nil {
+			fields["hours_until_full"] = *fs.HoursUntilFull
+		}
+		filesystems[point] = fields
+	}
+
+	disks := make(map[string]interface{}, len(usage.DiskIO))
+	for name, io := range usage.DiskIO {
+		disks[name] = map[string]interface{}{
+			"reads_per_sec":       io.ReadsPerSec,
+			"writes_per_sec":      io.WritesPerSec,
+			"read_bytes_per_sec":  io.ReadBytesPerSec,
+			"write_bytes_per_sec": io.WriteBytesPerSec,
+			"util_percent":        io.UtilPercent,
+			"await_ms":            io.AwaitMs,
+		}
+	}
+
+	return map[string]interface{}{
+		"event":       "storage_metrics",
+		"filesystems": filesystems,
+		"disk_io":     disks,
+	}
+}
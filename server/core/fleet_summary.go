@@ -0,0 +1,97 @@
+// Package core provides fleet-wide rollups of agent hardware for capacity
+// planning.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import "time"
+
+// UnknownInventoryValue groups agents that haven't reported a value, e.g.
+// their OS or GPU type
+const UnknownInventoryValue = "unknown"
+
+// FleetSummary totals the hardware of all registered agents
+type FleetSummary struct {
+	TotalAgents  int `json:"total_agents"`
+	OnlineAgents int `json:"online_agents"`
+
+	// CPUCores is the total logical core count
+	CPUCores int `json:"cpu_cores"`
+
+	// MemoryGB is the total memory; UsedMemoryGB is the memory in use on
+	// the agents that reported utilization, counted by MemoryReporting
+	MemoryGB        float64 `json:"memory_gb"`
+	UsedMemoryGB    float64 `json:"used_memory_gb"`
+	MemoryReporting int     `json:"memory_reporting_agents"`
+
+	// GPUs is the total GPU count; GPUsByType splits it by GPU type
+	GPUs       int            `json:"gpus"`
+	GPUsByType map[string]int `json:"gpus_by_type"`
+
+	AgentsByOS map[string]int `json:"agents_by_os"`
+
+	// AgentsByCluster counts the agents of each cluster; an agent in
+	// several clusters counts in each. Unclustered counts agents in none.
+	AgentsByCluster map[string]int `json:"agents_by_cluster"`
+	Unclustered     int            `json:"unclustered_agents"`
+
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// FleetSummary totals the hardware of all agents in a single pass over the
+// registry. clusters maps agent IDs to the clusters they belong to.
+func (r *Registry) FleetSummary(clusters map[string][]string) *FleetSummary {
+	summary := &FleetSummary{
+		GPUsByType:      make(map[string]int),
+		AgentsByOS:      make(map[string]int),
+		AgentsByCluster: make(map[string]int),
+		GeneratedAt:     time.Now(),
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for id, agent := range r.agents {
+		summary.TotalAgents++
+		if agent.Status == "online" {
+			summary.OnlineAgents++
+		}
+
+		summary.CPUCores += agent.CPULogic
+
+		// Memsum is reported in KiB
+		memoryGB := float64(agent.Memsum) / (1024 * 1024)
+		summary.MemoryGB += memoryGB
+		if agent.Usage != nil {
+			summary.UsedMemoryGB += memoryGB * (1 - freeShare(agent.Usage.MemoryUsage))
+			summary.MemoryReporting++
+		}
+
+		if agent.GPUNum > 0 {
+			summary.GPUs += agent.GPUNum
+			summary.GPUsByType[inventoryKey(agent.GPUType)] += agent.GPUNum
+		}
+
+		summary.AgentsByOS[inventoryKey(agent.OS)]++
+
+		if len(clusters[id]) == 0 {
+			summary.Unclustered++
+		}
+		for _, cluster := range clusters[id] {
+			summary.AgentsByCluster[cluster]++
+		}
+	}
+
+	summary.MemoryGB = round2(summary.MemoryGB)
+	summary.UsedMemoryGB = round2(summary.UsedMemoryGB)
+	return summary
+}
+
+// inventoryKey groups an empty inventory value under UnknownInventoryValue
+func inventoryKey(value string) string {
+	if value == "" {
+		return UnknownInventoryValue
+	}
+	return value
+}
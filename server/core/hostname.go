@@ -0,0 +1,150 @@
+// Package core provides detection of distinct machines registering with the
+// same hostname, which would otherwise overwrite each other's record.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HostnameCollision describes a machine that registered with the hostname of
+// another one and was registered under an ID of its own
+type HostnameCollision struct {
+	Hostname     string `json:"hostname"`
+	AgentID      string `json:"agent_id"`
+	CollidesWith string `json:"collides_with"`
+	SN           string `json:"sn,omitempty"`
+	ExistingSN   string `json:"existing_sn,omitempty"`
+}
+
+// HostnameCollisionAlertData returns a collision as alert rule input,
+// matchable with conditions on event, hostname and collides_with
+func HostnameCollisionAlertData(collision HostnameCollision) map[string]interface{} {
+	return map[string]interface{}{
+		"event":         "hostname_collision",
+		"hostname":      collision.Hostname,
+		"collides_with": collision.CollidesWith,
+		"sn":            collision.SN,
+		"existing_sn":   collision.ExistingSN,
+	}
+}
+
+// OnHostnameCollision registers a callback invoked when a machine registers
+// with the hostname of a different, already registered machine. It fires
+// once, when the second machine is first registered under its own ID.
+func (r *Registry) OnHostnameCollision(handler func(collision HostnameCollision)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collisionHandlers = append(r.collisionHandlers, handler)
+}
+
+// notifyHostnameCollision invokes the collision handlers; must be called
+// without r.mu held
+func (r *Registry) notifyHostnameCollision(collision HostnameCollision) {
+	r.mu.RLock()
+	handlers := r.collisionHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(collision)
+	}
+}
+
+// registrationKey returns the ID an agent asks to be registered under: the
+// identity it sent, e.g. its machine-id, or else its hostname
+func registrationKey(agent *AgentInfo) string {
+	if agent.ID != "" {
+		return agent.ID
+	}
+	return agent.Hostname
+}
+
+// registrationIDLocked returns the ID an agent registers under: its
+// registration key, or the key with a suffix identifying the machine if a
+// different machine is registered under the key already, e.g. a VM cloned
+// with its machine-id. The suffix is derived from the machine's SN or MACs,
+// so the machine keeps its ID when it registers again. It also returns the
+// collision if the agent is the first registration under such an ID; caller
+// must hold r.mu.
+func (r *Registry) registrationIDLocked(agent *AgentInfo) (string, *HostnameCollision) {
+	key := registrationKey(agent)
+	existing, ok := r.agents[key]
+	if !ok || !differentMachines(existing, agent) {
+		return key, nil
+	}
+
+	base := key + "-" + machineFingerprint(agent)
+	id := base
+	for n := 2; ; n++ {
+		registered, ok := r.agents[id]
+		if !ok {
+			return id, &HostnameCollision{
+				Hostname:     agent.Hostname,
+				AgentID:      id,
+				CollidesWith: existing.ID,
+				SN:           agent.SN,
+				ExistingSN:   existing.SN,
+			}
+		}
+		if !differentMachines(registered, agent) {
+			return id, nil
+		}
+		id = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// differentMachines reports whether two registrations are known to come
+// from different machines: their SNs differ, or without SNs to compare,
+// they share no MAC. Registrations that can't be told apart are taken to be
+// the same machine.
+func differentMachines(a, b *AgentInfo) bool {
+	if a.SN != "" && b.SN != "" {
+		return a.SN != b.SN
+	}
+
+	macsA, macsB := machineMACs(a), machineMACs(b)
+	if len(macsA) == 0 || len(macsB) == 0 {
+		return false
+	}
+	for _, mac := range macsB {
+		for _, other := range macsA {
+			if mac == other {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// machineMACs returns the sorted MAC addresses of an agent's interfaces
+func machineMACs(agent *AgentInfo) []string {
+	var macs []string
+	for _, iface := range agent.NetworkInfo {
+		mac, _ := iface["mac"].(string)
+		mac = strings.ToLower(strings.TrimSpace(mac))
+		if mac == "" || mac == "00:00:00:00:00:00" {
+			continue
+		}
+		macs = append(macs, mac)
+	}
+	sort.Strings(macs)
+	return macs
+}
+
+// machineFingerprint returns a short, stable identifier of a machine from
+// its SN, or its MACs if it reports no SN
+func machineFingerprint(agent *AgentInfo) string {
+	identity := agent.SN
+	if identity == "" {
+		identity = strings.Join(machineMACs(agent), ",")
+	}
+	sum := sha256.Sum256([]byte(identity))
+	return hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"io"
+	"testing"
+
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/storage"
+)
+
+func newTestRegistry() *Registry {
+	return NewRegistry(storage.NewInMemory(), log.NewWithWriter(false, io.Discard))
+}
+
+func machine(hostname, sn string, macs ...string) *AgentInfo {
+	agent := &AgentInfo{Hostname: hostname, SN: sn}
+	for _, mac := range macs {
+		agent.NetworkInfo = append(agent.NetworkInfo, map[string]interface{}{"mac": mac})
+	}
+	return agent
+}
+
+func TestRegisterSameHostnameDifferentMachines(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second *AgentInfo
+	}{
+		{"different SNs", machine("node-1", "SN-A"), machine("node-1", "SN-B")},
+		{"different MACs", machine("node-1", "", "aa:aa:aa:aa:aa:01"), machine("node-1", "", "AA:AA:AA:AA:AA:02")},
+		{"SN wins over shared MAC", machine("node-1", "SN-A", "aa:aa:aa:aa:aa:01"), machine("node-1", "SN-B", "aa:aa:aa:aa:aa:01")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry()
+			var collisions []HostnameCollision
+			r.OnHostnameCollision(func(collision HostnameCollision) {
+				collisions = append(collisions, collision)
+			})
+
+			firstID := r.Register(tt.first)
+			secondID := r.Register(tt.second)
+			if firstID != "node-1" {
+				t.Errorf("first machine registered as %q, want node-1", firstID)
+			}
+			if secondID == firstID {
+				t.Fatalf("both machines registered as %q", firstID)
+			}
+			if r.Get(firstID) == nil || r.Get(secondID) == nil || len(r.List()) != 2 {
+				t.Errorf("want both machines registered, have %d agents", len(r.List()))
+			}
+			if len(collisions) != 1 || collisions[0].AgentID != secondID || collisions[0].CollidesWith != firstID {
+				t.Errorf("collisions = %+v, want one of %s with %s", collisions, secondID, firstID)
+			}
+
+			// Each machine keeps its own ID when it registers again
+			againFirst := *tt.first
+			againFirst.ID = ""
+			againSecond := *tt.second
+			againSecond.ID = ""
+			if id := r.Register(&againSecond); id != secondID {
+				t.Errorf("second machine re-registered as %q, want %q", id, secondID)
+			}
+			if id := r.Register(&againFirst); id != firstID {
+				t.Errorf("first machine re-registered as %q, want %q", id, firstID)
+			}
+			if len(collisions) != 1 {
+				t.Errorf("re-registering reported %d more collisions", len(collisions)-1)
+			}
+		})
+	}
+}
+
+func TestRegisterThirdMachineSameHostname(t *testing.T) {
+	r := newTestRegistry()
+	ids := make(map[string]bool)
+	for _, sn := range []string{"SN-A", "SN-B", "SN-C"} {
+		ids[r.Register(machine("node-1", sn))] = true
+	}
+	if len(ids) != 3 {
+		t.Errorf("three machines registered under %d IDs: %v", len(ids), ids)
+	}
+}
+
+// Registrations that can't be told apart are the same machine re-registering
+func TestRegisterSameHostnameIndistinguishable(t *testing.T) {
+	tests := []struct {
+		name          string
+		first, second *AgentInfo
+	}{
+		{"same SN", machine("node-1", "SN-A", "aa:aa:aa:aa:aa:01"), machine("node-1", "SN-A", "aa:aa:aa:aa:aa:02")},
+		{"shared MAC", machine("node-1", "", "aa:aa:aa:aa:aa:01", "aa:aa:aa:aa:aa:02"), machine("node-1", "", "aa:aa:aa:aa:aa:02")},
+		{"nothing to compare", machine("node-1", ""), machine("node-1", "SN-B")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry()
+			firstID := r.Register(tt.first)
+			if secondID := r.Register(tt.second); secondID != firstID {
+				t.Errorf("registered as %q and %q, want the same ID", firstID, secondID)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package core provides idempotency keys, which let clients retry task
+// creation without creating duplicate tasks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultIdempotencyTTL is how long an idempotency key is remembered
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	maxIdempotencyKeyLength = 255
+)
+
+// ErrIdempotencyKeyReused is returned when an idempotency key is sent again
+// with a different request
+var ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+
+// idempotencyRecord holds the tasks created for an idempotency key
+type idempotencyRecord struct {
+	// fingerprint identifies the request the key was first sent with
+	fingerprint string
+	tasks       []*Task
+	expiresAt   time.Time
+}
+
+// ValidateIdempotencyKey accepts short printable keys so they are safe to log
+func ValidateIdempotencyKey(key string) error {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return fmt.Errorf("idempotency key must be 1 to %d characters", maxIdempotencyKeyLength)
+	}
+	for _, ch := range key {
+		if ch < '!' || ch > '~' {
+			return fmt.Errorf("idempotency key must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
+
+// SetIdempotencyTTL sets how long idempotency keys are remembered
+func (s *Scheduler) SetIdempotencyTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("idempotency key TTL must be positive")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idempotencyTTL = ttl
+	return nil
+}
+
+// SubmitIdempotent submits tasks created for a request carrying an
+// idempotency key. If the key was already used within its TTL, nothing is
+// submitted and the tasks created the first time are returned with replayed
+// set. fingerprint identifies the request; reusing the key with a different
+// request returns ErrIdempotencyKeyReused.
+func (s *Scheduler) SubmitIdempotent(key, fingerprint string, tasks []*Task) (submitted []*Task, replayed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if record, ok := s.idempotency[key]; ok && now.Before(record.expiresAt) {
+		if record.fingerprint != fingerprint {
+			return nil, false, ErrIdempotencyKeyReused
+		}
+		return record.tasks, true, nil
+	}
+
+	for _, task := range tasks {
+		s.submitLocked(task)
+	}
+	s.idempotency[key] = &idempotencyRecord{
+		fingerprint: fingerprint,
+		tasks:       tasks,
+		expiresAt:   now.Add(s.idempotencyTTL),
+	}
+	return tasks, false, nil
+}
+
+// sweepIdempotencyKeysLocked forgets expired idempotency keys; caller must
+// hold s.mu
+func (s *Scheduler) sweepIdempotencyKeysLocked(now time.Time) {
+	for key, record := range s.idempotency {
+		if !now.Before(record.expiresAt) {
+			delete(s.idempotency, key)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package core provides hardware inventory change tracking for agents.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxInventoryChanges bounds the change history kept per agent
+const maxInventoryChanges = 100
+
+// Inventory fields tracked for changes. Volatile values such as utilization
+// are deliberately not tracked.
+const (
+	InventoryCPUModel    = "cpu_model"
+	InventoryCPULogic    = "cpu_logic"
+	InventoryMemoryTotal = "memory_total"
+	InventoryGPUNum      = "gpu_num"
+	InventoryGPUType     = "gpu_type"
+	InventoryDisks       = "disks"
+)
+
+// InventoryChange records one inventory field of an agent changing value
+type InventoryChange struct {
+	AgentID    string      `json:"agent_id"`
+	Field      string      `json:"field"`
+	Old        interface{} `json:"old"`
+	New        interface{} `json:"new"`
+	DetectedAt time.Time   `json:"detected_at"`
+}
+
+// AlertData returns the change as alert rule input, matchable with
+// conditions on event, field, old and new
+func (c InventoryChange) AlertData() map[string]interface{} {
+	return map[string]interface{}{
+		"event": "inventory_change",
+		"field": c.Field,
+		"old":   c.Old,
+		"new":   c.New,
+	}
+}
+
+// inventorySnapshot returns the tracked inventory fields an agent reports.
+// Fields the agent hasn't reported are left out so they don't read as changes.
+func inventorySnapshot(agent *AgentInfo) map[string]interface{} {
+	snapshot := map[string]interface{}{
+		InventoryGPUNum: agent.GPUNum,
+	}
+	if agent.CPUType != "" {
+		snapshot[InventoryCPUModel] = agent.CPUType
+	}
+	if agent.CPULogic > 0 {
+		snapshot[InventoryCPULogic] = agent.CPULogic
+	}
+	if agent.Memsum > 0 {
+		snapshot[InventoryMemoryTotal] = agent.Memsum
+	}
+	if agent.GPUType != "" {
+		snapshot[InventoryGPUType] = agent.GPUType
+	}
+	if disks := diskSet(agent.DiskInfo); len(disks) > 0 {
+		snapshot[InventoryDisks] = disks
+	}
+	return snapshot
+}
+
+// diskSet returns the sorted names of the disks in disk_info
+func diskSet(diskInfo []map[string]interface{}) []string {
+	var disks []string
+	for _, disk := range diskInfo {
+		for _, key := range []string{"name", "device", "serial"} {
+			if name, ok := disk[key].(string); ok && name != "" {
+				disks = append(disks, name)
+				break
+			}
+		}
+	}
+	sort.Strings(disks)
+	return disks
+}
+
+// diffInventory returns the fields reported in both snapshots whose values differ
+func diffInventory(agentID string, before, after map[string]interface{}) []InventoryChange {
+	now := time.Now()
+	var changes []InventoryChange
+	for _, field := range []string{InventoryCPUModel, InventoryCPULogic, InventoryMemoryTotal, InventoryGPUNum, InventoryGPUType, InventoryDisks} {
+		old, hadOld := before[field]
+		value, hasNew := after[field]
+		if !hadOld || !hasNew || inventoryValue(old) == inventoryValue(value) {
+			continue
+		}
+		changes = append(changes, InventoryChange{
+			AgentID:    agentID,
+			Field:      field,
+			Old:        old,
+			New:        value,
+			DetectedAt: now,
+		})
+	}
+	return changes
+}
+
+// inventoryValue returns a comparable form of an inventory value
+func inventoryValue(value interface{}) string {
+	if list, ok := value.([]string); ok {
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+// recordInventoryChangesLocked appends changes to the agent's bounded history; caller must hold r.mu
+func (r *Registry) recordInventoryChangesLocked(agentID string, changes []InventoryChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		r.logger.Infof("Agent %s inventory changed: %s %v -> %v", agentID, change.Field, change.Old, change.New)
+	}
+
+	history := append(r.inventoryChanges[agentID], changes...)
+	if len(history) > maxInventoryChanges {
+		history = history[len(history)-maxInventoryChanges:]
+	}
+	r.inventoryChanges[agentID] = history
+}
+
+// notifyInventoryChanges invokes the inventory change handlers; must be called without r.mu held
+func (r *Registry) notifyInventoryChanges(agentID string, changes []InventoryChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	r.mu.RLock()
+	handlers := r.inventoryHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, changes)
+	}
+}
+
+// OnInventoryChange registers a callback invoked when an agent's inventory changes
+func (r *Registry) OnInventoryChange(handler func(agentID string, changes []InventoryChange)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.inventoryHandlers = append(r.inventoryHandlers, handler)
+}
+
+// InventoryChanges returns the recorded inventory changes of an agent, oldest first
+func (r *Registry) InventoryChanges(agentID string) []InventoryChange {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := r.inventoryChanges[agentID]
+	changes := make([]InventoryChange, len(history))
+	copy(changes, history)
+	return changes
+}
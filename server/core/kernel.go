@@ -0,0 +1,129 @@
+// Package core provides the kernel and package inventory agents report at
+// registration, for vulnerability tracking.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// MaxPackages caps the packages an agent may report at registration
+	MaxPackages = 5000
+
+	// maxCmdlineLength caps the reported kernel command line
+	maxCmdlineLength = 4096
+)
+
+// KernelInfo describes an agent's running kernel and the kernels installed
+// next to it; RebootRequired is set when the newest installed kernel isn't
+// the running one
+type KernelInfo struct {
+	Release        string   `json:"release"`
+	Version        string   `json:"version,omitempty"`
+	Cmdline        string   `json:"cmdline,omitempty"`
+	Installed      []string `json:"installed,omitempty"`
+	Latest         string   `json:"latest,omitempty"`
+	RebootRequired bool     `json:"reboot_required"`
+}
+
+// Package is a package installed on an agent
+type Package struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Arch    string `json:"arch,omitempty"`
+}
+
+// validateKernelInventory checks the kernel and packages of a registration
+func validateKernelInventory(kernel *KernelInfo, manager string, packages []Package) error {
+	if kernel != nil {
+		strs := []struct{ field, value string }{
+			{"kernel.release", kernel.Release},
+			{"kernel.version", kernel.Version},
+			{"kernel.latest", kernel.Latest},
+		}
+		for _, str := range strs {
+			if err := validateString(str.value, maxFieldLength); err != nil {
+				return fmt.Errorf("%s: %v", str.field, err)
+			}
+		}
+		if err := validateString(kernel.Cmdline, maxCmdlineLength); err != nil {
+			return fmt.Errorf("kernel.cmdline: %v", err)
+		}
+		if err := validateInventory("kernel.installed", kernel.Installed, 1); err != nil {
+			return err
+		}
+	}
+
+	if err := validateString(manager, maxFieldLength); err != nil {
+		return fmt.Errorf("package_manager: %v", err)
+	}
+	if len(packages) > MaxPackages {
+		return fmt.Errorf("packages: more than %d items", MaxPackages)
+	}
+	for i, pkg := range packages {
+		if pkg.Name == "" {
+			return fmt.Errorf("packages[%d].name: is required", i)
+		}
+		for _, str := range []struct{ field, value string }{{"name", pkg.Name}, {"version", pkg.Version}, {"arch", pkg.Arch}} {
+			if err := validateString(str.value, maxFieldLength); err != nil {
+				return fmt.Errorf("packages[%d].%s: %v", i, str.field, err)
+			}
+		}
+	}
+	return nil
+}
+
+// KernelAlertData returns an agent's kernel and packages as alert rule
+// input, matchable with conditions on event, kernel_release, kernel_cmdline,
+// reboot_required and packages.<name> holding the installed version, e.g. a
+// regex on kernel_release for kernels affected by a CVE
+func KernelAlertData(agent *AgentInfo) map[string]interface{} {
+	data := map[string]interface{}{
+		"event": "kernel_inventory",
+	}
+	if agent.Kernel != nil {
+		data["kernel_release"] = agent.Kernel.Release
+		data["kernel_version"] = agent.Kernel.Version
+		data["kernel_cmdline"] = agent.Kernel.Cmdline
+		data["kernel_latest"] = agent.Kernel.Latest
+		data["reboot_required"] = agent.Kernel.RebootRequired
+	}
+	if len(agent.Packages) > 0 {
+		packages := make(map[string]interface{}, len(agent.Packages))
+		for _, pkg := range agent.Packages {
+			packages[pkg.Name] = pkg.Version
+		}
+		data["package_manager"] = agent.PackageManager
+		data["packages"] = packages
+	}
+	return data
+}
+
+// FilterPackages returns the packages whose names match the glob pattern,
+// sorted by name; an empty pattern matches all
+func FilterPackages(packages []Package, pattern string) ([]Package, error) {
+	if pattern != "" {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q: %v", pattern, err)
+		}
+	}
+
+	matched := []Package{}
+	for _, pkg := range packages {
+		if ok, _ := filepath.Match(pattern, pkg.Name); pattern == "" || ok {
+			matched = append(matched, pkg)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Name != matched[j].Name {
+			return matched[i].Name < matched[j].Name
+		}
+		return matched[i].Arch < matched[j].Arch
+	})
+	return matched, nil
+}
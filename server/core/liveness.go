@@ -0,0 +1,173 @@
+// Package core provides the debounced online/offline state machine: how many
+// heartbeats an agent may miss before it is marked offline, how many it must
+// send in a row to come back online, and flap detection for agents that keep
+// going back and forth.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultHeartbeatInterval is the heartbeat interval agents are assumed
+	// to use when counting missed and consecutive heartbeats
+	DefaultHeartbeatInterval = 30 * time.Second
+
+	// DefaultFlapWindow and DefaultFlapThreshold mark an agent flapping once
+	// it went offline or online 4 times within an hour
+	DefaultFlapWindow    = time.Hour
+	DefaultFlapThreshold = 4
+
+	// maxStaleCheckInterval is how often silent agents are looked for at most
+	maxStaleCheckInterval = time.Minute
+)
+
+// LivenessPolicy debounces agents going offline and coming back online
+type LivenessPolicy struct {
+	// HeartbeatInterval is the interval agents heartbeat at
+	HeartbeatInterval time.Duration
+
+	// MissedHeartbeats is how many heartbeat intervals an agent may go
+	// without contact before it is marked offline; 0 uses the offline
+	// threshold of SetStalePolicy instead
+	MissedHeartbeats int
+
+	// RecoveryHeartbeats is how many heartbeats or control channel pings in
+	// a row, no more than two intervals apart, an offline agent must send to
+	// be marked online again
+	RecoveryHeartbeats int
+
+	// An agent is flapping while it went offline or online at least
+	// FlapThreshold times within FlapWindow
+	FlapWindow    time.Duration
+	FlapThreshold int
+}
+
+// DefaultLivenessPolicy marks agents offline after the stale policy's offline
+// threshold and back online on their first heartbeat
+func DefaultLivenessPolicy() LivenessPolicy {
+	return LivenessPolicy{
+		HeartbeatInterval:  DefaultHeartbeatInterval,
+		RecoveryHeartbeats: 1,
+		FlapWindow:         DefaultFlapWindow,
+		FlapThreshold:      DefaultFlapThreshold,
+	}
+}
+
+// SetLivenessPolicy sets how agents go offline and come back online, and
+// when they count as flapping
+func (r *Registry) SetLivenessPolicy(policy LivenessPolicy) error {
+	switch {
+	case policy.HeartbeatInterval <= 0:
+		return fmt.Errorf("heartbeat interval must be positive")
+	case policy.MissedHeartbeats < 0:
+		return fmt.Errorf("missed heartbeats must not be negative")
+	case policy.RecoveryHeartbeats < 1:
+		return fmt.Errorf("recovery heartbeats must be at least 1")
+	case policy.FlapWindow <= 0:
+		return fmt.Errorf("flap window must be positive")
+	case policy.FlapThreshold < 2:
+		return fmt.Errorf("flap threshold must be at least 2")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	offlineAfter := r.offlineAfter
+	if policy.MissedHeartbeats > 0 {
+		offlineAfter = time.Duration(policy.MissedHeartbeats) * policy.HeartbeatInterval
+	}
+	if r.removeAfter > 0 && r.removeAfter <= offlineAfter {
+		return fmt.Errorf("removal threshold must be longer than %d missed heartbeats (%v)", policy.MissedHeartbeats, offlineAfter)
+	}
+
+	r.liveness = policy
+	return nil
+}
+
+// offlineThresholdLocked returns how long an agent may go without contact
+// before it is marked offline; caller must hold r.mu
+func (r *Registry) offlineThresholdLocked() time.Duration {
+	if r.liveness.MissedHeartbeats > 0 {
+		return time.Duration(r.liveness.MissedHeartbeats) * r.liveness.HeartbeatInterval
+	}
+	return r.offlineAfter
+}
+
+// staleCheckInterval returns how often to look for silent agents, often
+// enough that agents go offline close to the offline threshold
+func (r *Registry) staleCheckInterval() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	interval := r.offlineThresholdLocked() / 4
+	if interval > maxStaleCheckInterval {
+		interval = maxStaleCheckInterval
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
+}
+
+// recoverLocked counts a heartbeat or ping of an offline agent toward the
+// recovery streak, reporting whether the agent may be marked online again.
+// Contact more than two heartbeat intervals after the previous one starts a
+// new streak, and contact within half an interval of it, such as a ping
+// right after a heartbeat, doesn't count again. Caller must hold r.mu.
+func (r *Registry) recoverLocked(agent *AgentInfo, previousContact, now time.Time) bool {
+	gap := now.Sub(previousContact)
+	switch {
+	case gap > 2*r.liveness.HeartbeatInterval:
+		agent.RecoveryStreak = 0
+	case gap < r.liveness.HeartbeatInterval/2 && agent.RecoveryStreak > 0:
+		return false
+	}
+	agent.RecoveryStreak++
+	if agent.RecoveryStreak < r.liveness.RecoveryHeartbeats {
+		return false
+	}
+	agent.RecoveryStreak = 0
+	return true
+}
+
+// recordStatusChangeLocked records an agent going offline or coming back
+// online for flap detection; caller must hold r.mu
+func (r *Registry) recordStatusChangeLocked(agent *AgentInfo, now time.Time) {
+	agent.StatusChanges = append(agent.StatusChanges, now)
+	wasFlapping := agent.Flapping
+	r.pruneStatusChangesLocked(agent, now)
+	if agent.Flapping && !wasFlapping {
+		r.logger.Infof("Agent %s is flapping: %d status changes within %v", agent.ID, agent.FlapCount, r.liveness.FlapWindow)
+	}
+}
+
+// pruneStatusChangesLocked drops status changes older than the flap window
+// and updates the flap count; caller must hold r.mu
+func (r *Registry) pruneStatusChangesLocked(agent *AgentInfo, now time.Time) {
+	kept := agent.StatusChanges[:0]
+	for _, changed := range agent.StatusChanges {
+		if now.Sub(changed) <= r.liveness.FlapWindow {
+			kept = append(kept, changed)
+		}
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	agent.StatusChanges = kept
+	agent.FlapCount = len(kept)
+	agent.Flapping = agent.FlapCount >= r.liveness.FlapThreshold
+}
+
+// keepLiveness carries the recovery streak and flap history of an agent's
+// record over to its replacement
+func keepLiveness(from, to *AgentInfo) {
+	to.RecoveryStreak = from.RecoveryStreak
+	to.StatusChanges = from.StatusChanges
+	to.FlapCount = from.FlapCount
+	to.Flapping = from.Flapping
+}
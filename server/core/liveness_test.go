@@ -0,0 +1,210 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+// livenessStep is a point of a simulated heartbeat timeline: the agent
+// heartbeats at the offset if heartbeat is set, the stale agent sweep runs,
+// and the agent's status and flapping are checked
+type livenessStep struct {
+	at        time.Duration
+	heartbeat bool
+	status    string
+	flapping  bool
+}
+
+func heartbeatAt(at time.Duration, status string) livenessStep {
+	return livenessStep{at: at, heartbeat: true, status: status}
+}
+
+func sweepAt(at time.Duration, status string) livenessStep {
+	return livenessStep{at: at, status: status}
+}
+
+// steady heartbeats every 10s from from to to, expecting the agent online
+func steady(from, to time.Duration, flapping bool) []livenessStep {
+	var steps []livenessStep
+	for at := from; at <= to; at += 10 * time.Second {
+		steps = append(steps, livenessStep{at: at, heartbeat: true, status: "online", flapping: flapping})
+	}
+	return steps
+}
+
+func flapping(steps ...livenessStep) []livenessStep {
+	for i := range steps {
+		steps[i].flapping = true
+	}
+	return steps
+}
+
+func timeline(parts ...[]livenessStep) []livenessStep {
+	var steps []livenessStep
+	for _, part := range parts {
+		steps = append(steps, part...)
+	}
+	return steps
+}
+
+func TestLivenessTimeline(t *testing.T) {
+	s := time.Second
+	// Heartbeats every 10s, offline after 3 missed, back online after 3 in
+	// a row, flapping after 4 changes within 10 minutes
+	policy := LivenessPolicy{
+		HeartbeatInterval:  10 * s,
+		MissedHeartbeats:   3,
+		RecoveryHeartbeats: 3,
+		FlapWindow:         10 * time.Minute,
+		FlapThreshold:      4,
+	}
+	quickRecovery := policy
+	quickRecovery.RecoveryHeartbeats = 1
+
+	tests := []struct {
+		name            string
+		policy          LivenessPolicy
+		steps           []livenessStep
+		offline, online int
+	}{
+		{
+			name:   "online to offline",
+			policy: policy,
+			steps: []livenessStep{
+				heartbeatAt(10*s, "online"),
+				heartbeatAt(20*s, "online"),
+				sweepAt(40*s, "online"),
+				sweepAt(50*s, "online"),
+				sweepAt(51*s, "offline"),
+				sweepAt(90*s, "offline"),
+			},
+			offline: 1,
+		},
+		{
+			name:   "late heartbeats within the threshold stay online",
+			policy: policy,
+			steps: []livenessStep{
+				sweepAt(29*s, "online"),
+				heartbeatAt(30*s, "online"),
+				sweepAt(59*s, "online"),
+				heartbeatAt(60*s, "online"),
+			},
+		},
+		{
+			name:   "recovery takes heartbeats in a row",
+			policy: policy,
+			steps: []livenessStep{
+				sweepAt(31*s, "offline"),
+				heartbeatAt(40*s, "offline"),
+				heartbeatAt(50*s, "offline"),
+				heartbeatAt(60*s, "online"),
+			},
+			offline: 1,
+			online:  1,
+		},
+		{
+			name:   "a gap restarts the recovery streak",
+			policy: policy,
+			steps: []livenessStep{
+				sweepAt(31*s, "offline"),
+				heartbeatAt(40*s, "offline"),
+				heartbeatAt(50*s, "offline"),
+				heartbeatAt(75*s, "offline"),
+				heartbeatAt(85*s, "offline"),
+				heartbeatAt(95*s, "online"),
+			},
+			offline: 1,
+			online:  1,
+		},
+		{
+			name:   "bursts of heartbeats count once",
+			policy: policy,
+			steps: []livenessStep{
+				sweepAt(31*s, "offline"),
+				heartbeatAt(40*s, "offline"),
+				heartbeatAt(41*s, "offline"),
+				heartbeatAt(42*s, "offline"),
+				heartbeatAt(50*s, "offline"),
+				heartbeatAt(60*s, "online"),
+			},
+			offline: 1,
+			online:  1,
+		},
+		{
+			name:   "flapping until stable for the flap window",
+			policy: quickRecovery,
+			steps: timeline(
+				[]livenessStep{
+					sweepAt(31*s, "offline"),
+					heartbeatAt(32*s, "online"),
+					sweepAt(63*s, "offline"),
+				},
+				flapping(heartbeatAt(64*s, "online")),
+				steady(74*s, 620*s, true),
+				// The first change leaves the window at 631s
+				steady(640*s, 700*s, false),
+			),
+			offline: 2,
+			online:  2,
+		},
+		{
+			name:   "changes spread beyond the flap window never flap",
+			policy: quickRecovery,
+			steps: timeline(
+				[]livenessStep{
+					sweepAt(31*s, "offline"),
+					heartbeatAt(32*s, "online"),
+				},
+				steady(42*s, 620*s, false),
+				[]livenessStep{
+					sweepAt(651*s, "offline"),
+					heartbeatAt(652*s, "online"),
+				},
+				steady(662*s, 1240*s, false),
+				[]livenessStep{
+					sweepAt(1271*s, "offline"),
+					heartbeatAt(1272*s, "online"),
+				},
+			),
+			offline: 3,
+			online:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry()
+			if err := r.SetLivenessPolicy(tt.policy); err != nil {
+				t.Fatalf("SetLivenessPolicy: %v", err)
+			}
+			start := time.Date(2025, 10, 28, 0, 0, 0, 0, time.UTC)
+			r.now = func() time.Time { return start }
+			id := r.Register(&AgentInfo{ID: "agent-1", Status: "online", LastSeen: start})
+
+			offline, online := 0, 0
+			r.OnOffline(func(string) { offline++ })
+			r.OnOnline(func(string) { online++ })
+
+			for _, step := range tt.steps {
+				now := start.Add(step.at)
+				r.now = func() time.Time { return now }
+				if step.heartbeat {
+					if agent, _ := r.Heartbeat(&Heartbeat{AgentID: id}); agent == nil {
+						t.Fatalf("at %v: heartbeat rejected", step.at)
+					}
+				}
+				r.sweepStaleAgents()
+
+				agent := r.Get(id)
+				if agent.Status != step.status {
+					t.Fatalf("at %v: status = %q, want %q", step.at, agent.Status, step.status)
+				}
+				if agent.Flapping != step.flapping {
+					t.Fatalf("at %v: flapping = %v (%d changes), want %v", step.at, agent.Flapping, agent.FlapCount, step.flapping)
+				}
+			}
+			if offline != tt.offline || online != tt.online {
+				t.Errorf("went offline %d and online %d times, want %d and %d", offline, online, tt.offline, tt.online)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// Package core provides operator-set agent metadata.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+)
+
+// Well-known metadata keys; any other valid key may be used as a label
+const (
+	MetadataOwner       = "owner"
+	MetadataEnvironment = "environment"
+	MetadataNotes       = "notes"
+
+	// MetadataChannel is the release channel an agent updates from
+	MetadataChannel = "channel"
+)
+
+const (
+	// maxMetadataKeys caps the metadata entries of an agent
+	maxMetadataKeys = 64
+
+	// maxMetadataKey and maxMetadataValue cap the length of keys and values
+	maxMetadataKey   = 63
+	maxMetadataValue = 1024
+)
+
+// PatchMetadata merges operator-set metadata into an agent: keys mapped to
+// nil are deleted, others are set. Metadata is never taken from agent
+// registrations or heartbeats. Returns the resulting metadata.
+func (r *Registry) PatchMetadata(id string, patch map[string]*string) (map[string]string, error) {
+	for key, value := range patch {
+		if err := validateMetadataKey(key); err != nil {
+			return nil, fmt.Errorf("metadata key %q: %v", key, err)
+		}
+		if value != nil {
+			if err := validateString(*value, maxMetadataValue); err != nil {
+				return nil, fmt.Errorf("metadata %s: %v", key, err)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent, ok := r.agents[id]
+	if !ok {
+		return nil, fmt.Errorf("agent %s not found", id)
+	}
+
+	// Copy on write so readers holding the previous map are unaffected
+	metadata := make(map[string]string, len(agent.Metadata)+len(patch))
+	for key, value := range agent.Metadata {
+		metadata[key] = value
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(metadata, key)
+		} else {
+			metadata[key] = *value
+		}
+	}
+	if len(metadata) > maxMetadataKeys {
+		return nil, fmt.Errorf("metadata is limited to %d keys", maxMetadataKeys)
+	}
+
+	agent.Metadata = metadata
+	r.sharedChangedLocked(id)
+	r.logger.Infof("Agent %s metadata updated", id)
+	return copyMetadata(metadata), nil
+}
+
+// copyMetadata returns a copy of an agent's metadata
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
+
+// validateMetadataKey accepts short keys of letters, digits, '-', '_' and '.'
+func validateMetadataKey(key string) error {
+	if key == "" || len(key) > maxMetadataKey {
+		return fmt.Errorf("must be 1 to %d characters", maxMetadataKey)
+	}
+	for _, ch := range key {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.') {
+			return fmt.Errorf("invalid character %q", ch)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+// Package core provides the agent statuses that hold back task dispatch,
+// letting operators quarantine flaky hosts while keeping them registered.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+// Statuses operators set to stop an agent receiving tasks
+const (
+	StatusMaintenance = "maintenance"
+	StatusError       = "error"
+)
+
+// DispatchHeld reports whether tasks for an agent in status are held back.
+// Held statuses are set by operators only: heartbeats and re-registrations
+// don't clear them, setting the agent back online does.
+func DispatchHeld(status string) bool {
+	return status == StatusMaintenance || status == StatusError
+}
+
+// HeldStatus returns the status of an agent whose tasks are held back, or ""
+func (r *Registry) HeldStatus(agentID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if agent, ok := r.agents[agentID]; ok && DispatchHeld(agent.Status) {
+		return agent.Status
+	}
+	return ""
+}
+
+// HeldAgents returns the registered agents among ids whose tasks are held
+// back, with their status
+func (r *Registry) HeldAgents(ids []string) map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	held := make(map[string]string)
+	for _, id := range ids {
+		if agent, ok := r.agents[id]; ok && DispatchHeld(agent.Status) {
+			held[id] = agent.Status
+		}
+	}
+	return held
+}
+
+// wakeAgent signals streaming agents to pick up their pending tasks, e.g.
+// tasks held while the agent was in maintenance
+func (s *Scheduler) wakeAgent(agentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.wakeWatchersLocked(agentID)
+}
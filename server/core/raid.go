@@ -0,0 +1,181 @@
+// Package core provides the per-controller RAID state agents report, and
+// alerting on arrays that degrade, rebuild or fail.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RAID volume states agents report
+const (
+	RaidHealthy    = "healthy"
+	RaidDegraded   = "degraded"
+	RaidRebuilding = "rebuilding"
+	RaidFailed     = "failed"
+	RaidUnknown    = "unknown"
+)
+
+const (
+	// maxRaidControllers and maxRaidVolumes cap the controllers an agent
+	// may report and the virtual disks of each
+	maxRaidControllers = 16
+	maxRaidVolumes     = 128
+)
+
+// RaidController is a RAID controller of an agent, or its software RAID,
+// and its virtual disks
+type RaidController struct {
+	Name         string       `json:"name"`
+	Tool         string       `json:"tool"`
+	VirtualDisks []RaidVolume `json:"virtual_disks"`
+}
+
+// RaidVolume is a virtual disk; State is normalized across tools and Detail
+// is the state the tool reported
+type RaidVolume struct {
+	Name   string `json:"name"`
+	Level  string `json:"level,omitempty"`
+	Size   string `json:"size,omitempty"`
+	State  string `json:"state"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// validateRaidInfo checks reported RAID controllers
+func validateRaidInfo(controllers []RaidController) error {
+	if len(controllers) > maxRaidControllers {
+		return fmt.Errorf("raid_info: more than %d controllers", maxRaidControllers)
+	}
+	for i, controller := range controllers {
+		if len(controller.VirtualDisks) > maxRaidVolumes {
+			return fmt.Errorf("raid_info[%d].virtual_disks: more than %d items", i, maxRaidVolumes)
+		}
+		for _, str := range []struct{ field, value string }{{"name", controller.Name}, {"tool", controller.Tool}} {
+			if err := validateString(str.value, maxFieldLength); err != nil {
+				return fmt.Errorf("raid_info[%d].%s: %v", i, str.field, err)
+			}
+		}
+		for j, volume := range controller.VirtualDisks {
+			switch volume.State {
+			case RaidHealthy, RaidDegraded, RaidRebuilding, RaidFailed, RaidUnknown:
+			default:
+				return fmt.Errorf("raid_info[%d].virtual_disks[%d].state: unknown state %q", i, j, volume.State)
+			}
+			strs := []struct{ field, value string }{
+				{"name", volume.Name},
+				{"level", volume.Level},
+				{"size", volume.Size},
+				{"detail", volume.Detail},
+			}
+			for _, str := range strs {
+				if err := validateString(str.value, maxFieldLength); err != nil {
+					return fmt.Errorf("raid_info[%d].virtual_disks[%d].%s: %v", i, j, str.field, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// decodeRaidInfo decodes the raid_info of a heartbeat's system_info
+func decodeRaidInfo(value interface{}) ([]RaidController, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var controllers []RaidController
+	if err := json.Unmarshal(encoded, &controllers); err != nil {
+		return nil, fmt.Errorf("raid_info: %v", err)
+	}
+	return controllers, validateRaidInfo(controllers)
+}
+
+// raidStates maps each virtual disk to its state
+func raidStates(controllers []RaidController) map[string]string {
+	states := make(map[string]string)
+	for _, controller := range controllers {
+		for _, volume := range controller.VirtualDisks {
+			states[volume.Name] = volume.State
+		}
+	}
+	return states
+}
+
+// raidChanged reports whether a virtual disk was added, removed or changed state
+func raidChanged(before, after []RaidController) bool {
+	old, updated := raidStates(before), raidStates(after)
+	if len(old) != len(updated) {
+		return true
+	}
+	for name, state := range updated {
+		if old[name] != state {
+			return true
+		}
+	}
+	return false
+}
+
+// updateRaidLocked records the RAID controllers of a heartbeat's
+// system_info, reporting whether a virtual disk changed state; caller must
+// hold r.mu
+func (r *Registry) updateRaidLocked(agent *AgentInfo, systemInfo map[string]interface{}) bool {
+	value, ok := systemInfo["raid_info"]
+	if !ok {
+		return false
+	}
+	controllers, err := decodeRaidInfo(value)
+	if err != nil {
+		r.logger.Errorf("Agent %s sent invalid RAID info: %v", agent.ID, err)
+		return false
+	}
+
+	changed := raidChanged(agent.RaidInfo, controllers)
+	agent.RaidInfo = controllers
+	return changed
+}
+
+// OnRaidChange registers a callback invoked when a heartbeat reports a RAID
+// virtual disk added, removed or in another state than before
+func (r *Registry) OnRaidChange(handler func(agentID string, controllers []RaidController)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.raidHandlers = append(r.raidHandlers, handler)
+}
+
+// notifyRaidChange invokes the RAID change handlers; must be called without r.mu held
+func (r *Registry) notifyRaidChange(agentID string, controllers []RaidController) {
+	r.mu.RLock()
+	handlers := r.raidHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, controllers)
+	}
+}
+
+// RaidAlertData returns an agent's RAID state as alert rule input,
+// matchable with conditions on event, the raid_degraded, raid_rebuilding,
+// raid_failed and raid_unhealthy volume counts, and raid_volumes.<name>
+// holding a volume's state, e.g. raid_unhealthy > 0 for any volume degraded
+func RaidAlertData(controllers []RaidController) map[string]interface{} {
+	counts := map[string]int{}
+	volumes := make(map[string]interface{})
+	for _, controller := range controllers {
+		for _, volume := range controller.VirtualDisks {
+			counts[volume.State]++
+			volumes[volume.Name] = volume.State
+		}
+	}
+	return map[string]interface{}{
+		"event":           "raid_status",
+		"raid_degraded":   counts[RaidDegraded],
+		"raid_rebuilding": counts[RaidRebuilding],
+		"raid_failed":     counts[RaidFailed],
+		"raid_unhealthy":  counts[RaidDegraded] + counts[RaidRebuilding] + counts[RaidFailed],
+		"raid_volumes":    volumes,
+	}
+}
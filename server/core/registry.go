@@ -1,10 +1,13 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/sharedstate"
 	"github.com/nerve/server/pkg/storage"
 )
 
@@ -41,6 +44,71 @@ type AgentInfo struct {
 	AgentVersion string                 `json:"agent_version"`
 	RegisteredAt time.Time              `json:"registered_at"`
 	LastSeen     time.Time              `json:"last_seen"`
+
+	// Capabilities are the task types and features the agent advertised at
+	// registration, or BaselineCapabilities for agents that advertise none
+	Capabilities []string `json:"capabilities"`
+
+	// LastPing is the last sign of life on the agent's WebSocket control channel
+	LastPing time.Time `json:"last_ping,omitempty"`
+
+	// Control is the control channel state from the last heartbeat that
+	// reported it
+	Control *ControlChannel `json:"control,omitempty"`
+
+	// ClockSkew is the agent clock minus the server clock in seconds, from the
+	// last heartbeat; ClockSkewed is set while it exceeds the tolerated skew
+	ClockSkew   float64 `json:"clock_skew_seconds"`
+	ClockSkewed bool    `json:"clock_skewed,omitempty"`
+
+	// InventoryHash is the hash of the last full inventory the agent sent
+	InventoryHash string `json:"inventory_hash,omitempty"`
+
+	// Metadata is set by operators through PatchMetadata, e.g. owner,
+	// environment, notes or labels; agent-reported updates never change it
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// CustomMetrics are the plugin metrics from the last heartbeat that
+	// carried any, keyed by plugin name
+	CustomMetrics map[string]interface{} `json:"custom_metrics,omitempty"`
+
+	// Usage is the resource utilization from the last heartbeat with metrics
+	Usage *AgentUsage `json:"usage,omitempty"`
+
+	// Kernel and Packages are reported at registration, see KernelAlertData
+	Kernel         *KernelInfo `json:"kernel,omitempty"`
+	PackageManager string      `json:"package_manager,omitempty"`
+	Packages       []Package   `json:"packages,omitempty"`
+
+	// RaidInfo is the RAID controllers and virtual disks the agent last
+	// reported, see RaidAlertData
+	RaidInfo []RaidController `json:"raid_info,omitempty"`
+
+	// RecoveryStreak counts the heartbeats in a row an offline agent sent
+	// toward coming back online, see LivenessPolicy
+	RecoveryStreak int `json:"recovery_streak,omitempty"`
+
+	// StatusChanges are when the agent went offline or came back online
+	// within the flap window; FlapCount counts them and Flapping is set
+	// while they reach the flap threshold
+	StatusChanges []time.Time `json:"status_changes,omitempty"`
+	FlapCount     int         `json:"flap_count"`
+	Flapping      bool        `json:"flapping,omitempty"`
+
+	// RunningTasks are the tasks the agent reported running in its last
+	// heartbeat, and StrayTasks those of them the server didn't dispatch to
+	// it or no longer expects it to run, see OnRunningTasks
+	RunningTasks []string `json:"running_tasks,omitempty"`
+	StrayTasks   []string `json:"stray_tasks,omitempty"`
+}
+
+// LastContact returns the most recent of the heartbeat and control channel
+// liveness signals
+func (a *AgentInfo) LastContact() time.Time {
+	if a.LastPing.After(a.LastSeen) {
+		return a.LastPing
+	}
+	return a.LastSeen
 }
 
 // Task represents a task
@@ -54,6 +122,42 @@ type Task struct {
 	Params  map[string]interface{} `json:"params,omitempty"`
 	Timeout int                    `json:"timeout,omitempty"`
 	Status  string                 `json:"status"`
+
+	// RequestID correlates the task with the API request that created it
+	RequestID string    `json:"request_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// ExpiresAt is when an undelivered or unfinished task is marked expired
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+
+	// ScheduleID is the schedule that created the task, if any
+	ScheduleID string `json:"schedule_id,omitempty"`
+
+	// BatchID groups the tasks created together for several agents, by one
+	// request or schedule run, see SearchOutput
+	BatchID string `json:"batch_id,omitempty"`
+
+	// Retry re-queues failed or expired tasks; Attempt counts from 1 and
+	// History records every finished attempt
+	Retry        *RetryPolicy  `json:"retry,omitempty"`
+	Attempt      int           `json:"attempt,omitempty"`
+	DispatchedAt time.Time     `json:"dispatched_at,omitempty"`
+	History      []TaskAttempt `json:"history,omitempty"`
+
+	// Signature is set at dispatch when task signing is enabled, see SetTaskSigner
+	Signature string `json:"signature,omitempty"`
+
+	// Output of the finished task, truncated to the output cap, see
+	// SetTaskResultPolicy
+	Output          string `json:"output,omitempty"`
+	OutputTruncated bool   `json:"output_truncated,omitempty"`
+
+	// expiry is the time allowed for each attempt
+	expiry time.Duration
+
+	// savedAt is when this server last wrote the task to shared storage
+	savedAt time.Time
 }
 
 // TaskResult represents task execution result
@@ -62,6 +166,141 @@ type TaskResult struct {
 	Success bool   `json:"success"`
 	Output  string `json:"output,omitempty"`
 	Error   string `json:"error,omitempty"`
+
+	// Data holds structured results, e.g. of benchmark tasks
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// RegisterRequest is the registration payload sent by agents
+type RegisterRequest struct {
+	Hostname     string                   `json:"hostname" binding:"required"`
+
+	// AgentID is the identity the agent asks to be registered under instead
+	// of its hostname, and IdentitySource where the agent took it from, e.g.
+	// machine-id; see registrationIDLocked
+	AgentID        string `json:"agent_id,omitempty"`
+	IdentitySource string `json:"identity_source,omitempty"`
+
+	CPUType      string                   `json:"cpu_type"`
+	CPULogic     int                      `json:"cpu_logic"`
+	Memsum       int64                    `json:"memsum"`
+	Memory       string                   `json:"memory"`
+	SN           string                   `json:"sn"`
+	Product      string                   `json:"product"`
+	Brand        string                   `json:"brand"`
+	Netcard      []string                 `json:"netcard"`
+	Basearch     string                   `json:"basearch"`
+	Disk         map[string]interface{}   `json:"disk"`
+	Raid         string                   `json:"raid"`
+	IPMIIP       string                   `json:"ipmi_ip"`
+	ManageIP     string                   `json:"manageip"`
+	StorageIP    string                   `json:"storageip"`
+	ParamIP      string                   `json:"paramip"`
+	OS           string                   `json:"os"`
+	GPUNum       int                      `json:"gpu_num"`
+	GPUType      string                   `json:"gpu_type"`
+	GPUVendors   []string                 `json:"gpu_vendors"`
+	DiskInfo     []map[string]interface{} `json:"disk_info"`
+	MemoryInfo   []map[string]interface{} `json:"memory_info"`
+	CPUInfo      map[string]interface{}   `json:"cpu_info"`
+	GPUInfo      []map[string]interface{} `json:"gpu_info"`
+	NetworkInfo  []map[string]interface{} `json:"network_info"`
+	RaidInfo     []RaidController         `json:"raid_info,omitempty"`
+	AgentVersion string                   `json:"agent_version"`
+
+	// Capabilities lists what the agent supports, see BaselineCapabilities
+	Capabilities []string `json:"capabilities,omitempty"`
+
+	// Kernel and Packages, the latter only when enabled on the agent
+	Kernel         *KernelInfo `json:"kernel,omitempty"`
+	PackageManager string      `json:"package_manager,omitempty"`
+	Packages       []Package   `json:"packages,omitempty"`
+}
+
+// AgentInfo builds an online AgentInfo from the registration payload. The
+// ID is the identity the agent asked for, empty to key it by hostname; the
+// registry settles the ID it is registered under.
+func (req *RegisterRequest) AgentInfo(id string) *AgentInfo {
+	now := time.Now()
+	return &AgentInfo{
+		ID:           id,
+		Hostname:     req.Hostname,
+		CPUType:      req.CPUType,
+		CPULogic:     req.CPULogic,
+		Memsum:       req.Memsum,
+		Memory:       req.Memory,
+		SN:           req.SN,
+		Product:      req.Product,
+		Brand:        req.Brand,
+		Netcard:      req.Netcard,
+		Basearch:     req.Basearch,
+		Disk:         req.Disk,
+		Raid:         req.Raid,
+		IPMIIP:       req.IPMIIP,
+		ManageIP:     req.ManageIP,
+		StorageIP:    req.StorageIP,
+		ParamIP:      req.ParamIP,
+		OS:           req.OS,
+		Status:       "online",
+		GPUNum:       req.GPUNum,
+		GPUType:      req.GPUType,
+		GPUVendors:   req.GPUVendors,
+		DiskInfo:     req.DiskInfo,
+		MemoryInfo:   req.MemoryInfo,
+		CPUInfo:      req.CPUInfo,
+		GPUInfo:      req.GPUInfo,
+		NetworkInfo:  req.NetworkInfo,
+		RaidInfo:     req.RaidInfo,
+		UpdateTime:   now.Format("2006-01-02 15:04:05"),
+		AgentVersion: req.AgentVersion,
+		Capabilities: req.Capabilities,
+		RegisteredAt: now,
+		LastSeen:     now,
+
+		Kernel:         req.Kernel,
+		PackageManager: req.PackageManager,
+		Packages:       req.Packages,
+	}
+}
+
+// Heartbeat is the periodic status payload sent by agents
+type Heartbeat struct {
+	AgentID    string                 `json:"agent_id,omitempty"`
+	Status     string                 `json:"status"`
+	SystemInfo map[string]interface{} `json:"system_info,omitempty"`
+	Metrics    map[string]interface{} `json:"metrics,omitempty"`
+
+	// Tasks are the IDs of the tasks the agent runs or has yet to deliver
+	// results for; agents that leave it out don't report their tasks
+	Tasks []string `json:"tasks,omitempty"`
+
+	// Timestamp is the agent's clock when it sent the heartbeat
+	Timestamp time.Time `json:"timestamp,omitempty"`
+
+	// InventoryHash identifies the agent's inventory; heartbeats that omit
+	// system_info carry only the hash of the inventory last sent in full
+	InventoryHash string `json:"inventory_hash,omitempty"`
+
+	// Custom holds metrics contributed by agent plugins, keyed by plugin name
+	Custom map[string]interface{} `json:"custom,omitempty"`
+
+	// Control is the agent's report of its control channel
+	Control *ControlChannel `json:"control,omitempty"`
+
+	// RulesVersion is the version of the alert rules an agent evaluating
+	// them holds, and AlertCandidates the rules it reports matching
+	RulesVersion    string          `json:"rules_version,omitempty"`
+	AlertCandidates json.RawMessage `json:"alert_candidates,omitempty"`
+}
+
+// Sender identifies the agent that sent a heartbeat: its ID, else the
+// hostname in system_info that token-based heartbeats are matched by
+func (hb *Heartbeat) Sender() string {
+	if hb.AgentID != "" {
+		return hb.AgentID
+	}
+	hostname, _ := hb.SystemInfo["hostname"].(string)
+	return hostname
 }
 
 // Registry manages agent registry
@@ -70,6 +309,76 @@ type Registry struct {
 	store storage.Storage
 	agents map[string]*AgentInfo
 	logger log.Logger
+
+	// Desired agent configuration and the last acknowledgement, see SetConfig
+	configs      map[string]*AgentConfig
+	configStatus map[string]ConfigStatus
+
+	// Callbacks for agents registering, going offline and coming online, see OnOffline
+	offlineHandlers    []func(agentID string)
+	onlineHandlers     []func(agentID string)
+	registeredHandlers []func(agentID string)
+
+	// Stale agent thresholds and removal callbacks, see SetStalePolicy
+	offlineAfter    time.Duration
+	removeAfter     time.Duration
+	removedHandlers []func(agentID string)
+
+	// Debouncing of agents going offline and online, see SetLivenessPolicy;
+	// now is the clock heartbeats and the stale agent sweep go by
+	liveness LivenessPolicy
+	now      func() time.Time
+
+	// Bounded inventory change history and callbacks, see OnInventoryChange
+	inventoryChanges  map[string][]InventoryChange
+	inventoryHandlers []func(agentID string, changes []InventoryChange)
+
+	// Tolerated agent clock skew and callbacks, see OnClockSkew
+	maxClockSkew time.Duration
+	skewHandlers []func(agentID string, skew time.Duration)
+
+	// Callbacks for plugin metrics in heartbeats, see OnCustomMetrics
+	customHandlers []func(agentID string, custom map[string]interface{})
+
+	// Callbacks for RAID virtual disks changing state, see OnRaidChange
+	raidHandlers []func(agentID string, controllers []RaidController)
+
+	// Callbacks for BMC sensors changing status, see OnSensorChange
+	sensorHandlers []func(agentID string, sensors []Sensor)
+
+	// Callbacks for filesystem usage and disk IO in heartbeats, see OnStorageMetrics
+	storageHandlers []func(agentID string, usage *AgentUsage)
+
+	// Callbacks for usage metrics in heartbeats, see OnMetricsSummary
+	summaryHandlers []func(agentID string, usage *AgentUsage)
+
+	// Callbacks for the tasks agents report running, see OnRunningTasks
+	runningHandlers []func(agentID string, tasks []string)
+
+	// Tokens agents registered with and hashes of retired ones, and
+	// callbacks for agents being decommissioned, see Decommission
+	agentTokens          map[string]string
+	retiredTokens        map[string]bool
+	decommissionHandlers []func(agentID string)
+
+	// Benchmark results of each agent, see RecordBenchmarkResult
+	benchmarks map[string][]BenchmarkResult
+
+	// Callbacks for machines registering with a taken hostname, see
+	// OnHostnameCollision
+	collisionHandlers []func(collision HostnameCollision)
+
+	// Agent records shared with other servers through the storage: changes
+	// not written yet or being written, and when each record was last
+	// written and read, see EnableSharedState
+	bus           *sharedstate.Bus
+	cacheTTL      time.Duration
+	sharedDirty   map[string]bool
+	sharedWriting map[string]bool
+	sharedSaved   map[string]time.Time
+	sharedLoaded  map[string]time.Time
+	sharedListed  time.Time
+	sharedFlush   chan struct{}
 }
 
 // NewRegistry creates a new registry
@@ -78,41 +387,175 @@ func NewRegistry(store storage.Storage, logger log.Logger) *Registry {
 		store:  store,
 		agents: make(map[string]*AgentInfo),
 		logger: logger,
+
+		configs:      make(map[string]*AgentConfig),
+		configStatus: make(map[string]ConfigStatus),
+
+		inventoryChanges: make(map[string][]InventoryChange),
+
+		agentTokens:   make(map[string]string),
+		retiredTokens: make(map[string]bool),
+
+		benchmarks: make(map[string][]BenchmarkResult),
+
+		maxClockSkew: DefaultMaxClockSkew,
+		offlineAfter: DefaultOfflineAfter,
+		liveness:     DefaultLivenessPolicy(),
+		now:          time.Now,
 	}
 
+	registry.loadRetiredTokens()
+
 	// Start cleanup goroutine
 	go registry.cleanupStaleAgents()
 
 	return registry
 }
 
-// Register registers an agent
+// Register registers an agent. Re-registering an agent diffs its inventory
+// against the previous registration.
 func (r *Registry) Register(agent *AgentInfo) string {
+	id, _ := r.register(agent, "", nil)
+	return id
+}
+
+// register registers an agent, binding it to token unless it is empty.
+// An agent bound to another token is only taken over if replaces reports
+// the token may replace the bound one.
+func (r *Registry) register(agent *AgentInfo, token string, replaces func(bound string) bool) (string, error) {
+	r.refreshAgent(registrationKey(agent))
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
-	id := agent.Hostname // Use hostname as ID for now
+	// Agents are keyed by the identity they asked for or their hostname,
+	// unless a different machine has it already
+	id, collision := r.registrationIDLocked(agent)
+	if token != "" {
+		if bound := r.agentTokens[id]; bound != "" && bound != token && (replaces == nil || !replaces(bound)) {
+			r.mu.Unlock()
+			return "", ErrAgentTokenMismatch
+		}
+		r.agentTokens[id] = token
+	}
 	agent.ID = id
+	if collision != nil {
+		r.logger.Errorf("Registration collision: %s (SN %q) is registered by another machine (SN %q), registering it as %s",
+			registrationKey(agent), collision.SN, collision.ExistingSN, id)
+	}
+	agent.Capabilities = normalizeCapabilities(agent.Capabilities)
+
+	var changes []InventoryChange
+	previous := ""
+	// Operator-set metadata and held statuses survive re-registration and can't be reported by the agent
+	agent.Metadata = nil
+	if existing, ok := r.agents[id]; ok {
+		changes = diffInventory(id, inventorySnapshot(existing), inventorySnapshot(agent))
+		r.recordInventoryChangesLocked(id, changes)
+		previous = existing.Status
+		agent.Metadata = existing.Metadata
+		if DispatchHeld(existing.Status) {
+			agent.Status = existing.Status
+		}
+		// Registering is a deliberate restart, so it brings the agent back
+		// online at once, though it still counts toward flapping
+		keepLiveness(existing, agent)
+		agent.RecoveryStreak = 0
+		if previous == "offline" && agent.Status != "offline" {
+			r.recordStatusChangeLocked(agent, r.now())
+		}
+	}
 
 	r.agents[id] = agent
+	r.sharedChangedLocked(id)
 	r.logger.Infof("Registered agent: %s", id)
+	r.mu.Unlock()
 
-	return id
+	r.notifyInventoryChanges(id, changes)
+	if collision != nil {
+		r.notifyHostnameCollision(*collision)
+	}
+	r.notifyRegistered(id)
+	if cameOnline(previous, agent.Status) {
+		r.notifyOnline(id)
+	}
+	return id, nil
 }
 
 // Update updates agent information
 func (r *Registry) Update(id string, agent *AgentInfo) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
+	var changes []InventoryChange
 	if existing, ok := r.agents[id]; ok {
+		changes = diffInventory(id, inventorySnapshot(existing), inventorySnapshot(agent))
+		r.recordInventoryChangesLocked(id, changes)
+
+		// Agent-reported fields replace the record; operator-set metadata and
+		// held statuses are kept, as are capabilities, which are only
+		// advertised at registration
+		metadata := existing.Metadata
+		capabilities := existing.Capabilities
+		status := existing.Status
+		kept := *existing
 		*existing = *agent
+		keepLiveness(&kept, existing)
 		existing.ID = id
+		existing.Metadata = metadata
+		existing.Capabilities = capabilities
+		if DispatchHeld(status) {
+			existing.Status = status
+		}
+		r.sharedChangedLocked(id)
 	}
+	r.mu.Unlock()
+
+	r.notifyInventoryChanges(id, changes)
+}
+
+// Remove removes an agent from the registry
+func (r *Registry) Remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.agents[id]; !ok {
+		return false
+	}
+
+	delete(r.agents, id)
+	delete(r.inventoryChanges, id)
+	delete(r.agentTokens, id)
+	r.sharedChangedLocked(id)
+	r.logger.Infof("Removed agent: %s", id)
+
+	return true
+}
+
+// SetStatus sets the status of an agent
+func (r *Registry) SetStatus(id, status string) bool {
+	r.mu.Lock()
+
+	agent, ok := r.agents[id]
+	if !ok {
+		r.mu.Unlock()
+		return false
+	}
+
+	previous := agent.Status
+	if previous != status {
+		r.logger.Infof("Agent %s status changed: %s -> %s", id, previous, status)
+	}
+	agent.Status = status
+	r.sharedChangedLocked(id)
+	r.mu.Unlock()
+
+	if cameOnline(previous, status) {
+		r.notifyOnline(id)
+	}
+	return true
 }
 
 // Get retrieves an agent by ID
 func (r *Registry) Get(id string) *AgentInfo {
+	r.refreshAgent(id)
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -121,6 +564,7 @@ func (r *Registry) Get(id string) *AgentInfo {
 
 // List returns all agents
 func (r *Registry) List() []*AgentInfo {
+	r.refreshAgents()
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
@@ -132,21 +576,388 @@ func (r *Registry) List() []*AgentInfo {
 	return agents
 }
 
-// cleanupStaleAgents removes agents that haven't been seen for 5 minutes
-func (r *Registry) cleanupStaleAgents() {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		r.mu.Lock()
-		now := time.Now()
-		for id, agent := range r.agents {
-			if now.Sub(agent.LastSeen) > 5*time.Minute {
-				agent.Status = "offline"
-				r.logger.Infof("Agent marked as offline: %s", id)
+// Heartbeat records a heartbeat and returns the updated agent along with the
+// time since its previous heartbeat. Agents are looked up by ID, falling back
+// to the hostname in system_info. Returns nil if the agent is not registered.
+func (r *Registry) Heartbeat(hb *Heartbeat) (*AgentInfo, time.Duration) {
+	if hb.AgentID != "" {
+		r.refreshAgent(hb.AgentID)
+	}
+	agent, interval, events := r.heartbeat(hb)
+	if agent == nil {
+		return nil, 0
+	}
+
+	r.notifyInventoryChanges(agent.ID, events.changes)
+	if events.online {
+		r.notifyOnline(agent.ID)
+	}
+	if events.skewed {
+		r.notifyClockSkew(agent.ID, events.skew)
+	}
+	if events.custom != nil {
+		r.notifyCustomMetrics(agent.ID, events.custom)
+	}
+	if events.raid {
+		r.notifyRaidChange(agent.ID, events.raidInfo)
+	}
+	if events.sensors != nil {
+		r.notifySensorChange(agent.ID, events.sensors)
+	}
+	if events.storage != nil {
+		r.notifyStorageMetrics(agent.ID, events.storage)
+	}
+	if events.summary != nil {
+		r.notifyMetricsSummary(agent.ID, events.summary)
+	}
+	if events.tasks != nil {
+		r.notifyRunningTasks(agent.ID, events.tasks)
+	}
+	r.saveHeartbeat(agent.ID, hb)
+	return agent, interval
+}
+
+// saveHeartbeat adds a heartbeat's metrics to the agent's heartbeat history,
+// if the storage backend keeps one; must be called without r.mu held
+func (r *Registry) saveHeartbeat(agentID string, hb *Heartbeat) {
+	recorder, ok := r.store.(storage.HeartbeatRecorder)
+	if _, queryable := r.store.(storage.HeartbeatQuerier); !ok || !queryable || hb.Metrics == nil {
+		return
+	}
+	if err := recorder.SaveHeartbeat(agentID, hb.Metrics); err != nil && !errors.Is(err, storage.ErrNoHeartbeatHistory) {
+		r.logger.Errorf("Failed to save heartbeat of agent %s: %v", agentID, err)
+	}
+}
+
+// heartbeatEvents are the notifications due once a heartbeat is applied
+type heartbeatEvents struct {
+	changes  []InventoryChange
+	online   bool
+	skewed   bool
+	skew     time.Duration
+	custom   map[string]interface{}
+	raid     bool
+	raidInfo []RaidController
+	sensors  []Sensor
+	storage  *AgentUsage
+	summary  *AgentUsage
+	tasks    []string
+}
+
+// heartbeat applies a heartbeat and returns the notifications it triggers
+func (r *Registry) heartbeat(hb *Heartbeat) (*AgentInfo, time.Duration, heartbeatEvents) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent := r.heartbeatAgentLocked(hb)
+	var events heartbeatEvents
+	if agent == nil {
+		return nil, 0, events
+	}
+
+	now := r.now()
+	var interval time.Duration
+	if !agent.LastSeen.IsZero() {
+		interval = now.Sub(agent.LastSeen)
+	}
+	previousContact := agent.LastContact()
+	agent.LastSeen = now
+	events.skew, events.skewed = r.updateClockSkewLocked(agent, hb.Timestamp, agent.LastSeen)
+
+	previous := agent.Status
+	switch {
+	case previous == StatusDecommissioning:
+		// Keep the status until the agent is removed
+	case DispatchHeld(previous):
+		// Operator-set, kept until an operator clears it
+	case hb.Status != "":
+		agent.Status = hb.Status
+	default:
+		agent.Status = "online"
+	}
+	if previous == "offline" && agent.Status != "offline" {
+		if r.recoverLocked(agent, previousContact, agent.LastSeen) {
+			r.recordStatusChangeLocked(agent, agent.LastSeen)
+		} else {
+			agent.Status = previous
+		}
+	}
+
+	// Update relevant fields from system_info
+	if hb.SystemInfo != nil {
+		before := inventorySnapshot(agent)
+
+		if hostname, ok := hb.SystemInfo["hostname"].(string); ok {
+			agent.Hostname = hostname
+		}
+		if cpuType, ok := hb.SystemInfo["cpu_type"].(string); ok {
+			agent.CPUType = cpuType
+		}
+		if cpuLogic, ok := hb.SystemInfo["cpu_logic"].(float64); ok {
+			agent.CPULogic = int(cpuLogic)
+		}
+		if memory, ok := hb.SystemInfo["memory"].(string); ok {
+			agent.Memory = memory
+		}
+		if memsum, ok := hb.SystemInfo["memsum"].(float64); ok {
+			agent.Memsum = int64(memsum)
+		}
+		if gpuNum, ok := hb.SystemInfo["gpu_num"].(float64); ok {
+			agent.GPUNum = int(gpuNum)
+		}
+		if gpuType, ok := hb.SystemInfo["gpu_type"].(string); ok {
+			agent.GPUType = gpuType
+		}
+		if diskInfo, ok := hb.SystemInfo["disk_info"].([]interface{}); ok {
+			agent.DiskInfo = make([]map[string]interface{}, 0, len(diskInfo))
+			for _, disk := range diskInfo {
+				if d, ok := disk.(map[string]interface{}); ok {
+					agent.DiskInfo = append(agent.DiskInfo, d)
+				}
 			}
 		}
+
+		events.raid = r.updateRaidLocked(agent, hb.SystemInfo)
+		events.raidInfo = agent.RaidInfo
+
+		events.changes = diffInventory(agent.ID, before, inventorySnapshot(agent))
+		r.recordInventoryChangesLocked(agent.ID, events.changes)
+		agent.InventoryHash = hb.InventoryHash
+	}
+	events.custom = r.updateCustomMetricsLocked(agent, hb.Custom)
+	events.sensors = r.updateUsageLocked(agent, hb.Metrics, agent.LastSeen)
+	if usage := agent.Usage; len(hb.Metrics) > 0 && usage != nil && (usage.Filesystems != nil || usage.DiskIO != nil) {
+		events.storage = usage
+	}
+	if usage := agent.Usage; len(hb.Metrics) > 0 && usage != nil {
+		events.summary = usage
+	}
+	controlChanged := r.updateControlLocked(agent, hb.Control)
+	tasksChanged := false
+	if events.tasks = parseRunningTasks(hb.Tasks); events.tasks != nil {
+		tasksChanged = !stringsEqual(agent.RunningTasks, events.tasks)
+		agent.RunningTasks = events.tasks
+	}
+
+	events.online = cameOnline(previous, agent.Status)
+	if agent.Status != previous || hb.SystemInfo != nil || controlChanged || tasksChanged {
+		r.sharedChangedLocked(agent.ID)
+	} else {
+		r.sharedContactLocked(agent.ID)
+	}
+	return agent, interval, events
+}
+
+// heartbeatAgentLocked returns the agent a heartbeat comes from, by its ID
+// or else the hostname in system_info; caller must hold r.mu
+func (r *Registry) heartbeatAgentLocked(hb *Heartbeat) *AgentInfo {
+	if agent := r.agents[hb.AgentID]; agent != nil {
+		return agent
+	}
+	// Machines sharing a hostname are told apart by their SN
+	if hostname, ok := hb.SystemInfo["hostname"].(string); ok && hostname != "" {
+		sn, _ := hb.SystemInfo["sn"].(string)
+		var match *AgentInfo
+		for _, agent := range r.agents {
+			if agent.Hostname != hostname {
+				continue
+			}
+			if sn != "" && agent.SN == sn {
+				return agent
+			}
+			if match == nil || agent.ID == hostname {
+				match = agent
+			}
+		}
+		return match
+	}
+	return nil
+}
+
+// HeartbeatAgentID returns the ID of the registered agent a heartbeat comes
+// from, or "" if it matches none
+func (r *Registry) HeartbeatAgentID(hb *Heartbeat) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if agent := r.heartbeatAgentLocked(hb); agent != nil {
+		return agent.ID
+	}
+	return ""
+}
+
+// NeedsInventory reports whether a heartbeat without system_info refers to an
+// inventory the registry doesn't hold, e.g. after a server restart, so the
+// agent must resend it in full
+func (r *Registry) NeedsInventory(hb *Heartbeat) bool {
+	if hb.SystemInfo != nil || hb.InventoryHash == "" {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	agent := r.agents[hb.AgentID]
+	return agent == nil || agent.InventoryHash != hb.InventoryHash
+}
+
+// Store returns the storage backend used by the registry
+func (r *Registry) Store() storage.Storage {
+	return r.store
+}
+
+// ControlAlive records liveness seen on an agent's WebSocket control channel.
+// A live control channel keeps the agent online even if its heartbeats stop
+// arriving, and brings an offline agent back online once it counts as
+// recovered, see LivenessPolicy.
+func (r *Registry) ControlAlive(agentID string) {
+	r.mu.Lock()
+
+	agent, ok := r.agents[agentID]
+	if !ok {
 		r.mu.Unlock()
+		return
+	}
+
+	previousContact := agent.LastContact()
+	agent.LastPing = r.now()
+	online := agent.Status == "offline" && r.recoverLocked(agent, previousContact, agent.LastPing)
+	if online {
+		agent.Status = "online"
+		r.recordStatusChangeLocked(agent, agent.LastPing)
+		r.sharedChangedLocked(agentID)
+		r.logger.Infof("Agent back online via control channel: %s", agentID)
+	} else {
+		r.sharedContactLocked(agentID)
+	}
+	r.mu.Unlock()
+
+	if online {
+		r.notifyOnline(agentID)
+	}
+}
+
+// OnOffline registers a callback invoked when an agent is marked offline
+func (r *Registry) OnOffline(handler func(agentID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.offlineHandlers = append(r.offlineHandlers, handler)
+}
+
+// OnOnline registers a callback invoked when an agent registers or comes back online
+func (r *Registry) OnOnline(handler func(agentID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.onlineHandlers = append(r.onlineHandlers, handler)
+}
+
+// OnRegistered registers a callback invoked each time an agent registers,
+// including re-registrations
+func (r *Registry) OnRegistered(handler func(agentID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.registeredHandlers = append(r.registeredHandlers, handler)
+}
+
+// notifyOnline invokes the online handlers; must be called without r.mu held
+func (r *Registry) notifyOnline(agentID string) {
+	r.mu.RLock()
+	handlers := r.onlineHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID)
+	}
+}
+
+// notifyRegistered invokes the registration handlers; must be called without
+// r.mu held
+func (r *Registry) notifyRegistered(agentID string) {
+	r.mu.RLock()
+	handlers := r.registeredHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID)
+	}
+}
+
+// cameOnline reports whether a status change brings an agent online
+func cameOnline(previous, status string) bool {
+	return status == "online" && previous != "online"
+}
+
+// cleanupStaleAgents marks agents that have shown no heartbeat and no control
+// channel liveness for the offline threshold as offline, and removes offline
+// agents past the removal threshold. Both signals count as contact, so an
+// agent only goes offline once both are stale. Agents in maintenance are
+// left alone. Servers sharing agent records take turns.
+func (r *Registry) cleanupStaleAgents() {
+	for {
+		time.Sleep(r.staleCheckInterval())
+
+		r.mu.RLock()
+		bus := r.bus
+		r.mu.RUnlock()
+		release, turn := sharedTurn(r.store, bus, staleLockName)
+		if !turn {
+			continue
+		}
+
+		r.sweepStaleAgents()
+		release()
+	}
+}
+
+// sweepStaleAgents marks the agents silent past the offline threshold as
+// offline and removes those offline past the removal threshold
+func (r *Registry) sweepStaleAgents() {
+	r.mu.Lock()
+	now := r.now()
+	var offline, removed []string
+	offlineAfter := r.offlineThresholdLocked()
+	for id, agent := range r.agents {
+		if flaps := agent.FlapCount; flaps > 0 {
+			r.pruneStatusChangesLocked(agent, now)
+			if agent.FlapCount != flaps {
+				r.sharedChangedLocked(id)
+			}
+		}
+		if agent.Status == StatusMaintenance {
+			continue
+		}
+		silence := now.Sub(agent.LastContact())
+		if agent.Status == "offline" && r.removeAfter > 0 && silence > r.removeAfter {
+			r.purgeLocked(id)
+			removed = append(removed, id)
+			r.logger.Infof("Removed agent offline for %v: %s", silence.Round(time.Minute), id)
+			continue
+		}
+		if agent.Status != "offline" && silence > offlineAfter {
+			agent.Status = "offline"
+			agent.RecoveryStreak = 0
+			r.recordStatusChangeLocked(agent, now)
+			r.sharedChangedLocked(id)
+			offline = append(offline, id)
+			r.logger.Infof("Agent marked as offline: %s", id)
+		}
+	}
+	offlineHandlers := r.offlineHandlers
+	removedHandlers := r.removedHandlers
+	r.mu.Unlock()
+
+	for _, id := range offline {
+		for _, handler := range offlineHandlers {
+			handler(id)
+		}
+	}
+	for _, id := range removed {
+		for _, handler := range removedHandlers {
+			handler(id)
+		}
 	}
 }
 
@@ -0,0 +1,122 @@
+// Package core provides the retry policy the scheduler applies to failed and expired tasks.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nerve/server/pkg/log"
+)
+
+const (
+	// maxTaskAttempts caps RetryPolicy.MaxAttempts
+	maxTaskAttempts = 10
+
+	// defaultRetryBackoff is the delay before the first retry when none is set
+	defaultRetryBackoff = 10 * time.Second
+)
+
+// RetryPolicy re-queues a task that failed or expired, waiting Backoff
+// seconds before the first retry and doubling the delay after each attempt
+type RetryPolicy struct {
+	MaxAttempts int `json:"max_attempts"`
+	Backoff     int `json:"backoff,omitempty"`
+
+	// NonIdempotent tasks are only retried if they never reached the agent,
+	// so a command that may have run is never run twice
+	NonIdempotent bool `json:"non_idempotent,omitempty"`
+}
+
+// TaskAttempt records the outcome of one attempt of a task
+type TaskAttempt struct {
+	Attempt      int       `json:"attempt"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	DispatchedAt time.Time `json:"dispatched_at,omitempty"`
+	FinishedAt   time.Time `json:"finished_at"`
+}
+
+// Validate checks the retry policy limits
+func (p *RetryPolicy) Validate() error {
+	if p.MaxAttempts < 1 || p.MaxAttempts > maxTaskAttempts {
+		return fmt.Errorf("retry max_attempts must be between 1 and %d", maxTaskAttempts)
+	}
+	if p.Backoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+	return nil
+}
+
+// delay returns the wait before the given attempt, counting from 2
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	backoff := defaultRetryBackoff
+	if p.Backoff > 0 {
+		backoff = time.Duration(p.Backoff) * time.Second
+	}
+	for i := 2; i < attempt; i++ {
+		backoff *= 2
+	}
+	return backoff
+}
+
+// recordAttemptLocked appends the outcome of the current attempt to the task history; caller must hold s.mu
+func (s *Scheduler) recordAttemptLocked(task *Task, status, errMsg string, now time.Time) {
+	task.History = append(task.History, TaskAttempt{
+		Attempt:      task.Attempt,
+		Status:       status,
+		Error:        errMsg,
+		DispatchedAt: task.DispatchedAt,
+		FinishedAt:   now,
+	})
+	task.UpdatedAt = now
+}
+
+// retryLocked re-queues a task after its backoff if its retry policy allows
+// another attempt, and reports whether it did; caller must hold s.mu
+func (s *Scheduler) retryLocked(task *Task, now time.Time) bool {
+	policy := task.Retry
+	if policy == nil || task.Attempt >= policy.MaxAttempts {
+		return false
+	}
+	if policy.NonIdempotent && !task.DispatchedAt.IsZero() {
+		return false
+	}
+
+	task.Attempt++
+	delay := policy.delay(task.Attempt)
+	task.Status = "retrying"
+	task.DispatchedAt = time.Time{}
+	task.ExpiresAt = now.Add(delay + task.expiry)
+	task.UpdatedAt = now
+
+	log.WithRequestID(s.logger, task.RequestID).Infof("Task %s will be retried in %v (attempt %d of %d)",
+		task.ID, delay, task.Attempt, policy.MaxAttempts)
+
+	s.saveTaskLocked(task)
+
+	attempt := task.Attempt
+	time.AfterFunc(delay, func() {
+		s.requeue(task.ID, attempt)
+	})
+	return true
+}
+
+// requeue makes a task waiting for a retry pending again
+func (s *Scheduler) requeue(taskID string, attempt int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The task may have been cancelled while waiting
+	task, ok := s.tasks[taskID]
+	if !ok || task.Status != "retrying" || task.Attempt != attempt {
+		return
+	}
+
+	task.Status = "pending"
+	task.UpdatedAt = time.Now()
+	s.saveTaskLocked(task)
+	s.wakeWatchersLocked(task.AgentID)
+}
@@ -0,0 +1,136 @@
+// Package core provides reconciling the tasks agents report running in their
+// heartbeats with the tasks the scheduler dispatched to them.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"sort"
+	"time"
+)
+
+// orphanedTaskGrace is how long after dispatch a running task may be missing
+// from an agent's heartbeats, since a heartbeat sent before the agent picked
+// the task up doesn't list it yet
+const orphanedTaskGrace = time.Minute
+
+// parseRunningTasks returns the task IDs of a heartbeat, skipping malformed
+// ones; nil means the agent doesn't report its tasks
+func parseRunningTasks(tasks []string) []string {
+	if tasks == nil {
+		return nil
+	}
+	running := make([]string, 0, len(tasks))
+	for _, id := range tasks {
+		if len(running) == maxListItems {
+			break
+		}
+		if id != "" && validateString(id, maxFieldLength) == nil {
+			running = append(running, id)
+		}
+	}
+	sort.Strings(running)
+	return running
+}
+
+// OnRunningTasks registers a callback invoked with the tasks an agent
+// reports in a heartbeat, by agents that report them
+func (r *Registry) OnRunningTasks(handler func(agentID string, tasks []string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.runningHandlers = append(r.runningHandlers, handler)
+}
+
+// notifyRunningTasks invokes the running task handlers; must be called without r.mu held
+func (r *Registry) notifyRunningTasks(agentID string, tasks []string) {
+	r.mu.RLock()
+	handlers := r.runningHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, tasks)
+	}
+}
+
+// setStrayTasks records the tasks an agent runs that the server doesn't
+// expect it to run, logging when they change
+func (r *Registry) setStrayTasks(agentID string, stray []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	agent, ok := r.agents[agentID]
+	if !ok || stringsEqual(agent.StrayTasks, stray) {
+		return
+	}
+	agent.StrayTasks = stray
+	r.sharedChangedLocked(agentID)
+	if len(stray) > 0 {
+		r.logger.Errorf("Agent %s is running tasks the server has not dispatched to it: %v", agentID, stray)
+	}
+}
+
+// stringsEqual reports whether two sorted lists hold the same strings
+func stringsEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// reconcileTasks compares the tasks an agent reports with those the server
+// has dispatched to it. A task running on the server that the agent no longer
+// lists, well after its dispatch, is orphaned and fails like a task that
+// reported failure, so its retry policy applies. Tasks the agent runs that
+// the server doesn't know, or holds pending, retrying, expired or cancelled,
+// are recorded as stray tasks on the agent.
+func (s *Scheduler) reconcileTasks(agentID string, reported []string) {
+	listed := make(map[string]bool, len(reported))
+	for _, id := range reported {
+		listed[id] = true
+
+		// The task may have been dispatched by another server
+		s.mu.RLock()
+		_, known := s.tasks[id]
+		s.mu.RUnlock()
+		if !known {
+			s.refreshTask(id)
+		}
+	}
+
+	s.mu.RLock()
+	now := time.Now()
+	var orphaned, stray []string
+	for _, task := range s.tasks {
+		if task.AgentID == agentID && task.Status == "running" && !listed[task.ID] &&
+			now.Sub(task.DispatchedAt) > orphanedTaskGrace {
+			orphaned = append(orphaned, task.ID)
+		}
+	}
+	for _, id := range reported {
+		task, ok := s.tasks[id]
+		if !ok || task.AgentID != agentID {
+			stray = append(stray, id)
+			continue
+		}
+		switch task.Status {
+		case "pending", "retrying", "expired", "cancelled":
+			stray = append(stray, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, id := range orphaned {
+		s.logger.Errorf("Task %s is running but agent %s no longer reports it", id, agentID)
+		s.MarkTaskDone(id, false, "", "agent is no longer running the task")
+	}
+	if s.registry != nil {
+		s.registry.setStrayTasks(agentID, stray)
+	}
+}
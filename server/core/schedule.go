@@ -0,0 +1,405 @@
+// Package core provides recurring task schedules materialized by the scheduler.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nerve/server/pkg/storage"
+)
+
+const (
+	// minScheduleInterval is the shortest interval a schedule may run at
+	minScheduleInterval = time.Minute
+
+	// scheduleTickInterval is how often due schedules are checked
+	scheduleTickInterval = 10 * time.Second
+
+	// scheduleKeyPrefix prefixes the storage keys of schedules
+	scheduleKeyPrefix = "schedule:"
+)
+
+// TaskTemplate describes the task created for each target agent
+type TaskTemplate struct {
+	Type    string                 `json:"type"`
+	Content string                 `json:"content"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+	Timeout int                    `json:"timeout,omitempty"`
+	Retry   *RetryPolicy           `json:"retry,omitempty"`
+}
+
+// Validate checks the template describes a runnable task
+func (t *TaskTemplate) Validate() error {
+	switch t.Type {
+	case "command", "script", "hook":
+	case "benchmark":
+		if err := ValidateBenchmark(t.Content); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("task type must be one of: command, script, hook, benchmark")
+	}
+	if t.Content == "" {
+		return fmt.Errorf("task content is required")
+	}
+	if t.Retry != nil {
+		return t.Retry.Validate()
+	}
+	return nil
+}
+
+// NewTask renders the template into a task for an agent
+func (t *TaskTemplate) NewTask(agentID string) *Task {
+	task := &Task{
+		AgentID: agentID,
+		Type:    t.Type,
+		Params:  t.Params,
+		Timeout: t.Timeout,
+	}
+
+	switch t.Type {
+	case "command":
+		task.Command = t.Content
+	case "script":
+		task.Script = t.Content
+	case "hook":
+		task.Plugin = t.Content
+	case "benchmark":
+		task.Params = benchmarkParams(t.Content, t.Params)
+	}
+
+	if t.Retry != nil {
+		// Each task gets its own copy of the policy
+		retry := *t.Retry
+		task.Retry = &retry
+	}
+	return task
+}
+
+// Schedule creates a task for each target agent on a cron expression or a
+// fixed interval. Targets are either AgentIDs or a Selector resolved at each run.
+type Schedule struct {
+	ID        string         `json:"id"`
+	Name      string         `json:"name"`
+	Cron      string         `json:"cron,omitempty"`
+	Interval  string         `json:"interval,omitempty"`
+	AgentIDs  []string       `json:"agent_ids,omitempty"`
+	Selector  *AgentSelector `json:"selector,omitempty"`
+	Task      TaskTemplate   `json:"task"`
+	Paused    bool           `json:"paused,omitempty"`
+	LastRunAt time.Time      `json:"last_run_at,omitempty"`
+	NextRunAt time.Time      `json:"next_run_at,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Validate checks the schedule timing, targets and task template
+func (sc *Schedule) Validate() error {
+	if sc.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if (sc.Cron == "") == (sc.Interval == "") {
+		return fmt.Errorf("specify either cron or interval")
+	}
+	if sc.Cron != "" {
+		if _, err := ParseCron(sc.Cron); err != nil {
+			return err
+		}
+	} else {
+		interval, err := time.ParseDuration(sc.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval: %v", err)
+		}
+		if interval < minScheduleInterval {
+			return fmt.Errorf("interval must be at least %v", minScheduleInterval)
+		}
+	}
+	if (len(sc.AgentIDs) > 0) == (sc.Selector != nil) {
+		return fmt.Errorf("specify either agent_ids or selector")
+	}
+	return sc.Task.Validate()
+}
+
+// next returns the first run time after t
+func (sc *Schedule) next(t time.Time) time.Time {
+	if sc.Cron != "" {
+		cron, err := ParseCron(sc.Cron)
+		if err != nil {
+			return time.Time{}
+		}
+		return cron.Next(t)
+	}
+	interval, err := time.ParseDuration(sc.Interval)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Add(interval)
+}
+
+// SetClusterResolver sets the lookup used to expand cluster selectors of schedules
+func (s *Scheduler) SetClusterResolver(members ClusterMembers) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clusterMembers = members
+}
+
+// AddSchedule validates, stores and activates a schedule
+func (s *Scheduler) AddSchedule(schedule *Schedule) error {
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if schedule.ID == "" {
+		schedule.ID = fmt.Sprintf("sched-%d", now.UnixNano())
+	}
+	if _, exists := s.schedules[schedule.ID]; exists {
+		return fmt.Errorf("schedule %s already exists", schedule.ID)
+	}
+
+	schedule.CreatedAt = now
+	schedule.UpdatedAt = now
+	schedule.LastRunAt = time.Time{}
+	schedule.NextRunAt = schedule.next(now)
+	if err := s.saveScheduleLocked(schedule); err != nil {
+		return err
+	}
+	s.schedules[schedule.ID] = schedule
+
+	s.logger.Infof("Schedule added: ID=%s, Name=%s, NextRun=%s", schedule.ID, schedule.Name, schedule.NextRunAt.Format(time.RFC3339))
+	return nil
+}
+
+// GetSchedule retrieves a schedule by ID
+func (s *Scheduler) GetSchedule(id string) (*Schedule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedule, exists := s.schedules[id]
+	if !exists {
+		return nil, fmt.Errorf("schedule %s not found", id)
+	}
+	return schedule, nil
+}
+
+// ListSchedules returns all schedules
+func (s *Scheduler) ListSchedules() []*Schedule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	schedules := make([]*Schedule, 0, len(s.schedules))
+	for _, schedule := range s.schedules {
+		schedules = append(schedules, schedule)
+	}
+	return schedules
+}
+
+// UpdateSchedule replaces the definition of a schedule, keeping its run history
+func (s *Scheduler) UpdateSchedule(id string, updated *Schedule) error {
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.schedules[id]
+	if !exists {
+		return fmt.Errorf("schedule %s not found", id)
+	}
+
+	now := time.Now()
+	updated.ID = id
+	updated.CreatedAt = existing.CreatedAt
+	updated.LastRunAt = existing.LastRunAt
+	updated.UpdatedAt = now
+	updated.NextRunAt = updated.next(now)
+	if err := s.saveScheduleLocked(updated); err != nil {
+		return err
+	}
+	*existing = *updated
+
+	return nil
+}
+
+// DeleteSchedule removes a schedule; tasks it already created are kept
+func (s *Scheduler) DeleteSchedule(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.schedules[id]; !exists {
+		return fmt.Errorf("schedule %s not found", id)
+	}
+
+	if store := s.store(); store != nil {
+		if err := store.Delete(scheduleKeyPrefix + id); err != nil {
+			return fmt.Errorf("failed to delete schedule: %v", err)
+		}
+	}
+	delete(s.schedules, id)
+
+	return nil
+}
+
+// saveScheduleLocked persists a schedule; caller must hold s.mu
+func (s *Scheduler) saveScheduleLocked(schedule *Schedule) error {
+	store := s.store()
+	if store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return err
+	}
+	if err := store.Set(scheduleKeyPrefix+schedule.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to persist schedule: %v", err)
+	}
+	return nil
+}
+
+// loadSchedules restores persisted schedules. Runs missed while the server
+// was down are not replayed; each schedule resumes at its next run time.
+func (s *Scheduler) loadSchedules() {
+	store := s.store()
+	if store == nil {
+		return
+	}
+
+	now := time.Now()
+	for key, value := range store.List() {
+		if !strings.HasPrefix(key, scheduleKeyPrefix) {
+			continue
+		}
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var schedule Schedule
+		if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+			s.logger.Errorf("Invalid stored schedule %s: %v", key, err)
+			continue
+		}
+		if schedule.NextRunAt.Before(now) {
+			schedule.NextRunAt = schedule.next(now)
+		}
+		s.schedules[schedule.ID] = &schedule
+	}
+
+	if len(s.schedules) > 0 {
+		s.logger.Infof("Loaded %d schedules", len(s.schedules))
+	}
+}
+
+// store returns the storage backend of the registry, if any
+func (s *Scheduler) store() storage.Storage {
+	if s.registry == nil {
+		return nil
+	}
+	return s.registry.store
+}
+
+// runSchedules materializes the tasks of due schedules. Servers sharing
+// tasks reread the schedules each tick and take turns running them.
+func (s *Scheduler) runSchedules() {
+	ticker := time.NewTicker(scheduleTickInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		bus := s.sharedBus()
+		release, turn := sharedTurn(s.store(), bus, schedulesLockName)
+
+		s.mu.Lock()
+		if bus != nil {
+			// Other servers may have changed the schedules or run them
+			if err := s.reloadSchedulesLocked(); err != nil {
+				s.logger.Errorf("Failed to reload schedules: %v", err)
+			}
+		}
+		var due []Schedule
+		for _, schedule := range s.schedules {
+			if !turn || schedule.Paused || schedule.NextRunAt.IsZero() || now.Before(schedule.NextRunAt) {
+				continue
+			}
+			schedule.LastRunAt = now
+			schedule.NextRunAt = schedule.next(now)
+			if err := s.saveScheduleLocked(schedule); err != nil {
+				s.logger.Errorf("Schedule %s: %v", schedule.ID, err)
+			}
+			due = append(due, *schedule)
+		}
+		members := s.clusterMembers
+		s.mu.Unlock()
+
+		for i := range due {
+			s.runSchedule(&due[i], members)
+		}
+		release()
+	}
+}
+
+// runSchedule submits a task for each target of a schedule, skipping agents
+// whose task from the previous run is still waiting to be picked up and
+// agents whose tasks are held back by their status
+func (s *Scheduler) runSchedule(schedule *Schedule, members ClusterMembers) {
+	agentIDs := schedule.AgentIDs
+	if schedule.Selector != nil {
+		if s.registry == nil {
+			return
+		}
+		ids, err := s.registry.SelectAgents(schedule.Selector, members)
+		if err != nil {
+			s.logger.Errorf("Schedule %s: failed to resolve targets: %v", schedule.ID, err)
+			return
+		}
+		agentIDs = ids
+	}
+
+	submitted := 0
+	batchID := NewBatchID()
+	for _, agentID := range agentIDs {
+		if s.hasOutstandingTask(schedule.ID, agentID) {
+			s.logger.Infof("Schedule %s: skipping %s, previous run still pending", schedule.ID, agentID)
+			continue
+		}
+		if s.registry != nil {
+			if status := s.registry.HeldStatus(agentID); status != "" {
+				s.logger.Infof("Schedule %s: skipping %s, agent in %s", schedule.ID, agentID, status)
+				continue
+			}
+		}
+
+		task := schedule.Task.NewTask(agentID)
+		task.ScheduleID = schedule.ID
+		task.BatchID = batchID
+		s.SubmitTask(task)
+		submitted++
+	}
+
+	s.logger.Infof("Schedule %s ran: %d of %d targets submitted", schedule.ID, submitted, len(agentIDs))
+}
+
+// hasOutstandingTask reports whether a task from the schedule is still
+// waiting to be picked up by the agent
+func (s *Scheduler) hasOutstandingTask(scheduleID, agentID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, task := range s.tasks {
+		if task.ScheduleID == scheduleID && task.AgentID == agentID &&
+			(task.Status == "pending" || task.Status == "retrying") {
+			return true
+		}
+	}
+	return false
+}
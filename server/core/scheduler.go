@@ -1,10 +1,23 @@
 package core
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"sync"
 	"time"
 
 	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/sharedstate"
+	"github.com/nerve/server/pkg/storage"
+)
+
+const (
+	// DefaultTaskExpiry is how long a task may stay pending or running, on top
+	// of its own timeout, before it is marked expired
+	DefaultTaskExpiry = time.Hour
+
+	// taskSweepInterval is how often expired tasks are swept
+	taskSweepInterval = 30 * time.Second
 )
 
 // Scheduler manages task scheduling
@@ -13,15 +26,91 @@ type Scheduler struct {
 	registry *Registry
 	logger   log.Logger
 	tasks    map[string]*Task
+	watchers map[string][]chan struct{}
+
+	// Callbacks for expired and finished tasks, see OnExpired
+	expiredHandlers  []func(task *Task)
+	finishedHandlers []func(task *Task)
+
+	// Recurring task schedules, see AddSchedule
+	schedules      map[string]*Schedule
+	clusterMembers ClusterMembers
+
+	// Key dispatched tasks are signed with, see SetTaskSigner
+	signer *TaskSigner
+
+	// Tasks created per idempotency key, see SubmitIdempotent
+	idempotency    map[string]*idempotencyRecord
+	idempotencyTTL time.Duration
+
+	// Output cap and retention of task results, see SetTaskResultPolicy
+	resultPolicy storage.TaskResultConfig
+
+	// Tasks shared with other servers through the storage, and when each
+	// was last read, see EnableSharedState
+	bus         *sharedstate.Bus
+	cacheTTL    time.Duration
+	taskLoaded  map[string]time.Time
+	tasksListed time.Time
 }
 
 // NewScheduler creates a new scheduler
 func NewScheduler(registry *Registry, logger log.Logger) *Scheduler {
-	return &Scheduler{
+	scheduler := &Scheduler{
 		registry: registry,
 		logger:   logger,
 		tasks:    make(map[string]*Task),
+		watchers: make(map[string][]chan struct{}),
+
+		schedules: make(map[string]*Schedule),
+
+		idempotency:    make(map[string]*idempotencyRecord),
+		idempotencyTTL: DefaultIdempotencyTTL,
+
+		resultPolicy: storage.DefaultTaskResultConfig(),
+	}
+	scheduler.loadSchedules()
+
+	// Tasks held while an agent was in maintenance go out once it is back
+	// online, and the tasks agents report are checked against those dispatched
+	if registry != nil {
+		registry.OnOnline(scheduler.wakeAgent)
+		registry.OnRunningTasks(scheduler.reconcileTasks)
+	}
+
+	// Start expiry sweeper and recurring schedules
+	go scheduler.sweepExpiredTasks()
+	go scheduler.runSchedules()
+
+	return scheduler
+}
+
+// Watch returns a channel that is signalled whenever a task is submitted for
+// the agent, and a function that stops watching
+func (s *Scheduler) Watch(agentID string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	s.mu.Lock()
+	s.watchers[agentID] = append(s.watchers[agentID], ch)
+	s.mu.Unlock()
+
+	cancel := func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		watchers := s.watchers[agentID]
+		for i, watcher := range watchers {
+			if watcher == ch {
+				s.watchers[agentID] = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(s.watchers[agentID]) == 0 {
+			delete(s.watchers, agentID)
+		}
 	}
+
+	return ch, cancel
 }
 
 // SubmitTask submits a task for execution
@@ -29,21 +118,51 @@ func (s *Scheduler) SubmitTask(task *Task) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	s.submitLocked(task)
+}
+
+// submitLocked submits a task; caller must hold s.mu
+func (s *Scheduler) submitLocked(task *Task) {
+	if task.ID == "" {
+		task.ID = generateTaskID()
+	}
 	task.Status = "pending"
+	task.CreatedAt = time.Now()
+	task.UpdatedAt = task.CreatedAt
+	if task.ExpiresAt.IsZero() {
+		task.ExpiresAt = task.CreatedAt.Add(DefaultTaskExpiry + time.Duration(task.Timeout)*time.Second)
+	}
+	task.expiry = task.ExpiresAt.Sub(task.CreatedAt)
+	task.Attempt = 1
 	s.tasks[task.ID] = task
-	
-	s.logger.Infof("Task submitted: ID=%s, AgentID=%s, Type=%s", 
+	s.saveTaskLocked(task)
+
+	s.wakeWatchersLocked(task.AgentID)
+
+	log.WithRequestID(s.logger, task.RequestID).Infof("Task submitted: ID=%s, AgentID=%s, Type=%s", 
 		task.ID, task.AgentID, task.Type)
 }
 
-// GetPendingTasks returns pending tasks for an agent
+// wakeWatchersLocked signals streaming agents that a task is pending; caller must hold s.mu
+func (s *Scheduler) wakeWatchersLocked(agentID string) {
+	// A pending signal already covers new tasks
+	for _, watcher := range s.watchers[agentID] {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// GetPendingTasks returns pending, unexpired tasks for an agent
 func (s *Scheduler) GetPendingTasks(agentID string) []*Task {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	now := time.Now()
 	var tasks []*Task
 	for _, task := range s.tasks {
-		if task.AgentID == agentID && task.Status == "pending" {
+		if task.AgentID == agentID && task.Status == "pending" && !task.expired(now) {
 			tasks = append(tasks, task)
 		}
 	}
@@ -51,22 +170,169 @@ func (s *Scheduler) GetPendingTasks(agentID string) []*Task {
 	return tasks
 }
 
-// MarkTaskDone marks a task as completed
+// DispatchTasks returns pending tasks for an agent and marks them as running.
+// Tasks that expired before the agent picked them up are never handed out,
+// and tasks the agent lacks the capability for are failed instead. A
+// benchmark stays pending while another one runs on the agent, and all tasks
+// stay pending while the agent is in maintenance or error status.
+func (s *Scheduler) DispatchTasks(agentID string) []*Task {
+	// Capabilities are nil for agents the registry doesn't know
+	var capabilities []string
+	held := ""
+	if s.registry != nil {
+		capabilities = s.registry.Capabilities(agentID)
+		held = s.registry.HeldStatus(agentID)
+	}
+
+	s.mu.Lock()
+
+	now := time.Now()
+	tasks := []*Task{}
+	var expired, unsupported []*Task
+	// One benchmark at a time per host, so they don't skew each other
+	benchmarking := s.benchmarkRunningLocked(agentID)
+	for _, task := range s.tasks {
+		if task.AgentID != agentID || task.Status != "pending" {
+			continue
+		}
+		if task.expired(now) {
+			if !s.expireLocked(task, now) {
+				expired = append(expired, task)
+			}
+			continue
+		}
+		if held != "" {
+			continue
+		}
+		if capabilities != nil && !hasCapability(capabilities, task.Type) {
+			s.failUnsupportedLocked(task, now)
+			unsupported = append(unsupported, task)
+			continue
+		}
+		if task.Type == "benchmark" && benchmarking {
+			continue
+		}
+		if !s.dispatchLocked(task, now) {
+			continue
+		}
+		benchmarking = benchmarking || task.Type == "benchmark"
+		tasks = append(tasks, task)
+	}
+	s.mu.Unlock()
+
+	s.notifyExpired(expired)
+	s.notifyFinished(unsupported)
+	return tasks
+}
+
+// dispatchLocked marks a pending task running for the agent to pick up.
+// With shared state the task is claimed first, and reports false if another
+// server dispatched it; caller must hold s.mu.
+func (s *Scheduler) dispatchLocked(task *Task, now time.Time) bool {
+	release, ok := s.claimLocked(task)
+	if !ok {
+		return false
+	}
+	defer release()
+
+	task.Status = "running"
+	task.UpdatedAt = now
+	task.DispatchedAt = now
+	s.signLocked(task)
+	s.saveTaskLocked(task)
+	return true
+}
+
+// GetTask retrieves a task by ID
+func (s *Scheduler) GetTask(taskID string) *Task {
+	s.refreshTask(taskID)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.tasks[taskID]
+}
+
+// ListTasks returns all tasks
+func (s *Scheduler) ListTasks() []*Task {
+	s.refreshTasks()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tasks := make([]*Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+
+	return tasks
+}
+
+// CancelTask cancels a task that has not been dispatched yet, or is waiting to be retried
+func (s *Scheduler) CancelTask(taskID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[taskID]
+	if !ok || (task.Status != "pending" && task.Status != "retrying") {
+		return false
+	}
+
+	task.Status = "cancelled"
+	task.UpdatedAt = time.Now()
+	s.saveTaskLocked(task)
+	return true
+}
+
+// MarkTaskDone marks a task as completed, or failed unless its retry
+// policy re-queues it. The output of a finished task is kept, truncated to
+// the output cap.
 func (s *Scheduler) MarkTaskDone(taskID string, success bool, output string, errMsg string) {
+	task, result := s.markTaskDone(taskID, success, output, errMsg)
+	if task == nil {
+		return
+	}
+	s.saveTaskResult(result)
+	s.notifyFinished([]*Task{task})
+}
+
+// markTaskDone applies a task result and returns the task and the result to
+// persist if it finished for good, rather than being ignored or retried
+func (s *Scheduler) markTaskDone(taskID string, success bool, output, errMsg string) (*Task, *storage.TaskResultRecord) {
+	// The task may have been dispatched by another server
+	s.refreshTask(taskID)
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	task, ok := s.tasks[taskID]
 	if !ok {
-		return
+		return nil, nil
+	}
+	logger := log.WithRequestID(s.logger, task.RequestID)
+	if task.Status != "running" {
+		// Expired, re-queued or already reported
+		logger.Infof("Ignoring result for task %s in status %s", taskID, task.Status)
+		return nil, nil
 	}
 
-	task.Status = "completed"
+	now := time.Now()
 	if success {
-		s.logger.Infof("Task completed: %s", taskID)
-	} else {
-		s.logger.Errorf("Task failed: %s - %s", taskID, errMsg)
+		task.Status = "completed"
+		s.recordAttemptLocked(task, "completed", "", now)
+		logger.Infof("Task completed: %s", taskID)
+		result := s.recordOutputLocked(task, success, output, errMsg, now)
+		s.saveTaskLocked(task)
+		return task, result
+	}
+
+	s.recordAttemptLocked(task, "failed", errMsg, now)
+	logger.Errorf("Task failed: %s - %s", taskID, errMsg)
+	if s.retryLocked(task, now) {
+		return nil, nil
 	}
+	task.Status = "failed"
+	task.UpdatedAt = now
+	result := s.recordOutputLocked(task, success, output, errMsg, now)
+	s.saveTaskLocked(task)
+	return task, result
 }
 
 // GetTasksByStatus returns tasks filtered by status
@@ -84,6 +350,134 @@ func (s *Scheduler) GetTasksByStatus(status string) []*Task {
 	return tasks
 }
 
+// CountByStatus returns the number of tasks in each status
+func (s *Scheduler) CountByStatus() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[string]int{
+		"pending":   0,
+		"running":   0,
+		"completed": 0,
+		"failed":    0,
+		"expired":   0,
+		"retrying":  0,
+	}
+	for _, task := range s.tasks {
+		counts[task.Status]++
+	}
+
+	return counts
+}
+
+// OnExpired registers a callback invoked when a task expires
+func (s *Scheduler) OnExpired(handler func(task *Task)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expiredHandlers = append(s.expiredHandlers, handler)
+}
+
+// OnFinished registers a callback invoked when a task completes, or fails
+// with no retries left
+func (s *Scheduler) OnFinished(handler func(task *Task)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.finishedHandlers = append(s.finishedHandlers, handler)
+}
+
+// expired reports whether a pending or running task is past its expiry
+func (t *Task) expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// expireLocked ends the current attempt of a task past its expiry and
+// reports whether the task was re-queued rather than marked expired; caller
+// must hold s.mu
+func (s *Scheduler) expireLocked(task *Task, now time.Time) bool {
+	log.WithRequestID(s.logger, task.RequestID).Infof("Task expired: ID=%s, AgentID=%s, Status=%s",
+		task.ID, task.AgentID, task.Status)
+
+	reason := "not picked up before expiry"
+	if task.Status == "running" {
+		reason = "no result before expiry"
+	}
+	s.recordAttemptLocked(task, "expired", reason, now)
+	if s.retryLocked(task, now) {
+		return true
+	}
+
+	task.Status = "expired"
+	task.UpdatedAt = now
+	s.saveTaskLocked(task)
+	return false
+}
+
+// notifyExpired invokes the expiry handlers; must be called without s.mu held
+func (s *Scheduler) notifyExpired(tasks []*Task) {
+	if len(tasks) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	handlers := s.expiredHandlers
+	s.mu.RUnlock()
+
+	for _, task := range tasks {
+		for _, handler := range handlers {
+			handler(task)
+		}
+	}
+}
+
+// notifyFinished invokes the finished handlers; must be called without s.mu held
+func (s *Scheduler) notifyFinished(tasks []*Task) {
+	if len(tasks) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	handlers := s.finishedHandlers
+	s.mu.RUnlock()
+
+	for _, task := range tasks {
+		for _, handler := range handlers {
+			handler(task)
+		}
+	}
+}
+
+// sweepExpiredTasks periodically expires pending and running tasks whose
+// agent never picked them up or never reported a result, and forgets expired
+// idempotency keys and tasks past the result retention. Servers sharing
+// tasks take turns expiring them and removing them from storage.
+func (s *Scheduler) sweepExpiredTasks() {
+	ticker := time.NewTicker(taskSweepInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		release, turn := sharedTurn(s.store(), s.sharedBus(), taskSweepLockName)
+
+		s.mu.Lock()
+		now := time.Now()
+		var expired []*Task
+		for _, task := range s.tasks {
+			if turn && (task.Status == "pending" || task.Status == "running") && task.expired(now) {
+				if !s.expireLocked(task, now) {
+					expired = append(expired, task)
+				}
+			}
+		}
+		s.sweepIdempotencyKeysLocked(now)
+		s.pruneFinishedTasksLocked(now, turn)
+		s.mu.Unlock()
+		release()
+
+		s.notifyExpired(expired)
+	}
+}
+
 // ScheduleHook schedules a hook execution
 func (s *Scheduler) ScheduleHook(agentID, plugin string, params map[string]interface{}) {
 	task := &Task{
@@ -112,7 +506,37 @@ func (s *Scheduler) ScheduleCommand(agentID, command string, timeout int) {
 	s.SubmitTask(task)
 }
 
+// ScheduleUpdate schedules an agent self-update to the given binary version.
+// The agent verifies the download against checksum, or without it, the
+// checksum of its platform in checksums, keyed by "<platform>/<arch>".
+func (s *Scheduler) ScheduleUpdate(agentID, version, checksum string, checksums map[string]string, requestID string) *Task {
+	params := map[string]interface{}{"version": version}
+	if checksum != "" {
+		params["checksum"] = checksum
+	} else {
+		platforms := make(map[string]interface{}, len(checksums))
+		for platform, sum := range checksums {
+			platforms[platform] = sum
+		}
+		params["checksums"] = platforms
+	}
+
+	task := &Task{
+		ID:        generateTaskID(),
+		AgentID:   agentID,
+		Type:      "update",
+		Params:    params,
+		Status:    "pending",
+		RequestID: requestID,
+	}
+
+	s.SubmitTask(task)
+	return task
+}
+
 func generateTaskID() string {
-	return time.Now().Format("20060102150405")
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().Format("20060102150405") + "-" + hex.EncodeToString(suffix)
 }
 
@@ -0,0 +1,75 @@
+// Package core provides selectors that match registered agents by attribute.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"sort"
+)
+
+// AgentSelector matches registered agents by attribute; empty fields match any agent
+type AgentSelector struct {
+	Status  string `json:"status,omitempty"`
+	Cluster string `json:"cluster,omitempty"`
+	GPUType string `json:"gpu_type,omitempty"`
+}
+
+// ParseAgentSelector builds a selector from labels keyed by the selector's
+// JSON field names, e.g. {"cluster": "prod", "gpu_type": "A100"}
+func ParseAgentSelector(labels map[string]string) (*AgentSelector, error) {
+	selector := &AgentSelector{}
+	for key, value := range labels {
+		switch key {
+		case "status":
+			selector.Status = value
+		case "cluster":
+			selector.Cluster = value
+		case "gpu_type":
+			selector.GPUType = value
+		default:
+			return nil, fmt.Errorf("unknown selector label %q", key)
+		}
+	}
+	return selector, nil
+}
+
+// ClusterMembers returns the agent IDs of a cluster
+type ClusterMembers func(clusterID string) ([]string, error)
+
+// SelectAgents returns the sorted IDs of registered agents matching the
+// selector. members resolves the selector's cluster, if any.
+func (r *Registry) SelectAgents(selector *AgentSelector, members ClusterMembers) ([]string, error) {
+	var inCluster map[string]bool
+	if selector.Cluster != "" {
+		if members == nil {
+			return nil, fmt.Errorf("cluster %s not found", selector.Cluster)
+		}
+		ids, err := members(selector.Cluster)
+		if err != nil {
+			return nil, err
+		}
+		inCluster = make(map[string]bool, len(ids))
+		for _, id := range ids {
+			inCluster[id] = true
+		}
+	}
+
+	var agentIDs []string
+	for _, agent := range r.List() {
+		if selector.Status != "" && agent.Status != selector.Status {
+			continue
+		}
+		if selector.GPUType != "" && agent.GPUType != selector.GPUType {
+			continue
+		}
+		if inCluster != nil && !inCluster[agent.ID] {
+			continue
+		}
+		agentIDs = append(agentIDs, agent.ID)
+	}
+	sort.Strings(agentIDs)
+
+	return agentIDs, nil
+}
@@ -0,0 +1,149 @@
+// Package core provides the BMC sensors agents report in heartbeat metrics:
+// power supplies, fans and temperatures, with alerting on status changes.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+// Sensor types and statuses agents report
+const (
+	SensorPowerSupply = "power_supply"
+	SensorFan         = "fan"
+	SensorTemperature = "temperature"
+
+	SensorOK       = "ok"
+	SensorWarning  = "warning"
+	SensorCritical = "critical"
+	SensorAbsent   = "absent"
+)
+
+// maxSensors caps the sensors kept from a heartbeat
+const maxSensors = 256
+
+// Sensor is a BMC sensor reading from heartbeat metrics. Value is set for
+// numeric readings such as fan RPM or degrees C; Detail holds a discrete
+// state, e.g. of a power supply.
+type Sensor struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	Status string   `json:"status"`
+	Value  *float64 `json:"value,omitempty"`
+	Unit   string   `json:"unit,omitempty"`
+	Detail string   `json:"detail,omitempty"`
+}
+
+// parseSensors reads the sensor list of heartbeat metrics, skipping
+// malformed entries
+func parseSensors(value interface{}) []Sensor {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var sensors []Sensor
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok || len(sensors) == maxSensors {
+			continue
+		}
+		sensor := Sensor{}
+		sensor.Name, _ = fields["name"].(string)
+		sensor.Type, _ = fields["type"].(string)
+		sensor.Status, _ = fields["status"].(string)
+		sensor.Unit, _ = fields["unit"].(string)
+		sensor.Detail, _ = fields["detail"].(string)
+		if v, ok := fields["value"].(float64); ok {
+			sensor.Value = &v
+		}
+		valid := sensor.Name != ""
+		for _, str := range []string{sensor.Name, sensor.Type, sensor.Status, sensor.Unit, sensor.Detail} {
+			valid = valid && validateString(str, maxFieldLength) == nil
+		}
+		if valid {
+			sensors = append(sensors, sensor)
+		}
+	}
+	return sensors
+}
+
+// sensorsChanged reports whether a heartbeat's sensors differ in status
+// from the previous report. Heartbeats without sensors change nothing.
+func sensorsChanged(before *AgentUsage, sensors []Sensor) bool {
+	if len(sensors) == 0 {
+		return false
+	}
+	if before == nil || len(before.Sensors) != len(sensors) {
+		return true
+	}
+	statuses := make(map[string]string, len(before.Sensors))
+	for _, sensor := range before.Sensors {
+		statuses[sensor.Name] = sensor.Status
+	}
+	for _, sensor := range sensors {
+		if status, ok := statuses[sensor.Name]; !ok || status != sensor.Status {
+			return true
+		}
+	}
+	return false
+}
+
+// OnSensorChange registers a callback invoked when a heartbeat reports BMC
+// sensors whose statuses differ from the previous report
+func (r *Registry) OnSensorChange(handler func(agentID string, sensors []Sensor)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sensorHandlers = append(r.sensorHandlers, handler)
+}
+
+// notifySensorChange invokes the sensor change handlers; must be called without r.mu held
+func (r *Registry) notifySensorChange(agentID string, sensors []Sensor) {
+	r.mu.RLock()
+	handlers := r.sensorHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, sensors)
+	}
+}
+
+// SensorAlertData returns an agent's BMC sensors as alert rule input,
+// matchable with conditions on event, the sensors_critical and
+// sensors_warning counts, psu_failed and fans_failed (power supplies and
+// fans not ok), max_temperature, and sensors.<name> holding each sensor's
+// status, e.g. psu_failed > 0 for lost power supply redundancy
+func SensorAlertData(sensors []Sensor) map[string]interface{} {
+	data := map[string]interface{}{"event": "ipmi_sensors"}
+	counts := map[string]int{}
+	statuses := make(map[string]interface{}, len(sensors))
+	psuFailed, fansFailed := 0, 0
+	var maxTemperature *float64
+	for _, sensor := range sensors {
+		counts[sensor.Status]++
+		statuses[sensor.Name] = sensor.Status
+		failed := sensor.Status == SensorWarning || sensor.Status == SensorCritical
+		switch sensor.Type {
+		case SensorPowerSupply:
+			if failed {
+				psuFailed++
+			}
+		case SensorFan:
+			if failed {
+				fansFailed++
+			}
+		case SensorTemperature:
+			if sensor.Value != nil && (maxTemperature == nil || *sensor.Value > *maxTemperature) {
+				maxTemperature = sensor.Value
+			}
+		}
+	}
+	data["sensors_critical"] = counts[SensorCritical]
+	data["sensors_warning"] = counts[SensorWarning]
+	data["psu_failed"] = psuFailed
+	data["fans_failed"] = fansFailed
+	data["sensors"] = statuses
+	if maxTemperature != nil {
+		data["max_temperature"] = *maxTemperature
+	}
+	return data
+}
@@ -0,0 +1,628 @@
+// Package core provides the agent records and tasks servers share through
+// their common storage, so agents and operators may reach any server behind
+// a load balancer.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nerve/server/pkg/sharedstate"
+	"github.com/nerve/server/pkg/storage"
+)
+
+const (
+	// DefaultSharedCacheTTL is how long a server serves its cached copy of a
+	// shared agent record or task before rereading it from storage
+	DefaultSharedCacheTTL = 30 * time.Second
+
+	// agentRecordKeyPrefix and taskKeyPrefix prefix the storage keys of
+	// shared agent records and tasks
+	agentRecordKeyPrefix = "agent_record:"
+	taskKeyPrefix        = "task:"
+
+	// sharedFlushInterval is how often changed agent records are written
+	// when nothing asks for them to be written at once
+	sharedFlushInterval = time.Second
+
+	// Storage locks letting one server at a time run each periodic job
+	staleLockName     = "stale-agents"
+	taskSweepLockName = "task-sweep"
+	schedulesLockName = "schedules"
+
+	// sharedJobLockTTL frees the lock of a periodic job whose server died
+	sharedJobLockTTL = time.Minute
+
+	// taskClaimLockTTL frees the lock of a task being dispatched by a
+	// server that died meanwhile
+	taskClaimLockTTL = 10 * time.Second
+)
+
+// storedTask is a task in storage, with the time allowed per attempt
+type storedTask struct {
+	*Task
+	Expiry time.Duration `json:"expiry"`
+}
+
+// agentRecordKey is the storage key of a shared agent record
+func agentRecordKey(agentID string) string {
+	return agentRecordKeyPrefix + agentID
+}
+
+// taskKey is the storage key of a shared task
+func taskKey(taskID string) string {
+	return taskKeyPrefix + taskID
+}
+
+// EnableSharedState shares agent records with the other servers using the
+// registry's storage, telling them about changes over the bus. Changed
+// records are written within a second, status changes at once; heartbeats
+// that change nothing but the last contact only every quarter of the offline
+// threshold. Lookups reread a record from storage once the cached copy is
+// older than cacheTTL, on every lookup if it is 0. Only one server at a time
+// marks agents offline. Must be called before the bus is started.
+func (r *Registry) EnableSharedState(bus *sharedstate.Bus, cacheTTL time.Duration) error {
+	if r.store == nil {
+		return fmt.Errorf("shared state needs a storage")
+	}
+	if cacheTTL < 0 {
+		return fmt.Errorf("shared cache TTL must not be negative")
+	}
+
+	records, err := storage.ListPrefix(r.store, agentRecordKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to load shared agent records: %v", err)
+	}
+
+	r.mu.Lock()
+	r.bus = bus
+	r.cacheTTL = cacheTTL
+	r.sharedDirty = make(map[string]bool)
+	r.sharedWriting = make(map[string]bool)
+	r.sharedSaved = make(map[string]time.Time)
+	r.sharedLoaded = make(map[string]time.Time)
+	r.sharedFlush = make(chan struct{}, 1)
+	r.installAgentsLocked(records, time.Now())
+	loaded := len(r.agents)
+	r.mu.Unlock()
+
+	bus.Handle(sharedstate.KindAgent, func(msg sharedstate.Message) {
+		r.reloadAgent(msg.Key)
+	})
+	bus.Handle(sharedstate.KindRetiredToken, func(msg sharedstate.Message) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		r.retiredTokens[msg.Key] = true
+	})
+
+	go r.flushSharedAgents()
+
+	r.logger.Infof("Sharing agent records as %s, loaded %d", bus.InstanceID(), loaded)
+	return nil
+}
+
+// sharedChangedLocked marks an agent record changed and has it written at
+// once; caller must hold r.mu
+func (r *Registry) sharedChangedLocked(id string) {
+	if r.bus == nil {
+		return
+	}
+	r.sharedDirty[id] = true
+
+	select {
+	case r.sharedFlush <- struct{}{}:
+	default:
+	}
+}
+
+// sharedContactLocked marks an agent record changed by a heartbeat or
+// control channel ping, unless it was written recently enough for the other
+// servers to keep the agent online; caller must hold r.mu
+func (r *Registry) sharedContactLocked(id string) {
+	if r.bus == nil {
+		return
+	}
+	if time.Since(r.sharedSaved[id]) >= r.offlineThresholdLocked()/4 {
+		r.sharedDirty[id] = true
+	}
+}
+
+// flushSharedAgents writes changed agent records every flush interval, or
+// as soon as one must be written at once
+func (r *Registry) flushSharedAgents() {
+	ticker := time.NewTicker(sharedFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.sharedFlush:
+		}
+		r.writeSharedAgents()
+	}
+}
+
+// writeSharedAgents writes or deletes the changed agent records and tells
+// the other servers. Records that fail to be written are retried on the
+// next flush.
+func (r *Registry) writeSharedAgents() {
+	r.mu.Lock()
+	if len(r.sharedDirty) == 0 {
+		r.mu.Unlock()
+		return
+	}
+
+	// An empty value deletes the record of a removed agent
+	writes := make(map[string]string, len(r.sharedDirty))
+	for id := range r.sharedDirty {
+		if agent, ok := r.agents[id]; ok {
+			data, err := json.Marshal(agent)
+			if err != nil {
+				r.logger.Errorf("Failed to encode agent %s: %v", id, err)
+				continue
+			}
+			writes[id] = string(data)
+		} else {
+			writes[id] = ""
+		}
+		r.sharedWriting[id] = true
+	}
+	r.sharedDirty = make(map[string]bool)
+	r.mu.Unlock()
+
+	for id, data := range writes {
+		var err error
+		if data == "" {
+			err = r.store.Delete(agentRecordKey(id))
+		} else {
+			err = r.store.Set(agentRecordKey(id), data)
+		}
+
+		r.mu.Lock()
+		delete(r.sharedWriting, id)
+		if err != nil {
+			r.sharedDirty[id] = true
+		} else {
+			now := time.Now()
+			r.sharedSaved[id] = now
+			r.sharedLoaded[id] = now
+		}
+		r.mu.Unlock()
+
+		if err != nil {
+			r.logger.Errorf("Failed to share agent %s: %v", id, err)
+			continue
+		}
+		r.bus.Publish(sharedstate.KindAgent, id)
+	}
+}
+
+// refreshAgent rereads an agent record from storage if the cached copy is
+// missing or older than the cache TTL
+func (r *Registry) refreshAgent(id string) {
+	r.mu.RLock()
+	stale := r.bus != nil && !r.sharedDirty[id] && !r.sharedWriting[id] && time.Since(r.sharedLoaded[id]) >= r.cacheTTL
+	r.mu.RUnlock()
+
+	if stale {
+		r.reloadAgent(id)
+	}
+}
+
+// refreshAgents rereads all agent records from storage once the last full
+// read is older than the cache TTL
+func (r *Registry) refreshAgents() {
+	r.mu.RLock()
+	stale := r.bus != nil && time.Since(r.sharedListed) >= r.cacheTTL
+	r.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	started := time.Now()
+	records, err := storage.ListPrefix(r.store, agentRecordKeyPrefix)
+	if err != nil {
+		r.logger.Errorf("Failed to reload shared agent records: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.installAgentsLocked(records, started)
+}
+
+// installAgentsLocked replaces the cached agent records with the ones read
+// from storage since started, dropping those no longer stored; caller must
+// hold r.mu
+func (r *Registry) installAgentsLocked(records map[string]interface{}, started time.Time) {
+	stored := make(map[string]bool, len(records))
+	for key, value := range records {
+		agent, err := decodeStoredAgent(value)
+		if err != nil {
+			r.logger.Errorf("Invalid shared agent record %s: %v", key, err)
+			continue
+		}
+		stored[agent.ID] = true
+		r.installAgentLocked(agent, started)
+	}
+	for id := range r.agents {
+		if !stored[id] {
+			r.dropAgentLocked(id, started)
+		}
+	}
+	r.sharedListed = started
+}
+
+// reloadAgent rereads an agent record another server changed
+func (r *Registry) reloadAgent(id string) {
+	started := time.Now()
+	value, err := r.store.Get(agentRecordKey(id))
+	var agent *AgentInfo
+	if err == nil {
+		agent, err = decodeStoredAgent(value)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch {
+	case err == storage.ErrNotFound:
+		r.dropAgentLocked(id, started)
+	case err != nil:
+		r.logger.Errorf("Failed to reload shared agent %s: %v", id, err)
+	default:
+		r.installAgentLocked(agent, started)
+	}
+}
+
+// installAgentLocked caches an agent record read from storage since
+// started, unless the cached copy has changes not written yet or was written
+// since the read started. Contact recorded here but not written yet is kept.
+// Cached configuration and benchmark results are dropped, since they change
+// along with the record. Caller must hold r.mu.
+func (r *Registry) installAgentLocked(agent *AgentInfo, started time.Time) {
+	id := agent.ID
+	if r.sharedPendingLocked(id, started) {
+		return
+	}
+
+	if existing, ok := r.agents[id]; ok {
+		if existing.LastSeen.After(agent.LastSeen) {
+			agent.LastSeen = existing.LastSeen
+		}
+		if existing.LastPing.After(agent.LastPing) {
+			agent.LastPing = existing.LastPing
+		}
+	}
+	r.agents[id] = agent
+	r.sharedLoaded[id] = started
+	delete(r.configs, id)
+	delete(r.benchmarks, id)
+}
+
+// dropAgentLocked forgets an agent another server removed, unless it has
+// changes not written yet or was written since the read started; caller
+// must hold r.mu
+func (r *Registry) dropAgentLocked(id string, started time.Time) {
+	if r.sharedPendingLocked(id, started) {
+		return
+	}
+	delete(r.agents, id)
+	delete(r.inventoryChanges, id)
+	delete(r.agentTokens, id)
+	delete(r.configs, id)
+	delete(r.configStatus, id)
+	delete(r.benchmarks, id)
+	delete(r.sharedSaved, id)
+	delete(r.sharedLoaded, id)
+}
+
+// sharedPendingLocked reports whether the cached agent record is newer than
+// one read from storage since started: it has changes not written yet, or
+// was written since; caller must hold r.mu
+func (r *Registry) sharedPendingLocked(id string, started time.Time) bool {
+	return r.sharedDirty[id] || r.sharedWriting[id] || r.sharedSaved[id].After(started)
+}
+
+// decodeStoredAgent decodes an agent record read from storage
+func decodeStoredAgent(value interface{}) (*AgentInfo, error) {
+	data, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value type %T", value)
+	}
+	var agent AgentInfo
+	if err := json.Unmarshal([]byte(data), &agent); err != nil {
+		return nil, err
+	}
+	if agent.ID == "" {
+		return nil, fmt.Errorf("agent record without ID")
+	}
+	return &agent, nil
+}
+
+// sharedTurn takes the named storage lock so that one server at a time runs
+// a periodic job over the shared state, reporting false while another server
+// holds it. Without shared state, or a storage without locks, it always
+// succeeds. The returned function releases the lock.
+func sharedTurn(store storage.Storage, bus *sharedstate.Bus, name string) (func(), bool) {
+	release := func() {}
+	if bus == nil {
+		return release, true
+	}
+	locker, ok := store.(storage.Locker)
+	if !ok {
+		return release, true
+	}
+
+	acquired, err := locker.TryLock(name, bus.InstanceID(), sharedJobLockTTL)
+	if err != nil || !acquired {
+		return release, false
+	}
+	return func() { locker.Unlock(name, bus.InstanceID()) }, true
+}
+
+// EnableSharedState shares tasks with the other servers using the registry's
+// storage, telling them about changes over the bus. Tasks are written at
+// each status change, and a pending task is claimed under a storage lock
+// before it is dispatched, so no two servers hand it out. Lookups reread a
+// task from storage once the cached copy is older than cacheTTL, on every
+// lookup if it is 0. Only one server at a time expires tasks and runs
+// schedules. Must be called before the bus is started.
+func (s *Scheduler) EnableSharedState(bus *sharedstate.Bus, cacheTTL time.Duration) error {
+	store := s.store()
+	if store == nil {
+		return fmt.Errorf("shared state needs a storage")
+	}
+	if cacheTTL < 0 {
+		return fmt.Errorf("shared cache TTL must not be negative")
+	}
+
+	records, err := storage.ListPrefix(store, taskKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to load shared tasks: %v", err)
+	}
+
+	s.mu.Lock()
+	s.bus = bus
+	s.cacheTTL = cacheTTL
+	s.taskLoaded = make(map[string]time.Time)
+	s.installTasksLocked(records, time.Now())
+	loaded := len(s.tasks)
+	s.mu.Unlock()
+
+	bus.Handle(sharedstate.KindTask, func(msg sharedstate.Message) {
+		s.reloadTask(msg.Key)
+	})
+
+	s.logger.Infof("Sharing tasks as %s, loaded %d", bus.InstanceID(), loaded)
+	return nil
+}
+
+// saveTaskLocked writes a changed task and tells the other servers; caller
+// must hold s.mu
+func (s *Scheduler) saveTaskLocked(task *Task) {
+	if s.bus == nil {
+		return
+	}
+
+	data, err := json.Marshal(storedTask{Task: task, Expiry: task.expiry})
+	if err != nil {
+		s.logger.Errorf("Failed to encode task %s: %v", task.ID, err)
+		return
+	}
+	if err := s.store().Set(taskKey(task.ID), string(data)); err != nil {
+		s.logger.Errorf("Failed to share task %s: %v", task.ID, err)
+		return
+	}
+	task.savedAt = time.Now()
+	s.taskLoaded[task.ID] = task.savedAt
+	s.bus.Publish(sharedstate.KindTask, task.ID)
+}
+
+// claimLocked locks a pending task in storage before it is dispatched and
+// checks no other server dispatched it meanwhile, installing the stored
+// copy if one did. The returned function releases the lock once the task
+// is saved as running. Caller must hold s.mu.
+func (s *Scheduler) claimLocked(task *Task) (func(), bool) {
+	release := func() {}
+	if s.bus == nil {
+		return release, true
+	}
+	store := s.store()
+
+	// Without locks, rereading the task only narrows the race
+	if locker, ok := store.(storage.Locker); ok {
+		name := taskKey(task.ID)
+		owner := s.bus.InstanceID()
+		acquired, err := locker.TryLock(name, owner, taskClaimLockTTL)
+		if err != nil {
+			s.logger.Errorf("Failed to claim task %s: %v", task.ID, err)
+			return release, false
+		}
+		if !acquired {
+			return release, false
+		}
+		release = func() { locker.Unlock(name, owner) }
+	}
+
+	value, err := store.Get(taskKey(task.ID))
+	if err == storage.ErrNotFound {
+		// Not shared yet, e.g. its write failed; it is ours to dispatch
+		return release, true
+	}
+	var record storedTask
+	if err == nil {
+		record, err = decodeStoredTask(value)
+	}
+	if err != nil {
+		release()
+		s.logger.Errorf("Failed to claim task %s: %v", task.ID, err)
+		return func() {}, false
+	}
+	if record.Status != "pending" || record.Attempt != task.Attempt {
+		release()
+		task.savedAt = time.Time{}
+		s.installTaskLocked(record, time.Now())
+		return func() {}, false
+	}
+	return release, true
+}
+
+// refreshTask rereads a task from storage if the cached copy is missing or
+// older than the cache TTL
+func (s *Scheduler) refreshTask(taskID string) {
+	s.mu.RLock()
+	stale := s.bus != nil && time.Since(s.taskLoaded[taskID]) >= s.cacheTTL
+	s.mu.RUnlock()
+
+	if stale {
+		s.reloadTask(taskID)
+	}
+}
+
+// refreshTasks rereads all tasks from storage once the last full read is
+// older than the cache TTL
+func (s *Scheduler) refreshTasks() {
+	s.mu.RLock()
+	stale := s.bus != nil && time.Since(s.tasksListed) >= s.cacheTTL
+	s.mu.RUnlock()
+	if !stale {
+		return
+	}
+
+	started := time.Now()
+	records, err := storage.ListPrefix(s.store(), taskKeyPrefix)
+	if err != nil {
+		s.logger.Errorf("Failed to reload shared tasks: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.installTasksLocked(records, started)
+}
+
+// installTasksLocked caches the tasks read from storage since started;
+// caller must hold s.mu
+func (s *Scheduler) installTasksLocked(records map[string]interface{}, started time.Time) {
+	for key, value := range records {
+		record, err := decodeStoredTask(value)
+		if err != nil {
+			s.logger.Errorf("Invalid shared task %s: %v", key, err)
+			continue
+		}
+		s.installTaskLocked(record, started)
+	}
+	s.tasksListed = started
+}
+
+// reloadTask rereads a task another server changed
+func (s *Scheduler) reloadTask(taskID string) {
+	started := time.Now()
+	value, err := s.store().Get(taskKey(taskID))
+	var record storedTask
+	if err == nil {
+		record, err = decodeStoredTask(value)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.tasks[taskID]
+	switch {
+	case ok && existing.savedAt.After(started):
+		// Saved here since the read started, so the cached copy is newer
+	case err == storage.ErrNotFound:
+		delete(s.tasks, taskID)
+		delete(s.taskLoaded, taskID)
+	case err != nil:
+		s.logger.Errorf("Failed to reload shared task %s: %v", taskID, err)
+	default:
+		s.installTaskLocked(record, started)
+	}
+}
+
+// installTaskLocked caches a task read from storage since started, unless
+// the cached copy was saved after the read started, and wakes the agent's
+// streams if the task is pending; caller must hold s.mu
+func (s *Scheduler) installTaskLocked(record storedTask, started time.Time) {
+	task := record.Task
+	if existing, ok := s.tasks[task.ID]; ok && existing.savedAt.After(started) {
+		return
+	}
+
+	task.expiry = record.Expiry
+	s.tasks[task.ID] = task
+	s.taskLoaded[task.ID] = started
+	if task.Status == "pending" {
+		s.wakeWatchersLocked(task.AgentID)
+	}
+}
+
+// deleteSharedTaskLocked removes a pruned task from storage; the other
+// servers prune their copies on their own. Caller must hold s.mu.
+func (s *Scheduler) deleteSharedTaskLocked(taskID string) {
+	if s.bus == nil {
+		return
+	}
+	delete(s.taskLoaded, taskID)
+	if err := s.store().Delete(taskKey(taskID)); err != nil {
+		s.logger.Errorf("Failed to delete shared task %s: %v", taskID, err)
+	}
+}
+
+// decodeStoredTask decodes a task read from storage
+func decodeStoredTask(value interface{}) (storedTask, error) {
+	record := storedTask{Task: &Task{}}
+	data, ok := value.(string)
+	if !ok {
+		return record, fmt.Errorf("unexpected value type %T", value)
+	}
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return record, err
+	}
+	if record.ID == "" {
+		return record, fmt.Errorf("task without ID")
+	}
+	return record, nil
+}
+
+// reloadSchedulesLocked replaces the schedules with the stored ones, which
+// the other servers may have changed; caller must hold s.mu
+func (s *Scheduler) reloadSchedulesLocked() error {
+	records, err := storage.ListPrefix(s.store(), scheduleKeyPrefix)
+	if err != nil {
+		return err
+	}
+
+	schedules := make(map[string]*Schedule, len(records))
+	for key, value := range records {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var schedule Schedule
+		if err := json.Unmarshal([]byte(data), &schedule); err != nil {
+			s.logger.Errorf("Invalid stored schedule %s: %v", key, err)
+			continue
+		}
+		schedules[schedule.ID] = &schedule
+	}
+	s.schedules = schedules
+	return nil
+}
+
+// sharedBus returns the bus tasks are shared over, or nil
+func (s *Scheduler) sharedBus() *sharedstate.Bus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.bus
+}
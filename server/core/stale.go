@@ -0,0 +1,63 @@
+// Package core provides the policy for marking silent agents offline and
+// removing long-offline agents.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultOfflineAfter is how long an agent may go without contact before it
+// is marked offline
+const DefaultOfflineAfter = 5 * time.Minute
+
+// SetStalePolicy sets how long an agent may go without contact before it is
+// marked offline, and before it is removed. removeAfter 0 never removes agents.
+// Agents in maintenance are exempt from both.
+func (r *Registry) SetStalePolicy(offlineAfter, removeAfter time.Duration) error {
+	if offlineAfter <= 0 {
+		return fmt.Errorf("offline threshold must be positive")
+	}
+	if removeAfter < 0 || (removeAfter > 0 && removeAfter <= offlineAfter) {
+		return fmt.Errorf("removal threshold must be 0 or longer than the offline threshold")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.offlineAfter = offlineAfter
+	r.removeAfter = removeAfter
+	return nil
+}
+
+// OnRemoved registers a callback invoked when a long-offline agent is removed
+func (r *Registry) OnRemoved(handler func(agentID string)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removedHandlers = append(r.removedHandlers, handler)
+}
+
+// purgeLocked removes an agent along with its stored configuration and
+// benchmark results; caller must hold r.mu
+func (r *Registry) purgeLocked(id string) {
+	delete(r.agents, id)
+	delete(r.inventoryChanges, id)
+	delete(r.agentTokens, id)
+	delete(r.configs, id)
+	delete(r.configStatus, id)
+	delete(r.benchmarks, id)
+	r.sharedChangedLocked(id)
+
+	if r.store != nil {
+		if err := r.store.Delete(configKey(id)); err != nil {
+			r.logger.Errorf("Failed to delete stored config of agent %s: %v", id, err)
+		}
+		if err := r.store.Delete(benchmarksKey(id)); err != nil {
+			r.logger.Errorf("Failed to delete stored benchmark results of agent %s: %v", id, err)
+		}
+	}
+}
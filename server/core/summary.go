@@ -0,0 +1,96 @@
+// Package core provides the spread of metrics agents sample between
+// heartbeats, with alerting on sustained load rather than single readings.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+// maxSummaryMetrics caps the sampled metrics kept from a heartbeat
+const maxSummaryMetrics = 16
+
+// MetricSummary is the spread of a metric an agent sampled over its last
+// heartbeat interval
+type MetricSummary struct {
+	Min     float64 `json:"min"`
+	Avg     float64 `json:"avg"`
+	Max     float64 `json:"max"`
+	P95     float64 `json:"p95"`
+	Samples int     `json:"samples"`
+}
+
+// parseMetricSummary reads the sampled metric summaries of heartbeat
+// metrics, skipping malformed entries
+func parseMetricSummary(value interface{}) map[string]MetricSummary {
+	metrics, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	summary := make(map[string]MetricSummary)
+	for name, item := range metrics {
+		fields, ok := item.(map[string]interface{})
+		if !ok || name == "" || validateString(name, maxFieldLength) != nil || len(summary) == maxSummaryMetrics {
+			continue
+		}
+		samples, ok := fields["samples"].(float64)
+		if !ok || samples < 1 {
+			continue
+		}
+		metric := MetricSummary{Samples: int(samples)}
+		metric.Min, _ = fields["min"].(float64)
+		metric.Avg, _ = fields["avg"].(float64)
+		metric.Max, _ = fields["max"].(float64)
+		metric.P95, _ = fields["p95"].(float64)
+		summary[name] = metric
+	}
+	if len(summary) == 0 {
+		return nil
+	}
+	return summary
+}
+
+// OnMetricsSummary registers a callback invoked for each heartbeat carrying
+// usage metrics, along with the sampled metric summaries of agents that
+// sample between heartbeats
+func (r *Registry) OnMetricsSummary(handler func(agentID string, usage *AgentUsage)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.summaryHandlers = append(r.summaryHandlers, handler)
+}
+
+// notifyMetricsSummary invokes the metrics summary handlers; must be called without r.mu held
+func (r *Registry) notifyMetricsSummary(agentID string, usage *AgentUsage) {
+	r.mu.RLock()
+	handlers := r.summaryHandlers
+	r.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(agentID, usage)
+	}
+}
+
+// MetricsSummaryAlertData returns an agent's sampled metric summaries as
+// alert rule input, matchable with conditions on event, the heartbeat's
+// cpu_usage, memory_usage and disk_usage, and nested fields such as
+// summary.cpu_usage.p95 or summary.memory_usage.min
+func MetricsSummaryAlertData(usage *AgentUsage) map[string]interface{} {
+	summary := make(map[string]interface{}, len(usage.Summary))
+	for name, metric := range usage.Summary {
+		summary[name] = map[string]interface{}{
+			"min":     metric.Min,
+			"avg":     metric.Avg,
+			"max":     metric.Max,
+			"p95":     metric.P95,
+			"samples": metric.Samples,
+		}
+	}
+
+	return map[string]interface{}{
+		"event":        "metrics_summary",
+		"cpu_usage":    usage.CPUUsage,
+		"memory_usage": usage.MemoryUsage,
+		"disk_usage":   usage.DiskUsage,
+		"summary":      summary,
+	}
+}
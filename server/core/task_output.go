@@ -0,0 +1,74 @@
+// Package core provides the output cap and retention of task results.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nerve/server/pkg/storage"
+)
+
+// SetTaskResultPolicy sets the output kept per task result and how long
+// finished tasks are kept in memory
+func (s *Scheduler) SetTaskResultPolicy(policy storage.TaskResultConfig) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resultPolicy = policy
+	return nil
+}
+
+// recordOutputLocked keeps the output of a finished task, truncated to the
+// output cap, and returns the result to persist; caller must hold s.mu
+func (s *Scheduler) recordOutputLocked(task *Task, success bool, output, errMsg string, now time.Time) *storage.TaskResultRecord {
+	task.Output, task.OutputTruncated = storage.TruncateOutput(output, s.resultPolicy.MaxOutputBytes)
+
+	return &storage.TaskResultRecord{
+		TaskID:      task.ID,
+		AgentID:     task.AgentID,
+		Type:        task.Type,
+		Status:      task.Status,
+		Success:     success,
+		Output:      task.Output,
+		Error:       errMsg,
+		OutputBytes: len(output),
+		Truncated:   task.OutputTruncated,
+		CompletedAt: now,
+	}
+}
+
+// saveTaskResult persists a task result if the storage backend keeps task
+// results; must be called without s.mu held
+func (s *Scheduler) saveTaskResult(record *storage.TaskResultRecord) {
+	results, ok := s.store().(storage.TaskResultStore)
+	if !ok {
+		return
+	}
+	if err := results.SaveTaskResult(record); err != nil && !errors.Is(err, storage.ErrNoTaskResults) {
+		s.logger.Errorf("Failed to persist result of task %s: %v", record.TaskID, err)
+	}
+}
+
+// pruneFinishedTasksLocked forgets tasks that finished longer than the
+// result retention ago; caller must hold s.mu
+func (s *Scheduler) pruneFinishedTasksLocked(now time.Time, deleteShared bool) {
+	cutoff := now.Add(-s.resultPolicy.Retention)
+	for id, task := range s.tasks {
+		switch task.Status {
+		case "completed", "failed", "expired", "cancelled":
+			if task.UpdatedAt.Before(cutoff) {
+				delete(s.tasks, id)
+				if deleteShared {
+					s.deleteSharedTaskLocked(id)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package core provides searching the output of a batch of tasks, e.g. for
+// the agents whose run of a fleet-wide command printed an error.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultOutputSearchLimit is how many matching tasks a search returns
+	// when it sets no limit
+	DefaultOutputSearchLimit = 100
+
+	// MaxOutputSearchLimit caps the matching tasks a search returns
+	MaxOutputSearchLimit = 1000
+
+	// MaxOutputSearchTasks caps the tasks of a batch one search scans
+	MaxOutputSearchTasks = 10000
+
+	// maxOutputMatchLines caps the matching lines returned per task
+	maxOutputMatchLines = 20
+
+	// maxOutputMatchLineLength caps the length of a returned line
+	maxOutputMatchLineLength = 1024
+
+	// maxOutputPatternLength caps the length of a search pattern
+	maxOutputPatternLength = 1024
+)
+
+// OutputSearch selects the finished tasks of a batch whose output contains a
+// substring or matches a regular expression
+type OutputSearch struct {
+	BatchID  string
+	Contains string
+	Regex    string
+	Limit    int
+
+	pattern *regexp.Regexp
+}
+
+// Validate checks the search names a batch and exactly one of a substring
+// and a regular expression, and compiles the latter
+func (q *OutputSearch) Validate() error {
+	if q.BatchID == "" {
+		return fmt.Errorf("batch is required")
+	}
+	if (q.Contains == "") == (q.Regex == "") {
+		return fmt.Errorf("specify either output_contains or output_regex")
+	}
+	if len(q.Contains) > maxOutputPatternLength || len(q.Regex) > maxOutputPatternLength {
+		return fmt.Errorf("search pattern must be at most %d bytes", maxOutputPatternLength)
+	}
+	if q.Limit < 0 || q.Limit > MaxOutputSearchLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxOutputSearchLimit)
+	}
+	if q.Regex != "" {
+		pattern, err := regexp.Compile(q.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid output_regex: %v", err)
+		}
+		q.pattern = pattern
+	}
+	return nil
+}
+
+// matches reports whether a line of output matches the search
+func (q *OutputSearch) matches(line string) bool {
+	if q.pattern != nil {
+		return q.pattern.MatchString(line)
+	}
+	return strings.Contains(line, q.Contains)
+}
+
+// OutputLine is a line of task output matching a search
+type OutputLine struct {
+	Number int    `json:"line"`
+	Text   string `json:"text"`
+}
+
+// OutputMatch is a task whose output matches a search
+type OutputMatch struct {
+	TaskID         string       `json:"task_id"`
+	AgentID        string       `json:"agent_id"`
+	Status         string       `json:"status"`
+	Lines          []OutputLine `json:"lines"`
+	LinesTruncated bool         `json:"lines_truncated,omitempty"`
+}
+
+// OutputSearchSummary reports what a search scanned
+type OutputSearchSummary struct {
+	Tasks   int  `json:"tasks"`
+	Scanned int  `json:"scanned"`
+	Matched int  `json:"matched"`
+	Limited bool `json:"limited,omitempty"`
+}
+
+// NewBatchID generates the ID grouping the tasks created together
+func NewBatchID() string {
+	return "batch-" + generateTaskID()
+}
+
+// SearchOutput scans the output of the finished tasks of a batch, ordered by
+// agent, and passes each matching task to emit as it is found. The scan stops
+// at the search limit, after MaxOutputSearchTasks tasks or when emit fails.
+// The output is that kept by the scheduler, truncated to the output cap.
+func (s *Scheduler) SearchOutput(search OutputSearch, emit func(*OutputMatch) error) (OutputSearchSummary, error) {
+	var summary OutputSearchSummary
+	if err := search.Validate(); err != nil {
+		return summary, err
+	}
+	if search.Limit == 0 {
+		search.Limit = DefaultOutputSearchLimit
+	}
+
+	// Scan a copy, so the scheduler isn't locked while matching
+	type finished struct {
+		id, agentID, status, output string
+	}
+	var batch []finished
+	s.mu.RLock()
+	for _, task := range s.tasks {
+		if task.BatchID != search.BatchID {
+			continue
+		}
+		summary.Tasks++
+		switch task.Status {
+		case "completed", "failed":
+			batch = append(batch, finished{task.ID, task.AgentID, task.Status, task.Output})
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(batch, func(i, j int) bool {
+		if batch[i].agentID != batch[j].agentID {
+			return batch[i].agentID < batch[j].agentID
+		}
+		return batch[i].id < batch[j].id
+	})
+
+	for _, task := range batch {
+		if summary.Matched >= search.Limit || summary.Scanned >= MaxOutputSearchTasks {
+			summary.Limited = true
+			break
+		}
+		summary.Scanned++
+
+		match := &OutputMatch{TaskID: task.id, AgentID: task.agentID, Status: task.status}
+		for i, line := range strings.Split(task.output, "\n") {
+			if !search.matches(line) {
+				continue
+			}
+			if len(match.Lines) == maxOutputMatchLines {
+				match.LinesTruncated = true
+				break
+			}
+			if len(line) > maxOutputMatchLineLength {
+				cut := maxOutputMatchLineLength
+				for cut > 0 && !utf8.RuneStart(line[cut]) {
+					cut--
+				}
+				line = line[:cut]
+			}
+			match.Lines = append(match.Lines, OutputLine{Number: i + 1, Text: line})
+		}
+		if len(match.Lines) == 0 {
+			continue
+		}
+
+		summary.Matched++
+		if err := emit(match); err != nil {
+			return summary, err
+		}
+	}
+	return summary, nil
+}
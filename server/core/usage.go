@@ -0,0 +1,102 @@
+// Package core provides the resource usage agents report in heartbeats.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import "time"
+
+// AgentUsage is the resource utilization from an agent's last heartbeat
+// that carried metrics, in percent
+type AgentUsage struct {
+	CPUUsage    float64   `json:"cpu_usage"`
+	MemoryUsage float64   `json:"memory_usage"`
+	DiskUsage   float64   `json:"disk_usage"`
+	ReportedAt  time.Time `json:"reported_at"`
+
+	// GPUs is the per-GPU utilization, empty for agents without NVIDIA GPUs
+	GPUs []GPUUsage `json:"gpus,omitempty"`
+
+	// Sensors are the BMC's power supply, fan and temperature sensors,
+	// empty for agents without IPMI
+	Sensors []Sensor `json:"sensors,omitempty"`
+
+	// Filesystems is the usage of each mounted filesystem keyed by mount
+	// point, and DiskIO the IO of each disk keyed by device name
+	Filesystems map[string]FilesystemUsage `json:"filesystems,omitempty"`
+	DiskIO      map[string]DiskIO          `json:"disk_io,omitempty"`
+
+	// Summary is the spread of metrics the agent sampled over its last
+	// heartbeat interval, keyed by metric name
+	Summary map[string]MetricSummary `json:"summary,omitempty"`
+}
+
+// GPUUsage is the utilization of one GPU, as reported in heartbeat metrics
+type GPUUsage struct {
+	Index         int     `json:"index"`
+	Name          string  `json:"name"`
+	Utilization   float64 `json:"utilization"`
+	MemoryUsedMB  float64 `json:"memory_used_mb"`
+	MemoryTotalMB float64 `json:"memory_total_mb"`
+}
+
+// Values returns the usage keyed by the heartbeat metric names, as
+// aggregated by cluster alert rules
+func (u *AgentUsage) Values() map[string]float64 {
+	return map[string]float64{
+		"cpu_usage":    u.CPUUsage,
+		"memory_usage": u.MemoryUsage,
+		"disk_usage":   u.DiskUsage,
+	}
+}
+
+// updateUsageLocked stores the utilization of a heartbeat's metrics and
+// returns its sensors if their statuses changed; caller must hold r.mu
+func (r *Registry) updateUsageLocked(agent *AgentInfo, metrics map[string]interface{}, now time.Time) []Sensor {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	usage := &AgentUsage{ReportedAt: now}
+	usage.CPUUsage, _ = metrics["cpu_usage"].(float64)
+	usage.MemoryUsage, _ = metrics["memory_usage"].(float64)
+	usage.DiskUsage, _ = metrics["disk_usage"].(float64)
+	usage.GPUs = parseGPUUsage(metrics["gpus"])
+	usage.Sensors = parseSensors(metrics["sensors"])
+	usage.Filesystems = parseFilesystems(metrics["filesystems"])
+	usage.DiskIO = parseDiskIO(metrics["disk_io"])
+	usage.Summary = parseMetricSummary(metrics["summary"])
+
+	changed := sensorsChanged(agent.Usage, usage.Sensors)
+	agent.Usage = usage
+	if !changed {
+		return nil
+	}
+	return usage.Sensors
+}
+
+// parseGPUUsage reads the per-GPU utilization list of heartbeat metrics,
+// skipping malformed entries
+func parseGPUUsage(value interface{}) []GPUUsage {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var gpus []GPUUsage
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		gpu := GPUUsage{}
+		index, _ := fields["index"].(float64)
+		gpu.Index = int(index)
+		gpu.Name, _ = fields["name"].(string)
+		gpu.Utilization, _ = fields["utilization"].(float64)
+		gpu.MemoryUsedMB, _ = fields["memory_used_mb"].(float64)
+		gpu.MemoryTotalMB, _ = fields["memory_total_mb"].(float64)
+		gpus = append(gpus, gpu)
+	}
+	return gpus
+}
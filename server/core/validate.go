@@ -0,0 +1,229 @@
+// Package core provides validation of agent registration payloads.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package core
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+)
+
+const (
+	// MaxRegisterBodyBytes caps the size of a registration request body
+	MaxRegisterBodyBytes = 1 << 20
+
+	// maxHostnameLength and maxHostnameLabel follow RFC 1123
+	maxHostnameLength = 253
+	maxHostnameLabel  = 63
+
+	// maxAgentIDLength caps the identity an agent asks to be registered under
+	maxAgentIDLength = 128
+
+	// maxFieldLength caps free-form string fields such as cpu_type or os
+	maxFieldLength = 256
+
+	// maxListItems caps lists such as netcard or disk_info
+	maxListItems = 256
+
+	// maxMapKeys caps the keys of each inventory map
+	maxMapKeys = 128
+
+	// maxInventoryDepth caps the nesting of inventory maps and lists
+	maxInventoryDepth = 4
+
+	// maxInventoryString caps string values inside inventory maps
+	maxInventoryString = 1024
+)
+
+// Validate checks a registration payload, returning an error naming the
+// first offending field
+func (req *RegisterRequest) Validate() error {
+	if err := validateHostname(req.Hostname); err != nil {
+		return fmt.Errorf("hostname: %v", err)
+	}
+	if req.AgentID != "" {
+		if err := validateAgentID(req.AgentID); err != nil {
+			return fmt.Errorf("agent_id: %v", err)
+		}
+	}
+
+	ips := []struct{ field, value string }{
+		{"ipmi_ip", req.IPMIIP},
+		{"manageip", req.ManageIP},
+		{"storageip", req.StorageIP},
+		{"paramip", req.ParamIP},
+	}
+	for _, ip := range ips {
+		if ip.value != "" && net.ParseIP(ip.value) == nil {
+			return fmt.Errorf("%s: %q is not a valid IP address", ip.field, ip.value)
+		}
+	}
+
+	strs := []struct{ field, value string }{
+		{"cpu_type", req.CPUType},
+		{"memory", req.Memory},
+		{"sn", req.SN},
+		{"product", req.Product},
+		{"brand", req.Brand},
+		{"basearch", req.Basearch},
+		{"raid", req.Raid},
+		{"os", req.OS},
+		{"gpu_type", req.GPUType},
+		{"agent_version", req.AgentVersion},
+		{"identity_source", req.IdentitySource},
+	}
+	for _, str := range strs {
+		if err := validateString(str.value, maxFieldLength); err != nil {
+			return fmt.Errorf("%s: %v", str.field, err)
+		}
+	}
+
+	switch {
+	case req.CPULogic < 0:
+		return fmt.Errorf("cpu_logic: must not be negative")
+	case req.Memsum < 0:
+		return fmt.Errorf("memsum: must not be negative")
+	case req.GPUNum < 0:
+		return fmt.Errorf("gpu_num: must not be negative")
+	}
+
+	inventory := []struct {
+		field string
+		value interface{}
+	}{
+		{"netcard", req.Netcard},
+		{"gpu_vendors", req.GPUVendors},
+		{"disk", req.Disk},
+		{"cpu_info", req.CPUInfo},
+		{"disk_info", req.DiskInfo},
+		{"memory_info", req.MemoryInfo},
+		{"gpu_info", req.GPUInfo},
+		{"network_info", req.NetworkInfo},
+	}
+	for _, item := range inventory {
+		if err := validateInventory(item.field, item.value, 1); err != nil {
+			return err
+		}
+	}
+
+	if err := validateKernelInventory(req.Kernel, req.PackageManager, req.Packages); err != nil {
+		return err
+	}
+	if err := validateRaidInfo(req.RaidInfo); err != nil {
+		return err
+	}
+
+	return validateCapabilities(req.Capabilities)
+}
+
+// validateHostname accepts RFC 1123 hostnames, also allowing underscores
+func validateHostname(hostname string) error {
+	if hostname == "" {
+		return fmt.Errorf("is required")
+	}
+	if len(hostname) > maxHostnameLength {
+		return fmt.Errorf("must be at most %d characters", maxHostnameLength)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if label == "" || len(label) > maxHostnameLabel {
+			return fmt.Errorf("labels must be 1 to %d characters", maxHostnameLabel)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("labels must not start or end with a hyphen")
+		}
+		for _, ch := range label {
+			if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+				return fmt.Errorf("invalid character %q", ch)
+			}
+		}
+	}
+	return nil
+}
+
+// validateAgentID accepts identities of letters, digits, '.', '-' and '_'
+// that don't start with a '.' or '-', as they appear in URL paths
+func validateAgentID(id string) error {
+	if len(id) > maxAgentIDLength {
+		return fmt.Errorf("must be at most %d characters", maxAgentIDLength)
+	}
+	if id[0] == '.' || id[0] == '-' {
+		return fmt.Errorf("must not start with %q", id[0])
+	}
+	for _, ch := range id {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.') {
+			return fmt.Errorf("invalid character %q", ch)
+		}
+	}
+	return nil
+}
+
+// validateString rejects long strings and characters that could inject
+// markup or control sequences where agent fields are displayed
+func validateString(value string, maxLength int) error {
+	if len(value) > maxLength {
+		return fmt.Errorf("must be at most %d characters", maxLength)
+	}
+	for _, ch := range value {
+		if ch == '<' || ch == '>' || unicode.IsControl(ch) && ch != '\t' {
+			return fmt.Errorf("invalid character %q", ch)
+		}
+	}
+	return nil
+}
+
+// validateInventory bounds the size and nesting of a decoded inventory value
+func validateInventory(field string, value interface{}, depth int) error {
+	if depth > maxInventoryDepth {
+		return fmt.Errorf("%s: nested more than %d levels", field, maxInventoryDepth)
+	}
+
+	switch v := value.(type) {
+	case string:
+		if err := validateString(v, maxInventoryString); err != nil {
+			return fmt.Errorf("%s: %v", field, err)
+		}
+	case []string:
+		if len(v) > maxListItems {
+			return fmt.Errorf("%s: more than %d items", field, maxListItems)
+		}
+		for i, item := range v {
+			if err := validateString(item, maxFieldLength); err != nil {
+				return fmt.Errorf("%s[%d]: %v", field, i, err)
+			}
+		}
+	case []interface{}:
+		if len(v) > maxListItems {
+			return fmt.Errorf("%s: more than %d items", field, maxListItems)
+		}
+		for i, item := range v {
+			if err := validateInventory(fmt.Sprintf("%s[%d]", field, i), item, depth+1); err != nil {
+				return err
+			}
+		}
+	case []map[string]interface{}:
+		if len(v) > maxListItems {
+			return fmt.Errorf("%s: more than %d items", field, maxListItems)
+		}
+		for i, item := range v {
+			if err := validateInventory(fmt.Sprintf("%s[%d]", field, i), item, depth+1); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		if len(v) > maxMapKeys {
+			return fmt.Errorf("%s: more than %d keys", field, maxMapKeys)
+		}
+		for key, item := range v {
+			if err := validateString(key, maxFieldLength); err != nil {
+				return fmt.Errorf("%s: key %v", field, err)
+			}
+			if err := validateInventory(field+"."+key, item, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -5,38 +5,109 @@
 package main
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	stdlog "log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nerve/pkg/logrotate"
 	"github.com/nerve/server/api"
 	"github.com/nerve/server/core"
 	"github.com/nerve/server/pkg/alert"
+	"github.com/nerve/server/pkg/apierror"
 	"github.com/nerve/server/pkg/binary"
 	"github.com/nerve/server/pkg/cluster"
+	"github.com/nerve/server/pkg/compression"
+	"github.com/nerve/server/pkg/config"
 	"github.com/nerve/server/pkg/log"
 	"github.com/nerve/server/pkg/metrics"
+	"github.com/nerve/server/pkg/retention"
+	"github.com/nerve/server/pkg/rpc"
 	"github.com/nerve/server/pkg/security"
+	"github.com/nerve/server/pkg/sharedstate"
 	"github.com/nerve/server/pkg/storage"
+	"github.com/nerve/server/pkg/webhook"
 	"github.com/nerve/server/pkg/websocket"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+// maxAuditQueryLimit caps the number of events one audit log query returns
+const maxAuditQueryLimit = 1000
+
 var (
-	addr         = flag.String("addr", ":8090", "Server address")
-	debug        = flag.Bool("debug", false, "Enable debug mode")
-	metricsAddr  = flag.String("metrics-addr", "", "Metrics server address (empty to disable)")
-	enableTLS    = flag.Bool("tls", false, "Enable TLS/HTTPS")
-	certFile     = flag.String("cert", "server.crt", "TLS certificate file")
-	keyFile      = flag.String("key", "server.key", "TLS private key file")
-	auditLogFile = flag.String("audit-log", "audit.log", "Audit log file")
+	addr              = flag.String("addr", ":8090", "Server address")
+	debug             = flag.Bool("debug", false, "Enable debug mode")
+	metricsAddr       = flag.String("metrics-addr", "", "Metrics server address (empty to disable)")
+	enableTLS         = flag.Bool("tls", false, "Enable TLS/HTTPS")
+	certFile          = flag.String("cert", "server.crt", "TLS certificate file")
+	keyFile           = flag.String("key", "server.key", "TLS private key file")
+	auditLogFile      = flag.String("audit-log", "audit.log", "Audit log file")
+	auditRedactHeader = flag.String("audit-redact-headers", "", "Comma-separated header names whose values are redacted from audit events, besides Authorization, Cookie and the like")
+	auditRedactFields = flag.String("audit-redact-fields", "", "Comma-separated JSON field names or dotted paths redacted from audit event details, besides password, token, secret and the like")
+	auditRedactQuery  = flag.String("audit-redact-query", "", "Comma-separated query parameters redacted from audit events, besides token, access_token, api_key, password and secret")
+	heartbeatInterval = flag.Duration("heartbeat-interval", 30*time.Second, "Expected agent heartbeat interval")
+	offlineAfter      = flag.Duration("offline-after", core.DefaultOfflineAfter, "Mark agents offline after this long without contact")
+	removeAfter       = flag.Duration("remove-after", 0, "Remove offline agents after this long without contact (0 to keep them)")
+	missedHeartbeats  = flag.Int("offline-missed-heartbeats", 0, "Mark agents offline after this many heartbeat intervals without contact (0 to use --offline-after)")
+	onlineHeartbeats  = flag.Int("online-heartbeats", 1, "Consecutive heartbeats an offline agent must send to be marked online again")
+	flapWindow        = flag.Duration("flap-window", core.DefaultFlapWindow, "Window in which an agent's offline and online transitions are counted")
+	flapThreshold     = flag.Int("flap-threshold", core.DefaultFlapThreshold, "Transitions within the flap window that mark an agent flapping")
+	maxClockSkew      = flag.Duration("max-clock-skew", core.DefaultMaxClockSkew, "Agent clock skew tolerated before the agent is flagged")
+	agentRate         = flag.Float64("agent-rate-limit", security.DefaultAgentRate, "Registrations and heartbeats allowed per second per agent (0 to disable)")
+	agentBurst        = flag.Int("agent-rate-burst", security.DefaultAgentBurst, "Registrations and heartbeats an agent may send in a burst")
+	logFile           = flag.String("log-file", "", "Write logs to this file instead of stderr")
+	logMaxSize        = flag.Int64("log-max-size", 100, "Rotate the log file after this many megabytes (0 to disable)")
+	logMaxAge         = flag.Duration("log-max-age", 24*time.Hour, "Rotate the log file after this age (0 to disable)")
+	logBackups        = flag.Int("log-max-backups", 7, "Number of rotated log files to keep (0 to keep all)")
+	grpcAddr          = flag.String("grpc-addr", "", "gRPC agent service address (empty to disable)")
+	gzipEnabled       = flag.Bool("gzip", true, "Gzip large API responses for clients that accept it")
+	gzipMinSize       = flag.Int("gzip-min-size", compression.DefaultMinSize, "Smallest response body in bytes that is gzipped")
+	gzipLevel         = flag.Int("gzip-level", gzip.DefaultCompression, "Gzip compression level (1-9, -1 for the default)")
+	gzipTypes         = flag.String("gzip-types", strings.Join(compression.DefaultContentTypes, ","), "Comma-separated content types that are gzipped")
+	slackWebhook      = flag.String("slack-webhook", "", "Slack incoming-webhook URL for alert notifications")
+	teamsWebhook      = flag.String("teams-webhook", "", "Microsoft Teams incoming-webhook URL for alert notifications")
+	discordWebhook    = flag.String("discord-webhook", "", "Discord webhook URL for alert notifications")
+	notifyFailures    = flag.Int("notify-breaker-failures", alert.DefaultBreakerFailures, "Consecutive failed notifications that stop deliveries to a webhook endpoint (0 to disable)")
+	notifyCooldown    = flag.Duration("notify-breaker-cooldown", alert.DefaultBreakerCooldown, "How long deliveries to a failing webhook endpoint stop before one is tried again")
+	taskSigningKey    = flag.String("task-signing-key", "", "Ed25519 private key (PEM) to sign dispatched tasks with (empty to send them unsigned)")
+	idempotencyTTL    = flag.Duration("idempotency-ttl", core.DefaultIdempotencyTTL, "How long task creation idempotency keys are remembered")
+	taskOutputMax     = flag.Int("task-output-max-bytes", storage.DefaultTaskOutputMaxBytes, "Output kept per task result in bytes; longer output is truncated with a marker")
+	taskRetention     = flag.Duration("task-result-retention", storage.DefaultTaskResultRetention, "How long finished tasks and their results are kept")
+	storageWrites     = flag.Int("storage-write-concurrency", storage.DefaultMaxConcurrentWrites, "Storage writes in flight at once (0 for no limit)")
+	storageWriteWait  = flag.Duration("storage-write-wait", storage.DefaultWriteWait, "How long a storage write waits for a free slot before it is rejected (0 to reject at once)")
+	logTailTimeout    = flag.Duration("log-tail-timeout", websocket.DefaultLogTailTimeout, "Longest a live agent log tail runs before it is stopped")
+	logTailRate       = flag.Int("log-tail-rate", websocket.DefaultLogTailRate, "Lines per second an agent may send for one log tail")
+	wsOrigins         = flag.String("ws-allowed-origins", "", "Comma-separated origins, e.g. https://ops.example.com, of pages besides the server's own that may open WebSockets (* for all)")
+	clusterAlertEvery = flag.Duration("cluster-alert-interval", alert.DefaultClusterEvaluationInterval, "How often cluster alert rules are evaluated (0 to disable)")
+	tokenRotation     = flag.Duration("token-rotation-window", security.DefaultTokenRotationWindow, "Rotate agent tokens this long before they expire, over the agent's control channel (0 to disable)")
+	tokenNewIPAlert   = flag.Bool("token-new-ip-alert", false, "Raise an alert when a token is used from an IP it has not recently been used from")
+	authDisabled      = flag.Bool("auth-disabled", false, "Leave the API open without tokens or RBAC (local development only)")
+	bootstrapAdmin    = flag.Bool("bootstrap-admin", false, "Issue a bootstrap-admin token at startup even if an active admin token exists")
+	bootstrapFile     = flag.String("bootstrap-token-file", "", "Write the bootstrap-admin token to this file (mode 0600) instead of showing it on the terminal")
+	configFile        = flag.String("config", "", "Server configuration file; its log level, rate limits and alert rules are reloaded on SIGHUP")
+	heartbeatRetain   = flag.Duration("heartbeat-retention", retention.DefaultHeartbeatRetention, "How long heartbeat history is kept in storage (0 to keep it forever)")
+	alertRetention    = flag.Duration("resolved-alert-retention", retention.DefaultResolvedAlertRetention, "How long resolved alerts are kept (0 to keep them forever)")
+	auditRetention    = flag.Duration("audit-log-retention", retention.DefaultAuditLogRetention, "How long audit log events are kept (0 to keep them forever)")
+	retentionEvery    = flag.Duration("retention-interval", retention.DefaultInterval, "How often data older than its retention is removed (0 to disable)")
+	execEnabled       = flag.Bool("exec-enabled", false, "Allow operators with the agents:exec permission to open interactive shells on agents that allow it")
+	execIdleTimeout   = flag.Duration("exec-idle-timeout", websocket.DefaultExecIdleTimeout, "End an interactive exec session after this long without input or output")
+	execMaxSessions   = flag.Int("exec-max-sessions", websocket.DefaultMaxExecSessions, "Interactive exec sessions open on this server at once")
+	sharedState       = flag.Bool("shared-state", false, "Share agents, tasks and tokens with the other servers using the same Redis-backed storage")
+	instanceID        = flag.String("instance-id", "", "Name of this server among those sharing state (default hostname-pid)")
+	sharedCacheTTL    = flag.Duration("shared-cache-ttl", core.DefaultSharedCacheTTL, "How long shared records are cached before being reread from storage")
 )
 
 func main() {
@@ -46,11 +117,35 @@ func main() {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	// Send server, access and standard library logs to the same file when requested
+	var logOutput io.Writer = os.Stderr
+	if *logFile != "" {
+		rotatingFile, err := logrotate.NewFile(*logFile, *logMaxSize*1024*1024, *logMaxAge, *logBackups)
+		if err != nil {
+			stdlog.Fatalf("Failed to open log file: %v", err)
+		}
+		defer rotatingFile.Close()
+
+		logOutput = rotatingFile
+		stdlog.SetOutput(rotatingFile)
+		gin.DefaultWriter = rotatingFile
+		gin.DefaultErrorWriter = rotatingFile
+	}
+
 	// Initialize security components
 	tlsServer := security.NewTLSServer(*certFile, *keyFile)
 	tokenManager := security.NewTokenManager(24*time.Hour, 7*24*time.Hour) // 24h rotation, 7d expiration
+	if err := tokenManager.SetRotationWindow(*tokenRotation); err != nil {
+		stdlog.Fatalf("Invalid --token-rotation-window: %v", err)
+	}
 	auditLogger := security.NewAuditLogger(*auditLogFile)
+	redaction := security.DefaultAuditRedaction()
+	redaction.Headers = append(redaction.Headers, splitList(*auditRedactHeader)...)
+	redaction.Fields = append(redaction.Fields, splitList(*auditRedactFields)...)
+	redaction.QueryParams = append(redaction.QueryParams, splitList(*auditRedactQuery)...)
+	auditLogger.SetRedaction(redaction)
 	permManager := security.NewPermissionManager()
+	agentLimiter := security.NewRateLimiter(*agentRate, *agentBurst)
 
 	// Setup TLS if enabled
 	if *enableTLS {
@@ -61,41 +156,386 @@ func main() {
 	}
 
 	// Initialize logger
-	logger := log.New(*debug)
+	logger := log.NewWithWriter(*debug, logOutput)
+
+	// The configuration file overrides the flags for the settings it sets
+	var cfg *config.File
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.Load(*configFile); err != nil {
+			stdlog.Fatalf("Invalid --config: %v", err)
+		}
+	}
 
-	// Initialize storage and registry
+	// Initialize storage and registry; in-memory unless configured
 	var store storage.Storage
-	// For now, use in-memory storage
 	store = storage.NewInMemory()
-	
+	if cfg != nil && cfg.Storage.Type != "" {
+		configured, err := storage.NewFromConfig(cfg.Storage)
+		if err != nil {
+			stdlog.Fatalf("Failed to open storage: %v", err)
+		}
+		store = configured
+	}
+
+	// Initialize other components
+	metricsCollector := metrics.NewMetricsCollector()
+
+	// Bound concurrent writes so bursts queue here rather than exhausting the backend
+	if *storageWrites > 0 {
+		limited, err := storage.NewLimited(store, *storageWrites, *storageWriteWait, metricsCollector)
+		if err != nil {
+			stdlog.Fatalf("Invalid storage write limit: %v", err)
+		}
+		store = limited
+	}
+
 	// Create registry
 	registry := core.NewRegistry(store, logger)
+	if err := registry.SetStalePolicy(*offlineAfter, *removeAfter); err != nil {
+		stdlog.Fatalf("Invalid stale agent policy: %v", err)
+	}
+	if err := registry.SetLivenessPolicy(core.LivenessPolicy{
+		HeartbeatInterval:  *heartbeatInterval,
+		MissedHeartbeats:   *missedHeartbeats,
+		RecoveryHeartbeats: *onlineHeartbeats,
+		FlapWindow:         *flapWindow,
+		FlapThreshold:      *flapThreshold,
+	}); err != nil {
+		stdlog.Fatalf("Invalid agent liveness policy: %v", err)
+	}
+	scheduler := core.NewScheduler(registry, logger)
+	if err := scheduler.SetIdempotencyTTL(*idempotencyTTL); err != nil {
+		stdlog.Fatalf("Invalid idempotency key TTL: %v", err)
+	}
+	if err := scheduler.SetTaskResultPolicy(storage.TaskResultConfig{
+		MaxOutputBytes: *taskOutputMax,
+		Retention:      *taskRetention,
+	}); err != nil {
+		stdlog.Fatalf("Invalid task result policy: %v", err)
+	}
+	wsManager := websocket.NewWebSocketManager(metricsCollector)
+
+	// Servers behind a load balancer share agents, tasks, tokens and UI
+	// events through a Redis-backed storage
+	if *sharedState {
+		id := *instanceID
+		if id == "" {
+			id = sharedstate.DefaultInstanceID()
+		}
+		bus, err := sharedstate.New(store, id, logger)
+		if err != nil {
+			stdlog.Fatalf("Invalid --shared-state: %v", err)
+		}
+		if err := registry.EnableSharedState(bus, *sharedCacheTTL); err != nil {
+			stdlog.Fatalf("Failed to enable shared state: %v", err)
+		}
+		if err := scheduler.EnableSharedState(bus, *sharedCacheTTL); err != nil {
+			stdlog.Fatalf("Failed to enable shared state: %v", err)
+		}
+		tokenManager.EnableSharedState(store, bus)
+
+		type sharedEvent struct {
+			Type string                 `json:"type"`
+			Data map[string]interface{} `json:"data,omitempty"`
+		}
+		wsManager.SetEventRelay(func(eventType, agentID string, data map[string]interface{}) {
+			bus.PublishData(sharedstate.KindEvent, agentID, sharedEvent{Type: eventType, Data: data})
+		})
+		bus.Handle(sharedstate.KindEvent, func(msg sharedstate.Message) {
+			var event sharedEvent
+			if err := json.Unmarshal(msg.Data, &event); err == nil {
+				wsManager.DeliverEvent(event.Type, msg.Key, event.Data)
+			}
+		})
+
+		// Control messages reach agents connected to any of the servers
+		router := sharedstate.NewRouter(bus, store, wsManager)
+		wsManager.OnAgentConnect(router.Claim)
+		wsManager.OnAgentDisconnect(router.Release)
+		wsManager.SetAgentForwarder(router.Forward)
+
+		if err := bus.Start(); err != nil {
+			stdlog.Fatalf("Failed to start shared state: %v", err)
+		}
+		defer bus.Close()
+		logger.Infof("Shared state enabled (cache TTL %v)", *sharedCacheTTL)
+	}
 
-	// Initialize other components
-	wsManager := websocket.NewWebSocketManager()
 	clusterMgr := cluster.NewClusterManager()
 	alertMgr := alert.NewAlertManager()
-	metricsCollector := metrics.NewMetricsCollector()
-	binaryMgr := binary.NewAgentBinaryManager("./binaries")
+	binaryMgr := binary.NewAgentBinaryManager("./binaries", tokenManager, auditLogger)
+
+	if err := alert.SetCircuitBreaker(*notifyFailures, *notifyCooldown); err != nil {
+		stdlog.Fatalf("Invalid notifier circuit breaker: %v", err)
+	}
+
+	// Chat notifiers, selectable by alert actions of the same type; registered
+	// before rules are loaded, which may rely on them
+	if *slackWebhook != "" {
+		alertMgr.RegisterNotifier("slack", alert.NewSlackNotifier(*slackWebhook))
+	}
+	if *teamsWebhook != "" {
+		alertMgr.RegisterNotifier("teams", alert.NewTeamsNotifier(*teamsWebhook))
+	}
+	if *discordWebhook != "" {
+		alertMgr.RegisterNotifier("discord", alert.NewDiscordNotifier(*discordWebhook))
+	}
+
+	// Cluster membership lookups shared by the components below
+	agentClusters := func(agentID string) []string {
+		var ids []string
+		for _, c := range clusterMgr.GetAgentClusters(agentID) {
+			ids = append(ids, c.ID)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+	clusterMembers := func(clusterID string) ([]string, error) {
+		c, err := clusterMgr.GetCluster(clusterID)
+		if err != nil {
+			return nil, err
+		}
+		return c.Agents, nil
+	}
+
+	// Maintenance windows may cover whole clusters, and tokens used from a new
+	// IP raise alerts when --token-new-ip-alert is set
+	alertMgr.SetClusterResolver(agentClusters)
+	if *tokenNewIPAlert {
+		tokenManager.OnNewIP(func(tokenInfo *security.TokenInfo, ip string, knownIPs []string) {
+			alertMgr.TokenNewIP(tokenInfo.ID, tokenInfo.Name, tokenInfo.AgentID, ip, knownIPs)
+		})
+	}
+
+	// Cluster rules aggregate the status and usage of each cluster's members
+	alertMgr.SetClusterSource(func() map[string][]alert.ClusterMember {
+		clusters := make(map[string][]alert.ClusterMember)
+		for _, c := range clusterMgr.ListClusters() {
+			members := make([]alert.ClusterMember, 0, len(c.Agents))
+			for _, agentID := range c.Agents {
+				member := alert.ClusterMember{AgentID: agentID}
+				if agent := registry.Get(agentID); agent != nil {
+					member.Online = agent.Status == "online"
+					if agent.Usage != nil {
+						member.Usage = agent.Usage.Values()
+					}
+				}
+				members = append(members, member)
+			}
+			clusters[c.ID] = members
+		}
+		return clusters
+	})
+
+	// SIGHUP reloads the configuration file's log level, rate limits and
+	// alert rules
+	var listenAddr string
+	if cfg != nil {
+		reloader := &configReloader{
+			path:         *configFile,
+			logger:       logger,
+			agentLimiter: agentLimiter,
+			alertMgr:     alertMgr,
+			debug:        *debug,
+			agentRate:    *agentRate,
+			agentBurst:   *agentBurst,
+		}
+		if err := reloader.apply(cfg); err != nil {
+			stdlog.Fatalf("Invalid --config: %v", err)
+		}
+		reloader.watch()
+		listenAddr = cfg.Server.Addr
+	}
+
+	// Schedules expand cluster selectors at each run; expired tasks are counted
+	scheduler.SetClusterResolver(clusterMembers)
+	scheduler.OnExpired(func(*core.Task) { metricsCollector.RecordTaskExpired() })
+
+	// Agents configured with the public key only run tasks signed with it
+	if *taskSigningKey != "" {
+		signer, err := core.LoadTaskSigner(*taskSigningKey)
+		if err != nil {
+			stdlog.Fatalf("Failed to load task signing key: %v", err)
+		}
+		scheduler.SetTaskSigner(signer)
+		fmt.Println("Task signing enabled")
+	}
+
+	// Per-agent series are labeled with the agent's clusters, OS and GPU vendors
+	metricsCollector.SetAgentLabelResolver(func(agentID string) metrics.AgentLabels {
+		labels := metrics.AgentLabels{Cluster: strings.Join(agentClusters(agentID), ",")}
+		if agent := registry.Get(agentID); agent != nil {
+			labels.OS = agent.OS
+			labels.GPUVendor = strings.Join(agent.GPUVendors, ",")
+		}
+		return labels
+	})
+
+	// Scoped broadcasts only reach the matching connected agents
+	wsManager.SetClusterResolver(clusterMembers)
+	wsManager.SetSelectorResolver(func(labels map[string]string) ([]string, error) {
+		selector, err := core.ParseAgentSelector(labels)
+		if err != nil {
+			return nil, err
+		}
+		return registry.SelectAgents(selector, clusterMembers)
+	})
+
+	// Browsers may open WebSockets from the server's own pages and these origins
+	if *wsOrigins != "" {
+		wsManager.SetAllowedOrigins(strings.Split(*wsOrigins, ","))
+	}
+
+	// Observers may tail the logs of agents that advertise log tailing
+	wsManager.SetLogTailLimits(*logTailTimeout, *logTailRate)
+	wsManager.SetLogTailResolver(func(agentID string) bool {
+		return registry.HasCapability(agentID, core.CapabilityLogTail)
+	})
+
+	// Interactive exec stays off unless explicitly enabled
+	if *execEnabled {
+		if *execIdleTimeout <= 0 || *execMaxSessions <= 0 {
+			stdlog.Fatalf("Invalid exec settings: --exec-idle-timeout and --exec-max-sessions must be positive")
+		}
+		wsManager.EnableExec(*execIdleTimeout, *execMaxSessions)
+		logger.Infof("Interactive exec enabled (idle timeout %v, at most %d sessions)", *execIdleTimeout, *execMaxSessions)
+	}
+
+	// Agent status changes and removals, alerts and finished tasks are pushed to UI observers
+	registry.OnOnline(func(agentID string) {
+		wsManager.PublishEvent(websocket.EventAgentOnline, agentID, nil)
+	})
+	registry.OnOffline(func(agentID string) {
+		wsManager.PublishEvent(websocket.EventAgentOffline, agentID, nil)
+	})
+	registry.OnRemoved(func(agentID string) {
+		metricsCollector.RemoveAgentMetrics(agentID)
+		auditLogger.LogSystemEvent("agent_removed", "remove", agentID, "success", map[string]interface{}{
+			"reason": "offline longer than --remove-after",
+		})
+		wsManager.PublishEvent(websocket.EventAgentRemoved, agentID, nil)
+	})
+	// Agent lifecycle events are delivered to the webhooks subscribing to them
+	webhooks := webhook.NewManager(store, logger)
+	registry.OnRegistered(func(agentID string) {
+		data := map[string]interface{}{}
+		if agent := registry.Get(agentID); agent != nil {
+			data["hostname"] = agent.Hostname
+			data["sn"] = agent.SN
+			data["manageip"] = agent.ManageIP
+			data["agent_version"] = agent.AgentVersion
+		}
+		webhooks.Publish(webhook.EventAgentRegistered, agentID, data)
+	})
+	registry.OnOnline(func(agentID string) {
+		webhooks.Publish(webhook.EventAgentOnline, agentID, nil)
+	})
+	registry.OnOffline(func(agentID string) {
+		webhooks.Publish(webhook.EventAgentOffline, agentID, nil)
+	})
+	registry.OnDecommission(func(agentID string) {
+		webhooks.Publish(webhook.EventAgentDecommissioned, agentID, nil)
+	})
+	registry.OnRemoved(func(agentID string) {
+		webhooks.Publish(webhook.EventAgentRemoved, agentID, map[string]interface{}{
+			"reason": "offline longer than --remove-after",
+		})
+	})
+	alertMgr.OnAlert(func(a *alert.Alert) {
+		wsManager.PublishEvent(websocket.EventAlert, a.AgentID, map[string]interface{}{
+			"id":       a.ID,
+			"rule_id":  a.RuleID,
+			"severity": a.Severity,
+			"status":   a.Status,
+			"message":  a.Message,
+		})
+	})
+	publishTask := func(task *core.Task) {
+		wsManager.PublishEvent(websocket.EventTaskCompleted, task.AgentID, map[string]interface{}{
+			"task_id": task.ID,
+			"type":    task.Type,
+			"status":  task.Status,
+			"attempt": task.Attempt,
+		})
+	}
+	scheduler.OnFinished(publishTask)
+	scheduler.OnExpired(publishTask)
+
+	// Registry events, such as inventory changes and heartbeat metrics, are
+	// evaluated against alert rules
+	registry.SetMaxClockSkew(*maxClockSkew)
+	evaluateAgentAlerts(registry, alertMgr)
 
 	// Start WebSocket manager
 	go wsManager.Run()
 
 	// Start metrics collector
 	go startMetricsServer(metricsCollector)
+	go startAgentMetricsUpdater(registry, metricsCollector)
+	if *clusterAlertEvery > 0 {
+		go startClusterAlertEvaluator(alertMgr, *clusterAlertEvery)
+	}
+
+	// Remove data older than its retention; servers sharing a storage take
+	// turns cleaning it up
+	retentionMgr, err := retention.NewManager(store, retention.Policy{
+		Heartbeats:     *heartbeatRetain,
+		ResolvedAlerts: *alertRetention,
+		CompletedTasks: *taskRetention,
+		AuditLogs:      *auditRetention,
+		Interval:       *retentionEvery,
+	}, logger)
+	if err != nil {
+		stdlog.Fatalf("Invalid retention policy: %v", err)
+	}
+	retentionMgr.Register(retention.KindResolvedAlerts, func(cutoff time.Time) (int64, error) {
+		return int64(alertMgr.PruneResolved(cutoff)), nil
+	})
+	retentionMgr.Register(retention.KindAuditLogs, func(cutoff time.Time) (int64, error) {
+		removed, err := auditLogger.PruneBefore(cutoff)
+		return int64(removed), err
+	})
+	retentionMgr.Start()
 
 	// Setup HTTP router
-	router := gin.Default()
+	router := gin.New()
+
+	// Assign request IDs first so access logs and audit events share them
+	router.Use(security.RequestIDMiddleware())
+	router.Use(gin.LoggerWithFormatter(accessLogFormatter))
+	router.Use(gin.Recovery())
 
 	// Add security middleware
 	router.Use(security.AuditMiddleware(auditLogger))
 
+	// Compress large responses; binary downloads are already compressed
+	if *gzipEnabled {
+		gzipMiddleware, err := compression.Middleware(compression.Config{
+			Level:         *gzipLevel,
+			MinSize:       *gzipMinSize,
+			ContentTypes:  strings.Split(*gzipTypes, ","),
+			ExcludedPaths: []string{"/api/download", "/api/binaries/download"},
+		}, metricsCollector.RecordCompression)
+		if err != nil {
+			stdlog.Fatalf("Invalid gzip configuration: %v", err)
+		}
+		router.Use(gzipMiddleware)
+	}
+
 	// Setup API routes with security
-	apiRouter := api.NewAPIRouter(wsManager, clusterMgr, alertMgr, registry)
+	apiRouter := api.NewAPIRouter(wsManager, clusterMgr, alertMgr, registry, scheduler, metricsCollector, tokenManager, auditLogger)
+	apiRouter.SetAgentRateLimiter(agentLimiter)
+	apiRouter.SetWebhookManager(webhooks)
+	apiRouter.SetRetentionManager(retentionMgr)
+	apiRouter.SetBinaryManager(binaryMgr)
+	if !*authDisabled {
+		apiRouter.SetPermissionManager(permManager)
+	}
 	apiRouter.SetupRoutes(router)
 
 	// Setup security routes
-	setupSecurityRoutes(router, tokenManager, permManager, auditLogger)
+	setupSecurityRoutes(router, tokenManager, permManager, auditLogger, *authDisabled)
 
 	// Setup metrics routes
 	metricsHandler := api.NewMetricsHandler(metricsCollector)
@@ -105,6 +545,9 @@ func main() {
 	binaryMgr.SetupBinaryRoutes(router)
 
 	// Create HTTP server
+	if listenAddr != "" {
+		*addr = listenAddr
+	}
 	srv := &http.Server{
 		Addr:    *addr,
 		Handler: router,
@@ -129,6 +572,26 @@ func main() {
 		}
 	}()
 
+	// Start gRPC agent service if enabled
+	var grpcServer *rpc.Server
+	if *grpcAddr != "" {
+		grpcServer = rpc.NewServer(registry, scheduler, metricsCollector, logger, tlsServer.GetTLSConfig())
+		grpcServer.SetRateLimiter(agentLimiter)
+		if !*authDisabled {
+			grpcServer.SetTokenManager(tokenManager)
+			grpcServer.SetPermissionManager(permManager)
+		}
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			stdlog.Fatalf("Failed to listen on gRPC address: %v", err)
+		}
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				stdlog.Fatalf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
 	protocol := "http"
 	if *enableTLS {
 		protocol = "https"
@@ -141,6 +604,16 @@ func main() {
 		fmt.Printf("Metrics endpoint: %s://localhost%s/metrics\n", protocol, *addr)
 	}
 	fmt.Printf("Web UI: %s://localhost%s/web/\n", protocol, *addr)
+	if *authDisabled {
+		fmt.Println("WARNING: authentication is disabled, the API is open to anyone who can reach it")
+	} else if *bootstrapAdmin || !tokenManager.HasAdminToken() {
+		if err := issueBootstrapToken(tokenManager, *bootstrapFile); err != nil {
+			stdlog.Fatalf("Failed to issue bootstrap admin token: %v", err)
+		}
+	}
+	if grpcServer != nil {
+		fmt.Printf("gRPC agent service: %s\n", *grpcAddr)
+	}
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -149,6 +622,10 @@ func main() {
 
 	fmt.Println("Shutting down server...")
 
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -159,8 +636,82 @@ func main() {
 	fmt.Println("Server exiting")
 }
 
+// issueBootstrapToken issues an admin token to create the other tokens
+// with. It is written to path if set, and otherwise shown once on the
+// terminal; it is never logged, and not issued at all when stdout is not a
+// terminal and no file is given, since it would end up in the service's
+// logs.
+func issueBootstrapToken(tokenManager *security.TokenManager, path string) error {
+	if path == "" {
+		if info, err := os.Stdout.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			fmt.Println("No admin token exists; restart with --bootstrap-token-file to issue one")
+			return nil
+		}
+	}
+
+	adminToken, err := tokenManager.CreateToken("bootstrap-admin", "", []string{"*"}, 0)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		fmt.Printf("Admin token (shown once): %s\n", adminToken.Token)
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(adminToken.Token+"\n"), 0600); err != nil {
+		tokenManager.RevokeTokenByID(adminToken.ID)
+		return err
+	}
+	// WriteFile keeps the mode of an existing file
+	if err := os.Chmod(path, 0600); err != nil {
+		tokenManager.RevokeTokenByID(adminToken.ID)
+		return err
+	}
+	fmt.Printf("Admin token written to %s\n", path)
+	return nil
+}
+
+// accessLogFormatter formats gin access logs with the request ID
+func accessLogFormatter(param gin.LogFormatterParams) string {
+	requestID, _ := param.Keys[security.RequestIDKey].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | req=%s\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		requestID,
+		param.ErrorMessage,
+	)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // setupSecurityRoutes sets up security-related routes
-func setupSecurityRoutes(router *gin.Engine, tokenManager *security.TokenManager, permManager *security.PermissionManager, auditLogger *security.AuditLogger) {
+func setupSecurityRoutes(router *gin.Engine, tokenManager *security.TokenManager, permManager *security.PermissionManager, auditLogger *security.AuditLogger, authDisabled bool) {
+	// Token, role and user management is admin-only unless auth is disabled
+	requirePermission := security.PermissionMiddleware(permManager)
+	require := func(resource, action string) gin.HandlerFunc {
+		if authDisabled {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return requirePermission(resource, action)
+	}
+	authenticate := func(c *gin.Context) { c.Next() }
+	if !authDisabled {
+		authenticate = security.TokenAuthMiddleware(tokenManager)
+	}
+
 	// Authentication routes
 	auth := router.Group("/api/auth")
 	{
@@ -168,49 +719,101 @@ func setupSecurityRoutes(router *gin.Engine, tokenManager *security.TokenManager
 			// TODO: Implement login logic
 			c.JSON(http.StatusOK, gin.H{"token": "dummy-token"})
 		})
+		// Logout revokes the caller's token, or with everywhere=true every
+		// active token of its owner; the token is rejected from then on
 		auth.POST("/logout", func(c *gin.Context) {
-			// TODO: Implement logout logic
-			c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+			everywhere := false
+			if raw := c.Query("everywhere"); raw != "" {
+				parsed, err := strconv.ParseBool(raw)
+				if err != nil {
+					apierror.Respond(c, apierror.InvalidRequest, "everywhere must be true or false")
+					return
+				}
+				everywhere = parsed
+			}
+
+			tokenInfo, err := tokenManager.ValidateRequestToken(c)
+			if err != nil {
+				apierror.Respond(c, apierror.Unauthorized, err.Error())
+				return
+			}
+
+			revoked := 0
+			if everywhere {
+				revoked, err = tokenManager.RevokeOwnerTokens(tokenInfo.Owner())
+			} else if err = tokenManager.RevokeToken(tokenInfo.Token); err == nil {
+				revoked = 1
+			}
+
+			result := "success"
+			if err != nil {
+				result = "failure"
+			}
+			auditLogger.LogLogout(c, tokenInfo, everywhere, revoked, result)
+			if err != nil {
+				apierror.Respond(c, apierror.Internal, "failed to revoke tokens: "+err.Error())
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{
+				"message": "logged out",
+				"revoked": revoked,
+			})
 		})
 	}
 
 	// Token management routes
-	tokens := router.Group("/api/tokens")
+	tokens := router.Group("/api/tokens", authenticate)
 	{
-		tokens.GET("/", func(c *gin.Context) {
-			tokenList := tokenManager.ListTokens()
-			c.JSON(http.StatusOK, gin.H{"tokens": tokenList})
+		tokens.GET("/", require("tokens", "read"), func(c *gin.Context) {
+			filter := security.TokenFilter{Status: c.Query("status"), AgentID: c.Query("agent_id")}
+			for param, target := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+				if value := c.Query(param); value != "" {
+					parsed, err := strconv.Atoi(value)
+					if err != nil {
+						apierror.Respond(c, apierror.InvalidRequest, "invalid "+param)
+						return
+					}
+					*target = parsed
+				}
+			}
+			tokenList, total, err := tokenManager.QueryTokens(filter)
+			if err != nil {
+				apierror.RespondError(c, err, apierror.InvalidRequest)
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"tokens": tokenList, "total": total, "offset": filter.Offset})
 		})
-		tokens.POST("/generate", func(c *gin.Context) {
+		tokens.POST("/generate", require("tokens", "create"), func(c *gin.Context) {
 			var req struct {
 				AgentID     string   `json:"agent_id"`
 				Permissions []string `json:"permissions"`
 			}
 			if err := c.ShouldBindJSON(&req); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
 			token, err := tokenManager.GenerateToken(req.AgentID, req.Permissions)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.Internal)
 				return
 			}
 
 			c.JSON(http.StatusOK, gin.H{"token": token})
 		})
-		tokens.POST("/rotate", func(c *gin.Context) {
+		tokens.POST("/rotate", require("tokens", "update"), func(c *gin.Context) {
 			var req struct {
 				OldToken string `json:"old_token"`
 			}
 			if err := c.ShouldBindJSON(&req); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
 			newToken, err := tokenManager.RotateToken(req.OldToken)
 			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
@@ -219,21 +822,21 @@ func setupSecurityRoutes(router *gin.Engine, tokenManager *security.TokenManager
 	}
 
 	// Role management routes
-	roles := router.Group("/api/roles")
+	roles := router.Group("/api/roles", authenticate)
 	{
-		roles.GET("/", func(c *gin.Context) {
+		roles.GET("/", require("roles", "read"), func(c *gin.Context) {
 			roleList := permManager.ListRoles()
 			c.JSON(http.StatusOK, gin.H{"roles": roleList})
 		})
-		roles.POST("/", func(c *gin.Context) {
+		roles.POST("/", require("roles", "create"), func(c *gin.Context) {
 			var role security.Role
 			if err := c.ShouldBindJSON(&role); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
 			if err := permManager.AddRole(&role); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
@@ -242,21 +845,21 @@ func setupSecurityRoutes(router *gin.Engine, tokenManager *security.TokenManager
 	}
 
 	// User management routes
-	users := router.Group("/api/users")
+	users := router.Group("/api/users", authenticate)
 	{
-		users.GET("/", func(c *gin.Context) {
+		users.GET("/", require("users", "read"), func(c *gin.Context) {
 			userList := permManager.ListUsers()
 			c.JSON(http.StatusOK, gin.H{"users": userList})
 		})
-		users.POST("/", func(c *gin.Context) {
+		users.POST("/", require("users", "create"), func(c *gin.Context) {
 			var user security.User
 			if err := c.ShouldBindJSON(&user); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
 			if err := permManager.AddUser(&user); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				apierror.RespondError(c, err, apierror.InvalidRequest)
 				return
 			}
 
@@ -264,22 +867,65 @@ func setupSecurityRoutes(router *gin.Engine, tokenManager *security.TokenManager
 		})
 	}
 
-	// Audit log routes
+	// Audit log routes; reading the audit log requires the audit:read
+	// permission and is itself audited
 	audit := router.Group("/api/audit")
+	audit.Use(security.TokenAuthMiddleware(tokenManager))
 	{
-		audit.GET("/logs", func(c *gin.Context) {
-			limit := 100
+		audit.GET("/logs", requirePermission("audit", "read"), func(c *gin.Context) {
+			filter := security.AuditFilter{
+				UserID:    c.Query("user"),
+				EventType: c.Query("event_type"),
+				Limit:     100,
+			}
 			if limitStr := c.Query("limit"); limitStr != "" {
-				fmt.Sscanf(limitStr, "%d", &limit)
+				limit, err := strconv.Atoi(limitStr)
+				if err != nil || limit <= 0 || limit > maxAuditQueryLimit {
+					apierror.Respond(c, apierror.InvalidRequest, fmt.Sprintf("limit must be between 1 and %d", maxAuditQueryLimit))
+					return
+				}
+				filter.Limit = limit
+			}
+			for param, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+				if value := c.Query(param); value != "" {
+					t, err := time.Parse(time.RFC3339, value)
+					if err != nil {
+						apierror.Respond(c, apierror.InvalidRequest, fmt.Sprintf("%s must be an RFC3339 time", param))
+						return
+					}
+					*target = t
+				}
 			}
 
-			logs, err := auditLogger.GetAuditLogs(limit)
+			logs, err := auditLogger.QueryAuditLogs(filter)
+			result := "success"
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				result = "failure"
+			}
+			auditLogger.LogEvent(&security.AuditEvent{
+				EventType: "data_access",
+				UserID:    c.GetString("user_id"),
+				IPAddress: c.ClientIP(),
+				UserAgent: c.GetHeader("User-Agent"),
+				Action:    "read",
+				Resource:  "audit_log",
+				Result:    result,
+				RequestID: security.RequestIDFromContext(c),
+				Details: map[string]interface{}{
+					"user":       filter.UserID,
+					"event_type": filter.EventType,
+					"from":       c.Query("from"),
+					"to":         c.Query("to"),
+					"limit":      filter.Limit,
+					"returned":   len(logs),
+				},
+			})
+			if err != nil {
+				apierror.RespondError(c, err, apierror.Internal)
 				return
 			}
 
-			c.JSON(http.StatusOK, gin.H{"logs": logs})
+			c.JSON(http.StatusOK, gin.H{"logs": logs, "total": len(logs)})
 		})
 	}
 }
@@ -307,3 +953,53 @@ func startMetricsServer(collector *metrics.MetricsCollector) {
 	}
 }
 
+// startClusterAlertEvaluator periodically evaluates cluster alert rules
+func startClusterAlertEvaluator(alertMgr *alert.AlertManager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		alertMgr.EvaluateClusterRules()
+	}
+}
+
+// startAgentMetricsUpdater periodically refreshes fleet-level agent gauges from the registry
+func startAgentMetricsUpdater(registry *core.Registry, collector *metrics.MetricsCollector) {
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		total, online, offline, late, flapping := 0, 0, 0, 0, 0
+		now := time.Now()
+		registered := make(map[string]bool)
+
+		for _, agent := range registry.List() {
+			total++
+			registered[agent.ID] = true
+			if agent.Status == "online" {
+				online++
+				// Agents more than two intervals behind are considered late
+				if now.Sub(agent.LastSeen) > 2**heartbeatInterval {
+					late++
+				}
+			} else {
+				offline++
+			}
+			if agent.Flapping {
+				flapping++
+			}
+		}
+
+		collector.UpdateAgentMetrics(total, online, offline)
+		collector.UpdateLateAgents(late)
+		collector.UpdateFlappingAgents(flapping)
+
+		// Agents removed through another server sharing the registry never
+		// reach this server's removal callbacks
+		if agents, series := collector.PruneAgentMetrics(func(agentID string) bool {
+			return registered[agentID]
+		}); len(agents) > 0 {
+			stdlog.Printf("Pruned %d metric series of %d agents no longer registered", series, len(agents))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Package alert provides the rules pushed to agents that evaluate alert
+// rules themselves, and raising alerts from the candidates they report.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// AgentRule is the part of an alert rule agents evaluate; actions stay on
+// the server
+type AgentRule struct {
+	ID         string           `json:"id"`
+	Severity   string           `json:"severity"`
+	Logic      string           `json:"logic,omitempty"`
+	Conditions []AlertCondition `json:"conditions,omitempty"`
+	Groups     []ConditionGroup `json:"groups,omitempty"`
+}
+
+// AgentRuleSet is the set of rules pushed to agents. Version is a hash of
+// the rules, equal on every server holding the same rules, so agents and
+// the server can tell when an agent's rules are stale.
+type AgentRuleSet struct {
+	Version string      `json:"version"`
+	Rules   []AgentRule `json:"rules"`
+}
+
+// Candidate is a rule an agent reports its metrics started matching, with
+// the values of the fields the rule looks at
+type Candidate struct {
+	RuleID    string                 `json:"rule_id"`
+	Data      map[string]interface{} `json:"data"`
+	MatchedAt time.Time              `json:"matched_at,omitempty"`
+}
+
+// AgentRules returns the rules agents evaluate: the enabled agent rules
+// that don't match on event, since event data such as inventory changes
+// only exists on the server
+func (am *AlertManager) AgentRules() AgentRuleSet {
+	am.mutex.RLock()
+	cached := am.agentRules
+	am.mutex.RUnlock()
+	if cached != nil {
+		return *cached
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	set := AgentRuleSet{Rules: []AgentRule{}}
+	for _, rule := range am.rules {
+		if !rule.Enabled || rule.Scope == ScopeCluster || usesField(rule.Conditions, rule.Groups, "event") {
+			continue
+		}
+		set.Rules = append(set.Rules, AgentRule{
+			ID:         rule.ID,
+			Severity:   rule.Severity,
+			Logic:      rule.Logic,
+			Conditions: rule.Conditions,
+			Groups:     rule.Groups,
+		})
+	}
+	sort.Slice(set.Rules, func(i, j int) bool { return set.Rules[i].ID < set.Rules[j].ID })
+
+	data, _ := json.Marshal(set.Rules)
+	sum := sha256.Sum256(data)
+	set.Version = hex.EncodeToString(sum[:8])
+
+	am.agentRules = &set
+	return set
+}
+
+// usesField reports whether any condition of the conditions and nested
+// groups looks at field
+func usesField(conditions []AlertCondition, groups []ConditionGroup, field string) bool {
+	for _, condition := range conditions {
+		if condition.Field == field {
+			return true
+		}
+	}
+	for _, group := range groups {
+		if usesField(group.Conditions, group.Groups, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// EvaluateCandidates raises alerts for the candidates an agent reports. Each
+// is checked against the current rule, so candidates of rules since
+// disabled, deleted or changed, or not backed by the reported values, are
+// dropped.
+func (am *AlertManager) EvaluateCandidates(agentID string, candidates []Candidate) int {
+	window := am.InMaintenance(agentID)
+
+	raised := 0
+	for _, candidate := range candidates {
+		am.mutex.RLock()
+		rule, exists := am.rules[candidate.RuleID]
+		am.mutex.RUnlock()
+
+		if !exists || !rule.Enabled || rule.Scope == ScopeCluster {
+			continue
+		}
+		if !am.evaluateRule(rule, agentID, candidate.Data) {
+			fmt.Printf("Dropped alert candidate of rule %s from agent %s: reported values don't match the rule\n", rule.ID, agentID)
+			continue
+		}
+
+		data := make(map[string]interface{}, len(candidate.Data)+1)
+		for key, value := range candidate.Data {
+			data[key] = value
+		}
+		data["evaluated_by"] = "agent"
+		am.raiseRuleAlert(rule, agentID, data, window)
+		raised++
+	}
+	return raised
+}
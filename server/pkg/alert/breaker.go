@@ -0,0 +1,166 @@
+// Package alert provides the circuit breakers that stop notifier deliveries
+// to endpoints that keep failing.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerFailures is the number of consecutive failed deliveries
+	// that open an endpoint's circuit
+	DefaultBreakerFailures = 5
+
+	// DefaultBreakerCooldown is how long an open circuit rejects deliveries
+	// before a probe is let through
+	DefaultBreakerCooldown = time.Minute
+)
+
+// Circuit states
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is returned for deliveries to an endpoint whose circuit is
+// open, without contacting it
+var ErrCircuitOpen = errors.New("circuit open: endpoint is failing, delivery skipped")
+
+// circuitBreaker tracks the deliveries to one endpoint. After failures
+// consecutive failed deliveries it opens and rejects deliveries for
+// cooldown; then a single probe is let through (half-open), whose outcome
+// closes or reopens it.
+type circuitBreaker struct {
+	endpoint string
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breakers holds a circuit breaker per endpoint URL, shared by all notifiers
+// so that notifiers created per alert action share an endpoint's state
+var breakers = struct {
+	sync.Mutex
+	byURL     map[string]*circuitBreaker
+	threshold int
+	cooldown  time.Duration
+}{
+	byURL:     make(map[string]*circuitBreaker),
+	threshold: DefaultBreakerFailures,
+	cooldown:  DefaultBreakerCooldown,
+}
+
+// SetCircuitBreaker sets after how many consecutive failed deliveries an
+// endpoint's circuit opens, and how long it stays open before a probe. A
+// threshold of 0 disables circuit breaking.
+func SetCircuitBreaker(failures int, cooldown time.Duration) error {
+	if failures < 0 {
+		return fmt.Errorf("failure threshold must not be negative")
+	}
+	if failures > 0 && cooldown <= 0 {
+		return fmt.Errorf("cooldown must be positive")
+	}
+
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	breakers.threshold = failures
+	breakers.cooldown = cooldown
+	return nil
+}
+
+// breakerFor returns the circuit breaker of an endpoint, nil when circuit
+// breaking is disabled
+func breakerFor(rawURL string) *circuitBreaker {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	if breakers.threshold == 0 {
+		return nil
+	}
+	b, ok := breakers.byURL[rawURL]
+	if !ok {
+		b = &circuitBreaker{endpoint: endpointName(rawURL), state: CircuitClosed}
+		breakers.byURL[rawURL] = b
+	}
+	return b
+}
+
+// allow reports whether a delivery may be attempted, and whether it is the
+// probe of a half-open circuit
+func (b *circuitBreaker) allow() (bool, bool) {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < breakers.cooldown {
+			return false, false
+		}
+		b.transition(CircuitHalfOpen)
+		b.probing = true
+		return true, true
+	case CircuitHalfOpen:
+		// Only one probe at a time
+		if b.probing {
+			return false, false
+		}
+		b.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// record updates the circuit with the outcome of a delivery
+func (b *circuitBreaker) record(err error) {
+	breakers.Lock()
+	defer breakers.Unlock()
+
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+		if b.state != CircuitClosed {
+			b.transition(CircuitClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || (b.state == CircuitClosed && b.failures >= breakers.threshold) {
+		b.openedAt = time.Now()
+		b.transition(CircuitOpen)
+	}
+}
+
+// transition changes the circuit's state and logs it; caller must hold
+// breakers
+func (b *circuitBreaker) transition(state string) {
+	switch state {
+	case CircuitOpen:
+		fmt.Printf("Notifier circuit for %s %s -> %s after %d consecutive failures; retrying in %v\n",
+			b.endpoint, b.state, state, b.failures, breakers.cooldown)
+	default:
+		fmt.Printf("Notifier circuit for %s %s -> %s\n", b.endpoint, b.state, state)
+	}
+	b.state = state
+}
+
+// endpointName identifies an endpoint in logs by its host, as webhook URLs
+// usually embed their secret in the path
+func endpointName(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Host == "" {
+		return "webhook"
+	}
+	return parsed.Host
+}
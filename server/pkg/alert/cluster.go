@@ -0,0 +1,245 @@
+// Package alert provides cluster-scoped alert rules evaluated against
+// aggregates of the member agents.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Rule scopes: agent rules are evaluated against the data of each agent as
+// it arrives, cluster rules periodically against cluster aggregates
+const (
+	ScopeAgent   = "agent"
+	ScopeCluster = "cluster"
+)
+
+// DefaultClusterEvaluationInterval is how often cluster rules are evaluated
+const DefaultClusterEvaluationInterval = time.Minute
+
+// ClusterMember is the state of a cluster's member agent that cluster rules
+// aggregate
+type ClusterMember struct {
+	AgentID string
+	Online  bool
+
+	// Usage holds the agent's last reported utilization, e.g. cpu_usage;
+	// nil if it hasn't reported any
+	Usage map[string]float64
+}
+
+// SetClusterSource sets the lookup of the members of every cluster, keyed
+// by cluster ID, that cluster rules are evaluated against
+func (am *AlertManager) SetClusterSource(source func() map[string][]ClusterMember) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.clusterSource = source
+}
+
+// AggregateCluster computes the data cluster rules are evaluated against:
+// agents_total, agents_online, agents_offline and offline_percent, plus
+// <metric>_avg, <metric>_min and <metric>_max for each usage metric over the
+// online members reporting it. An empty cluster is 0% offline and has no
+// usage aggregates, so usage conditions don't match it.
+func AggregateCluster(members []ClusterMember) map[string]interface{} {
+	online := 0
+	sums := make(map[string]float64)
+	mins := make(map[string]float64)
+	maxes := make(map[string]float64)
+	counts := make(map[string]int)
+
+	for _, member := range members {
+		if !member.Online {
+			continue
+		}
+		online++
+		for metric, value := range member.Usage {
+			if counts[metric] == 0 || value < mins[metric] {
+				mins[metric] = value
+			}
+			if counts[metric] == 0 || value > maxes[metric] {
+				maxes[metric] = value
+			}
+			sums[metric] += value
+			counts[metric]++
+		}
+	}
+
+	total := len(members)
+	offlinePercent := 0.0
+	if total > 0 {
+		offlinePercent = float64(total-online) / float64(total) * 100
+	}
+
+	// Counts are float64 like the numbers decoded from rule JSON, so eq matches
+	data := map[string]interface{}{
+		"agents_total":    float64(total),
+		"agents_online":   float64(online),
+		"agents_offline":  float64(total - online),
+		"offline_percent": offlinePercent,
+	}
+	for metric, count := range counts {
+		data[metric+"_avg"] = sums[metric] / float64(count)
+		data[metric+"_min"] = mins[metric]
+		data[metric+"_max"] = maxes[metric]
+	}
+	return data
+}
+
+// EvaluateClusterRules evaluates the enabled cluster rules against the
+// aggregates of each cluster they target. A rule raises one alert per
+// cluster while its conditions hold, resolved once they no longer do.
+func (am *AlertManager) EvaluateClusterRules() {
+	am.mutex.RLock()
+	source := am.clusterSource
+	rules := make([]*AlertRule, 0, len(am.rules))
+	for _, rule := range am.rules {
+		if rule.Enabled && rule.Scope == ScopeCluster {
+			rules = append(rules, rule)
+		}
+	}
+	am.mutex.RUnlock()
+
+	if source == nil || len(rules) == 0 {
+		return
+	}
+
+	// Resolve outside the lock, the source belongs to other managers
+	clusters := source()
+	clusterIDs := make([]string, 0, len(clusters))
+	for id := range clusters {
+		clusterIDs = append(clusterIDs, id)
+	}
+	sort.Strings(clusterIDs)
+
+	for _, clusterID := range clusterIDs {
+		data := AggregateCluster(clusters[clusterID])
+		data["cluster_id"] = clusterID
+
+		for _, rule := range rules {
+			if !rule.targetsCluster(clusterID) {
+				continue
+			}
+			if am.evaluateRule(rule, "", data) {
+				am.raiseClusterAlert(rule, clusterID, data)
+			} else {
+				am.resolveClusterAlert(rule.ID, clusterID)
+			}
+		}
+	}
+}
+
+// raiseClusterAlert raises an alert for a cluster rule unless one is still
+// active for the cluster
+func (am *AlertManager) raiseClusterAlert(rule *AlertRule, clusterID string, data map[string]interface{}) {
+	key := rule.ID + "/" + clusterID
+
+	am.mutex.RLock()
+	firing, exists := am.alerts[am.clusterAlerts[key]]
+	am.mutex.RUnlock()
+	if exists && firing.Status != "resolved" {
+		return
+	}
+
+	now := time.Now()
+	alert := &Alert{
+		ID:        fmt.Sprintf("%s-%s-%d", rule.ID, clusterID, now.Unix()),
+		RuleID:    rule.ID,
+		ClusterID: clusterID,
+		Severity:  rule.Severity,
+		Status:    "active",
+		Message:   rule.Description,
+		Data:      data,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	// Record but don't notify during maintenance of the cluster
+	window := am.clusterInMaintenance(clusterID)
+	if window != nil {
+		alert.Status = "suppressed"
+	}
+
+	am.mutex.Lock()
+	am.clusterAlerts[key] = alert.ID
+	am.mutex.Unlock()
+
+	if err := am.createAlert(alert); err != nil {
+		fmt.Printf("Failed to create alert: %v\n", err)
+	}
+
+	if window == nil {
+		am.executeActions(rule.Actions, alert)
+	}
+}
+
+// resolveClusterAlert resolves the alert a cluster rule raised for a cluster
+// once its conditions no longer hold
+func (am *AlertManager) resolveClusterAlert(ruleID, clusterID string) {
+	key := ruleID + "/" + clusterID
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	alertID, exists := am.clusterAlerts[key]
+	if !exists {
+		return
+	}
+	delete(am.clusterAlerts, key)
+
+	if alert, exists := am.alerts[alertID]; exists && alert.Status != "resolved" {
+		now := time.Now()
+		alert.Status = "resolved"
+		alert.UpdatedAt = now
+		alert.ResolvedAt = &now
+	}
+}
+
+// clusterInMaintenance returns the active maintenance window covering the
+// cluster, or nil
+func (am *AlertManager) clusterInMaintenance(clusterID string) *MaintenanceWindow {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	now := time.Now()
+	for _, window := range am.windows {
+		if window.Active(now) && window.Covers("", []string{clusterID}) {
+			return window
+		}
+	}
+
+	return nil
+}
+
+// targetsCluster reports whether a cluster rule applies to a cluster; rules
+// without clusters apply to all of them
+func (r *AlertRule) targetsCluster(clusterID string) bool {
+	if len(r.Clusters) == 0 {
+		return true
+	}
+	for _, id := range r.Clusters {
+		if id == clusterID {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScope checks a rule's scope and that only cluster rules target clusters
+func validateScope(scope string, clusters []string) error {
+	switch scope {
+	case "", ScopeAgent:
+		if len(clusters) > 0 {
+			return fmt.Errorf("clusters can only be set on cluster rules")
+		}
+	case ScopeCluster:
+	default:
+		return fmt.Errorf("invalid scope %q: must be %q or %q", scope, ScopeAgent, ScopeCluster)
+	}
+	return nil
+}
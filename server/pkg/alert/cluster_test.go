@@ -0,0 +1,137 @@
+package alert
+
+import "testing"
+
+func TestAggregateCluster(t *testing.T) {
+	members := []ClusterMember{
+		{AgentID: "a", Online: true, Usage: map[string]float64{"cpu_usage": 20, "memory_usage": 50}},
+		{AgentID: "b", Online: true, Usage: map[string]float64{"cpu_usage": 80}},
+		{AgentID: "c", Online: true, Usage: map[string]float64{"cpu_usage": 50}},
+		{AgentID: "d", Online: true},
+		// Offline members count towards the totals but not the usage
+		{AgentID: "e", Online: false, Usage: map[string]float64{"cpu_usage": 100, "memory_usage": 100}},
+	}
+
+	data := AggregateCluster(members)
+	want := map[string]float64{
+		"agents_total":     5,
+		"agents_online":    4,
+		"agents_offline":   1,
+		"offline_percent":  20,
+		"cpu_usage_avg":    50,
+		"cpu_usage_min":    20,
+		"cpu_usage_max":    80,
+		"memory_usage_avg": 50,
+		"memory_usage_min": 50,
+		"memory_usage_max": 50,
+	}
+	for key, value := range want {
+		got, ok := data[key].(float64)
+		if !ok {
+			t.Errorf("%s = %v (%T), want float64 %v", key, data[key], data[key], value)
+			continue
+		}
+		if got != value {
+			t.Errorf("%s = %v, want %v", key, got, value)
+		}
+	}
+	if len(data) != len(want) {
+		t.Errorf("got %d aggregates, want %d: %v", len(data), len(want), data)
+	}
+}
+
+func TestAggregateClusterNegativeAndSingle(t *testing.T) {
+	tests := []struct {
+		name          string
+		values        []float64
+		min, max, avg float64
+	}{
+		{"single", []float64{42}, 42, 42, 42},
+		{"negative", []float64{-5, -1, -3}, -5, -1, -3},
+		{"zero first", []float64{0, 10, 5}, 0, 10, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var members []ClusterMember
+			for _, value := range tt.values {
+				members = append(members, ClusterMember{Online: true, Usage: map[string]float64{"temp": value}})
+			}
+			data := AggregateCluster(members)
+			if data["temp_min"] != tt.min || data["temp_max"] != tt.max || data["temp_avg"] != tt.avg {
+				t.Errorf("min/max/avg = %v/%v/%v, want %v/%v/%v",
+					data["temp_min"], data["temp_max"], data["temp_avg"], tt.min, tt.max, tt.avg)
+			}
+		})
+	}
+}
+
+func TestAggregateClusterEmpty(t *testing.T) {
+	tests := []struct {
+		name           string
+		members        []ClusterMember
+		total, offline float64
+		percent        float64
+	}{
+		{"no members", nil, 0, 0, 0},
+		{"all offline", []ClusterMember{
+			{AgentID: "a", Usage: map[string]float64{"cpu_usage": 90}},
+			{AgentID: "b"},
+		}, 2, 2, 100},
+		{"online without usage", []ClusterMember{{AgentID: "a", Online: true}}, 1, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := AggregateCluster(tt.members)
+			if data["agents_total"] != tt.total || data["agents_offline"] != tt.offline || data["offline_percent"] != tt.percent {
+				t.Errorf("total/offline/percent = %v/%v/%v, want %v/%v/%v",
+					data["agents_total"], data["agents_offline"], data["offline_percent"], tt.total, tt.offline, tt.percent)
+			}
+			if _, ok := data["cpu_usage_avg"]; ok {
+				t.Errorf("usage aggregated without online members reporting it: %v", data)
+			}
+		})
+	}
+}
+
+// Usage conditions don't match a cluster nobody reports usage for, while
+// conditions on the counts still do
+func TestEvaluateClusterRulesEmptyCluster(t *testing.T) {
+	am := NewAlertManager()
+	rules := []*AlertRule{
+		{ID: "busy", Enabled: true, Severity: "warning", Scope: ScopeCluster,
+			Conditions: []AlertCondition{cond("cpu_usage_avg", "lt", 1000.0)}},
+		{ID: "down", Enabled: true, Severity: "critical", Scope: ScopeCluster,
+			Conditions: []AlertCondition{cond("offline_percent", "gte", 50.0)}},
+		{ID: "empty", Enabled: true, Severity: "info", Scope: ScopeCluster,
+			Conditions: []AlertCondition{cond("agents_total", "eq", 0.0)}},
+	}
+	for _, rule := range rules {
+		if err := am.AddAlertRule(rule); err != nil {
+			t.Fatalf("AddAlertRule(%s): %v", rule.ID, err)
+		}
+	}
+	am.SetClusterSource(func() map[string][]ClusterMember {
+		return map[string][]ClusterMember{
+			"empty":   nil,
+			"offline": {{AgentID: "a"}, {AgentID: "b", Online: true}},
+		}
+	})
+
+	am.EvaluateClusterRules()
+
+	fired := make(map[string]bool)
+	for _, alert := range am.ListAlerts() {
+		fired[alert.RuleID+"/"+alert.ClusterID] = true
+	}
+	want := map[string]bool{"empty/empty": true, "down/offline": true}
+	for key := range want {
+		if !fired[key] {
+			t.Errorf("%s didn't fire", key)
+		}
+	}
+	for key := range fired {
+		if !want[key] {
+			t.Errorf("%s fired", key)
+		}
+	}
+}
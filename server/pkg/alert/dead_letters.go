@@ -0,0 +1,71 @@
+// Package alert provides the dead-letter log of notifications that could
+// not be delivered.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxDeadLetters caps the notification dead-letter log
+const maxDeadLetters = 200
+
+// DeadLetter records a notification a notifier failed to deliver.
+// CircuitOpen is set when it was skipped because the endpoint's circuit was
+// open.
+type DeadLetter struct {
+	Notifier    string    `json:"notifier"`
+	AlertID     string    `json:"alert_id"`
+	RuleID      string    `json:"rule_id,omitempty"`
+	AgentID     string    `json:"agent_id,omitempty"`
+	Severity    string    `json:"severity"`
+	Error       string    `json:"error"`
+	CircuitOpen bool      `json:"circuit_open,omitempty"`
+	FailedAt    time.Time `json:"failed_at"`
+}
+
+// sendNotification sends an alert through a notifier, logging a failure and
+// recording it in the dead-letter log
+func (am *AlertManager) sendNotification(notifier Notifier, alert *Alert) {
+	err := notifier.Send(alert)
+	if err == nil {
+		return
+	}
+	fmt.Printf("Notifier %s failed for alert %s: %v\n", notifier.Name(), alert.ID, err)
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.deadLetters = append(am.deadLetters, DeadLetter{
+		Notifier:    notifier.Name(),
+		AlertID:     alert.ID,
+		RuleID:      alert.RuleID,
+		AgentID:     alert.AgentID,
+		Severity:    alert.Severity,
+		Error:       err.Error(),
+		CircuitOpen: errors.Is(err, ErrCircuitOpen),
+		FailedAt:    time.Now(),
+	})
+	if len(am.deadLetters) > maxDeadLetters {
+		am.deadLetters = append([]DeadLetter{}, am.deadLetters[len(am.deadLetters)-maxDeadLetters:]...)
+	}
+}
+
+// DeadLetters returns the notifications that failed, oldest first,
+// optionally only those of one notifier
+func (am *AlertManager) DeadLetters(notifier string) []DeadLetter {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	letters := []DeadLetter{}
+	for _, letter := range am.deadLetters {
+		if notifier == "" || letter.Notifier == notifier {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
@@ -0,0 +1,212 @@
+// Package alert provides maintenance windows that suppress alert notifications.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"fmt"
+	"time"
+)
+
+// MaintenanceWindow suppresses notifications for its target agents and
+// clusters between StartsAt and EndsAt
+type MaintenanceWindow struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason,omitempty"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Agents    []string  `json:"agents,omitempty"`
+	Clusters  []string  `json:"clusters,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the window has a valid time range and at least one target
+func (w *MaintenanceWindow) Validate() error {
+	if w.StartsAt.IsZero() || w.EndsAt.IsZero() {
+		return fmt.Errorf("starts_at and ends_at are required")
+	}
+	if !w.EndsAt.After(w.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	if len(w.Agents) == 0 && len(w.Clusters) == 0 {
+		return fmt.Errorf("maintenance window must target at least one agent or cluster")
+	}
+	return nil
+}
+
+// Active reports whether the window is in effect at t
+func (w *MaintenanceWindow) Active(t time.Time) bool {
+	return !t.Before(w.StartsAt) && t.Before(w.EndsAt)
+}
+
+// Covers reports whether the window targets the agent or one of its clusters
+func (w *MaintenanceWindow) Covers(agentID string, clusterIDs []string) bool {
+	for _, id := range w.Agents {
+		if id == agentID {
+			return true
+		}
+	}
+	for _, id := range w.Clusters {
+		for _, clusterID := range clusterIDs {
+			if id == clusterID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetClusterResolver sets the lookup used to match agents against
+// cluster-targeted maintenance windows
+func (am *AlertManager) SetClusterResolver(resolver func(agentID string) []string) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.clusterResolver = resolver
+}
+
+// AddMaintenanceWindow schedules a new maintenance window
+func (am *AlertManager) AddMaintenanceWindow(window *MaintenanceWindow) error {
+	if err := window.Validate(); err != nil {
+		return err
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	now := time.Now()
+	am.pruneMaintenanceWindowsLocked(now)
+
+	if window.ID == "" {
+		window.ID = fmt.Sprintf("mw-%d", now.UnixNano())
+	}
+	if _, exists := am.windows[window.ID]; exists {
+		return fmt.Errorf("maintenance window %s already exists", window.ID)
+	}
+	if !window.EndsAt.After(now) {
+		return fmt.Errorf("maintenance window %s has already ended", window.ID)
+	}
+
+	window.CreatedAt = now
+	window.UpdatedAt = now
+	am.windows[window.ID] = window
+
+	return nil
+}
+
+// GetMaintenanceWindow retrieves a maintenance window by ID
+func (am *AlertManager) GetMaintenanceWindow(id string) (*MaintenanceWindow, error) {
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	window, exists := am.windows[id]
+	if !exists || !window.EndsAt.After(time.Now()) {
+		return nil, fmt.Errorf("maintenance window %s not found", id)
+	}
+
+	return window, nil
+}
+
+// ListMaintenanceWindows returns scheduled and active maintenance windows
+func (am *AlertManager) ListMaintenanceWindows() []*MaintenanceWindow {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.pruneMaintenanceWindowsLocked(time.Now())
+
+	windows := make([]*MaintenanceWindow, 0, len(am.windows))
+	for _, window := range am.windows {
+		windows = append(windows, window)
+	}
+
+	return windows
+}
+
+// UpdateMaintenanceWindow updates an existing maintenance window
+func (am *AlertManager) UpdateMaintenanceWindow(id string, updates *MaintenanceWindow) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	window, exists := am.windows[id]
+	if !exists {
+		return fmt.Errorf("maintenance window %s not found", id)
+	}
+
+	updated := *window
+	if updates.Name != "" {
+		updated.Name = updates.Name
+	}
+	if updates.Reason != "" {
+		updated.Reason = updates.Reason
+	}
+	if !updates.StartsAt.IsZero() {
+		updated.StartsAt = updates.StartsAt
+	}
+	if !updates.EndsAt.IsZero() {
+		updated.EndsAt = updates.EndsAt
+	}
+	if updates.Agents != nil {
+		updated.Agents = updates.Agents
+	}
+	if updates.Clusters != nil {
+		updated.Clusters = updates.Clusters
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	updated.UpdatedAt = time.Now()
+	*window = updated
+
+	return nil
+}
+
+// DeleteMaintenanceWindow removes a maintenance window, ending it early
+func (am *AlertManager) DeleteMaintenanceWindow(id string) error {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	if _, exists := am.windows[id]; !exists {
+		return fmt.Errorf("maintenance window %s not found", id)
+	}
+
+	delete(am.windows, id)
+	return nil
+}
+
+// InMaintenance returns the active maintenance window covering the agent, or nil
+func (am *AlertManager) InMaintenance(agentID string) *MaintenanceWindow {
+	am.mutex.RLock()
+	resolver := am.clusterResolver
+	am.mutex.RUnlock()
+
+	// Resolve outside the lock, the resolver belongs to another manager
+	var clusterIDs []string
+	if resolver != nil {
+		clusterIDs = resolver(agentID)
+	}
+
+	am.mutex.RLock()
+	defer am.mutex.RUnlock()
+
+	now := time.Now()
+	for _, window := range am.windows {
+		if window.Active(now) && window.Covers(agentID, clusterIDs) {
+			return window
+		}
+	}
+
+	return nil
+}
+
+// pruneMaintenanceWindowsLocked drops windows that have ended; caller must hold am.mutex
+func (am *AlertManager) pruneMaintenanceWindowsLocked(now time.Time) {
+	for id, window := range am.windows {
+		if !window.EndsAt.After(now) {
+			delete(am.windows, id)
+		}
+	}
+}
@@ -5,7 +5,12 @@
 package alert
 
 import (
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,6 +21,27 @@ type AlertManager struct {
 	rules     map[string]*AlertRule
 	mutex     sync.RWMutex
 	notifiers map[string]Notifier
+
+	// Maintenance windows suppress notifications, see InMaintenance
+	windows         map[string]*MaintenanceWindow
+	clusterResolver func(agentID string) []string
+
+	// Cluster rules aggregate the members from clusterSource; clusterAlerts
+	// maps rule/cluster to the alert raised for it, see EvaluateClusterRules
+	clusterSource func() map[string][]ClusterMember
+	clusterAlerts map[string]string
+
+	// Compiled regex condition patterns, see compilePattern
+	patterns sync.Map
+
+	// Callbacks for new alerts, see OnAlert
+	alertHandlers []func(alert *Alert)
+
+	// Rules pushed to agents, cached until the rules change, see AgentRules
+	agentRules *AgentRuleSet
+
+	// Notifications that failed, see DeadLetters
+	deadLetters []DeadLetter
 }
 
 // Alert represents an alert instance
@@ -44,10 +70,39 @@ type AlertRule struct {
 	Actions     []AlertAction          `json:"actions"`
 	CreatedAt   time.Time              `json:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at"`
+
+	// Logic combines Conditions and Groups: "and" (default) or "or"
+	Logic  string           `json:"logic,omitempty"`
+	Groups []ConditionGroup `json:"groups,omitempty"`
+
+	// Scope is "agent" (default) or "cluster"; cluster rules are evaluated
+	// against the aggregates of the Clusters they target, or of all clusters
+	Scope    string   `json:"scope,omitempty"`
+	Clusters []string `json:"clusters,omitempty"`
+
+	// Source names the file a rule was loaded from, see LoadRules; rules
+	// created over the API have none
+	Source string `json:"source,omitempty"`
+}
+
+// Condition group logic operators
+const (
+	LogicAnd = "and"
+	LogicOr  = "or"
+)
+
+// ConditionGroup nests conditions and groups combined with its own logic,
+// e.g. (cpu > 90 AND mem > 90) as one term of an OR rule
+type ConditionGroup struct {
+	Logic      string           `json:"logic,omitempty"`
+	Conditions []AlertCondition `json:"conditions,omitempty"`
+	Groups     []ConditionGroup `json:"groups,omitempty"`
 }
 
 // AlertCondition defines a single condition
 type AlertCondition struct {
+	// Field is a key of the evaluated data; dots address nested values,
+	// e.g. custom.myplugin.queue_depth
 	Field    string      `json:"field"`
 	Operator string      `json:"operator"`
 	Value    interface{} `json:"value"`
@@ -69,9 +124,11 @@ type Notifier interface {
 // NewAlertManager creates a new alert manager
 func NewAlertManager() *AlertManager {
 	return &AlertManager{
-		alerts:    make(map[string]*Alert),
-		rules:     make(map[string]*AlertRule),
-		notifiers: make(map[string]Notifier),
+		alerts:        make(map[string]*Alert),
+		rules:         make(map[string]*AlertRule),
+		notifiers:     make(map[string]Notifier),
+		windows:       make(map[string]*MaintenanceWindow),
+		clusterAlerts: make(map[string]string),
 	}
 }
 
@@ -84,9 +141,18 @@ func (am *AlertManager) AddAlertRule(rule *AlertRule) error {
 		return fmt.Errorf("alert rule %s already exists", rule.ID)
 	}
 
+	if err := am.validateRuleLocked(rule); err != nil {
+		return err
+	}
+
+	// Compile regex patterns up front so invalid ones are reported at creation
+	am.compileGroupPatterns(rule.Conditions, rule.Groups)
+
+	rule.Source = ""
 	rule.CreatedAt = time.Now()
 	rule.UpdatedAt = time.Now()
 	am.rules[rule.ID] = rule
+	am.agentRules = nil
 
 	return nil
 }
@@ -127,6 +193,14 @@ func (am *AlertManager) UpdateAlertRule(id string, updates map[string]interface{
 		return fmt.Errorf("alert rule %s not found", id)
 	}
 
+	// Checked before any field changes, so a rejected update changes nothing
+	if severity, ok := updates["severity"]; ok {
+		s, _ := severity.(string)
+		if err := validateSeverity(s); err != nil {
+			return err
+		}
+	}
+
 	// Update fields
 	if name, ok := updates["name"].(string); ok {
 		rule.Name = name
@@ -142,10 +216,74 @@ func (am *AlertManager) UpdateAlertRule(id string, updates map[string]interface{
 	}
 
 	rule.UpdatedAt = time.Now()
+	am.agentRules = nil
 
 	return nil
 }
 
+// SetAlertRuleEnabled enables or disables an alert rule
+func (am *AlertManager) SetAlertRuleEnabled(id string, enabled bool) (*AlertRule, error) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	rule, exists := am.rules[id]
+	if !exists {
+		return nil, fmt.Errorf("alert rule %s not found", id)
+	}
+
+	rule.Enabled = enabled
+	rule.UpdatedAt = time.Now()
+	am.agentRules = nil
+
+	return rule, nil
+}
+
+// GroupResult reports how a condition group evaluated against sample data
+type GroupResult struct {
+	Logic      string            `json:"logic"`
+	Matched    bool              `json:"matched"`
+	Conditions []ConditionResult `json:"conditions,omitempty"`
+	Groups     []GroupResult     `json:"groups,omitempty"`
+}
+
+// ConditionResult reports how a single condition evaluated against sample data
+type ConditionResult struct {
+	Condition AlertCondition `json:"condition"`
+	Matched   bool           `json:"matched"`
+	Value     interface{}    `json:"value,omitempty"`
+	Missing   bool           `json:"missing,omitempty"`
+}
+
+// RuleTestResult reports whether a rule would fire for sample data
+type RuleTestResult struct {
+	RuleID     string            `json:"rule_id"`
+	Enabled    bool              `json:"enabled"`
+	WouldFire  bool              `json:"would_fire"`
+	Logic      string            `json:"logic"`
+	Conditions []ConditionResult `json:"conditions"`
+	Groups     []GroupResult     `json:"groups,omitempty"`
+}
+
+// TestAlertRule evaluates a rule against sample data without creating an
+// alert or running its actions. Disabled rules are evaluated as well.
+func (am *AlertManager) TestAlertRule(id string, data map[string]interface{}) (*RuleTestResult, error) {
+	rule, err := am.GetAlertRule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	group := am.evaluateGroup(rule.Logic, rule.Conditions, rule.Groups, data)
+
+	return &RuleTestResult{
+		RuleID:     rule.ID,
+		Enabled:    rule.Enabled,
+		WouldFire:  group.Matched,
+		Logic:      group.Logic,
+		Conditions: group.Conditions,
+		Groups:     group.Groups,
+	}, nil
+}
+
 // DeleteAlertRule removes an alert rule
 func (am *AlertManager) DeleteAlertRule(id string) error {
 	am.mutex.Lock()
@@ -156,97 +294,295 @@ func (am *AlertManager) DeleteAlertRule(id string) error {
 	}
 
 	delete(am.rules, id)
+	am.agentRules = nil
 	return nil
 }
 
-// EvaluateRules evaluates all enabled alert rules against agent data
+// EvaluateRules evaluates all enabled agent rules against agent data
 func (am *AlertManager) EvaluateRules(agentID string, data map[string]interface{}) error {
 	am.mutex.RLock()
 	rules := make([]*AlertRule, 0, len(am.rules))
 	for _, rule := range am.rules {
-		if rule.Enabled {
+		if rule.Enabled && rule.Scope != ScopeCluster {
 			rules = append(rules, rule)
 		}
 	}
 	am.mutex.RUnlock()
 
+	window := am.InMaintenance(agentID)
+
 	for _, rule := range rules {
 		if am.evaluateRule(rule, agentID, data) {
-			alert := &Alert{
-				ID:        fmt.Sprintf("%s-%d", rule.ID, time.Now().Unix()),
-				RuleID:    rule.ID,
-				AgentID:   agentID,
-				Severity:  rule.Severity,
-				Status:    "active",
-				Message:   rule.Description,
-				Data:      data,
-				CreatedAt: time.Now(),
-				UpdatedAt: time.Now(),
-			}
-
-			if err := am.createAlert(alert); err != nil {
-				fmt.Printf("Failed to create alert: %v\n", err)
-			}
-
-			// Execute actions
-			am.executeActions(rule.Actions, alert)
+			am.raiseRuleAlert(rule, agentID, data, window)
 		}
 	}
 
 	return nil
 }
 
+// raiseRuleAlert records an alert for a rule that matched and runs its
+// actions, unless the agent is in a maintenance window
+func (am *AlertManager) raiseRuleAlert(rule *AlertRule, agentID string, data map[string]interface{}, window *MaintenanceWindow) {
+	// Agents matching the rule at the same time each get their own alert
+	alert := &Alert{
+		ID:        fmt.Sprintf("%s-%s-%d", rule.ID, agentID, time.Now().UnixNano()),
+		RuleID:    rule.ID,
+		AgentID:   agentID,
+		Severity:  rule.Severity,
+		Status:    "active",
+		Message:   rule.Description,
+		Data:      data,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	// Record but don't notify during maintenance
+	if window != nil {
+		alert.Status = "suppressed"
+	}
+
+	if err := am.createAlert(alert); err != nil {
+		fmt.Printf("Failed to create alert: %v\n", err)
+	}
+
+	if window != nil {
+		return
+	}
+
+	// Execute actions
+	am.executeActions(rule.Actions, alert)
+}
+
+// AgentOffline raises an alert for an agent that stopped sending heartbeats
+// and notifies the registered notifiers, unless the agent is in maintenance
+func (am *AlertManager) AgentOffline(agentID string) {
+	now := time.Now()
+	alert := &Alert{
+		ID:        fmt.Sprintf("agent-offline-%s-%d", agentID, now.Unix()),
+		RuleID:    "agent-offline",
+		AgentID:   agentID,
+		Severity:  "warning",
+		Status:    "active",
+		Message:   fmt.Sprintf("Agent %s is offline", agentID),
+		Data:      map[string]interface{}{"status": "offline"},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	window := am.InMaintenance(agentID)
+	if window != nil {
+		alert.Status = "suppressed"
+	}
+
+	if err := am.createAlert(alert); err != nil {
+		fmt.Printf("Failed to create alert: %v\n", err)
+	}
+
+	if window == nil {
+		am.notify(alert)
+	}
+}
+
+
+// TokenNewIP raises an alert for a token used from an IP it has not been
+// seen from recently, which may mean it was stolen, and notifies the
+// registered notifiers
+func (am *AlertManager) TokenNewIP(tokenID, tokenName, agentID, ip string, knownIPs []string) {
+	now := time.Now()
+	alert := &Alert{
+		ID:       fmt.Sprintf("token-new-ip-%s-%d", tokenID, now.UnixNano()),
+		RuleID:   "token-new-ip",
+		AgentID:  agentID,
+		Severity: "warning",
+		Status:   "active",
+		Message:  fmt.Sprintf("Token %s used from new IP %s", tokenID, ip),
+		Data: map[string]interface{}{
+			"token_id":   tokenID,
+			"token_name": tokenName,
+			"ip":         ip,
+			"known_ips":  knownIPs,
+		},
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := am.createAlert(alert); err != nil {
+		fmt.Printf("Failed to create alert: %v\n", err)
+	}
+	am.notify(alert)
+}
+
+// notify sends an alert to all registered notifiers
+func (am *AlertManager) notify(alert *Alert) {
+	am.mutex.RLock()
+	notifiers := make([]Notifier, 0, len(am.notifiers))
+	for _, notifier := range am.notifiers {
+		notifiers = append(notifiers, notifier)
+	}
+	am.mutex.RUnlock()
+
+	for _, notifier := range notifiers {
+		am.sendNotification(notifier, alert)
+	}
+}
+
 // evaluateRule checks if a rule condition is met
 func (am *AlertManager) evaluateRule(rule *AlertRule, agentID string, data map[string]interface{}) bool {
-	for _, condition := range rule.Conditions {
-		if !am.evaluateCondition(condition, data) {
-			return false
+	return am.evaluateGroup(rule.Logic, rule.Conditions, rule.Groups, data).Matched
+}
+
+// evaluateGroup combines conditions and nested groups with the given logic.
+// Without groups and logic this is the plain all-AND condition list; an
+// empty AND group matches and an empty OR group does not.
+func (am *AlertManager) evaluateGroup(logic string, conditions []AlertCondition, groups []ConditionGroup, data map[string]interface{}) GroupResult {
+	if logic == "" {
+		logic = LogicAnd
+	}
+
+	result := GroupResult{
+		Logic:      logic,
+		Matched:    logic == LogicAnd,
+		Conditions: make([]ConditionResult, 0, len(conditions)),
+	}
+	combine := func(matched bool) {
+		if logic == LogicOr {
+			result.Matched = result.Matched || matched
+		} else {
+			result.Matched = result.Matched && matched
+		}
+	}
+
+	for _, condition := range conditions {
+		value, exists := lookupField(data, condition.Field)
+		matched := am.evaluateCondition(condition, data)
+		result.Conditions = append(result.Conditions, ConditionResult{
+			Condition: condition,
+			Matched:   matched,
+			Value:     value,
+			Missing:   !exists,
+		})
+		combine(matched)
+	}
+	for _, group := range groups {
+		nested := am.evaluateGroup(group.Logic, group.Conditions, group.Groups, data)
+		result.Groups = append(result.Groups, nested)
+		combine(nested.Matched)
+	}
+
+	return result
+}
+
+// validateLogic rejects unknown logic operators in a rule and its groups
+func validateLogic(logic string, groups []ConditionGroup) error {
+	if logic != "" && logic != LogicAnd && logic != LogicOr {
+		return fmt.Errorf("invalid logic %q, must be %q or %q", logic, LogicAnd, LogicOr)
+	}
+	for _, group := range groups {
+		if err := validateLogic(group.Logic, group.Groups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileGroupPatterns compiles the regex patterns of conditions and nested groups
+func (am *AlertManager) compileGroupPatterns(conditions []AlertCondition, groups []ConditionGroup) {
+	for _, condition := range conditions {
+		if pattern, ok := condition.Value.(string); ok && condition.Operator == "regex" {
+			am.compilePattern(pattern)
 		}
 	}
-	return true
+	for _, group := range groups {
+		am.compileGroupPatterns(group.Conditions, group.Groups)
+	}
 }
 
 // evaluateCondition checks a single condition
 func (am *AlertManager) evaluateCondition(condition AlertCondition, data map[string]interface{}) bool {
-	value, exists := data[condition.Field]
+	value, exists := lookupField(data, condition.Field)
 	if !exists {
 		return false
 	}
 
 	switch condition.Operator {
 	case "eq":
-		return value == condition.Value
+		return equalValues(value, condition.Value)
 	case "ne":
-		return value != condition.Value
+		return !equalValues(value, condition.Value)
 	case "gt":
-		return compareNumbers(value, condition.Value) > 0
+		cmp, ok := compareNumbers(value, condition.Value)
+		return ok && cmp > 0
 	case "gte":
-		return compareNumbers(value, condition.Value) >= 0
+		cmp, ok := compareNumbers(value, condition.Value)
+		return ok && cmp >= 0
 	case "lt":
-		return compareNumbers(value, condition.Value) < 0
+		cmp, ok := compareNumbers(value, condition.Value)
+		return ok && cmp < 0
 	case "lte":
-		return compareNumbers(value, condition.Value) <= 0
+		cmp, ok := compareNumbers(value, condition.Value)
+		return ok && cmp <= 0
 	case "contains":
 		if str, ok := value.(string); ok {
 			if target, ok := condition.Value.(string); ok {
-				return contains(str, target)
+				return strings.Contains(str, target)
 			}
 		}
 		return false
+	case "in":
+		return inList(value, condition.Value)
+	case "not_in":
+		list, ok := condition.Value.([]interface{})
+		return ok && !inList(value, list)
+	case "regex":
+		pattern, ok := condition.Value.(string)
+		if !ok {
+			return false
+		}
+		re := am.compilePattern(pattern)
+		return re != nil && re.MatchString(fmt.Sprint(value))
 	default:
 		return false
 	}
 }
 
-// createAlert creates a new alert
+// compilePattern returns the compiled regex condition pattern, compiling it
+// only on first use. Invalid patterns are reported once and never match.
+func (am *AlertManager) compilePattern(pattern string) *regexp.Regexp {
+	if cached, ok := am.patterns.Load(pattern); ok {
+		return cached.(*regexp.Regexp)
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		fmt.Printf("Warning: invalid regex in alert condition %q: %v\n", pattern, err)
+		re = nil
+	}
+	am.patterns.Store(pattern, re)
+	return re
+}
+
+// createAlert creates a new alert and invokes the alert handlers
 func (am *AlertManager) createAlert(alert *Alert) error {
 	am.mutex.Lock()
-	defer am.mutex.Unlock()
-
 	am.alerts[alert.ID] = alert
+	handlers := am.alertHandlers
+	am.mutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(alert)
+	}
 	return nil
 }
 
+// OnAlert registers a callback invoked when an alert is raised, including
+// alerts suppressed by a maintenance window
+func (am *AlertManager) OnAlert(handler func(alert *Alert)) {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	am.alertHandlers = append(am.alertHandlers, handler)
+}
+
 // executeActions executes alert actions
 func (am *AlertManager) executeActions(actions []AlertAction, alert *Alert) {
 	for _, action := range actions {
@@ -259,18 +595,16 @@ func (am *AlertManager) executeActions(actions []AlertAction, alert *Alert) {
 			am.executeWebhookAction(action, alert)
 		case "email":
 			am.executeEmailAction(action, alert)
-		case "slack":
-			am.executeSlackAction(action, alert)
 		default:
-			fmt.Printf("Unknown action type: %s\n", action.Type)
+			am.executeNotifierAction(action, alert)
 		}
 	}
 }
 
-// executeWebhookAction executes a webhook action
+// executeWebhookAction posts the alert as JSON to the action's config.url
 func (am *AlertManager) executeWebhookAction(action AlertAction, alert *Alert) {
-	// TODO: Implement webhook execution
-	fmt.Printf("Executing webhook action for alert %s\n", alert.ID)
+	url, _ := action.Config["url"].(string)
+	am.sendNotification(NewWebhookNotifier(url), alert)
 }
 
 // executeEmailAction executes an email action
@@ -279,10 +613,26 @@ func (am *AlertManager) executeEmailAction(action AlertAction, alert *Alert) {
 	fmt.Printf("Executing email action for alert %s\n", alert.ID)
 }
 
-// executeSlackAction executes a Slack action
-func (am *AlertManager) executeSlackAction(action AlertAction, alert *Alert) {
-	// TODO: Implement Slack execution
-	fmt.Printf("Executing Slack action for alert %s\n", alert.ID)
+// executeNotifierAction sends the alert through a chat notifier. A
+// "webhook_url" in the action config selects a slack, teams or discord
+// webhook directly; otherwise the notifier registered under the action type
+// is used.
+func (am *AlertManager) executeNotifierAction(action AlertAction, alert *Alert) {
+	var notifier Notifier
+	if url, ok := action.Config["webhook_url"].(string); ok && url != "" {
+		notifier = newChatNotifier(action.Type, url)
+	}
+	if notifier == nil {
+		am.mutex.RLock()
+		notifier = am.notifiers[action.Type]
+		am.mutex.RUnlock()
+	}
+	if notifier == nil {
+		fmt.Printf("Unknown action type: %s\n", action.Type)
+		return
+	}
+
+	am.sendNotification(notifier, alert)
 }
 
 // ListAlerts returns all alerts
@@ -316,6 +666,27 @@ func (am *AlertManager) ResolveAlert(alertID string) error {
 	return nil
 }
 
+// PruneResolved forgets the alerts resolved before cutoff and returns how
+// many were removed
+func (am *AlertManager) PruneResolved(cutoff time.Time) int {
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	pruned := make(map[string]bool)
+	for id, alert := range am.alerts {
+		if alert.Status == "resolved" && alert.ResolvedAt != nil && alert.ResolvedAt.Before(cutoff) {
+			delete(am.alerts, id)
+			pruned[id] = true
+		}
+	}
+	for key, alertID := range am.clusterAlerts {
+		if pruned[alertID] {
+			delete(am.clusterAlerts, key)
+		}
+	}
+	return len(pruned)
+}
+
 // RegisterNotifier registers a notification handler
 func (am *AlertManager) RegisterNotifier(name string, notifier Notifier) {
 	am.mutex.Lock()
@@ -326,13 +697,100 @@ func (am *AlertManager) RegisterNotifier(name string, notifier Notifier) {
 
 // Helper functions
 
-func compareNumbers(a, b interface{}) int {
-	// Simple numeric comparison
-	// TODO: Implement proper numeric comparison
-	return 0
+// lookupField returns the value of a condition field. A key present as is
+// wins; otherwise a dotted field walks nested maps.
+func lookupField(data map[string]interface{}, field string) (interface{}, bool) {
+	if value, ok := data[field]; ok {
+		return value, true
+	}
+	if !strings.Contains(field, ".") {
+		return nil, false
+	}
+
+	var value interface{} = data
+	for _, key := range strings.Split(field, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
 }
 
-func contains(s, substr string) bool {
-	return len(s) >= len(substr) && s[:len(substr)] == substr
+// equalValues reports whether a field value equals a condition value. Lists
+// and objects, which == panics on, compare deeply.
+func equalValues(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
 }
 
+// compareNumbers returns -1, 0 or 1 comparing a to b, and false if either
+// value is not numeric
+func compareNumbers(a, b interface{}) (int, bool) {
+	x, okA := toFloat(a)
+	y, okB := toFloat(b)
+	if !okA || !okB {
+		return 0, false
+	}
+
+	switch {
+	case x < y:
+		return -1, true
+	case x > y:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// toFloat converts JSON and Go numeric values (and numeric strings) to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// inList reports whether value is a member of list, a JSON array. Numbers
+// compare by value so 1 matches 1.0.
+func inList(value, list interface{}) bool {
+	items, ok := list.([]interface{})
+	if !ok {
+		return false
+	}
+
+	for _, item := range items {
+		if cmp, ok := compareNumbers(value, item); ok {
+			if cmp == 0 {
+				return true
+			}
+			continue
+		}
+		if fmt.Sprint(value) == fmt.Sprint(item) {
+			return true
+		}
+	}
+	return false
+}
+
+
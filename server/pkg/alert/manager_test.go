@@ -0,0 +1,197 @@
+package alert
+
+import "testing"
+
+func cond(field, operator string, value interface{}) AlertCondition {
+	return AlertCondition{Field: field, Operator: operator, Value: value}
+}
+
+func TestEvaluateGroupNested(t *testing.T) {
+	am := NewAlertManager()
+	// (cpu > 90 AND mem > 90) OR disk > 95
+	hot := ConditionGroup{Logic: LogicAnd, Conditions: []AlertCondition{
+		cond("cpu", "gt", 90.0),
+		cond("mem", "gt", 90.0),
+	}}
+	full := []AlertCondition{cond("disk", "gt", 95.0)}
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want bool
+	}{
+		{"cpu and mem high", map[string]interface{}{"cpu": 95.0, "mem": 93.0, "disk": 10.0}, true},
+		{"only cpu high", map[string]interface{}{"cpu": 95.0, "mem": 50.0, "disk": 10.0}, false},
+		{"disk full", map[string]interface{}{"cpu": 5.0, "mem": 5.0, "disk": 99.0}, true},
+		{"all high", map[string]interface{}{"cpu": 95.0, "mem": 95.0, "disk": 99.0}, true},
+		{"nothing high", map[string]interface{}{"cpu": 5.0, "mem": 5.0, "disk": 5.0}, false},
+		{"fields missing", map[string]interface{}{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := am.evaluateGroup(LogicOr, full, []ConditionGroup{hot}, tt.data)
+			if got.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", got.Matched, tt.want)
+			}
+			if len(got.Conditions) != 1 || len(got.Groups) != 1 || len(got.Groups[0].Conditions) != 2 {
+				t.Errorf("result doesn't mirror the rule: %+v", got)
+			}
+		})
+	}
+}
+
+func TestEvaluateGroupDeeplyNested(t *testing.T) {
+	am := NewAlertManager()
+	// status == "degraded" AND (gpu > 80 OR (temp > 70 AND fan < 1000))
+	groups := []ConditionGroup{{
+		Logic:      LogicOr,
+		Conditions: []AlertCondition{cond("gpu", "gt", 80.0)},
+		Groups: []ConditionGroup{{
+			Conditions: []AlertCondition{cond("temp", "gt", 70.0), cond("fan", "lt", 1000.0)},
+		}},
+	}}
+	conditions := []AlertCondition{cond("status", "eq", "degraded")}
+
+	tests := []struct {
+		name string
+		data map[string]interface{}
+		want bool
+	}{
+		{"gpu busy", map[string]interface{}{"status": "degraded", "gpu": 90.0, "temp": 20.0, "fan": 3000.0}, true},
+		{"hot and fan slow", map[string]interface{}{"status": "degraded", "gpu": 10.0, "temp": 80.0, "fan": 500.0}, true},
+		{"hot but fan fast", map[string]interface{}{"status": "degraded", "gpu": 10.0, "temp": 80.0, "fan": 3000.0}, false},
+		{"not degraded", map[string]interface{}{"status": "ok", "gpu": 90.0, "temp": 80.0, "fan": 500.0}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := am.evaluateGroup("", conditions, groups, tt.data); got.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", got.Matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateGroupEmpty(t *testing.T) {
+	am := NewAlertManager()
+	data := map[string]interface{}{"cpu": 95.0}
+
+	tests := []struct {
+		name   string
+		logic  string
+		groups []ConditionGroup
+		want   bool
+	}{
+		{"empty and", LogicAnd, nil, true},
+		{"empty default logic", "", nil, true},
+		{"empty or", LogicOr, nil, false},
+		{"and of an empty or", LogicAnd, []ConditionGroup{{Logic: LogicOr}}, false},
+		{"or of an empty and", LogicOr, []ConditionGroup{{Logic: LogicAnd}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := am.evaluateGroup(tt.logic, nil, tt.groups, data); got.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", got.Matched, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateGroupFlatListIsAnd(t *testing.T) {
+	am := NewAlertManager()
+	rule := &AlertRule{Conditions: []AlertCondition{cond("cpu", "gt", 90.0), cond("mem", "gt", 90.0)}}
+
+	if !am.evaluateRule(rule, "agent-1", map[string]interface{}{"cpu": 95.0, "mem": 95.0}) {
+		t.Error("all conditions met, want match")
+	}
+	if am.evaluateRule(rule, "agent-1", map[string]interface{}{"cpu": 95.0, "mem": 50.0}) {
+		t.Error("one condition unmet, want no match")
+	}
+}
+
+// A decided group stays decided whatever the later terms are, while every
+// term is still reported so rule explanations show why it matched
+func TestEvaluateGroupShortCircuit(t *testing.T) {
+	am := NewAlertManager()
+	data := map[string]interface{}{"cpu": 95.0, "mem": 50.0}
+
+	tests := []struct {
+		name       string
+		logic      string
+		conditions []AlertCondition
+		want       bool
+	}{
+		{"or decided by first", LogicOr, []AlertCondition{cond("cpu", "gt", 90.0), cond("mem", "gt", 90.0), cond("missing", "gt", 1.0)}, true},
+		{"or decided by last", LogicOr, []AlertCondition{cond("missing", "gt", 1.0), cond("mem", "gt", 90.0), cond("cpu", "gt", 90.0)}, true},
+		{"and decided by first", LogicAnd, []AlertCondition{cond("mem", "gt", 90.0), cond("cpu", "gt", 90.0), cond("cpu", "gt", 1.0)}, false},
+		{"and decided by last", LogicAnd, []AlertCondition{cond("cpu", "gt", 90.0), cond("cpu", "gt", 1.0), cond("missing", "gt", 1.0)}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := am.evaluateGroup(tt.logic, tt.conditions, nil, data)
+			if got.Matched != tt.want {
+				t.Errorf("Matched = %v, want %v", got.Matched, tt.want)
+			}
+			if len(got.Conditions) != len(tt.conditions) {
+				t.Fatalf("reported %d conditions, want %d", len(got.Conditions), len(tt.conditions))
+			}
+			for i, result := range got.Conditions {
+				if want := am.evaluateCondition(tt.conditions[i], data); result.Matched != want {
+					t.Errorf("condition %d Matched = %v, want %v", i, result.Matched, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateEqualityValues(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr bool
+	}{
+		{"string", "degraded", false},
+		{"number", 3.0, false},
+		{"bool", true, false},
+		{"null", nil, false},
+		{"list", []interface{}{"a", "b"}, true},
+		{"object", map[string]interface{}{"a": 1.0}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, operator := range []string{"eq", "ne"} {
+				if err := validateCondition(cond("status", operator, tt.value)); (err != nil) != tt.wantErr {
+					t.Errorf("%s: error = %v, want error %v", operator, err, tt.wantErr)
+				}
+			}
+		})
+	}
+}
+
+// Fields holding lists or objects compare without panicking, whatever the
+// condition value
+func TestEvaluateEqualityUncomparable(t *testing.T) {
+	am := NewAlertManager()
+	data := map[string]interface{}{
+		"tags":   []interface{}{"gpu", "a100"},
+		"labels": map[string]interface{}{"rack": "r1"},
+	}
+
+	tests := []struct {
+		name      string
+		condition AlertCondition
+		want      bool
+	}{
+		{"equal list", cond("tags", "eq", []interface{}{"gpu", "a100"}), true},
+		{"different list", cond("tags", "ne", []interface{}{"gpu"}), true},
+		{"equal object", cond("labels", "eq", map[string]interface{}{"rack": "r1"}), true},
+		{"list against scalar", cond("tags", "eq", "gpu"), false},
+		{"object against scalar", cond("labels", "ne", "r1"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := am.evaluateCondition(tt.condition, data); got != tt.want {
+				t.Errorf("Matched = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+// Package alert provides Slack, Microsoft Teams and Discord webhook notifiers.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"fmt"
+	"time"
+)
+
+// Severity colors shared by the chat notifiers
+const (
+	colorCritical = "D32F2F"
+	colorWarning  = "F9A825"
+	colorInfo     = "1976D2"
+	colorResolved = "388E3C"
+)
+
+// severityColor maps an alert to a hex RGB color without the leading '#'
+func severityColor(alert *Alert) string {
+	if alert.Status == "resolved" {
+		return colorResolved
+	}
+	switch alert.Severity {
+	case "critical", "error":
+		return colorCritical
+	case "warning":
+		return colorWarning
+	default:
+		return colorInfo
+	}
+}
+
+// alertTitle returns a one-line summary of an alert
+func alertTitle(alert *Alert) string {
+	message := alert.Message
+	if message == "" {
+		message = fmt.Sprintf("Alert %s fired on %s", alert.RuleID, alert.AgentID)
+	}
+	return fmt.Sprintf("[%s] %s", alert.Severity, message)
+}
+
+// alertFacts returns the agent, rule, status and timestamp of an alert as
+// label/value pairs, skipping empty values
+func alertFacts(alert *Alert) [][2]string {
+	candidates := [][2]string{
+		{"Agent", alert.AgentID},
+		{"Cluster", alert.ClusterID},
+		{"Rule", alert.RuleID},
+		{"Status", alert.Status},
+		{"Time", alert.CreatedAt.Format(time.RFC3339)},
+	}
+
+	facts := make([][2]string, 0, len(candidates))
+	for _, fact := range candidates {
+		if fact[1] != "" {
+			facts = append(facts, fact)
+		}
+	}
+	return facts
+}
+
+// SlackNotifier posts alerts to a Slack incoming webhook
+type SlackNotifier struct {
+	webhookURL string
+	client     *webhookClient
+}
+
+// NewSlackNotifier creates a Slack notifier for an incoming-webhook URL
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{webhookURL: webhookURL, client: newWebhookClient()}
+}
+
+// Name returns the notifier name
+func (n *SlackNotifier) Name() string {
+	return "slack"
+}
+
+// Send posts the alert as a Slack attachment
+func (n *SlackNotifier) Send(alert *Alert) error {
+	fields := make([]map[string]interface{}, 0, 5)
+	for _, fact := range alertFacts(alert) {
+		fields = append(fields, map[string]interface{}{"title": fact[0], "value": fact[1], "short": true})
+	}
+
+	return n.client.post(n.webhookURL, map[string]interface{}{
+		"attachments": []map[string]interface{}{{
+			"color":    "#" + severityColor(alert),
+			"title":    alertTitle(alert),
+			"fields":   fields,
+			"fallback": alertTitle(alert),
+			"ts":       alert.CreatedAt.Unix(),
+		}},
+	})
+}
+
+// TeamsNotifier posts alerts to a Microsoft Teams incoming webhook as an Adaptive Card
+type TeamsNotifier struct {
+	webhookURL string
+	client     *webhookClient
+}
+
+// NewTeamsNotifier creates a Teams notifier for an incoming-webhook URL
+func NewTeamsNotifier(webhookURL string) *TeamsNotifier {
+	return &TeamsNotifier{webhookURL: webhookURL, client: newWebhookClient()}
+}
+
+// Name returns the notifier name
+func (n *TeamsNotifier) Name() string {
+	return "teams"
+}
+
+// Send posts the alert as an Adaptive Card message
+func (n *TeamsNotifier) Send(alert *Alert) error {
+	facts := make([]map[string]string, 0, 5)
+	for _, fact := range alertFacts(alert) {
+		facts = append(facts, map[string]string{"title": fact[0], "value": fact[1]})
+	}
+
+	// Adaptive Cards only support named colors
+	color := "Accent"
+	switch severityColor(alert) {
+	case colorCritical:
+		color = "Attention"
+	case colorWarning:
+		color = "Warning"
+	case colorResolved:
+		color = "Good"
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []map[string]interface{}{
+			{"type": "TextBlock", "text": alertTitle(alert), "weight": "Bolder", "size": "Medium", "color": color, "wrap": true},
+			{"type": "FactSet", "facts": facts},
+		},
+	}
+
+	return n.client.post(n.webhookURL, map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content":     card,
+		}},
+	})
+}
+
+// DiscordNotifier posts alerts to a Discord webhook as an embed
+type DiscordNotifier struct {
+	webhookURL string
+	client     *webhookClient
+}
+
+// NewDiscordNotifier creates a Discord notifier for a webhook URL
+func NewDiscordNotifier(webhookURL string) *DiscordNotifier {
+	return &DiscordNotifier{webhookURL: webhookURL, client: newWebhookClient()}
+}
+
+// Name returns the notifier name
+func (n *DiscordNotifier) Name() string {
+	return "discord"
+}
+
+// Send posts the alert as a Discord embed
+func (n *DiscordNotifier) Send(alert *Alert) error {
+	fields := make([]map[string]interface{}, 0, 5)
+	for _, fact := range alertFacts(alert) {
+		fields = append(fields, map[string]interface{}{"name": fact[0], "value": fact[1], "inline": true})
+	}
+
+	var color int
+	fmt.Sscanf(severityColor(alert), "%x", &color)
+
+	return n.client.post(n.webhookURL, map[string]interface{}{
+		"embeds": []map[string]interface{}{{
+			"title":     alertTitle(alert),
+			"color":     color,
+			"fields":    fields,
+			"timestamp": alert.CreatedAt.Format(time.RFC3339),
+		}},
+	})
+}
+
+// newChatNotifier creates the notifier for an action type with an explicit webhook URL
+func newChatNotifier(actionType, webhookURL string) Notifier {
+	switch actionType {
+	case "slack":
+		return NewSlackNotifier(webhookURL)
+	case "teams":
+		return NewTeamsNotifier(webhookURL)
+	case "discord":
+		return NewDiscordNotifier(webhookURL)
+	default:
+		return nil
+	}
+}
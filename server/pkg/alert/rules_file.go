@@ -0,0 +1,119 @@
+// Package alert provides loading alert rules from a file, replacing the
+// rules loaded from it before.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// RuleChanges lists the IDs of the rules LoadRules added, updated and removed
+type RuleChanges struct {
+	Added   []string `json:"added,omitempty"`
+	Updated []string `json:"updated,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether no rule changed
+func (c RuleChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Updated) == 0 && len(c.Removed) == 0
+}
+
+// ReadRulesFile reads a JSON array of alert rules
+func ReadRulesFile(file string) ([]*AlertRule, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %v", err)
+	}
+
+	var rules []*AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid alert rules in %s: %v", file, err)
+	}
+	return rules, nil
+}
+
+// LoadRules makes rules the set of rules loaded from source: new rules are
+// added, changed ones replaced and those no longer listed removed. Rules
+// created over the API are left alone and may not be overwritten. Nothing
+// changes unless all rules are valid.
+func (am *AlertManager) LoadRules(source string, rules []*AlertRule) (RuleChanges, error) {
+	var changes RuleChanges
+	if source == "" {
+		return changes, fmt.Errorf("alert rule source is required")
+	}
+
+	listed := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return changes, fmt.Errorf("alert rule without id in %s", source)
+		}
+		if listed[rule.ID] {
+			return changes, fmt.Errorf("duplicate alert rule %s in %s", rule.ID, source)
+		}
+		listed[rule.ID] = true
+	}
+
+	am.mutex.Lock()
+	defer am.mutex.Unlock()
+
+	for _, rule := range rules {
+		if err := am.validateRuleLocked(rule); err != nil {
+			return changes, fmt.Errorf("alert rule %s: %v", rule.ID, err)
+		}
+		if existing, exists := am.rules[rule.ID]; exists && existing.Source != source {
+			return changes, fmt.Errorf("alert rule %s already exists and was not loaded from %s", rule.ID, source)
+		}
+	}
+
+	now := time.Now()
+	for _, rule := range rules {
+		am.compileGroupPatterns(rule.Conditions, rule.Groups)
+		rule.Source = source
+		rule.UpdatedAt = now
+
+		if existing, exists := am.rules[rule.ID]; exists {
+			rule.CreatedAt = existing.CreatedAt
+			if rulesEqual(existing, rule) {
+				rule.UpdatedAt = existing.UpdatedAt
+			} else {
+				changes.Updated = append(changes.Updated, rule.ID)
+			}
+		} else {
+			rule.CreatedAt = now
+			changes.Added = append(changes.Added, rule.ID)
+		}
+		am.rules[rule.ID] = rule
+	}
+
+	for id, rule := range am.rules {
+		if rule.Source == source && !listed[id] {
+			delete(am.rules, id)
+			changes.Removed = append(changes.Removed, id)
+		}
+	}
+
+	am.agentRules = nil
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Updated)
+	sort.Strings(changes.Removed)
+	return changes, nil
+}
+
+// rulesEqual reports whether two rules have the same definition, ignoring
+// their timestamps
+func rulesEqual(a, b *AlertRule) bool {
+	x, y := *a, *b
+	x.CreatedAt, x.UpdatedAt = time.Time{}, time.Time{}
+	y.CreatedAt, y.UpdatedAt = time.Time{}, time.Time{}
+
+	dataX, errX := json.Marshal(x)
+	dataY, errY := json.Marshal(y)
+	return errX == nil && errY == nil && string(dataX) == string(dataY)
+}
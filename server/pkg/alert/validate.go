@@ -0,0 +1,205 @@
+// Package alert provides validation of alert rules, so misconfigured rules
+// are rejected when they are written instead of never firing.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Alert rule severities
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityError    = "error"
+	SeverityCritical = "critical"
+)
+
+// severities and operators are the values rules may use
+var (
+	severities = []string{SeverityInfo, SeverityWarning, SeverityError, SeverityCritical}
+	operators  = []string{"eq", "ne", "gt", "gte", "lt", "lte", "contains", "in", "not_in", "regex"}
+)
+
+// validateSeverity checks a rule severity
+func validateSeverity(severity string) error {
+	for _, known := range severities {
+		if severity == known {
+			return nil
+		}
+	}
+	if severity == "" {
+		return fmt.Errorf("severity is required, must be one of %s", strings.Join(severities, ", "))
+	}
+	return fmt.Errorf("invalid severity %q, must be one of %s", severity, strings.Join(severities, ", "))
+}
+
+// validateRuleLocked checks a rule's severity, conditions, logic, scope and
+// actions, naming the offending field in the error; caller must hold am.mutex
+func (am *AlertManager) validateRuleLocked(rule *AlertRule) error {
+	if err := validateSeverity(rule.Severity); err != nil {
+		return err
+	}
+	if err := validateLogic(rule.Logic, rule.Groups); err != nil {
+		return err
+	}
+	if err := validateScope(rule.Scope, rule.Clusters); err != nil {
+		return err
+	}
+	if len(rule.Conditions) == 0 && len(rule.Groups) == 0 {
+		return fmt.Errorf("conditions: at least one condition or group is required")
+	}
+	if err := validateConditions("", rule.Conditions, rule.Groups); err != nil {
+		return err
+	}
+	for i, action := range rule.Actions {
+		if err := am.validateActionLocked(action); err != nil {
+			return fmt.Errorf("actions[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// validateConditions checks the conditions and nested groups under path
+func validateConditions(path string, conditions []AlertCondition, groups []ConditionGroup) error {
+	for i, condition := range conditions {
+		if err := validateCondition(condition); err != nil {
+			return fmt.Errorf("%sconditions[%d]: %v", path, i, err)
+		}
+	}
+	for i, group := range groups {
+		groupPath := fmt.Sprintf("%sgroups[%d].", path, i)
+		if len(group.Conditions) == 0 && len(group.Groups) == 0 {
+			return fmt.Errorf("%sconditions: at least one condition or group is required", groupPath)
+		}
+		if err := validateConditions(groupPath, group.Conditions, group.Groups); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateCondition checks a condition's field, operator and value type
+func validateCondition(condition AlertCondition) error {
+	if condition.Field == "" {
+		return fmt.Errorf("field is required")
+	}
+
+	switch condition.Operator {
+	case "eq", "ne":
+		if !isScalar(condition.Value) {
+			return fmt.Errorf("operator %s needs a string, number, boolean or null value, got %v", condition.Operator, condition.Value)
+		}
+	case "gt", "gte", "lt", "lte":
+		if _, ok := toFloat(condition.Value); !ok {
+			return fmt.Errorf("operator %s needs a numeric value, got %v", condition.Operator, condition.Value)
+		}
+	case "contains":
+		if _, ok := condition.Value.(string); !ok {
+			return fmt.Errorf("operator contains needs a string value, got %v", condition.Value)
+		}
+	case "in", "not_in":
+		if _, ok := condition.Value.([]interface{}); !ok {
+			return fmt.Errorf("operator %s needs a list value, got %v", condition.Operator, condition.Value)
+		}
+	case "regex":
+		pattern, ok := condition.Value.(string)
+		if !ok {
+			return fmt.Errorf("operator regex needs a pattern string, got %v", condition.Value)
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid regex: %v", err)
+		}
+	case "":
+		return fmt.Errorf("operator is required, must be one of %s", strings.Join(operators, ", "))
+	default:
+		return fmt.Errorf("unknown operator %q, must be one of %s", condition.Operator, strings.Join(operators, ", "))
+	}
+	return nil
+}
+
+// isScalar reports whether a condition value is a string, number, boolean
+// or null, which eq and ne can compare
+func isScalar(value interface{}) bool {
+	switch value.(type) {
+	case nil, string, bool:
+		return true
+	}
+	_, ok := toFloat(value)
+	return ok
+}
+
+// validateActionLocked checks that an action's type is known and its config
+// has the fields the type needs; caller must hold am.mutex
+func (am *AlertManager) validateActionLocked(action AlertAction) error {
+	switch action.Type {
+	case "webhook":
+		return validateURLConfig(action.Config, "url", true)
+	case "email":
+		to, ok := action.Config["to"]
+		if !ok {
+			return fmt.Errorf("config.to is required for email actions")
+		}
+		switch to := to.(type) {
+		case string:
+			if to == "" {
+				return fmt.Errorf("config.to must not be empty")
+			}
+		case []interface{}:
+			if len(to) == 0 {
+				return fmt.Errorf("config.to must not be empty")
+			}
+			for i, address := range to {
+				if s, ok := address.(string); !ok || s == "" {
+					return fmt.Errorf("config.to[%d] must be an address", i)
+				}
+			}
+		default:
+			return fmt.Errorf("config.to must be an address or a list of addresses")
+		}
+		return nil
+	case "slack", "teams", "discord":
+		// Without a webhook_url the server-wide notifier of that type is used
+		_, registered := am.notifiers[action.Type]
+		if err := validateURLConfig(action.Config, "webhook_url", !registered); err != nil {
+			if !registered {
+				return fmt.Errorf("%v (no server-wide %s webhook is configured)", err, action.Type)
+			}
+			return err
+		}
+		return nil
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		if _, registered := am.notifiers[action.Type]; !registered {
+			return fmt.Errorf("unknown action type %q", action.Type)
+		}
+		return nil
+	}
+}
+
+// validateURLConfig checks that config[key] is an http or https URL, if set
+// or required
+func validateURLConfig(config map[string]interface{}, key string, required bool) error {
+	value, ok := config[key]
+	if !ok {
+		if required {
+			return fmt.Errorf("config.%s is required", key)
+		}
+		return nil
+	}
+	raw, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("config.%s must be a string", key)
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("config.%s must be an http or https URL, got %q", key, raw)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+// Package alert provides the HTTP delivery shared by webhook-based notifiers,
+// and the notifier for generic webhook actions.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// webhookTimeout bounds a single delivery attempt
+	webhookTimeout = 10 * time.Second
+
+	// webhookRetries is the number of attempts after the first one
+	webhookRetries = 3
+
+	// webhookBackoff is the delay before the first retry, doubled after each attempt
+	webhookBackoff = time.Second
+)
+
+// webhookClient posts JSON payloads to incoming-webhook URLs, retrying
+// network errors, rate limiting and server errors with exponential backoff.
+// Deliveries to an endpoint that keeps failing are stopped by its circuit
+// breaker, see circuitBreaker.
+type webhookClient struct {
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// newWebhookClient creates a webhook client with the default retry policy
+func newWebhookClient() *webhookClient {
+	return &webhookClient{
+		client:  &http.Client{Timeout: webhookTimeout},
+		retries: webhookRetries,
+		backoff: webhookBackoff,
+	}
+}
+
+// post sends payload as JSON to url. The probe of a half-open circuit is a
+// single attempt.
+func (w *webhookClient) post(url string, payload interface{}) error {
+	if url == "" {
+		return fmt.Errorf("webhook URL is not configured")
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	retries := w.retries
+	breaker := breakerFor(url)
+	if breaker != nil {
+		allowed, probe := breaker.allow()
+		if !allowed {
+			return ErrCircuitOpen
+		}
+		if probe {
+			retries = 0
+		}
+	}
+
+	err = w.deliver(url, data, retries)
+	if breaker != nil {
+		breaker.record(err)
+	}
+	return err
+}
+
+// deliver makes up to retries+1 attempts to deliver data to url
+func (w *webhookClient) deliver(url string, data []byte, retries int) error {
+	backoff := w.backoff
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		retry, err := w.send(url, data)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+
+	return lastErr
+}
+
+// send makes one delivery attempt and reports whether a failure is retryable
+func (w *webhookClient) send(url string, data []byte) (bool, error) {
+	resp, err := w.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %d: %s", resp.StatusCode, bytes.TrimSpace(body))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, err
+}
+
+// WebhookNotifier posts alerts as JSON to a generic webhook
+type WebhookNotifier struct {
+	url    string
+	client *webhookClient
+}
+
+// NewWebhookNotifier creates a notifier posting to a webhook URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, client: newWebhookClient()}
+}
+
+// Name returns the notifier name
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+// Send posts the alert as its JSON representation
+func (n *WebhookNotifier) Send(alert *Alert) error {
+	return n.client.post(n.url, alert)
+}
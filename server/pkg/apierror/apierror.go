@@ -0,0 +1,173 @@
+// Package apierror provides the structured error responses of the API, with
+// stable machine-readable codes and the HTTP status of each.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/storage"
+)
+
+// Code is a stable machine-readable error code. Clients may rely on codes;
+// messages are for humans and may change.
+type Code string
+
+// Error codes
+const (
+	InvalidRequest     Code = "INVALID_REQUEST"
+	InvalidStatus      Code = "INVALID_STATUS"
+	UnsupportedTask    Code = "UNSUPPORTED_TASK"
+	TaskNotCancellable Code = "TASK_NOT_CANCELLABLE"
+
+	Unauthorized Code = "UNAUTHORIZED"
+	Forbidden    Code = "FORBIDDEN"
+	TokenRetired Code = "TOKEN_RETIRED"
+
+	NotFound                  Code = "NOT_FOUND"
+	AgentNotFound             Code = "AGENT_NOT_FOUND"
+	TaskNotFound              Code = "TASK_NOT_FOUND"
+	ScheduleNotFound          Code = "SCHEDULE_NOT_FOUND"
+	ClusterNotFound           Code = "CLUSTER_NOT_FOUND"
+	AlertRuleNotFound         Code = "ALERT_RULE_NOT_FOUND"
+	MaintenanceWindowNotFound Code = "MAINTENANCE_WINDOW_NOT_FOUND"
+	WebhookNotFound           Code = "WEBHOOK_NOT_FOUND"
+	TokenNotFound             Code = "TOKEN_NOT_FOUND"
+	BinaryNotFound            Code = "BINARY_NOT_FOUND"
+
+	TokenShared          Code = "TOKEN_SHARED"
+	AgentNotConnected    Code = "AGENT_NOT_CONNECTED"
+	PayloadTooLarge      Code = "PAYLOAD_TOO_LARGE"
+	IdempotencyKeyReused Code = "IDEMPOTENCY_KEY_REUSED"
+	RateLimited          Code = "RATE_LIMITED"
+
+	Internal          Code = "INTERNAL_ERROR"
+	NotImplemented    Code = "NOT_IMPLEMENTED"
+	Unavailable       Code = "SERVICE_UNAVAILABLE"
+	StorageOverloaded Code = "STORAGE_OVERLOADED"
+)
+
+// statuses maps each code to its HTTP status
+var statuses = map[Code]int{
+	InvalidRequest:     http.StatusBadRequest,
+	InvalidStatus:      http.StatusBadRequest,
+	UnsupportedTask:    http.StatusBadRequest,
+	TaskNotCancellable: http.StatusBadRequest,
+
+	Unauthorized: http.StatusUnauthorized,
+	Forbidden:    http.StatusForbidden,
+	TokenRetired: http.StatusForbidden,
+
+	NotFound:                  http.StatusNotFound,
+	AgentNotFound:             http.StatusNotFound,
+	TaskNotFound:              http.StatusNotFound,
+	ScheduleNotFound:          http.StatusNotFound,
+	ClusterNotFound:           http.StatusNotFound,
+	AlertRuleNotFound:         http.StatusNotFound,
+	MaintenanceWindowNotFound: http.StatusNotFound,
+	WebhookNotFound:           http.StatusNotFound,
+	TokenNotFound:             http.StatusNotFound,
+	BinaryNotFound:            http.StatusNotFound,
+
+	TokenShared:          http.StatusConflict,
+	AgentNotConnected:    http.StatusConflict,
+	PayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	IdempotencyKeyReused: http.StatusUnprocessableEntity,
+	RateLimited:          http.StatusTooManyRequests,
+
+	Internal:          http.StatusInternalServerError,
+	NotImplemented:    http.StatusNotImplemented,
+	Unavailable:       http.StatusServiceUnavailable,
+	StorageOverloaded: http.StatusServiceUnavailable,
+}
+
+// Status returns the HTTP status of a code, 500 for unknown codes
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Response is the body of every error response
+type Response struct {
+	Code      Code        `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// requestIDKey is the gin context key security.RequestIDMiddleware stores
+// the request ID under
+const requestIDKey = "request_id"
+
+// knownErrors maps errors returned by lower layers to their codes, see Register
+var knownErrors = []struct {
+	err  error
+	code Code
+}{
+	{storage.ErrNotFound, NotFound},
+	{storage.ErrOverloaded, StorageOverloaded},
+}
+
+// Register maps an error, and errors wrapping it, to a code for
+// RespondError. Call it during initialization only.
+func Register(err error, code Code) {
+	knownErrors = append(knownErrors, struct {
+		err  error
+		code Code
+	}{err, code})
+}
+
+// CodeOf returns the code registered for err, or fallback
+func CodeOf(err error, fallback Code) Code {
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return PayloadTooLarge
+	}
+	return fallback
+}
+
+// Respond writes an error response with the status of the code
+func Respond(c *gin.Context, code Code, message string) {
+	RespondDetails(c, code, message, nil)
+}
+
+// RespondDetails writes an error response carrying details, e.g. the
+// offending fields or IDs
+func RespondDetails(c *gin.Context, code Code, message string, details interface{}) {
+	c.JSON(code.Status(), Response{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: c.GetString(requestIDKey),
+	})
+}
+
+// Abort writes an error response and stops the remaining handlers
+func Abort(c *gin.Context, code Code, message string) {
+	Respond(c, code, message)
+	c.Abort()
+}
+
+// RespondError writes the error response for err, with the code registered
+// for it or else fallback
+func RespondError(c *gin.Context, err error, fallback Code) {
+	code := CodeOf(err, fallback)
+	message := err.Error()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		message = fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)
+	}
+	Respond(c, code, message)
+}
@@ -0,0 +1,190 @@
+// Package binary provides release channels: stable, beta and canary each
+// point at an uploaded version, so agents can follow a channel instead of a
+// version and a version is promoted from one channel to the next in a
+// single call.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package binary
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+)
+
+// Release channels, from most to least stable
+const (
+	ChannelStable = "stable"
+	ChannelBeta   = "beta"
+	ChannelCanary = "canary"
+)
+
+// Channels lists the release channels from most to least stable; a
+// version is promoted toward the front
+var Channels = []string{ChannelStable, ChannelBeta, ChannelCanary}
+
+// validChannel reports whether name is a release channel
+func validChannel(name string) bool {
+	for _, channel := range Channels {
+		if name == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// channelError describes an unknown channel name
+func channelError(name string) string {
+	return fmt.Sprintf("unknown channel %q, must be one of %s", name, strings.Join(Channels, ", "))
+}
+
+// ChannelVersion returns the version a release channel is at
+func (bm *AgentBinaryManager) ChannelVersion(channel string) (string, error) {
+	if !validChannel(channel) {
+		return "", fmt.Errorf("%s", channelError(channel))
+	}
+
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	version, ok := bm.channels[channel]
+	if !ok {
+		return "", fmt.Errorf("channel %s has no version yet", channel)
+	}
+	return version, nil
+}
+
+// channelsLocked returns a copy of the channel versions; caller must hold bm.mu
+func (bm *AgentBinaryManager) channelsLocked() map[string]string {
+	channels := make(map[string]string, len(bm.channels))
+	for channel, version := range bm.channels {
+		channels[channel] = version
+	}
+	return channels
+}
+
+// channelsAtLocked returns the channels at a version, most stable first;
+// caller must hold bm.mu
+func (bm *AgentBinaryManager) channelsAtLocked(version string) []string {
+	var channels []string
+	for _, channel := range Channels {
+		if current, ok := bm.channels[channel]; ok && current == version {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+// hasVersionLocked reports whether any build of version was uploaded;
+// caller must hold bm.mu
+func (bm *AgentBinaryManager) hasVersionLocked(version string) bool {
+	for _, binary := range bm.versions {
+		if binary.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// listChannels returns the version of each release channel
+func (bm *AgentBinaryManager) listChannels(c *gin.Context) {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"channels": bm.channelsLocked(),
+		"order":    Channels,
+	})
+}
+
+// setChannel points a release channel at an uploaded version, e.g. to roll
+// it back
+func (bm *AgentBinaryManager) setChannel(c *gin.Context) {
+	channel := c.Param("channel")
+	if !validChannel(channel) {
+		apierror.Respond(c, apierror.InvalidRequest, channelError(channel))
+		return
+	}
+
+	var request struct {
+		Version string `json:"version" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		apierror.RespondError(c, err, apierror.InvalidRequest)
+		return
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	if !bm.hasVersionLocked(request.Version) {
+		apierror.Respond(c, apierror.BinaryNotFound, "binary not found")
+		return
+	}
+	previous := bm.channels[channel]
+	bm.channels[channel] = request.Version
+
+	c.JSON(http.StatusOK, gin.H{
+		"channel":  channel,
+		"version":  request.Version,
+		"previous": previous,
+	})
+}
+
+// promoteChannel moves a release channel to the version of a less stable
+// one, by default the next: promoting stable takes beta's version and
+// promoting beta takes canary's
+func (bm *AgentBinaryManager) promoteChannel(c *gin.Context) {
+	channel := c.Param("channel")
+	if !validChannel(channel) {
+		apierror.Respond(c, apierror.InvalidRequest, channelError(channel))
+		return
+	}
+
+	var request struct {
+		From string `json:"from"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			apierror.RespondError(c, err, apierror.InvalidRequest)
+			return
+		}
+	}
+	if request.From == "" {
+		for i, name := range Channels {
+			if name == channel && i+1 < len(Channels) {
+				request.From = Channels[i+1]
+			}
+		}
+		if request.From == "" {
+			apierror.Respond(c, apierror.InvalidRequest, fmt.Sprintf("channel %s is the least stable, there is nothing to promote from", channel))
+			return
+		}
+	}
+	if !validChannel(request.From) || request.From == channel {
+		apierror.Respond(c, apierror.InvalidRequest, fmt.Sprintf("from must be another channel, one of %s", strings.Join(Channels, ", ")))
+		return
+	}
+
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	version, ok := bm.channels[request.From]
+	if !ok {
+		apierror.Respond(c, apierror.BinaryNotFound, fmt.Sprintf("channel %s has no version to promote", request.From))
+		return
+	}
+	previous := bm.channels[channel]
+	bm.channels[channel] = version
+
+	c.JSON(http.StatusOK, gin.H{
+		"channel":  channel,
+		"from":     request.From,
+		"version":  version,
+		"previous": previous,
+	})
+}
@@ -5,19 +5,33 @@
 package binary
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/install"
+	"github.com/nerve/server/pkg/security"
 )
 
 // AgentBinaryManager manages agent binary distribution
 type AgentBinaryManager struct {
-	binaryPath    string
-	versions      map[string]*BinaryVersion
-	currentVersion string
+	mu           sync.RWMutex
+	binaryPath   string
+	versions     map[string]*BinaryVersion
+	tokenManager *security.TokenManager
+	auditLogger  *security.AuditLogger
+
+	// channels maps each release channel to the version it is at
+	channels map[string]string
 }
 
 // BinaryVersion represents a versioned agent binary
@@ -29,14 +43,19 @@ type BinaryVersion struct {
 	Checksum    string    `json:"checksum"`
 	Size        int64     `json:"size"`
 	CreatedAt   time.Time `json:"created_at"`
+
+	// Channels are the release channels at this version
+	Channels []string `json:"channels,omitempty"`
 }
 
 // NewAgentBinaryManager creates a new binary manager
-func NewAgentBinaryManager(binaryPath string) *AgentBinaryManager {
+func NewAgentBinaryManager(binaryPath string, tokenManager *security.TokenManager, auditLogger *security.AuditLogger) *AgentBinaryManager {
 	return &AgentBinaryManager{
 		binaryPath:   binaryPath,
 		versions:     make(map[string]*BinaryVersion),
-		currentVersion: "latest",
+		tokenManager: tokenManager,
+		auditLogger:  auditLogger,
+		channels:     make(map[string]string),
 	}
 }
 
@@ -48,22 +67,33 @@ func (bm *AgentBinaryManager) SetupBinaryRoutes(router *gin.Engine) {
 		binaries.POST("/upload", bm.uploadBinary)
 		binaries.GET("/download/:version/:platform/:arch", bm.downloadBinary)
 		binaries.DELETE("/:version", bm.deleteBinary)
+
+		binaries.GET("/channels", bm.listChannels)
+		binaries.PUT("/channels/:channel", bm.setChannel)
+		binaries.POST("/channels/:channel/promote", bm.promoteChannel)
 	}
 
 	// Install script endpoint
 	router.GET("/install.sh", bm.serveInstallScript)
 }
 
-// listBinaries lists available agent binaries
+// listBinaries lists available agent binaries and the release channels at
+// each; current is the stable channel's version
 func (bm *AgentBinaryManager) listBinaries(c *gin.Context) {
-	versions := make([]*BinaryVersion, 0, len(bm.versions))
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	versions := make([]BinaryVersion, 0, len(bm.versions))
 	for _, version := range bm.versions {
-		versions = append(versions, version)
+		listed := *version
+		listed.Channels = bm.channelsAtLocked(version.Version)
+		versions = append(versions, listed)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"binaries": versions,
-		"current":  bm.currentVersion,
+		"current":  bm.channels[ChannelStable],
+		"channels": bm.channelsLocked(),
 	})
 }
 
@@ -71,28 +101,44 @@ func (bm *AgentBinaryManager) listBinaries(c *gin.Context) {
 func (bm *AgentBinaryManager) uploadBinary(c *gin.Context) {
 	file, err := c.FormFile("binary")
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.InvalidRequest)
 		return
 	}
 
 	version := c.PostForm("version")
 	platform := c.PostForm("platform")
 	arch := c.PostForm("arch")
+	channel := c.DefaultPostForm("channel", ChannelStable)
 
 	if version == "" || platform == "" || arch == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "version, platform, and arch are required"})
+		apierror.Respond(c, apierror.InvalidRequest, "version, platform, and arch are required")
+		return
+	}
+	// A version named like a channel couldn't be told apart when downloading
+	if version == "latest" || validChannel(version) {
+		apierror.Respond(c, apierror.InvalidRequest, "version must not be latest or a channel name")
+		return
+	}
+	if channel != "" && channel != "none" && !validChannel(channel) {
+		apierror.Respond(c, apierror.InvalidRequest, channelError(channel))
 		return
 	}
 
 	// Save uploaded file
 	dst := filepath.Join(bm.binaryPath, version, platform, arch, file.Filename)
 	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.Internal)
 		return
 	}
 
 	if err := c.SaveUploadedFile(file, dst); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		apierror.RespondError(c, err, apierror.Internal)
+		return
+	}
+
+	checksum, err := fileChecksum(dst)
+	if err != nil {
+		apierror.RespondError(c, err, apierror.Internal)
 		return
 	}
 
@@ -102,11 +148,18 @@ func (bm *AgentBinaryManager) uploadBinary(c *gin.Context) {
 		Platform:  platform,
 		Arch:      arch,
 		Path:      dst,
+		Checksum:  checksum,
 		Size:      file.Size,
 		CreatedAt: time.Now(),
 	}
 
-	bm.versions[version] = binaryVersion
+	// The upload moves the channel to the new version, unless it is "none" or empty
+	bm.mu.Lock()
+	bm.versions[versionKey(version, platform, arch)] = binaryVersion
+	if validChannel(channel) {
+		bm.channels[channel] = version
+	}
+	bm.mu.Unlock()
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Binary uploaded successfully",
@@ -120,17 +173,35 @@ func (bm *AgentBinaryManager) downloadBinary(c *gin.Context) {
 	platform := c.Param("platform")
 	arch := c.Param("arch")
 
-	if version == "latest" {
-		version = bm.currentVersion
+	tokenInfo, ok := bm.authorize(c)
+	if !ok {
+		return
 	}
 
-	versionKey := filepath.Join(version, platform, arch)
-	binary, exists := bm.versions[versionKey]
+	// latest is the stable channel; a channel name resolves to its version
+	bm.mu.RLock()
+	if version == "latest" {
+		version = ChannelStable
+	}
+	if validChannel(version) {
+		version = bm.channels[version]
+	}
+	binary, exists := bm.versions[versionKey(version, platform, arch)]
+	bm.mu.RUnlock()
 	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "binary not found"})
+		apierror.Respond(c, apierror.BinaryNotFound, "binary not found")
 		return
 	}
 
+	if bm.auditLogger != nil {
+		bm.auditLogger.LogDownload(c, tokenInfo, "binary/"+versionKey(binary.Version, platform, arch), "success", map[string]interface{}{
+			"checksum": binary.Checksum,
+		})
+	}
+
+	// Agents verify the download against this checksum before self-updating
+	c.Header("X-Checksum-SHA256", binary.Checksum)
+	c.Header("X-Binary-Version", binary.Version)
 	c.File(binary.Path)
 }
 
@@ -138,24 +209,96 @@ func (bm *AgentBinaryManager) downloadBinary(c *gin.Context) {
 func (bm *AgentBinaryManager) deleteBinary(c *gin.Context) {
 	version := c.Param("version")
 
-	binary, exists := bm.versions[version]
-	if !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "binary not found"})
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+
+	// A channel must be moved off a version before it is deleted
+	if channels := bm.channelsAtLocked(version); len(channels) > 0 {
+		apierror.Respond(c, apierror.InvalidRequest, fmt.Sprintf("version %s is the release of channel %s, move the channel first", version, strings.Join(channels, ", ")))
 		return
 	}
 
-	if err := os.Remove(binary.Path); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	// Remove every platform/arch build of the version
+	found := false
+	for key, binary := range bm.versions {
+		if binary.Version != version {
+			continue
+		}
+		found = true
+
+		if err := os.Remove(binary.Path); err != nil && !os.IsNotExist(err) {
+			apierror.RespondError(c, err, apierror.Internal)
+			return
+		}
+		delete(bm.versions, key)
 	}
 
-	delete(bm.versions, version)
+	if !found {
+		apierror.Respond(c, apierror.BinaryNotFound, "binary not found")
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Binary deleted successfully",
 	})
 }
 
+// Checksums returns the SHA-256 of each uploaded binary of a version, keyed
+// by "<platform>/<arch>"
+func (bm *AgentBinaryManager) Checksums(version string) map[string]string {
+	bm.mu.RLock()
+	defer bm.mu.RUnlock()
+
+	checksums := make(map[string]string)
+	for _, binary := range bm.versions {
+		if binary.Version == version {
+			checksums[binary.Platform+"/"+binary.Arch] = binary.Checksum
+		}
+	}
+	return checksums
+}
+
+// versionKey builds the lookup key for a platform-specific binary
+func versionKey(version, platform, arch string) string {
+	return filepath.Join(version, platform, arch)
+}
+
+// fileChecksum returns the hex-encoded SHA-256 of a file
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// authorize validates the request token and writes a 401 response when it is missing or invalid
+func (bm *AgentBinaryManager) authorize(c *gin.Context) (*security.TokenInfo, bool) {
+	if bm.tokenManager == nil {
+		return nil, true
+	}
+
+	tokenInfo, err := bm.tokenManager.ValidateRequestToken(c)
+	if err != nil {
+		if bm.auditLogger != nil {
+			bm.auditLogger.LogDownload(c, nil, c.Request.URL.Path, "denied", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		apierror.Respond(c, apierror.Unauthorized, err.Error())
+		return nil, false
+	}
+
+	return tokenInfo, true
+}
+
 // serveInstallScript serves the installation script
 func (bm *AgentBinaryManager) serveInstallScript(c *gin.Context) {
 	token := c.Query("token")
@@ -168,83 +311,36 @@ func (bm *AgentBinaryManager) serveInstallScript(c *gin.Context) {
 		return
 	}
 
-	if platform == "" {
-		platform = "linux"
+	tokenInfo, ok := bm.authorize(c)
+	if !ok {
+		return
+	}
+	if bm.auditLogger != nil {
+		bm.auditLogger.LogDownload(c, tokenInfo, "install.sh", "success", map[string]interface{}{
+			"platform": platform,
+			"arch":     arch,
+		})
+	}
+
+	opts, err := install.OptionsFromQuery(c)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
 	}
-	if arch == "" {
-		arch = "amd64"
+	opts.Token = token
+	opts.ServerURL = serverURL
+	channel := c.DefaultQuery("channel", ChannelStable)
+	if !validChannel(channel) {
+		c.String(http.StatusBadRequest, channelError(channel))
+		return
 	}
+	opts.DownloadPath = "/api/binaries/download/" + channel + "/$PLATFORM/$ARCH"
 
-	script := bm.generateInstallScript(token, serverURL, platform, arch)
+	script, err := install.Render(opts)
+	if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
 	c.Header("Content-Type", "text/x-shellscript")
 	c.String(http.StatusOK, script)
 }
-
-// generateInstallScript generates the installation script
-func (bm *AgentBinaryManager) generateInstallScript(token, serverURL, platform, arch string) string {
-	return `#!/bin/bash
-
-set -e
-
-# Configuration
-TOKEN="` + token + `"
-SERVER_URL="` + serverURL + `"
-PLATFORM="` + platform + `"
-ARCH="` + arch + `"
-
-echo "Nerve Agent Installation Script"
-echo "==============================="
-
-# Detect platform if not specified
-if [ -z "$PLATFORM" ]; then
-    case "$(uname -s)" in
-        Linux*)     PLATFORM="linux" ;;
-        Darwin*)    PLATFORM="darwin" ;;
-        *)          echo "Unsupported platform" ; exit 1 ;;
-    esac
-fi
-
-# Detect arch if not specified
-if [ -z "$ARCH" ]; then
-    case "$(uname -m)" in
-        x86_64)     ARCH="amd64" ;;
-        arm64)      ARCH="arm64" ;;
-        *)          echo "Unsupported architecture" ; exit 1 ;;
-    esac
-fi
-
-echo "Platform: $PLATFORM-$ARCH"
-echo "Server: $SERVER_URL"
-
-# Download agent binary
-BINARY_URL="$SERVER_URL/api/binaries/download/latest/$PLATFORM/$ARCH"
-AGENT_PATH="/usr/local/bin/nerve-agent"
-
-echo "Downloading agent binary..."
-curl -fsSL "$BINARY_URL" -o "$AGENT_PATH"
-chmod +x "$AGENT_PATH"
-
-# Create systemd service
-cat > /etc/systemd/system/nerve-agent.service <<EOF
-[Unit]
-Description=Nerve Agent
-After=network.target
-
-[Service]
-ExecStart=$AGENT_PATH --server=$SERVER_URL --token=$TOKEN --debug
-Restart=always
-
-[Install]
-WantedBy=multi-user.target
-EOF
-
-# Enable and start service
-systemctl daemon-reload
-systemctl enable nerve-agent
-systemctl start nerve-agent
-
-echo "Nerve Agent installed and started successfully!"
-echo "Status: $(systemctl is-active nerve-agent)"
-`
-}
-
@@ -76,33 +76,10 @@ func (cm *ClusterManager) ListClusters() []*Cluster {
 	return clusters
 }
 
-// UpdateCluster updates an existing cluster
+// UpdateCluster validates and applies an update of an existing cluster,
+// without checking its agents, see UpdateClusterChecked
 func (cm *ClusterManager) UpdateCluster(id string, updates map[string]interface{}) error {
-	cm.mutex.Lock()
-	defer cm.mutex.Unlock()
-
-	cluster, exists := cm.clusters[id]
-	if !exists {
-		return fmt.Errorf("cluster %s not found", id)
-	}
-
-	// Update fields
-	if name, ok := updates["name"].(string); ok {
-		cluster.Name = name
-	}
-	if desc, ok := updates["description"].(string); ok {
-		cluster.Description = desc
-	}
-	if config, ok := updates["config"].(map[string]interface{}); ok {
-		cluster.Config = config
-	}
-	if agents, ok := updates["agents"].([]string); ok {
-		cluster.Agents = agents
-	}
-
-	cluster.UpdatedAt = time.Now()
-
-	return nil
+	return cm.UpdateClusterChecked(id, updates, nil)
 }
 
 // DeleteCluster removes a cluster
@@ -0,0 +1,200 @@
+// Package cluster provides validation of cluster updates, and checking the
+// agents of a membership change against the registered agents.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrClusterNotFound is returned for updates of a cluster that doesn't exist
+var ErrClusterNotFound = errors.New("cluster not found")
+
+// UnknownAgentsError rejects a membership change naming agents that aren't
+// registered
+type UnknownAgentsError struct {
+	AgentIDs []string
+}
+
+// Error lists the unknown agents
+func (e *UnknownAgentsError) Error() string {
+	return fmt.Sprintf("agents: unknown agents %s", strings.Join(e.AgentIDs, ", "))
+}
+
+// readOnlyFields are cluster fields an update may carry, e.g. when a client
+// sends back a cluster it fetched, but that are not changed
+var readOnlyFields = map[string]bool{"id": true, "created_at": true, "updated_at": true}
+
+// clusterUpdate is a validated update; only the fields set are changed
+type clusterUpdate struct {
+	name        *string
+	description *string
+	config      map[string]interface{}
+	setConfig   bool
+	agents      []string
+	setAgents   bool
+}
+
+// parseUpdate validates the fields of an update in name order, returning
+// an error naming the first offending field and the type it has
+func parseUpdate(updates map[string]interface{}) (*clusterUpdate, error) {
+	fields := make([]string, 0, len(updates))
+	for field := range updates {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	update := &clusterUpdate{}
+	for _, field := range fields {
+		value := updates[field]
+		switch field {
+		case "name":
+			name, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("name: must be a string, got %s", jsonType(value))
+			}
+			if strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("name: must not be empty")
+			}
+			update.name = &name
+		case "description":
+			description, ok := value.(string)
+			if value != nil && !ok {
+				return nil, fmt.Errorf("description: must be a string, got %s", jsonType(value))
+			}
+			update.description = &description
+		case "config":
+			config, ok := value.(map[string]interface{})
+			if value != nil && !ok {
+				return nil, fmt.Errorf("config: must be an object, got %s", jsonType(value))
+			}
+			update.config, update.setConfig = config, true
+		case "agents":
+			agents, err := parseAgents(value)
+			if err != nil {
+				return nil, err
+			}
+			update.agents, update.setAgents = agents, true
+		default:
+			if !readOnlyFields[field] {
+				return nil, fmt.Errorf("%s: unknown field", field)
+			}
+		}
+	}
+	return update, nil
+}
+
+// parseAgents validates a list of agent IDs, dropping duplicates; null
+// empties the cluster
+func parseAgents(value interface{}) ([]string, error) {
+	if value == nil {
+		return []string{}, nil
+	}
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case []string:
+		for _, id := range v {
+			items = append(items, id)
+		}
+	default:
+		return nil, fmt.Errorf("agents: must be a list of agent IDs, got %s", jsonType(value))
+	}
+
+	agents := make([]string, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		id, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("agents[%d]: must be a string, got %s", i, jsonType(item))
+		}
+		if id = strings.TrimSpace(id); id == "" {
+			return nil, fmt.Errorf("agents[%d]: must not be empty", i)
+		}
+		if !seen[id] {
+			seen[id] = true
+			agents = append(agents, id)
+		}
+	}
+	return agents, nil
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, int, int64:
+		return "number"
+	case []interface{}, []string:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// UpdateClusterChecked validates and applies an update of the name,
+// description, config and agents of a cluster. Nothing is changed if any
+// field is invalid. With registered set, a membership change naming agents
+// for which it returns false is rejected with an *UnknownAgentsError.
+func (cm *ClusterManager) UpdateClusterChecked(id string, updates map[string]interface{}, registered func(agentID string) bool) error {
+	update, err := parseUpdate(updates)
+	if err != nil {
+		return err
+	}
+
+	if update.setAgents && registered != nil {
+		if unknown := UnknownAgents(update.agents, registered); len(unknown) > 0 {
+			return &UnknownAgentsError{AgentIDs: unknown}
+		}
+	}
+
+	cm.mutex.Lock()
+	defer cm.mutex.Unlock()
+
+	cluster, exists := cm.clusters[id]
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrClusterNotFound, id)
+	}
+
+	if update.name != nil {
+		cluster.Name = *update.name
+	}
+	if update.description != nil {
+		cluster.Description = *update.description
+	}
+	if update.setConfig {
+		cluster.Config = update.config
+	}
+	if update.setAgents {
+		cluster.Agents = update.agents
+	}
+	cluster.UpdatedAt = time.Now()
+
+	return nil
+}
+
+// UnknownAgents returns the agent IDs for which registered returns false,
+// in order
+func UnknownAgents(agentIDs []string, registered func(agentID string) bool) []string {
+	unknown := []string{}
+	for _, id := range agentIDs {
+		if !registered(id) {
+			unknown = append(unknown, id)
+		}
+	}
+	return unknown
+}
@@ -0,0 +1,243 @@
+// Package compression provides gzip compression of large HTTP responses.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package compression
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMinSize is the smallest response body worth compressing
+const DefaultMinSize = 1024
+
+// DefaultContentTypes are the compressible response types. Binaries and
+// archives are already compressed and left out.
+var DefaultContentTypes = []string{
+	"application/json",
+	"application/javascript",
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/javascript",
+}
+
+// Config controls which responses are compressed
+type Config struct {
+	// Level is a compress/gzip level; zero means gzip.DefaultCompression
+	Level int
+	// MinSize is the body size from which a response is compressed
+	MinSize int
+	// ContentTypes are the compressible media types, without parameters
+	ContentTypes []string
+	// ExcludedPaths are path prefixes that are never compressed
+	ExcludedPaths []string
+}
+
+// Middleware gzips responses of allowlisted content types once their body
+// reaches MinSize. observe, if set, receives the size of each compressed
+// response before and after compression.
+func Middleware(cfg Config, observe func(original, compressed int)) (gin.HandlerFunc, error) {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+	if _, err := gzip.NewWriterLevel(io.Discard, cfg.Level); err != nil {
+		return nil, fmt.Errorf("invalid gzip level %d", cfg.Level)
+	}
+	if cfg.MinSize < 0 {
+		return nil, fmt.Errorf("gzip min size must not be negative")
+	}
+
+	types := make(map[string]bool, len(cfg.ContentTypes))
+	for _, contentType := range cfg.ContentTypes {
+		types[strings.ToLower(strings.TrimSpace(contentType))] = true
+	}
+	pool := sync.Pool{New: func() interface{} {
+		gz, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+		return gz
+	}}
+
+	return func(c *gin.Context) {
+		if !acceptsGzip(c.Request) || excluded(c.Request.URL.Path, cfg.ExcludedPaths) {
+			c.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: c.Writer, minSize: cfg.MinSize, types: types, pool: &pool}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+			original, compressed, ok := w.finish()
+			if ok && observe != nil {
+				observe(original, compressed)
+			}
+		}()
+
+		c.Header("Vary", "Accept-Encoding")
+		c.Next()
+	}, nil
+}
+
+// acceptsGzip reports whether a response to r may be gzipped. Range and
+// connection upgrade requests are served as is.
+func acceptsGzip(r *http.Request) bool {
+	if r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" {
+		return false
+	}
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, _, _ := strings.Cut(encoding, ";")
+		if strings.TrimSpace(name) == "gzip" {
+			return true
+		}
+	}
+	return false
+}
+
+// excluded reports whether path falls under one of the excluded prefixes
+func excluded(path string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter buffers the start of a response body until it can decide
+// whether the response is worth compressing
+type gzipWriter struct {
+	gin.ResponseWriter
+	minSize int
+	types   map[string]bool
+	pool    *sync.Pool
+
+	buf      []byte
+	decided  bool
+	gz       *gzip.Writer
+	counter  *countingWriter
+	original int
+}
+
+// countingWriter counts the compressed bytes written to the client
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += n
+	return n, err
+}
+
+// Write buffers p until the body reaches minSize, then compresses or passes it through
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	w.original += len(p)
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.minSize {
+			return len(p), nil
+		}
+		w.decide()
+		if err := w.flushBuffer(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// WriteString writes s like Write
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// WriteHeaderNow sends the header; a bodyless response is never compressed
+func (w *gzipWriter) WriteHeaderNow() {
+	if !w.decided && len(w.buf) == 0 {
+		w.decided = true
+	}
+	if w.decided {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+// Flush sends what has been written so far, deciding on compression early
+func (w *gzipWriter) Flush() {
+	if !w.decided {
+		w.decide()
+		w.flushBuffer()
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// decide starts compression if the response status and content type allow it
+func (w *gzipWriter) decide() {
+	w.decided = true
+
+	header := w.Header()
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		header.Get("Content-Encoding") != "" {
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !w.types[mediaType] {
+		return
+	}
+
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	w.counter = &countingWriter{w: w.ResponseWriter}
+	w.gz = w.pool.Get().(*gzip.Writer)
+	w.gz.Reset(w.counter)
+}
+
+// flushBuffer writes the buffered start of the body
+func (w *gzipWriter) flushBuffer() error {
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// finish writes a body that stayed below minSize uncompressed, or completes
+// the gzip stream, reporting the sizes of a compressed response
+func (w *gzipWriter) finish() (original, compressed int, ok bool) {
+	if !w.decided {
+		w.decided = true
+		w.flushBuffer()
+		return 0, 0, false
+	}
+	if w.gz == nil {
+		return 0, 0, false
+	}
+
+	w.gz.Close()
+	w.gz.Reset(io.Discard)
+	w.pool.Put(w.gz)
+	w.gz = nil
+	return w.original, w.counter.n, true
+}
@@ -0,0 +1,141 @@
+// Package config provides loading the server configuration file and telling
+// which of its settings can be applied while the server is running.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/nerve/server/pkg/alert"
+	"github.com/nerve/server/pkg/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Log levels
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+)
+
+// File is the server configuration file. Only the log level, rate limits and
+// alert rules are applied on reload; the listen address is read at startup.
+type File struct {
+	Server    ServerConfig    `yaml:"server"`
+	Log       LogConfig       `yaml:"log"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Alerts    AlertsConfig    `yaml:"alerts"`
+	Storage   storage.Config  `yaml:"storage"`
+
+	// path is where the file was loaded from
+	path string
+}
+
+// ServerConfig configures the HTTP listener
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+}
+
+// LogConfig configures logging; an empty level keeps the --debug flag
+type LogConfig struct {
+	Level string `yaml:"level"`
+}
+
+// RateLimitConfig configures the per-agent rate limit; unset fields keep
+// the command line flags
+type RateLimitConfig struct {
+	AgentRate  *float64 `yaml:"agent_rate"`
+	AgentBurst *int     `yaml:"agent_burst"`
+}
+
+// AlertsConfig names a JSON file of alert rules, relative to the
+// configuration file
+type AlertsConfig struct {
+	RulesFile string `yaml:"rules_file"`
+}
+
+// Load reads and validates a configuration file
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+
+	cfg := &File{path: path}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks the settings that are applied while running
+func (f *File) Validate() error {
+	switch f.Log.Level {
+	case "", LogLevelDebug, LogLevelInfo:
+	default:
+		return fmt.Errorf("invalid log.level %q, must be %q or %q", f.Log.Level, LogLevelDebug, LogLevelInfo)
+	}
+	if f.RateLimit.AgentRate != nil && *f.RateLimit.AgentRate < 0 {
+		return fmt.Errorf("rate_limit.agent_rate must not be negative")
+	}
+	if f.RateLimit.AgentBurst != nil && *f.RateLimit.AgentBurst < 1 {
+		return fmt.Errorf("rate_limit.agent_burst must be at least 1")
+	}
+	return nil
+}
+
+// RulesPath returns the path of the alert rules file, or "" if none is set
+func (f *File) RulesPath() string {
+	if f.Alerts.RulesFile == "" || filepath.IsAbs(f.Alerts.RulesFile) {
+		return f.Alerts.RulesFile
+	}
+	return filepath.Join(filepath.Dir(f.path), f.Alerts.RulesFile)
+}
+
+// AlertRules reads the alert rules file; no rules file yields no rules
+func (f *File) AlertRules() ([]*alert.AlertRule, error) {
+	if f.RulesPath() == "" {
+		return nil, nil
+	}
+	return alert.ReadRulesFile(f.RulesPath())
+}
+
+// Debug returns whether debug logging is enabled, falling back to def
+func (f *File) Debug(def bool) bool {
+	if f.Log.Level == "" {
+		return def
+	}
+	return f.Log.Level == LogLevelDebug
+}
+
+// AgentRateLimit returns the per-agent rate and burst, falling back to the
+// given defaults for unset fields
+func (f *File) AgentRateLimit(rate float64, burst int) (float64, int) {
+	if f.RateLimit.AgentRate != nil {
+		rate = *f.RateLimit.AgentRate
+	}
+	if f.RateLimit.AgentBurst != nil {
+		burst = *f.RateLimit.AgentBurst
+	}
+	return rate, burst
+}
+
+// Unreloadable returns the sections that differ from prev but cannot be
+// changed while the server is running
+func (f *File) Unreloadable(prev *File) []string {
+	var changed []string
+	if !reflect.DeepEqual(f.Server, prev.Server) {
+		changed = append(changed, "server")
+	}
+	if !reflect.DeepEqual(f.Storage, prev.Storage) {
+		changed = append(changed, "storage")
+	}
+	return changed
+}
@@ -0,0 +1,262 @@
+// Package install provides templated agent installation scripts.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package install
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Supported init systems
+const (
+	InitSystemd = "systemd"
+	InitOpenRC  = "openrc"
+)
+
+// DefaultInstallPath is where the agent binary is installed unless overridden
+const DefaultInstallPath = "/usr/local/bin/nerve-agent"
+
+var (
+	pathPattern = regexp.MustCompile(`^/[A-Za-z0-9_./-]+$`)
+	userPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+	flagPattern = regexp.MustCompile(`^--?[A-Za-z0-9][A-Za-z0-9_.:/=,-]*$`)
+	archPattern = regexp.MustCompile(`^[a-z0-9_]*$`)
+)
+
+// Options controls the generated install script
+type Options struct {
+	Token        string
+	ServerURL    string
+	DownloadPath string // server path the binary is fetched from, may reference $PLATFORM and $ARCH
+	Platform     string
+	Arch         string
+	InstallPath  string
+	Debug        bool
+	Interval     time.Duration
+	ExtraFlags   []string
+	ServiceUser  string
+	Init         string
+}
+
+// OptionsFromQuery reads script options from request query parameters:
+// install_path, debug, interval, extra_flags, user and init.
+func OptionsFromQuery(c *gin.Context) (Options, error) {
+	opts := Options{
+		InstallPath: c.DefaultQuery("install_path", DefaultInstallPath),
+		ServiceUser: c.Query("user"),
+		Init:        c.DefaultQuery("init", InitSystemd),
+		Platform:    c.Query("platform"),
+		Arch:        c.Query("arch"),
+	}
+
+	if value := c.Query("debug"); value != "" {
+		debug, err := strconv.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid debug value: %v", err)
+		}
+		opts.Debug = debug
+	}
+
+	if value := c.Query("interval"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return opts, fmt.Errorf("invalid interval: %v", err)
+		}
+		opts.Interval = interval
+	}
+
+	if value := c.Query("extra_flags"); value != "" {
+		opts.ExtraFlags = strings.Fields(value)
+	}
+
+	return opts, opts.Validate()
+}
+
+// Validate rejects values that are unsafe to embed in a shell script
+func (o *Options) Validate() error {
+	if o.InstallPath == "" {
+		o.InstallPath = DefaultInstallPath
+	}
+	if o.Init == "" {
+		o.Init = InitSystemd
+	}
+
+	if o.Init != InitSystemd && o.Init != InitOpenRC {
+		return fmt.Errorf("unsupported init system: %s", o.Init)
+	}
+	if !pathPattern.MatchString(o.InstallPath) || strings.Contains(o.InstallPath, "..") {
+		return fmt.Errorf("invalid install path: %s", o.InstallPath)
+	}
+	if o.ServiceUser != "" && !userPattern.MatchString(o.ServiceUser) {
+		return fmt.Errorf("invalid service user: %s", o.ServiceUser)
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("interval must be positive")
+	}
+	if !archPattern.MatchString(o.Platform) || !archPattern.MatchString(o.Arch) {
+		return fmt.Errorf("invalid platform or arch")
+	}
+	for _, extra := range o.ExtraFlags {
+		if !flagPattern.MatchString(extra) {
+			return fmt.Errorf("invalid extra flag: %s", extra)
+		}
+		// Connection settings come from the script arguments
+		if strings.HasPrefix(strings.TrimLeft(extra, "-"), "server") || strings.HasPrefix(strings.TrimLeft(extra, "-"), "token") {
+			return fmt.Errorf("extra flag %s is set by the install script", extra)
+		}
+	}
+
+	return nil
+}
+
+// AgentArgs returns the agent arguments other than --server and --token
+func (o Options) AgentArgs() string {
+	var args []string
+	if o.Debug {
+		args = append(args, "--debug")
+	}
+	if o.Interval > 0 {
+		args = append(args, "--interval="+o.Interval.String())
+	}
+	args = append(args, o.ExtraFlags...)
+	return strings.Join(args, " ")
+}
+
+// Render generates the install script for the options
+func Render(opts Options) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := scriptTemplate.Execute(&buf, opts); err != nil {
+		return "", fmt.Errorf("failed to render install script: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// shellQuote single-quotes a value for safe use in a shell script
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+var scriptTemplate = template.Must(template.New("install").Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(`#!/bin/bash
+set -e
+
+# Defaults, overridable with --token and --server
+TOKEN={{quote .Token}}
+SERVER_URL={{quote .ServerURL}}
+SERVER_URL="${SERVER_URL:-http://localhost:8090}"
+PLATFORM={{quote .Platform}}
+ARCH={{quote .Arch}}
+AGENT_PATH={{quote .InstallPath}}
+SERVICE_USER={{quote .ServiceUser}}
+AGENT_ARGS={{quote .AgentArgs}}
+
+while [[ "$#" -gt 0 ]]; do
+  case $1 in
+    --token) TOKEN="$2"; shift ;;
+    --server) SERVER_URL="$2"; shift ;;
+  esac
+  shift
+done
+
+if [ -z "$TOKEN" ]; then
+  echo "Error: --token is required"
+  exit 1
+fi
+
+if [ -z "$PLATFORM" ]; then
+  case "$(uname -s)" in
+    Linux*)  PLATFORM="linux" ;;
+    Darwin*) PLATFORM="darwin" ;;
+    *)       echo "Unsupported platform"; exit 1 ;;
+  esac
+fi
+
+if [ -z "$ARCH" ]; then
+  case "$(uname -m)" in
+    x86_64)        ARCH="amd64" ;;
+    arm64|aarch64) ARCH="arm64" ;;
+    *)             echo "Unsupported architecture"; exit 1 ;;
+  esac
+fi
+
+echo "Installing Nerve Agent ($PLATFORM-$ARCH) from $SERVER_URL..."
+
+# Download agent binary
+mkdir -p "$(dirname "$AGENT_PATH")"
+curl -fSL -H "Authorization: Bearer $TOKEN" "$SERVER_URL{{.DownloadPath}}" -o "$AGENT_PATH"
+chmod +x "$AGENT_PATH"
+
+if [ -n "$SERVICE_USER" ] && ! id -u "$SERVICE_USER" >/dev/null 2>&1; then
+  useradd --system --no-create-home --shell /sbin/nologin "$SERVICE_USER"
+fi
+{{if eq .Init "openrc"}}
+# Create OpenRC service
+cat > /etc/init.d/nerve-agent << EOF
+#!/sbin/openrc-run
+
+description="Nerve Agent"
+command="$AGENT_PATH"
+command_args="--server=$SERVER_URL --token=$TOKEN $AGENT_ARGS"
+command_background=true
+pidfile="/run/nerve-agent.pid"
+output_log="/var/log/nerve-agent.log"
+error_log="/var/log/nerve-agent.log"
+EOF
+if [ -n "$SERVICE_USER" ]; then
+  echo "command_user=\"$SERVICE_USER\"" >> /etc/init.d/nerve-agent
+fi
+cat >> /etc/init.d/nerve-agent << 'EOF'
+
+depend() {
+  need net
+}
+EOF
+chmod +x /etc/init.d/nerve-agent
+
+# Enable and start service
+rc-update add nerve-agent default
+rc-service nerve-agent restart
+{{else}}
+# Create systemd service
+cat > /etc/systemd/system/nerve-agent.service << EOF
+[Unit]
+Description=Nerve Agent
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=$AGENT_PATH --server=$SERVER_URL --token=$TOKEN $AGENT_ARGS
+Restart=always
+RestartSec=10
+TimeoutStopSec=90
+EOF
+if [ -n "$SERVICE_USER" ]; then
+  echo "User=$SERVICE_USER" >> /etc/systemd/system/nerve-agent.service
+fi
+cat >> /etc/systemd/system/nerve-agent.service << EOF
+
+[Install]
+WantedBy=multi-user.target
+EOF
+
+# Enable and start service
+systemctl daemon-reload
+systemctl enable nerve-agent
+systemctl restart nerve-agent
+{{end}}
+echo "Nerve Agent installed successfully!"
+`))
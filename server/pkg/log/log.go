@@ -1,8 +1,10 @@
 package log
 
 import (
+	"io"
 	"log"
 	"os"
+	"sync/atomic"
 )
 
 // Logger provides structured logging
@@ -18,20 +20,32 @@ type Logger interface {
 }
 
 type logger struct {
-	debug bool
+	debug atomic.Bool
 	*log.Logger
 }
 
 // New creates a new logger
 func New(debug bool) Logger {
-	return &logger{
-		debug:  debug,
-		Logger: log.New(os.Stderr, "[NerveCenter] ", log.LstdFlags),
+	return NewWithWriter(debug, os.Stderr)
+}
+
+// NewWithWriter creates a new logger writing to w
+func NewWithWriter(debug bool, w io.Writer) Logger {
+	l := &logger{Logger: log.New(w, "[NerveCenter] ", log.LstdFlags)}
+	l.debug.Store(debug)
+	return l
+}
+
+// SetDebug turns debug output of a logger created by New or NewWithWriter
+// on or off while it is in use
+func SetDebug(l Logger, debug bool) {
+	if lg, ok := l.(*logger); ok {
+		lg.debug.Store(debug)
 	}
 }
 
 func (l *logger) Debug(format string, args ...interface{}) {
-	if l.debug {
+	if l.debug.Load() {
 		l.Printf("[DEBUG] "+format, args...)
 	}
 }
@@ -66,3 +80,49 @@ func (l *logger) Debugf(format string, args ...interface{}) {
 	l.Debug(format, args...)
 }
 
+
+// requestLogger prefixes every line with a request ID
+type requestLogger struct {
+	Logger
+	prefix string
+}
+
+// WithRequestID returns a logger that tags every line with the request ID
+func WithRequestID(l Logger, requestID string) Logger {
+	if requestID == "" {
+		return l
+	}
+	return &requestLogger{Logger: l, prefix: "[req=" + requestID + "] "}
+}
+
+func (l *requestLogger) Debug(format string, args ...interface{}) {
+	l.Logger.Debug(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Info(format string, args ...interface{}) {
+	l.Logger.Info(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Error(format string, args ...interface{}) {
+	l.Logger.Error(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Fatal(format string, args ...interface{}) {
+	l.Logger.Fatal(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Fatalf(format string, args ...interface{}) {
+	l.Logger.Fatalf(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Infof(format string, args ...interface{}) {
+	l.Logger.Infof(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Errorf(format string, args ...interface{}) {
+	l.Logger.Errorf(l.prefix+format, args...)
+}
+
+func (l *requestLogger) Debugf(format string, args ...interface{}) {
+	l.Logger.Debugf(l.prefix+format, args...)
+}
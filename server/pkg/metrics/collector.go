@@ -5,6 +5,7 @@
 package metrics
 
 import (
+	"sort"
 	"sync"
 	"time"
 
@@ -15,38 +16,93 @@ import (
 // MetricsCollector collects and exposes metrics
 type MetricsCollector struct {
 	// Agent metrics
-	agentTotal           prometheus.Gauge
-	agentOnline          prometheus.Gauge
-	agentOffline         prometheus.Gauge
-	agentHeartbeatTotal  prometheus.Counter
-	agentHeartbeatErrors prometheus.Counter
+	agentTotal             prometheus.Gauge
+	agentOnline            prometheus.Gauge
+	agentOffline           prometheus.Gauge
+	agentHeartbeatTotal    prometheus.Counter
+	agentHeartbeatErrors   prometheus.Counter
+	agentHeartbeatInterval prometheus.Histogram
+	agentHeartbeatLate     prometheus.Gauge
+	agentsFlapping         prometheus.Gauge
+	agentRateLimited       *prometheus.CounterVec
+
+	// Per-agent resource metrics, labeled by agentLabelNames
+	agentCPUUsage       *prometheus.GaugeVec
+	agentMemoryUsage    *prometheus.GaugeVec
+	agentDiskUsage      *prometheus.GaugeVec
+	agentNetworkRxBytes *prometheus.GaugeVec
+	agentNetworkTxBytes *prometheus.GaugeVec
+	agentUptime         *prometheus.GaugeVec
+	agentLastHeartbeat  *prometheus.GaugeVec
+
+	// Per-GPU metrics, labeled by gpuLabelNames
+	gpuUtilization *prometheus.GaugeVec
+	gpuMemoryUsed  *prometheus.GaugeVec
+	gpuMemoryTotal *prometheus.GaugeVec
+	gpuTemperature *prometheus.GaugeVec
+	gpuPower       *prometheus.GaugeVec
 
 	// Task metrics
-	taskTotal          prometheus.Counter
-	taskSuccess        prometheus.Counter
-	taskFailed         prometheus.Counter
-	taskDuration       prometheus.Histogram
+	taskTotal    prometheus.Counter
+	taskSuccess  prometheus.Counter
+	taskFailed   prometheus.Counter
+	taskDuration prometheus.Histogram
+	taskExpired  prometheus.Counter
 
 	// System metrics
-	systemInfoUpdateTotal prometheus.Counter
+	systemInfoUpdateTotal  prometheus.Counter
 	systemInfoUpdateErrors prometheus.Counter
 
 	// Performance metrics
-	apiRequestTotal     *prometheus.CounterVec
-	apiRequestDuration  *prometheus.HistogramVec
-	apiRequestErrors    *prometheus.CounterVec
+	apiRequestTotal    *prometheus.CounterVec
+	apiRequestDuration *prometheus.HistogramVec
+	apiRequestErrors   *prometheus.CounterVec
+	apiGzipRatio       prometheus.Histogram
+
+	// WebSocket metrics
+	wsConnections      prometheus.Gauge
+	wsConnectsTotal    prometheus.Counter
+	wsDisconnectsTotal prometheus.Counter
+	wsMessagesTotal    *prometheus.CounterVec
 
 	// Data metrics
-	dataWriteTotal  prometheus.Counter
-	dataWriteErrors prometheus.Counter
-	dataReadTotal   prometheus.Counter
+	dataWriteTotal      prometheus.Counter
+	dataWriteErrors     prometheus.Counter
+	dataReadTotal       prometheus.Counter
+	dataWriteQueueDepth prometheus.Gauge
+	dataWriteDuration   prometheus.Histogram
+	dataWriteShed       prometheus.Counter
+
+	// agentLabels resolves the fleet labels of an agent; seriesLabels holds
+	// the label values of each agent's current series
+	agentLabels  func(agentID string) AgentLabels
+	seriesLabels map[string][]string
+
+	// seriesGPUs holds the GPU indexes of each agent's current GPU series
+	seriesGPUs map[string][]string
+
+	// seriesPruned counts the series deleted for agents no longer registered
+	seriesPruned prometheus.Counter
 
 	mu sync.RWMutex
 }
 
+// agentLabelNames are the labels of the per-agent series. Each label other
+// than agent_id must take few distinct values across the fleet.
+var agentLabelNames = []string{"agent_id", "cluster", "os", "gpu_vendor"}
+
+// AgentLabels are the grouping labels attached to an agent's series
+type AgentLabels struct {
+	Cluster   string
+	OS        string
+	GPUVendor string
+}
+
 // NewMetricsCollector creates a new metrics collector
 func NewMetricsCollector() *MetricsCollector {
 	return &MetricsCollector{
+		seriesLabels: make(map[string][]string),
+		seriesGPUs:   make(map[string][]string),
 		agentTotal: promauto.NewGauge(prometheus.GaugeOpts{
 			Name: "nerve_agent_total",
 			Help: "Total number of registered agents",
@@ -67,6 +123,110 @@ func NewMetricsCollector() *MetricsCollector {
 			Name: "nerve_agent_heartbeat_errors_total",
 			Help: "Total number of agent heartbeat errors",
 		}),
+		agentHeartbeatInterval: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "nerve_agent_heartbeat_interval_seconds",
+			Help:    "Observed time between consecutive heartbeats of the same agent",
+			Buckets: []float64{5, 10, 15, 20, 25, 30, 35, 45, 60, 90, 120, 300, 600},
+		}),
+		agentHeartbeatLate: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "nerve_agent_heartbeat_late",
+			Help: "Number of online agents whose last heartbeat is older than twice the expected interval",
+		}),
+		agentsFlapping: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "nerve_agents_flapping",
+			Help: "Number of agents going offline and back online too often within the flap window",
+		}),
+		agentRateLimited: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nerve_agent_rate_limited_total",
+				Help: "Total number of agent registrations and heartbeats rejected by the rate limit",
+			},
+			[]string{"endpoint"},
+		),
+		agentCPUUsage: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_cpu_usage",
+				Help: "Agent CPU usage percentage",
+			},
+			agentLabelNames,
+		),
+		agentMemoryUsage: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_memory_usage",
+				Help: "Agent memory usage percentage",
+			},
+			agentLabelNames,
+		),
+		agentDiskUsage: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_disk_usage",
+				Help: "Agent root filesystem usage percentage",
+			},
+			agentLabelNames,
+		),
+		agentNetworkRxBytes: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_network_rx_bytes",
+				Help: "Agent cumulative network bytes received",
+			},
+			agentLabelNames,
+		),
+		agentNetworkTxBytes: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_network_tx_bytes",
+				Help: "Agent cumulative network bytes transmitted",
+			},
+			agentLabelNames,
+		),
+		agentUptime: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_uptime_seconds",
+				Help: "Agent host uptime in seconds",
+			},
+			agentLabelNames,
+		),
+		agentLastHeartbeat: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_last_heartbeat_timestamp_seconds",
+				Help: "Unix timestamp of the last heartbeat received from the agent",
+			},
+			agentLabelNames,
+		),
+		gpuUtilization: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_gpu_utilization",
+				Help: "Agent GPU utilization percentage",
+			},
+			gpuLabelNames,
+		),
+		gpuMemoryUsed: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_gpu_memory_used_bytes",
+				Help: "Agent GPU memory in use",
+			},
+			gpuLabelNames,
+		),
+		gpuMemoryTotal: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_gpu_memory_total_bytes",
+				Help: "Agent GPU memory capacity",
+			},
+			gpuLabelNames,
+		),
+		gpuTemperature: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_gpu_temperature_celsius",
+				Help: "Agent GPU temperature",
+			},
+			gpuLabelNames,
+		),
+		gpuPower: promauto.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "nerve_agent_gpu_power_watts",
+				Help: "Agent GPU power draw",
+			},
+			gpuLabelNames,
+		),
 		taskTotal: promauto.NewCounter(prometheus.CounterOpts{
 			Name: "nerve_task_total",
 			Help: "Total number of tasks executed",
@@ -84,6 +244,10 @@ func NewMetricsCollector() *MetricsCollector {
 			Help:    "Task execution duration in seconds",
 			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10),
 		}),
+		taskExpired: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "nerve_task_expired_total",
+			Help: "Total number of tasks expired before completing",
+		}),
 		systemInfoUpdateTotal: promauto.NewCounter(prometheus.CounterOpts{
 			Name: "nerve_system_info_update_total",
 			Help: "Total number of system info updates",
@@ -114,6 +278,30 @@ func NewMetricsCollector() *MetricsCollector {
 			},
 			[]string{"method", "endpoint"},
 		),
+		apiGzipRatio: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "nerve_api_gzip_ratio",
+			Help:    "Compressed size as a fraction of the original size of gzipped API responses",
+			Buckets: []float64{0.05, 0.1, 0.15, 0.2, 0.3, 0.4, 0.5, 0.75, 1},
+		}),
+		wsConnections: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "nerve_websocket_connections",
+			Help: "Number of currently open WebSocket connections",
+		}),
+		wsConnectsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "nerve_websocket_connects_total",
+			Help: "Total number of WebSocket connections opened",
+		}),
+		wsDisconnectsTotal: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "nerve_websocket_disconnects_total",
+			Help: "Total number of WebSocket connections closed",
+		}),
+		wsMessagesTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "nerve_websocket_messages_total",
+				Help: "Total number of WebSocket messages by direction",
+			},
+			[]string{"direction"},
+		),
 		dataWriteTotal: promauto.NewCounter(prometheus.CounterOpts{
 			Name: "nerve_data_write_total",
 			Help: "Total number of data write operations",
@@ -126,6 +314,23 @@ func NewMetricsCollector() *MetricsCollector {
 			Name: "nerve_data_read_total",
 			Help: "Total number of data read operations",
 		}),
+		dataWriteQueueDepth: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "nerve_data_write_queue_depth",
+			Help: "Storage writes waiting for a free write slot",
+		}),
+		dataWriteDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "nerve_data_write_duration_seconds",
+			Help:    "Storage write latency, excluding time spent waiting for a slot",
+			Buckets: prometheus.DefBuckets,
+		}),
+		dataWriteShed: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "nerve_data_write_shed_total",
+			Help: "Storage writes rejected because no write slot freed up in time",
+		}),
+		seriesPruned: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "nerve_metrics_series_pruned_total",
+			Help: "Per-agent series deleted because their agent is no longer registered",
+		}),
 	}
 }
 
@@ -147,6 +352,26 @@ func (mc *MetricsCollector) RecordHeartbeat(success bool) {
 	}
 }
 
+// RecordHeartbeatInterval records the time elapsed since an agent's previous heartbeat
+func (mc *MetricsCollector) RecordHeartbeatInterval(interval time.Duration) {
+	mc.agentHeartbeatInterval.Observe(interval.Seconds())
+}
+
+// RecordRateLimited records an agent request rejected by the rate limit ("register" or "heartbeat")
+func (mc *MetricsCollector) RecordRateLimited(endpoint string) {
+	mc.agentRateLimited.WithLabelValues(endpoint).Inc()
+}
+
+// UpdateLateAgents sets the number of agents heartbeating later than expected
+func (mc *MetricsCollector) UpdateLateAgents(late int) {
+	mc.agentHeartbeatLate.Set(float64(late))
+}
+
+// UpdateFlappingAgents sets the number of agents flapping between offline and online
+func (mc *MetricsCollector) UpdateFlappingAgents(flapping int) {
+	mc.agentsFlapping.Set(float64(flapping))
+}
+
 // RecordTask records a task execution
 func (mc *MetricsCollector) RecordTask(success bool, duration time.Duration) {
 	mc.taskTotal.Inc()
@@ -158,6 +383,11 @@ func (mc *MetricsCollector) RecordTask(success bool, duration time.Duration) {
 	mc.taskDuration.Observe(duration.Seconds())
 }
 
+// RecordTaskExpired records a task that expired before completing
+func (mc *MetricsCollector) RecordTaskExpired() {
+	mc.taskExpired.Inc()
+}
+
 // RecordSystemInfoUpdate records a system info update
 func (mc *MetricsCollector) RecordSystemInfoUpdate(success bool) {
 	mc.systemInfoUpdateTotal.Inc()
@@ -176,6 +406,30 @@ func (mc *MetricsCollector) RecordAPIRequest(method, endpoint string, status int
 	}
 }
 
+// RecordCompression records the sizes of a gzipped response before and after compression
+func (mc *MetricsCollector) RecordCompression(original, compressed int) {
+	if original > 0 {
+		mc.apiGzipRatio.Observe(float64(compressed) / float64(original))
+	}
+}
+
+// RecordWebSocketConnect records a new WebSocket connection
+func (mc *MetricsCollector) RecordWebSocketConnect() {
+	mc.wsConnectsTotal.Inc()
+	mc.wsConnections.Inc()
+}
+
+// RecordWebSocketDisconnect records a closed WebSocket connection
+func (mc *MetricsCollector) RecordWebSocketDisconnect() {
+	mc.wsDisconnectsTotal.Inc()
+	mc.wsConnections.Dec()
+}
+
+// RecordWebSocketMessage records a WebSocket message ("in" or "out")
+func (mc *MetricsCollector) RecordWebSocketMessage(direction string) {
+	mc.wsMessagesTotal.WithLabelValues(direction).Inc()
+}
+
 // RecordDataWrite records a data write operation
 func (mc *MetricsCollector) RecordDataWrite(success bool) {
 	mc.dataWriteTotal.Inc()
@@ -184,6 +438,22 @@ func (mc *MetricsCollector) RecordDataWrite(success bool) {
 	}
 }
 
+// AddDataWriteQueueDepth tracks storage writes starting (+1) or ending (-1)
+// to wait for a write slot
+func (mc *MetricsCollector) AddDataWriteQueueDepth(delta int) {
+	mc.dataWriteQueueDepth.Add(float64(delta))
+}
+
+// RecordDataWriteDuration records the latency of a storage write
+func (mc *MetricsCollector) RecordDataWriteDuration(duration time.Duration) {
+	mc.dataWriteDuration.Observe(duration.Seconds())
+}
+
+// RecordDataWriteShed records a storage write rejected under load
+func (mc *MetricsCollector) RecordDataWriteShed() {
+	mc.dataWriteShed.Inc()
+}
+
 // RecordDataRead records a data read operation
 func (mc *MetricsCollector) RecordDataRead() {
 	mc.dataReadTotal.Inc()
@@ -191,19 +461,160 @@ func (mc *MetricsCollector) RecordDataRead() {
 
 // AgentMetrics represents agent-specific metrics
 type AgentMetrics struct {
-	CPUUsage        float64
-	MemoryUsage     float64
-	DiskUsage       float64
-	NetworkRxBytes  int64
-	NetworkTxBytes  int64
-	Uptime          time.Duration
-	LastHeartbeat   time.Time
+	CPUUsage       float64
+	MemoryUsage    float64
+	DiskUsage      float64
+	NetworkRxBytes int64
+	NetworkTxBytes int64
+	Uptime         time.Duration
+	LastHeartbeat  time.Time
+
+	// GPUs holds per-GPU metrics; empty for agents without NVIDIA GPUs
+	GPUs []GPUMetrics
 }
 
-// CollectAgentMetrics collects metrics from an agent
+// AgentMetricsFromMap builds AgentMetrics from a heartbeat "metrics" payload
+func AgentMetricsFromMap(data map[string]interface{}) AgentMetrics {
+	metrics := AgentMetrics{LastHeartbeat: time.Now()}
+
+	if v, ok := data["cpu_usage"].(float64); ok {
+		metrics.CPUUsage = v
+	}
+	if v, ok := data["memory_usage"].(float64); ok {
+		metrics.MemoryUsage = v
+	}
+	if v, ok := data["disk_usage"].(float64); ok {
+		metrics.DiskUsage = v
+	}
+	if v, ok := data["network_rx_bytes"].(float64); ok {
+		metrics.NetworkRxBytes = int64(v)
+	}
+	if v, ok := data["network_tx_bytes"].(float64); ok {
+		metrics.NetworkTxBytes = int64(v)
+	}
+	if v, ok := data["uptime_seconds"].(float64); ok {
+		metrics.Uptime = time.Duration(v) * time.Second
+	}
+	metrics.GPUs = gpuMetricsFromList(data["gpus"])
+
+	return metrics
+}
+
+// SetAgentLabelResolver sets the lookup of the grouping labels of an agent
+func (mc *MetricsCollector) SetAgentLabelResolver(resolver func(agentID string) AgentLabels) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	mc.agentLabels = resolver
+}
+
+// CollectAgentMetrics exposes agent-specific metrics as Prometheus gauge vectors
 func (mc *MetricsCollector) CollectAgentMetrics(agentID string, metrics AgentMetrics) {
-	// TODO: Store agent-specific metrics in a time series database
-	// For now, we'll use Prometheus Gauge vectors
+	mc.mu.RLock()
+	resolver := mc.agentLabels
+	mc.mu.RUnlock()
+
+	var labels AgentLabels
+	if resolver != nil {
+		labels = resolver(agentID)
+	}
+	values := []string{agentID, labels.Cluster, labels.OS, labels.GPUVendor}
+
+	// Drop the agent's old series when its labels change, e.g. when it
+	// joins a cluster, so it isn't reported twice
+	mc.mu.Lock()
+	if previous, ok := mc.seriesLabels[agentID]; ok && !equalLabels(previous, values) {
+		mc.deleteAgentSeries(agentID)
+	}
+	mc.seriesLabels[agentID] = values
+	mc.updateGPUSeriesLocked(agentID, metrics.GPUs)
+	mc.mu.Unlock()
+
+	mc.agentCPUUsage.WithLabelValues(values...).Set(metrics.CPUUsage)
+	mc.agentMemoryUsage.WithLabelValues(values...).Set(metrics.MemoryUsage)
+	mc.agentDiskUsage.WithLabelValues(values...).Set(metrics.DiskUsage)
+	mc.agentNetworkRxBytes.WithLabelValues(values...).Set(float64(metrics.NetworkRxBytes))
+	mc.agentNetworkTxBytes.WithLabelValues(values...).Set(float64(metrics.NetworkTxBytes))
+	mc.agentUptime.WithLabelValues(values...).Set(metrics.Uptime.Seconds())
+	mc.agentLastHeartbeat.WithLabelValues(values...).Set(float64(metrics.LastHeartbeat.Unix()))
+	mc.collectGPUMetrics(values, metrics.GPUs)
+}
+
+// RemoveAgentMetrics deletes all per-agent series so deregistered agents
+// don't linger, and returns the number of series deleted
+func (mc *MetricsCollector) RemoveAgentMetrics(agentID string) int {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	return mc.removeAgentLocked(agentID)
+}
+
+// PruneAgentMetrics deletes the series of the agents for which registered
+// returns false, e.g. agents removed through another server sharing the
+// registry, and returns those agents and the number of series deleted
+func (mc *MetricsCollector) PruneAgentMetrics(registered func(agentID string) bool) ([]string, int) {
+	mc.mu.RLock()
+	var stale []string
+	for agentID := range mc.seriesLabels {
+		if !registered(agentID) {
+			stale = append(stale, agentID)
+		}
+	}
+	mc.mu.RUnlock()
+
+	pruned := 0
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	for _, agentID := range stale {
+		pruned += mc.removeAgentLocked(agentID)
+	}
+	sort.Strings(stale)
+	return stale, pruned
+}
+
+// removeAgentLocked deletes an agent's series and forgets its labels,
+// counting the series deleted; caller must hold mc.mu
+func (mc *MetricsCollector) removeAgentLocked(agentID string) int {
+	deleted := mc.deleteAgentSeries(agentID)
+	delete(mc.seriesLabels, agentID)
+	delete(mc.seriesGPUs, agentID)
+	mc.seriesPruned.Add(float64(deleted))
+	return deleted
+}
+
+// deleteAgentSeries deletes the series of an agent whatever their other
+// labels and returns how many were deleted
+func (mc *MetricsCollector) deleteAgentSeries(agentID string) int {
+	deleted := 0
+	match := prometheus.Labels{"agent_id": agentID}
+	for _, vec := range []*prometheus.GaugeVec{
+		mc.agentCPUUsage,
+		mc.agentMemoryUsage,
+		mc.agentDiskUsage,
+		mc.agentNetworkRxBytes,
+		mc.agentNetworkTxBytes,
+		mc.agentUptime,
+		mc.agentLastHeartbeat,
+	} {
+		deleted += vec.DeletePartialMatch(match)
+	}
+	for _, vec := range mc.gpuVecs() {
+		deleted += vec.DeletePartialMatch(match)
+	}
+	return deleted
+}
+
+// equalLabels reports whether two label value lists are the same
+func equalLabels(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 // GetMetricsSnapshot returns a snapshot of current metrics
@@ -213,7 +624,7 @@ func (mc *MetricsCollector) GetMetricsSnapshot() map[string]interface{} {
 
 	return map[string]interface{}{
 		"agent_total":     getGaugeValue(mc.agentTotal),
-		"agent_online":   getGaugeValue(mc.agentOnline),
+		"agent_online":    getGaugeValue(mc.agentOnline),
 		"agent_offline":   getGaugeValue(mc.agentOffline),
 		"heartbeat_total": getCounterValue(mc.agentHeartbeatTotal),
 		"task_total":      getCounterValue(mc.taskTotal),
@@ -232,4 +643,3 @@ func getCounterValue(counter prometheus.Counter) float64 {
 	// TODO: Implement actual counter value reading
 	return 0
 }
-
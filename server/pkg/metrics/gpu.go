@@ -0,0 +1,103 @@
+// Package metrics provides the per-GPU series of agents with NVIDIA GPUs.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package metrics
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gpuLabelNames are the labels of the per-GPU series: the agent labels plus
+// the GPU index on the host
+var gpuLabelNames = []string{"agent_id", "cluster", "os", "gpu_vendor", "gpu"}
+
+// bytesPerMB converts the MiB nvidia-smi reports to bytes
+const bytesPerMB = 1024 * 1024
+
+// GPUMetrics represents the metrics of one GPU of an agent
+type GPUMetrics struct {
+	Index            int
+	Utilization      float64
+	MemoryUsedBytes  float64
+	MemoryTotalBytes float64
+	Temperature      float64
+	PowerWatts       float64
+}
+
+// gpuMetricsFromList builds GPUMetrics from the "gpus" list of a heartbeat
+// "metrics" payload, skipping entries without an index
+func gpuMetricsFromList(data interface{}) []GPUMetrics {
+	list, ok := data.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	gpus := make([]GPUMetrics, 0, len(list))
+	for _, item := range list {
+		gpu, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		index, ok := gpu["index"].(float64)
+		if !ok {
+			continue
+		}
+		metrics := GPUMetrics{Index: int(index)}
+		metrics.Utilization, _ = gpu["utilization"].(float64)
+		metrics.Temperature, _ = gpu["temperature_c"].(float64)
+		metrics.PowerWatts, _ = gpu["power_draw_watts"].(float64)
+		if v, ok := gpu["memory_used_mb"].(float64); ok {
+			metrics.MemoryUsedBytes = v * bytesPerMB
+		}
+		if v, ok := gpu["memory_total_mb"].(float64); ok {
+			metrics.MemoryTotalBytes = v * bytesPerMB
+		}
+		gpus = append(gpus, metrics)
+	}
+	return gpus
+}
+
+// updateGPUSeriesLocked drops an agent's GPU series when its set of GPUs
+// changes, e.g. after a GPU falls off the bus, so the missing GPU doesn't
+// keep reporting its last values; caller must hold mc.mu
+func (mc *MetricsCollector) updateGPUSeriesLocked(agentID string, gpus []GPUMetrics) {
+	indexes := make([]string, len(gpus))
+	for i, gpu := range gpus {
+		indexes[i] = strconv.Itoa(gpu.Index)
+	}
+
+	if previous, ok := mc.seriesGPUs[agentID]; ok && !equalLabels(previous, indexes) {
+		match := prometheus.Labels{"agent_id": agentID}
+		for _, vec := range mc.gpuVecs() {
+			vec.DeletePartialMatch(match)
+		}
+	}
+	mc.seriesGPUs[agentID] = indexes
+}
+
+// collectGPUMetrics sets the GPU series of an agent with the given agent
+// label values
+func (mc *MetricsCollector) collectGPUMetrics(values []string, gpus []GPUMetrics) {
+	for _, gpu := range gpus {
+		labels := append(append([]string{}, values...), strconv.Itoa(gpu.Index))
+		mc.gpuUtilization.WithLabelValues(labels...).Set(gpu.Utilization)
+		mc.gpuMemoryUsed.WithLabelValues(labels...).Set(gpu.MemoryUsedBytes)
+		mc.gpuMemoryTotal.WithLabelValues(labels...).Set(gpu.MemoryTotalBytes)
+		mc.gpuTemperature.WithLabelValues(labels...).Set(gpu.Temperature)
+		mc.gpuPower.WithLabelValues(labels...).Set(gpu.PowerWatts)
+	}
+}
+
+// gpuVecs returns the per-GPU gauge vectors
+func (mc *MetricsCollector) gpuVecs() []*prometheus.GaugeVec {
+	return []*prometheus.GaugeVec{
+		mc.gpuUtilization,
+		mc.gpuMemoryUsed,
+		mc.gpuMemoryTotal,
+		mc.gpuTemperature,
+		mc.gpuPower,
+	}
+}
@@ -0,0 +1,332 @@
+// Package retention provides the data retention scheduler, which
+// periodically removes heartbeats, resolved alerts, completed tasks and
+// audit events older than their configured retention.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/storage"
+)
+
+// Kinds of data the retention policy covers
+const (
+	KindHeartbeats     = "heartbeats"
+	KindResolvedAlerts = "resolved_alerts"
+	KindCompletedTasks = "completed_tasks"
+	KindAuditLogs      = "audit_logs"
+)
+
+const (
+	// DefaultHeartbeatRetention is how long heartbeat history is kept
+	DefaultHeartbeatRetention = 7 * 24 * time.Hour
+
+	// DefaultResolvedAlertRetention is how long resolved alerts are kept
+	DefaultResolvedAlertRetention = 30 * 24 * time.Hour
+
+	// DefaultAuditLogRetention is how long audit events are kept
+	DefaultAuditLogRetention = 90 * 24 * time.Hour
+
+	// DefaultInterval is how often cleanup runs
+	DefaultInterval = time.Hour
+
+	// minInterval keeps cleanup runs, which scan whole tables, apart
+	minInterval = time.Minute
+
+	// lockName names the storage lock that lets one server at a time clean
+	// up the storage shared by all servers
+	lockName = "retention"
+)
+
+// Policy sets how long each kind of data is kept, zero keeping it forever,
+// and how often cleanup runs, zero disabling it
+type Policy struct {
+	Heartbeats     time.Duration
+	ResolvedAlerts time.Duration
+	CompletedTasks time.Duration
+	AuditLogs      time.Duration
+	Interval       time.Duration
+}
+
+// DefaultPolicy returns the default retention policy
+func DefaultPolicy() Policy {
+	return Policy{
+		Heartbeats:     DefaultHeartbeatRetention,
+		ResolvedAlerts: DefaultResolvedAlertRetention,
+		CompletedTasks: storage.DefaultTaskResultRetention,
+		AuditLogs:      DefaultAuditLogRetention,
+		Interval:       DefaultInterval,
+	}
+}
+
+// Validate checks the retentions and interval of the policy
+func (p Policy) Validate() error {
+	for _, kind := range []string{KindHeartbeats, KindResolvedAlerts, KindCompletedTasks, KindAuditLogs} {
+		if p.Retention(kind) < 0 {
+			return fmt.Errorf("%s retention must not be negative", kind)
+		}
+	}
+	if p.Interval < 0 || (p.Interval > 0 && p.Interval < minInterval) {
+		return fmt.Errorf("retention interval must be 0 or at least %v", minInterval)
+	}
+	return nil
+}
+
+// Retention returns how long a kind of data is kept
+func (p Policy) Retention(kind string) time.Duration {
+	switch kind {
+	case KindHeartbeats:
+		return p.Heartbeats
+	case KindResolvedAlerts:
+		return p.ResolvedAlerts
+	case KindCompletedTasks:
+		return p.CompletedTasks
+	case KindAuditLogs:
+		return p.AuditLogs
+	}
+	return 0
+}
+
+// MarshalJSON writes the durations of the policy as strings such as "168h0m0s"
+func (p Policy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		KindHeartbeats:     p.Heartbeats.String(),
+		KindResolvedAlerts: p.ResolvedAlerts.String(),
+		KindCompletedTasks: p.CompletedTasks.String(),
+		KindAuditLogs:      p.AuditLogs.String(),
+		"interval":         p.Interval.String(),
+	})
+}
+
+// Pruner removes the data of one kind older than cutoff and returns how
+// many records it removed
+type Pruner func(cutoff time.Time) (int64, error)
+
+// KindResult reports the cleanup of one kind of data in a run
+type KindResult struct {
+	Cutoff  time.Time `json:"cutoff"`
+	Removed int64     `json:"removed"`
+	Skipped string    `json:"skipped,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// RunResult reports a cleanup run
+type RunResult struct {
+	StartedAt  time.Time              `json:"started_at"`
+	FinishedAt time.Time              `json:"finished_at"`
+	Results    map[string]*KindResult `json:"results"`
+}
+
+// Status reports the policy of a manager and its last cleanup run
+type Status struct {
+	Policy  Policy     `json:"policy"`
+	Owner   string     `json:"owner"`
+	LastRun *RunResult `json:"last_run"`
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// pruner is a registered Pruner; shared pruners clean up the storage all
+// servers share and run under the storage lock
+type pruner struct {
+	prune  Pruner
+	shared bool
+}
+
+// Manager runs cleanup on the interval of its policy. Data kept by each
+// server, such as its alerts and audit log, is cleaned up by every server;
+// the storage shared by all servers is cleaned up by the one holding the
+// storage lock, if the backend has locks.
+type Manager struct {
+	mu      sync.Mutex
+	store   storage.Storage
+	logger  log.Logger
+	policy  Policy
+	owner   string
+	pruners map[string]pruner
+	lastRun *RunResult
+	nextRun time.Time
+}
+
+// NewManager creates a retention manager for a policy, cleaning up the
+// heartbeats and task results of store if it keeps any
+func NewManager(store storage.Storage, policy Policy, logger log.Logger) (*Manager, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	m := &Manager{
+		store:   store,
+		logger:  logger,
+		policy:  policy,
+		owner:   fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		pruners: make(map[string]pruner),
+	}
+	if cleaner, ok := store.(storage.Cleaner); ok {
+		m.pruners[KindHeartbeats] = pruner{prune: cleaner.DeleteHeartbeatsBefore, shared: true}
+		m.pruners[KindCompletedTasks] = pruner{prune: cleaner.DeleteTaskResultsBefore, shared: true}
+	}
+	return m, nil
+}
+
+// Register adds a pruner for data of a kind kept by this server alone; it
+// runs on every server, without the storage lock
+func (m *Manager) Register(kind string, prune Pruner) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pruners[kind] = pruner{prune: prune}
+}
+
+// Start runs cleanup on the policy interval in the background; it does
+// nothing if the interval is zero
+func (m *Manager) Start() {
+	if m.policy.Interval <= 0 {
+		return
+	}
+
+	m.setNextRun(time.Now().Add(m.policy.Interval))
+	go func() {
+		ticker := time.NewTicker(m.policy.Interval)
+		defer ticker.Stop()
+
+		for tick := range ticker.C {
+			m.setNextRun(tick.Add(m.policy.Interval))
+			m.run()
+		}
+	}()
+}
+
+// setNextRun records when the next run is due
+func (m *Manager) setNextRun(next time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextRun = next
+}
+
+// run removes the data older than its retention, skipping the shared
+// storage if another server holds the storage lock
+func (m *Manager) run() *RunResult {
+	now := time.Now()
+	run := &RunResult{StartedAt: now, Results: make(map[string]*KindResult)}
+
+	m.mu.Lock()
+	pruners := make(map[string]pruner, len(m.pruners))
+	for kind, p := range m.pruners {
+		pruners[kind] = p
+	}
+	m.mu.Unlock()
+
+	var local, shared []string
+	for kind, p := range pruners {
+		if m.policy.Retention(kind) <= 0 {
+			continue
+		}
+		if p.shared {
+			shared = append(shared, kind)
+		} else {
+			local = append(local, kind)
+		}
+	}
+	sort.Strings(local)
+	sort.Strings(shared)
+
+	for _, kind := range local {
+		if result := m.prune(kind, pruners[kind].prune, now); result != nil {
+			run.Results[kind] = result
+		}
+	}
+	if len(shared) > 0 {
+		unlock, skipped := m.lock()
+		for _, kind := range shared {
+			if skipped != "" {
+				run.Results[kind] = &KindResult{Cutoff: now.Add(-m.policy.Retention(kind)), Skipped: skipped}
+				continue
+			}
+			if result := m.prune(kind, pruners[kind].prune, now); result != nil {
+				run.Results[kind] = result
+			}
+		}
+		unlock()
+	}
+
+	run.FinishedAt = time.Now()
+	m.mu.Lock()
+	m.lastRun = run
+	m.mu.Unlock()
+	return run
+}
+
+// prune cleans up one kind of data, returning nil if the pruner reports
+// ErrNoCleanup, i.e. the storage turns out to keep none
+func (m *Manager) prune(kind string, prune Pruner, now time.Time) *KindResult {
+	result := &KindResult{Cutoff: now.Add(-m.policy.Retention(kind))}
+	removed, err := prune(result.Cutoff)
+	if errors.Is(err, storage.ErrNoCleanup) {
+		return nil
+	}
+	result.Removed = removed
+	if err != nil {
+		result.Error = err.Error()
+		m.logger.Errorf("Failed to clean up %s older than %s: %v", kind, result.Cutoff.Format(time.RFC3339), err)
+	} else if removed > 0 {
+		m.logger.Infof("Removed %d %s older than %s", removed, kind, result.Cutoff.Format(time.RFC3339))
+	}
+	return result
+}
+
+// lock takes the storage lock for cleaning up the shared storage. It
+// returns the function releasing it, or why the shared storage is skipped.
+// Storages without locks are not shared and are cleaned up unlocked.
+func (m *Manager) lock() (func(), string) {
+	locker, ok := m.store.(storage.Locker)
+	if !ok {
+		return func() {}, ""
+	}
+
+	// Outlive a run, but expire before the next if the holder goes away
+	ttl := m.policy.Interval
+	if ttl <= 0 {
+		ttl = DefaultInterval
+	}
+	acquired, err := locker.TryLock(lockName, m.owner, ttl)
+	switch {
+	case errors.Is(err, storage.ErrNoLocks):
+		return func() {}, ""
+	case err != nil:
+		m.logger.Errorf("Failed to take the retention lock: %v", err)
+		return func() {}, fmt.Sprintf("failed to take the retention lock: %v", err)
+	case !acquired:
+		return func() {}, "another server is cleaning up"
+	}
+
+	return func() {
+		if err := locker.Unlock(lockName, m.owner); err != nil {
+			m.logger.Errorf("Failed to release the retention lock: %v", err)
+		}
+	}, ""
+}
+
+// Status returns the policy and the last run
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := Status{Policy: m.policy, Owner: m.owner, LastRun: m.lastRun}
+	if !m.nextRun.IsZero() {
+		next := m.nextRun
+		status.NextRun = &next
+	}
+	return status
+}
@@ -0,0 +1,34 @@
+// Package rpc provides the JSON codec used by the Nerve gRPC service.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package rpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the gRPC content subtype of the JSON codec
+const CodecName = "json"
+
+// jsonCodec marshals gRPC messages as JSON so that the REST payload types can
+// be reused as-is and agents need no generated protobuf code
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CodecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
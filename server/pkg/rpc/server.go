@@ -0,0 +1,441 @@
+// Package rpc provides a gRPC server for agent communication that shares the
+// registry and scheduler with the REST API.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package rpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/metrics"
+	"github.com/nerve/server/pkg/security"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// stopGracePeriod bounds how long Stop waits for RPCs to finish
+const stopGracePeriod = 5 * time.Second
+
+// Server implements AgentServiceServer on top of the registry and scheduler
+type Server struct {
+	registry  *core.Registry
+	scheduler *core.Scheduler
+	metrics   *metrics.MetricsCollector
+	logger    log.Logger
+	server    *grpc.Server
+
+	// limiter throttles registrations and heartbeats per agent
+	limiter *security.RateLimiter
+
+	// tokens validates the tokens of calls, see SetTokenManager
+	tokens *security.TokenManager
+
+	// permissions checks the permissions tokens grant, see SetPermissionManager
+	permissions *security.PermissionManager
+}
+
+// permission is an action on a resource a method requires
+type permission struct {
+	resource string
+	action   string
+}
+
+// methodPermissions are the permissions each method requires when RBAC is
+// enforced, the same as the REST endpoints the methods mirror
+var methodPermissions = map[string][]permission{
+	"Register":     {{"agents", "report"}},
+	"Heartbeat":    {{"agents", "report"}},
+	"Tasks":        {{"tasks", "execute"}, {"agents", "report"}},
+	"ReportResult": {{"tasks", "execute"}},
+}
+
+// NewServer creates a gRPC server. A nil tlsConfig serves plaintext.
+func NewServer(registry *core.Registry, scheduler *core.Scheduler, metricsCollector *metrics.MetricsCollector, logger log.Logger, tlsConfig *tls.Config) *Server {
+	s := &Server{
+		registry:  registry,
+		scheduler: scheduler,
+		metrics:   metricsCollector,
+		logger:    logger,
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.UnaryInterceptor(s.unaryAuthInterceptor),
+		grpc.StreamInterceptor(s.streamAuthInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	s.server = grpc.NewServer(opts...)
+	s.server.RegisterService(&ServiceDesc, s)
+
+	return s
+}
+
+// SetRateLimiter sets the limiter applied to agent registrations and heartbeats
+func (s *Server) SetRateLimiter(limiter *security.RateLimiter) {
+	s.limiter = limiter
+}
+
+// SetTokenManager validates the token of every call. Without it any token
+// is accepted, and the last registration of an agent wins.
+func (s *Server) SetTokenManager(tokens *security.TokenManager) {
+	s.tokens = tokens
+}
+
+// SetPermissionManager requires tokens to grant the permissions of the REST
+// endpoint a method mirrors. It only applies along with SetTokenManager.
+func (s *Server) SetPermissionManager(permissions *security.PermissionManager) {
+	s.permissions = permissions
+}
+
+// allow applies the agent rate limit, recording rejected requests
+func (s *Server) allow(endpoint, key string) bool {
+	if s.limiter.Allow(endpoint + ":" + key) {
+		return true
+	}
+	if s.metrics != nil {
+		s.metrics.RecordRateLimited(endpoint)
+	}
+	return false
+}
+
+// Serve accepts connections on the listener until Stop is called
+func (s *Server) Serve(lis net.Listener) error {
+	return s.server.Serve(lis)
+}
+
+// Stop stops the server. Long-lived agent streams are closed after a short
+// grace period so shutdown does not wait on them.
+func (s *Server) Stop() {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stopGracePeriod):
+		s.server.Stop()
+	}
+}
+
+// Register registers an agent
+func (s *Server) Register(ctx context.Context, req *core.RegisterRequest) (*RegisterResponse, error) {
+	if err := req.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if !s.allow("register", req.Hostname) {
+		return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+	token := tokenFromContext(ctx)
+	if s.registry.TokenRetired(token) {
+		return nil, status.Error(codes.PermissionDenied, "token belongs to a decommissioned agent")
+	}
+
+	// A token issued for an agent registers only that agent, and an agent
+	// registered with another valid token can't be taken over
+	agentID := req.AgentID
+	replaces := func(string) bool { return true }
+	if tokenInfo := tokenInfoFromContext(ctx); tokenInfo != nil {
+		if tokenInfo.AgentID != "" {
+			if agentID != "" && agentID != tokenInfo.AgentID {
+				return nil, status.Error(codes.PermissionDenied, "token is issued for agent "+tokenInfo.AgentID)
+			}
+			agentID = tokenInfo.AgentID
+		}
+		replaces = func(bound string) bool { return s.tokens.Replaces(tokenInfo, bound) }
+	}
+
+	id, err := s.registry.RegisterWithToken(req.AgentInfo(agentID), token, replaces)
+	if err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+
+	return &RegisterResponse{ID: id, Status: "registered"}, nil
+}
+
+// Heartbeat consumes heartbeats until the agent closes the stream. A
+// heartbeat for an agent bound to another token ends the stream.
+func (s *Server) Heartbeat(stream grpc.ServerStream) error {
+	token := tokenFromContext(stream.Context())
+	if s.registry.TokenRetired(token) {
+		return status.Error(codes.PermissionDenied, "token belongs to a decommissioned agent")
+	}
+
+	received := 0
+	for {
+		var hb core.Heartbeat
+		if err := stream.RecvMsg(&hb); err != nil {
+			if err == io.EOF {
+				return stream.SendMsg(&HeartbeatSummary{Received: received})
+			}
+			if s.metrics != nil && status.Code(err) != codes.Canceled {
+				s.metrics.RecordHeartbeat(false)
+			}
+			return err
+		}
+
+		// Heartbeats over the limit are dropped; the stream stays open
+		if !s.allow("heartbeat", hb.Sender()) {
+			continue
+		}
+		// Only the token an agent registered with reports for it
+		if agentID, ok := s.heartbeatTokenMatches(&hb, token); !ok {
+			return status.Error(codes.PermissionDenied, "token does not belong to agent "+agentID)
+		}
+		received++
+		s.recordHeartbeat(&hb)
+	}
+}
+
+// Tasks pushes pending tasks to the agent as soon as they are submitted.
+// The first message from the agent identifies it; later messages may carry
+// task results. Only the agent's token subscribes, and a result for a task
+// of another agent ends the stream.
+func (s *Server) Tasks(stream grpc.ServerStream) error {
+	var subscribe TaskStreamRequest
+	if err := stream.RecvMsg(&subscribe); err != nil {
+		return err
+	}
+	if subscribe.AgentID == "" {
+		return status.Error(codes.InvalidArgument, "agent_id is required")
+	}
+	if !s.holdsAgent(stream.Context(), subscribe.AgentID) {
+		return status.Error(codes.PermissionDenied, "token does not belong to agent "+subscribe.AgentID)
+	}
+
+	notify, cancel := s.scheduler.Watch(subscribe.AgentID)
+	defer cancel()
+
+	s.logger.Infof("Agent %s subscribed to task stream", subscribe.AgentID)
+	defer s.logger.Infof("Agent %s task stream closed", subscribe.AgentID)
+
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			var msg TaskStreamRequest
+			if err := stream.RecvMsg(&msg); err != nil {
+				recvErr <- err
+				return
+			}
+			if msg.Result == nil {
+				continue
+			}
+			if err := s.recordResult(stream.Context(), msg.Result, msg.RequestID); status.Code(err) == codes.PermissionDenied {
+				recvErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		for _, task := range s.scheduler.DispatchTasks(subscribe.AgentID) {
+			if err := stream.SendMsg(task); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-notify:
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// ReportResult records a task result
+func (s *Server) ReportResult(ctx context.Context, result *core.TaskResult) (*ReportResultResponse, error) {
+	if err := s.recordResult(ctx, result, requestIDFromContext(ctx)); err != nil {
+		return nil, err
+	}
+
+	return &ReportResultResponse{Status: "received", TaskID: result.TaskID}, nil
+}
+
+// recordHeartbeat applies a heartbeat the same way the REST handler does
+func (s *Server) recordHeartbeat(hb *core.Heartbeat) {
+	agent, interval := s.registry.Heartbeat(hb)
+	if s.metrics == nil {
+		return
+	}
+
+	if agent != nil {
+		if interval > 0 {
+			s.metrics.RecordHeartbeatInterval(interval)
+		}
+		if hb.Metrics != nil {
+			s.metrics.CollectAgentMetrics(agent.ID, metrics.AgentMetricsFromMap(hb.Metrics))
+		}
+	}
+	s.metrics.RecordHeartbeat(true)
+}
+
+// recordResult marks a task done. Results for unknown tasks are NotFound,
+// and those for tasks of an agent the caller doesn't hold PermissionDenied.
+func (s *Server) recordResult(ctx context.Context, result *core.TaskResult, requestID string) error {
+	task := s.scheduler.GetTask(result.TaskID)
+	if task == nil {
+		log.WithRequestID(s.logger, requestID).Errorf("Result for unknown task: %s", result.TaskID)
+		return status.Error(codes.NotFound, "task not found")
+	}
+	// Only the agent the task was sent to reports its result
+	if !s.holdsAgent(ctx, task.AgentID) {
+		log.WithRequestID(s.logger, requestID).Errorf("Result for task %s of agent %s from another token", result.TaskID, task.AgentID)
+		return status.Error(codes.PermissionDenied, "token does not belong to agent "+task.AgentID)
+	}
+
+	s.scheduler.RecordBenchmarkResult(result)
+	s.scheduler.MarkTaskDone(result.TaskID, result.Success, result.Output, result.Error)
+	return nil
+}
+
+// heartbeatTokenMatches reports whether token is the one the agent a
+// heartbeat comes from registered with, returning the agent's ID, like the
+// REST heartbeat endpoints
+func (s *Server) heartbeatTokenMatches(hb *core.Heartbeat, token string) (string, bool) {
+	agentID := s.registry.HeartbeatAgentID(hb)
+	if agentID == "" {
+		return "", true
+	}
+	bound := s.registry.ClaimToken(agentID, token)
+	return agentID, subtle.ConstantTimeCompare([]byte(bound), []byte(token)) == 1
+}
+
+// holdsAgent reports whether the call's token was issued for an agent or is
+// the one the agent is bound to. Without a token manager, an agent not bound
+// to a token accepts any caller.
+func (s *Server) holdsAgent(ctx context.Context, agentID string) bool {
+	tokenInfo := tokenInfoFromContext(ctx)
+	if tokenInfo != nil && tokenInfo.AgentID == agentID {
+		return true
+	}
+
+	bound := s.registry.AgentToken(agentID)
+	if bound == "" {
+		return tokenInfo == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(bound), []byte(tokenFromContext(ctx))) == 1
+}
+
+// requestIDFromContext returns the x-request-id metadata sent by the agent
+func requestIDFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md.Get("x-request-id"); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// tokenInfoKey is the context key of the info of a call's validated token
+type tokenInfoKey struct{}
+
+// authorize requires a bearer token in the call metadata. With a token
+// manager, like the REST agent endpoints, the token must be valid and grant
+// the permissions of the method, and the returned context carries its info.
+func (s *Server) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	token := tokenFromContext(ctx)
+	if token == "" {
+		return ctx, status.Error(codes.Unauthenticated, "authorization token required")
+	}
+	if s.tokens == nil {
+		return ctx, nil
+	}
+
+	tokenInfo, err := s.tokens.ValidateTokenFrom(token, peerIP(ctx))
+	if err != nil {
+		return ctx, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if s.permissions != nil {
+		method := strings.TrimPrefix(fullMethod, "/"+ServiceName+"/")
+		for _, perm := range methodPermissions[method] {
+			if !s.permissions.TokenAllows(tokenInfo, perm.resource, perm.action) {
+				return ctx, status.Error(codes.PermissionDenied, "insufficient permissions")
+			}
+		}
+	}
+	return context.WithValue(ctx, tokenInfoKey{}, tokenInfo), nil
+}
+
+// tokenInfoFromContext returns the info of the call's token, or nil without
+// a token manager
+func tokenInfoFromContext(ctx context.Context) *security.TokenInfo {
+	tokenInfo, _ := ctx.Value(tokenInfoKey{}).(*security.TokenInfo)
+	return tokenInfo
+}
+
+// peerIP returns the IP address of the caller, or ""
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// tokenFromContext returns the bearer token in the call metadata, or ""
+func tokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get("authorization") {
+		if token := strings.TrimSpace(strings.TrimPrefix(value, "Bearer ")); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// authorizedStream is a server stream whose context carries the token info
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (a *authorizedStream) Context() context.Context {
+	return a.ctx
+}
+
+func (s *Server) unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) streamAuthInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.authorize(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authorizedStream{ServerStream: stream, ctx: ctx})
+}
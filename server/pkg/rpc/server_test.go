@@ -0,0 +1,167 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nerve/server/core"
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/security"
+	"github.com/nerve/server/pkg/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testServer is a gRPC server with token and permission checks, listening
+// on a loopback port
+type testServer struct {
+	t         *testing.T
+	conn      *grpc.ClientConn
+	scheduler *core.Scheduler
+	tokens    *security.TokenManager
+	agentPerm []string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	logger := log.NewWithWriter(false, io.Discard)
+	registry := core.NewRegistry(storage.NewInMemory(), logger)
+	scheduler := core.NewScheduler(registry, logger)
+	tokens := security.NewTokenManager(time.Hour, time.Hour)
+	permissions := security.NewPermissionManager()
+
+	server := NewServer(registry, scheduler, nil, logger, nil)
+	server.SetTokenManager(tokens)
+	server.SetPermissionManager(permissions)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(CodecName)))
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	agentPerm, err := permissions.RolePermissions("agent")
+	if err != nil {
+		t.Fatalf("RolePermissions: %v", err)
+	}
+	return &testServer{t: t, conn: conn, scheduler: scheduler, tokens: tokens, agentPerm: agentPerm}
+}
+
+// token creates a token with permissions
+func (s *testServer) token(name string, permissions []string) string {
+	s.t.Helper()
+
+	tokenInfo, err := s.tokens.CreateToken(name, "", permissions, 0)
+	if err != nil {
+		s.t.Fatalf("CreateToken: %v", err)
+	}
+	return tokenInfo.Token
+}
+
+// context returns a call context carrying token
+func (s *testServer) context(token string) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	s.t.Cleanup(cancel)
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// register registers a host with token, returning the agent's ID
+func (s *testServer) register(token, hostname string) (string, error) {
+	var resp RegisterResponse
+	err := s.conn.Invoke(s.context(token), "/"+ServiceName+"/Register", &core.RegisterRequest{Hostname: hostname, SN: "SN-" + hostname}, &resp)
+	return resp.ID, err
+}
+
+func TestAuthorize(t *testing.T) {
+	server := newTestServer(t)
+	viewer := server.token("viewer", []string{"agents:read"})
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"agent token", server.token("node-1", server.agentPerm), codes.OK},
+		{"unknown token", "not-a-token", codes.Unauthenticated},
+		{"token without agents:report", viewer, codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := server.register(tt.token, "node-1"); status.Code(err) != tt.want {
+				t.Errorf("Register = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Only the token an agent registered with subscribes to its tasks, reports
+// its results and sends its heartbeats
+func TestAgentTokenBinding(t *testing.T) {
+	server := newTestServer(t)
+	ownToken := server.token("node-1", server.agentPerm)
+	otherToken := server.token("node-2", server.agentPerm)
+
+	agentID, err := server.register(ownToken, "node-1")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := server.register(otherToken, "node-2"); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	server.scheduler.SubmitTask(&core.Task{ID: "task-1", AgentID: agentID, Type: "command", Status: "pending"})
+
+	t.Run("task stream", func(t *testing.T) {
+		stream, err := server.conn.NewStream(server.context(otherToken), &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, "/"+ServiceName+"/Tasks")
+		if err != nil {
+			t.Fatalf("NewStream: %v", err)
+		}
+		if err := stream.SendMsg(&TaskStreamRequest{AgentID: agentID}); err != nil {
+			t.Fatalf("SendMsg: %v", err)
+		}
+		var task core.Task
+		if err := stream.RecvMsg(&task); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("subscribing with another agent's token = %v, want PermissionDenied", err)
+		}
+	})
+
+	t.Run("heartbeat", func(t *testing.T) {
+		stream, err := server.conn.NewStream(server.context(otherToken), &grpc.StreamDesc{ClientStreams: true}, "/"+ServiceName+"/Heartbeat")
+		if err != nil {
+			t.Fatalf("NewStream: %v", err)
+		}
+		if err := stream.SendMsg(&core.Heartbeat{AgentID: agentID, Status: "online"}); err != nil {
+			t.Fatalf("SendMsg: %v", err)
+		}
+		stream.CloseSend()
+		var summary HeartbeatSummary
+		if err := stream.RecvMsg(&summary); status.Code(err) != codes.PermissionDenied {
+			t.Errorf("heartbeat with another agent's token = %v, want PermissionDenied", err)
+		}
+	})
+
+	t.Run("result", func(t *testing.T) {
+		result := &core.TaskResult{TaskID: "task-1", Success: true}
+		var resp ReportResultResponse
+		err := server.conn.Invoke(server.context(otherToken), "/"+ServiceName+"/ReportResult", result, &resp)
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("result with another agent's token = %v, want PermissionDenied", err)
+		}
+		if err := server.conn.Invoke(server.context(ownToken), "/"+ServiceName+"/ReportResult", result, &resp); err != nil {
+			t.Errorf("result with the agent's token = %v", err)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+// Package rpc provides the gRPC service definition for agent communication.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package rpc
+
+import (
+	"context"
+
+	"github.com/nerve/server/core"
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the fully qualified gRPC service name
+const ServiceName = "nerve.v1.AgentService"
+
+// RegisterResponse is returned by Register
+type RegisterResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// HeartbeatSummary is returned when an agent closes its heartbeat stream
+type HeartbeatSummary struct {
+	Received int `json:"received"`
+}
+
+// TaskStreamRequest is sent by agents on the task stream. The first message
+// subscribes the agent; later messages may carry task results.
+type TaskStreamRequest struct {
+	AgentID   string           `json:"agent_id"`
+	Result    *core.TaskResult `json:"result,omitempty"`
+	RequestID string           `json:"request_id,omitempty"`
+}
+
+// ReportResultResponse is returned by ReportResult
+type ReportResultResponse struct {
+	Status string `json:"status"`
+	TaskID string `json:"task_id"`
+}
+
+// AgentServiceServer is the server API for the agent service
+type AgentServiceServer interface {
+	// Register registers an agent, mirroring POST /api/agents/register
+	Register(context.Context, *core.RegisterRequest) (*RegisterResponse, error)
+	// Heartbeat receives a stream of heartbeats, mirroring POST /api/agents/{id}/heartbeat
+	Heartbeat(grpc.ServerStream) error
+	// Tasks pushes tasks to the agent as they are submitted, replacing GET /api/tasks polling
+	Tasks(grpc.ServerStream) error
+	// ReportResult records a task result, mirroring POST /api/tasks/{id}/result
+	ReportResult(context.Context, *core.TaskResult) (*ReportResultResponse, error)
+}
+
+// ServiceDesc describes the agent service for grpc.Server.RegisterService
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AgentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Register", Handler: registerHandler},
+		{MethodName: "ReportResult", Handler: reportResultHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Heartbeat", Handler: heartbeatHandler, ClientStreams: true},
+		{StreamName: "Tasks", Handler: tasksHandler, ServerStreams: true, ClientStreams: true},
+	},
+	Metadata: "nerve/agent_service",
+}
+
+func registerHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(core.RegisterRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).Register(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Register"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).Register(ctx, req.(*core.RegisterRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func reportResultHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(core.TaskResult)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AgentServiceServer).ReportResult(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/ReportResult"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AgentServiceServer).ReportResult(ctx, req.(*core.TaskResult))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func heartbeatHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).Heartbeat(stream)
+}
+
+func tasksHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AgentServiceServer).Tasks(stream)
+}
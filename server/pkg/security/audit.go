@@ -5,6 +5,7 @@
 package security
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -14,10 +15,42 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// maxAuditLineSize bounds the size of one audit log entry when querying
+const maxAuditLineSize = 1024 * 1024
+
+// AuditFilter selects audit events; zero fields match everything
+type AuditFilter struct {
+	UserID    string
+	EventType string
+	From      time.Time
+	To        time.Time
+	Limit     int
+}
+
+// matches reports whether an event passes the filter
+func (f *AuditFilter) matches(event *AuditEvent) bool {
+	if f.UserID != "" && event.UserID != f.UserID {
+		return false
+	}
+	if f.EventType != "" && event.EventType != f.EventType {
+		return false
+	}
+	if !f.From.IsZero() && event.Timestamp.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && event.Timestamp.After(f.To) {
+		return false
+	}
+	return true
+}
+
 // AuditLogger manages audit logging
 type AuditLogger struct {
 	logFile string
 	mutex   sync.Mutex
+
+	// redactor scrubs secrets from events before they are written
+	redactor *redactor
 }
 
 // AuditEvent represents an audit event
@@ -38,11 +71,12 @@ type AuditEvent struct {
 // NewAuditLogger creates a new audit logger
 func NewAuditLogger(logFile string) *AuditLogger {
 	return &AuditLogger{
-		logFile: logFile,
+		logFile:  logFile,
+		redactor: newRedactor(DefaultAuditRedaction()),
 	}
 }
 
-// LogEvent logs an audit event
+// LogEvent logs an audit event, redacting secrets first, see SetRedaction
 func (al *AuditLogger) LogEvent(event *AuditEvent) error {
 	al.mutex.Lock()
 	defer al.mutex.Unlock()
@@ -51,6 +85,7 @@ func (al *AuditLogger) LogEvent(event *AuditEvent) error {
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
+	al.redactor.redactEvent(event)
 
 	// Convert to JSON
 	eventJSON, err := json.Marshal(event)
@@ -121,6 +156,140 @@ func (al *AuditLogger) LogDataAccess(userID, agentID, action, resource, result s
 	return al.LogEvent(event)
 }
 
+// LogDownload logs an agent binary or install script download
+func (al *AuditLogger) LogDownload(c *gin.Context, tokenInfo *TokenInfo, resource, result string, details map[string]interface{}) error {
+	event := &AuditEvent{
+		EventType: "download",
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Action:    "download",
+		Resource:  resource,
+		Result:    result,
+		Details:   details,
+		RequestID: RequestIDFromContext(c),
+	}
+	if tokenInfo != nil {
+		event.UserID = tokenInfo.TokenIdentity()
+		event.AgentID = tokenInfo.AgentID
+	}
+
+	return al.LogEvent(event)
+}
+
+// LogDecommission logs a step of decommissioning an agent, attributed to the
+// operator whose token made the request
+func (al *AuditLogger) LogDecommission(c *gin.Context, agentID, result string, details map[string]interface{}) error {
+	operator := c.GetString("user_id")
+	if operator == "" {
+		operator = "anonymous"
+	}
+	event := &AuditEvent{
+		EventType: "decommission",
+		UserID:    operator,
+		AgentID:   agentID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Action:    "decommission",
+		Resource:  "agent/" + agentID,
+		Result:    result,
+		Details:   details,
+		RequestID: RequestIDFromContext(c),
+	}
+
+	return al.LogEvent(event)
+}
+
+// LogExec logs the start or end of an interactive exec session on an agent,
+// attributed to the operator whose token opened it
+func (al *AuditLogger) LogExec(c *gin.Context, agentID, result string, details map[string]interface{}) error {
+	operator := c.GetString("user_id")
+	if operator == "" {
+		operator = "anonymous"
+	}
+	event := &AuditEvent{
+		EventType: "exec",
+		UserID:    operator,
+		AgentID:   agentID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Action:    "exec",
+		Resource:  "agent/" + agentID,
+		Result:    result,
+		Details:   details,
+		RequestID: RequestIDFromContext(c),
+	}
+
+	return al.LogEvent(event)
+}
+
+// LogLogTail logs a live log tail an operator started on an agent, or was
+// refused, attributed to the operator whose token opened the event stream
+func (al *AuditLogger) LogLogTail(c *gin.Context, agentID, result string, details map[string]interface{}) error {
+	operator := c.GetString("user_id")
+	if operator == "" {
+		operator = "anonymous"
+	}
+	event := &AuditEvent{
+		EventType: "log_tail",
+		UserID:    operator,
+		AgentID:   agentID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Action:    "log_tail",
+		Resource:  "agent/" + agentID,
+		Result:    result,
+		Details:   details,
+		RequestID: RequestIDFromContext(c),
+	}
+
+	return al.LogEvent(event)
+}
+
+// LogRefresh logs a request for an agent to refresh its inventory,
+// attributed to the operator whose token made it
+func (al *AuditLogger) LogRefresh(c *gin.Context, agentID, result string) error {
+	operator := c.GetString("user_id")
+	if operator == "" {
+		operator = "anonymous"
+	}
+	event := &AuditEvent{
+		EventType: "agent_refresh",
+		UserID:    operator,
+		AgentID:   agentID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Action:    "refresh",
+		Resource:  "agent/" + agentID,
+		Result:    result,
+		RequestID: RequestIDFromContext(c),
+	}
+
+	return al.LogEvent(event)
+}
+
+// LogLogout logs a logout revoking the caller's token, or with everywhere
+// all the tokens of its owner
+func (al *AuditLogger) LogLogout(c *gin.Context, tokenInfo *TokenInfo, everywhere bool, revoked int, result string) error {
+	event := &AuditEvent{
+		EventType: "authentication",
+		UserID:    tokenInfo.TokenIdentity(),
+		AgentID:   tokenInfo.AgentID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+		Action:    "logout",
+		Resource:  "token/" + tokenInfo.ID,
+		Result:    result,
+		RequestID: RequestIDFromContext(c),
+		Details: map[string]interface{}{
+			"everywhere": everywhere,
+			"owner_id":   tokenInfo.Owner(),
+			"revoked":    revoked,
+		},
+	}
+
+	return al.LogEvent(event)
+}
+
 // LogSystemEvent logs system events
 func (al *AuditLogger) LogSystemEvent(eventType, action, resource, result string, details map[string]interface{}) error {
 	event := &AuditEvent{
@@ -182,12 +351,17 @@ func AuditMiddleware(auditLogger *AuditLogger) func(c *gin.Context) {
 			Action:    c.Request.Method,
 			Resource:  c.Request.URL.Path,
 			Result:    fmt.Sprintf("%d", status),
+			RequestID: RequestIDFromContext(c),
 			Details: map[string]interface{}{
 				"duration_ms": duration.Milliseconds(),
 				"request_size": c.Request.ContentLength,
 				"response_size": c.Writer.Size(),
 			},
 		}
+		// Logged as sent; LogEvent redacts tokens such as install.sh?token=
+		if query := c.Request.URL.RawQuery; query != "" {
+			event.Details["query"] = query
+		}
 
 		// Add user info if available
 		if userID, exists := c.Get("user_id"); exists {
@@ -223,9 +397,49 @@ func (al *AuditLogger) GetAuditLogs(limit int) ([]*AuditEvent, error) {
 		if err := decoder.Decode(&event); err != nil {
 			continue // Skip malformed entries
 		}
+		al.redactor.redactEvent(&event)
 		events = append(events, &event)
 	}
 
 	return events, nil
 }
 
+// QueryAuditLogs returns the most recent events matching the filter, oldest first
+func (al *AuditLogger) QueryAuditLogs(filter AuditFilter) ([]*AuditEvent, error) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	file, err := os.Open(al.logFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*AuditEvent{}, nil
+		}
+		return nil, fmt.Errorf("failed to open audit log file: %v", err)
+	}
+	defer file.Close()
+
+	events := []*AuditEvent{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxAuditLineSize)
+	for scanner.Scan() {
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue // Skip malformed entries
+		}
+		if !filter.matches(&event) {
+			continue
+		}
+		// Events written before a redaction was configured are redacted on read
+		al.redactor.redactEvent(&event)
+		events = append(events, &event)
+		// Keep only the newest Limit matches
+		if filter.Limit > 0 && len(events) > filter.Limit {
+			events = events[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log file: %v", err)
+	}
+
+	return events, nil
+}
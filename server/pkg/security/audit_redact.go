@@ -0,0 +1,220 @@
+// Package security provides redaction of secrets from audit events: header
+// values, JSON fields and query parameters are replaced with *** before an
+// event is written.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue replaces redacted values in audit events
+const RedactedValue = "***"
+
+// Secrets redacted from every audit event, in addition to those configured
+var (
+	DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-API-Key", "X-Auth-Token"}
+	DefaultRedactedFields  = []string{"password", "token", "secret", "api_key", "access_token", "refresh_token", "private_key", "signing_key"}
+	DefaultRedactedQuery   = []string{"token", "access_token", "api_key", "password", "secret"}
+)
+
+// AuditRedaction lists what is redacted from audit events. Headers are
+// matched by name in the event's "headers" detail. Fields are JSON field
+// names matched at any depth of the details, or dotted paths from the
+// details' root such as "body.config.password". Query parameters are
+// matched in every string of the event, e.g. the token of
+// /api/install.sh?token=... All names are case-insensitive.
+type AuditRedaction struct {
+	Headers     []string
+	Fields      []string
+	QueryParams []string
+}
+
+// DefaultAuditRedaction returns the redaction applied unless configured otherwise
+func DefaultAuditRedaction() AuditRedaction {
+	return AuditRedaction{
+		Headers:     append([]string{}, DefaultRedactedHeaders...),
+		Fields:      append([]string{}, DefaultRedactedFields...),
+		QueryParams: append([]string{}, DefaultRedactedQuery...),
+	}
+}
+
+// redactor applies an AuditRedaction
+type redactor struct {
+	headers map[string]bool
+	names   map[string]bool
+	paths   map[string]bool
+	query   *regexp.Regexp
+}
+
+// newRedactor compiles a redaction
+func newRedactor(redaction AuditRedaction) *redactor {
+	r := &redactor{
+		headers: make(map[string]bool),
+		names:   make(map[string]bool),
+		paths:   make(map[string]bool),
+	}
+	for _, header := range redaction.Headers {
+		if header = strings.TrimSpace(header); header != "" {
+			r.headers[http.CanonicalHeaderKey(header)] = true
+		}
+	}
+	for _, field := range redaction.Fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		switch {
+		case field == "":
+		case strings.Contains(field, "."):
+			r.paths[field] = true
+		default:
+			r.names[field] = true
+		}
+	}
+
+	var params []string
+	for _, param := range redaction.QueryParams {
+		if param = strings.TrimSpace(param); param != "" {
+			params = append(params, regexp.QuoteMeta(param))
+		}
+	}
+	if len(params) > 0 {
+		r.query = regexp.MustCompile(`(?i)((?:^|[?&;])(?:` + strings.Join(params, "|") + `)=)[^&;#\s"]*`)
+	}
+	return r
+}
+
+// SetRedaction sets what is redacted from the events logged from now on
+func (al *AuditLogger) SetRedaction(redaction AuditRedaction) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	al.redactor = newRedactor(redaction)
+}
+
+// redactEvent redacts an event's resource, user agent and details. The
+// details are copied, so maps the caller passed in are left untouched.
+func (r *redactor) redactEvent(event *AuditEvent) {
+	event.Resource = r.redactString(event.Resource)
+	event.UserAgent = r.redactString(event.UserAgent)
+	if event.Details != nil {
+		event.Details = r.redactMap(event.Details, "").(map[string]interface{})
+	}
+}
+
+// redactMap returns a copy of a map with sensitive fields redacted; path is
+// the dotted path of the map from the details' root
+func (r *redactor) redactMap(m map[string]interface{}, path string) interface{} {
+	redacted := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		fieldPath := strings.ToLower(key)
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		switch {
+		case r.names[strings.ToLower(key)] || r.paths[fieldPath]:
+			redacted[key] = RedactedValue
+		case path == "" && key == "headers":
+			redacted[key] = r.redactHeaders(value)
+		default:
+			redacted[key] = r.redactValue(value, fieldPath)
+		}
+	}
+	return redacted
+}
+
+// redactValue returns a value with its sensitive parts redacted
+func (r *redactor) redactValue(value interface{}, path string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return r.redactMap(v, path)
+	case map[string]string:
+		m := make(map[string]interface{}, len(v))
+		for key, s := range v {
+			m[key] = s
+		}
+		return r.redactMap(m, path)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = r.redactValue(item, path)
+		}
+		return list
+	case []string:
+		list := make([]string, len(v))
+		for i, item := range v {
+			list[i] = r.redactString(item)
+		}
+		return list
+	case json.RawMessage:
+		return r.redactJSON(v, path)
+	case string:
+		// JSON bodies logged as strings are redacted field by field
+		if trimmed := strings.TrimSpace(v); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			if redacted, ok := r.redactJSON(json.RawMessage(trimmed), path).(json.RawMessage); ok {
+				return string(redacted)
+			}
+		}
+		return r.redactString(v)
+	default:
+		return value
+	}
+}
+
+// redactJSON redacts an encoded JSON value, falling back to treating it as
+// a string if it doesn't decode
+func (r *redactor) redactJSON(raw json.RawMessage, path string) interface{} {
+	var decoded interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		return r.redactString(string(raw))
+	}
+	encoded, err := json.Marshal(r.redactValue(decoded, path))
+	if err != nil {
+		return RedactedValue
+	}
+	return json.RawMessage(encoded)
+}
+
+// redactHeaders redacts the values of sensitive headers in a "headers" detail
+func (r *redactor) redactHeaders(value interface{}) interface{} {
+	headers := make(map[string]interface{})
+	switch v := value.(type) {
+	case http.Header:
+		for name, values := range v {
+			headers[name] = values
+		}
+	case map[string][]string:
+		for name, values := range v {
+			headers[name] = values
+		}
+	case map[string]string:
+		for name, s := range v {
+			headers[name] = s
+		}
+	case map[string]interface{}:
+		headers = v
+	default:
+		return r.redactValue(value, "headers")
+	}
+
+	redacted := make(map[string]interface{}, len(headers))
+	for name, values := range headers {
+		if r.headers[http.CanonicalHeaderKey(name)] {
+			redacted[name] = RedactedValue
+		} else {
+			redacted[name] = r.redactValue(values, "headers."+strings.ToLower(name))
+		}
+	}
+	return redacted
+}
+
+// redactString redacts the values of sensitive query parameters in a string
+func (r *redactor) redactString(s string) string {
+	if r.query == nil || !strings.Contains(s, "=") {
+		return s
+	}
+	return r.query.ReplaceAllString(s, "${1}"+RedactedValue)
+}
@@ -0,0 +1,186 @@
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	r := newRedactor(AuditRedaction{Headers: append(DefaultRedactedHeaders, "x-vault-token")})
+	tests := []struct {
+		name    string
+		headers interface{}
+	}{
+		{"http.Header", http.Header{
+			"Authorization": {"Bearer s3cr3t"},
+			"X-Vault-Token": {"s3cr3t"},
+			"Content-Type":  {"application/json"},
+		}},
+		{"map of strings, any case", map[string]string{
+			"authorization": "Bearer s3cr3t",
+			"x-vault-token": "s3cr3t",
+			"content-type":  "application/json",
+		}},
+		{"decoded JSON", map[string]interface{}{
+			"Cookie":       "session=s3cr3t",
+			"X-Api-Key":    "s3cr3t",
+			"Content-Type": "application/json",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &AuditEvent{Details: map[string]interface{}{"headers": tt.headers}}
+			r.redactEvent(event)
+
+			headers := event.Details["headers"].(map[string]interface{})
+			for name, value := range headers {
+				sensitive := !strings.EqualFold(name, "Content-Type")
+				if sensitive && value != RedactedValue {
+					t.Errorf("%s = %v, want it redacted", name, value)
+				}
+				if !sensitive && strings.Contains(mustJSON(t, value), RedactedValue) {
+					t.Errorf("%s = %v, want it kept", name, value)
+				}
+			}
+			if strings.Contains(mustJSON(t, event), "s3cr3t") {
+				t.Errorf("secret left in %s", mustJSON(t, event))
+			}
+		})
+	}
+}
+
+func TestRedactBodyFields(t *testing.T) {
+	r := newRedactor(AuditRedaction{Fields: append(DefaultRedactedFields, "body.config.dsn")})
+	tests := []struct {
+		name string
+		body interface{}
+	}{
+		{"decoded", map[string]interface{}{
+			"name":     "dashboard",
+			"Password": "s3cr3t",
+			"config":   map[string]interface{}{"dsn": "s3cr3t", "region": "eu"},
+			"targets":  []interface{}{map[string]interface{}{"api_key": "s3cr3t", "url": "https://example.com"}},
+		}},
+		{"JSON string", `{"name":"dashboard","password":"s3cr3t","config":{"dsn":"s3cr3t","region":"eu"},"targets":[{"api_key":"s3cr3t","url":"https://example.com"}]}`},
+		{"raw JSON", json.RawMessage(`{"name":"dashboard","token":"s3cr3t","config":{"dsn":"s3cr3t","region":"eu"}}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &AuditEvent{Details: map[string]interface{}{"body": tt.body}}
+			r.redactEvent(event)
+
+			logged := mustJSON(t, event)
+			if strings.Contains(logged, "s3cr3t") {
+				t.Errorf("secret left in %s", logged)
+			}
+			for _, kept := range []string{"dashboard", "eu"} {
+				if !strings.Contains(logged, kept) {
+					t.Errorf("%q redacted from %s", kept, logged)
+				}
+			}
+		})
+	}
+}
+
+// A dotted path redacts only the field at that path
+func TestRedactFieldPath(t *testing.T) {
+	r := newRedactor(AuditRedaction{Fields: []string{"body.config.dsn"}})
+	event := &AuditEvent{Details: map[string]interface{}{
+		"body": map[string]interface{}{"config": map[string]interface{}{"dsn": "s3cr3t"}, "dsn": "kept"},
+	}}
+	r.redactEvent(event)
+
+	body := event.Details["body"].(map[string]interface{})
+	if got := body["config"].(map[string]interface{})["dsn"]; got != RedactedValue {
+		t.Errorf("body.config.dsn = %v, want it redacted", got)
+	}
+	if body["dsn"] != "kept" {
+		t.Errorf("body.dsn = %v, want it kept", body["dsn"])
+	}
+}
+
+func TestRedactQueryTokens(t *testing.T) {
+	r := newRedactor(DefaultAuditRedaction())
+	tests := []struct {
+		in, want string
+	}{
+		{"/api/install.sh?token=s3cr3t", "/api/install.sh?token=***"},
+		{"/api/download?os=linux&TOKEN=s3cr3t&arch=amd64", "/api/download?os=linux&TOKEN=***&arch=amd64"},
+		{"/ws/events?access_token=s3cr3t#tail", "/ws/events?access_token=***#tail"},
+		{"curl https://nerve/api/install.sh?api_key=s3cr3t | sh", "curl https://nerve/api/install.sh?api_key=*** | sh"},
+		{"/api/agents?mytoken=kept", "/api/agents?mytoken=kept"},
+		{"/api/agents", "/api/agents"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			event := &AuditEvent{
+				Resource:  tt.in,
+				UserAgent: tt.in,
+				Details:   map[string]interface{}{"url": tt.in, "urls": []string{tt.in}},
+			}
+			r.redactEvent(event)
+
+			if event.Resource != tt.want || event.UserAgent != tt.want || event.Details["url"] != tt.want {
+				t.Errorf("redacted to %q, %q and %q, want %q", event.Resource, event.UserAgent, event.Details["url"], tt.want)
+			}
+			if urls := event.Details["urls"].([]string); urls[0] != tt.want {
+				t.Errorf("list item redacted to %q, want %q", urls[0], tt.want)
+			}
+		})
+	}
+}
+
+// The details the caller passed in are left as they were
+func TestRedactCopiesDetails(t *testing.T) {
+	body := map[string]interface{}{"password": "s3cr3t"}
+	event := &AuditEvent{Details: map[string]interface{}{"body": body}}
+	newRedactor(DefaultAuditRedaction()).redactEvent(event)
+
+	if body["password"] != "s3cr3t" {
+		t.Errorf("caller's map changed to %v", body)
+	}
+}
+
+// Secrets never reach the audit log file
+func TestLogEventRedacts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "audit.log")
+	logger := NewAuditLogger(file)
+	logger.SetRedaction(AuditRedaction{
+		Headers:     append(DefaultRedactedHeaders, "X-Vault-Token"),
+		Fields:      DefaultRedactedFields,
+		QueryParams: append(DefaultRedactedQuery, "sig"),
+	})
+
+	err := logger.LogEvent(&AuditEvent{
+		EventType: "api_request",
+		Resource:  "/api/download?sig=s3cr3t",
+		Details: map[string]interface{}{
+			"headers": http.Header{"X-Vault-Token": {"s3cr3t"}},
+			"body":    `{"secret":"s3cr3t"}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("LogEvent: %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("secret written to the audit log: %s", data)
+	}
+}
+
+func mustJSON(t *testing.T, value interface{}) string {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,71 @@
+// Package security provides pruning of old events from the audit log.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PruneBefore removes the events logged before cutoff from the audit log and
+// returns how many were removed. The log is rewritten through a temporary
+// file, so a failure leaves it as it was; entries that can't be parsed are
+// kept.
+func (al *AuditLogger) PruneBefore(cutoff time.Time) (int, error) {
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	in, err := os.Open(al.logFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to open audit log file: %v", err)
+	}
+	defer in.Close()
+
+	tmp := al.logFile + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create audit log file: %v", err)
+	}
+	writer := bufio.NewWriter(out)
+
+	removed := 0
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), maxAuditLineSize)
+	for scanner.Scan() {
+		var event struct {
+			Timestamp time.Time `json:"timestamp"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err == nil && event.Timestamp.Before(cutoff) {
+			removed++
+			continue
+		}
+		writer.Write(scanner.Bytes())
+		writer.WriteByte('\n')
+	}
+
+	err = scanner.Err()
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && removed > 0 {
+		err = os.Rename(tmp, al.logFile)
+	}
+	if err != nil || removed == 0 {
+		os.Remove(tmp)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune audit log file: %v", err)
+	}
+	return removed, nil
+}
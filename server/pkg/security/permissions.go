@@ -6,11 +6,11 @@ package security
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
 )
 
 // Permission represents a permission
@@ -77,7 +77,9 @@ func (pm *PermissionManager) initializeDefaultRoles() {
 		Name:        "Agent",
 		Description: "Agent operations",
 		Permissions: []Permission{
-			{Resource: "agents", Actions: []string{"read", "update"}},
+			// report registers and sends heartbeats; update would let an
+			// agent's token manage every other agent
+			{Resource: "agents", Actions: []string{"read", "report"}},
 			{Resource: "tasks", Actions: []string{"read", "execute"}},
 			{Resource: "system_info", Actions: []string{"read", "update"}},
 		},
@@ -92,8 +94,11 @@ func (pm *PermissionManager) initializeDefaultRoles() {
 		Permissions: []Permission{
 			{Resource: "agents", Actions: []string{"read", "create", "update", "delete"}},
 			{Resource: "tasks", Actions: []string{"read", "create", "update", "delete"}},
+			{Resource: "schedules", Actions: []string{"read", "create", "update", "delete"}},
 			{Resource: "clusters", Actions: []string{"read", "create", "update", "delete"}},
 			{Resource: "alerts", Actions: []string{"read", "create", "update", "delete"}},
+			{Resource: "plugins", Actions: []string{"read"}},
+			{Resource: "system", Actions: []string{"read"}},
 		},
 	}
 	pm.roles["operator"] = operatorRole
@@ -106,8 +111,11 @@ func (pm *PermissionManager) initializeDefaultRoles() {
 		Permissions: []Permission{
 			{Resource: "agents", Actions: []string{"read"}},
 			{Resource: "tasks", Actions: []string{"read"}},
+			{Resource: "schedules", Actions: []string{"read"}},
 			{Resource: "clusters", Actions: []string{"read"}},
 			{Resource: "alerts", Actions: []string{"read"}},
+			{Resource: "plugins", Actions: []string{"read"}},
+			{Resource: "system", Actions: []string{"read"}},
 		},
 	}
 	pm.roles["viewer"] = viewerRole
@@ -247,21 +255,7 @@ func (pm *PermissionManager) CheckPermission(userID, resource, action string) bo
 		return false
 	}
 
-	// Check wildcard permissions first
-	if wildcardPerms, exists := pm.permissions["*"]; exists {
-		if wildcardPerms["*"] || wildcardPerms[action] {
-			return true
-		}
-	}
-
-	// Check resource-specific permissions
-	if resourcePerms, exists := pm.permissions[resource]; exists {
-		if resourcePerms["*"] || resourcePerms[action] {
-			return true
-		}
-	}
-
-	// Check user roles
+	// Only the user's own roles count; pm.permissions spans every role
 	for _, roleID := range user.Roles {
 		role, exists := pm.roles[roleID]
 		if !exists {
@@ -331,6 +325,31 @@ func (pm *PermissionManager) GetUserPermissions(userID string) ([]Permission, er
 	return permissions, nil
 }
 
+// RolePermissions returns the permissions of a role as token permission
+// strings, e.g. "agents:read", so a token can be issued for the role
+func (pm *PermissionManager) RolePermissions(roleID string) ([]string, error) {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	role, exists := pm.roles[roleID]
+	if !exists {
+		return nil, fmt.Errorf("role %s not found", roleID)
+	}
+
+	var permissions []string
+	for _, perm := range role.Permissions {
+		for _, action := range perm.Actions {
+			if perm.Resource == "*" && action == "*" {
+				permissions = append(permissions, "*")
+			} else {
+				permissions = append(permissions, perm.Resource+":"+action)
+			}
+		}
+	}
+
+	return permissions, nil
+}
+
 // UpdateUserRoles updates user roles
 func (pm *PermissionManager) UpdateUserRoles(userID string, roles []string) error {
 	pm.mutex.Lock()
@@ -356,16 +375,14 @@ func (pm *PermissionManager) UpdateUserRoles(userID string, roles []string) erro
 func PermissionMiddleware(permManager *PermissionManager) func(resource, action string) func(c *gin.Context) {
 	return func(resource, action string) func(c *gin.Context) {
 		return func(c *gin.Context) {
-			userID, exists := c.Get("user_id")
-			if !exists {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
-				c.Abort()
+			tokenInfo := RequestTokenInfo(c)
+			if tokenInfo == nil {
+				apierror.Abort(c, apierror.Unauthorized, "user not authenticated")
 				return
 			}
 
-			if !permManager.CheckPermission(userID.(string), resource, action) {
-				c.JSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
-				c.Abort()
+			if !permManager.TokenAllows(tokenInfo, resource, action) {
+				apierror.Abort(c, apierror.Forbidden, "insufficient permissions")
 				return
 			}
 
@@ -374,3 +391,25 @@ func PermissionMiddleware(permManager *PermissionManager) func(resource, action
 	}
 }
 
+
+// TokenAllows reports whether a token grants the action on the resource,
+// through its own permissions or the roles of the user owning it. A token's
+// name never grants a user's roles.
+func (pm *PermissionManager) TokenAllows(tokenInfo *TokenInfo, resource, action string) bool {
+	if permissionsGrant(tokenInfo.Permissions, resource, action) {
+		return true
+	}
+	userID := tokenInfo.OwnerUserID()
+	return userID != "" && pm.CheckPermission(userID, resource, action)
+}
+
+// permissionsGrant reports whether permission strings grant the action on
+// the resource, such as "audit:read", "audit:*", "*:read" or "*"
+func permissionsGrant(permissions []string, resource, action string) bool {
+	for _, perm := range permissions {
+		if perm == "*" || perm == resource+":"+action || perm == resource+":*" || perm == "*:"+action {
+			return true
+		}
+	}
+	return false
+}
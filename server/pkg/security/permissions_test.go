@@ -0,0 +1,70 @@
+package security
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles come from the user owning a token, never from the token's name
+func TestTokenAllowsOwnerRoles(t *testing.T) {
+	pm := NewPermissionManager()
+	if err := pm.AddUser(&User{ID: "alice", Username: "alice", Roles: []string{"admin"}, IsActive: true}); err != nil {
+		t.Fatalf("AddUser: %v", err)
+	}
+	tm := NewTokenManager(time.Hour, time.Hour)
+
+	named, err := tm.CreateToken("alice", "", []string{"agents:read"}, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	owned, err := tm.CreateUserToken("alice", "laptop", nil, 0)
+	if err != nil {
+		t.Fatalf("CreateUserToken: %v", err)
+	}
+	agent, err := tm.CreateToken("", "alice", nil, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		token            *TokenInfo
+		resource, action string
+		want             bool
+	}{
+		{"named after a user, own permission", named, "agents", "read", true},
+		{"named after a user", named, "tokens", "create", false},
+		{"issued for an agent named after a user", agent, "tokens", "create", false},
+		{"owned by a user", owned, "tokens", "create", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pm.TokenAllows(tt.token, tt.resource, tt.action); got != tt.want {
+				t.Errorf("TokenAllows(%s:%s) = %v, want %v", tt.resource, tt.action, got, tt.want)
+			}
+		})
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/tokens", TokenAuthMiddleware(tm), PermissionMiddleware(pm)("tokens", "create"), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for token, want := range map[string]int{
+		named.Token: http.StatusForbidden,
+		owned.Token: http.StatusOK,
+		"":          http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/tokens", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != want {
+			t.Errorf("request as %q = %d, want %d", token, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Package security provides per-key rate limiting for agent endpoints.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAgentRate is the sustained register/heartbeat rate allowed per
+	// agent, well above the one-second minimum heartbeat interval
+	DefaultAgentRate = 1.0
+
+	// DefaultAgentBurst is the number of requests an agent may send at once
+	DefaultAgentBurst = 10
+
+	// rateLimiterSweepInterval is how often idle buckets are dropped
+	rateLimiterSweepInterval = 5 * time.Minute
+)
+
+// RateLimiter is a token bucket per key, e.g. per agent ID or hostname
+type RateLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+	mutex     sync.Mutex
+}
+
+// rateBucket holds the tokens left for one key
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate requests per second per key
+// with bursts of up to burst requests. A rate of zero or less disables it.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:      rate,
+		burst:     float64(burst),
+		buckets:   make(map[string]*rateBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// SetLimit changes the rate and burst while the limiter is in use; buckets
+// keep their tokens, capped to the new burst
+func (rl *RateLimiter) SetLimit(rate float64, burst int) {
+	if burst < 1 {
+		burst = 1
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	rl.rate = rate
+	rl.burst = float64(burst)
+}
+
+// Limit returns the rate and burst of the limiter
+func (rl *RateLimiter) Limit() (float64, int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	return rl.rate, int(rl.burst)
+}
+
+// Allow reports whether a request for key is within the limit and consumes a token if so
+func (rl *RateLimiter) Allow(key string) bool {
+	if rl == nil {
+		return true
+	}
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	if rl.rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(rl.lastSweep) > rateLimiterSweepInterval {
+		rl.sweepLocked(now)
+	}
+
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		bucket = &rateBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bucket
+	}
+
+	bucket.tokens += now.Sub(bucket.last).Seconds() * rl.rate
+	if bucket.tokens > rl.burst {
+		bucket.tokens = rl.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// sweepLocked drops buckets that have refilled completely; caller must hold rl.mutex
+func (rl *RateLimiter) sweepLocked(now time.Time) {
+	full := time.Duration(rl.burst / rl.rate * float64(time.Second))
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) > full {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
@@ -0,0 +1,61 @@
+// Package security provides request-ID correlation for logs and audit events.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// RequestIDHeader is the header used to propagate request IDs
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the gin context key holding the request ID
+	RequestIDKey = "request_id"
+
+	maxRequestIDLength = 128
+)
+
+// RequestIDMiddleware assigns a request ID to every request, reusing a
+// well-formed X-Request-ID from the caller, and echoes it in the response.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Next()
+	}
+}
+
+// RequestIDFromContext returns the request ID assigned by RequestIDMiddleware
+func RequestIDFromContext(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
+
+// validRequestID accepts short printable IDs so they are safe to log
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, ch := range id {
+		if ch < '!' || ch > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	idBytes := make([]byte, 16)
+	rand.Read(idBytes)
+	return hex.EncodeToString(idBytes)
+}
@@ -7,9 +7,16 @@ package security
 import (
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nerve/server/pkg/apierror"
+	"github.com/nerve/server/pkg/sharedstate"
+	"github.com/nerve/server/pkg/storage"
 )
 
 // TokenManager manages token generation and rotation
@@ -18,17 +25,46 @@ type TokenManager struct {
 	mutex       sync.RWMutex
 	rotationInterval time.Duration
 	expirationTime   time.Duration
+
+	// Callbacks for tokens used from a new IP, see OnNewIP
+	newIPHandlers []NewIPHandler
+
+	// Automatic rotation of agent tokens, see RotateExpiringTokens
+	rotationWindow  time.Duration
+	pushToken       TokenPusher
+	rotationChanged chan struct{}
+
+	// Tokens shared with other servers, see EnableSharedState
+	store storage.Storage
+	bus   *sharedstate.Bus
 }
 
 // TokenInfo represents token information
 type TokenInfo struct {
-	Token       string    `json:"token"`
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	Token       string    `json:"-"` // never serialized; see TokenSummary
 	CreatedAt   time.Time `json:"created_at"`
 	ExpiresAt   time.Time `json:"expires_at"`
 	LastUsed    time.Time `json:"last_used"`
 	AgentID     string    `json:"agent_id,omitempty"`
 	Permissions []string  `json:"permissions"`
 	IsActive    bool      `json:"is_active"`
+
+	// OwnerID is who the token belongs to: "user/<id>" for a user's token,
+	// "agent/<id>" for a token issued for an agent, else "token/<id>", the
+	// token itself. Logging out everywhere revokes the tokens of an owner.
+	OwnerID string `json:"owner_id,omitempty"`
+
+	// LastIP is the client IP of the last use; RecentIPs holds up to
+	// MaxRecentTokenIPs distinct IPs the token was used from
+	LastIP    string    `json:"last_ip,omitempty"`
+	RecentIPs []TokenIP `json:"recent_ips,omitempty"`
+
+	// SuccessorID links a token being rotated to the token replacing it,
+	// and PredecessorID back, until the agent acknowledges the rotation
+	SuccessorID   string `json:"successor_id,omitempty"`
+	PredecessorID string `json:"predecessor_id,omitempty"`
 }
 
 // NewTokenManager creates a new token manager
@@ -37,6 +73,7 @@ func NewTokenManager(rotationInterval, expirationTime time.Duration) *TokenManag
 		tokens:           make(map[string]*TokenInfo),
 		rotationInterval: rotationInterval,
 		expirationTime:   expirationTime,
+		rotationChanged:  make(chan struct{}, 1),
 	}
 
 	// Start token rotation routine
@@ -47,72 +84,166 @@ func NewTokenManager(rotationInterval, expirationTime time.Duration) *TokenManag
 
 // GenerateToken generates a new token
 func (tm *TokenManager) GenerateToken(agentID string, permissions []string) (string, error) {
+	tokenInfo, err := tm.CreateToken("", agentID, permissions, tm.expirationTime)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenInfo.Token, nil
+}
+
+// CreateToken generates a named token with a custom lifetime
+func (tm *TokenManager) CreateToken(name, agentID string, permissions []string, ttl time.Duration) (*TokenInfo, error) {
+	return tm.issueToken(name, agentID, "", permissions, ttl)
+}
+
+// CreateUserToken generates a named token belonging to a user
+func (tm *TokenManager) CreateUserToken(userID, name string, permissions []string, ttl time.Duration) (*TokenInfo, error) {
+	if userID == "" {
+		return nil, fmt.Errorf("user ID is required")
+	}
+	return tm.issueToken(name, "", "user/"+userID, permissions, ttl)
+}
+
+// issueToken generates a token and shares it with the other servers
+func (tm *TokenManager) issueToken(name, agentID, ownerID string, permissions []string, ttl time.Duration) (*TokenInfo, error) {
+	tokenInfo, err := tm.createToken(name, agentID, ownerID, permissions, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	tm.mutex.Lock()
+	tm.saveSharedLocked(tokenInfo)
+	tm.mutex.Unlock()
+
+	return tokenInfo, nil
+}
+
+// createToken generates a token known only to this server. Without an
+// owner, a token issued for an agent belongs to the agent and any other to
+// itself.
+func (tm *TokenManager) createToken(name, agentID, ownerID string, permissions []string, ttl time.Duration) (*TokenInfo, error) {
 	tokenBytes := make([]byte, 32)
 	if _, err := rand.Read(tokenBytes); err != nil {
-		return "", fmt.Errorf("failed to generate random token: %v", err)
+		return nil, fmt.Errorf("failed to generate random token: %v", err)
+	}
+
+	if ttl <= 0 {
+		ttl = tm.expirationTime
 	}
 
 	token := base64.URLEncoding.EncodeToString(tokenBytes)
 	now := time.Now()
 
 	tokenInfo := &TokenInfo{
+		ID:          newTokenID(),
+		Name:        name,
 		Token:       token,
 		CreatedAt:   now,
-		ExpiresAt:   now.Add(tm.expirationTime),
+		ExpiresAt:   now.Add(ttl),
 		LastUsed:    now,
 		AgentID:     agentID,
 		Permissions: permissions,
 		IsActive:    true,
+		OwnerID:     ownerID,
+	}
+	switch {
+	case ownerID != "":
+	case agentID != "":
+		tokenInfo.OwnerID = "agent/" + agentID
+	default:
+		tokenInfo.OwnerID = "token/" + tokenInfo.ID
 	}
 
 	tm.mutex.Lock()
 	tm.tokens[token] = tokenInfo
 	tm.mutex.Unlock()
 
-	return token, nil
+	return tokenInfo, nil
 }
 
 // ValidateToken validates a token and updates last used time
 func (tm *TokenManager) ValidateToken(token string) (*TokenInfo, error) {
+	return tm.ValidateTokenFrom(token, "")
+}
+
+// ValidateTokenFrom validates a token used from a client IP and records the
+// use, calling the OnNewIP handlers if the IP is new to the token
+func (tm *TokenManager) ValidateTokenFrom(token, ip string) (*TokenInfo, error) {
 	tm.mutex.RLock()
 	tokenInfo, exists := tm.tokens[token]
 	tm.mutex.RUnlock()
 
+	if !exists {
+		tokenInfo, exists = tm.loadShared(token)
+	}
 	if !exists {
 		return nil, fmt.Errorf("token not found")
 	}
 
+	// Revoking writes IsActive under the lock, so check it there
+	now := time.Now()
+	tm.mutex.Lock()
 	if !tokenInfo.IsActive {
+		tm.mutex.Unlock()
 		return nil, fmt.Errorf("token is inactive")
 	}
-
-	if time.Now().After(tokenInfo.ExpiresAt) {
+	if now.After(tokenInfo.ExpiresAt) {
+		tm.mutex.Unlock()
 		return nil, fmt.Errorf("token has expired")
 	}
 
-	// Update last used time
-	tm.mutex.Lock()
-	tokenInfo.LastUsed = time.Now()
+	// Update last used time and client IP
+	knownIPs := tokenInfo.recordUseLocked(ip, now)
+	handlers := tm.newIPHandlers
 	tm.mutex.Unlock()
 
+	if knownIPs != nil {
+		for _, handler := range handlers {
+			handler(tokenInfo, ip, knownIPs)
+		}
+	}
+
 	return tokenInfo, nil
 }
 
 // RevokeToken revokes a token
 func (tm *TokenManager) RevokeToken(token string) error {
+	tm.loadShared(token)
+
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
 	if tokenInfo, exists := tm.tokens[token]; exists {
 		tokenInfo.IsActive = false
+		tm.saveSharedLocked(tokenInfo)
 		return nil
 	}
 
 	return fmt.Errorf("token not found")
 }
 
+// RevokeTokenByID revokes a token by its ID
+func (tm *TokenManager) RevokeTokenByID(id string) error {
+	tm.mutex.Lock()
+	for _, tokenInfo := range tm.tokens {
+		if tokenInfo.ID == id {
+			tokenInfo.IsActive = false
+			tm.saveSharedLocked(tokenInfo)
+			tm.mutex.Unlock()
+			return nil
+		}
+	}
+	tm.mutex.Unlock()
+
+	// Created on, or not yet used through, another server
+	return tm.revokeSharedByID(id)
+}
+
 // RotateToken generates a new token for an existing agent
 func (tm *TokenManager) RotateToken(oldToken string) (string, error) {
+	tm.loadShared(oldToken)
+
 	tm.mutex.Lock()
 	defer tm.mutex.Unlock()
 
@@ -132,6 +263,8 @@ func (tm *TokenManager) RotateToken(oldToken string) (string, error) {
 
 	// Create new token info
 	newTokenInfo := &TokenInfo{
+		ID:          newTokenID(),
+		Name:        tokenInfo.Name,
 		Token:       newToken,
 		CreatedAt:   now,
 		ExpiresAt:   now.Add(tm.expirationTime),
@@ -139,6 +272,7 @@ func (tm *TokenManager) RotateToken(oldToken string) (string, error) {
 		AgentID:     tokenInfo.AgentID,
 		Permissions: tokenInfo.Permissions,
 		IsActive:    true,
+		OwnerID:     tokenInfo.Owner(),
 	}
 
 	// Deactivate old token
@@ -146,6 +280,8 @@ func (tm *TokenManager) RotateToken(oldToken string) (string, error) {
 
 	// Add new token
 	tm.tokens[newToken] = newTokenInfo
+	tm.saveSharedLocked(tokenInfo)
+	tm.saveSharedLocked(newTokenInfo)
 
 	return newToken, nil
 }
@@ -163,6 +299,32 @@ func (tm *TokenManager) ListTokens() []*TokenInfo {
 	return tokens
 }
 
+// HasAdminToken reports whether an active, unexpired token grants every
+// permission, here or in shared storage
+func (tm *TokenManager) HasAdminToken() bool {
+	now := time.Now()
+	for _, tokenInfo := range tm.ListTokens() {
+		if tokenInfo.grantsAll(now) {
+			return true
+		}
+	}
+	return tm.hasSharedAdminToken(now)
+}
+
+// grantsAll reports whether the token is usable at now and grants every
+// permission
+func (t *TokenInfo) grantsAll(now time.Time) bool {
+	if t.Status(now) != TokenStatusActive {
+		return false
+	}
+	for _, permission := range t.Permissions {
+		if permission == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 // CleanupExpiredTokens removes expired tokens
 func (tm *TokenManager) CleanupExpiredTokens() {
 	tm.mutex.Lock()
@@ -172,18 +334,39 @@ func (tm *TokenManager) CleanupExpiredTokens() {
 	for token, tokenInfo := range tm.tokens {
 		if now.After(tokenInfo.ExpiresAt) {
 			delete(tm.tokens, token)
+			tm.deleteSharedLocked(token)
+		}
+	}
+
+	// A successor whose predecessor expired unacknowledged stands on its own
+	for _, tokenInfo := range tm.tokens {
+		if tokenInfo.PredecessorID == "" {
+			continue
+		}
+		predecessorLeft := false
+		for _, other := range tm.tokens {
+			if other.ID == tokenInfo.PredecessorID {
+				predecessorLeft = true
+				break
+			}
+		}
+		if !predecessorLeft {
+			tokenInfo.PredecessorID = ""
 		}
 	}
 }
 
 // startTokenRotation starts the token rotation routine
 func (tm *TokenManager) startTokenRotation() {
-	ticker := time.NewTicker(tm.rotationInterval)
-	defer ticker.Stop()
-
-	for range ticker.C {
+	for {
+		// Changing the rotation settings restarts the wait with the new interval
+		select {
+		case <-time.After(tm.rotationCheckInterval()):
+		case <-tm.rotationChanged:
+			continue
+		}
 		tm.CleanupExpiredTokens()
-		// TODO: Implement automatic token rotation for long-lived tokens
+		tm.RotateExpiringTokens(time.Now())
 	}
 }
 
@@ -215,3 +398,111 @@ func (tm *TokenManager) GetTokenStats() map[string]interface{} {
 	}
 }
 
+
+// TokenFromRequest extracts a token from the Authorization header or the token query parameter
+func TokenFromRequest(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return c.Query("token")
+}
+
+// ValidateRequestToken validates the token carried by a request
+func (tm *TokenManager) ValidateRequestToken(c *gin.Context) (*TokenInfo, error) {
+	token := TokenFromRequest(c)
+	if token == "" {
+		return nil, fmt.Errorf("token required")
+	}
+	return tm.ValidateTokenFrom(token, c.ClientIP())
+}
+
+// TokenPermissionsKey is the gin context key holding the permissions of the request token
+const TokenPermissionsKey = "token_permissions"
+
+// TokenInfoKey is the gin context key holding the request token's TokenInfo
+const TokenInfoKey = "token_info"
+
+// RequestTokenInfo returns the token TokenAuthMiddleware validated for the
+// request, or nil if it did not run
+func RequestTokenInfo(c *gin.Context) *TokenInfo {
+	tokenInfo, _ := c.Get(TokenInfoKey)
+	info, _ := tokenInfo.(*TokenInfo)
+	return info
+}
+
+// TokenAuthMiddleware rejects requests without a valid token and records the
+// token identity as user_id, and the token for PermissionMiddleware
+func TokenAuthMiddleware(tm *TokenManager) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenInfo, err := tm.ValidateRequestToken(c)
+		if err != nil {
+			apierror.Abort(c, apierror.Unauthorized, err.Error())
+			return
+		}
+
+		c.Set("user_id", tokenInfo.TokenIdentity())
+		c.Set(TokenPermissionsKey, tokenInfo.Permissions)
+		c.Set(TokenInfoKey, tokenInfo)
+		c.Next()
+	}
+}
+
+// Replaces reports whether tokenInfo may take over what the token held was
+// used for: held can no longer be used, or tokenInfo is the successor it is
+// being rotated to
+func (tm *TokenManager) Replaces(tokenInfo *TokenInfo, held string) bool {
+	tm.mutex.RLock()
+	heldInfo, exists := tm.tokens[held]
+	tm.mutex.RUnlock()
+
+	if !exists {
+		heldInfo, exists = tm.loadShared(held)
+	}
+	if !exists {
+		return true
+	}
+
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	if !heldInfo.IsActive || time.Now().After(heldInfo.ExpiresAt) {
+		return true
+	}
+	return heldInfo.SuccessorID != "" && heldInfo.SuccessorID == tokenInfo.ID
+}
+
+// Owner returns the OwnerID of the token, or for a token issued before
+// owners were recorded, the token itself
+func (t *TokenInfo) Owner() string {
+	if t.OwnerID != "" {
+		return t.OwnerID
+	}
+	return "token/" + t.ID
+}
+
+// OwnerUserID returns the ID of the user owning the token, or "" if it
+// isn't a user's token
+func (t *TokenInfo) OwnerUserID() string {
+	if strings.HasPrefix(t.OwnerID, "user/") {
+		return strings.TrimPrefix(t.OwnerID, "user/")
+	}
+	return ""
+}
+
+// TokenIdentity returns a human-readable identity for audit records
+func (t *TokenInfo) TokenIdentity() string {
+	if t.AgentID != "" {
+		return t.AgentID
+	}
+	if t.Name != "" {
+		return t.Name
+	}
+	return "token/" + t.ID
+}
+
+// newTokenID generates a short public identifier for a token
+func newTokenID() string {
+	idBytes := make([]byte, 8)
+	rand.Read(idBytes)
+	return hex.EncodeToString(idBytes)
+}
@@ -0,0 +1,153 @@
+// Package security provides filtered, paginated token listings that never
+// expose token secrets.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Token statuses, derived from IsActive and ExpiresAt
+const (
+	TokenStatusActive  = "active"
+	TokenStatusExpired = "expired"
+	TokenStatusRevoked = "revoked"
+)
+
+const (
+	// DefaultTokenListLimit is the page size when a listing sets no limit
+	DefaultTokenListLimit = 100
+
+	// MaxTokenListLimit caps the page size of a listing
+	MaxTokenListLimit = 1000
+
+	// tokenPrefixLength is how many characters of a token a listing shows
+	tokenPrefixLength = 8
+)
+
+// TokenFilter selects and pages tokens; empty fields match any token
+type TokenFilter struct {
+	Status  string
+	AgentID string
+	Offset  int
+	Limit   int
+}
+
+// Validate checks the status and pagination of the filter
+func (f *TokenFilter) Validate() error {
+	switch f.Status {
+	case "", TokenStatusActive, TokenStatusExpired, TokenStatusRevoked:
+	default:
+		return fmt.Errorf("invalid status %q, must be one of: %s, %s, %s",
+			f.Status, TokenStatusActive, TokenStatusExpired, TokenStatusRevoked)
+	}
+	if f.Offset < 0 {
+		return fmt.Errorf("offset must not be negative")
+	}
+	if f.Limit < 0 || f.Limit > MaxTokenListLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxTokenListLimit)
+	}
+	return nil
+}
+
+// TokenSummary is a token as listed, identified by its ID and a masked
+// prefix; the secret is only ever returned when the token is generated
+type TokenSummary struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name,omitempty"`
+	Prefix      string    `json:"prefix"`
+	AgentID     string    `json:"agent_id,omitempty"`
+	OwnerID     string    `json:"owner_id"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	LastUsed    time.Time `json:"last_used"`
+	Status      string    `json:"status"`
+
+	// LastIP and RecentIPs are masked, see MaskIP
+	LastIP    string    `json:"last_ip,omitempty"`
+	RecentIPs []TokenIP `json:"recent_ips,omitempty"`
+}
+
+// Status returns whether the token is active, expired or revoked at now
+func (t *TokenInfo) Status(now time.Time) string {
+	switch {
+	case !t.IsActive:
+		return TokenStatusRevoked
+	case now.After(t.ExpiresAt):
+		return TokenStatusExpired
+	default:
+		return TokenStatusActive
+	}
+}
+
+// MaskToken hides all but the first characters of a token
+func MaskToken(token string) string {
+	if len(token) <= tokenPrefixLength {
+		return "****"
+	}
+	return token[:tokenPrefixLength] + "..."
+}
+
+// QueryTokens returns the page of tokens matching the filter, newest first,
+// and the number of matching tokens
+func (tm *TokenManager) QueryTokens(filter TokenFilter) ([]*TokenSummary, int, error) {
+	if err := filter.Validate(); err != nil {
+		return nil, 0, err
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultTokenListLimit
+	}
+
+	now := time.Now()
+	matched := []*TokenSummary{}
+	tm.mutex.RLock()
+	for _, tokenInfo := range tm.tokens {
+		status := tokenInfo.Status(now)
+		if filter.Status != "" && status != filter.Status {
+			continue
+		}
+		if filter.AgentID != "" && tokenInfo.AgentID != filter.AgentID {
+			continue
+		}
+		summary := &TokenSummary{
+			ID:          tokenInfo.ID,
+			Name:        tokenInfo.Name,
+			Prefix:      MaskToken(tokenInfo.Token),
+			AgentID:     tokenInfo.AgentID,
+			OwnerID:     tokenInfo.Owner(),
+			Permissions: append([]string{}, tokenInfo.Permissions...),
+			CreatedAt:   tokenInfo.CreatedAt,
+			ExpiresAt:   tokenInfo.ExpiresAt,
+			LastUsed:    tokenInfo.LastUsed,
+			Status:      status,
+		}
+		if tokenInfo.LastIP != "" {
+			summary.LastIP = MaskIP(tokenInfo.LastIP)
+			summary.RecentIPs = maskedIPs(tokenInfo.RecentIPs)
+		}
+		matched = append(matched, summary)
+	}
+	tm.mutex.RUnlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID < matched[j].ID
+	})
+
+	total := len(matched)
+	if filter.Offset >= total {
+		return []*TokenSummary{}, total, nil
+	}
+	end := filter.Offset + filter.Limit
+	if end > total {
+		end = total
+	}
+	return matched[filter.Offset:end], total, nil
+}
@@ -0,0 +1,76 @@
+// Package security provides revoking all the tokens of an owner, e.g. to
+// log a user out everywhere.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nerve/server/pkg/sharedstate"
+	"github.com/nerve/server/pkg/storage"
+)
+
+// RevokeOwnerTokens revokes every active token of an owner, see OwnerID,
+// and returns how many were revoked. With shared state enabled, tokens in
+// storage that this server has not seen are revoked too, and the other
+// servers are told to drop their cached copies.
+func (tm *TokenManager) RevokeOwnerTokens(ownerID string) (int, error) {
+	if ownerID == "" {
+		return 0, fmt.Errorf("owner ID is required")
+	}
+
+	tm.mutex.Lock()
+	revoked := make(map[string]bool)
+	for token, tokenInfo := range tm.tokens {
+		if !tokenInfo.IsActive || tokenInfo.Owner() != ownerID {
+			continue
+		}
+		tokenInfo.IsActive = false
+		tm.saveSharedLocked(tokenInfo)
+		revoked[tokenHash(token)] = true
+	}
+	store, bus := tm.store, tm.bus
+	tm.mutex.Unlock()
+
+	if bus == nil {
+		return len(revoked), nil
+	}
+	shared, err := revokeSharedOwner(store, bus, ownerID, revoked)
+	return len(revoked) + shared, err
+}
+
+// revokeSharedOwner revokes the active tokens of an owner in storage,
+// skipping those whose hash is in skip, and returns how many were revoked
+func revokeSharedOwner(store storage.Storage, bus *sharedstate.Bus, ownerID string, skip map[string]bool) (int, error) {
+	records, err := storage.ListPrefix(store, sharedTokenKeyPrefix)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for key, value := range records {
+		hash := key[len(sharedTokenKeyPrefix):]
+		if skip[hash] {
+			continue
+		}
+		tokenInfo, err := decodeSharedToken(value)
+		if err != nil || !tokenInfo.IsActive || tokenInfo.Owner() != ownerID {
+			continue
+		}
+
+		tokenInfo.IsActive = false
+		data, err := json.Marshal(tokenInfo)
+		if err != nil {
+			return revoked, err
+		}
+		if err := store.Set(key, string(data)); err != nil {
+			return revoked, err
+		}
+		bus.Publish(sharedstate.KindToken, hash)
+		revoked++
+	}
+	return revoked, nil
+}
@@ -0,0 +1,126 @@
+package security
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenOwner(t *testing.T) {
+	tm := NewTokenManager(time.Hour, time.Hour)
+	userToken, err := tm.CreateUserToken("alice", "laptop", []string{"agents:read"}, 0)
+	if err != nil {
+		t.Fatalf("CreateUserToken: %v", err)
+	}
+	agentToken, err := tm.CreateToken("", "node-1", nil, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+	ownToken, err := tm.CreateToken("bootstrap-admin", "", []string{"*"}, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token *TokenInfo
+		want  string
+	}{
+		{"user", userToken, "user/alice"},
+		{"agent", agentToken, "agent/node-1"},
+		{"itself", ownToken, "token/" + ownToken.ID},
+		{"recorded before owners", &TokenInfo{ID: "abc", Name: "bootstrap-admin"}, "token/abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.token.Owner(); got != tt.want {
+				t.Errorf("Owner() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := tm.CreateUserToken("", "laptop", nil, 0); err == nil {
+		t.Error("CreateUserToken without a user succeeded")
+	}
+}
+
+// Revoking an owner's tokens leaves the tokens of other owners, even those
+// with the same name
+func TestRevokeOwnerTokens(t *testing.T) {
+	tm := NewTokenManager(time.Hour, time.Hour)
+	create := func(userID, name string) *TokenInfo {
+		t.Helper()
+		var tokenInfo *TokenInfo
+		var err error
+		if userID != "" {
+			tokenInfo, err = tm.CreateUserToken(userID, name, []string{"*"}, 0)
+		} else {
+			tokenInfo, err = tm.CreateToken(name, "", []string{"*"}, 0)
+		}
+		if err != nil {
+			t.Fatalf("create token: %v", err)
+		}
+		return tokenInfo
+	}
+	aliceLaptop := create("alice", "admin")
+	alicePhone := create("alice", "admin")
+	bob := create("bob", "admin")
+	firstBootstrap := create("", "bootstrap-admin")
+	secondBootstrap := create("", "bootstrap-admin")
+
+	revoked, err := tm.RevokeOwnerTokens(aliceLaptop.Owner())
+	if err != nil || revoked != 2 {
+		t.Fatalf("RevokeOwnerTokens(alice) = %d, %v, want 2", revoked, err)
+	}
+	revoked, err = tm.RevokeOwnerTokens(firstBootstrap.Owner())
+	if err != nil || revoked != 1 {
+		t.Fatalf("RevokeOwnerTokens(bootstrap) = %d, %v, want 1", revoked, err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		token  *TokenInfo
+		active bool
+	}{
+		{"alice's laptop", aliceLaptop, false},
+		{"alice's phone", alicePhone, false},
+		{"bob", bob, true},
+		{"first bootstrap token", firstBootstrap, false},
+		{"second bootstrap token", secondBootstrap, true},
+	} {
+		_, err := tm.ValidateToken(tt.token.Token)
+		if active := err == nil; active != tt.active {
+			t.Errorf("%s active = %v, want %v", tt.name, active, tt.active)
+		}
+	}
+
+	if _, err := tm.RevokeOwnerTokens(""); err == nil {
+		t.Error("RevokeOwnerTokens without an owner succeeded")
+	}
+}
+
+// A token revoked while it is being validated is rejected from then on
+func TestValidateWhileRevoking(t *testing.T) {
+	tm := NewTokenManager(time.Hour, time.Hour)
+	tokenInfo, err := tm.CreateUserToken("alice", "laptop", nil, 0)
+	if err != nil {
+		t.Fatalf("CreateUserToken: %v", err)
+	}
+
+	started, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		close(started)
+		for i := 0; i < 1000; i++ {
+			tm.ValidateTokenFrom(tokenInfo.Token, "10.0.0.1")
+		}
+	}()
+	<-started
+	if _, err := tm.RevokeOwnerTokens(tokenInfo.Owner()); err != nil {
+		t.Fatalf("RevokeOwnerTokens: %v", err)
+	}
+	<-done
+
+	if _, err := tm.ValidateToken(tokenInfo.Token); err == nil {
+		t.Error("revoked token still valid")
+	}
+}
@@ -0,0 +1,181 @@
+// Package security provides automatic rotation of agent tokens nearing
+// expiry, handed to the agents over their control channel.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultTokenRotationWindow is how long before expiry an agent token is
+// rotated
+const DefaultTokenRotationWindow = 48 * time.Hour
+
+// minTokenRotationWindow keeps the rotation checks, four per window, apart
+const minTokenRotationWindow = time.Minute
+
+// TokenPusher hands the successor of a token to its agent and reports
+// whether it was delivered, i.e. the agent is connected with the token
+type TokenPusher func(agentID string, current, successor *TokenInfo) bool
+
+// SetRotationWindow enables rotating agent tokens that expire within
+// window; zero disables it
+func (tm *TokenManager) SetRotationWindow(window time.Duration) error {
+	if window < 0 || (window > 0 && window < minTokenRotationWindow) {
+		return fmt.Errorf("token rotation window must be 0 or at least %v", minTokenRotationWindow)
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.rotationWindow = window
+	tm.notifyRotationChanged()
+	return nil
+}
+
+// OnRotate sets how successor tokens are handed to agents; tokens are only
+// rotated once it is set
+func (tm *TokenManager) OnRotate(push TokenPusher) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.pushToken = push
+	tm.notifyRotationChanged()
+}
+
+// notifyRotationChanged wakes the rotation routine to pick up new settings
+func (tm *TokenManager) notifyRotationChanged() {
+	select {
+	case tm.rotationChanged <- struct{}{}:
+	default:
+	}
+}
+
+// rotationCheckInterval returns how often tokens are checked for rotation,
+// often enough that none expires unnoticed within the window
+func (tm *TokenManager) rotationCheckInterval() time.Duration {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	interval := tm.rotationInterval
+	if tm.rotationWindow > 0 && tm.pushToken != nil && tm.rotationWindow/4 < interval {
+		interval = tm.rotationWindow / 4
+	}
+	return interval
+}
+
+// RotateExpiringTokens hands a successor to each connected agent whose
+// token expires within the rotation window. A successor is resent until the
+// agent acknowledges it; the old token stays valid until then, or until it
+// expires if the agent never does. Tokens shared by several agents carry no
+// agent ID and are never rotated.
+func (tm *TokenManager) RotateExpiringTokens(now time.Time) {
+	tm.mutex.RLock()
+	window, push := tm.rotationWindow, tm.pushToken
+	tm.mutex.RUnlock()
+	if window <= 0 || push == nil {
+		return
+	}
+
+	for _, tokenInfo := range tm.expiringAgentTokens(now, window) {
+		successor, created, err := tm.successorOf(tokenInfo)
+		if err != nil {
+			continue
+		}
+		if push(tokenInfo.AgentID, tokenInfo, successor) {
+			if created {
+				// Shared only once delivered, so an agent connected elsewhere
+				// never has a successor created here
+				tm.mutex.Lock()
+				tm.saveSharedLocked(successor)
+				tm.saveSharedLocked(tokenInfo)
+				tm.mutex.Unlock()
+			}
+			continue
+		}
+		if !created {
+			continue
+		}
+
+		// Not delivered, e.g. the agent is disconnected; try again next time
+		tm.mutex.Lock()
+		delete(tm.tokens, successor.Token)
+		tokenInfo.SuccessorID = ""
+		tm.mutex.Unlock()
+	}
+}
+
+// expiringAgentTokens returns the active agent tokens expiring within window
+func (tm *TokenManager) expiringAgentTokens(now time.Time, window time.Duration) []*TokenInfo {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	var expiring []*TokenInfo
+	for _, tokenInfo := range tm.tokens {
+		if tokenInfo.AgentID != "" && tokenInfo.IsActive && tokenInfo.PredecessorID == "" &&
+			!now.After(tokenInfo.ExpiresAt) && tokenInfo.ExpiresAt.Sub(now) <= window {
+			expiring = append(expiring, tokenInfo)
+		}
+	}
+	return expiring
+}
+
+// successorOf returns the pending successor of a token, creating it if
+// there is none yet
+func (tm *TokenManager) successorOf(tokenInfo *TokenInfo) (*TokenInfo, bool, error) {
+	tm.mutex.Lock()
+	if tokenInfo.SuccessorID != "" {
+		for _, candidate := range tm.tokens {
+			if candidate.ID == tokenInfo.SuccessorID {
+				tm.mutex.Unlock()
+				return candidate, false, nil
+			}
+		}
+	}
+	tm.mutex.Unlock()
+
+	successor, err := tm.createToken(tokenInfo.Name, tokenInfo.AgentID, tokenInfo.Owner(), tokenInfo.Permissions, tm.expirationTime)
+	if err != nil {
+		return nil, false, err
+	}
+
+	tm.mutex.Lock()
+	successor.PredecessorID = tokenInfo.ID
+	tokenInfo.SuccessorID = successor.ID
+	tm.mutex.Unlock()
+	return successor, true, nil
+}
+
+// AckRotation completes the rotation to a successor token the agent has
+// switched to, revoking the token it replaces
+func (tm *TokenManager) AckRotation(agentID, successorID string) (*TokenInfo, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	var successor, predecessor *TokenInfo
+	for _, tokenInfo := range tm.tokens {
+		if tokenInfo.ID == successorID {
+			successor = tokenInfo
+		}
+	}
+	if successor == nil || successor.AgentID != agentID || successor.PredecessorID == "" {
+		return nil, fmt.Errorf("no pending token rotation %s for agent %s", successorID, agentID)
+	}
+	for _, tokenInfo := range tm.tokens {
+		if tokenInfo.ID == successor.PredecessorID {
+			predecessor = tokenInfo
+		}
+	}
+
+	if predecessor != nil {
+		predecessor.IsActive = false
+		predecessor.SuccessorID = ""
+		tm.saveSharedLocked(predecessor)
+	}
+	successor.PredecessorID = ""
+	tm.saveSharedLocked(successor)
+	return successor, nil
+}
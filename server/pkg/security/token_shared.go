@@ -0,0 +1,190 @@
+// Package security provides sharing tokens between the servers behind a
+// load balancer through their common storage.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nerve/server/pkg/sharedstate"
+	"github.com/nerve/server/pkg/storage"
+)
+
+// sharedTokenKeyPrefix prefixes the storage keys of shared tokens
+const sharedTokenKeyPrefix = "api_token:"
+
+// EnableSharedState shares tokens with the other servers using store,
+// telling them about changes over the bus. Tokens are written when they are
+// created, rotated or revoked, keyed by their hash; the tokens themselves are
+// never stored. A token unknown here is looked up in storage, and the cached
+// copy of a token another server changed is dropped so it is looked up
+// again. Usage and recent IPs are tracked by each server on its own. Must be
+// called before the bus is started.
+func (tm *TokenManager) EnableSharedState(store storage.Storage, bus *sharedstate.Bus) {
+	tm.mutex.Lock()
+	tm.store = store
+	tm.bus = bus
+	tm.mutex.Unlock()
+
+	bus.Handle(sharedstate.KindToken, func(msg sharedstate.Message) {
+		tm.dropShared(msg.Key)
+	})
+}
+
+// sharedTokenKey is the storage key of a shared token
+func sharedTokenKey(token string) string {
+	return sharedTokenKeyPrefix + tokenHash(token)
+}
+
+// tokenHash identifies a token without revealing it
+func tokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// saveSharedLocked writes a changed token and tells the other servers;
+// caller must hold tm.mutex
+func (tm *TokenManager) saveSharedLocked(tokenInfo *TokenInfo) {
+	if tm.bus == nil {
+		return
+	}
+
+	data, err := json.Marshal(tokenInfo)
+	if err == nil {
+		err = tm.store.Set(sharedTokenKey(tokenInfo.Token), string(data))
+	}
+	if err != nil {
+		fmt.Printf("Failed to share token %s: %v\n", tokenInfo.ID, err)
+		return
+	}
+	tm.bus.Publish(sharedstate.KindToken, tokenHash(tokenInfo.Token))
+}
+
+// deleteSharedLocked removes an expired token from storage; caller must
+// hold tm.mutex
+func (tm *TokenManager) deleteSharedLocked(token string) {
+	if tm.bus == nil {
+		return
+	}
+	if err := tm.store.Delete(sharedTokenKey(token)); err != nil {
+		fmt.Printf("Failed to delete shared token: %v\n", err)
+	}
+}
+
+// loadShared looks up a token unknown here in storage and caches it
+func (tm *TokenManager) loadShared(token string) (*TokenInfo, bool) {
+	tm.mutex.RLock()
+	store, bus := tm.store, tm.bus
+	tm.mutex.RUnlock()
+	if bus == nil || token == "" {
+		return nil, false
+	}
+
+	value, err := store.Get(sharedTokenKey(token))
+	if err != nil {
+		return nil, false
+	}
+	tokenInfo, err := decodeSharedToken(value)
+	if err != nil {
+		return nil, false
+	}
+	tokenInfo.Token = token
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	if cached, ok := tm.tokens[token]; ok {
+		return cached, true
+	}
+	tm.tokens[token] = tokenInfo
+	return tokenInfo, true
+}
+
+// revokeSharedByID revokes a token by its ID in storage, for tokens this
+// server has not seen
+func (tm *TokenManager) revokeSharedByID(id string) error {
+	tm.mutex.RLock()
+	store, bus := tm.store, tm.bus
+	tm.mutex.RUnlock()
+	if bus == nil {
+		return fmt.Errorf("token not found")
+	}
+
+	records, err := storage.ListPrefix(store, sharedTokenKeyPrefix)
+	if err != nil {
+		return err
+	}
+	for key, value := range records {
+		tokenInfo, err := decodeSharedToken(value)
+		if err != nil || tokenInfo.ID != id {
+			continue
+		}
+
+		tokenInfo.IsActive = false
+		data, err := json.Marshal(tokenInfo)
+		if err != nil {
+			return err
+		}
+		if err := store.Set(key, string(data)); err != nil {
+			return err
+		}
+		bus.Publish(sharedstate.KindToken, key[len(sharedTokenKeyPrefix):])
+		return nil
+	}
+	return fmt.Errorf("token not found")
+}
+
+// hasSharedAdminToken reports whether storage holds a token usable at now
+// that grants every permission
+func (tm *TokenManager) hasSharedAdminToken(now time.Time) bool {
+	tm.mutex.RLock()
+	store, bus := tm.store, tm.bus
+	tm.mutex.RUnlock()
+	if bus == nil {
+		return false
+	}
+
+	records, err := storage.ListPrefix(store, sharedTokenKeyPrefix)
+	if err != nil {
+		return false
+	}
+	for _, value := range records {
+		tokenInfo, err := decodeSharedToken(value)
+		if err == nil && tokenInfo.grantsAll(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// dropShared forgets the cached copy of a token another server changed
+func (tm *TokenManager) dropShared(hash string) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	for token := range tm.tokens {
+		if tokenHash(token) == hash {
+			delete(tm.tokens, token)
+			return
+		}
+	}
+}
+
+// decodeSharedToken decodes a token read from storage
+func decodeSharedToken(value interface{}) (*TokenInfo, error) {
+	data, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected value type %T", value)
+	}
+	var tokenInfo TokenInfo
+	if err := json.Unmarshal([]byte(data), &tokenInfo); err != nil {
+		return nil, err
+	}
+	return &tokenInfo, nil
+}
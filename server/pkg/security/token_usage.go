@@ -0,0 +1,99 @@
+// Package security provides tracking of the client IPs tokens are used from,
+// for spotting stolen tokens.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package security
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxRecentTokenIPs caps the client IPs remembered per token; the least
+// recently seen is forgotten first
+const MaxRecentTokenIPs = 10
+
+// TokenIP is a client IP a token was used from
+type TokenIP struct {
+	IP       string    `json:"ip"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// NewIPHandler is called when a token that was already used from other IPs
+// is used from one it has not been seen from recently
+type NewIPHandler func(tokenInfo *TokenInfo, ip string, knownIPs []string)
+
+// OnNewIP registers a callback for tokens used from a new IP
+func (tm *TokenManager) OnNewIP(handler NewIPHandler) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.newIPHandlers = append(tm.newIPHandlers, handler)
+}
+
+// recordUseLocked updates the last use of a token and, for a known ip, its
+// recent IPs. It returns the IPs the token was used from before when ip is
+// new to a token that has been used elsewhere; caller must hold tm.mutex.
+func (t *TokenInfo) recordUseLocked(ip string, now time.Time) []string {
+	t.LastUsed = now
+	if ip == "" {
+		return nil
+	}
+	t.LastIP = ip
+
+	for i := range t.RecentIPs {
+		if t.RecentIPs[i].IP == ip {
+			t.RecentIPs[i].LastSeen = now
+			return nil
+		}
+	}
+
+	known := make([]string, 0, len(t.RecentIPs))
+	for _, recent := range t.RecentIPs {
+		known = append(known, recent.IP)
+	}
+
+	t.RecentIPs = append(t.RecentIPs, TokenIP{IP: ip, LastSeen: now})
+	if len(t.RecentIPs) > MaxRecentTokenIPs {
+		sort.Slice(t.RecentIPs, func(i, j int) bool {
+			return t.RecentIPs[i].LastSeen.After(t.RecentIPs[j].LastSeen)
+		})
+		t.RecentIPs = t.RecentIPs[:MaxRecentTokenIPs]
+	}
+
+	if len(known) == 0 {
+		return nil
+	}
+	return known
+}
+
+// MaskIP hides the host part of an IP: the last octet of IPv4 addresses and
+// all but the /48 prefix of IPv6 addresses
+func MaskIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "****"
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		parts := strings.Split(v4.String(), ".")
+		return strings.Join(parts[:3], ".") + ".*"
+	}
+	masked := parsed.Mask(net.CIDRMask(48, 128)).String()
+	return strings.TrimSuffix(masked, "::") + "::*"
+}
+
+// maskedIPs returns recent IPs with their host parts masked, most recently
+// seen first
+func maskedIPs(recent []TokenIP) []TokenIP {
+	masked := make([]TokenIP, 0, len(recent))
+	for _, entry := range recent {
+		masked = append(masked, TokenIP{IP: MaskIP(entry.IP), LastSeen: entry.LastSeen})
+	}
+	sort.SliceStable(masked, func(i, j int) bool {
+		return masked[i].LastSeen.After(masked[j].LastSeen)
+	})
+	return masked
+}
@@ -0,0 +1,176 @@
+// Package sharedstate provides the message bus servers sharing a storage
+// use to keep their in-memory state in sync: each server publishes the
+// records it changed, and the others drop or reload their cached copies.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sharedstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/storage"
+)
+
+// Channel is the storage notification channel the bus uses
+const Channel = "nerve:shared"
+
+// Kinds of messages published on the bus
+const (
+	KindAgent        = "agent"
+	KindTask         = "task"
+	KindToken        = "token"
+	KindRetiredToken = "retired_token"
+	KindEvent        = "event"
+)
+
+// publishQueue caps the messages waiting to be published; more are dropped,
+// and the other servers catch up when their caches expire
+const publishQueue = 1024
+
+// Message tells the other servers a shared record changed, or carries a
+// real-time event for them to deliver
+type Message struct {
+	// Instance is the server that published the message
+	Instance string `json:"instance"`
+	Kind     string `json:"kind"`
+	Key      string `json:"key"`
+
+	// Data is set for events; changed records are reread from storage
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// Bus publishes messages to the other servers sharing a storage and
+// dispatches theirs to the handlers of each kind. A server never receives
+// its own messages.
+type Bus struct {
+	instance string
+	notifier storage.Notifier
+	logger   log.Logger
+
+	mu       sync.RWMutex
+	handlers map[string][]func(Message)
+
+	queue       chan Message
+	unsubscribe func()
+}
+
+// DefaultInstanceID names this server by its hostname and process ID
+func DefaultInstanceID() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// New creates a bus over a storage whose backend supports notifications.
+// instanceID must be unique among the servers sharing the storage.
+func New(store storage.Storage, instanceID string, logger log.Logger) (*Bus, error) {
+	notifier, ok := store.(storage.Notifier)
+	if !ok {
+		return nil, storage.ErrNoNotify
+	}
+	if instanceID == "" {
+		return nil, fmt.Errorf("instance ID must not be empty")
+	}
+	return &Bus{
+		instance: instanceID,
+		notifier: notifier,
+		logger:   logger,
+		handlers: make(map[string][]func(Message)),
+		queue:    make(chan Message, publishQueue),
+	}, nil
+}
+
+// InstanceID returns the ID this server publishes under
+func (b *Bus) InstanceID() string {
+	return b.instance
+}
+
+// Handle registers a handler for the messages of a kind other servers publish
+func (b *Bus) Handle(kind string, handler func(Message)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[kind] = append(b.handlers[kind], handler)
+}
+
+// Start subscribes to the other servers' messages and starts publishing ours
+func (b *Bus) Start() error {
+	unsubscribe, err := b.notifier.Subscribe(Channel, b.dispatch)
+	if err != nil {
+		return fmt.Errorf("subscribe to %s: %v", Channel, err)
+	}
+	b.unsubscribe = unsubscribe
+
+	go b.publishLoop()
+	return nil
+}
+
+// Close stops receiving the other servers' messages
+func (b *Bus) Close() {
+	if b.unsubscribe != nil {
+		b.unsubscribe()
+	}
+}
+
+// Publish tells the other servers a record changed. It never blocks: the
+// message is dropped if too many are waiting to be published.
+func (b *Bus) Publish(kind, key string) {
+	b.enqueue(Message{Instance: b.instance, Kind: kind, Key: key})
+}
+
+// PublishData sends data of a kind to the other servers, e.g. an event for
+// their UI observers
+func (b *Bus) PublishData(kind, key string, data interface{}) {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		b.logger.Errorf("Failed to encode %s message: %v", kind, err)
+		return
+	}
+	b.enqueue(Message{Instance: b.instance, Kind: kind, Key: key, Data: encoded})
+}
+
+// enqueue queues a message for publishing
+func (b *Bus) enqueue(msg Message) {
+	select {
+	case b.queue <- msg:
+	default:
+		b.logger.Errorf("Shared state queue full, dropping %s message for %s", msg.Kind, msg.Key)
+	}
+}
+
+// publishLoop publishes queued messages in order
+func (b *Bus) publishLoop() {
+	for msg := range b.queue {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			continue
+		}
+		if err := b.notifier.Publish(Channel, data); err != nil {
+			b.logger.Errorf("Failed to publish %s message for %s: %v", msg.Kind, msg.Key, err)
+		}
+	}
+}
+
+// dispatch passes a message from another server to the handlers of its kind
+func (b *Bus) dispatch(data []byte) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		b.logger.Errorf("Invalid shared state message: %v", err)
+		return
+	}
+	if msg.Instance == b.instance {
+		return
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers[msg.Kind]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(msg)
+	}
+}
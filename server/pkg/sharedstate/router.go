@@ -0,0 +1,178 @@
+// Package sharedstate provides routing of control messages to agents whose
+// WebSocket is held by another server.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package sharedstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nerve/server/pkg/storage"
+)
+
+// Kinds of messages the router publishes
+const (
+	KindAgentMessage    = "agent_message"
+	KindAgentMessageAck = "agent_message_ack"
+)
+
+const (
+	// agentRouteKeyPrefix prefixes the storage keys naming the server that
+	// holds an agent's WebSocket
+	agentRouteKeyPrefix = "agent_route:"
+
+	// forwardTimeout is how long a forwarded message waits for the owning
+	// server to report whether the agent received it
+	forwardTimeout = 2 * time.Second
+)
+
+// LocalAgents are the agent WebSockets held by this server
+type LocalAgents interface {
+	// DeliverToAgent writes a message to an agent connected to this server
+	DeliverToAgent(agentID string, message []byte) bool
+
+	// IsAgentConnected reports whether the agent is connected to this server
+	IsAgentConnected(agentID string) bool
+}
+
+// agentMessage is a message forwarded to the server holding an agent's
+// WebSocket, or that server's report of whether the agent received it
+type agentMessage struct {
+	// To is the server the message is meant for
+	To        string `json:"to"`
+	ID        string `json:"id,omitempty"`
+	Message   []byte `json:"message,omitempty"`
+	Delivered bool   `json:"delivered,omitempty"`
+}
+
+// Router keeps a table in storage of the server holding each agent's
+// WebSocket, and forwards messages for agents connected elsewhere to that
+// server over the bus.
+type Router struct {
+	bus    *Bus
+	store  storage.Storage
+	agents LocalAgents
+
+	// routeMu orders the route writes of agents reconnecting to this server
+	routeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[string]chan bool
+	nextID  uint64
+}
+
+// NewRouter creates a router delivering the messages forwarded to this
+// server to agents. Must be called before the bus is started.
+func NewRouter(bus *Bus, store storage.Storage, agents LocalAgents) *Router {
+	r := &Router{
+		bus:     bus,
+		store:   store,
+		agents:  agents,
+		pending: make(map[string]chan bool),
+	}
+	bus.Handle(KindAgentMessage, r.deliver)
+	bus.Handle(KindAgentMessageAck, r.acknowledged)
+	return r
+}
+
+// Claim records this server as holding an agent's WebSocket
+func (r *Router) Claim(agentID string) {
+	r.routeMu.Lock()
+	defer r.routeMu.Unlock()
+
+	if err := r.store.Set(agentRouteKeyPrefix+agentID, r.bus.instance); err != nil {
+		r.bus.logger.Errorf("Failed to record route of agent %s: %v", agentID, err)
+	}
+}
+
+// Release drops an agent's route once its WebSocket to this server closed,
+// unless it reconnected meanwhile or now connects through another server
+func (r *Router) Release(agentID string) {
+	r.routeMu.Lock()
+	defer r.routeMu.Unlock()
+
+	if r.agents.IsAgentConnected(agentID) || r.Owner(agentID) != r.bus.instance {
+		return
+	}
+	if err := r.store.Delete(agentRouteKeyPrefix + agentID); err != nil && err != storage.ErrNotFound {
+		r.bus.logger.Errorf("Failed to drop route of agent %s: %v", agentID, err)
+	}
+}
+
+// Owner returns the server holding an agent's WebSocket, or "" if none is
+// known
+func (r *Router) Owner(agentID string) string {
+	value, err := r.store.Get(agentRouteKeyPrefix + agentID)
+	if err != nil {
+		return ""
+	}
+	owner, _ := value.(string)
+	return owner
+}
+
+// Forward sends a message for an agent not connected here to the server
+// holding its WebSocket, and reports whether the agent received it. An agent
+// without a route, or routed here, is not connected anywhere.
+func (r *Router) Forward(agentID string, message []byte) bool {
+	owner := r.Owner(agentID)
+	if owner == "" || owner == r.bus.instance {
+		return false
+	}
+
+	id := fmt.Sprintf("%s-%d", r.bus.instance, atomic.AddUint64(&r.nextID, 1))
+	delivered := make(chan bool, 1)
+	r.mu.Lock()
+	r.pending[id] = delivered
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	r.bus.PublishData(KindAgentMessage, agentID, agentMessage{To: owner, ID: id, Message: message})
+
+	// An owner that died with the agent connected never answers
+	select {
+	case ok := <-delivered:
+		return ok
+	case <-time.After(forwardTimeout):
+		r.bus.logger.Errorf("No answer from %s forwarding a message to agent %s", owner, agentID)
+		return false
+	}
+}
+
+// deliver passes a message forwarded to this server on to the agent and
+// reports back whether it received it
+func (r *Router) deliver(msg Message) {
+	var forwarded agentMessage
+	if err := json.Unmarshal(msg.Data, &forwarded); err != nil || forwarded.To != r.bus.instance {
+		return
+	}
+
+	delivered := r.agents.DeliverToAgent(msg.Key, forwarded.Message)
+	r.bus.PublishData(KindAgentMessageAck, forwarded.ID, agentMessage{To: msg.Instance, Delivered: delivered})
+}
+
+// acknowledged wakes the Forward call waiting for a report
+func (r *Router) acknowledged(msg Message) {
+	var ack agentMessage
+	if err := json.Unmarshal(msg.Data, &ack); err != nil || ack.To != r.bus.instance {
+		return
+	}
+
+	r.mu.Lock()
+	delivered, ok := r.pending[msg.Key]
+	r.mu.Unlock()
+	if ok {
+		select {
+		case delivered <- ack.Delivered:
+		default:
+		}
+	}
+}
@@ -0,0 +1,50 @@
+// Package storage provides on-demand cleanup of old records and advisory
+// locks for jobs that must run on one server at a time.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import (
+	"errors"
+	"hash/fnv"
+	"time"
+)
+
+// ErrNoCleanup is returned by wrapping storages whose backend keeps no
+// records that age out
+var ErrNoCleanup = errors.New("storage does not support cleanup")
+
+// ErrNoLocks is returned by wrapping storages whose backend is not shared
+// between servers and so needs no locks
+var ErrNoLocks = errors.New("storage does not support locks")
+
+// Cleaner is implemented by storage backends that keep heartbeat history or
+// task results and can remove the old ones on demand
+type Cleaner interface {
+	// DeleteHeartbeatsBefore removes heartbeat samples taken before cutoff
+	DeleteHeartbeatsBefore(cutoff time.Time) (int64, error)
+
+	// DeleteTaskResultsBefore removes task results completed before cutoff
+	DeleteTaskResultsBefore(cutoff time.Time) (int64, error)
+}
+
+// Locker is implemented by storage backends shared by several servers, to
+// run a job on one of them at a time. Locks are advisory: they only exclude
+// other callers of TryLock.
+type Locker interface {
+	// TryLock takes the named lock for owner, reporting false if another
+	// owner holds it. The lock is released by Unlock, or once ttl passes if
+	// its owner goes away without unlocking.
+	TryLock(name, owner string, ttl time.Duration) (bool, error)
+
+	// Unlock releases the named lock if owner holds it
+	Unlock(name, owner string) error
+}
+
+// lockKey maps a lock name to the 64-bit key of a PostgreSQL advisory lock
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("nerve:" + name))
+	return int64(h.Sum64())
+}
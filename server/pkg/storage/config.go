@@ -5,6 +5,7 @@
 package storage
 
 import (
+	"fmt"
 	"time"
 )
 
@@ -14,6 +15,10 @@ type Config struct {
 	MongoDB  *MongoDBConfig      `yaml:"mongodb,omitempty"`
 	Redis    *RedisConfig        `yaml:"redis,omitempty"`
 	Postgres *PostgresConfig     `yaml:"postgres,omitempty"`
+	Tiered   *TieredConfig       `yaml:"tiered,omitempty"`
+
+	// TaskResults sets the output cap and retention of stored task results
+	TaskResults *TaskResultConfig `yaml:"task_results,omitempty"`
 }
 
 // MongoDBConfig contains MongoDB connection configuration
@@ -42,24 +47,56 @@ type PostgresConfig struct {
 	SSLMode  string `yaml:"sslmode"`
 }
 
+// TieredConfig names the backend types used as cache and primary of a
+// "tiered" storage; each is configured by its own section of Config
+type TieredConfig struct {
+	Cache   string `yaml:"cache"`
+	Primary string `yaml:"primary"`
+}
+
 // NewFromConfig creates a storage instance from configuration
 func NewFromConfig(cfg Config) (Storage, error) {
+	taskResults := DefaultTaskResultConfig()
+	if cfg.TaskResults != nil {
+		if cfg.TaskResults.MaxOutputBytes != 0 {
+			taskResults.MaxOutputBytes = cfg.TaskResults.MaxOutputBytes
+		}
+		if cfg.TaskResults.Retention != 0 {
+			taskResults.Retention = cfg.TaskResults.Retention
+		}
+		if err := taskResults.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	switch cfg.Type {
 	case "mongodb":
 		if cfg.MongoDB == nil {
 			return nil, ErrNotFound
 		}
-		return NewMongoDB(*cfg.MongoDB)
+		store, err := NewMongoDB(*cfg.MongoDB)
+		if err != nil {
+			return nil, err
+		}
+		store.SetTaskResultConfig(taskResults)
+		return store, nil
 	case "postgres":
 		if cfg.Postgres == nil {
 			return nil, ErrNotFound
 		}
-		return NewPostgres(*cfg.Postgres)
+		store, err := NewPostgres(*cfg.Postgres)
+		if err != nil {
+			return nil, err
+		}
+		store.SetTaskResultConfig(taskResults)
+		return store, nil
 	case "redis":
 		if cfg.Redis == nil {
 			return nil, ErrNotFound
 		}
 		return NewRedis(*cfg.Redis)
+	case "tiered":
+		return newTieredFromConfig(cfg)
 	case "memory", "":
 		return NewInMemory(), nil
 	default:
@@ -67,3 +104,37 @@ func NewFromConfig(cfg Config) (Storage, error) {
 	}
 }
 
+// newTieredFromConfig creates the cache and primary backends of a tiered storage
+func newTieredFromConfig(cfg Config) (Storage, error) {
+	if cfg.Tiered == nil {
+		return nil, ErrNotFound
+	}
+	tiers := cfg.Tiered
+	for _, backend := range []string{tiers.Cache, tiers.Primary} {
+		switch backend {
+		case "mongodb", "postgres", "redis", "memory":
+		default:
+			return nil, fmt.Errorf("unsupported tiered storage backend %q", backend)
+		}
+	}
+	if tiers.Cache == tiers.Primary {
+		return nil, fmt.Errorf("tiered storage needs different cache and primary backends")
+	}
+
+	tier := cfg
+	tier.Tiered = nil
+
+	tier.Type = tiers.Primary
+	primary, err := NewFromConfig(tier)
+	if err != nil {
+		return nil, fmt.Errorf("primary storage: %v", err)
+	}
+
+	tier.Type = tiers.Cache
+	cache, err := NewFromConfig(tier)
+	if err != nil {
+		return nil, fmt.Errorf("cache storage: %v", err)
+	}
+
+	return NewTiered(cache, primary), nil
+}
@@ -0,0 +1,52 @@
+// Package storage provides heartbeat history types and downsampling helpers.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoHeartbeatHistory is returned for heartbeat samples saved to a backend
+// that can't query them back
+var ErrNoHeartbeatHistory = errors.New("storage does not keep heartbeat history")
+
+// HeartbeatPoint represents a single (possibly downsampled) heartbeat sample
+type HeartbeatPoint struct {
+	Timestamp time.Time              `json:"timestamp"`
+	Samples   int                    `json:"samples"`
+	Metrics   map[string]interface{} `json:"metrics"`
+}
+
+// HeartbeatQuerier is implemented by storage backends that keep heartbeat history
+type HeartbeatQuerier interface {
+	GetHeartbeats(agentID string, from, to time.Time, limit int) ([]HeartbeatPoint, error)
+}
+
+// HeartbeatRecorder is implemented by storage backends that save heartbeat
+// samples; only those that are also a HeartbeatQuerier keep a history that
+// can be read back
+type HeartbeatRecorder interface {
+	SaveHeartbeat(agentID string, heartbeat interface{}) error
+}
+
+// heartbeatBuckets returns the start and width of limit equal buckets
+// spanning a time range. A zero from starts at the first sample, and the
+// range reaches at least the last sample.
+func heartbeatBuckets(from, to, first, last time.Time, limit int) (time.Time, time.Duration) {
+	if from.IsZero() {
+		from = first
+	}
+	if to.IsZero() || to.Before(last) {
+		to = last
+	}
+
+	width := to.Sub(from) / time.Duration(limit)
+	// Timestamps are stored with millisecond precision
+	if width < time.Millisecond {
+		width = time.Millisecond
+	}
+	return from, width
+}
@@ -0,0 +1,244 @@
+// Package storage provides a storage wrapper that bounds concurrent writes.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// DefaultMaxConcurrentWrites caps the writes in flight to the backend
+	DefaultMaxConcurrentWrites = 32
+
+	// DefaultWriteWait is how long a write may wait for a free slot
+	DefaultWriteWait = 5 * time.Second
+)
+
+// ErrOverloaded is returned for writes shed because the backend is saturated
+var ErrOverloaded = errors.New("storage overloaded: too many concurrent writes")
+
+// WriteObserver receives the metrics of writes through a LimitedStorage
+type WriteObserver interface {
+	AddDataWriteQueueDepth(delta int)
+	RecordDataWrite(success bool)
+	RecordDataWriteDuration(duration time.Duration)
+	RecordDataWriteShed()
+}
+
+// LimitedStorage bounds the writes in flight to a backend, so a burst of
+// writes queues in the server instead of exhausting the backend's
+// connections. Writes wait up to maxWait for a free slot and are shed with
+// ErrOverloaded after that; reads are passed through.
+type LimitedStorage struct {
+	backend  Storage
+	slots    chan struct{}
+	maxWait  time.Duration
+	observer WriteObserver
+}
+
+// NewLimited wraps a backend, allowing maxConcurrent writes at a time. A
+// zero maxWait sheds writes as soon as all slots are busy. observer may be nil.
+func NewLimited(backend Storage, maxConcurrent int, maxWait time.Duration, observer WriteObserver) (*LimitedStorage, error) {
+	if maxConcurrent <= 0 {
+		return nil, fmt.Errorf("max concurrent writes must be positive")
+	}
+	if maxWait < 0 {
+		return nil, fmt.Errorf("write wait must not be negative")
+	}
+	return &LimitedStorage{
+		backend:  backend,
+		slots:    make(chan struct{}, maxConcurrent),
+		maxWait:  maxWait,
+		observer: observer,
+	}, nil
+}
+
+// Get retrieves a value from the backend
+func (l *LimitedStorage) Get(key string) (interface{}, error) {
+	return l.backend.Get(key)
+}
+
+// Set stores a value once a write slot is free
+func (l *LimitedStorage) Set(key string, value interface{}) error {
+	return l.write(func() error { return l.backend.Set(key, value) })
+}
+
+// Delete removes a value once a write slot is free
+func (l *LimitedStorage) Delete(key string) error {
+	return l.write(func() error { return l.backend.Delete(key) })
+}
+
+// List returns all key-value pairs from the backend
+func (l *LimitedStorage) List() map[string]interface{} {
+	return l.backend.List()
+}
+
+// ListPrefix returns the key-value pairs under a key prefix from the backend
+func (l *LimitedStorage) ListPrefix(prefix string) (map[string]interface{}, error) {
+	return ListPrefix(l.backend, prefix)
+}
+
+// GetHeartbeats queries heartbeat history from the backend
+func (l *LimitedStorage) GetHeartbeats(agentID string, from, to time.Time, limit int) ([]HeartbeatPoint, error) {
+	querier, ok := l.backend.(HeartbeatQuerier)
+	if !ok {
+		return nil, fmt.Errorf("storage does not keep heartbeat history")
+	}
+	return querier.GetHeartbeats(agentID, from, to, limit)
+}
+
+// SaveHeartbeat saves a heartbeat sample once a write slot is free, if the
+// backend keeps heartbeat history
+func (l *LimitedStorage) SaveHeartbeat(agentID string, heartbeat interface{}) error {
+	recorder, ok := l.backend.(HeartbeatRecorder)
+	if _, queryable := l.backend.(HeartbeatQuerier); !ok || !queryable {
+		return ErrNoHeartbeatHistory
+	}
+	return l.write(func() error { return recorder.SaveHeartbeat(agentID, heartbeat) })
+}
+
+// SaveTaskResult saves a task result once a write slot is free
+func (l *LimitedStorage) SaveTaskResult(record *TaskResultRecord) error {
+	results, ok := l.backend.(TaskResultStore)
+	if !ok {
+		return ErrNoTaskResults
+	}
+	return l.write(func() error { return results.SaveTaskResult(record) })
+}
+
+// GetTaskResult retrieves a task result from the backend
+func (l *LimitedStorage) GetTaskResult(taskID string) (*TaskResultRecord, error) {
+	results, ok := l.backend.(TaskResultStore)
+	if !ok {
+		return nil, ErrNoTaskResults
+	}
+	return results.GetTaskResult(taskID)
+}
+
+// DeleteHeartbeatsBefore removes old heartbeat samples once a write slot is
+// free
+func (l *LimitedStorage) DeleteHeartbeatsBefore(cutoff time.Time) (int64, error) {
+	cleaner, ok := l.backend.(Cleaner)
+	if !ok {
+		return 0, ErrNoCleanup
+	}
+	var deleted int64
+	err := l.write(func() (err error) {
+		deleted, err = cleaner.DeleteHeartbeatsBefore(cutoff)
+		return err
+	})
+	return deleted, err
+}
+
+// DeleteTaskResultsBefore removes old task results once a write slot is free
+func (l *LimitedStorage) DeleteTaskResultsBefore(cutoff time.Time) (int64, error) {
+	cleaner, ok := l.backend.(Cleaner)
+	if !ok {
+		return 0, ErrNoCleanup
+	}
+	var deleted int64
+	err := l.write(func() (err error) {
+		deleted, err = cleaner.DeleteTaskResultsBefore(cutoff)
+		return err
+	})
+	return deleted, err
+}
+
+// TryLock takes a lock from the backend
+func (l *LimitedStorage) TryLock(name, owner string, ttl time.Duration) (bool, error) {
+	locker, ok := l.backend.(Locker)
+	if !ok {
+		return false, ErrNoLocks
+	}
+	return locker.TryLock(name, owner, ttl)
+}
+
+// Unlock releases a lock taken from the backend
+func (l *LimitedStorage) Unlock(name, owner string) error {
+	locker, ok := l.backend.(Locker)
+	if !ok {
+		return ErrNoLocks
+	}
+	return locker.Unlock(name, owner)
+}
+
+// Publish sends a message through the backend; it is not a write
+func (l *LimitedStorage) Publish(channel string, message []byte) error {
+	notifier, ok := l.backend.(Notifier)
+	if !ok {
+		return ErrNoNotify
+	}
+	return notifier.Publish(channel, message)
+}
+
+// Subscribe subscribes to messages through the backend
+func (l *LimitedStorage) Subscribe(channel string, handler func(message []byte)) (func(), error) {
+	notifier, ok := l.backend.(Notifier)
+	if !ok {
+		return nil, ErrNoNotify
+	}
+	return notifier.Subscribe(channel, handler)
+}
+
+// Close closes the backend
+func (l *LimitedStorage) Close() error {
+	if closer, ok := l.backend.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// write runs fn in a write slot, waiting for one up to maxWait
+func (l *LimitedStorage) write(fn func() error) error {
+	if !l.acquire() {
+		if l.observer != nil {
+			l.observer.RecordDataWriteShed()
+		}
+		return ErrOverloaded
+	}
+	defer func() { <-l.slots }()
+
+	start := time.Now()
+	err := fn()
+	if l.observer != nil {
+		l.observer.RecordDataWriteDuration(time.Since(start))
+		l.observer.RecordDataWrite(err == nil)
+	}
+	return err
+}
+
+// acquire takes a write slot, reporting false if none frees up in time
+func (l *LimitedStorage) acquire() bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.maxWait == 0 {
+		return false
+	}
+
+	l.addDepth(1)
+	defer l.addDepth(-1)
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// addDepth reports a write starting or ending to wait for a slot
+func (l *LimitedStorage) addDepth(delta int) {
+	if l.observer != nil {
+		l.observer.AddDataWriteQueueDepth(delta)
+	}
+}
@@ -6,6 +6,7 @@ package storage
 
 import (
 	"context"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,6 +18,10 @@ import (
 type MongoDBStorage struct {
 	client   *mongo.Client
 	database *mongo.Database
+
+	// taskResults caps stored output and sets the expiry of task results
+	// as they are saved
+	taskResults TaskResultConfig
 }
 
 // NewMongoDB creates a new MongoDB storage instance
@@ -40,8 +45,9 @@ func NewMongoDB(cfg MongoDBConfig) (*MongoDBStorage, error) {
 	createIndexes(db)
 
 	return &MongoDBStorage{
-		client:   client,
-		database: db,
+		client:      client,
+		database:    db,
+		taskResults: DefaultTaskResultConfig(),
 	}, nil
 }
 
@@ -71,18 +77,31 @@ func createIndexes(db *mongo.Database) {
 		{Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "status", Value: 1}}},
 		{Keys: bson.D{{Key: "created_at", Value: -1}}},
 	})
+
+	// Task results collection; each result carries its own expiry, so a
+	// changed retention applies without rebuilding the TTL index
+	taskResultsCol := db.Collection("task_results")
+	taskResultsCol.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "task_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "agent_id", Value: 1}, {Key: "completed_at", Value: -1}}},
+		{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+	})
 }
 
 // Get retrieves a value from storage
 func (m *MongoDBStorage) Get(key string) (interface{}, error) {
 	ctx := context.Background()
 	
-	var result bson.M
-	err := m.database.Collection("agents").FindOne(ctx, bson.M{"_id": key}).Decode(&result)
+	// Values live in the same collection Set and List use
+	var doc bson.M
+	err := m.database.Collection("data").FindOne(ctx, bson.M{"_id": key}).Decode(&doc)
 	if err == mongo.ErrNoDocuments {
 		return nil, ErrNotFound
 	}
-	return result, err
+	if err != nil {
+		return nil, err
+	}
+	return doc["value"], nil
 }
 
 // Set stores a value in storage
@@ -162,6 +181,292 @@ func (m *MongoDBStorage) SaveHeartbeat(agentID string, heartbeat interface{}) er
 	return err
 }
 
+// GetHeartbeats retrieves heartbeats for an agent within a time range, ordered by timestamp.
+// When more than limit samples fall into the range they are averaged into limit buckets
+// by the database, so only the buckets are read.
+func (m *MongoDBStorage) GetHeartbeats(agentID string, from, to time.Time, limit int) ([]HeartbeatPoint, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	timeRange := bson.M{}
+	if !from.IsZero() {
+		timeRange["$gte"] = from
+	}
+	if !to.IsZero() {
+		timeRange["$lte"] = to
+	}
+
+	// Served by the agent_id+timestamp compound index
+	filter := bson.M{"agent_id": agentID}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	if limit > 0 {
+		span, err := m.heartbeatSpan(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		if span.Count > limit {
+			start, width := heartbeatBuckets(from, to, span.First, span.Last, limit)
+			return m.bucketHeartbeats(ctx, filter, start, width, limit)
+		}
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetProjection(bson.M{"timestamp": 1, "heartbeat": 1})
+
+	cursor, err := m.database.Collection("heartbeats").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	points := []HeartbeatPoint{}
+	for cursor.Next(ctx) {
+		var doc struct {
+			Timestamp time.Time `bson:"timestamp"`
+			Heartbeat bson.M    `bson:"heartbeat"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			continue
+		}
+		points = append(points, HeartbeatPoint{
+			Timestamp: doc.Timestamp,
+			Samples:   1,
+			Metrics:   map[string]interface{}(doc.Heartbeat),
+		})
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return points, nil
+}
+
+// heartbeatSpan is the number of heartbeats matching a query and when the
+// first and last were taken
+type heartbeatSpan struct {
+	Count int       `bson:"count"`
+	First time.Time `bson:"first"`
+	Last  time.Time `bson:"last"`
+}
+
+// heartbeatSpan counts the heartbeats matching filter
+func (m *MongoDBStorage) heartbeatSpan(ctx context.Context, filter bson.M) (heartbeatSpan, error) {
+	var span heartbeatSpan
+	cursor, err := m.database.Collection("heartbeats").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"first": bson.M{"$min": "$timestamp"},
+			"last":  bson.M{"$max": "$timestamp"},
+		}}},
+	})
+	if err != nil {
+		return span, err
+	}
+	defer cursor.Close(ctx)
+
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&span); err != nil {
+			return span, err
+		}
+	}
+	return span, cursor.Err()
+}
+
+// bucketHeartbeats averages the heartbeats matching filter into limit
+// buckets of width from start. Numeric metrics are averaged; other metrics
+// keep the bucket's last value.
+func (m *MongoDBStorage) bucketHeartbeats(ctx context.Context, filter bson.M, start time.Time, width time.Duration, limit int) ([]HeartbeatPoint, error) {
+	// Subtracting dates gives milliseconds; the last bucket also takes
+	// samples at the very end of the range
+	bucket := bson.M{"$min": bson.A{
+		bson.M{"$floor": bson.M{"$divide": bson.A{
+			bson.M{"$subtract": bson.A{"$timestamp", start}},
+			float64(width) / float64(time.Millisecond),
+		}}},
+		limit - 1,
+	}}
+
+	cursor, err := m.database.Collection("heartbeats").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$sort", Value: bson.D{{Key: "timestamp", Value: 1}}}},
+		{{Key: "$project", Value: bson.M{
+			"bucket":  bucket,
+			"metrics": bson.M{"$objectToArray": bson.M{"$ifNull": bson.A{"$heartbeat", bson.M{}}}},
+		}}},
+		{{Key: "$facet", Value: bson.M{
+			"samples": bson.A{
+				bson.M{"$group": bson.M{"_id": "$bucket", "samples": bson.M{"$sum": 1}}},
+			},
+			"metrics": bson.A{
+				bson.M{"$unwind": "$metrics"},
+				// $avg skips non-numeric values and is null without any
+				bson.M{"$group": bson.M{
+					"_id":  bson.M{"bucket": "$bucket", "key": "$metrics.k"},
+					"avg":  bson.M{"$avg": "$metrics.v"},
+					"last": bson.M{"$last": "$metrics.v"},
+				}},
+				bson.M{"$group": bson.M{
+					"_id": "$_id.bucket",
+					"metrics": bson.M{"$push": bson.M{
+						"k": "$_id.key",
+						"v": bson.M{"$ifNull": bson.A{"$avg", "$last"}},
+					}},
+				}},
+				bson.M{"$project": bson.M{"metrics": bson.M{"$arrayToObject": "$metrics"}}},
+			},
+		}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result struct {
+		Samples []struct {
+			Bucket  int64 `bson:"_id"`
+			Samples int   `bson:"samples"`
+		} `bson:"samples"`
+		Metrics []struct {
+			Bucket  int64  `bson:"_id"`
+			Metrics bson.M `bson:"metrics"`
+		} `bson:"metrics"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	metrics := make(map[int64]bson.M, len(result.Metrics))
+	for _, bucket := range result.Metrics {
+		metrics[bucket.Bucket] = bucket.Metrics
+	}
+
+	points := make([]HeartbeatPoint, 0, len(result.Samples))
+	for _, bucket := range result.Samples {
+		values := metrics[bucket.Bucket]
+		if values == nil {
+			values = bson.M{}
+		}
+		points = append(points, HeartbeatPoint{
+			Timestamp: start.Add(time.Duration(bucket.Bucket) * width),
+			Samples:   bucket.Samples,
+			Metrics:   map[string]interface{}(values),
+		})
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].Timestamp.Before(points[j].Timestamp) })
+
+	return points, nil
+}
+
+// SetTaskResultConfig sets the output cap of task results and how long
+// those saved from now on are kept
+func (m *MongoDBStorage) SetTaskResultConfig(cfg TaskResultConfig) {
+	m.taskResults = cfg
+}
+
+// SaveTaskResult saves the result of a finished task, truncating its output
+// to the output cap and expiring it after the task result retention
+func (m *MongoDBStorage) SaveTaskResult(record *TaskResultRecord) error {
+	ctx := context.Background()
+	record = withOutputCap(record, m.taskResults.MaxOutputBytes)
+
+	_, err := m.database.Collection("task_results").UpdateOne(
+		ctx,
+		bson.M{"task_id": record.TaskID},
+		bson.M{"$set": bson.M{
+			"agent_id":     record.AgentID,
+			"type":         record.Type,
+			"status":       record.Status,
+			"success":      record.Success,
+			"output":       record.Output,
+			"error":        record.Error,
+			"output_bytes": record.OutputBytes,
+			"truncated":    record.Truncated,
+			"completed_at": record.CompletedAt,
+			"expires_at":   record.CompletedAt.Add(m.taskResults.Retention),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// GetTaskResult retrieves the stored result of a task
+func (m *MongoDBStorage) GetTaskResult(taskID string) (*TaskResultRecord, error) {
+	ctx := context.Background()
+
+	var record TaskResultRecord
+	err := m.database.Collection("task_results").FindOne(ctx, bson.M{"task_id": taskID}).Decode(&record)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// DeleteHeartbeatsBefore removes heartbeat samples taken before cutoff. The
+// TTL index on the heartbeats collection still removes samples after 7 days
+// whatever the cutoff.
+func (m *MongoDBStorage) DeleteHeartbeatsBefore(cutoff time.Time) (int64, error) {
+	result, err := m.database.Collection("heartbeats").DeleteMany(context.Background(),
+		bson.M{"timestamp": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// DeleteTaskResultsBefore removes task results completed before cutoff,
+// ahead of the expiry they were saved with
+func (m *MongoDBStorage) DeleteTaskResultsBefore(cutoff time.Time) (int64, error) {
+	result, err := m.database.Collection("task_results").DeleteMany(context.Background(),
+		bson.M{"completed_at": bson.M{"$lt": cutoff}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+// TryLock takes a lock held as a document of the locks collection, which
+// another owner may take over once it has expired
+func (m *MongoDBStorage) TryLock(name, owner string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	_, err := m.database.Collection("locks").UpdateOne(
+		context.Background(),
+		bson.M{"_id": name, "$or": bson.A{
+			bson.M{"owner": owner},
+			bson.M{"expires_at": bson.M{"$lt": now}},
+		}},
+		bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(ttl)}},
+		options.Update().SetUpsert(true),
+	)
+	// Held by another owner: the filter misses and the upsert collides
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock releases the named lock if owner holds it
+func (m *MongoDBStorage) Unlock(name, owner string) error {
+	_, err := m.database.Collection("locks").DeleteOne(context.Background(), bson.M{"_id": name, "owner": owner})
+	return err
+}
+
 // GetAgents retrieves all agents
 func (m *MongoDBStorage) GetAgents(filter interface{}) ([]interface{}, error) {
 	ctx := context.Background()
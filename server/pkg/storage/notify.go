@@ -0,0 +1,25 @@
+// Package storage provides notifications between the servers sharing a
+// storage backend.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import "errors"
+
+// ErrNoNotify is returned by wrapping storages whose backend can't deliver
+// messages to the other servers sharing it
+var ErrNoNotify = errors.New("storage does not support notifications")
+
+// Notifier is implemented by storage backends that can deliver messages to
+// every server sharing them, e.g. to tell the others a shared record changed.
+// Delivery is best effort: servers not subscribed when a message is published
+// never receive it.
+type Notifier interface {
+	// Publish sends a message to the subscribers of channel on all servers
+	Publish(channel string, message []byte) error
+
+	// Subscribe calls handler with each message published to channel, in
+	// order, until the returned function is called
+	Subscribe(channel string, handler func(message []byte)) (func(), error)
+}
@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"time"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
@@ -12,6 +15,15 @@ import (
 // PostgresStorage implements Storage using PostgreSQL
 type PostgresStorage struct {
 	db *sql.DB
+
+	// taskResults caps stored output and sets how long RunCleanup keeps
+	// task results
+	taskResults TaskResultConfig
+
+	// locks holds the connection each advisory lock taken by TryLock was
+	// taken on, since PostgreSQL releases it when that session ends
+	locksMu sync.Mutex
+	locks   map[string]*sql.Conn
 }
 
 // NewPostgres creates a new PostgreSQL storage instance
@@ -29,7 +41,7 @@ func NewPostgres(cfg PostgresConfig) (*PostgresStorage, error) {
 		return nil, err
 	}
 
-	storage := &PostgresStorage{db: db}
+	storage := &PostgresStorage{db: db, taskResults: DefaultTaskResultConfig(), locks: make(map[string]*sql.Conn)}
 	
 	// Create tables
 	if err := storage.createTables(); err != nil {
@@ -88,6 +100,23 @@ func (p *PostgresStorage) createTables() error {
 	CREATE INDEX IF NOT EXISTS idx_tasks_agent_status ON tasks(agent_id, status);
 	CREATE INDEX IF NOT EXISTS idx_tasks_created ON tasks(created_at DESC);
 
+	-- Task results, pruned by cleanup_old_task_results
+	CREATE TABLE IF NOT EXISTS task_results (
+		task_id VARCHAR(255) PRIMARY KEY,
+		agent_id VARCHAR(255) NOT NULL,
+		type VARCHAR(50),
+		status VARCHAR(50),
+		success BOOLEAN,
+		output TEXT,
+		error TEXT,
+		output_bytes INTEGER,
+		truncated BOOLEAN DEFAULT FALSE,
+		completed_at TIMESTAMP DEFAULT NOW()
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_task_results_agent ON task_results(agent_id, completed_at DESC);
+	CREATE INDEX IF NOT EXISTS idx_task_results_completed ON task_results(completed_at);
+
 	-- Retention policy (cleanup old data)
 	CREATE OR REPLACE FUNCTION cleanup_old_heartbeats()
 	RETURNS void AS $$
@@ -95,6 +124,13 @@ func (p *PostgresStorage) createTables() error {
 		DELETE FROM heartbeats WHERE timestamp < NOW() - INTERVAL '7 days';
 	END;
 	$$ LANGUAGE plpgsql;
+
+	CREATE OR REPLACE FUNCTION cleanup_old_task_results(retention INTERVAL)
+	RETURNS void AS $$
+	BEGIN
+		DELETE FROM task_results WHERE completed_at < NOW() - retention;
+	END;
+	$$ LANGUAGE plpgsql;
 	`
 
 	_, err := p.db.Exec(query)
@@ -262,14 +298,138 @@ func (p *PostgresStorage) GetAgents(filter map[string]interface{}) ([]interface{
 	return results, nil
 }
 
+// SetTaskResultConfig sets the output cap of task results and how long
+// RunCleanup keeps them
+func (p *PostgresStorage) SetTaskResultConfig(cfg TaskResultConfig) {
+	p.taskResults = cfg
+}
+
+// SaveTaskResult saves the result of a finished task, truncating its output
+// to the output cap
+func (p *PostgresStorage) SaveTaskResult(record *TaskResultRecord) error {
+	record = withOutputCap(record, p.taskResults.MaxOutputBytes)
+
+	query := `
+		INSERT INTO task_results (task_id, agent_id, type, status, success, output, error, output_bytes, truncated, completed_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (task_id)
+		DO UPDATE SET
+			status = EXCLUDED.status,
+			success = EXCLUDED.success,
+			output = EXCLUDED.output,
+			error = EXCLUDED.error,
+			output_bytes = EXCLUDED.output_bytes,
+			truncated = EXCLUDED.truncated,
+			completed_at = EXCLUDED.completed_at
+	`
+	_, err := p.db.Exec(query, record.TaskID, record.AgentID, record.Type, record.Status, record.Success,
+		record.Output, record.Error, record.OutputBytes, record.Truncated, record.CompletedAt)
+	return err
+}
+
+// GetTaskResult retrieves the stored result of a task
+func (p *PostgresStorage) GetTaskResult(taskID string) (*TaskResultRecord, error) {
+	query := `
+		SELECT task_id, agent_id, type, status, success, output, error, output_bytes, truncated, completed_at
+		FROM task_results WHERE task_id = $1
+	`
+	var record TaskResultRecord
+	err := p.db.QueryRow(query, taskID).Scan(&record.TaskID, &record.AgentID, &record.Type, &record.Status,
+		&record.Success, &record.Output, &record.Error, &record.OutputBytes, &record.Truncated, &record.CompletedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
 // Close closes the PostgreSQL connection
 func (p *PostgresStorage) Close() error {
 	return p.db.Close()
 }
 
-// RunCleanup runs cleanup tasks (e.g., old heartbeats)
+// RunCleanup runs cleanup tasks (e.g., old heartbeats and task results)
 func (p *PostgresStorage) RunCleanup() error {
 	ctx := context.Background()
-	_, err := p.db.ExecContext(ctx, "SELECT cleanup_old_heartbeats()")
+	if _, err := p.db.ExecContext(ctx, "SELECT cleanup_old_heartbeats()"); err != nil {
+		return err
+	}
+
+	retention := fmt.Sprintf("%d seconds", int64(p.taskResults.Retention/time.Second))
+	_, err := p.db.ExecContext(ctx, "SELECT cleanup_old_task_results($1::interval)", retention)
 	return err
 }
+
+// DeleteHeartbeatsBefore removes heartbeat samples taken before cutoff
+func (p *PostgresStorage) DeleteHeartbeatsBefore(cutoff time.Time) (int64, error) {
+	result, err := p.db.Exec("DELETE FROM heartbeats WHERE timestamp < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteTaskResultsBefore removes task results completed before cutoff
+func (p *PostgresStorage) DeleteTaskResultsBefore(cutoff time.Time) (int64, error) {
+	result, err := p.db.Exec("DELETE FROM task_results WHERE completed_at < $1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// TryLock takes a session-level advisory lock on a connection of its own.
+// ttl is not needed: PostgreSQL releases the lock if the server holding it
+// dies and its session ends.
+func (p *PostgresStorage) TryLock(name, owner string, ttl time.Duration) (bool, error) {
+	p.locksMu.Lock()
+	defer p.locksMu.Unlock()
+
+	if _, held := p.locks[name]; held {
+		return false, nil
+	}
+
+	ctx := context.Background()
+	conn, err := p.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", lockKey(name)).Scan(&acquired); err != nil {
+		discardConn(conn)
+		return false, err
+	}
+	if !acquired {
+		conn.Close()
+		return false, nil
+	}
+	p.locks[name] = conn
+	return true, nil
+}
+
+// Unlock releases an advisory lock taken by TryLock and returns its
+// connection to the pool
+func (p *PostgresStorage) Unlock(name, owner string) error {
+	p.locksMu.Lock()
+	conn, held := p.locks[name]
+	delete(p.locks, name)
+	p.locksMu.Unlock()
+	if !held {
+		return nil
+	}
+
+	if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey(name)); err != nil {
+		discardConn(conn)
+		return err
+	}
+	return conn.Close()
+}
+
+// discardConn closes a connection instead of returning it to the pool, so
+// an advisory lock possibly left on its session is released with it
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+	conn.Close()
+}
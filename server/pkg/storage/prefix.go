@@ -0,0 +1,29 @@
+// Package storage provides listing the values stored under a key prefix.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import "strings"
+
+// PrefixLister is implemented by storage backends that can list the keys
+// with a prefix without reading every stored value
+type PrefixLister interface {
+	ListPrefix(prefix string) (map[string]interface{}, error)
+}
+
+// ListPrefix returns the key-value pairs whose key starts with prefix,
+// through the backend's PrefixLister if it has one and List otherwise
+func ListPrefix(store Storage, prefix string) (map[string]interface{}, error) {
+	if lister, ok := store.(PrefixLister); ok {
+		return lister.ListPrefix(prefix)
+	}
+
+	result := make(map[string]interface{})
+	for key, value := range store.List() {
+		if strings.HasPrefix(key, prefix) {
+			result[key] = value
+		}
+	}
+	return result, nil
+}
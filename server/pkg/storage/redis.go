@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -92,6 +94,29 @@ func (r *RedisStorage) List() map[string]interface{} {
 	return result
 }
 
+// ListPrefix returns the key-value pairs whose key starts with prefix,
+// scanning only the matching keys
+func (r *RedisStorage) ListPrefix(prefix string) (map[string]interface{}, error) {
+	ctx := context.Background()
+
+	result := make(map[string]interface{})
+	iter := r.client.Scan(ctx, 0, prefix+"*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		val, err := r.client.Get(ctx, key).Result()
+		if err != nil {
+			continue
+		}
+
+		var data interface{}
+		if err := json.Unmarshal([]byte(val), &data); err != nil {
+			continue
+		}
+		result[key] = data
+	}
+	return result, iter.Err()
+}
+
 // SaveAgent saves agent information
 func (r *RedisStorage) SaveAgent(agent interface{}) error {
 	ctx := context.Background()
@@ -125,6 +150,98 @@ func (r *RedisStorage) SaveHeartbeat(agentID string, heartbeat interface{}) erro
 	return r.client.Set(ctx, key, data, time.Hour).Err()
 }
 
+// DeleteHeartbeatsBefore removes heartbeat samples taken before cutoff,
+// ahead of their one hour TTL
+func (r *RedisStorage) DeleteHeartbeatsBefore(cutoff time.Time) (int64, error) {
+	ctx := context.Background()
+
+	var deleted int64
+	iter := r.client.Scan(ctx, 0, "heartbeat:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		// Keys are heartbeat:<agent>:<unix seconds>
+		taken, err := strconv.ParseInt(key[strings.LastIndex(key, ":")+1:], 10, 64)
+		if err != nil || !time.Unix(taken, 0).Before(cutoff) {
+			continue
+		}
+		n, err := r.client.Del(ctx, key).Result()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, iter.Err()
+}
+
+// DeleteTaskResultsBefore removes nothing: Redis keeps no task results
+func (r *RedisStorage) DeleteTaskResultsBefore(cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+// lockPrefix prefixes the keys of locks taken by TryLock
+const lockPrefix = "lock:"
+
+// renewLock extends a lock its owner holds already
+var renewLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseLock deletes a lock if its owner holds it
+var releaseLock = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// TryLock takes a lock held as a key that expires after ttl
+func (r *RedisStorage) TryLock(name, owner string, ttl time.Duration) (bool, error) {
+	ctx := context.Background()
+	key := lockPrefix + name
+
+	acquired, err := r.client.SetNX(ctx, key, owner, ttl).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+	renewed, err := renewLock.Run(ctx, r.client, []string{key}, owner, ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// Unlock releases the named lock if owner holds it
+func (r *RedisStorage) Unlock(name, owner string) error {
+	return releaseLock.Run(context.Background(), r.client, []string{lockPrefix + name}, owner).Err()
+}
+
+// Publish sends a message to the subscribers of a Redis pub/sub channel
+func (r *RedisStorage) Publish(channel string, message []byte) error {
+	return r.client.Publish(context.Background(), channel, message).Err()
+}
+
+// Subscribe calls handler with each message published to a Redis pub/sub
+// channel. The subscription reconnects on its own if the connection drops;
+// messages published meanwhile are lost.
+func (r *RedisStorage) Subscribe(channel string, handler func(message []byte)) (func(), error) {
+	ctx := context.Background()
+	sub := r.client.Subscribe(ctx, channel)
+	// Wait for the confirmation, so messages published from now on arrive
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+
+	messages := sub.Channel()
+	go func() {
+		for msg := range messages {
+			handler([]byte(msg.Payload))
+		}
+	}()
+	return func() { sub.Close() }, nil
+}
+
 // GetAgents retrieves all agents
 func (r *RedisStorage) GetAgents(filter interface{}) ([]interface{}, error) {
 	ctx := context.Background()
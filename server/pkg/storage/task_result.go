@@ -0,0 +1,105 @@
+// Package storage provides task result persistence types, output size caps
+// and retention settings.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultTaskOutputMaxBytes caps the output kept per task result
+	DefaultTaskOutputMaxBytes = 64 * 1024
+
+	// DefaultTaskResultRetention is how long task results are kept
+	DefaultTaskResultRetention = 30 * 24 * time.Hour
+)
+
+// ErrNoTaskResults is returned by wrapping storages whose backend does not
+// keep task results
+var ErrNoTaskResults = errors.New("storage does not keep task results")
+
+// TaskResultConfig holds the output cap and retention of task results
+type TaskResultConfig struct {
+	// MaxOutputBytes caps the output kept per result; longer output is
+	// truncated with a marker
+	MaxOutputBytes int `yaml:"max_output_bytes"`
+
+	// Retention is how long results are kept after the task finished
+	Retention time.Duration `yaml:"retention"`
+}
+
+// DefaultTaskResultConfig returns the default output cap and retention
+func DefaultTaskResultConfig() TaskResultConfig {
+	return TaskResultConfig{
+		MaxOutputBytes: DefaultTaskOutputMaxBytes,
+		Retention:      DefaultTaskResultRetention,
+	}
+}
+
+// Validate checks the output cap and retention
+func (c *TaskResultConfig) Validate() error {
+	if c.MaxOutputBytes <= 0 {
+		return fmt.Errorf("task output cap must be positive")
+	}
+	if c.Retention <= 0 {
+		return fmt.Errorf("task result retention must be positive")
+	}
+	return nil
+}
+
+// TaskResultRecord is the stored result of a finished task
+type TaskResultRecord struct {
+	TaskID  string `json:"task_id" bson:"task_id"`
+	AgentID string `json:"agent_id" bson:"agent_id"`
+	Type    string `json:"type" bson:"type"`
+	Status  string `json:"status" bson:"status"`
+	Success bool   `json:"success" bson:"success"`
+	Output  string `json:"output,omitempty" bson:"output,omitempty"`
+	Error   string `json:"error,omitempty" bson:"error,omitempty"`
+
+	// OutputBytes is the size of the output as reported, before truncation
+	OutputBytes int  `json:"output_bytes" bson:"output_bytes"`
+	Truncated   bool `json:"truncated,omitempty" bson:"truncated,omitempty"`
+
+	CompletedAt time.Time `json:"completed_at" bson:"completed_at"`
+}
+
+// TaskResultStore is implemented by storage backends that keep task results
+type TaskResultStore interface {
+	SaveTaskResult(record *TaskResultRecord) error
+	GetTaskResult(taskID string) (*TaskResultRecord, error)
+}
+
+// withOutputCap returns the record with its output truncated to maxBytes,
+// copying it rather than changing the caller's record
+func withOutputCap(record *TaskResultRecord, maxBytes int) *TaskResultRecord {
+	output, truncated := TruncateOutput(record.Output, maxBytes)
+	if !truncated {
+		return record
+	}
+	capped := *record
+	capped.Output = output
+	capped.Truncated = true
+	return &capped
+}
+
+// TruncateOutput keeps at most maxBytes of output, cut before a UTF-8
+// character, and appends a marker with the full size. It reports whether
+// output was truncated.
+func TruncateOutput(output string, maxBytes int) (string, bool) {
+	if maxBytes <= 0 || len(output) <= maxBytes {
+		return output, false
+	}
+
+	keep := maxBytes
+	for keep > 0 && !utf8.RuneStart(output[keep]) {
+		keep--
+	}
+	return output[:keep] + fmt.Sprintf("\n... [output truncated, %d bytes total]", len(output)), true
+}
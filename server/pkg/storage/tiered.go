@@ -0,0 +1,195 @@
+// Package storage provides a tiered storage that caches a durable primary
+// backend in a fast cache backend.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// TieredStorage writes through to a durable primary backend and keeps a copy
+// in a fast cache backend. The primary is authoritative: reads are served from
+// the cache and fall back to the primary on a miss or cache error, and a cache
+// entry that may disagree with the primary is dropped rather than kept.
+type TieredStorage struct {
+	cache   Storage
+	primary Storage
+}
+
+// NewTiered creates a tiered storage over a cache and a primary backend
+func NewTiered(cache, primary Storage) *TieredStorage {
+	return &TieredStorage{cache: cache, primary: primary}
+}
+
+// Get retrieves a value from the cache, falling back to the primary and
+// filling the cache on a miss
+func (t *TieredStorage) Get(key string) (interface{}, error) {
+	value, err := t.cache.Get(key)
+	if err == nil {
+		return value, nil
+	}
+
+	value, err = t.primary.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed fill only costs a later primary read
+	t.cache.Set(key, value)
+	return value, nil
+}
+
+// Set stores a value in the primary, then in the cache. If the cache can't be
+// updated its stale entry is dropped so reads fall back to the primary.
+func (t *TieredStorage) Set(key string, value interface{}) error {
+	if err := t.primary.Set(key, value); err != nil {
+		return err
+	}
+
+	if err := t.cache.Set(key, value); err != nil {
+		if err := t.cache.Delete(key); err != nil {
+			return fmt.Errorf("stored in primary but cache may be stale: %v", err)
+		}
+	}
+	return nil
+}
+
+// Delete removes a value from the primary, then from the cache
+func (t *TieredStorage) Delete(key string) error {
+	if err := t.primary.Delete(key); err != nil {
+		return err
+	}
+
+	if err := t.cache.Delete(key); err != nil {
+		return fmt.Errorf("deleted from primary but cache may be stale: %v", err)
+	}
+	return nil
+}
+
+// List returns all key-value pairs from the primary, since the cache may
+// only hold part of them
+func (t *TieredStorage) List() map[string]interface{} {
+	return t.primary.List()
+}
+
+// ListPrefix returns the key-value pairs under a key prefix from the primary
+func (t *TieredStorage) ListPrefix(prefix string) (map[string]interface{}, error) {
+	return ListPrefix(t.primary, prefix)
+}
+
+// GetHeartbeats queries heartbeat history from the primary
+func (t *TieredStorage) GetHeartbeats(agentID string, from, to time.Time, limit int) ([]HeartbeatPoint, error) {
+	querier, ok := t.primary.(HeartbeatQuerier)
+	if !ok {
+		return nil, fmt.Errorf("primary storage does not keep heartbeat history")
+	}
+	return querier.GetHeartbeats(agentID, from, to, limit)
+}
+
+// SaveHeartbeat saves a heartbeat sample to the primary, if it keeps
+// heartbeat history
+func (t *TieredStorage) SaveHeartbeat(agentID string, heartbeat interface{}) error {
+	recorder, ok := t.primary.(HeartbeatRecorder)
+	if _, queryable := t.primary.(HeartbeatQuerier); !ok || !queryable {
+		return ErrNoHeartbeatHistory
+	}
+	return recorder.SaveHeartbeat(agentID, heartbeat)
+}
+
+// SaveTaskResult saves a task result to the primary
+func (t *TieredStorage) SaveTaskResult(record *TaskResultRecord) error {
+	results, ok := t.primary.(TaskResultStore)
+	if !ok {
+		return ErrNoTaskResults
+	}
+	return results.SaveTaskResult(record)
+}
+
+// GetTaskResult retrieves a task result from the primary
+func (t *TieredStorage) GetTaskResult(taskID string) (*TaskResultRecord, error) {
+	results, ok := t.primary.(TaskResultStore)
+	if !ok {
+		return nil, ErrNoTaskResults
+	}
+	return results.GetTaskResult(taskID)
+}
+
+// DeleteHeartbeatsBefore removes old heartbeat samples from the primary
+func (t *TieredStorage) DeleteHeartbeatsBefore(cutoff time.Time) (int64, error) {
+	cleaner, ok := t.primary.(Cleaner)
+	if !ok {
+		return 0, ErrNoCleanup
+	}
+	return cleaner.DeleteHeartbeatsBefore(cutoff)
+}
+
+// DeleteTaskResultsBefore removes old task results from the primary
+func (t *TieredStorage) DeleteTaskResultsBefore(cutoff time.Time) (int64, error) {
+	cleaner, ok := t.primary.(Cleaner)
+	if !ok {
+		return 0, ErrNoCleanup
+	}
+	return cleaner.DeleteTaskResultsBefore(cutoff)
+}
+
+// TryLock takes a lock from the primary, which all servers share
+func (t *TieredStorage) TryLock(name, owner string, ttl time.Duration) (bool, error) {
+	locker, ok := t.primary.(Locker)
+	if !ok {
+		return false, ErrNoLocks
+	}
+	return locker.TryLock(name, owner, ttl)
+}
+
+// Unlock releases a lock taken from the primary
+func (t *TieredStorage) Unlock(name, owner string) error {
+	locker, ok := t.primary.(Locker)
+	if !ok {
+		return ErrNoLocks
+	}
+	return locker.Unlock(name, owner)
+}
+
+// Publish sends a message through whichever backend supports it, the
+// primary first; all servers sharing the storage pick the same one
+func (t *TieredStorage) Publish(channel string, message []byte) error {
+	notifier, ok := t.notifier()
+	if !ok {
+		return ErrNoNotify
+	}
+	return notifier.Publish(channel, message)
+}
+
+// Subscribe subscribes to messages through the backend Publish uses
+func (t *TieredStorage) Subscribe(channel string, handler func(message []byte)) (func(), error) {
+	notifier, ok := t.notifier()
+	if !ok {
+		return nil, ErrNoNotify
+	}
+	return notifier.Subscribe(channel, handler)
+}
+
+// notifier returns the backend messages go through
+func (t *TieredStorage) notifier() (Notifier, bool) {
+	if notifier, ok := t.primary.(Notifier); ok {
+		return notifier, true
+	}
+	notifier, ok := t.cache.(Notifier)
+	return notifier, ok
+}
+
+// Close closes both backends
+func (t *TieredStorage) Close() error {
+	var firstErr error
+	for _, backend := range []Storage{t.cache, t.primary} {
+		if closer, ok := backend.(interface{ Close() error }); ok {
+			if err := closer.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
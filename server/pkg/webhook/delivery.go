@@ -0,0 +1,191 @@
+// Package webhook provides signed delivery of lifecycle events with retries,
+// and the dead-letter log of events that could not be delivered.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// deliveryTimeout bounds a single delivery attempt
+	deliveryTimeout = 10 * time.Second
+
+	// deliveryAttempts is the number of attempts before an event is
+	// dead-lettered
+	deliveryAttempts = 5
+
+	// deliveryBackoff is the delay before the first retry, doubled after
+	// each attempt
+	deliveryBackoff = 2 * time.Second
+
+	// deadLettersKey is the storage key of the dead-letter log
+	deadLettersKey = "webhook_dead_letters"
+
+	// maxDeadLetters caps the dead-letter log
+	maxDeadLetters = 100
+)
+
+// Headers sent with each delivery
+const (
+	HeaderEvent     = "X-Nerve-Event"
+	HeaderDelivery  = "X-Nerve-Delivery"
+	HeaderTimestamp = "X-Nerve-Timestamp"
+	HeaderSignature = "X-Nerve-Signature"
+)
+
+// DeadLetter records an event a webhook persistently failed to receive
+type DeadLetter struct {
+	WebhookID string    `json:"webhook_id"`
+	URL       string    `json:"url"`
+	Event     Event     `json:"event"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// Sign returns the signature of a delivery: the hex HMAC-SHA256, keyed by
+// the webhook secret, of the timestamp header, a dot and the body
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver posts an event to a webhook, retrying network errors, rate
+// limiting and server errors with exponential backoff. It returns the
+// number of attempts made.
+func (m *Manager) deliver(w *Webhook, event Event) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	backoff := m.backoff
+	var lastErr error
+	attempt := 0
+	for attempt < m.attempts {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		attempt++
+
+		retry, err := m.send(w, event, body)
+		if err == nil {
+			return attempt, nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return attempt, lastErr
+}
+
+// send makes one delivery attempt and reports whether a failure is retryable
+func (m *Manager) send(w *Webhook, event Event, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	// Signed afresh per attempt, so receivers can reject stale timestamps
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, event.Type)
+	req.Header.Set(HeaderDelivery, event.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(w.Secret, timestamp, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("webhook returned %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, err
+}
+
+// deadLetter records an event that could not be delivered, keeping the
+// latest maxDeadLetters, and persists the log
+func (m *Manager) deadLetter(w *Webhook, event Event, attempts int, err error) {
+	m.logger.Errorf("Webhook %s: giving up on %s event %s after %d attempts: %v",
+		w.ID, event.Type, event.ID, attempts, err)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deadLetters = append(m.deadLetters, DeadLetter{
+		WebhookID: w.ID,
+		URL:       w.URL,
+		Event:     event,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		FailedAt:  time.Now(),
+	})
+	if len(m.deadLetters) > maxDeadLetters {
+		m.deadLetters = append([]DeadLetter{}, m.deadLetters[len(m.deadLetters)-maxDeadLetters:]...)
+	}
+
+	if m.store != nil {
+		data, err := json.Marshal(m.deadLetters)
+		if err == nil {
+			err = m.store.Set(deadLettersKey, string(data))
+		}
+		if err != nil {
+			m.logger.Errorf("Failed to persist webhook dead letters: %v", err)
+		}
+	}
+}
+
+// DeadLetters returns the events webhooks failed to receive, oldest first,
+// optionally only those of one webhook
+func (m *Manager) DeadLetters(webhookID string) []DeadLetter {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	letters := []DeadLetter{}
+	for _, letter := range m.deadLetters {
+		if webhookID == "" || letter.WebhookID == webhookID {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+// loadDeadLettersLocked restores the persisted dead-letter log; caller must
+// hold m.mu
+func (m *Manager) loadDeadLettersLocked() {
+	value, err := m.store.Get(deadLettersKey)
+	if err != nil {
+		return
+	}
+	data, ok := value.(string)
+	if !ok {
+		return
+	}
+	if err := json.Unmarshal([]byte(data), &m.deadLetters); err != nil {
+		m.logger.Errorf("Invalid stored webhook dead letters: %v", err)
+	}
+}
@@ -0,0 +1,354 @@
+// Package webhook provides outbound webhooks fired on agent lifecycle events,
+// for integrating external systems such as a CMDB or ticketing.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/storage"
+)
+
+// Agent lifecycle event types webhooks subscribe to
+const (
+	EventAgentRegistered     = "agent_registered"
+	EventAgentOnline         = "agent_online"
+	EventAgentOffline        = "agent_offline"
+	EventAgentDecommissioned = "agent_decommissioned"
+	EventAgentRemoved        = "agent_removed"
+)
+
+// EventTypes lists the lifecycle event types
+var EventTypes = []string{
+	EventAgentRegistered,
+	EventAgentOnline,
+	EventAgentOffline,
+	EventAgentDecommissioned,
+	EventAgentRemoved,
+}
+
+const (
+	// webhookKeyPrefix prefixes the storage keys of webhooks
+	webhookKeyPrefix = "webhook:"
+
+	// queueSize bounds the events waiting for delivery per webhook
+	queueSize = 1000
+)
+
+// Webhook posts signed lifecycle events of the subscribed types to a URL
+type Webhook struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+
+	// Secret signs each delivery; it is generated if not set, and only
+	// returned when the webhook is created
+	Secret string   `json:"secret,omitempty"`
+	Events []string `json:"events"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Validate checks the URL and event types of a webhook
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an http or https URL")
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("events must list at least one of: %s", strings.Join(EventTypes, ", "))
+	}
+	for _, event := range w.Events {
+		if !contains(EventTypes, event) {
+			return fmt.Errorf("unknown event type %q, must be one of: %s", event, strings.Join(EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// Subscribes reports whether the webhook subscribes to an event type
+func (w *Webhook) Subscribes(eventType string) bool {
+	return contains(w.Events, eventType)
+}
+
+// redacted returns a copy of the webhook without its secret
+func (w *Webhook) redacted() *Webhook {
+	copied := *w
+	copied.Secret = ""
+	copied.Events = append([]string{}, w.Events...)
+	return &copied
+}
+
+// Event is the JSON payload of a delivery
+type Event struct {
+	// ID identifies the event, so receivers can drop redeliveries
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	AgentID   string                 `json:"agent_id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// Manager stores webhooks and delivers events to them, each webhook in
+// order from its own queue
+type Manager struct {
+	mu       sync.RWMutex
+	store    storage.Storage
+	logger   log.Logger
+	client   *http.Client
+	webhooks map[string]*Webhook
+	queues   map[string]chan Event
+
+	// Delivery retry policy, see deliver
+	attempts int
+	backoff  time.Duration
+
+	deadLetters []DeadLetter
+}
+
+// NewManager creates a webhook manager, restoring webhooks and dead letters
+// persisted in store
+func NewManager(store storage.Storage, logger log.Logger) *Manager {
+	m := &Manager{
+		store:    store,
+		logger:   logger,
+		client:   &http.Client{Timeout: deliveryTimeout},
+		webhooks: make(map[string]*Webhook),
+		queues:   make(map[string]chan Event),
+		attempts: deliveryAttempts,
+		backoff:  deliveryBackoff,
+	}
+	m.load()
+	return m
+}
+
+// Create adds a webhook, generating its ID and secret unless set
+func (m *Manager) Create(w *Webhook) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		w.Secret = randomHex(32)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w.ID == "" {
+		w.ID = "webhook-" + randomHex(8)
+	}
+	if _, exists := m.webhooks[w.ID]; exists {
+		return fmt.Errorf("webhook %s already exists", w.ID)
+	}
+	w.CreatedAt = time.Now()
+	w.UpdatedAt = w.CreatedAt
+
+	if err := m.saveLocked(w); err != nil {
+		return err
+	}
+	m.startLocked(w)
+	return nil
+}
+
+// Get returns a webhook without its secret
+func (m *Manager) Get(id string) (*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	w, ok := m.webhooks[id]
+	if !ok {
+		return nil, fmt.Errorf("webhook %s not found", id)
+	}
+	return w.redacted(), nil
+}
+
+// List returns all webhooks without their secrets
+func (m *Manager) List() []*Webhook {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhooks := make([]*Webhook, 0, len(m.webhooks))
+	for _, w := range m.webhooks {
+		webhooks = append(webhooks, w.redacted())
+	}
+	return webhooks
+}
+
+// Update replaces the URL, name and events of a webhook, and its secret if
+// set. Queued events are delivered with the new settings.
+func (m *Manager) Update(id string, updated *Webhook) error {
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.webhooks[id]
+	if !ok {
+		return fmt.Errorf("webhook %s not found", id)
+	}
+	updated.ID = id
+	updated.CreatedAt = existing.CreatedAt
+	updated.UpdatedAt = time.Now()
+	if updated.Secret == "" {
+		updated.Secret = existing.Secret
+	}
+
+	if err := m.saveLocked(updated); err != nil {
+		return err
+	}
+	m.webhooks[id] = updated
+	return nil
+}
+
+// Delete removes a webhook, dropping its queued events
+func (m *Manager) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.webhooks[id]; !ok {
+		return fmt.Errorf("webhook %s not found", id)
+	}
+	if m.store != nil {
+		if err := m.store.Delete(webhookKeyPrefix + id); err != nil {
+			return fmt.Errorf("failed to delete webhook: %v", err)
+		}
+	}
+	delete(m.webhooks, id)
+	close(m.queues[id])
+	delete(m.queues, id)
+	return nil
+}
+
+// Publish queues a lifecycle event for the webhooks subscribing to its type.
+// It never blocks; events for a webhook whose queue is full are dead-lettered.
+func (m *Manager) Publish(eventType, agentID string, data map[string]interface{}) {
+	event := Event{
+		ID:        randomHex(16),
+		Type:      eventType,
+		AgentID:   agentID,
+		Timestamp: time.Now().UTC(),
+		Data:      data,
+	}
+
+	var full []*Webhook
+	m.mu.RLock()
+	for id, w := range m.webhooks {
+		if !w.Subscribes(eventType) {
+			continue
+		}
+		select {
+		case m.queues[id] <- event:
+		default:
+			full = append(full, w.redacted())
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, w := range full {
+		m.deadLetter(w, event, 0, fmt.Errorf("delivery queue full"))
+	}
+}
+
+// startLocked starts delivering events to a webhook; caller must hold m.mu
+func (m *Manager) startLocked(w *Webhook) {
+	queue := make(chan Event, queueSize)
+	m.webhooks[w.ID] = w
+	m.queues[w.ID] = queue
+	go m.deliverQueue(w.ID, queue)
+}
+
+// deliverQueue delivers the events queued for a webhook in order until the
+// webhook is deleted
+func (m *Manager) deliverQueue(id string, queue <-chan Event) {
+	for event := range queue {
+		m.mu.RLock()
+		w, ok := m.webhooks[id]
+		m.mu.RUnlock()
+		if !ok {
+			continue
+		}
+
+		if attempts, err := m.deliver(w, event); err != nil {
+			m.deadLetter(w.redacted(), event, attempts, err)
+		}
+	}
+}
+
+// saveLocked persists a webhook; caller must hold m.mu
+func (m *Manager) saveLocked(w *Webhook) error {
+	if m.store == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	if err := m.store.Set(webhookKeyPrefix+w.ID, string(data)); err != nil {
+		return fmt.Errorf("failed to persist webhook: %v", err)
+	}
+	return nil
+}
+
+// load restores persisted webhooks and dead letters
+func (m *Manager) load() {
+	if m.store == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, value := range m.store.List() {
+		if !strings.HasPrefix(key, webhookKeyPrefix) {
+			continue
+		}
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var w Webhook
+		if err := json.Unmarshal([]byte(data), &w); err != nil {
+			m.logger.Errorf("Invalid stored webhook %s: %v", key, err)
+			continue
+		}
+		m.startLocked(&w)
+	}
+	m.loadDeadLettersLocked()
+
+	if len(m.webhooks) > 0 {
+		m.logger.Infof("Loaded %d webhooks", len(m.webhooks))
+	}
+}
+
+// randomHex returns n random bytes, hex encoded
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// contains reports whether list contains value
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
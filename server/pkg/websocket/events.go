@@ -0,0 +1,170 @@
+// Package websocket provides the event stream pushed to UI observers.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Event types pushed to UI observers
+const (
+	EventAgentOnline   = "agent_online"
+	EventAgentOffline  = "agent_offline"
+	EventAgentRemoved  = "agent_removed"
+	EventAlert         = "alert"
+	EventTaskCompleted = "task_completed"
+)
+
+// ObserverOptions are the checks applied to the requests of an observer
+type ObserverOptions struct {
+	// AuthorizeLogTail is asked before each log tail the observer starts,
+	// e.g. to check its token still grants it; nil allows every tail
+	AuthorizeLogTail func(agentID, path string) error
+
+	// OnLogTail is told of each log tail the observer asks for, e.g. for
+	// the audit log
+	OnLogTail func(record LogTailRecord)
+}
+
+// HandleEventStream upgrades a UI observer connection. Observers receive
+// events of the types listed in ?events= (all types if omitted) and never
+// agent broadcasts or control messages.
+func (ws *WebSocketManager) HandleEventStream(c *gin.Context) {
+	ws.HandleEventStreamWith(c, ObserverOptions{})
+}
+
+// HandleEventStreamWith upgrades a UI observer connection whose requests
+// are checked with options, see HandleEventStream
+func (ws *WebSocketManager) HandleEventStreamWith(c *gin.Context, options ObserverOptions) {
+	conn, err := ws.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		fmt.Printf("WebSocket upgrade error: %v\n", err)
+		return
+	}
+
+	clientID := c.Query("client_id")
+	if clientID == "" {
+		clientID = fmt.Sprintf("ui-%d", time.Now().UnixNano())
+	}
+
+	client := &Client{
+		ID:       clientID,
+		Conn:     conn,
+		Send:     make(chan []byte, 256),
+		LastPing: time.Now(),
+		Observer: true,
+		events:   parseEventTypes(c.Query("events")),
+		options:  options,
+	}
+
+	ws.register <- client
+
+	go client.writePump()
+	go client.readPump(ws)
+}
+
+// parseEventTypes returns the set of event types in a comma-separated list
+func parseEventTypes(list string) map[string]bool {
+	events := make(map[string]bool)
+	for _, eventType := range strings.Split(list, ",") {
+		if eventType = strings.TrimSpace(eventType); eventType != "" {
+			events[eventType] = true
+		}
+	}
+	return events
+}
+
+// handleObserverMessage handles subscriptions and log tail requests from observers
+func (ws *WebSocketManager) handleObserverMessage(client *Client, message []byte) {
+	var msg WebSocketMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return
+	}
+	switch msg.Type {
+	case "subscribe":
+		ws.subscribe(client, &msg)
+	case MessageLogTail:
+		ws.startLogTail(client, &msg)
+	case MessageLogTailStop:
+		ws.handleLogTailStop(client, &msg)
+	}
+}
+
+// subscribe replaces the event types an observer receives, e.g.
+// {"type": "subscribe", "data": {"events": ["alert"]}}
+func (ws *WebSocketManager) subscribe(client *Client, msg *WebSocketMessage) {
+	var types []string
+	if list, ok := msg.Data["events"].([]interface{}); ok {
+		for _, eventType := range list {
+			if s, ok := eventType.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+
+	ws.mu.Lock()
+	client.events = parseEventTypes(strings.Join(types, ","))
+	ws.mu.Unlock()
+}
+
+// SetEventRelay passes each published event on to relay as well, e.g. for
+// the observers connected to the other servers behind a load balancer
+func (ws *WebSocketManager) SetEventRelay(relay func(eventType, agentID string, data map[string]interface{})) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.eventRelay = relay
+}
+
+// PublishEvent pushes an event to the observers subscribed to its type, and
+// to the event relay if one is set. Observers too slow to keep up miss
+// events rather than block the caller.
+func (ws *WebSocketManager) PublishEvent(eventType, agentID string, data map[string]interface{}) {
+	ws.DeliverEvent(eventType, agentID, data)
+
+	ws.mu.RLock()
+	relay := ws.eventRelay
+	ws.mu.RUnlock()
+	if relay != nil {
+		relay(eventType, agentID, data)
+	}
+}
+
+// DeliverEvent pushes an event to the observers of this server only, e.g.
+// one relayed from another server
+func (ws *WebSocketManager) DeliverEvent(eventType, agentID string, data map[string]interface{}) {
+	message, err := NewWebSocketMessage(eventType, agentID, data).ToJSON()
+	if err != nil {
+		return
+	}
+
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	for id, client := range ws.observers {
+		if len(client.events) > 0 && !client.events[eventType] {
+			continue
+		}
+		select {
+		case client.Send <- message:
+			if ws.metrics != nil {
+				ws.metrics.RecordWebSocketMessage("out")
+			}
+		default:
+			fmt.Printf("Dropping %s event for slow observer %s\n", eventType, id)
+		}
+	}
+}
+
+// removeObserverLocked drops an observer and stops its write pump; caller must hold ws.mu
+func (ws *WebSocketManager) removeObserverLocked(client *Client) {
+	delete(ws.observers, client.ID)
+	close(client.Send)
+}
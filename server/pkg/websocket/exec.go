@@ -0,0 +1,413 @@
+// Package websocket provides interactive exec sessions, relaying a shell on a
+// PTY of an agent to an operator's WebSocket connection.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package websocket
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Exec message types. The operator's client sends exec_input and
+// exec_resize; the server asks the agent to start and stop the shell with
+// exec_start and exec_stop, and relays the agent's exec_output and final
+// exec_end to the client. Input and output are base64 in data.data.
+const (
+	MessageExecStart   = "exec_start"
+	MessageExecStarted = "exec_started"
+	MessageExecInput   = "exec_input"
+	MessageExecResize  = "exec_resize"
+	MessageExecStop    = "exec_stop"
+	MessageExecOutput  = "exec_output"
+	MessageExecEnd     = "exec_end"
+)
+
+const (
+	// DefaultExecIdleTimeout is how long a session may go without input or
+	// output before it is ended
+	DefaultExecIdleTimeout = 10 * time.Minute
+
+	// DefaultMaxExecSessions caps the sessions open on this server at once
+	DefaultMaxExecSessions = 4
+
+	// maxExecClientMessageSize caps the messages read from exec clients
+	maxExecClientMessageSize = 16 << 10
+
+	// execClientBuffer is how many messages are queued for a client before
+	// the session is ended as too slow
+	execClientBuffer = 256
+)
+
+var (
+	// ErrExecDisabled is returned when exec sessions are not enabled
+	ErrExecDisabled = errors.New("interactive exec is disabled on this server")
+
+	// ErrExecLimit is returned when the session cap is reached
+	ErrExecLimit = errors.New("too many exec sessions")
+)
+
+// ExecRequest describes the session an operator opens on an agent
+type ExecRequest struct {
+	AgentID string
+	Shell   string
+	Cols    int
+	Rows    int
+}
+
+// ExecSummary reports a session once it has ended, for auditing
+type ExecSummary struct {
+	ID          string
+	AgentID     string
+	Shell       string
+	StartedAt   time.Time
+	Duration    time.Duration
+	Reason      string
+	Error       string
+	ExitCode    *int
+	InputBytes  int64
+	OutputBytes int64
+}
+
+// execSession is a shell running on an agent on behalf of a client
+type execSession struct {
+	id      string
+	agentID string
+	shell   string
+	started time.Time
+	client  *websocket.Conn
+	send    chan []byte
+	done    chan struct{}
+	idle    *time.Timer
+	onEnd   func(ExecSummary)
+
+	// Bytes of input and output, counted atomically
+	input  int64
+	output int64
+}
+
+// EnableExec allows exec sessions, ending each after idleTimeout without
+// input or output and allowing at most maxSessions at once. Without it
+// sessions are refused with ErrExecDisabled.
+func (ws *WebSocketManager) EnableExec(idleTimeout time.Duration, maxSessions int) {
+	ws.execMu.Lock()
+	defer ws.execMu.Unlock()
+
+	ws.execIdle = idleTimeout
+	ws.execMax = maxSessions
+}
+
+// ExecEnabled reports whether exec sessions are allowed
+func (ws *WebSocketManager) ExecEnabled() bool {
+	ws.execMu.Lock()
+	defer ws.execMu.Unlock()
+
+	return ws.execMax > 0
+}
+
+// HandleExecSession upgrades c to an exec session with a shell on the agent.
+// It returns ErrExecDisabled or ErrExecLimit without upgrading if the
+// session can't be opened; later failures are sent to the client as
+// exec_end. onStart and onEnd, if set, are called with the session ID once
+// the agent has been asked to start the shell and once the session has ended.
+func (ws *WebSocketManager) HandleExecSession(c *gin.Context, req ExecRequest, onStart func(id string), onEnd func(ExecSummary)) error {
+	ws.execMu.Lock()
+	idleTimeout, max, open := ws.execIdle, ws.execMax, len(ws.execs)
+	ws.execMu.Unlock()
+	if max <= 0 {
+		return ErrExecDisabled
+	}
+	if open >= max {
+		return fmt.Errorf("%w: at most %d may be open at once", ErrExecLimit, max)
+	}
+
+	conn, err := ws.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// The upgrader has answered the request
+		fmt.Printf("WebSocket upgrade error: %v\n", err)
+		return nil
+	}
+	session := &execSession{
+		id:      fmt.Sprintf("exec-%d", time.Now().UnixNano()),
+		agentID: req.AgentID,
+		shell:   req.Shell,
+		started: time.Now(),
+		client:  conn,
+		send:    make(chan []byte, execClientBuffer),
+		done:    make(chan struct{}),
+		onEnd:   onEnd,
+	}
+	session.idle = time.AfterFunc(idleTimeout, func() {
+		ws.endExecSession(session.id, "idle timeout", "", nil, true)
+	})
+	go session.writePump()
+
+	// Sessions opened concurrently may have taken the last slots
+	ws.execMu.Lock()
+	if max = ws.execMax; len(ws.execs) >= max {
+		ws.execMu.Unlock()
+		session.idle.Stop()
+		session.queue(MessageExecEnd, req.AgentID, map[string]interface{}{
+			"id":     session.id,
+			"reason": "error",
+			"error":  fmt.Sprintf("%v: at most %d may be open at once", ErrExecLimit, max),
+		})
+		close(session.done)
+		return nil
+	}
+	ws.execs[session.id] = session
+	ws.execMu.Unlock()
+
+	if onStart != nil {
+		onStart(session.id)
+	}
+	start, err := NewWebSocketMessage(MessageExecStart, req.AgentID, map[string]interface{}{
+		"id":           session.id,
+		"shell":        req.Shell,
+		"cols":         req.Cols,
+		"rows":         req.Rows,
+		"idle_timeout": int(idleTimeout / time.Second),
+	}).ToJSON()
+	// The output arrives over the agent's control channel, so only an agent
+	// connected to this server can run the shell
+	if err != nil || !ws.DeliverToAgent(req.AgentID, start) {
+		ws.endExecSession(session.id, "error", "agent is not connected", nil, false)
+		return nil
+	}
+	session.queue(MessageExecStarted, req.AgentID, map[string]interface{}{
+		"id":           session.id,
+		"shell":        req.Shell,
+		"idle_timeout": int(idleTimeout / time.Second),
+	})
+
+	go ws.readExecClient(session, idleTimeout)
+	return nil
+}
+
+// readExecClient forwards input and resizes from the client to the agent
+// until the client disconnects
+func (ws *WebSocketManager) readExecClient(session *execSession, idleTimeout time.Duration) {
+	conn := session.client
+	conn.SetReadLimit(maxExecClientMessageSize)
+	conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		var msg WebSocketMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			ws.endExecSession(session.id, "client disconnected", "", nil, true)
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+
+		data := map[string]interface{}{"id": session.id}
+		switch msg.Type {
+		case MessageExecInput:
+			encoded, _ := msg.Data["data"].(string)
+			input, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil || len(input) == 0 {
+				continue
+			}
+			atomic.AddInt64(&session.input, int64(len(input)))
+			session.idle.Reset(idleTimeout)
+			data["data"] = encoded
+		case MessageExecResize:
+			cols, _ := msg.Data["cols"].(float64)
+			rows, _ := msg.Data["rows"].(float64)
+			if cols <= 0 || rows <= 0 {
+				continue
+			}
+			data["cols"], data["rows"] = int(cols), int(rows)
+		default:
+			continue
+		}
+
+		request, err := NewWebSocketMessage(msg.Type, session.agentID, data).ToJSON()
+		if err != nil {
+			continue
+		}
+		if !ws.DeliverToAgent(session.agentID, request) {
+			ws.endExecSession(session.id, "error", "agent is not connected", nil, false)
+			return
+		}
+	}
+}
+
+// relayExec forwards exec_output and exec_end messages from an agent to the
+// client of the session. Messages for sessions the agent doesn't run are dropped.
+func (ws *WebSocketManager) relayExec(client *Client, msg *WebSocketMessage) {
+	id, _ := msg.Data["id"].(string)
+
+	ws.execMu.Lock()
+	session, ok := ws.execs[id]
+	idleTimeout := ws.execIdle
+	ws.execMu.Unlock()
+	if !ok || session.agentID != client.AgentID {
+		return
+	}
+
+	if msg.Type == MessageExecEnd {
+		reason, _ := msg.Data["reason"].(string)
+		errMsg, _ := msg.Data["error"].(string)
+		var exitCode *int
+		if code, ok := msg.Data["exit_code"].(float64); ok {
+			c := int(code)
+			exitCode = &c
+		}
+		ws.endExecSession(id, reason, errMsg, exitCode, false)
+		return
+	}
+
+	encoded, _ := msg.Data["data"].(string)
+	if output, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+		atomic.AddInt64(&session.output, int64(len(output)))
+	}
+	session.idle.Reset(idleTimeout)
+	if !session.queue(MessageExecOutput, session.agentID, map[string]interface{}{"id": id, "data": encoded}) {
+		ws.endExecSession(id, "error", "client too slow", nil, true)
+	}
+}
+
+// endExecSessions ends the sessions matching a filter, e.g. when their
+// agent disconnects; must be called without ws.mu held
+func (ws *WebSocketManager) endExecSessions(match func(session *execSession) bool, reason string) {
+	ws.execMu.Lock()
+	var ids []string
+	for id, session := range ws.execs {
+		if match(session) {
+			ids = append(ids, id)
+		}
+	}
+	ws.execMu.Unlock()
+
+	for _, id := range ids {
+		ws.endExecSession(id, reason, "", nil, false)
+	}
+}
+
+// endExecSession ends a session, telling the agent to stop the shell if
+// notifyAgent and the client why, then closes the client connection
+func (ws *WebSocketManager) endExecSession(id, reason, errMsg string, exitCode *int, notifyAgent bool) {
+	session := ws.removeExecSession(id)
+	if session == nil {
+		return
+	}
+
+	if notifyAgent {
+		if stop, err := NewWebSocketMessage(MessageExecStop, session.agentID, map[string]interface{}{"id": id}).ToJSON(); err == nil {
+			ws.DeliverToAgent(session.agentID, stop)
+		}
+	}
+
+	end := map[string]interface{}{"id": id, "reason": reason}
+	if errMsg != "" {
+		end["error"] = errMsg
+	}
+	if exitCode != nil {
+		end["exit_code"] = *exitCode
+	}
+	session.queue(MessageExecEnd, session.agentID, end)
+	close(session.done)
+
+	if session.onEnd != nil {
+		session.onEnd(ExecSummary{
+			ID:          id,
+			AgentID:     session.agentID,
+			Shell:       session.shell,
+			StartedAt:   session.started,
+			Duration:    time.Since(session.started),
+			Reason:      reason,
+			Error:       errMsg,
+			ExitCode:    exitCode,
+			InputBytes:  atomic.LoadInt64(&session.input),
+			OutputBytes: atomic.LoadInt64(&session.output),
+		})
+	}
+}
+
+// removeExecSession forgets a session and returns it, or nil if it already ended
+func (ws *WebSocketManager) removeExecSession(id string) *execSession {
+	ws.execMu.Lock()
+	defer ws.execMu.Unlock()
+
+	session, ok := ws.execs[id]
+	if !ok {
+		return nil
+	}
+	delete(ws.execs, id)
+	if session.idle != nil {
+		session.idle.Stop()
+	}
+	return session
+}
+
+// queue queues a message for the client, reporting false if the client
+// can't keep up. Messages queued after the session ended are dropped.
+func (s *execSession) queue(msgType, agentID string, data map[string]interface{}) bool {
+	message, err := NewWebSocketMessage(msgType, agentID, data).ToJSON()
+	if err != nil {
+		return true
+	}
+	select {
+	case <-s.done:
+		return true
+	default:
+	}
+	select {
+	case s.send <- message:
+		return true
+	default:
+		return false
+	}
+}
+
+// writePump writes queued messages to the client and pings it, closing the
+// connection once the session has ended and its messages are written
+func (s *execSession) writePump() {
+	ticker := time.NewTicker(54 * time.Second)
+	defer func() {
+		ticker.Stop()
+		s.client.Close()
+	}()
+
+	write := func(message []byte) bool {
+		s.client.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return s.client.WriteMessage(websocket.TextMessage, message) == nil
+	}
+	for {
+		select {
+		case message := <-s.send:
+			if !write(message) {
+				return
+			}
+		case <-s.done:
+			for {
+				select {
+				case message := <-s.send:
+					if !write(message) {
+						return
+					}
+				default:
+					s.client.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+					return
+				}
+			}
+		case <-ticker.C:
+			s.client.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := s.client.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
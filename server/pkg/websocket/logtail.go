@@ -0,0 +1,279 @@
+// Package websocket provides live tailing of agent log files for UI observers.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package websocket
+
+import (
+	"fmt"
+	"time"
+)
+
+// Log tail message types. Observers send log_tail and log_tail_stop; the
+// agent streams log_line messages and a final log_tail_end, which are
+// relayed to the observer that started the tail.
+const (
+	MessageLogTail        = "log_tail"
+	MessageLogTailStop    = "log_tail_stop"
+	MessageLogTailStarted = "log_tail_started"
+	MessageLogLine        = "log_line"
+	MessageLogTailEnd     = "log_tail_end"
+)
+
+const (
+	// DefaultLogTailTimeout is how long a tail runs unless the observer asks for less
+	DefaultLogTailTimeout = 10 * time.Minute
+
+	// DefaultLogTailRate is the lines per second an agent may send for one tail
+	DefaultLogTailRate = 50
+
+	// logTailGrace is how long past its timeout the server waits for the
+	// agent to end a tail before ending it itself
+	logTailGrace = 30 * time.Second
+)
+
+// Log tail request results, see LogTailRecord
+const (
+	LogTailStarted = "started"
+	LogTailDenied  = "denied"
+	LogTailFailed  = "failed"
+)
+
+// LogTailRecord describes a log tail an observer asked for; ID is set for
+// tails that started and Error for those that didn't
+type LogTailRecord struct {
+	ID         string
+	ObserverID string
+	AgentID    string
+	Path       string
+	Timeout    time.Duration
+	Result     string
+	Error      string
+}
+
+// logTail is a tail running on an agent on behalf of an observer
+type logTail struct {
+	id       string
+	agentID  string
+	observer *Client
+	timer    *time.Timer
+}
+
+// SetLogTailLimits sets the longest a tail may run and the lines per second
+// agents may send for it
+func (ws *WebSocketManager) SetLogTailLimits(timeout time.Duration, rate int) {
+	ws.tailsMu.Lock()
+	defer ws.tailsMu.Unlock()
+
+	ws.tailTimeout = timeout
+	ws.tailRate = rate
+}
+
+// SetLogTailResolver sets the lookup of whether an agent supports log tailing
+func (ws *WebSocketManager) SetLogTailResolver(resolver func(agentID string) bool) {
+	ws.tailsMu.Lock()
+	defer ws.tailsMu.Unlock()
+
+	ws.tailSupported = resolver
+}
+
+// startLogTail asks an agent to tail a file for an observer, e.g.
+// {"type": "log_tail", "agent_id": "node-01", "data": {"path": "/var/log/syslog", "timeout": 300}}
+func (ws *WebSocketManager) startLogTail(observer *Client, msg *WebSocketMessage) {
+	path, _ := msg.Data["path"].(string)
+	if msg.AgentID == "" || path == "" {
+		ws.endLogTailForObserver(observer, "", msg.AgentID, "agent_id and data.path are required")
+		return
+	}
+	record := LogTailRecord{ObserverID: observer.ID, AgentID: msg.AgentID, Path: path}
+	refuse := func(result, errMsg string) {
+		record.Result, record.Error = result, errMsg
+		recordLogTail(observer, record)
+		ws.endLogTailForObserver(observer, record.ID, msg.AgentID, errMsg)
+	}
+
+	if authorize := observer.options.AuthorizeLogTail; authorize != nil {
+		if err := authorize(msg.AgentID, path); err != nil {
+			refuse(LogTailDenied, err.Error())
+			return
+		}
+	}
+
+	ws.tailsMu.Lock()
+	timeout := ws.tailTimeout
+	if requested, ok := msg.Data["timeout"].(float64); ok && requested > 0 && time.Duration(requested)*time.Second < timeout {
+		timeout = time.Duration(requested) * time.Second
+	}
+	rate := ws.tailRate
+	supported := ws.tailSupported == nil || ws.tailSupported(msg.AgentID)
+	ws.tailsMu.Unlock()
+
+	record.Timeout = timeout
+	if !supported {
+		refuse(LogTailFailed, "agent does not support log tailing")
+		return
+	}
+
+	tail := &logTail{
+		id:       fmt.Sprintf("tail-%d", time.Now().UnixNano()),
+		agentID:  msg.AgentID,
+		observer: observer,
+	}
+	request, err := NewWebSocketMessage(MessageLogTail, msg.AgentID, map[string]interface{}{
+		"id":       tail.id,
+		"path":     path,
+		"timeout":  int(timeout / time.Second),
+		"max_rate": rate,
+	}).ToJSON()
+	if err != nil {
+		return
+	}
+
+	ws.tailsMu.Lock()
+	ws.tails[tail.id] = tail
+	tail.timer = time.AfterFunc(timeout+logTailGrace, func() {
+		ws.stopLogTail(tail.id, "timeout")
+	})
+	ws.tailsMu.Unlock()
+
+	// The lines arrive over the agent's control channel, so only an agent
+	// connected to this server can be tailed
+	record.ID = tail.id
+	if !ws.DeliverToAgent(msg.AgentID, request) {
+		ws.removeLogTail(tail.id)
+		refuse(LogTailFailed, "agent is not connected")
+		return
+	}
+	record.Result = LogTailStarted
+	recordLogTail(observer, record)
+
+	ws.sendToObserver(observer, MessageLogTailStarted, msg.AgentID, map[string]interface{}{
+		"id":      tail.id,
+		"path":    path,
+		"timeout": int(timeout / time.Second),
+	})
+}
+
+// recordLogTail tells the observer's OnLogTail of a tail it asked for
+func recordLogTail(observer *Client, record LogTailRecord) {
+	if observer.options.OnLogTail != nil {
+		observer.options.OnLogTail(record)
+	}
+}
+
+// handleLogTailStop stops a tail at the request of the observer that started it
+func (ws *WebSocketManager) handleLogTailStop(observer *Client, msg *WebSocketMessage) {
+	id, _ := msg.Data["id"].(string)
+
+	ws.tailsMu.Lock()
+	tail, ok := ws.tails[id]
+	ws.tailsMu.Unlock()
+
+	if ok && tail.observer == observer {
+		ws.stopLogTail(id, "stopped")
+	}
+}
+
+// stopLogTail ends a tail, telling the agent to stop and the observer why
+func (ws *WebSocketManager) stopLogTail(id, reason string) {
+	tail := ws.removeLogTail(id)
+	if tail == nil {
+		return
+	}
+
+	if stop, err := NewWebSocketMessage(MessageLogTailStop, tail.agentID, map[string]interface{}{"id": id}).ToJSON(); err == nil {
+		ws.DeliverToAgent(tail.agentID, stop)
+	}
+	ws.sendToObserver(tail.observer, MessageLogTailEnd, tail.agentID, map[string]interface{}{
+		"id":     id,
+		"reason": reason,
+	})
+}
+
+// relayLogTail forwards log_line and log_tail_end messages from an agent to
+// the observer of the tail. Messages for tails the agent doesn't run are dropped.
+func (ws *WebSocketManager) relayLogTail(client *Client, msg *WebSocketMessage) {
+	id, _ := msg.Data["id"].(string)
+
+	ws.tailsMu.Lock()
+	tail, ok := ws.tails[id]
+	if ok && tail.agentID != client.AgentID {
+		ok = false
+	}
+	ws.tailsMu.Unlock()
+	if !ok {
+		return
+	}
+
+	if msg.Type == MessageLogTailEnd {
+		ws.removeLogTail(id)
+	}
+	ws.sendToObserver(tail.observer, msg.Type, tail.agentID, msg.Data)
+}
+
+// endLogTails ends the tails matching a filter, e.g. when their observer or
+// agent disconnects; must be called without ws.mu held
+func (ws *WebSocketManager) endLogTails(match func(tail *logTail) bool, reason string) {
+	ws.tailsMu.Lock()
+	var ids []string
+	for id, tail := range ws.tails {
+		if match(tail) {
+			ids = append(ids, id)
+		}
+	}
+	ws.tailsMu.Unlock()
+
+	for _, id := range ids {
+		ws.stopLogTail(id, reason)
+	}
+}
+
+// removeLogTail forgets a tail and returns it, or nil if it already ended
+func (ws *WebSocketManager) removeLogTail(id string) *logTail {
+	ws.tailsMu.Lock()
+	defer ws.tailsMu.Unlock()
+
+	tail, ok := ws.tails[id]
+	if !ok {
+		return nil
+	}
+	delete(ws.tails, id)
+	if tail.timer != nil {
+		tail.timer.Stop()
+	}
+	return tail
+}
+
+// endLogTailForObserver tells an observer that a tail ended or never started
+func (ws *WebSocketManager) endLogTailForObserver(observer *Client, id, agentID, errMsg string) {
+	ws.sendToObserver(observer, MessageLogTailEnd, agentID, map[string]interface{}{
+		"id":     id,
+		"reason": "error",
+		"error":  errMsg,
+	})
+}
+
+// sendToObserver queues a message for one observer if it is still
+// connected, dropping it if the observer can't keep up
+func (ws *WebSocketManager) sendToObserver(observer *Client, msgType, agentID string, data map[string]interface{}) {
+	message, err := NewWebSocketMessage(msgType, agentID, data).ToJSON()
+	if err != nil {
+		return
+	}
+
+	// Holding ws.mu keeps the observer's Send channel from being closed
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	if ws.observers[observer.ID] != observer {
+		return
+	}
+	select {
+	case observer.Send <- message:
+		if ws.metrics != nil {
+			ws.metrics.RecordWebSocketMessage("out")
+		}
+	default:
+		fmt.Printf("Dropping %s message for slow observer %s\n", msgType, observer.ID)
+	}
+}
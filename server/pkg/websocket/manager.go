@@ -5,24 +5,82 @@
 package websocket
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/nerve/server/pkg/metrics"
 )
 
 // WebSocketManager manages WebSocket connections
 type WebSocketManager struct {
 	upgrader websocket.Upgrader
 	clients  map[string]*websocket.Conn
+	agents   map[string]string // agent ID -> client ID
+	agentTokens map[string]string // agent ID -> token its connection was opened with
+	mu       sync.RWMutex
 	register chan *Client
 	unregister chan *Client
 	broadcast chan []byte
+	metrics  *metrics.MetricsCollector
+
+	// Handlers for typed messages and agent connections, see HandleMessageType
+	handlers     map[string]MessageHandler
+	onConnect    []func(agentID string)
+	onDisconnect []func(agentID string)
+	onAlive      []func(agentID string)
+
+	// Membership lookups for scoped broadcasts, see BroadcastToCluster
+	clusterAgents  func(clusterID string) ([]string, error)
+	selectorAgents func(labels map[string]string) ([]string, error)
+
+	// UI observers receiving pushed events, see HandleEventStream
+	observers map[string]*Client
+
+	// Log tails running on agents for observers, see startLogTail
+	tailsMu       sync.Mutex
+	tails         map[string]*logTail
+	tailTimeout   time.Duration
+	tailRate      int
+	tailSupported func(agentID string) bool
+
+	// Exec sessions running on agents for operators, see HandleExecSession
+	execMu   sync.Mutex
+	execs    map[string]*execSession
+	execIdle time.Duration
+	execMax  int
+
+	// Recent logs requests waiting for agents' replies, see FetchRecentLogs
+	recentLogsMu sync.Mutex
+	recentLogs   map[string]*recentLogsRequest
+
+	// Passes events on to other servers' observers, see SetEventRelay
+	eventRelay func(eventType, agentID string, data map[string]interface{})
+
+	// Sends messages to agents connected to other servers, see SetAgentForwarder
+	forwardToAgent func(agentID string, message []byte) bool
+
+	// Origins of pages besides the server's own that may connect, see SetAllowedOrigins
+	allowedOrigins map[string]bool
 }
 
+const (
+	// maxObserverMessageSize and maxAgentMessageSize cap the messages read
+	// from observers and agents; agents send log lines
+	maxObserverMessageSize = 512
+	maxAgentMessageSize    = 16 << 10
+)
+
+// MessageHandler processes a typed message received from a client
+type MessageHandler func(client *Client, msg *WebSocketMessage)
+
 // Client represents a WebSocket client
 type Client struct {
 	ID       string
@@ -30,20 +88,122 @@ type Client struct {
 	Send     chan []byte
 	AgentID  string
 	LastPing time.Time
+
+	// Observer marks a UI client receiving events instead of control
+	// messages; events lists its subscribed event types, empty for all
+	Observer bool
+	events   map[string]bool
+
+	// authToken is the bearer token the connection was opened with
+	authToken string
+
+	// options are the checks applied to an observer's requests
+	options ObserverOptions
 }
 
 // NewWebSocketManager creates a new WebSocket manager
-func NewWebSocketManager() *WebSocketManager {
-	return &WebSocketManager{
-		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins in development
-			},
-		},
-		clients:    make(map[string]*websocket.Conn),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		broadcast:  make(chan []byte),
+func NewWebSocketManager(metricsCollector *metrics.MetricsCollector) *WebSocketManager {
+	ws := &WebSocketManager{
+		clients:     make(map[string]*websocket.Conn),
+		agents:      make(map[string]string),
+		agentTokens: make(map[string]string),
+		register:    make(chan *Client),
+		unregister:  make(chan *Client),
+		broadcast:   make(chan []byte),
+		metrics:     metricsCollector,
+		handlers:    make(map[string]MessageHandler),
+		observers:   make(map[string]*Client),
+
+		tails:       make(map[string]*logTail),
+		tailTimeout: DefaultLogTailTimeout,
+		tailRate:    DefaultLogTailRate,
+
+		execs: make(map[string]*execSession),
+
+		recentLogs: make(map[string]*recentLogsRequest),
+	}
+	ws.upgrader.CheckOrigin = ws.checkOrigin
+	ws.handlers[MessageLogLine] = ws.relayLogTail
+	ws.handlers[MessageLogTailEnd] = ws.relayLogTail
+	ws.handlers[MessageExecOutput] = ws.relayExec
+	ws.handlers[MessageExecEnd] = ws.relayExec
+	ws.handlers[MessageRecentLogsResult] = ws.collectRecentLogs
+	return ws
+}
+
+// SetAllowedOrigins sets the origins, e.g. https://ops.example.com, of the
+// pages besides the server's own that may open WebSockets; "*" allows all
+func (ws *WebSocketManager) SetAllowedOrigins(origins []string) {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		if origin = normalizeOrigin(origin); origin != "" {
+			allowed[origin] = true
+		}
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.allowedOrigins = allowed
+}
+
+// checkOrigin allows connections without an Origin header, which browsers
+// always send, e.g. from agents, and those from the server's own pages and
+// the allowed origins
+func (ws *WebSocketManager) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+		return true
+	}
+
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	return ws.allowedOrigins["*"] || ws.allowedOrigins[normalizeOrigin(origin)]
+}
+
+// normalizeOrigin lowercases an origin and drops a trailing slash
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// HandleMessageType registers a handler for messages of the given type.
+// Handlers must be registered before Run is started.
+func (ws *WebSocketManager) HandleMessageType(msgType string, handler MessageHandler) {
+	ws.handlers[msgType] = handler
+}
+
+// OnAgentConnect registers a callback invoked when an agent connects.
+// Callbacks must be registered before Run is started.
+func (ws *WebSocketManager) OnAgentConnect(callback func(agentID string)) {
+	ws.onConnect = append(ws.onConnect, callback)
+}
+
+// OnAgentDisconnect registers a callback invoked when an agent's last
+// control channel to this server closes. Callbacks must be registered
+// before Run is started.
+func (ws *WebSocketManager) OnAgentDisconnect(callback func(agentID string)) {
+	ws.onDisconnect = append(ws.onDisconnect, callback)
+}
+
+// OnAgentAlive registers a callback invoked whenever an agent's connection
+// shows liveness: on connect, on every pong and on every message received.
+// Callbacks must be registered before Run is started.
+func (ws *WebSocketManager) OnAgentAlive(callback func(agentID string)) {
+	ws.onAlive = append(ws.onAlive, callback)
+}
+
+// agentAlive records liveness of an agent connection
+func (ws *WebSocketManager) agentAlive(client *Client) {
+	client.LastPing = time.Now()
+	if client.AgentID == "" {
+		return
+	}
+	for _, callback := range ws.onAlive {
+		callback(client.AgentID)
 	}
 }
 
@@ -52,25 +212,96 @@ func (ws *WebSocketManager) Run() {
 	for {
 		select {
 		case client := <-ws.register:
-			ws.clients[client.ID] = client.Conn
+			ws.mu.Lock()
+			if client.Observer {
+				ws.observers[client.ID] = client
+			} else {
+				ws.clients[client.ID] = client.Conn
+				if client.AgentID != "" {
+					ws.agents[client.AgentID] = client.ID
+					ws.agentTokens[client.AgentID] = client.authToken
+				}
+			}
+			ws.mu.Unlock()
+			if ws.metrics != nil {
+				ws.metrics.RecordWebSocketConnect()
+			}
 			fmt.Printf("Client %s connected\n", client.ID)
+			if client.AgentID != "" {
+				for _, callback := range ws.onConnect {
+					go callback(client.AgentID)
+				}
+				for _, callback := range ws.onAlive {
+					callback(client.AgentID)
+				}
+			}
 
 		case client := <-ws.unregister:
-			if conn, ok := ws.clients[client.ID]; ok {
-				delete(ws.clients, client.ID)
+			ws.mu.Lock()
+			var conn *websocket.Conn
+			var ok bool
+			if client.Observer {
+				if ok = ws.observers[client.ID] == client; ok {
+					conn = client.Conn
+					ws.removeObserverLocked(client)
+				}
+			} else if conn, ok = ws.clients[client.ID]; ok {
+				ws.removeClientLocked(client.ID)
+			}
+			_, reconnected := ws.agents[client.AgentID]
+			ws.mu.Unlock()
+			if ok {
 				conn.Close()
+				if ws.metrics != nil {
+					ws.metrics.RecordWebSocketDisconnect()
+				}
 				fmt.Printf("Client %s disconnected\n", client.ID)
+
+				// Tails end with their observer or agent connection
+				go ws.endLogTails(func(tail *logTail) bool {
+					return tail.observer == client || !client.Observer && tail.agentID == client.AgentID
+				}, "disconnected")
+				if !client.Observer && client.AgentID != "" {
+					go ws.endExecSessions(func(session *execSession) bool {
+						return session.agentID == client.AgentID
+					}, "agent disconnected")
+					if !reconnected {
+						for _, callback := range ws.onDisconnect {
+							go callback(client.AgentID)
+						}
+					}
+				}
 			}
 
 		case message := <-ws.broadcast:
+			ws.mu.Lock()
 			for id, conn := range ws.clients {
 				err := conn.WriteMessage(websocket.TextMessage, message)
 				if err != nil {
 					fmt.Printf("Error sending message to client %s: %v\n", id, err)
 					conn.Close()
-					delete(ws.clients, id)
+					ws.removeClientLocked(id)
+					if ws.metrics != nil {
+						ws.metrics.RecordWebSocketDisconnect()
+					}
+					continue
+				}
+				if ws.metrics != nil {
+					ws.metrics.RecordWebSocketMessage("out")
 				}
 			}
+			ws.mu.Unlock()
+		}
+	}
+}
+
+// removeClientLocked drops a client and its agent index entry; caller must hold ws.mu
+func (ws *WebSocketManager) removeClientLocked(clientID string) {
+	delete(ws.clients, clientID)
+	for agentID, id := range ws.agents {
+		if id == clientID {
+			delete(ws.agents, agentID)
+			delete(ws.agentTokens, agentID)
 		}
 	}
 }
@@ -92,6 +323,8 @@ func (ws *WebSocketManager) HandleWebSocket(c *gin.Context) {
 		Send:     make(chan []byte, 256),
 		AgentID:  agentID,
 		LastPing: time.Now(),
+
+		authToken: strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "),
 	}
 
 	ws.register <- client
@@ -151,11 +384,15 @@ func (c *Client) readPump(ws *WebSocketManager) {
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(512)
+	if c.Observer {
+		c.Conn.SetReadLimit(maxObserverMessageSize)
+	} else {
+		c.Conn.SetReadLimit(maxAgentMessageSize)
+	}
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		c.LastPing = time.Now()
+		ws.agentAlive(c)
 		return nil
 	})
 
@@ -168,6 +405,9 @@ func (c *Client) readPump(ws *WebSocketManager) {
 			break
 		}
 
+		// Any message proves the connection is alive
+		ws.agentAlive(c)
+
 		// Handle incoming message
 		ws.handleMessage(c, message)
 	}
@@ -175,9 +415,29 @@ func (c *Client) readPump(ws *WebSocketManager) {
 
 // handleMessage processes incoming WebSocket messages
 func (ws *WebSocketManager) handleMessage(client *Client, message []byte) {
-	// TODO: Parse and handle different message types
+	if ws.metrics != nil {
+		ws.metrics.RecordWebSocketMessage("in")
+	}
+
+	if client.Observer {
+		ws.handleObserverMessage(client, message)
+		return
+	}
+
+	var msg WebSocketMessage
+	if err := json.Unmarshal(message, &msg); err == nil {
+		if handler, ok := ws.handlers[msg.Type]; ok {
+			if msg.AgentID == "" {
+				msg.AgentID = client.AgentID
+			}
+			handler(client, &msg)
+			return
+		}
+	}
+
 	fmt.Printf("Received message from client %s: %s\n", client.ID, string(message))
-	
+
+
 	// Echo back for now
 	client.Send <- message
 }
@@ -187,28 +447,156 @@ func (ws *WebSocketManager) BroadcastMessage(message []byte) {
 	ws.broadcast <- message
 }
 
-// SendToAgent sends a message to a specific agent
-func (ws *WebSocketManager) SendToAgent(agentID string, message []byte) {
-	for id, conn := range ws.clients {
-		// TODO: Match by agent ID instead of client ID
-		if id == agentID {
-			err := conn.WriteMessage(websocket.TextMessage, message)
-			if err != nil {
-				fmt.Printf("Error sending message to agent %s: %v\n", agentID, err)
-			}
+// SetClusterResolver sets the lookup of a cluster's agent IDs used by BroadcastToCluster
+func (ws *WebSocketManager) SetClusterResolver(resolver func(clusterID string) ([]string, error)) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.clusterAgents = resolver
+}
+
+// SetSelectorResolver sets the lookup of the agent IDs matching a label
+// selector used by BroadcastToSelector
+func (ws *WebSocketManager) SetSelectorResolver(resolver func(labels map[string]string) ([]string, error)) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.selectorAgents = resolver
+}
+
+// BroadcastToCluster sends a message to the connected agents of a cluster
+// and returns how many received it
+func (ws *WebSocketManager) BroadcastToCluster(clusterID string, message []byte) (int, error) {
+	ws.mu.RLock()
+	resolver := ws.clusterAgents
+	ws.mu.RUnlock()
+
+	if resolver == nil {
+		return 0, fmt.Errorf("cluster broadcast not configured")
+	}
+	agentIDs, err := resolver(clusterID)
+	if err != nil {
+		return 0, err
+	}
+	return ws.sendToAgents(agentIDs, message), nil
+}
+
+// BroadcastToSelector sends a message to the connected agents matching all
+// labels and returns how many received it
+func (ws *WebSocketManager) BroadcastToSelector(labels map[string]string, message []byte) (int, error) {
+	if len(labels) == 0 {
+		return 0, fmt.Errorf("selector must have at least one label")
+	}
+
+	ws.mu.RLock()
+	resolver := ws.selectorAgents
+	ws.mu.RUnlock()
+
+	if resolver == nil {
+		return 0, fmt.Errorf("selector broadcast not configured")
+	}
+	agentIDs, err := resolver(labels)
+	if err != nil {
+		return 0, err
+	}
+	return ws.sendToAgents(agentIDs, message), nil
+}
+
+// sendToAgents sends a message to each of the agents with an open control
+// channel, skipping the rest, and returns how many received it
+func (ws *WebSocketManager) sendToAgents(agentIDs []string, message []byte) int {
+	sent := 0
+	for _, agentID := range agentIDs {
+		if ws.SendToAgent(agentID, message) {
+			sent++
 		}
 	}
+	return sent
+}
+
+// SetAgentForwarder has messages for agents not connected to this server
+// passed to forward, e.g. to send them through the server holding the
+// agent's control channel. forward reports whether the agent received the
+// message.
+func (ws *WebSocketManager) SetAgentForwarder(forward func(agentID string, message []byte) bool) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	ws.forwardToAgent = forward
+}
+
+// SendToAgent sends a message to a specific agent and reports whether it was
+// written to the agent's connection. Messages for agents connected to
+// another server go through the agent forwarder, if one is set.
+func (ws *WebSocketManager) SendToAgent(agentID string, message []byte) bool {
+	ws.mu.RLock()
+	_, local := ws.agents[agentID]
+	forward := ws.forwardToAgent
+	ws.mu.RUnlock()
+
+	if local || forward == nil {
+		return ws.DeliverToAgent(agentID, message)
+	}
+	return forward(agentID, message)
+}
+
+// DeliverToAgent sends a message to an agent connected to this server and
+// reports whether it was written to the agent's connection
+func (ws *WebSocketManager) DeliverToAgent(agentID string, message []byte) bool {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	clientID, ok := ws.agents[agentID]
+	if !ok {
+		return false
+	}
+
+	conn, ok := ws.clients[clientID]
+	if !ok {
+		return false
+	}
+
+	if err := conn.WriteMessage(websocket.TextMessage, message); err != nil {
+		fmt.Printf("Error sending message to agent %s: %v\n", agentID, err)
+		return false
+	}
+	if ws.metrics != nil {
+		ws.metrics.RecordWebSocketMessage("out")
+	}
+	return true
 }
 
 // GetConnectedAgents returns list of connected agent IDs
 func (ws *WebSocketManager) GetConnectedAgents() []string {
-	var agents []string
-	for id := range ws.clients {
-		agents = append(agents, id)
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	agents := make([]string, 0, len(ws.agents))
+	for agentID := range ws.agents {
+		agents = append(agents, agentID)
 	}
 	return agents
 }
 
+// IsAgentConnected reports whether the agent has an open control channel
+func (ws *WebSocketManager) IsAgentConnected(agentID string) bool {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	_, ok := ws.agents[agentID]
+	return ok
+}
+
+// AgentConnectedWith reports whether the agent has an open control channel
+// that was opened with the given token
+func (ws *WebSocketManager) AgentConnectedWith(agentID, token string) bool {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+
+	bound, ok := ws.agentTokens[agentID]
+	return ok && token != "" && subtle.ConstantTimeCompare([]byte(bound), []byte(token)) == 1
+}
+
 // WebSocketMessage represents a WebSocket message
 type WebSocketMessage struct {
 	Type      string                 `json:"type"`
@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	ws := NewWebSocketManager(nil)
+	ws.SetAllowedOrigins([]string{"https://Ops.example.com/"})
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{"no origin", "", true},
+		{"same host", "http://nerve.local:8090", true},
+		{"allowed origin", "https://ops.example.com", true},
+		{"other origin", "https://evil.example.com", false},
+		{"allowed host on another scheme", "http://ops.example.com", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://nerve.local:8090/ws/events", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := ws.checkOrigin(req); got != tt.want {
+				t.Errorf("checkOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+
+	ws.SetAllowedOrigins([]string{"*"})
+	req := httptest.NewRequest("GET", "http://nerve.local:8090/ws/events", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	if !ws.checkOrigin(req) {
+		t.Error("* should allow every origin")
+	}
+}
+
+func TestStartLogTailDenied(t *testing.T) {
+	ws := NewWebSocketManager(nil)
+	var records []LogTailRecord
+	observer := &Client{
+		ID:       "ui-1",
+		Send:     make(chan []byte, 4),
+		Observer: true,
+		options: ObserverOptions{
+			AuthorizeLogTail: func(agentID, path string) error {
+				return errors.New("insufficient permissions")
+			},
+			OnLogTail: func(record LogTailRecord) { records = append(records, record) },
+		},
+	}
+	ws.observers[observer.ID] = observer
+
+	ws.startLogTail(observer, &WebSocketMessage{
+		Type:    MessageLogTail,
+		AgentID: "node-01",
+		Data:    map[string]interface{}{"path": "/var/log/messages"},
+	})
+
+	if len(ws.tails) != 0 {
+		t.Errorf("denied tail started: %d tails", len(ws.tails))
+	}
+	if len(records) != 1 || records[0].Result != LogTailDenied || records[0].Path != "/var/log/messages" {
+		t.Errorf("records = %+v, want one denied tail", records)
+	}
+	if len(observer.Send) != 1 {
+		t.Errorf("observer got %d messages, want a log_tail_end", len(observer.Send))
+	}
+}
@@ -0,0 +1,113 @@
+// Package websocket provides fetching of the recent log lines agents keep
+// in memory.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Recent logs message types. The server sends recent_logs; the agent replies
+// with one or more recent_logs_result messages, the last marked done.
+const (
+	MessageRecentLogs       = "recent_logs"
+	MessageRecentLogsResult = "recent_logs_result"
+)
+
+// maxRecentLogLines caps the lines collected for one request
+const maxRecentLogLines = 10000
+
+var (
+	// ErrAgentNotConnected is returned when the agent isn't connected to this server
+	ErrAgentNotConnected = errors.New("agent is not connected to this server")
+
+	// ErrRecentLogsTimeout is returned when the agent doesn't reply in time
+	ErrRecentLogsTimeout = errors.New("agent did not return its logs in time")
+)
+
+// recentLogsRequest collects the replies to a recent_logs request
+type recentLogsRequest struct {
+	agentID string
+	lines   []string
+	err     string
+	done    chan struct{}
+}
+
+// FetchRecentLogs asks an agent for up to lines of its most recent log
+// lines, oldest first, waiting at most timeout for them. The agent redacts
+// credentials before sending them.
+func (ws *WebSocketManager) FetchRecentLogs(agentID string, lines int, timeout time.Duration) ([]string, error) {
+	req := &recentLogsRequest{agentID: agentID, done: make(chan struct{})}
+	id := fmt.Sprintf("logs-%d", time.Now().UnixNano())
+	message, err := NewWebSocketMessage(MessageRecentLogs, agentID, map[string]interface{}{
+		"id":    id,
+		"lines": lines,
+	}).ToJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	ws.recentLogsMu.Lock()
+	ws.recentLogs[id] = req
+	ws.recentLogsMu.Unlock()
+	defer func() {
+		ws.recentLogsMu.Lock()
+		delete(ws.recentLogs, id)
+		ws.recentLogsMu.Unlock()
+	}()
+
+	// The reply arrives over the agent's control channel, so only an agent
+	// connected to this server can be asked
+	if !ws.DeliverToAgent(agentID, message) {
+		return nil, ErrAgentNotConnected
+	}
+
+	select {
+	case <-req.done:
+	case <-time.After(timeout):
+		return nil, ErrRecentLogsTimeout
+	}
+
+	ws.recentLogsMu.Lock()
+	defer ws.recentLogsMu.Unlock()
+	if req.err != "" {
+		return nil, errors.New(req.err)
+	}
+	return req.lines, nil
+}
+
+// collectRecentLogs adds the lines of a recent_logs_result message to the
+// request it answers. Replies for requests the agent wasn't sent are dropped.
+func (ws *WebSocketManager) collectRecentLogs(client *Client, msg *WebSocketMessage) {
+	id, _ := msg.Data["id"].(string)
+
+	ws.recentLogsMu.Lock()
+	defer ws.recentLogsMu.Unlock()
+
+	req, ok := ws.recentLogs[id]
+	if !ok || req.agentID != client.AgentID {
+		return
+	}
+	select {
+	case <-req.done:
+		return
+	default:
+	}
+
+	lines, _ := msg.Data["lines"].([]interface{})
+	for _, line := range lines {
+		if text, ok := line.(string); ok && len(req.lines) < maxRecentLogLines {
+			req.lines = append(req.lines, text)
+		}
+	}
+	if errMsg, _ := msg.Data["error"].(string); errMsg != "" {
+		req.err = errMsg
+	}
+	if done, _ := msg.Data["done"].(bool); done {
+		close(req.done)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// An agent registered with one token can't be taken over with another
+// until the first is revoked
+func TestRegisterKeepsAgentToken(t *testing.T) {
+	h := newHarness(t, 2)
+	id := h.register(0)
+
+	// Agent 1 claims agent 0's identity, by its ID and by its machine
+	h.do(http.MethodPost, "/api/agents/register", h.agentTokens[1], map[string]interface{}{
+		"agent_id": id,
+		"hostname": "node-1",
+	}, http.StatusForbidden, nil)
+	h.do(http.MethodPost, "/api/agents/register", h.agentTokens[1], map[string]interface{}{
+		"hostname": "node-0",
+		"sn":       "SN-0",
+	}, http.StatusForbidden, nil)
+	h.do(http.MethodPost, "/api/agents/"+id+"/heartbeat", h.agentTokens[1], map[string]interface{}{
+		"metrics": map[string]interface{}{"cpu_usage": 10.0},
+	}, http.StatusForbidden, nil)
+	if bound := h.registry.AgentToken(id); bound != h.agentTokens[0] {
+		t.Fatal("agent taken over by another token")
+	}
+
+	// Agent 0 re-registers as before
+	if again := h.register(0); again != id {
+		t.Fatalf("re-registered as %q, want %q", again, id)
+	}
+
+	// A revoked token no longer holds the agent
+	if err := h.tokens.RevokeToken(h.agentTokens[0]); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	h.do(http.MethodPost, "/api/agents/register", h.agentTokens[1], map[string]interface{}{
+		"hostname": "node-0",
+		"sn":       "SN-0",
+	}, http.StatusOK, nil)
+	if bound := h.registry.AgentToken(id); bound != h.agentTokens[1] {
+		t.Error("agent not bound to the new token")
+	}
+}
+
+// A token issued for an agent registers only that agent
+func TestRegisterWithAgentToken(t *testing.T) {
+	h := newHarness(t, 0)
+	token, err := h.tokens.CreateToken("", "node-7", h.agentPermissions, 0)
+	if err != nil {
+		t.Fatalf("CreateToken: %v", err)
+	}
+
+	h.do(http.MethodPost, "/api/agents/register", token.Token, map[string]interface{}{
+		"agent_id": "node-0",
+		"hostname": "node-0",
+	}, http.StatusForbidden, nil)
+
+	var registered struct {
+		ID string `json:"id"`
+	}
+	h.do(http.MethodPost, "/api/agents/register", token.Token, map[string]interface{}{
+		"hostname": "node-0",
+	}, http.StatusOK, &registered)
+	if registered.ID != "node-7" {
+		t.Errorf("registered as %q, want the token's agent node-7", registered.ID)
+	}
+	if agents := h.registry.List(); len(agents) != 1 {
+		t.Errorf("%d agents registered, want 1", len(agents))
+	}
+}
+
+// An agent's token reports for its own agent only: it can't manage other
+// agents or report the results of their tasks
+func TestAgentTokenReportsOwnAgent(t *testing.T) {
+	h := newHarness(t, 2)
+	ids := []string{h.register(0), h.register(1)}
+
+	for _, path := range []string{"/api/v1/agents/" + ids[0] + "/config", "/api/v1/agents/bulk/restart"} {
+		h.do(http.MethodPost, path, h.agentTokens[1], map[string]interface{}{}, http.StatusForbidden, nil)
+	}
+	h.do(http.MethodPut, "/api/agents/"+ids[0]+"/status", h.agentTokens[1], map[string]interface{}{"status": "maintenance"}, http.StatusForbidden, nil)
+
+	var created struct {
+		Tasks []struct {
+			ID string `json:"id"`
+		} `json:"tasks"`
+	}
+	h.do(http.MethodPost, "/api/tasks", h.adminToken, map[string]interface{}{
+		"type":          "command",
+		"target_agents": []string{ids[0]},
+		"content":       "uptime",
+	}, http.StatusOK, &created)
+	if len(created.Tasks) != 1 {
+		t.Fatalf("created %d tasks, want 1", len(created.Tasks))
+	}
+	path := "/api/tasks/" + created.Tasks[0].ID + "/result"
+	result := map[string]interface{}{"success": true, "output": "up 1 day"}
+
+	h.do(http.MethodPost, path, h.agentTokens[1], result, http.StatusForbidden, nil)
+	h.do(http.MethodPost, path, h.agentTokens[0], result, http.StatusOK, nil)
+}
+
+// Listing an agent's tasks leaves them pending; only the agent polling for
+// them dispatches them
+func TestListingTasksDoesNotDispatch(t *testing.T) {
+	h := newHarness(t, 2)
+	id := h.register(0)
+	h.register(1)
+
+	h.do(http.MethodPost, "/api/tasks", h.adminToken, map[string]interface{}{
+		"type":          "command",
+		"target_agents": []string{id},
+		"content":       "uptime",
+	}, http.StatusOK, nil)
+
+	type taskList struct {
+		Tasks []struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+		} `json:"tasks"`
+	}
+	for _, path := range []string{"/api/tasks?agent_id=" + id, "/api/v1/tasks/list?agent_id=" + id} {
+		var listed taskList
+		h.do(http.MethodGet, path, h.adminToken, nil, http.StatusOK, &listed)
+		if len(listed.Tasks) != 1 || listed.Tasks[0].Status != "pending" {
+			t.Fatalf("%s listed %+v, want one pending task", path, listed.Tasks)
+		}
+	}
+
+	h.do(http.MethodGet, "/api/agents/"+id+"/tasks", h.adminToken, nil, http.StatusForbidden, nil)
+	h.do(http.MethodGet, "/api/agents/"+id+"/tasks", h.agentTokens[1], nil, http.StatusForbidden, nil)
+
+	var polled taskList
+	h.do(http.MethodGet, "/api/agents/"+id+"/tasks", h.agentTokens[0], nil, http.StatusOK, &polled)
+	if len(polled.Tasks) != 1 || polled.Tasks[0].Status != "running" {
+		t.Fatalf("agent polled %+v, want its task running", polled.Tasks)
+	}
+	h.do(http.MethodGet, "/api/agents/"+id+"/tasks", h.agentTokens[0], nil, http.StatusOK, &polled)
+	if len(polled.Tasks) != 0 {
+		t.Errorf("task handed out again: %+v", polled.Tasks)
+	}
+}
@@ -0,0 +1,102 @@
+// Package main provides reloading the server configuration file on SIGHUP.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/nerve/server/pkg/alert"
+	"github.com/nerve/server/pkg/config"
+	"github.com/nerve/server/pkg/log"
+	"github.com/nerve/server/pkg/security"
+)
+
+// configRulesSource is the source of alert rules loaded from the rules file
+// of the configuration, see alert.LoadRules
+const configRulesSource = "config"
+
+// configReloader applies the reloadable settings of the configuration file
+type configReloader struct {
+	path         string
+	logger       log.Logger
+	agentLimiter *security.RateLimiter
+	alertMgr     *alert.AlertManager
+
+	// Flag values, used for settings the file leaves unset
+	debug      bool
+	agentRate  float64
+	agentBurst int
+
+	mu      sync.Mutex
+	current *config.File
+}
+
+// apply makes the log level, rate limits and alert rules of cfg current and
+// logs what changed
+func (r *configReloader) apply(cfg *config.File) error {
+	// Read the rules first, so a broken rules file leaves everything as is
+	rules, err := cfg.AlertRules()
+	if err != nil {
+		return err
+	}
+	changes, err := r.alertMgr.LoadRules(configRulesSource, rules)
+	if err != nil {
+		return err
+	}
+	if !changes.Empty() {
+		r.logger.Infof("Alert rules reloaded: added %v, updated %v, removed %v", changes.Added, changes.Updated, changes.Removed)
+	}
+
+	debug := cfg.Debug(r.debug)
+	rate, burst := cfg.AgentRateLimit(r.agentRate, r.agentBurst)
+	if r.current == nil || r.current.Debug(r.debug) != debug {
+		log.SetDebug(r.logger, debug)
+		r.logger.Infof("Debug logging set to %v", debug)
+	}
+	if oldRate, oldBurst := r.agentLimiter.Limit(); oldRate != rate || oldBurst != burst {
+		r.agentLimiter.SetLimit(rate, burst)
+		r.logger.Infof("Agent rate limit changed from %g/s burst %d to %g/s burst %d", oldRate, oldBurst, rate, burst)
+	}
+
+	r.current = cfg
+	return nil
+}
+
+// reload re-reads the configuration file, keeping the current settings if it
+// is invalid
+func (r *configReloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.logger.Infof("Reloading configuration from %s", r.path)
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		r.logger.Errorf("Configuration not reloaded: %v", err)
+		return
+	}
+	if ignored := cfg.Unreloadable(r.current); len(ignored) > 0 {
+		r.logger.Infof("Ignoring changed settings that cannot be reloaded: %s", strings.Join(ignored, ", "))
+	}
+	if err := r.apply(cfg); err != nil {
+		r.logger.Errorf("Configuration not reloaded: %v", err)
+		return
+	}
+	r.logger.Infof("Configuration reloaded")
+}
+
+// watch reloads the configuration on every SIGHUP
+func (r *configReloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			r.reload()
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Restarting agents that can't be reached fails per agent rather than
+// reporting the restart as sent
+func TestRestartUnreachableAgents(t *testing.T) {
+	h := newHarness(t, 2)
+	oldAgent := h.register(0)
+
+	var registered struct {
+		ID string `json:"id"`
+	}
+	h.do(http.MethodPost, "/api/agents/register", h.agentTokens[1], map[string]interface{}{
+		"hostname":     "node-1",
+		"sn":           "SN-1",
+		"capabilities": []string{"control", "restart"},
+	}, http.StatusOK, &registered)
+	disconnected := registered.ID
+
+	h.do(http.MethodPost, "/api/v1/agents/"+oldAgent+"/restart", h.adminToken, nil, http.StatusBadRequest, nil)
+	h.do(http.MethodPost, "/api/v1/agents/"+disconnected+"/restart", h.adminToken, nil, http.StatusConflict, nil)
+
+	var bulk struct {
+		Results []struct {
+			AgentID string `json:"agent_id"`
+			Success bool   `json:"success"`
+			Error   string `json:"error"`
+		} `json:"results"`
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+	}
+	h.do(http.MethodPost, "/api/v1/agents/bulk/restart", h.adminToken, map[string]interface{}{
+		"agent_ids": []string{oldAgent, disconnected, "no-such-agent"},
+	}, http.StatusOK, &bulk)
+	if bulk.Succeeded != 0 || bulk.Failed != 3 {
+		t.Fatalf("bulk restart succeeded %d, failed %d, want 0 and 3", bulk.Succeeded, bulk.Failed)
+	}
+	want := map[string]string{
+		oldAgent:        "agent does not support restart",
+		disconnected:    "agent has no open control channel",
+		"no-such-agent": "agent not found",
+	}
+	for _, result := range bulk.Results {
+		if result.Error != want[result.AgentID] {
+			t.Errorf("agent %s failed with %q, want %q", result.AgentID, result.Error, want[result.AgentID])
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package main provides a tool that copies all entries from one storage
+// backend to another, or through a JSON dump file.
+//
+// Author: mmwei3 (2025-10-28)
+// Wethers: cloudWays
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nerve/server/pkg/storage"
+)
+
+// dumpVersion is the version of the dump file format
+const dumpVersion = 1
+
+// progressEvery is how many entries are copied between progress lines
+const progressEvery = 500
+
+// Dump is the JSON dump format: every storage entry by key
+type Dump struct {
+	Version    int                    `json:"version"`
+	Source     string                 `json:"source"`
+	ExportedAt time.Time              `json:"exported_at"`
+	Entries    map[string]interface{} `json:"entries"`
+}
+
+var (
+	fromConfig = flag.String("from-config", "", "Source storage config (JSON storage.Config)")
+	fromDump   = flag.String("from-dump", "", "Source JSON dump file")
+	toConfig   = flag.String("to-config", "", "Destination storage config (JSON storage.Config)")
+	toDump     = flag.String("to-dump", "", "Destination JSON dump file")
+	prefix     = flag.String("prefix", "", "Only copy keys with this prefix, e.g. schedule:")
+	dryRun     = flag.Bool("dry-run", false, "Report what would be copied without writing")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: storage-migrate (-from-config FILE | -from-dump FILE) (-to-config FILE | -to-dump FILE) [flags]\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if (*fromConfig == "") == (*fromDump == "") || (*toConfig == "") == (*toDump == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	entries, source, err := readSource()
+	if err != nil {
+		log.Fatalf("Failed to read source: %v", err)
+	}
+	entries = filterEntries(entries, *prefix)
+	fmt.Printf("Read %d entries from %s\n", len(entries), source)
+	printSummary(entries)
+
+	if *toDump != "" {
+		if *dryRun {
+			fmt.Printf("Dry run: would write %d entries to %s\n", len(entries), *toDump)
+			return
+		}
+		if err := writeDump(*toDump, source, entries); err != nil {
+			log.Fatalf("Failed to write dump: %v", err)
+		}
+		fmt.Printf("Wrote %d entries to %s\n", len(entries), *toDump)
+		return
+	}
+
+	dest, err := openStorage(*toConfig)
+	if err != nil {
+		log.Fatalf("Failed to open destination: %v", err)
+	}
+	defer closeStorage(dest)
+
+	copied, unchanged, failed := copyEntries(dest, entries)
+	if *dryRun {
+		fmt.Printf("Dry run: would write %d, %d unchanged\n", copied, unchanged)
+		return
+	}
+	fmt.Printf("Done: %d written, %d unchanged, %d failed\n", copied, unchanged, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// readSource returns the entries of the source storage or dump and a description of it
+func readSource() (map[string]interface{}, string, error) {
+	if *fromDump != "" {
+		dump, err := readDump(*fromDump)
+		if err != nil {
+			return nil, "", err
+		}
+		return dump.Entries, fmt.Sprintf("dump %s (%s, exported %s)", *fromDump, dump.Source, dump.ExportedAt.Format(time.RFC3339)), nil
+	}
+
+	src, err := openStorage(*fromConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	defer closeStorage(src)
+
+	return src.List(), *fromConfig, nil
+}
+
+// openStorage creates a storage from a JSON storage.Config file
+func openStorage(path string) (storage.Storage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg storage.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	return storage.NewFromConfig(cfg)
+}
+
+// closeStorage closes a storage backend that holds connections
+func closeStorage(s storage.Storage) {
+	if closer, ok := s.(interface{ Close() error }); ok {
+		closer.Close()
+	}
+}
+
+// readDump loads a JSON dump file
+func readDump(path string) (*Dump, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var dump Dump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		return nil, fmt.Errorf("invalid dump %s: %v", path, err)
+	}
+	if dump.Version != dumpVersion {
+		return nil, fmt.Errorf("unsupported dump version %d", dump.Version)
+	}
+	return &dump, nil
+}
+
+// writeDump writes entries to a JSON dump file, replacing it atomically
+func writeDump(path, source string, entries map[string]interface{}) error {
+	data, err := json.MarshalIndent(Dump{
+		Version:    dumpVersion,
+		Source:     source,
+		ExportedAt: time.Now().UTC(),
+		Entries:    entries,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// filterEntries returns the entries whose keys start with prefix
+func filterEntries(entries map[string]interface{}, prefix string) map[string]interface{} {
+	if prefix == "" {
+		return entries
+	}
+	filtered := make(map[string]interface{})
+	for key, value := range entries {
+		if strings.HasPrefix(key, prefix) {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// printSummary prints the number of entries per key kind, e.g. "schedule" for "schedule:sched-1"
+func printSummary(entries map[string]interface{}) {
+	counts := make(map[string]int)
+	for key := range entries {
+		kind := key
+		if i := strings.Index(key, ":"); i >= 0 {
+			kind = key[:i]
+		}
+		counts[kind]++
+	}
+
+	kinds := make([]string, 0, len(counts))
+	for kind := range counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("  %-20s %d\n", kind, counts[kind])
+	}
+}
+
+// copyEntries upserts entries into dest in key order. Entries the
+// destination already holds with the same value are skipped, so a migration
+// can be re-run after a failure.
+func copyEntries(dest storage.Storage, entries map[string]interface{}) (copied, unchanged, failed int) {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		value := entries[key]
+		if existing, err := dest.Get(key); err == nil && sameValue(existing, value) {
+			unchanged++
+		} else if *dryRun {
+			copied++
+		} else if err := dest.Set(key, value); err != nil {
+			fmt.Printf("  failed %s: %v\n", key, err)
+			failed++
+		} else {
+			copied++
+		}
+
+		if (i+1)%progressEvery == 0 {
+			fmt.Printf("  %d/%d\n", i+1, len(keys))
+		}
+	}
+	return copied, unchanged, failed
+}
+
+// sameValue compares two values by their JSON encoding, since backends
+// decode stored values into different types
+func sameValue(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}